@@ -0,0 +1,28 @@
+// Command darajastub serves a local imitation of Safaricom's Daraja API so the
+// backend's M-Pesa flow can be run end to end. Point MPESA_BASE_URL at it.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"farmpro/backend/internal/mpesa"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "listen address")
+	result := flag.Int("result", mpesa.ResultSuccess, "result code every STK push ends with (1032 cancels)")
+	delay := flag.Duration("delay", 2*time.Second, "how long the customer takes to answer an STK prompt")
+	flag.Parse()
+
+	stub := mpesa.NewStub()
+	stub.ResultCode = *result
+	stub.Delay = *delay
+
+	log.Printf("Daraja stub running on %s", *addr)
+	if err := http.ListenAndServe(*addr, stub); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -16,11 +16,28 @@ import (
 	"farmpro/backend/internal/api"
 	"farmpro/backend/internal/config"
 	"farmpro/backend/internal/database"
+	"farmpro/backend/internal/etims"
+	"farmpro/backend/internal/mpesa"
+	"farmpro/backend/internal/sms"
 )
 
 func main() {
 	loadEnvFiles(".env", "backend/.env")
 
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"migrate": runMigrateCommand,
+			"export":  runExportCommand,
+			"restore": runRestoreCommand,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatal(err)
@@ -35,13 +52,45 @@ func main() {
 	}
 	defer pool.Close()
 
-	migrateCtx, migrateCancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer migrateCancel()
-	if err := database.EnsureSchema(migrateCtx, pool, cfg.SchemaPath); err != nil {
-		log.Fatal(err)
+	if cfg.AutoMigrate {
+		migrateCtx, migrateCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		applied, err := database.MigrateUp(migrateCtx, pool, cfg.MigrationsPath)
+		migrateCancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %04d_%s", m.Version, m.Name)
+		}
 	}
 
-	mailer := api.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.FromName, cfg.FromEmail)
+	mailer := api.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.FromName, cfg.FromEmail, cfg.SMTPTLS)
+	var etimsClient etims.Client
+	switch {
+	case cfg.EtimsBaseURL != "":
+		etimsClient = etims.NewHTTPClient(cfg.EtimsBaseURL)
+	case cfg.EtimsFake:
+		etimsClient = etims.NewFake()
+		log.Printf("ETIMS_FAKE set, tax receipts are signed locally and not sent to KRA")
+	default:
+		log.Printf("ETIMS_BASE_URL not set, tax receipts are disabled")
+	}
+	var mpesaClient mpesa.Client
+	if cfg.MpesaBaseURL != "" {
+		mpesaClient = mpesa.NewHTTPClient(cfg.MpesaBaseURL, cfg.MpesaAppKey, cfg.MpesaAppSecret)
+	} else {
+		log.Printf("MPESA_BASE_URL not set, M-Pesa payments are disabled")
+	}
+	var smsSender sms.Sender
+	switch {
+	case cfg.SMSBaseURL != "":
+		smsSender = sms.NewHTTPClient(cfg.SMSBaseURL, cfg.SMSUsername, cfg.SMSAPIKey, cfg.SMSSenderID)
+	case cfg.SMSFake:
+		smsSender = sms.NewFake()
+		log.Printf("SMS_FAKE set, text messages are logged instead of sent")
+	default:
+		log.Printf("SMS_BASE_URL not set, text messages are disabled")
+	}
 	srv := api.NewServer(
 		pool,
 		cfg.JWTSecret,
@@ -51,6 +100,14 @@ func main() {
 		cfg.AppTimezone,
 		cfg.KRAPIN,
 		cfg.MLBaseURL,
+		etimsClient,
+		cfg.EtimsBranchID,
+		cfg.EtimsDeviceSerial,
+		mpesaClient,
+		cfg.MpesaCallbackURL,
+		cfg.MpesaCallbackToken,
+		smsSender,
+		cfg.SMSCallbackToken,
 	)
 	httpServer := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -63,6 +120,10 @@ func main() {
 	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	go srv.RunEtimsRetries(stopCtx, time.Minute)
+	go srv.RunReportSchedules(stopCtx, time.Minute)
+	go srv.RunAlertRules(stopCtx, 15*time.Minute)
+
 	go func() {
 		<-stopCtx.Done()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
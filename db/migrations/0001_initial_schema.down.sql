@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS etims_submissions;
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS poultry_breeding_records;
+DROP TABLE IF EXISTS sales;
+DROP TABLE IF EXISTS feeding_records;
+DROP TABLE IF EXISTS feeding_plans;
+DROP TABLE IF EXISTS feeding_ration_items;
+DROP TABLE IF EXISTS feeding_rations;
+DROP TABLE IF EXISTS expenses;
+DROP TABLE IF EXISTS production_logs;
+DROP TABLE IF EXISTS breeding_records;
+DROP TABLE IF EXISTS health_records;
+DROP TABLE IF EXISTS animals;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
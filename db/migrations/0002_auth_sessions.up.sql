@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS auth_sessions (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  user_agent TEXT NOT NULL DEFAULT '',
+  ip TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  last_used_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP,
+  revoked_reason TEXT
+);
+
+CREATE TABLE IF NOT EXISTS auth_refresh_tokens (
+  id SERIAL PRIMARY KEY,
+  session_id INTEGER NOT NULL REFERENCES auth_sessions(id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_sessions_user_id ON auth_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_auth_refresh_tokens_session_id ON auth_refresh_tokens(session_id);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE key IN ('roles.read', 'roles.manage');
+
+ALTER TABLE roles ALTER COLUMN is_system SET DEFAULT TRUE;
+ALTER TABLE roles DROP COLUMN IF EXISTS description;
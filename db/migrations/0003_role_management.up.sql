@@ -0,0 +1,26 @@
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE roles ALTER COLUMN is_system SET DEFAULT FALSE;
+
+UPDATE roles SET description = 'Full access to every farm function' WHERE name = 'owner' AND description = '';
+UPDATE roles SET description = 'Runs day-to-day operations' WHERE name = 'manager' AND description = '';
+UPDATE roles SET description = 'Animal health and treatments' WHERE name = 'veterinarian' AND description = '';
+UPDATE roles SET description = 'Records production and feeding' WHERE name = 'worker' AND description = '';
+
+INSERT INTO permissions(key, description) VALUES
+  ('roles.read', 'Read roles and the permission catalogue'),
+  ('roles.manage', 'Create, update, delete roles and their permissions')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.key IN ('roles.read', 'roles.manage')
+WHERE r.name = 'owner'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.key = 'roles.read'
+WHERE r.name = 'manager'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,34 @@
+DELETE FROM permissions WHERE key IN ('farms.manage', 'farms.create');
+
+ALTER TABLE auth_sessions DROP COLUMN IF EXISTS farm_id;
+
+ALTER TABLE production_logs DROP CONSTRAINT IF EXISTS production_logs_farm_log_date_key;
+ALTER TABLE production_logs ADD CONSTRAINT production_logs_log_date_key UNIQUE (log_date);
+ALTER TABLE animals DROP CONSTRAINT IF EXISTS animals_farm_tag_id_key;
+ALTER TABLE animals ADD CONSTRAINT animals_tag_id_key UNIQUE (tag_id);
+
+ALTER TABLE etims_submissions DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE reports DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE sales DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE feeding_records DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE feeding_plans DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE feeding_rations DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE expenses DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE production_logs DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE poultry_breeding_records DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE breeding_records DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE health_records DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE animals DROP COLUMN IF EXISTS farm_id;
+
+-- Custom roles from other farms keep a suffix so role names are unique again.
+UPDATE roles r
+SET name = r.name || '-' || r.farm_id
+WHERE r.farm_id IS NOT NULL
+  AND EXISTS (SELECT 1 FROM roles o WHERE o.name = r.name AND o.id < r.id);
+
+DROP INDEX IF EXISTS roles_farm_name_key;
+ALTER TABLE roles DROP COLUMN IF EXISTS farm_id;
+ALTER TABLE roles ADD CONSTRAINT roles_name_key UNIQUE (name);
+
+DROP TABLE IF EXISTS farm_members;
+DROP TABLE IF EXISTS farms;
@@ -0,0 +1,106 @@
+CREATE TABLE IF NOT EXISTS farms (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  county TEXT NOT NULL DEFAULT '',
+  kra_pin TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Membership status is per farm: an admin deactivates someone on their farm,
+-- not the person's account. Memberships have an id so changes can be audited.
+CREATE TABLE IF NOT EXISTS farm_members (
+  id SERIAL UNIQUE,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role_id INTEGER NOT NULL REFERENCES roles(id),
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'inactive')),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (farm_id, user_id)
+);
+
+-- Everything recorded before tenancy belongs to one farm, and every existing
+-- user keeps their current role and status on it.
+INSERT INTO farms(name) VALUES ('Main Farm');
+
+INSERT INTO farm_members(farm_id, user_id, role_id, status)
+SELECT (SELECT MIN(id) FROM farms), u.id, u.role_id, u.status
+FROM users u
+ON CONFLICT DO NOTHING;
+
+-- Custom roles belong to the farm that made them; system roles (farm_id
+-- NULL) stay shared and keep their names to themselves.
+ALTER TABLE roles ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE roles DROP CONSTRAINT IF EXISTS roles_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS roles_farm_name_key ON roles(COALESCE(farm_id, 0), name);
+UPDATE roles SET farm_id = (SELECT MIN(id) FROM farms) WHERE NOT is_system;
+
+ALTER TABLE animals ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE health_records ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE breeding_records ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE poultry_breeding_records ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE production_logs ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE expenses ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE feeding_rations ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE feeding_plans ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE feeding_records ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE sales ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE reports ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+ALTER TABLE etims_submissions ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE;
+
+UPDATE animals SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE health_records SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE breeding_records SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE poultry_breeding_records SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE production_logs SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE expenses SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE feeding_rations SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE feeding_plans SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE feeding_records SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE sales SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE reports SET farm_id = (SELECT MIN(id) FROM farms);
+UPDATE etims_submissions SET farm_id = (SELECT MIN(id) FROM farms);
+
+ALTER TABLE animals ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE health_records ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE breeding_records ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE poultry_breeding_records ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE production_logs ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE expenses ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE feeding_rations ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE feeding_plans ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE feeding_records ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE sales ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE reports ALTER COLUMN farm_id SET NOT NULL;
+ALTER TABLE etims_submissions ALTER COLUMN farm_id SET NOT NULL;
+
+ALTER TABLE animals DROP CONSTRAINT IF EXISTS animals_tag_id_key;
+ALTER TABLE animals ADD CONSTRAINT animals_farm_tag_id_key UNIQUE (farm_id, tag_id);
+ALTER TABLE production_logs DROP CONSTRAINT IF EXISTS production_logs_log_date_key;
+ALTER TABLE production_logs ADD CONSTRAINT production_logs_farm_log_date_key UNIQUE (farm_id, log_date);
+
+ALTER TABLE auth_sessions ADD COLUMN farm_id INTEGER REFERENCES farms(id) ON DELETE SET NULL;
+
+INSERT INTO permissions(key, description) VALUES
+  ('farms.manage', 'Update farm details and manage farm members'),
+  ('farms.create', 'Create new farms')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.key IN ('farms.manage', 'farms.create')
+WHERE r.name = 'owner' AND r.is_system
+ON CONFLICT DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS idx_farm_members_user ON farm_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_animals_farm ON animals(farm_id, is_active);
+CREATE INDEX IF NOT EXISTS idx_health_farm ON health_records(farm_id, record_date);
+CREATE INDEX IF NOT EXISTS idx_breeding_farm ON breeding_records(farm_id, status);
+CREATE INDEX IF NOT EXISTS idx_poultry_breeding_farm ON poultry_breeding_records(farm_id, status);
+CREATE INDEX IF NOT EXISTS idx_expense_farm_date ON expenses(farm_id, expense_date);
+CREATE INDEX IF NOT EXISTS idx_feeding_rations_farm ON feeding_rations(farm_id);
+CREATE INDEX IF NOT EXISTS idx_feeding_plans_farm ON feeding_plans(farm_id);
+CREATE INDEX IF NOT EXISTS idx_feeding_farm_date ON feeding_records(farm_id, feed_date);
+CREATE INDEX IF NOT EXISTS idx_sale_farm_date ON sales(farm_id, sale_date);
+CREATE INDEX IF NOT EXISTS idx_reports_farm ON reports(farm_id);
+CREATE INDEX IF NOT EXISTS idx_etims_farm ON etims_submissions(farm_id, submitted_at DESC);
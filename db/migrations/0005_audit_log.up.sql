@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  farm_id INTEGER,
+  user_id INTEGER,
+  permission TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+  entity TEXT NOT NULL,
+  entity_id BIGINT NOT NULL,
+  before_data JSONB,
+  after_data JSONB,
+  ip TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- No foreign keys on purpose: deleting a user or farm must not rewrite or drop
+-- the history that describes it.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_no_change ON audit_events;
+CREATE TRIGGER audit_events_no_change
+  BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate
+  BEFORE TRUNCATE ON audit_events
+  FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+CREATE INDEX IF NOT EXISTS idx_audit_farm_created ON audit_events(farm_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_entity ON audit_events(farm_id, entity, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_user ON audit_events(farm_id, user_id);
+
+INSERT INTO permissions(key, description) VALUES
+  ('audit.read', 'Read the audit log of changes')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.key = 'audit.read'
+WHERE r.name = 'owner'
+ON CONFLICT DO NOTHING;
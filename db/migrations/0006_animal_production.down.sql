@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS production_logs (
+  id SERIAL PRIMARY KEY,
+  log_date DATE NOT NULL,
+  milk_liters NUMERIC(10,2) NOT NULL DEFAULT 0,
+  milk_cow_liters NUMERIC(10,2) NOT NULL DEFAULT 0,
+  milk_goat_liters NUMERIC(10,2) NOT NULL DEFAULT 0,
+  eggs_count INTEGER NOT NULL DEFAULT 0,
+  wool_kg NUMERIC(10,2) NOT NULL DEFAULT 0,
+  meat_kg NUMERIC(10,2) NOT NULL DEFAULT 0,
+  total_value NUMERIC(12,2) NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  CONSTRAINT production_logs_farm_log_date_key UNIQUE (farm_id, log_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_production_log_date ON production_logs(log_date);
+
+-- Per-animal detail is folded back into one row per farm and day.
+INSERT INTO production_logs(farm_id, log_date, milk_liters, milk_cow_liters, milk_goat_liters, eggs_count, wool_kg, meat_kg, total_value)
+SELECT farm_id, log_date, milk_liters, milk_cow_liters, milk_goat_liters, eggs_count, wool_kg, meat_kg, total_value
+FROM production_daily;
+
+DROP VIEW IF EXISTS production_daily;
+DROP TABLE IF EXISTS production_records;
@@ -0,0 +1,67 @@
+-- One row per measurement. animal_id is NULL for the "herd" pseudo-entry,
+-- which holds whatever was recorded for the farm as a whole rather than for a
+-- single animal or flock.
+CREATE TABLE IF NOT EXISTS production_records (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  animal_id INTEGER REFERENCES animals(id) ON DELETE CASCADE,
+  record_date DATE NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('milk', 'eggs', 'wool', 'meat', 'weight')),
+  session TEXT NOT NULL DEFAULT '' CHECK (session IN ('', 'am', 'pm')),
+  species TEXT NOT NULL DEFAULT '',
+  quantity NUMERIC(10,2) NOT NULL CHECK (quantity >= 0),
+  unit TEXT NOT NULL,
+  value NUMERIC(12,2) NOT NULL DEFAULT 0,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_production_records_farm_date ON production_records(farm_id, record_date);
+CREATE INDEX IF NOT EXISTS idx_production_records_animal ON production_records(animal_id, kind, record_date);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_production_records_milk_session
+  ON production_records(animal_id, record_date, session)
+  WHERE kind = 'milk' AND animal_id IS NOT NULL;
+
+-- Each daily log becomes herd records, one per product. The day's total value
+-- cannot be split back into products, so it is carried on the first record.
+INSERT INTO production_records(farm_id, animal_id, record_date, kind, species, quantity, unit, value, notes, created_at)
+SELECT farm_id, NULL, log_date, kind, species, quantity, unit,
+       CASE WHEN ROW_NUMBER() OVER (PARTITION BY log_id ORDER BY ord) = 1 THEN total_value ELSE 0 END,
+       'Migrated from daily log', created_at
+FROM (
+  SELECT l.id AS log_id, l.farm_id, l.log_date, l.total_value, l.created_at,
+         v.ord, v.kind, v.species, v.quantity, v.unit
+  FROM production_logs l
+  CROSS JOIN LATERAL (VALUES
+    (1, 'milk', 'cow', l.milk_cow_liters, 'L'),
+    (2, 'milk', 'goat', l.milk_goat_liters, 'L'),
+    (3, 'milk', '', GREATEST(l.milk_liters - l.milk_cow_liters - l.milk_goat_liters, 0), 'L'),
+    (4, 'eggs', '', l.eggs_count::numeric, 'eggs'),
+    (5, 'wool', '', l.wool_kg, 'kg'),
+    (6, 'meat', '', l.meat_kg, 'kg')
+  ) AS v(ord, kind, species, quantity, unit)
+  WHERE v.quantity > 0
+     OR (v.ord = 3 AND l.total_value > 0
+         AND l.milk_liters = 0 AND l.eggs_count = 0 AND l.wool_kg = 0 AND l.meat_kg = 0)
+) migrated;
+
+DROP TABLE production_logs;
+
+-- Daily totals for the dashboard, insights and reports, derived from the
+-- individual records. Weights are measurements, not output, so they are left out.
+CREATE VIEW production_daily AS
+SELECT
+  farm_id,
+  record_date AS log_date,
+  COALESCE(SUM(quantity) FILTER (WHERE kind = 'milk'), 0) AS milk_liters,
+  COALESCE(SUM(quantity) FILTER (WHERE kind = 'milk' AND species = 'cow'), 0) AS milk_cow_liters,
+  COALESCE(SUM(quantity) FILTER (WHERE kind = 'milk' AND species = 'goat'), 0) AS milk_goat_liters,
+  COALESCE(SUM(quantity) FILTER (WHERE kind = 'eggs'), 0)::integer AS eggs_count,
+  COALESCE(SUM(quantity) FILTER (WHERE kind = 'wool'), 0) AS wool_kg,
+  COALESCE(SUM(quantity) FILTER (WHERE kind = 'meat'), 0) AS meat_kg,
+  COALESCE(SUM(value), 0) AS total_value,
+  COUNT(*) AS record_count,
+  COUNT(*) FILTER (WHERE animal_id IS NULL) AS herd_record_count
+FROM production_records
+WHERE kind <> 'weight'
+GROUP BY farm_id, record_date;
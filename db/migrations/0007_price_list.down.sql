@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE key = 'prices.manage';
+
+ALTER TABLE production_records DROP COLUMN IF EXISTS value_source;
+DROP TABLE IF EXISTS commodity_prices;
+DROP TABLE IF EXISTS commodity_price_defaults;
@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS commodity_prices (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  product TEXT NOT NULL CHECK (product IN ('milk', 'eggs', 'wool', 'meat')),
+  species TEXT NOT NULL DEFAULT '',
+  unit TEXT NOT NULL,
+  price NUMERIC(12,2) NOT NULL CHECK (price >= 0),
+  effective_from DATE NOT NULL,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT commodity_prices_effective_key UNIQUE (farm_id, product, species, unit, effective_from)
+);
+
+CREATE INDEX IF NOT EXISTS idx_commodity_prices_lookup ON commodity_prices(farm_id, product, effective_from DESC);
+
+-- The rates that used to be hard-coded in the production handlers become the
+-- opening price list of every farm, existing and new.
+CREATE TABLE IF NOT EXISTS commodity_price_defaults (
+  product TEXT NOT NULL CHECK (product IN ('milk', 'eggs', 'wool', 'meat')),
+  species TEXT NOT NULL DEFAULT '',
+  unit TEXT NOT NULL,
+  price NUMERIC(12,2) NOT NULL CHECK (price >= 0),
+  PRIMARY KEY (product, species, unit)
+);
+
+INSERT INTO commodity_price_defaults(product, species, unit, price) VALUES
+  ('milk', '', 'L', 60.00),
+  ('milk', 'cow', 'L', 60.00),
+  ('milk', 'goat', 'L', 80.00),
+  ('eggs', '', 'eggs', 15.00),
+  ('wool', '', 'kg', 500.00),
+  ('meat', '', 'kg', 450.00)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO commodity_prices(farm_id, product, species, unit, price, effective_from, notes)
+SELECT f.id, d.product, d.species, d.unit, d.price, DATE '2000-01-01', 'Opening price'
+FROM farms f
+CROSS JOIN commodity_price_defaults d
+ON CONFLICT DO NOTHING;
+
+-- Values priced from the list are revalued when a price is corrected; values
+-- typed in or computed from a one-off rate are left alone. Nothing recorded so
+-- far came from the list.
+ALTER TABLE production_records ADD COLUMN value_source TEXT NOT NULL DEFAULT 'price' CHECK (value_source IN ('price', 'manual'));
+UPDATE production_records SET value_source = 'manual';
+
+INSERT INTO permissions(key, description) VALUES
+  ('prices.manage', 'Maintain the commodity price list and revalue production')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.key = 'prices.manage'
+WHERE r.name IN ('owner', 'manager')
+ON CONFLICT DO NOTHING;
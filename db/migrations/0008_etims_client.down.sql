@@ -0,0 +1,28 @@
+UPDATE permissions
+SET description = 'Generate and download local tax receipts'
+WHERE key = 'etims.manage';
+
+DROP TRIGGER IF EXISTS etims_submissions_state ON etims_submissions;
+DROP FUNCTION IF EXISTS etims_submissions_transition();
+
+DROP INDEX IF EXISTS idx_etims_retry;
+ALTER TABLE etims_submissions DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE etims_submissions DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE etims_submissions DROP COLUMN IF EXISTS last_error;
+ALTER TABLE etims_submissions DROP COLUMN IF EXISTS attempts;
+
+ALTER TABLE etims_submissions DROP CONSTRAINT IF EXISTS etims_submissions_status_check;
+UPDATE etims_submissions
+SET status = CASE status
+  WHEN 'pending' THEN 'local_generated'
+  WHEN 'submitted' THEN 'submitted_local'
+  WHEN 'failed' THEN 'submitted_local'
+  WHEN 'accepted' THEN 'accepted_local'
+  WHEN 'rejected' THEN 'rejected_local'
+  ELSE status
+END;
+ALTER TABLE etims_submissions ALTER COLUMN status SET DEFAULT 'local_generated';
+ALTER TABLE etims_submissions ADD CONSTRAINT etims_submissions_status_check
+  CHECK (status IN ('local_generated', 'submitted_local', 'accepted_local', 'rejected_local'));
+
+DROP TABLE IF EXISTS etims_devices;
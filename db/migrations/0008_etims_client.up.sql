@@ -0,0 +1,67 @@
+-- One initialised sales control unit per farm. cmc_key authenticates every
+-- call to KRA and never leaves the server.
+CREATE TABLE IF NOT EXISTS etims_devices (
+  farm_id INTEGER PRIMARY KEY REFERENCES farms(id) ON DELETE CASCADE,
+  tin TEXT NOT NULL,
+  branch_id TEXT NOT NULL,
+  device_serial TEXT NOT NULL,
+  device_id TEXT NOT NULL DEFAULT '',
+  sdc_id TEXT NOT NULL DEFAULT '',
+  mrc_number TEXT NOT NULL DEFAULT '',
+  cmc_key TEXT NOT NULL,
+  initialized_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE etims_submissions DROP CONSTRAINT IF EXISTS etims_submissions_status_check;
+
+UPDATE etims_submissions
+SET status = CASE status
+  WHEN 'local_generated' THEN 'pending'
+  WHEN 'submitted_local' THEN 'submitted'
+  WHEN 'accepted_local' THEN 'accepted'
+  WHEN 'rejected_local' THEN 'rejected'
+  ELSE status
+END;
+
+ALTER TABLE etims_submissions ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE etims_submissions ADD CONSTRAINT etims_submissions_status_check
+  CHECK (status IN ('pending', 'submitted', 'accepted', 'rejected', 'failed'));
+
+ALTER TABLE etims_submissions ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE etims_submissions ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE etims_submissions ADD COLUMN next_attempt_at TIMESTAMP;
+ALTER TABLE etims_submissions ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_etims_retry ON etims_submissions(next_attempt_at) WHERE status = 'failed';
+
+-- pending -> submitted -> accepted | rejected | failed, failed -> submitted for
+-- retries, and a rejected or failed receipt can be regenerated as pending.
+-- Accepted receipts are final.
+CREATE OR REPLACE FUNCTION etims_submissions_transition() RETURNS trigger AS $$
+BEGIN
+  IF NEW.status = OLD.status AND NEW.status <> 'accepted' THEN
+    RETURN NEW;
+  END IF;
+  IF (OLD.status, NEW.status) IN (
+    ('pending', 'submitted'),
+    ('submitted', 'accepted'),
+    ('submitted', 'rejected'),
+    ('submitted', 'failed'),
+    ('failed', 'submitted'),
+    ('failed', 'pending'),
+    ('rejected', 'pending')
+  ) THEN
+    RETURN NEW;
+  END IF;
+  RAISE EXCEPTION 'etims submission cannot move from % to %', OLD.status, NEW.status;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS etims_submissions_state ON etims_submissions;
+CREATE TRIGGER etims_submissions_state
+  BEFORE UPDATE ON etims_submissions
+  FOR EACH ROW EXECUTE FUNCTION etims_submissions_transition();
+
+UPDATE permissions
+SET description = 'Submit sales to KRA eTIMS and download tax receipts'
+WHERE key = 'etims.manage';
@@ -0,0 +1,62 @@
+DROP VIEW IF EXISTS sales_lines;
+
+CREATE TABLE IF NOT EXISTS sales (
+  id SERIAL PRIMARY KEY,
+  sale_date DATE NOT NULL,
+  product TEXT NOT NULL,
+  quantity_value NUMERIC(12,2) NOT NULL,
+  quantity_unit TEXT NOT NULL,
+  buyer TEXT NOT NULL,
+  buyer_pin TEXT NOT NULL DEFAULT '',
+  delivery_county TEXT NOT NULL DEFAULT '',
+  delivery_subcounty TEXT NOT NULL DEFAULT '',
+  vat_applicable BOOLEAN NOT NULL DEFAULT FALSE,
+  vat_rate NUMERIC(5,4) NOT NULL DEFAULT 0,
+  vat_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  net_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  price_per_unit NUMERIC(12,2) NOT NULL,
+  total_amount NUMERIC(12,2) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE
+);
+
+-- An invoice's first line keeps the invoice id so its eTIMS submission still
+-- points at it; further lines become sales of their own. Credit notes have no
+-- equivalent and are dropped.
+INSERT INTO sales(
+  id, sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin, delivery_county, delivery_subcounty,
+  vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount, created_at, farm_id
+)
+SELECT i.id, i.invoice_date, l.product, l.quantity_value, l.quantity_unit, i.buyer, i.buyer_pin, i.delivery_county, i.delivery_subcounty,
+       l.vat_class <> 'A', l.vat_rate, l.vat_amount, l.net_amount, l.price_per_unit, l.total_amount, i.created_at, i.farm_id
+FROM invoices i
+JOIN invoice_lines l ON l.invoice_id = i.id AND l.line_no = 1
+WHERE i.kind = 'invoice';
+
+SELECT setval(pg_get_serial_sequence('sales', 'id'), COALESCE((SELECT MAX(id) FROM sales), 0) + 1, false);
+
+INSERT INTO sales(
+  sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin, delivery_county, delivery_subcounty,
+  vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount, created_at, farm_id
+)
+SELECT i.invoice_date, l.product, l.quantity_value, l.quantity_unit, i.buyer, i.buyer_pin, i.delivery_county, i.delivery_subcounty,
+       l.vat_class <> 'A', l.vat_rate, l.vat_amount, l.net_amount, l.price_per_unit, l.total_amount, i.created_at, i.farm_id
+FROM invoices i
+JOIN invoice_lines l ON l.invoice_id = i.id AND l.line_no > 1
+WHERE i.kind = 'invoice'
+ORDER BY i.id, l.line_no;
+
+CREATE INDEX IF NOT EXISTS idx_sale_date ON sales(sale_date);
+CREATE INDEX IF NOT EXISTS idx_sale_farm_date ON sales(farm_id, sale_date);
+
+DELETE FROM etims_submissions WHERE invoice_id NOT IN (SELECT id FROM sales);
+
+ALTER TABLE etims_submissions DROP CONSTRAINT IF EXISTS etims_submissions_invoice_id_fkey;
+ALTER TABLE etims_submissions RENAME COLUMN invoice_id TO sale_id;
+ALTER INDEX IF EXISTS idx_etims_invoice_id_unique RENAME TO idx_etims_sale_id_unique;
+ALTER TABLE etims_submissions ADD CONSTRAINT etims_submissions_sale_id_fkey
+  FOREIGN KEY (sale_id) REFERENCES sales(id) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS invoice_lines;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS invoice_sequences;
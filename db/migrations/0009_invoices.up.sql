@@ -0,0 +1,122 @@
+-- Invoices and credit notes draw from one numbering series per farm, the way
+-- KRA expects a single invoice sequence per branch.
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+  farm_id INTEGER PRIMARY KEY REFERENCES farms(id) ON DELETE CASCADE,
+  last_number BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL DEFAULT 'invoice' CHECK (kind IN ('invoice', 'credit_note')),
+  sequence BIGINT NOT NULL,
+  invoice_number TEXT NOT NULL,
+  invoice_date DATE NOT NULL,
+  original_invoice_id INTEGER REFERENCES invoices(id) ON DELETE RESTRICT,
+  reason TEXT NOT NULL DEFAULT '',
+  buyer TEXT NOT NULL,
+  buyer_pin TEXT NOT NULL DEFAULT '',
+  delivery_county TEXT NOT NULL DEFAULT '',
+  delivery_subcounty TEXT NOT NULL DEFAULT '',
+  net_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  vat_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  total_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT invoices_sequence_key UNIQUE (farm_id, sequence),
+  CONSTRAINT invoices_number_key UNIQUE (farm_id, invoice_number),
+  CONSTRAINT invoices_credit_note_original CHECK ((kind = 'credit_note') = (original_invoice_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_farm_date ON invoices(farm_id, invoice_date DESC);
+CREATE INDEX IF NOT EXISTS idx_invoices_original ON invoices(original_invoice_id) WHERE original_invoice_id IS NOT NULL;
+
+-- vat_class is KRA's tax type: A exempt, B standard 16%, C zero rated, E 8%.
+-- Amounts are VAT inclusive like the sales they replace; a credit note's
+-- lines are stored positive and point at the line they reverse.
+CREATE TABLE IF NOT EXISTS invoice_lines (
+  id SERIAL PRIMARY KEY,
+  invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+  line_no INTEGER NOT NULL,
+  product TEXT NOT NULL,
+  quantity_value NUMERIC(12,2) NOT NULL CHECK (quantity_value > 0),
+  quantity_unit TEXT NOT NULL,
+  price_per_unit NUMERIC(12,2) NOT NULL,
+  vat_class TEXT NOT NULL DEFAULT 'A' CHECK (vat_class IN ('A', 'B', 'C', 'E')),
+  vat_rate NUMERIC(5,4) NOT NULL DEFAULT 0,
+  net_amount NUMERIC(12,2) NOT NULL,
+  vat_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  total_amount NUMERIC(12,2) NOT NULL,
+  original_line_id INTEGER REFERENCES invoice_lines(id) ON DELETE RESTRICT,
+  CONSTRAINT invoice_lines_position_key UNIQUE (invoice_id, line_no)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_lines_original ON invoice_lines(original_line_id) WHERE original_line_id IS NOT NULL;
+
+-- Every sale becomes a one-line invoice under its own id. Its sequence and
+-- number stay what eTIMS was already sent, so signed receipts keep matching.
+INSERT INTO invoices(
+  id, farm_id, kind, sequence, invoice_number, invoice_date, buyer, buyer_pin,
+  delivery_county, delivery_subcounty, net_amount, vat_amount, total_amount, created_at
+)
+SELECT id, farm_id, 'invoice', id, 'FP-' || LPAD(id::text, GREATEST(6, LENGTH(id::text)), '0'), sale_date, buyer, buyer_pin,
+       delivery_county, delivery_subcounty, net_amount, vat_amount, total_amount, created_at
+FROM sales;
+
+INSERT INTO invoice_lines(
+  invoice_id, line_no, product, quantity_value, quantity_unit, price_per_unit,
+  vat_class, vat_rate, net_amount, vat_amount, total_amount
+)
+SELECT id, 1, product, quantity_value, quantity_unit, price_per_unit,
+       CASE
+         WHEN NOT vat_applicable THEN 'A'
+         WHEN vat_rate = 0 THEN 'C'
+         WHEN vat_rate = 0.08 THEN 'E'
+         ELSE 'B'
+       END,
+       CASE WHEN vat_applicable THEN vat_rate ELSE 0 END,
+       net_amount, vat_amount, total_amount
+FROM sales;
+
+SELECT setval(pg_get_serial_sequence('invoices', 'id'), COALESCE((SELECT MAX(id) FROM invoices), 0) + 1, false);
+
+INSERT INTO invoice_sequences(farm_id, last_number)
+SELECT f.id, COALESCE(MAX(i.sequence), 0)
+FROM farms f
+LEFT JOIN invoices i ON i.farm_id = f.id
+GROUP BY f.id;
+
+ALTER TABLE etims_submissions DROP CONSTRAINT IF EXISTS etims_submissions_sale_id_fkey;
+ALTER TABLE etims_submissions RENAME COLUMN sale_id TO invoice_id;
+ALTER INDEX IF EXISTS idx_etims_sale_id_unique RENAME TO idx_etims_invoice_id_unique;
+ALTER TABLE etims_submissions ADD CONSTRAINT etims_submissions_invoice_id_fkey
+  FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE;
+
+DROP TABLE sales;
+
+-- One row per invoice line with credit notes signed negative, so revenue
+-- totals are plain sums.
+CREATE VIEW sales_lines AS
+SELECT l.id,
+       i.id AS invoice_id,
+       i.farm_id,
+       i.kind,
+       i.invoice_number,
+       i.invoice_date AS sale_date,
+       l.line_no,
+       l.product,
+       l.quantity_value * k.sign AS quantity_value,
+       l.quantity_unit,
+       i.buyer,
+       i.buyer_pin,
+       i.delivery_county,
+       i.delivery_subcounty,
+       l.vat_class,
+       l.vat_class <> 'A' AS vat_applicable,
+       l.vat_rate,
+       l.vat_amount * k.sign AS vat_amount,
+       l.net_amount * k.sign AS net_amount,
+       l.price_per_unit,
+       l.total_amount * k.sign AS total_amount
+FROM invoice_lines l
+JOIN invoices i ON i.id = l.invoice_id
+CROSS JOIN LATERAL (SELECT CASE WHEN i.kind = 'credit_note' THEN -1 ELSE 1 END AS sign) k;
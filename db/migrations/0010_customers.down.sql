@@ -0,0 +1,36 @@
+DROP VIEW IF EXISTS invoice_balances;
+DROP VIEW IF EXISTS sales_lines;
+
+DROP TABLE IF EXISTS payments;
+
+DROP INDEX IF EXISTS idx_invoices_customer;
+ALTER TABLE invoices DROP COLUMN IF EXISTS due_date;
+ALTER TABLE invoices DROP COLUMN IF EXISTS customer_id;
+
+DROP TABLE IF EXISTS customers;
+
+CREATE VIEW sales_lines AS
+SELECT l.id,
+       i.id AS invoice_id,
+       i.farm_id,
+       i.kind,
+       i.invoice_number,
+       i.invoice_date AS sale_date,
+       l.line_no,
+       l.product,
+       l.quantity_value * k.sign AS quantity_value,
+       l.quantity_unit,
+       i.buyer,
+       i.buyer_pin,
+       i.delivery_county,
+       i.delivery_subcounty,
+       l.vat_class,
+       l.vat_class <> 'A' AS vat_applicable,
+       l.vat_rate,
+       l.vat_amount * k.sign AS vat_amount,
+       l.net_amount * k.sign AS net_amount,
+       l.price_per_unit,
+       l.total_amount * k.sign AS total_amount
+FROM invoice_lines l
+JOIN invoices i ON i.id = l.invoice_id
+CROSS JOIN LATERAL (SELECT CASE WHEN i.kind = 'credit_note' THEN -1 ELSE 1 END AS sign) k;
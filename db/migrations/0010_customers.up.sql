@@ -0,0 +1,133 @@
+-- name_key folds punctuation, case and a trailing company suffix so "KCC",
+-- "K.C.C." and "Kcc Ltd" are one customer. customerKey in the API computes
+-- the same key.
+CREATE FUNCTION pg_temp.customer_key(name TEXT) RETURNS TEXT AS $$
+  SELECT COALESCE(
+    NULLIF(regexp_replace(regexp_replace(lower(name), '[^a-z0-9]+', '', 'g'), '(limited|ltd|plc)$', ''), ''),
+    regexp_replace(lower(name), '[^a-z0-9]+', '', 'g')
+  )
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS customers (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  name_key TEXT NOT NULL,
+  kra_pin TEXT NOT NULL DEFAULT '',
+  phone TEXT NOT NULL DEFAULT '',
+  email TEXT NOT NULL DEFAULT '',
+  county TEXT NOT NULL DEFAULT '',
+  subcounty TEXT NOT NULL DEFAULT '',
+  credit_days INTEGER NOT NULL DEFAULT 0 CHECK (credit_days BETWEEN 0 AND 365),
+  credit_limit NUMERIC(12,2) CHECK (credit_limit >= 0),
+  notes TEXT NOT NULL DEFAULT '',
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT customers_name_key UNIQUE (farm_id, name_key)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_kra_pin ON customers(farm_id, kra_pin) WHERE kra_pin <> '';
+
+-- Each spelling family of buyer becomes one customer named after its most
+-- used spelling. A PIN seen under several families stays with the busiest.
+WITH grouped AS (
+  SELECT farm_id,
+         pg_temp.customer_key(buyer) AS name_key,
+         MODE() WITHIN GROUP (ORDER BY buyer) AS name,
+         MAX(buyer_pin) AS kra_pin,
+         MAX(delivery_county) AS county,
+         COUNT(*) AS invoices
+  FROM invoices
+  GROUP BY farm_id, pg_temp.customer_key(buyer)
+)
+INSERT INTO customers(farm_id, name, name_key, kra_pin, county)
+SELECT farm_id, name, name_key,
+       CASE
+         WHEN kra_pin <> '' AND ROW_NUMBER() OVER (PARTITION BY farm_id, kra_pin ORDER BY invoices DESC, name_key) = 1 THEN kra_pin
+         ELSE ''
+       END,
+       county
+FROM grouped;
+
+ALTER TABLE invoices ADD COLUMN customer_id INTEGER REFERENCES customers(id) ON DELETE RESTRICT;
+ALTER TABLE invoices ADD COLUMN due_date DATE;
+
+UPDATE invoices i
+SET customer_id = c.id, due_date = i.invoice_date
+FROM customers c
+WHERE c.farm_id = i.farm_id AND c.name_key = pg_temp.customer_key(i.buyer);
+
+ALTER TABLE invoices ALTER COLUMN customer_id SET NOT NULL;
+ALTER TABLE invoices ALTER COLUMN due_date SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invoices_customer ON invoices(customer_id, invoice_date);
+
+CREATE TABLE IF NOT EXISTS payments (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE RESTRICT,
+  invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE RESTRICT,
+  payment_date DATE NOT NULL,
+  amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+  method TEXT NOT NULL DEFAULT 'cash' CHECK (method IN ('cash', 'mpesa', 'bank', 'cheque', 'other')),
+  reference TEXT NOT NULL DEFAULT '',
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_invoice ON payments(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_payments_customer_date ON payments(customer_id, payment_date);
+
+-- What is still owed on each invoice after credit notes and payments. A
+-- negative balance is money owed back to the customer.
+CREATE VIEW invoice_balances AS
+SELECT i.id AS invoice_id,
+       i.farm_id,
+       i.customer_id,
+       i.invoice_number,
+       i.invoice_date,
+       i.due_date,
+       i.total_amount,
+       COALESCE(cn.total, 0) AS credited_amount,
+       COALESCE(p.total, 0) AS paid_amount,
+       i.total_amount - COALESCE(cn.total, 0) - COALESCE(p.total, 0) AS balance
+FROM invoices i
+LEFT JOIN (
+  SELECT original_invoice_id, SUM(total_amount) AS total
+  FROM invoices
+  WHERE kind = 'credit_note'
+  GROUP BY original_invoice_id
+) cn ON cn.original_invoice_id = i.id
+LEFT JOIN (
+  SELECT invoice_id, SUM(amount) AS total
+  FROM payments
+  GROUP BY invoice_id
+) p ON p.invoice_id = i.id
+WHERE i.kind = 'invoice';
+
+CREATE OR REPLACE VIEW sales_lines AS
+SELECT l.id,
+       i.id AS invoice_id,
+       i.farm_id,
+       i.kind,
+       i.invoice_number,
+       i.invoice_date AS sale_date,
+       l.line_no,
+       l.product,
+       l.quantity_value * k.sign AS quantity_value,
+       l.quantity_unit,
+       i.buyer,
+       i.buyer_pin,
+       i.delivery_county,
+       i.delivery_subcounty,
+       l.vat_class,
+       l.vat_class <> 'A' AS vat_applicable,
+       l.vat_rate,
+       l.vat_amount * k.sign AS vat_amount,
+       l.net_amount * k.sign AS net_amount,
+       l.price_per_unit,
+       l.total_amount * k.sign AS total_amount,
+       i.customer_id
+FROM invoice_lines l
+JOIN invoices i ON i.id = l.invoice_id
+CROSS JOIN LATERAL (SELECT CASE WHEN i.kind = 'credit_note' THEN -1 ELSE 1 END AS sign) k;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS mpesa_stk_requests;
+DROP TABLE IF EXISTS mpesa_transactions;
+DROP TABLE IF EXISTS mpesa_accounts;
@@ -0,0 +1,60 @@
+-- The shortcode each farm is paid through. C2B confirmations name the
+-- shortcode, which is how they find their farm. passkey signs STK pushes and
+-- never leaves the server.
+CREATE TABLE IF NOT EXISTS mpesa_accounts (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL UNIQUE REFERENCES farms(id) ON DELETE CASCADE,
+  shortcode TEXT NOT NULL UNIQUE,
+  account_type TEXT NOT NULL DEFAULT 'paybill' CHECK (account_type IN ('paybill', 'till')),
+  passkey TEXT NOT NULL,
+  urls_registered_at TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Every M-Pesa receipt received, from an STK callback or a C2B confirmation.
+-- A receipt number is unique across Safaricom, so a payment reported by both
+-- routes is stored once. Unmatched rows are the reconciliation queue.
+CREATE TABLE IF NOT EXISTS mpesa_transactions (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  source TEXT NOT NULL CHECK (source IN ('stk', 'c2b')),
+  receipt_number TEXT NOT NULL UNIQUE,
+  transaction_time TIMESTAMP NOT NULL,
+  amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+  phone TEXT NOT NULL DEFAULT '',
+  payer_name TEXT NOT NULL DEFAULT '',
+  bill_ref TEXT NOT NULL DEFAULT '',
+  shortcode TEXT NOT NULL DEFAULT '',
+  raw JSONB NOT NULL DEFAULT '{}'::jsonb,
+  status TEXT NOT NULL DEFAULT 'unmatched' CHECK (status IN ('matched', 'unmatched', 'ignored')),
+  match_method TEXT NOT NULL DEFAULT '' CHECK (match_method IN ('', 'stk', 'reference', 'phone', 'manual')),
+  invoice_id INTEGER REFERENCES invoices(id) ON DELETE SET NULL,
+  payment_id INTEGER REFERENCES payments(id) ON DELETE SET NULL,
+  note TEXT NOT NULL DEFAULT '',
+  resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  resolved_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mpesa_transactions_queue ON mpesa_transactions(farm_id, status, transaction_time DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mpesa_transactions_payment ON mpesa_transactions(payment_id) WHERE payment_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS mpesa_stk_requests (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  invoice_id INTEGER REFERENCES invoices(id) ON DELETE SET NULL,
+  phone TEXT NOT NULL,
+  amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+  account_reference TEXT NOT NULL DEFAULT '',
+  merchant_request_id TEXT NOT NULL DEFAULT '',
+  checkout_request_id TEXT NOT NULL UNIQUE,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'failed', 'cancelled')),
+  result_code INTEGER,
+  result_desc TEXT NOT NULL DEFAULT '',
+  transaction_id INTEGER REFERENCES mpesa_transactions(id) ON DELETE SET NULL,
+  requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mpesa_stk_invoice ON mpesa_stk_requests(invoice_id, created_at DESC);
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS feed_stock_movements;
+ALTER TABLE expenses DROP COLUMN IF EXISTS goods_receipt_line_id;
+DROP TABLE IF EXISTS goods_receipt_lines;
+DROP TABLE IF EXISTS goods_receipts;
+DROP TABLE IF EXISTS purchase_order_lines;
+DROP TABLE IF EXISTS purchase_orders;
+DROP TABLE IF EXISTS feed_items;
+DROP TABLE IF EXISTS document_sequences;
+
+DROP INDEX IF EXISTS idx_feeding_supplier;
+DROP INDEX IF EXISTS idx_expenses_supplier;
+ALTER TABLE feeding_records DROP COLUMN IF EXISTS supplier_id;
+ALTER TABLE expenses DROP COLUMN IF EXISTS supplier_id;
+DROP TABLE IF EXISTS suppliers;
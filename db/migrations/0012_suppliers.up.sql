@@ -0,0 +1,150 @@
+-- Same folding as customers: "Unga Feeds", "UNGA FEEDS LTD" and "unga-feeds"
+-- are one supplier. customerKey in the API computes the same key.
+CREATE FUNCTION pg_temp.supplier_key(name TEXT) RETURNS TEXT AS $$
+  SELECT COALESCE(
+    NULLIF(regexp_replace(regexp_replace(lower(name), '[^a-z0-9]+', '', 'g'), '(limited|ltd|plc)$', ''), ''),
+    regexp_replace(lower(name), '[^a-z0-9]+', '', 'g')
+  )
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE IF NOT EXISTS suppliers (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  name_key TEXT NOT NULL,
+  kra_pin TEXT NOT NULL DEFAULT '',
+  phone TEXT NOT NULL DEFAULT '',
+  email TEXT NOT NULL DEFAULT '',
+  county TEXT NOT NULL DEFAULT '',
+  payment_days INTEGER NOT NULL DEFAULT 0 CHECK (payment_days BETWEEN 0 AND 365),
+  notes TEXT NOT NULL DEFAULT '',
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT suppliers_name_key UNIQUE (farm_id, name_key)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_suppliers_kra_pin ON suppliers(farm_id, kra_pin) WHERE kra_pin <> '';
+
+-- Every vendor and feed supplier spelling family becomes one supplier named
+-- after its most used spelling.
+WITH names AS (
+  SELECT farm_id, btrim(vendor) AS name FROM expenses WHERE btrim(vendor) <> ''
+  UNION ALL
+  SELECT farm_id, btrim(supplier) FROM feeding_records WHERE btrim(supplier) <> ''
+)
+INSERT INTO suppliers(farm_id, name, name_key)
+SELECT farm_id, MODE() WITHIN GROUP (ORDER BY name), pg_temp.supplier_key(name)
+FROM names
+WHERE pg_temp.supplier_key(name) <> ''
+GROUP BY farm_id, pg_temp.supplier_key(name);
+
+ALTER TABLE expenses ADD COLUMN supplier_id INTEGER REFERENCES suppliers(id) ON DELETE RESTRICT;
+ALTER TABLE feeding_records ADD COLUMN supplier_id INTEGER REFERENCES suppliers(id) ON DELETE RESTRICT;
+
+UPDATE expenses e
+SET supplier_id = s.id
+FROM suppliers s
+WHERE s.farm_id = e.farm_id AND s.name_key = pg_temp.supplier_key(e.vendor);
+
+UPDATE feeding_records f
+SET supplier_id = s.id
+FROM suppliers s
+WHERE s.farm_id = f.farm_id AND s.name_key = pg_temp.supplier_key(f.supplier);
+
+CREATE INDEX IF NOT EXISTS idx_expenses_supplier ON expenses(supplier_id, expense_date);
+CREATE INDEX IF NOT EXISTS idx_feeding_supplier ON feeding_records(supplier_id);
+
+-- Gapless per-farm numbering for purchasing documents, one counter per kind.
+CREATE TABLE IF NOT EXISTS document_sequences (
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL,
+  last_number INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (farm_id, kind)
+);
+
+-- What the farm keeps in its feed store. unit is what stock is counted in.
+CREATE TABLE IF NOT EXISTS feed_items (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  name_key TEXT NOT NULL,
+  unit TEXT NOT NULL DEFAULT 'kg',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT feed_items_name_key UNIQUE (farm_id, name_key)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_orders (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  supplier_id INTEGER NOT NULL REFERENCES suppliers(id) ON DELETE RESTRICT,
+  po_number TEXT NOT NULL,
+  order_date DATE NOT NULL,
+  expected_date DATE,
+  status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'partial', 'received', 'cancelled')),
+  notes TEXT NOT NULL DEFAULT '',
+  total_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT purchase_orders_number UNIQUE (farm_id, po_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_purchase_orders_supplier ON purchase_orders(supplier_id, order_date);
+CREATE INDEX IF NOT EXISTS idx_purchase_orders_farm_status ON purchase_orders(farm_id, status);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+  id SERIAL PRIMARY KEY,
+  purchase_order_id INTEGER NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+  line_no INTEGER NOT NULL,
+  item TEXT NOT NULL,
+  category TEXT NOT NULL,
+  feed_item_id INTEGER REFERENCES feed_items(id) ON DELETE RESTRICT,
+  quantity_ordered NUMERIC(12,2) NOT NULL CHECK (quantity_ordered > 0),
+  quantity_received NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (quantity_received >= 0),
+  quantity_unit TEXT NOT NULL DEFAULT 'kg',
+  unit_price NUMERIC(12,2) NOT NULL CHECK (unit_price >= 0),
+  total_amount NUMERIC(12,2) NOT NULL,
+  CONSTRAINT purchase_order_lines_no UNIQUE (purchase_order_id, line_no)
+);
+
+-- A goods-received note records what actually arrived against an order.
+CREATE TABLE IF NOT EXISTS goods_receipts (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  purchase_order_id INTEGER NOT NULL REFERENCES purchase_orders(id) ON DELETE RESTRICT,
+  grn_number TEXT NOT NULL,
+  received_date DATE NOT NULL,
+  delivery_note TEXT NOT NULL DEFAULT '',
+  notes TEXT NOT NULL DEFAULT '',
+  total_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+  received_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT goods_receipts_number UNIQUE (farm_id, grn_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_goods_receipts_order ON goods_receipts(purchase_order_id);
+
+CREATE TABLE IF NOT EXISTS goods_receipt_lines (
+  id SERIAL PRIMARY KEY,
+  goods_receipt_id INTEGER NOT NULL REFERENCES goods_receipts(id) ON DELETE CASCADE,
+  purchase_order_line_id INTEGER NOT NULL REFERENCES purchase_order_lines(id) ON DELETE RESTRICT,
+  quantity NUMERIC(12,2) NOT NULL CHECK (quantity > 0),
+  amount NUMERIC(12,2) NOT NULL
+);
+
+-- Receiving books the expense and the feed store entry; reversing the note
+-- takes both away again.
+ALTER TABLE expenses ADD COLUMN goods_receipt_line_id INTEGER UNIQUE REFERENCES goods_receipt_lines(id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS feed_stock_movements (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  feed_item_id INTEGER NOT NULL REFERENCES feed_items(id) ON DELETE CASCADE,
+  movement_date DATE NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('receipt')),
+  quantity NUMERIC(12,2) NOT NULL,
+  unit_cost NUMERIC(12,2) NOT NULL DEFAULT 0,
+  goods_receipt_line_id INTEGER UNIQUE REFERENCES goods_receipt_lines(id) ON DELETE CASCADE,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_feed_stock_item_date ON feed_stock_movements(feed_item_id, movement_date);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_feed_stock_feeding_record;
+DELETE FROM feed_stock_movements WHERE kind <> 'receipt';
+ALTER TABLE feed_stock_movements DROP COLUMN IF EXISTS created_by;
+ALTER TABLE feed_stock_movements DROP COLUMN IF EXISTS feeding_record_id;
+ALTER TABLE feed_stock_movements DROP CONSTRAINT IF EXISTS feed_stock_movements_count_check;
+ALTER TABLE feed_stock_movements DROP COLUMN IF EXISTS counted_quantity;
+ALTER TABLE feed_stock_movements DROP CONSTRAINT IF EXISTS feed_stock_movements_kind_check;
+ALTER TABLE feed_stock_movements ADD CONSTRAINT feed_stock_movements_kind_check CHECK (kind IN ('receipt'));
+
+ALTER TABLE feeding_ration_items DROP COLUMN IF EXISTS feed_item_id;
+ALTER TABLE feed_items DROP COLUMN IF EXISTS notes;
+ALTER TABLE feed_items DROP COLUMN IF EXISTS reorder_level;
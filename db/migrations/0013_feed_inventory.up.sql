@@ -0,0 +1,32 @@
+ALTER TABLE feed_items ADD COLUMN reorder_level NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (reorder_level >= 0);
+ALTER TABLE feed_items ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+
+-- Every ration ingredient becomes a store item so feeding can draw it down.
+INSERT INTO feed_items(farm_id, name, name_key, unit)
+SELECT DISTINCT ON (r.farm_id, lower(regexp_replace(btrim(i.ingredient), '\s+', ' ', 'g')))
+       r.farm_id, regexp_replace(btrim(i.ingredient), '\s+', ' ', 'g'), lower(regexp_replace(btrim(i.ingredient), '\s+', ' ', 'g')), i.quantity_unit
+FROM feeding_ration_items i
+JOIN feeding_rations r ON r.id = i.ration_id
+WHERE btrim(i.ingredient) <> ''
+ORDER BY r.farm_id, lower(regexp_replace(btrim(i.ingredient), '\s+', ' ', 'g')), i.id
+ON CONFLICT (farm_id, name_key) DO NOTHING;
+
+ALTER TABLE feeding_ration_items ADD COLUMN feed_item_id INTEGER REFERENCES feed_items(id) ON DELETE SET NULL;
+
+UPDATE feeding_ration_items i
+SET feed_item_id = f.id
+FROM feeding_rations r, feed_items f
+WHERE r.id = i.ration_id AND f.farm_id = r.farm_id
+  AND f.name_key = lower(regexp_replace(btrim(i.ingredient), '\s+', ' ', 'g'));
+
+-- Stock on hand is the sum of quantity. Issues are negative, adjustments go
+-- either way and a count stores the difference it found next to what was
+-- counted.
+ALTER TABLE feed_stock_movements DROP CONSTRAINT feed_stock_movements_kind_check;
+ALTER TABLE feed_stock_movements ADD CONSTRAINT feed_stock_movements_kind_check CHECK (kind IN ('receipt', 'issue', 'adjustment', 'count'));
+ALTER TABLE feed_stock_movements ADD COLUMN counted_quantity NUMERIC(12,2);
+ALTER TABLE feed_stock_movements ADD CONSTRAINT feed_stock_movements_count_check CHECK ((kind = 'count') = (counted_quantity IS NOT NULL));
+ALTER TABLE feed_stock_movements ADD COLUMN feeding_record_id INTEGER REFERENCES feeding_records(id) ON DELETE CASCADE;
+ALTER TABLE feed_stock_movements ADD COLUMN created_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_feed_stock_feeding_record ON feed_stock_movements(feeding_record_id) WHERE feeding_record_id IS NOT NULL;
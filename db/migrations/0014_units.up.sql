@@ -0,0 +1,39 @@
+-- Fold the unit spellings already stored onto the registry codes in
+-- internal/api/units.go. Units that match nothing are left as entered; they
+-- have to be corrected before the row can be saved again.
+CREATE TEMP TABLE unit_aliases (alias TEXT PRIMARY KEY, code TEXT NOT NULL) ON COMMIT DROP;
+INSERT INTO unit_aliases(alias, code) VALUES
+  ('kg', 'kg'), ('kgs', 'kg'), ('kilo', 'kg'), ('kilos', 'kg'), ('kilogram', 'kg'), ('kilograms', 'kg'),
+  ('g', 'g'), ('gm', 'g'), ('gms', 'g'), ('gram', 'g'), ('grams', 'g'),
+  ('t', 't'), ('ton', 't'), ('tons', 't'), ('tonne', 't'), ('tonnes', 't'),
+  ('bag-50kg', 'bag-50kg'), ('bag', 'bag-50kg'), ('bags', 'bag-50kg'), ('50kg bag', 'bag-50kg'), ('bag 50kg', 'bag-50kg'),
+  ('l', 'L'), ('ltr', 'L'), ('ltrs', 'L'), ('litre', 'L'), ('litres', 'L'), ('liter', 'L'), ('liters', 'L'),
+  ('ml', 'ml'), ('mls', 'ml'), ('millilitre', 'ml'), ('milliliter', 'ml'),
+  ('mg', 'mg'), ('milligram', 'mg'), ('milligrams', 'mg'),
+  ('pcs', 'pcs'), ('pc', 'pcs'), ('piece', 'pcs'), ('pieces', 'pcs'), ('each', 'pcs'),
+  ('tablet', 'pcs'), ('tablets', 'pcs'), ('bolus', 'pcs'), ('boluses', 'pcs'), ('dose', 'pcs'), ('doses', 'pcs'),
+  ('head', 'head'), ('heads', 'head'),
+  ('dozen', 'dozen'), ('doz', 'dozen'), ('dozens', 'dozen'),
+  ('tray-30', 'tray-30'), ('tray', 'tray-30'), ('trays', 'tray-30');
+
+UPDATE feeding_records t SET quantity_unit = a.code
+FROM unit_aliases a WHERE a.alias = lower(regexp_replace(btrim(t.quantity_unit), '\s+', ' ', 'g')) AND t.quantity_unit <> a.code;
+
+UPDATE feeding_ration_items t SET quantity_unit = a.code
+FROM unit_aliases a WHERE a.alias = lower(regexp_replace(btrim(t.quantity_unit), '\s+', ' ', 'g')) AND t.quantity_unit <> a.code;
+
+UPDATE feeding_plans t SET daily_quantity_unit = a.code
+FROM unit_aliases a WHERE a.alias = lower(regexp_replace(btrim(t.daily_quantity_unit), '\s+', ' ', 'g')) AND t.daily_quantity_unit <> a.code;
+
+UPDATE invoice_lines t SET quantity_unit = a.code
+FROM unit_aliases a WHERE a.alias = lower(regexp_replace(btrim(t.quantity_unit), '\s+', ' ', 'g')) AND t.quantity_unit <> a.code;
+
+UPDATE purchase_order_lines t SET quantity_unit = a.code
+FROM unit_aliases a WHERE a.alias = lower(regexp_replace(btrim(t.quantity_unit), '\s+', ' ', 'g')) AND t.quantity_unit <> a.code;
+
+UPDATE feed_items t SET unit = a.code
+FROM unit_aliases a WHERE a.alias = lower(regexp_replace(btrim(t.unit), '\s+', ' ', 'g')) AND t.unit <> a.code;
+
+-- kg per litre, for feeds measured by volume in one place and by weight in
+-- another. Blank falls back to the built-in densities.
+ALTER TABLE feed_items ADD COLUMN density NUMERIC(8,3) CHECK (density > 0);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_animals_birth_record;
+DROP INDEX IF EXISTS idx_animals_father;
+DROP INDEX IF EXISTS idx_animals_mother;
+ALTER TABLE animals DROP CONSTRAINT IF EXISTS animals_parents_check;
+ALTER TABLE animals DROP COLUMN IF EXISTS birth_record_id;
+ALTER TABLE animals DROP COLUMN IF EXISTS sire_name;
+ALTER TABLE animals DROP COLUMN IF EXISTS father_id;
+ALTER TABLE animals DROP COLUMN IF EXISTS mother_id;
+ALTER TABLE animals DROP COLUMN IF EXISTS sex;
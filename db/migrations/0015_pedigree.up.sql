@@ -0,0 +1,18 @@
+-- Parentage. A sire used by AI from outside the farm has no animal row, so
+-- its name or code is kept as text instead.
+ALTER TABLE animals ADD COLUMN sex TEXT NOT NULL DEFAULT '' CHECK (sex IN ('', 'female', 'male'));
+ALTER TABLE animals ADD COLUMN mother_id INTEGER REFERENCES animals(id) ON DELETE SET NULL;
+ALTER TABLE animals ADD COLUMN father_id INTEGER REFERENCES animals(id) ON DELETE SET NULL;
+ALTER TABLE animals ADD COLUMN sire_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE animals ADD COLUMN birth_record_id INTEGER REFERENCES breeding_records(id) ON DELETE SET NULL;
+ALTER TABLE animals ADD CONSTRAINT animals_parents_check CHECK (mother_id <> id AND father_id <> id AND mother_id <> father_id);
+
+CREATE INDEX IF NOT EXISTS idx_animals_mother ON animals(mother_id) WHERE mother_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_animals_father ON animals(father_id) WHERE father_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_animals_birth_record ON animals(birth_record_id) WHERE birth_record_id IS NOT NULL;
+
+UPDATE animals SET sex = 'female'
+WHERE id IN (SELECT mother_animal_id FROM breeding_records);
+
+UPDATE animals SET sex = 'male'
+WHERE sex = '' AND id IN (SELECT father_animal_id FROM breeding_records WHERE father_animal_id IS NOT NULL);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS animal_events;
+
+UPDATE animals SET status = 'inactive' WHERE status IN ('dead', 'culled');
+ALTER TABLE animals DROP CONSTRAINT IF EXISTS animals_status_check;
+ALTER TABLE animals ADD CONSTRAINT animals_status_check CHECK (status IN ('active', 'inactive', 'sold'));
+
+ALTER TABLE animals DROP COLUMN IF EXISTS location_id;
+DROP TABLE IF EXISTS animal_locations;
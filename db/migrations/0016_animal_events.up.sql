@@ -0,0 +1,67 @@
+CREATE TABLE IF NOT EXISTS animal_locations (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  name_key TEXT NOT NULL,
+  kind TEXT NOT NULL DEFAULT 'pen' CHECK (kind IN ('pen', 'paddock', 'other')),
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT animal_locations_name_key UNIQUE (farm_id, name_key)
+);
+
+ALTER TABLE animals ADD COLUMN location_id INTEGER REFERENCES animal_locations(id) ON DELETE RESTRICT;
+
+-- Sold, dead and culled animals leave the herd through an event. inactive is
+-- left for records deleted as mistakes.
+ALTER TABLE animals DROP CONSTRAINT IF EXISTS animals_status_check;
+ALTER TABLE animals ADD CONSTRAINT animals_status_check CHECK (status IN ('active', 'inactive', 'sold', 'dead', 'culled'));
+
+-- Every animal enters the herd once (purchase or birth) and leaves at most
+-- once (sale, death or cull); transfers move it between locations in
+-- between. The herd on any date is what entered by then and had not left.
+CREATE TABLE IF NOT EXISTS animal_events (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  animal_id INTEGER NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+  event_type TEXT NOT NULL CHECK (event_type IN ('purchase', 'birth', 'transfer', 'sale', 'death', 'cull')),
+  event_date DATE NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  notes TEXT NOT NULL DEFAULT '',
+  amount NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (amount >= 0),
+  from_location_id INTEGER REFERENCES animal_locations(id) ON DELETE SET NULL,
+  to_location_id INTEGER REFERENCES animal_locations(id) ON DELETE SET NULL,
+  invoice_id INTEGER REFERENCES invoices(id) ON DELETE RESTRICT,
+  expense_id INTEGER REFERENCES expenses(id) ON DELETE SET NULL,
+  breeding_record_id INTEGER REFERENCES breeding_records(id) ON DELETE SET NULL,
+  created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT animal_events_invoice_check CHECK (invoice_id IS NULL OR event_type = 'sale'),
+  CONSTRAINT animal_events_expense_check CHECK (expense_id IS NULL OR event_type = 'purchase'),
+  CONSTRAINT animal_events_transfer_check CHECK ((event_type = 'transfer') = (to_location_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_animal_events_farm_date ON animal_events(farm_id, event_date);
+CREATE INDEX IF NOT EXISTS idx_animal_events_animal ON animal_events(animal_id, event_date);
+CREATE INDEX IF NOT EXISTS idx_animal_events_invoice ON animal_events(invoice_id) WHERE invoice_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_animal_events_entry ON animal_events(animal_id) WHERE event_type IN ('purchase', 'birth');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_animal_events_exit ON animal_events(animal_id) WHERE event_type IN ('sale', 'death', 'cull');
+
+-- Existing animals get an opening entry. Offspring registered from a birth
+-- entered on their birth date; everything else on the day it was recorded.
+INSERT INTO animal_events(farm_id, animal_id, event_type, event_date, reason, breeding_record_id)
+SELECT farm_id, id,
+       CASE WHEN birth_record_id IS NOT NULL AND birth_date IS NOT NULL THEN 'birth' ELSE 'purchase' END,
+       CASE WHEN birth_record_id IS NOT NULL AND birth_date IS NOT NULL THEN birth_date ELSE created_at::date END,
+       CASE WHEN birth_record_id IS NOT NULL AND birth_date IS NOT NULL THEN '' ELSE 'opening_stock' END,
+       birth_record_id
+FROM animals;
+
+-- Animals already marked sold left on their last recorded change.
+INSERT INTO animal_events(farm_id, animal_id, event_type, event_date, reason)
+SELECT a.farm_id, a.id, 'sale',
+       GREATEST(a.created_at::date, COALESCE(
+         (SELECT MAX(e.created_at)::date FROM audit_events e WHERE e.entity = 'animals' AND e.entity_id = a.id),
+         a.created_at::date)),
+       'unrecorded'
+FROM animals a
+WHERE a.status = 'sold';
@@ -0,0 +1,39 @@
+-- Reports generated on a timetable and emailed to recipients. hour, weekday
+-- and month_day are in the server's timezone; weekday is 0 for Sunday and
+-- month_day stops at 28 so every month has one. Run times are stored in UTC.
+CREATE TABLE IF NOT EXISTS report_schedules (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  category TEXT NOT NULL,
+  date_range TEXT NOT NULL,
+  format TEXT NOT NULL CHECK (format IN ('PDF', 'CSV')),
+  frequency TEXT NOT NULL CHECK (frequency IN ('daily', 'weekly', 'monthly')),
+  hour INTEGER NOT NULL DEFAULT 6 CHECK (hour BETWEEN 0 AND 23),
+  weekday INTEGER NOT NULL DEFAULT 1 CHECK (weekday BETWEEN 0 AND 6),
+  month_day INTEGER NOT NULL DEFAULT 1 CHECK (month_day BETWEEN 1 AND 28),
+  recipients TEXT[] NOT NULL CHECK (cardinality(recipients) > 0),
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  next_run_at TIMESTAMP NOT NULL,
+  last_run_at TIMESTAMP,
+  created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_schedules_due ON report_schedules(next_run_at) WHERE is_active;
+
+-- One row per run. partial means some recipients got the email and some
+-- did not; error says which.
+CREATE TABLE IF NOT EXISTS report_runs (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  schedule_id INTEGER NOT NULL REFERENCES report_schedules(id) ON DELETE CASCADE,
+  report_id INTEGER REFERENCES reports(id) ON DELETE SET NULL,
+  status TEXT NOT NULL CHECK (status IN ('sent', 'partial', 'failed')),
+  recipients TEXT[] NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  started_at TIMESTAMP NOT NULL,
+  finished_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_runs_schedule ON report_runs(schedule_id, started_at DESC);
@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE key = 'alerts.manage';
+
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS notification_deliveries;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS alert_firings;
+DROP TABLE IF EXISTS alert_rules;
+
+DROP TRIGGER IF EXISTS animals_health_status_since ON animals;
+DROP FUNCTION IF EXISTS animals_health_status_since();
+ALTER TABLE animals DROP COLUMN IF EXISTS health_status_since;
@@ -0,0 +1,120 @@
+-- When an animal's health status last changed, so alert rules can ask how
+-- long it has been in attention or sick. The trigger keeps it current however
+-- the status is written. Existing animals take their last audited status
+-- change, or the day they were recorded.
+ALTER TABLE animals ADD COLUMN IF NOT EXISTS health_status_since TIMESTAMP NOT NULL DEFAULT NOW();
+
+UPDATE animals a
+SET health_status_since = COALESCE(
+  (SELECT MAX(e.created_at) FROM audit_events e
+   WHERE e.entity = 'animals' AND e.entity_id = a.id
+     AND e.after_data->>'health_status' IS DISTINCT FROM e.before_data->>'health_status'),
+  a.created_at);
+
+CREATE OR REPLACE FUNCTION animals_health_status_since() RETURNS trigger AS $$
+BEGIN
+  IF NEW.health_status IS DISTINCT FROM OLD.health_status THEN
+    NEW.health_status_since := NOW();
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS animals_health_status_since ON animals;
+CREATE TRIGGER animals_health_status_since
+  BEFORE UPDATE OF health_status ON animals
+  FOR EACH ROW EXECUTE FUNCTION animals_health_status_since();
+
+-- Conditions checked on a schedule for each farm. days is the look-ahead for
+-- vaccination_due and birth_expected, how long an animal has been in the
+-- status for animal_attention and animal_sick, and the length of the two
+-- windows compared for milk_drop. percent is only used by milk_drop.
+CREATE TABLE IF NOT EXISTS alert_rules (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  condition TEXT NOT NULL CHECK (condition IN ('vaccination_due', 'birth_expected', 'milk_drop', 'animal_attention', 'animal_sick')),
+  days INTEGER NOT NULL CHECK (days BETWEEN 0 AND 365),
+  percent NUMERIC(5,2) NOT NULL DEFAULT 0 CHECK (percent >= 0 AND percent <= 100),
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_rules_farm ON alert_rules(farm_id);
+
+-- What each rule has already alerted about, so a condition that still holds
+-- on the next check is not announced again. key names the thing that matched,
+-- e.g. the health record and its due date.
+CREATE TABLE IF NOT EXISTS alert_firings (
+  rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  fired_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (rule_id, key)
+);
+
+-- One row per user per alert. in_app is false when the user only wanted the
+-- alert by email or SMS; those rows stay out of the notification center.
+CREATE TABLE IF NOT EXISTS notifications (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  rule_id INTEGER REFERENCES alert_rules(id) ON DELETE SET NULL,
+  kind TEXT NOT NULL,
+  title TEXT NOT NULL,
+  body TEXT NOT NULL DEFAULT '',
+  entity TEXT NOT NULL DEFAULT '',
+  entity_id BIGINT,
+  in_app BOOLEAN NOT NULL DEFAULT TRUE,
+  read_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(farm_id, user_id, created_at DESC) WHERE in_app;
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(farm_id, user_id) WHERE in_app AND read_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+  id SERIAL PRIMARY KEY,
+  notification_id INTEGER NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+  channel TEXT NOT NULL CHECK (channel IN ('email', 'sms')),
+  destination TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL CHECK (status IN ('sent', 'failed', 'skipped')),
+  error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_notification ON notification_deliveries(notification_id);
+
+-- Which channels a user wants for each kind of alert on a farm. Without a
+-- row the alert only goes to the notification center.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  id SERIAL UNIQUE,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL,
+  in_app BOOLEAN NOT NULL DEFAULT TRUE,
+  email BOOLEAN NOT NULL DEFAULT FALSE,
+  sms BOOLEAN NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (farm_id, user_id, kind)
+);
+
+INSERT INTO alert_rules(farm_id, name, condition, days, percent)
+SELECT f.id, v.name, v.condition, v.days, v.percent
+FROM farms f
+CROSS JOIN (VALUES
+  ('Vaccinations due this week', 'vaccination_due', 7, 0),
+  ('Births expected in two weeks', 'birth_expected', 14, 0),
+  ('Milk yield down 20%', 'milk_drop', 3, 20),
+  ('Needs attention for 3 days', 'animal_attention', 3, 0),
+  ('Sick animals', 'animal_sick', 0, 0)
+) AS v(name, condition, days, percent);
+
+INSERT INTO permissions(key, description) VALUES
+  ('alerts.manage', 'Create, update and delete alert rules')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO role_permissions(role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.key = 'alerts.manage'
+WHERE r.name IN ('owner', 'manager')
+ON CONFLICT DO NOTHING;
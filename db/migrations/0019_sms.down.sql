@@ -0,0 +1,6 @@
+ALTER TABLE users DROP COLUMN IF EXISTS reset_code_attempts;
+ALTER TABLE users DROP COLUMN IF EXISTS reset_code_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS reset_code_hash;
+
+DROP TABLE IF EXISTS sms_opt_outs;
+DROP TABLE IF EXISTS sms_messages;
@@ -0,0 +1,37 @@
+-- Every text message we hand to the gateway, with what it cost and how far
+-- it got. farm_id is empty for account messages such as password reset
+-- codes, which belong to a user rather than a farm.
+CREATE TABLE IF NOT EXISTS sms_messages (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER REFERENCES farms(id) ON DELETE CASCADE,
+  user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  notification_id INTEGER REFERENCES notifications(id) ON DELETE SET NULL,
+  phone TEXT NOT NULL,
+  template TEXT NOT NULL,
+  body TEXT NOT NULL,
+  provider_message_id TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'sent', 'delivered', 'failed', 'opted_out')),
+  failure_reason TEXT NOT NULL DEFAULT '',
+  cost NUMERIC(10,4) NOT NULL DEFAULT 0,
+  currency TEXT NOT NULL DEFAULT 'KES',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sms_messages_farm ON sms_messages(farm_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sms_messages_provider_id ON sms_messages(provider_message_id) WHERE provider_message_id <> '';
+
+-- Numbers that must not be texted, whoever asks. source says whether the
+-- person opted out in FarmPro or the gateway told us they blocked us.
+CREATE TABLE IF NOT EXISTS sms_opt_outs (
+  id SERIAL UNIQUE,
+  phone TEXT PRIMARY KEY,
+  source TEXT NOT NULL CHECK (source IN ('user', 'gateway')),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Password reset by text message. The code is short, so it only survives a
+-- few wrong guesses.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reset_code_hash TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reset_code_expires_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reset_code_attempts INTEGER NOT NULL DEFAULT 0;
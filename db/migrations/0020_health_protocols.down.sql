@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS health_tasks;
+DROP TABLE IF EXISTS animal_health_protocols;
+DROP TABLE IF EXISTS health_protocol_steps;
+DROP TABLE IF EXISTS health_protocols;
@@ -0,0 +1,77 @@
+-- Reusable vaccination and treatment schedules for one species, e.g.
+-- "Cattle: FMD every 6 months, LSD yearly, deworm every 3 months". New
+-- animals of the species get the auto_assign ones.
+CREATE TABLE IF NOT EXISTS health_protocols (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  species TEXT NOT NULL,
+  auto_assign BOOLEAN NOT NULL DEFAULT TRUE,
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE (farm_id, name)
+);
+
+-- One recurring treatment in a protocol. min_age_days holds the first dose
+-- back for young stock, counted from the birth date.
+CREATE TABLE IF NOT EXISTS health_protocol_steps (
+  id SERIAL PRIMARY KEY,
+  protocol_id INTEGER NOT NULL REFERENCES health_protocols(id) ON DELETE CASCADE,
+  action TEXT NOT NULL,
+  treatment TEXT NOT NULL,
+  every_count INTEGER NOT NULL CHECK (every_count > 0),
+  every_unit TEXT NOT NULL CHECK (every_unit IN ('day', 'week', 'month', 'year')),
+  min_age_days INTEGER NOT NULL DEFAULT 0 CHECK (min_age_days >= 0),
+  position INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_protocol_steps_protocol ON health_protocol_steps(protocol_id, position);
+
+CREATE TABLE IF NOT EXISTS animal_health_protocols (
+  animal_id INTEGER NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+  protocol_id INTEGER NOT NULL REFERENCES health_protocols(id) ON DELETE CASCADE,
+  assigned_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (animal_id, protocol_id)
+);
+
+-- A treatment an animal is due for. Tasks come from protocol steps or from
+-- the next due date typed on a health record (source_record_id); completing
+-- one writes the health record that closes it (health_record_id).
+CREATE TABLE IF NOT EXISTS health_tasks (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  animal_id INTEGER NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+  protocol_id INTEGER REFERENCES health_protocols(id) ON DELETE SET NULL,
+  step_id INTEGER REFERENCES health_protocol_steps(id) ON DELETE SET NULL,
+  source_record_id INTEGER REFERENCES health_records(id) ON DELETE SET NULL,
+  action TEXT NOT NULL,
+  treatment TEXT NOT NULL,
+  due_date DATE NOT NULL,
+  status TEXT NOT NULL DEFAULT 'scheduled' CHECK (status IN ('scheduled', 'done', 'skipped', 'cancelled')),
+  health_record_id INTEGER REFERENCES health_records(id) ON DELETE SET NULL,
+  completed_at TIMESTAMP,
+  completed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_tasks_due ON health_tasks(farm_id, due_date) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS idx_health_tasks_animal ON health_tasks(animal_id, due_date);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_health_tasks_open_step ON health_tasks(animal_id, step_id) WHERE status = 'scheduled' AND step_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_health_tasks_source ON health_tasks(source_record_id) WHERE source_record_id IS NOT NULL;
+
+-- Next due dates already on record become tasks for animals still in the
+-- herd, unless a later record of the same treatment shows the animal has had
+-- it since.
+INSERT INTO health_tasks(farm_id, animal_id, source_record_id, action, treatment, due_date)
+SELECT h.farm_id, h.animal_id, h.id, h.action, h.treatment, h.next_due
+FROM health_records h
+JOIN animals a ON a.id = h.animal_id AND a.is_active
+WHERE h.next_due IS NOT NULL
+  AND NOT EXISTS (
+    SELECT 1 FROM health_records later
+    WHERE later.animal_id = h.animal_id
+      AND LOWER(later.treatment) = LOWER(h.treatment)
+      AND (later.record_date > h.record_date OR (later.record_date = h.record_date AND later.id > h.id))
+  )
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,12 @@
+ALTER TABLE production_records DROP COLUMN IF EXISTS withheld;
+
+ALTER TABLE health_records DROP COLUMN IF EXISTS meat_withdrawal_until;
+ALTER TABLE health_records DROP COLUMN IF EXISTS milk_withdrawal_until;
+ALTER TABLE health_records DROP COLUMN IF EXISTS dose_unit;
+ALTER TABLE health_records DROP COLUMN IF EXISTS dose_quantity;
+ALTER TABLE health_records DROP COLUMN IF EXISTS batch_id;
+ALTER TABLE health_records DROP COLUMN IF EXISTS medicine_id;
+
+DROP TABLE IF EXISTS medicine_stock_movements;
+DROP TABLE IF EXISTS medicine_batches;
+DROP TABLE IF EXISTS medicines;
@@ -0,0 +1,66 @@
+-- The farm's veterinary medicines. Withdrawal periods are as printed on the
+-- label: milk in hours, meat in days.
+CREATE TABLE IF NOT EXISTS medicines (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  active_ingredient TEXT NOT NULL DEFAULT '',
+  unit TEXT NOT NULL,
+  milk_withdrawal_hours INTEGER NOT NULL DEFAULT 0 CHECK (milk_withdrawal_hours >= 0),
+  meat_withdrawal_days INTEGER NOT NULL DEFAULT 0 CHECK (meat_withdrawal_days >= 0),
+  notes TEXT NOT NULL DEFAULT '',
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE (farm_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS medicine_batches (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  medicine_id INTEGER NOT NULL REFERENCES medicines(id) ON DELETE CASCADE,
+  batch_number TEXT NOT NULL,
+  expiry_date DATE NOT NULL,
+  received_date DATE NOT NULL,
+  supplier_id INTEGER REFERENCES suppliers(id) ON DELETE SET NULL,
+  cost NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (cost >= 0),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE (medicine_id, batch_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_medicine_batches_expiry ON medicine_batches(farm_id, expiry_date);
+
+-- Stock on hand in a batch is the sum of quantity, in the medicine's unit.
+-- Doses given are issues tied to the health record, so deleting the record
+-- puts the stock back.
+CREATE TABLE IF NOT EXISTS medicine_stock_movements (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  batch_id INTEGER NOT NULL REFERENCES medicine_batches(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('receipt', 'issue', 'adjustment', 'disposal')),
+  quantity NUMERIC(12,3) NOT NULL,
+  movement_date DATE NOT NULL,
+  health_record_id INTEGER REFERENCES health_records(id) ON DELETE CASCADE,
+  notes TEXT NOT NULL DEFAULT '',
+  created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_medicine_stock_batch ON medicine_stock_movements(batch_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_medicine_stock_health_record ON medicine_stock_movements(health_record_id) WHERE health_record_id IS NOT NULL;
+
+-- What was given and the withdrawal it started. The end dates are worked
+-- out when the record is saved, so later label changes do not move them; a
+-- date is the last day the animal's milk or meat must be kept out.
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS medicine_id INTEGER REFERENCES medicines(id) ON DELETE SET NULL;
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS batch_id INTEGER REFERENCES medicine_batches(id) ON DELETE SET NULL;
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS dose_quantity NUMERIC(12,3);
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS dose_unit TEXT NOT NULL DEFAULT '';
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS milk_withdrawal_until DATE;
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS meat_withdrawal_until DATE;
+
+CREATE INDEX IF NOT EXISTS idx_health_milk_withdrawal ON health_records(animal_id, milk_withdrawal_until) WHERE milk_withdrawal_until IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_health_meat_withdrawal ON health_records(animal_id, meat_withdrawal_until) WHERE meat_withdrawal_until IS NOT NULL;
+
+-- Milk recorded from an animal under withdrawal is kept for its yield
+-- history but is not for sale, so it carries no value.
+ALTER TABLE production_records ADD COLUMN IF NOT EXISTS withheld BOOLEAN NOT NULL DEFAULT FALSE;
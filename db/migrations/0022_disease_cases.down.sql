@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS quarantine_animals;
+DROP TABLE IF EXISTS quarantine_groups;
+
+ALTER TABLE health_records DROP COLUMN IF EXISTS case_id;
+
+DROP TABLE IF EXISTS disease_cases;
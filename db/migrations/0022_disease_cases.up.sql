@@ -0,0 +1,68 @@
+-- An illness of one animal from onset to outcome. An open case (no outcome
+-- yet) is what makes an animal sick (moderate or severe) or in need of
+-- attention (mild); animals.health_status is kept in step with its cases.
+-- Notifiable diseases must be reported to the county veterinary officer.
+CREATE TABLE IF NOT EXISTS disease_cases (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  animal_id INTEGER NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+  diagnosis TEXT NOT NULL,
+  onset_date DATE NOT NULL,
+  symptoms TEXT[] NOT NULL DEFAULT '{}',
+  severity TEXT NOT NULL CHECK (severity IN ('mild', 'moderate', 'severe')),
+  notifiable BOOLEAN NOT NULL DEFAULT FALSE,
+  reported_on DATE,
+  veterinarian TEXT NOT NULL DEFAULT '',
+  outcome TEXT CHECK (outcome IN ('recovered', 'chronic', 'died', 'culled')),
+  closed_on DATE,
+  notes TEXT NOT NULL DEFAULT '',
+  created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  CHECK ((outcome IS NULL) = (closed_on IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_disease_cases_farm_onset ON disease_cases(farm_id, onset_date);
+CREATE INDEX IF NOT EXISTS idx_disease_cases_open ON disease_cases(animal_id) WHERE outcome IS NULL;
+
+-- Treatments given for a case.
+ALTER TABLE health_records ADD COLUMN IF NOT EXISTS case_id INTEGER REFERENCES disease_cases(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_health_records_case ON health_records(case_id) WHERE case_id IS NOT NULL;
+
+-- Animals kept apart from the herd, e.g. new arrivals or the contacts of a
+-- case. A group is closed by setting ended_on, which releases everyone in it.
+CREATE TABLE IF NOT EXISTS quarantine_groups (
+  id SERIAL PRIMARY KEY,
+  farm_id INTEGER NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  location_id INTEGER REFERENCES animal_locations(id) ON DELETE SET NULL,
+  started_on DATE NOT NULL,
+  ended_on DATE,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE (farm_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS quarantine_animals (
+  id SERIAL PRIMARY KEY,
+  group_id INTEGER NOT NULL REFERENCES quarantine_groups(id) ON DELETE CASCADE,
+  animal_id INTEGER NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+  case_id INTEGER REFERENCES disease_cases(id) ON DELETE SET NULL,
+  entered_on DATE NOT NULL,
+  released_on DATE,
+  CHECK (released_on IS NULL OR released_on >= entered_on)
+);
+
+-- An animal is in at most one quarantine at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quarantine_animals_open ON quarantine_animals(animal_id) WHERE released_on IS NULL;
+CREATE INDEX IF NOT EXISTS idx_quarantine_animals_group ON quarantine_animals(group_id);
+
+-- Animals marked sick or needing attention by hand get an open case, so the
+-- status they have now survives being derived from cases.
+INSERT INTO disease_cases(farm_id, animal_id, diagnosis, onset_date, severity, notes)
+SELECT farm_id, id, 'Undiagnosed', health_status_since::date,
+  CASE health_status WHEN 'sick' THEN 'moderate' ELSE 'mild' END,
+  'Opened from the health status set before disease cases were recorded.'
+FROM animals
+WHERE health_status <> 'healthy'
+  AND NOT EXISTS (SELECT 1 FROM disease_cases c WHERE c.animal_id = animals.id);
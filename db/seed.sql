@@ -11,3 +11,10 @@ SELECT
 FROM roles r
 WHERE r.name = 'owner'
   AND NOT EXISTS (SELECT 1 FROM users u WHERE u.email = 'john@farmpro.com');
+
+INSERT INTO farm_members(farm_id, user_id, role_id)
+SELECT f.id, u.id, u.role_id
+FROM users u
+CROSS JOIN (SELECT id FROM farms ORDER BY id LIMIT 1) f
+WHERE u.email = 'john@farmpro.com'
+ON CONFLICT (farm_id, user_id) DO NOTHING;
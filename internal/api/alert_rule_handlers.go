@@ -0,0 +1,554 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	alertVaccinationDue  = "vaccination_due"
+	alertBirthExpected   = "birth_expected"
+	alertMilkDrop        = "milk_drop"
+	alertAnimalAttention = "animal_attention"
+	alertAnimalSick      = "animal_sick"
+)
+
+// alertConditions lists the conditions in the order they are shown, with the
+// days a new rule gets when none is given and the permission a member needs
+// to be told, since an alert shows the records it is about.
+var alertConditions = []struct {
+	name       string
+	label      string
+	days       int
+	permission string
+}{
+	{alertVaccinationDue, "Vaccination due", 7, "health.read"},
+	{alertBirthExpected, "Birth expected", 14, "breeding.read"},
+	{alertMilkDrop, "Milk yield drop", 3, "production.read"},
+	{alertAnimalAttention, "Animal needs attention", 3, "animals.read"},
+	{alertAnimalSick, "Animal sick", 0, "health.read"},
+}
+
+func alertConditionPermission(condition string) string {
+	for _, c := range alertConditions {
+		if c.name == condition {
+			return c.permission
+		}
+	}
+	return ""
+}
+
+func alertConditionLabel(condition string) (string, bool) {
+	for _, c := range alertConditions {
+		if c.name == condition {
+			return c.label, true
+		}
+	}
+	return "", false
+}
+
+type alertRule struct {
+	id        int64
+	farmID    int64
+	name      string
+	condition string
+	days      int
+	percent   float64
+	isActive  bool
+}
+
+const alertRuleColumns = `id, farm_id, name, condition, days, percent::float8, is_active`
+
+func scanAlertRule(row pgx.Row) (alertRule, error) {
+	var rule alertRule
+	err := row.Scan(&rule.id, &rule.farmID, &rule.name, &rule.condition, &rule.days, &rule.percent, &rule.isActive)
+	return rule, err
+}
+
+func alertRuleJSON(rule alertRule) map[string]any {
+	return map[string]any{
+		"id":        rule.id,
+		"name":      rule.name,
+		"condition": rule.condition,
+		"days":      rule.days,
+		"percent":   rule.percent,
+		"isActive":  rule.isActive,
+	}
+}
+
+// seedDefaultAlertRules gives a new farm the same rules existing farms got
+// when alerts were introduced.
+func seedDefaultAlertRules(ctx context.Context, tx pgx.Tx, farmID int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO alert_rules(farm_id, name, condition, days, percent)
+		SELECT $1, v.name, v.condition, v.days, v.percent
+		FROM (VALUES
+			('Vaccinations due this week', 'vaccination_due', 7, 0),
+			('Births expected in two weeks', 'birth_expected', 14, 0),
+			('Milk yield down 20%', 'milk_drop', 3, 20),
+			('Needs attention for 3 days', 'animal_attention', 3, 0),
+			('Sick animals', 'animal_sick', 0, 0)
+		) AS v(name, condition, days, percent)
+	`, farmID)
+	return err
+}
+
+type alertRuleInput struct {
+	Name      string  `json:"name"`
+	Condition string  `json:"condition"`
+	Days      *int    `json:"days"`
+	Percent   float64 `json:"percent"`
+	IsActive  *bool   `json:"isActive"`
+}
+
+func normalizeAlertRuleInput(in alertRuleInput) (alertRule, string) {
+	rule := alertRule{
+		name:      strings.Join(strings.Fields(in.Name), " "),
+		condition: strings.ToLower(strings.TrimSpace(in.Condition)),
+		isActive:  true,
+	}
+	label, ok := alertConditionLabel(rule.condition)
+	if !ok {
+		return rule, "condition must be one of vaccination_due, birth_expected, milk_drop, animal_attention, animal_sick"
+	}
+	for _, c := range alertConditions {
+		if c.name == rule.condition {
+			rule.days = c.days
+		}
+	}
+	if in.Days != nil {
+		rule.days = *in.Days
+	}
+	if rule.days < 0 || rule.days > 365 {
+		return rule, "days must be between 0 and 365"
+	}
+	if rule.condition == alertMilkDrop {
+		if rule.days < 1 {
+			return rule, "milk_drop needs a window of at least 1 day"
+		}
+		if in.Percent <= 0 || in.Percent > 100 {
+			return rule, "percent must be above 0 and at most 100"
+		}
+		rule.percent = in.Percent
+	}
+	if in.IsActive != nil {
+		rule.isActive = *in.IsActive
+	}
+	if rule.name == "" {
+		rule.name = label
+	}
+	if len(rule.name) > 120 {
+		return rule, "name is too long"
+	}
+	return rule, ""
+}
+
+func (s *Server) handleAlertRules(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE farm_id = $1 ORDER BY condition, name, id`, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load alert rules"})
+		return
+	}
+	defer rows.Close()
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse alert rules"})
+			return
+		}
+		out = append(out, alertRuleJSON(rule))
+	}
+	conditions := make([]map[string]any, 0, len(alertConditions))
+	for _, c := range alertConditions {
+		conditions = append(conditions, map[string]any{"condition": c.name, "label": c.label, "defaultDays": c.days})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": out, "conditions": conditions})
+}
+
+func (s *Server) handleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var in alertRuleInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	rule, msg := normalizeAlertRuleInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "alert_rules", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO alert_rules(farm_id, name, condition, days, percent, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, farmID, rule.name, rule.condition, rule.days, rule.percent, rule.isActive).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create alert rule"})
+		return
+	}
+	rule.id = id
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "rule": alertRuleJSON(rule)})
+}
+
+func (s *Server) handleUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid rule id"})
+		return
+	}
+	var in alertRuleInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	rule, msg := normalizeAlertRuleInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "alert_rules", ruleID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE alert_rules
+			SET name = $1, condition = $2, days = $3, percent = $4, is_active = $5
+			WHERE id = $6 AND farm_id = $7
+		`, rule.name, rule.condition, rule.days, rule.percent, rule.isActive, ruleID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return ruleID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update alert rule"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "alert rule not found"})
+		return
+	}
+	rule.id = ruleID
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "rule": alertRuleJSON(rule)})
+}
+
+func (s *Server) handleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid rule id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "alert_rules", ruleID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM alert_rules WHERE id = $1 AND farm_id = $2`, ruleID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return ruleID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete alert rule"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "alert rule not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// RunAlertRules checks every active alert rule each interval until ctx is
+// done, notifying farm members about anything new that matches.
+func (s *Server) RunAlertRules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.evaluateAlertRules(ctx)
+		}
+	}
+}
+
+func (s *Server) evaluateAlertRules(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE is_active ORDER BY farm_id, id`)
+	if err != nil {
+		log.Printf("alert rules: load rules: %v", err)
+		return
+	}
+	var rules []alertRule
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			rows.Close()
+			log.Printf("alert rules: parse rules: %v", err)
+			return
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+
+	for _, rule := range rules {
+		matches, err := s.matchAlertRule(ctx, rule)
+		if err != nil {
+			log.Printf("alert rules: rule %d: %v", rule.id, err)
+			continue
+		}
+		for _, m := range matches {
+			if err := s.fireAlert(ctx, rule, m); err != nil {
+				log.Printf("alert rules: rule %d %s: %v", rule.id, m.key, err)
+			}
+		}
+	}
+}
+
+// fireAlert claims m for rule and stores the notifications in one
+// transaction, so an alert that could not be stored is claimed again on the
+// next run. Email and text messages go out once both are committed.
+func (s *Server) fireAlert(ctx context.Context, rule alertRule, m alertMatch) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	fresh, err := claimAlert(ctx, tx, rule, m)
+	if err != nil || !fresh {
+		return err
+	}
+	n := farmNotice{
+		ruleID:     rule.id,
+		kind:       rule.condition,
+		permission: alertConditionPermission(rule.condition),
+		title:      m.title,
+		body:       m.body,
+		entity:     m.entity,
+		entityID:   m.entityID,
+		data:       m.data,
+	}
+	recipients, err := notifyFarm(ctx, tx, rule.farmID, n)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.deliverNotice(ctx, rule.farmID, n, recipients)
+	return nil
+}
+
+type alertMatch struct {
+	key      string
+	entity   string
+	entityID int64
+	title    string
+	body     string
+	// cooldown is how long before the same key may alert again. Zero means
+	// never; keys that include a date only match once anyway.
+	cooldown time.Duration
+	data     map[string]string
+}
+
+// matchAlertRule lists what currently satisfies rule on its farm.
+func (s *Server) matchAlertRule(ctx context.Context, rule alertRule) ([]alertMatch, error) {
+	query, args, err := alertRuleQuery(rule, s.formatISODate(s.now()))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []alertMatch
+	for rows.Next() {
+		var row alertRow
+		if err := rows.Scan(&row.id, &row.tag, &row.detail, &row.at, &row.value); err != nil {
+			return nil, err
+		}
+		out = append(out, s.alertMatchFor(rule, row))
+	}
+	return out, rows.Err()
+}
+
+// alertRow is one record a rule's query found: the record's id, the animal's
+// tag, a condition-specific detail, the date it is about and, for milk
+// drops, the drop in percent.
+type alertRow struct {
+	id     int64
+	tag    string
+	detail string
+	at     time.Time
+	value  float64
+}
+
+// alertRuleQuery returns the query listing the records that satisfy rule on
+// the given day, and its arguments. Every query yields alertRow's columns.
+func alertRuleQuery(rule alertRule, today string) (string, []any, error) {
+	var query string
+	var args []any
+	switch rule.condition {
+	case alertVaccinationDue:
+		query = `
+			SELECT t.id, a.tag_id, t.treatment, t.due_date, 0::float8
+			FROM health_tasks t
+			JOIN animals a ON a.id = t.animal_id
+			WHERE t.farm_id = $1 AND t.status = 'scheduled' AND a.is_active
+				AND t.due_date BETWEEN $2::date AND $2::date + $3::int
+		`
+		args = []any{rule.farmID, today, rule.days}
+	case alertBirthExpected:
+		query = `
+			SELECT b.id, a.tag_id, b.species, b.expected_birth_date, 0::float8
+			FROM breeding_records b
+			JOIN animals a ON a.id = b.mother_animal_id
+			WHERE b.farm_id = $1 AND b.status = 'active' AND a.status = 'active'
+				AND b.expected_birth_date BETWEEN $2::date AND $2::date + $3::int
+		`
+		args = []any{rule.farmID, today, rule.days}
+	case alertMilkDrop:
+		// Average daily milk over the last days days against the days days
+		// before that. Today is left out because its sessions may not all be
+		// recorded yet, and days without records do not count as zero.
+		query = `
+			WITH daily AS (
+				SELECT animal_id, record_date, SUM(quantity) AS liters
+				FROM production_records
+				WHERE farm_id = $1 AND kind = 'milk' AND animal_id IS NOT NULL
+					AND record_date >= $2::date - 2 * $3::int AND record_date < $2::date
+				GROUP BY animal_id, record_date
+			), windows AS (
+				SELECT animal_id,
+					AVG(liters) FILTER (WHERE record_date >= $2::date - $3::int) AS recent,
+					AVG(liters) FILTER (WHERE record_date < $2::date - $3::int) AS earlier
+				FROM daily
+				GROUP BY animal_id
+			)
+			SELECT a.id, a.tag_id, '', $2::date, ((w.earlier - w.recent) / w.earlier * 100)::float8
+			FROM windows w
+			JOIN animals a ON a.id = w.animal_id
+			WHERE a.status = 'active' AND w.earlier > 0 AND w.recent IS NOT NULL
+				AND (w.earlier - w.recent) / w.earlier * 100 >= $4
+		`
+		args = []any{rule.farmID, today, rule.days, rule.percent}
+	case alertAnimalAttention, alertAnimalSick:
+		status := "attention"
+		if rule.condition == alertAnimalSick {
+			status = "sick"
+		}
+		query = `
+			SELECT a.id, a.tag_id, a.health_status, a.health_status_since, 0::float8
+			FROM animals a
+			WHERE a.farm_id = $1 AND a.status = 'active' AND a.health_status = $2
+				AND a.health_status_since <= NOW() - make_interval(days => $3)
+		`
+		args = []any{rule.farmID, status, rule.days}
+	default:
+		return "", nil, fmt.Errorf("unknown condition %q", rule.condition)
+	}
+	return query, args, nil
+}
+
+// alertMatchFor turns a row rule's query found into the alert it raises. The
+// key names what the alert is about, so the same thing alerts once.
+func (s *Server) alertMatchFor(rule alertRule, row alertRow) alertMatch {
+	var m alertMatch
+	switch rule.condition {
+	case alertVaccinationDue:
+		detail := row.detail
+		if detail == "" {
+			detail = "Treatment"
+		}
+		m = alertMatch{
+			key:    fmt.Sprintf("health_tasks:%d:%s", row.id, row.at.Format("2006-01-02")),
+			entity: "health_tasks",
+			title:  "Vaccination due: " + row.tag,
+			body:   fmt.Sprintf("%s for %s is due on %s.", detail, row.tag, s.formatDateLong(row.at)),
+			data:   map[string]string{"tag": row.tag, "treatment": detail, "date": s.formatDateCompact(row.at)},
+		}
+	case alertBirthExpected:
+		m = alertMatch{
+			key:    fmt.Sprintf("breeding_records:%d:%s", row.id, row.at.Format("2006-01-02")),
+			entity: "breeding_records",
+			title:  "Birth expected: " + row.tag,
+			body:   fmt.Sprintf("%s is expected to give birth on %s.", row.tag, s.formatDateLong(row.at)),
+			data:   map[string]string{"tag": row.tag, "date": s.formatDateCompact(row.at)},
+		}
+	case alertMilkDrop:
+		m = alertMatch{
+			key:      fmt.Sprintf("animals:%d:milk", row.id),
+			entity:   "animals",
+			title:    "Milk yield down: " + row.tag,
+			body:     fmt.Sprintf("%s averaged %.0f%% less milk a day over the last %d days than the %d days before.", row.tag, row.value, rule.days, rule.days),
+			cooldown: time.Duration(2*rule.days) * 24 * time.Hour,
+		}
+	default:
+		m = alertMatch{
+			key:    fmt.Sprintf("animals:%d:%s:%d", row.id, row.detail, row.at.Unix()),
+			entity: "animals",
+			title:  fmt.Sprintf("%s is %s", row.tag, row.detail),
+			body:   fmt.Sprintf("%s has been marked %s since %s.", row.tag, row.detail, s.formatDateLong(row.at)),
+		}
+	}
+	m.entityID = row.id
+	return m
+}
+
+// claimAlert records that rule fired for m and reports whether it had not
+// already. The insert is atomic, so two servers checking at once notify once.
+func claimAlert(ctx context.Context, tx pgx.Tx, rule alertRule, m alertMatch) (bool, error) {
+	now := time.Now().UTC()
+	again := alertRefireBefore(now, m.cooldown)
+	var claimed bool
+	err := tx.QueryRow(ctx, `
+		INSERT INTO alert_firings(rule_id, key, fired_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (rule_id, key) DO UPDATE SET fired_at = EXCLUDED.fired_at
+		WHERE alert_firings.fired_at < $4
+		RETURNING true
+	`, rule.id, m.key, now, again).Scan(&claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return claimed, err
+}
+
+// alertRefireBefore is the time a key must have last fired before to fire
+// again. Without a cooldown it is the zero time, which nothing fired before.
+func alertRefireBefore(now time.Time, cooldown time.Duration) time.Time {
+	if cooldown <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-cooldown)
+}
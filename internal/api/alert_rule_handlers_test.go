@@ -0,0 +1,162 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertRuleQuery(t *testing.T) {
+	tests := []struct {
+		rule alertRule
+		args []any
+	}{
+		{alertRule{farmID: 4, condition: alertVaccinationDue, days: 7}, []any{int64(4), "2026-03-10", 7}},
+		{alertRule{farmID: 4, condition: alertBirthExpected, days: 14}, []any{int64(4), "2026-03-10", 14}},
+		{alertRule{farmID: 4, condition: alertMilkDrop, days: 3, percent: 20}, []any{int64(4), "2026-03-10", 3, 20.0}},
+		{alertRule{farmID: 4, condition: alertAnimalAttention, days: 3}, []any{int64(4), "attention", 3}},
+		{alertRule{farmID: 4, condition: alertAnimalSick}, []any{int64(4), "sick", 0}},
+	}
+	for _, tt := range tests {
+		query, args, err := alertRuleQuery(tt.rule, "2026-03-10")
+		if err != nil {
+			t.Errorf("%s: %v", tt.rule.condition, err)
+			continue
+		}
+		if len(args) != len(tt.args) {
+			t.Errorf("%s: args = %v, want %v", tt.rule.condition, args, tt.args)
+			continue
+		}
+		for i := range args {
+			if args[i] != tt.args[i] {
+				t.Errorf("%s: arg %d = %#v, want %#v", tt.rule.condition, i+1, args[i], tt.args[i])
+			}
+		}
+		// Animals that left the herd never alert.
+		if !strings.Contains(query, "a.is_active") && !strings.Contains(query, "a.status = 'active'") {
+			t.Errorf("%s: query does not skip inactive animals", tt.rule.condition)
+		}
+	}
+	if _, _, err := alertRuleQuery(alertRule{condition: "unknown"}, "2026-03-10"); err == nil {
+		t.Error("unknown condition was accepted")
+	}
+}
+
+func TestAlertMatchFor(t *testing.T) {
+	s := &Server{}
+	at := time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rule     alertRule
+		row      alertRow
+		key      string
+		title    string
+		body     string
+		cooldown time.Duration
+	}{
+		{
+			rule:  alertRule{condition: alertVaccinationDue},
+			row:   alertRow{id: 9, tag: "COW-1", at: at},
+			key:   "health_tasks:9:2026-03-12",
+			title: "Vaccination due: COW-1",
+			body:  "Treatment for COW-1 is due on 12 March 2026.",
+		},
+		{
+			rule:  alertRule{condition: alertBirthExpected},
+			row:   alertRow{id: 3, tag: "GOAT-2", at: at},
+			key:   "breeding_records:3:2026-03-12",
+			title: "Birth expected: GOAT-2",
+			body:  "GOAT-2 is expected to give birth on 12 March 2026.",
+		},
+		{
+			rule:     alertRule{condition: alertMilkDrop, days: 3},
+			row:      alertRow{id: 5, tag: "COW-5", at: at, value: 24.6},
+			key:      "animals:5:milk",
+			title:    "Milk yield down: COW-5",
+			body:     "COW-5 averaged 25% less milk a day over the last 3 days than the 3 days before.",
+			cooldown: 6 * 24 * time.Hour,
+		},
+		{
+			rule:  alertRule{condition: alertAnimalSick},
+			row:   alertRow{id: 7, tag: "COW-7", detail: "sick", at: at},
+			key:   "animals:7:sick:1773273600",
+			title: "COW-7 is sick",
+			body:  "COW-7 has been marked sick since 12 March 2026.",
+		},
+	}
+	for _, tt := range tests {
+		m := s.alertMatchFor(tt.rule, tt.row)
+		if m.key != tt.key || m.title != tt.title || m.body != tt.body || m.cooldown != tt.cooldown {
+			t.Errorf("%s: got %q %q %q %v, want %q %q %q %v", tt.rule.condition, m.key, m.title, m.body, m.cooldown, tt.key, tt.title, tt.body, tt.cooldown)
+		}
+		if m.entityID != tt.row.id {
+			t.Errorf("%s: entityID = %d, want %d", tt.rule.condition, m.entityID, tt.row.id)
+		}
+	}
+}
+
+// claimAlert lets a key fire again only once it last fired before the cutoff.
+func TestAlertRefireBefore(t *testing.T) {
+	now := time.Date(2026, 3, 12, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		cooldown  time.Duration
+		lastFired time.Time
+		again     bool
+	}{
+		{"no cooldown fires once", 0, now.AddDate(-1, 0, 0), false},
+		{"within cooldown", 6 * 24 * time.Hour, now.Add(-time.Hour), false},
+		{"cooldown over", 6 * 24 * time.Hour, now.AddDate(0, 0, -7), true},
+	}
+	for _, tt := range tests {
+		if got := tt.lastFired.Before(alertRefireBefore(now, tt.cooldown)); got != tt.again {
+			t.Errorf("%s: fires again = %v, want %v", tt.name, got, tt.again)
+		}
+	}
+}
+
+func TestNoticeRecipients(t *testing.T) {
+	member := func(id int64, permitted, inApp, byMail bool) noticeMember {
+		return noticeMember{noticeRecipient: noticeRecipient{userID: id, inApp: inApp, byMail: byMail}, permitted: permitted}
+	}
+	members := []noticeMember{
+		member(1, true, true, false),
+		member(2, false, true, true),
+		member(3, true, false, false),
+		member(4, true, false, true),
+	}
+	tests := []struct {
+		permission string
+		want       []int64
+	}{
+		{"health.read", []int64{1, 4}},
+		{"", []int64{1, 2, 4}},
+	}
+	for _, tt := range tests {
+		got := noticeRecipients(farmNotice{permission: tt.permission}, members)
+		ids := make([]int64, len(got))
+		for i, rc := range got {
+			ids[i] = rc.userID
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("permission %q: recipients %v, want %v", tt.permission, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("permission %q: recipients %v, want %v", tt.permission, ids, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAlertConditionPermission(t *testing.T) {
+	for _, c := range alertConditions {
+		if alertConditionPermission(c.name) == "" {
+			t.Errorf("%s has no permission", c.name)
+		}
+	}
+	if p := alertConditionPermission("unknown"); p != "" {
+		t.Errorf("unknown condition needs %q", p)
+	}
+}
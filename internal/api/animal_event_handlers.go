@@ -0,0 +1,875 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	animalEventPurchase = "purchase"
+	animalEventBirth    = "birth"
+	animalEventTransfer = "transfer"
+	animalEventSale     = "sale"
+	animalEventDeath    = "death"
+	animalEventCull     = "cull"
+)
+
+// animalEventReasons are the reason codes each event type accepts. For a
+// death the reason is the cause.
+var animalEventReasons = map[string][]string{
+	animalEventPurchase: {"herd_expansion", "replacement", "breeding_stock", "opening_stock", "other"},
+	animalEventBirth:    {},
+	animalEventTransfer: {"management", "grazing", "weaning", "isolation", "treatment", "other"},
+	animalEventSale:     {"breeding_stock", "slaughter", "surplus", "other"},
+	animalEventDeath:    {"disease", "injury", "predation", "birth_complications", "poisoning", "old_age", "unknown", "other"},
+	animalEventCull:     {"low_production", "infertility", "age", "disease", "injury", "temperament", "conformation", "other"},
+}
+
+// exitStatuses is the animal status each exit event leaves behind.
+var exitStatuses = map[string]string{
+	animalEventSale:  "sold",
+	animalEventDeath: "dead",
+	animalEventCull:  "culled",
+}
+
+func isEntryEvent(eventType string) bool {
+	return eventType == animalEventPurchase || eventType == animalEventBirth
+}
+
+func isExitEvent(eventType string) bool {
+	_, ok := exitStatuses[eventType]
+	return ok
+}
+
+func normalizeEventReason(eventType, raw string) (string, bool) {
+	reason := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(raw)), " ", "_")
+	if reason == "" {
+		return "", true
+	}
+	for _, r := range animalEventReasons[eventType] {
+		if r == reason {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+type animalEvent struct {
+	animalID         int64
+	eventType        string
+	date             time.Time
+	reason           string
+	notes            string
+	amount           float64
+	fromLocationID   *int64
+	toLocationID     *int64
+	invoiceID        *int64
+	expenseID        *int64
+	breedingRecordID *int64
+}
+
+// insertAnimalEvent books ev inside tx. Callers record the audit entry.
+func insertAnimalEvent(ctx context.Context, tx pgx.Tx, r *http.Request, farmID int64, ev animalEvent) (int64, error) {
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+	var createdBy *int64
+	if userID > 0 {
+		createdBy = &userID
+	}
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO animal_events(farm_id, animal_id, event_type, event_date, reason, notes, amount,
+			from_location_id, to_location_id, invoice_id, expense_id, breeding_record_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`, farmID, ev.animalID, ev.eventType, ev.date, ev.reason, ev.notes, ev.amount,
+		ev.fromLocationID, ev.toLocationID, ev.invoiceID, ev.expenseID, ev.breedingRecordID, createdBy).Scan(&id)
+	return id, err
+}
+
+// herdStay is when one animal entered the herd and, if it has, when it left.
+type herdStay struct {
+	animalType string
+	entered    time.Time
+	left       *time.Time
+}
+
+// herdStays loads the stays of every animal that had entered by the end of
+// day. Records deleted as mistakes never count.
+func (s *Server) herdStays(ctx context.Context, farmID int64, day time.Time) ([]herdStay, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT a.type, en.event_date, ex.event_date
+		FROM animals a
+		JOIN animal_events en ON en.animal_id = a.id AND en.event_type IN ('purchase', 'birth')
+		LEFT JOIN animal_events ex ON ex.animal_id = a.id AND ex.event_type IN ('sale', 'death', 'cull')
+		WHERE a.farm_id = $1 AND a.status <> 'inactive' AND en.event_date <= $2
+	`, farmID, day.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stays := make([]herdStay, 0)
+	for rows.Next() {
+		var st herdStay
+		if err := rows.Scan(&st.animalType, &st.entered, &st.left); err != nil {
+			return nil, err
+		}
+		stays = append(stays, st)
+	}
+	return stays, rows.Err()
+}
+
+// herdOn is the herd by animal type at the end of day: animals that had
+// entered by then and not yet left.
+func herdOn(stays []herdStay, day time.Time) (int64, []map[string]any) {
+	day = calendarDate(day)
+	byType := map[string]int64{}
+	var total int64
+	for _, st := range stays {
+		if calendarDate(st.entered).After(day) || (st.left != nil && !calendarDate(*st.left).After(day)) {
+			continue
+		}
+		byType[st.animalType]++
+		total++
+	}
+	types := make([]string, 0, len(byType))
+	for typ := range byType {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	out := make([]map[string]any, 0, len(types))
+	for _, typ := range types {
+		out = append(out, map[string]any{"type": typ, "count": byType[typ]})
+	}
+	return total, out
+}
+
+// calendarDate drops the time of day, so a date compares the same whatever
+// zone it was read in.
+func calendarDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// herdCounts is the herd by animal type at the end of day.
+func (s *Server) herdCounts(ctx context.Context, farmID int64, day time.Time) (int64, []map[string]any, error) {
+	stays, err := s.herdStays(ctx, farmID, day)
+	if err != nil {
+		return 0, nil, err
+	}
+	total, byType := herdOn(stays, day)
+	return total, byType, nil
+}
+
+const animalEventColumns = `
+	e.id, a.tag_id, a.type, e.event_type, e.event_date, e.reason, e.notes, e.amount,
+	COALESCE(fl.name, ''), COALESCE(tl.name, ''), COALESCE(e.invoice_id, 0), COALESCE(i.invoice_number, ''),
+	COALESCE(e.expense_id, 0), COALESCE(e.breeding_record_id, 0), COALESCE(u.name, '')`
+
+const animalEventJoins = `
+	FROM animal_events e
+	JOIN animals a ON a.id = e.animal_id
+	LEFT JOIN animal_locations fl ON fl.id = e.from_location_id
+	LEFT JOIN animal_locations tl ON tl.id = e.to_location_id
+	LEFT JOIN invoices i ON i.id = e.invoice_id
+	LEFT JOIN users u ON u.id = e.created_by`
+
+func (s *Server) scanAnimalEvents(rows pgx.Rows) ([]map[string]any, error) {
+	defer rows.Close()
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, invoiceID, expenseID, breedingRecordID int64
+		var tag, typ, eventType, reason, notes, from, to, invoiceNumber, createdBy string
+		var date time.Time
+		var amount float64
+		if err := rows.Scan(&id, &tag, &typ, &eventType, &date, &reason, &notes, &amount,
+			&from, &to, &invoiceID, &invoiceNumber, &expenseID, &breedingRecordID, &createdBy); err != nil {
+			return nil, err
+		}
+		out = append(out, map[string]any{
+			"id":               id,
+			"tagId":            tag,
+			"animalType":       typ,
+			"type":             eventType,
+			"date":             s.formatISODate(date),
+			"reason":           reason,
+			"notes":            notes,
+			"amount":           amount,
+			"fromLocation":     from,
+			"toLocation":       to,
+			"invoiceId":        invoiceID,
+			"invoiceNumber":    invoiceNumber,
+			"expenseId":        expenseID,
+			"breedingRecordId": breedingRecordID,
+			"createdBy":        createdBy,
+		})
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleAnimalEventReasons(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]any{"reasons": animalEventReasons})
+}
+
+func (s *Server) handleAnimalEvents(w http.ResponseWriter, r *http.Request) {
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var animalID int64
+	var status, location string
+	if err := s.db.QueryRow(ctx, `
+		SELECT a.id, a.status, COALESCE(l.name, '')
+		FROM animals a
+		LEFT JOIN animal_locations l ON l.id = a.location_id
+		WHERE a.tag_id = $1 AND a.farm_id = $2
+	`, tagID, farmID).Scan(&animalID, &status, &location); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
+		return
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+animalEventColumns+animalEventJoins+`
+		WHERE e.animal_id = $1 AND e.farm_id = $2
+		ORDER BY e.event_date, e.id
+	`, animalID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load events"})
+		return
+	}
+	items, err := s.scanAnimalEvents(rows)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse events"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"tagId":    tagID,
+		"status":   status,
+		"location": location,
+		"items":    items,
+	})
+}
+
+// handleHerdEvents is the farm's lifecycle ledger, newest first.
+func (s *Server) handleHerdEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	eventType := strings.ToLower(strings.TrimSpace(q.Get("type")))
+	if _, ok := animalEventReasons[eventType]; eventType != "" && !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "type must be purchase, birth, transfer, sale, death or cull"})
+		return
+	}
+	from, err := optionalDate(q.Get("from"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+		return
+	}
+	to, err := optionalDate(q.Get("to"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	search := parseSearch(r)
+	offset := (page - 1) * pageSize
+
+	const filter = `
+		WHERE e.farm_id = $1
+			AND ($2 = '' OR e.event_type = $2)
+			AND ($3::date IS NULL OR e.event_date >= $3)
+			AND ($4::date IS NULL OR e.event_date <= $4)
+			AND ($5 = '' OR a.tag_id ILIKE '%' || $5 || '%' OR a.type ILIKE '%' || $5 || '%' OR e.reason ILIKE '%' || $5 || '%')`
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*)`+animalEventJoins+filter, farmID, eventType, from, to, search).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `SELECT `+animalEventColumns+animalEventJoins+filter+`
+		ORDER BY e.event_date DESC, e.id DESC
+		LIMIT $6 OFFSET $7
+	`, farmID, eventType, from, to, search, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load events"})
+		return
+	}
+	items, err := s.scanAnimalEvents(rows)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse events"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    items,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// handleCreateAnimalEvent records a transfer or an exit. Entries are booked
+// when the animal is added or born.
+func (s *Server) handleCreateAnimalEvent(w http.ResponseWriter, r *http.Request) {
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+	var in struct {
+		Type         string  `json:"type"`
+		Date         string  `json:"date"`
+		Reason       string  `json:"reason"`
+		Notes        string  `json:"notes"`
+		Amount       float64 `json:"amount"`
+		ToLocationID *int64  `json:"toLocationId"`
+		InvoiceID    *int64  `json:"invoiceId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Type = strings.ToLower(strings.TrimSpace(in.Type))
+	in.Notes = strings.TrimSpace(in.Notes)
+	if isEntryEvent(in.Type) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "purchases and births are recorded when the animal is added"})
+		return
+	}
+	if in.Type != animalEventTransfer && !isExitEvent(in.Type) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "type must be transfer, sale, death or cull"})
+		return
+	}
+	reason, ok := normalizeEventReason(in.Type, in.Reason)
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("reason for a %s must be one of %s", in.Type, strings.Join(animalEventReasons[in.Type], ", "))})
+		return
+	}
+	if reason == "" && (in.Type == animalEventDeath || in.Type == animalEventCull) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("a %s needs a reason", in.Type)})
+		return
+	}
+	if in.Amount < 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "amount cannot be negative"})
+		return
+	}
+	if in.Type == animalEventTransfer && in.ToLocationID == nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "toLocationId is required for a transfer"})
+		return
+	}
+	if in.Type == animalEventSale && in.InvoiceID == nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invoiceId is required for a sale"})
+		return
+	}
+	d := s.now()
+	if in.Date != "" {
+		parsed, err := time.Parse("2006-01-02", in.Date)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		d = parsed
+	}
+	if d.After(time.Now()) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date cannot be in the future"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	warnings := make([]string, 0)
+	id, err := s.auditedWrite(ctx, r, auditCreate, "animal_events", 0, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		var status string
+		var locationID *int64
+		err := tx.QueryRow(ctx, `
+			SELECT id, status, location_id FROM animals WHERE tag_id = $1 AND farm_id = $2 FOR UPDATE
+		`, tagID, farmID).Scan(&animalID, &status, &locationID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusNotFound, "animal not found"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if status == "inactive" {
+			return 0, &httpError{http.StatusConflict, "this animal was deleted"}
+		}
+		var entered *time.Time
+		var exited string
+		var lastTransfer *time.Time
+		err = tx.QueryRow(ctx, `
+			SELECT
+				(SELECT MIN(event_date) FROM animal_events WHERE animal_id = $1 AND event_type IN ('purchase', 'birth')),
+				COALESCE((SELECT event_type FROM animal_events WHERE animal_id = $1 AND event_type IN ('sale', 'death', 'cull')), ''),
+				(SELECT MAX(event_date) FROM animal_events WHERE animal_id = $1 AND event_type = 'transfer')
+		`, animalID).Scan(&entered, &exited, &lastTransfer)
+		if err != nil {
+			return 0, err
+		}
+		if exited != "" {
+			return 0, &httpError{http.StatusConflict, fmt.Sprintf("this animal already left the herd (%s)", exited)}
+		}
+		if entered != nil && d.Before(*entered) {
+			return 0, &httpError{http.StatusBadRequest, "date is before the animal entered the herd on " + s.formatISODate(*entered)}
+		}
+		if lastTransfer != nil && d.Before(*lastTransfer) {
+			return 0, &httpError{http.StatusBadRequest, "date is before the animal's last transfer on " + s.formatISODate(*lastTransfer)}
+		}
+
+		ev := animalEvent{animalID: animalID, eventType: in.Type, date: d, reason: reason, notes: in.Notes, amount: roundCents(in.Amount)}
+		switch in.Type {
+		case animalEventTransfer:
+			if locationID != nil && *locationID == *in.ToLocationID {
+				return 0, &httpError{http.StatusBadRequest, "the animal is already in that location"}
+			}
+			var exists bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM animal_locations WHERE id = $1 AND farm_id = $2)`, *in.ToLocationID, farmID).Scan(&exists); err != nil {
+				return 0, err
+			}
+			if !exists {
+				return 0, &httpError{http.StatusBadRequest, "location not found"}
+			}
+			ev.fromLocationID = locationID
+			ev.toLocationID = in.ToLocationID
+		case animalEventSale:
+			var kind string
+			err := tx.QueryRow(ctx, `SELECT kind FROM invoices WHERE id = $1 AND farm_id = $2`, *in.InvoiceID, farmID).Scan(&kind)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, &httpError{http.StatusBadRequest, "invoice not found"}
+			}
+			if err != nil {
+				return 0, err
+			}
+			if kind != invoiceKindInvoice {
+				return 0, &httpError{http.StatusBadRequest, "a sale must link to an invoice, not a credit note"}
+			}
+			ev.invoiceID = in.InvoiceID
+		}
+		if in.Type == animalEventSale || in.Type == animalEventCull {
+			// Selling for slaughter under a meat withdrawal would put drug
+			// residues in the food chain; any other buyer is told.
+			list, err := activeWithdrawals(ctx, tx, farmID, animalID, d)
+			if err != nil {
+				return 0, err
+			}
+			for _, a := range list {
+				if a.meatUntil != nil && reason == "slaughter" {
+					return 0, &httpError{http.StatusConflict, fmt.Sprintf("%s is under meat withdrawal until %s after %s and cannot go for slaughter before then", tagID, s.formatISODate(*a.meatUntil), a.treatments)}
+				}
+				if a.meatUntil != nil {
+					warnings = append(warnings, fmt.Sprintf("%s is under meat withdrawal until %s after %s; tell the buyer", tagID, s.formatDate(*a.meatUntil), a.treatments))
+				}
+				if a.milkUntil != nil {
+					warnings = append(warnings, fmt.Sprintf("%s is under milk withdrawal until %s after %s; tell the buyer", tagID, s.formatDate(*a.milkUntil), a.treatments))
+				}
+			}
+		}
+
+		before, err := auditSnapshot(ctx, tx, "animals", animalID)
+		if err != nil {
+			return 0, err
+		}
+		if in.Type == animalEventTransfer {
+			_, err = tx.Exec(ctx, `UPDATE animals SET location_id = $1 WHERE id = $2`, in.ToLocationID, animalID)
+		} else {
+			_, err = tx.Exec(ctx, `UPDATE animals SET status = $1, is_active = false WHERE id = $2`, exitStatuses[in.Type], animalID)
+			if err == nil {
+				err = cancelAnimalHealthTasks(ctx, tx, animalID)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := s.recordAudit(ctx, tx, r, auditUpdate, "animals", animalID, before); err != nil {
+			return 0, err
+		}
+		return insertAnimalEvent(ctx, tx, r, farmID, ev)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to record event")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "warnings": warnings})
+}
+
+// handleDeleteAnimalEvent undoes an exit or the latest transfer. Entries
+// stay for as long as the animal does.
+func (s *Server) handleDeleteAnimalEvent(w http.ResponseWriter, r *http.Request) {
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+	eventID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid event id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "animal_events", eventID, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		var eventType, status string
+		var fromLocationID *int64
+		err := tx.QueryRow(ctx, `
+			SELECT e.animal_id, e.event_type, e.from_location_id, a.status
+			FROM animal_events e
+			JOIN animals a ON a.id = e.animal_id
+			WHERE e.id = $1 AND e.farm_id = $2 AND a.tag_id = $3
+			FOR UPDATE OF a
+		`, eventID, farmID, tagID).Scan(&animalID, &eventType, &fromLocationID, &status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if status == "inactive" {
+			return 0, &httpError{http.StatusConflict, "this animal was deleted"}
+		}
+		if isEntryEvent(eventType) {
+			return 0, &httpError{http.StatusConflict, "an animal's entry into the herd cannot be removed; delete the animal instead"}
+		}
+		if eventType == animalEventTransfer {
+			var later bool
+			if err := tx.QueryRow(ctx, `
+				SELECT EXISTS (
+					SELECT 1 FROM animal_events o, animal_events e
+					WHERE e.id = $1 AND o.animal_id = e.animal_id AND o.event_type = 'transfer'
+						AND (o.event_date, o.id) > (e.event_date, e.id)
+				)
+			`, eventID).Scan(&later); err != nil {
+				return 0, err
+			}
+			if later {
+				return 0, &httpError{http.StatusConflict, "only the latest transfer can be removed"}
+			}
+		}
+
+		before, err := auditSnapshot(ctx, tx, "animals", animalID)
+		if err != nil {
+			return 0, err
+		}
+		if eventType == animalEventTransfer {
+			_, err = tx.Exec(ctx, `UPDATE animals SET location_id = $1 WHERE id = $2`, fromLocationID, animalID)
+		} else {
+			_, err = tx.Exec(ctx, `UPDATE animals SET status = 'active', is_active = true WHERE id = $1`, animalID)
+			if err == nil {
+				err = rescheduleAnimalHealthTasks(ctx, tx, farmID, animalID, s.today())
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := s.recordAudit(ctx, tx, r, auditUpdate, "animals", animalID, before); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM animal_events WHERE id = $1`, eventID); err != nil {
+			return 0, err
+		}
+		return eventID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete event")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "event not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleHerd is the herd by type on a date, today unless ?date is given.
+func (s *Server) handleHerd(w http.ResponseWriter, r *http.Request) {
+	day := s.now()
+	if raw := strings.TrimSpace(r.URL.Query().Get("date")); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		day = parsed
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	total, byType, err := s.herdCounts(ctx, farmID, day)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to count herd"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"date":   s.formatISODate(day),
+		"total":  total,
+		"byType": byType,
+	})
+}
+
+func (s *Server) handleAnimalLocations(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT l.id, l.name, l.kind, l.notes,
+			(SELECT COUNT(*) FROM animals a WHERE a.location_id = l.id AND a.is_active = true)
+		FROM animal_locations l
+		WHERE l.farm_id = $1
+		ORDER BY l.name
+	`, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load locations"})
+		return
+	}
+	defer rows.Close()
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, animals int64
+		var name, kind, notes string
+		if err := rows.Scan(&id, &name, &kind, &notes, &animals); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse locations"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":      id,
+			"name":    name,
+			"kind":    kind,
+			"notes":   notes,
+			"animals": animals,
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+type animalLocationInput struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Notes string `json:"notes"`
+}
+
+func normalizeAnimalLocationInput(in *animalLocationInput) string {
+	in.Name = strings.Join(strings.Fields(in.Name), " ")
+	in.Kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	in.Notes = strings.TrimSpace(in.Notes)
+	if in.Name == "" {
+		return "name is required"
+	}
+	if in.Kind == "" {
+		in.Kind = "pen"
+	}
+	if in.Kind != "pen" && in.Kind != "paddock" && in.Kind != "other" {
+		return "kind must be pen, paddock or other"
+	}
+	return ""
+}
+
+func (s *Server) handleCreateAnimalLocation(w http.ResponseWriter, r *http.Request) {
+	var in animalLocationInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	if msg := normalizeAnimalLocationInput(&in); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "animal_locations", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO animal_locations(farm_id, name, name_key, kind, notes)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, farmID, in.Name, strings.ToLower(in.Name), in.Kind, in.Notes).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a location with this name already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create location"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+func (s *Server) handleUpdateAnimalLocation(w http.ResponseWriter, r *http.Request) {
+	locationID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid location id"})
+		return
+	}
+	var in animalLocationInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	if msg := normalizeAnimalLocationInput(&in); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "animal_locations", locationID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE animal_locations SET name = $1, name_key = $2, kind = $3, notes = $4
+			WHERE id = $5 AND farm_id = $6
+		`, in.Name, strings.ToLower(in.Name), in.Kind, in.Notes, locationID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return locationID, nil
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a location with this name already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update location"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "location not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeleteAnimalLocation(w http.ResponseWriter, r *http.Request) {
+	locationID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid location id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "animal_locations", locationID, func(tx pgx.Tx) (int64, error) {
+		// Moving an animal in takes a key share lock on the location, so
+		// once the row is locked nobody can arrive before it is gone.
+		var id int64
+		err := tx.QueryRow(ctx, `SELECT id FROM animal_locations WHERE id = $1 AND farm_id = $2 FOR UPDATE`, locationID, farmID).Scan(&id)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		var occupied bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM animals WHERE location_id = $1)`, locationID).Scan(&occupied); err != nil {
+			return 0, err
+		}
+		if occupied {
+			return 0, &httpError{http.StatusConflict, "move the animals out of this location before deleting it"}
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM animal_locations WHERE id = $1`, locationID); err != nil {
+			return 0, err
+		}
+		return locationID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete location")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "location not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// herdMovement is what the herd report and insights share: the herd at both
+// ends of a window, the events in between and the rates they give.
+type herdMovement struct {
+	opening  int64
+	closing  int64
+	counts   map[string]int64
+	byReason map[string]map[string]int64
+}
+
+func (m herdMovement) averageHerd() float64 {
+	return float64(m.opening+m.closing) / 2
+}
+
+// rate is the events of one type per hundred head of average herd.
+func (m herdMovement) rate(eventType string) float64 {
+	avg := m.averageHerd()
+	if avg <= 0 {
+		return 0
+	}
+	return roundCents(float64(m.counts[eventType]) * 100 / avg)
+}
+
+// topReason is the most common reason among events of one type.
+func (m herdMovement) topReason(eventType string) string {
+	reasons := make([]string, 0, len(m.byReason[eventType]))
+	for reason := range m.byReason[eventType] {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		ci, cj := m.byReason[eventType][reasons[i]], m.byReason[eventType][reasons[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return reasons[i] < reasons[j]
+	})
+	if len(reasons) == 0 {
+		return ""
+	}
+	return reasons[0]
+}
+
+func (s *Server) herdMovementBetween(ctx context.Context, farmID int64, from, to time.Time) (herdMovement, error) {
+	m := herdMovement{counts: map[string]int64{}, byReason: map[string]map[string]int64{}}
+	stays, err := s.herdStays(ctx, farmID, to)
+	if err != nil {
+		return m, err
+	}
+	m.opening, _ = herdOn(stays, from.AddDate(0, 0, -1))
+	m.closing, _ = herdOn(stays, to)
+	rows, err := s.db.Query(ctx, `
+		SELECT e.event_type, e.reason, COUNT(*)
+		FROM animal_events e
+		JOIN animals a ON a.id = e.animal_id
+		WHERE e.farm_id = $1 AND a.status <> 'inactive' AND e.event_date BETWEEN $2 AND $3
+		GROUP BY e.event_type, e.reason
+	`, farmID, from, to)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventType, reason string
+		var count int64
+		if err := rows.Scan(&eventType, &reason, &count); err != nil {
+			return m, err
+		}
+		m.counts[eventType] += count
+		if reason != "" {
+			if m.byReason[eventType] == nil {
+				m.byReason[eventType] = map[string]int64{}
+			}
+			m.byReason[eventType][reason] += count
+		}
+	}
+	return m, rows.Err()
+}
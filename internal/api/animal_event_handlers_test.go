@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHerdOn(t *testing.T) {
+	left := func(s string) *time.Time {
+		d := testDate(s)
+		return &d
+	}
+	stays := []herdStay{
+		{animalType: "Cattle", entered: testDate("2025-06-01")},
+		{animalType: "Cattle", entered: testDate("2026-02-10"), left: left("2026-03-05")},
+		{animalType: "Goat", entered: testDate("2026-01-15")},
+		{animalType: "Goat", entered: testDate("2026-03-01")},
+		{animalType: "Cattle", entered: testDate("2025-01-01"), left: left("2025-12-31")},
+	}
+	nairobi := time.FixedZone("EAT", 3*60*60)
+	tests := []struct {
+		name  string
+		day   time.Time
+		total int64
+		types string
+	}{
+		{"before anything entered", testDate("2024-12-31"), 0, "[]"},
+		{"a sale leaves at the end of its day", testDate("2025-12-31"), 1, "[Cattle:1]"},
+		{"an animal counts from the day it entered", testDate("2026-03-01"), 4, "[Cattle:2 Goat:2]"},
+		{"the day before it entered", testDate("2026-02-28"), 3, "[Cattle:2 Goat:1]"},
+		{"after a death", testDate("2026-03-05"), 3, "[Cattle:1 Goat:2]"},
+		// Late evening in Nairobi is still the same farm day.
+		{"time of day is ignored", time.Date(2026, 3, 4, 23, 30, 0, 0, nairobi), 4, "[Cattle:2 Goat:2]"},
+	}
+	for _, tt := range tests {
+		total, byType := herdOn(stays, tt.day)
+		types := make([]string, 0, len(byType))
+		for _, row := range byType {
+			types = append(types, fmt.Sprintf("%s:%d", row["type"], row["count"]))
+		}
+		if total != tt.total || fmt.Sprint(types) != tt.types {
+			t.Errorf("%s: got %d %v, want %d %s", tt.name, total, types, tt.total, tt.types)
+		}
+	}
+}
+
+func TestHerdMovement(t *testing.T) {
+	m := herdMovement{
+		opening: 38,
+		closing: 42,
+		counts:  map[string]int64{animalEventDeath: 3, animalEventCull: 2, animalEventBirth: 9},
+		byReason: map[string]map[string]int64{
+			animalEventDeath: {"disease": 1, "predation": 1, "injury": 1},
+			animalEventCull:  {"infertility": 2},
+		},
+	}
+	if got := m.averageHerd(); got != 40 {
+		t.Errorf("average herd %v, want 40", got)
+	}
+	if got := m.rate(animalEventDeath); got != 7.5 {
+		t.Errorf("mortality rate %v, want 7.5", got)
+	}
+	if got := m.rate(animalEventSale); got != 0 {
+		t.Errorf("sale rate %v, want 0", got)
+	}
+	// A tie goes to the reason that sorts first.
+	if got := m.topReason(animalEventDeath); got != "disease" {
+		t.Errorf("top death cause %q, want disease", got)
+	}
+	if got := m.topReason(animalEventCull); got != "infertility" {
+		t.Errorf("top cull reason %q, want infertility", got)
+	}
+	if got := m.topReason(animalEventBirth); got != "" {
+		t.Errorf("top birth reason %q, want none", got)
+	}
+	if got := (herdMovement{}).rate(animalEventDeath); got != 0 {
+		t.Errorf("rate with no herd %v, want 0", got)
+	}
+}
+
+func TestNormalizeEventReason(t *testing.T) {
+	tests := []struct {
+		eventType, raw, want string
+		ok                   bool
+	}{
+		{animalEventDeath, " Old Age ", "old_age", true},
+		{animalEventCull, "low_production", "low_production", true},
+		{animalEventSale, "", "", true},
+		{animalEventBirth, "twins", "", false},
+		{animalEventDeath, "low_production", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeEventReason(tt.eventType, tt.raw)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s %q: got %q, %v; want %q, %v", tt.eventType, tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+	for _, eventType := range []string{animalEventSale, animalEventDeath, animalEventCull} {
+		if !isExitEvent(eventType) || isEntryEvent(eventType) {
+			t.Errorf("%s should be an exit", eventType)
+		}
+	}
+	if isExitEvent(animalEventTransfer) || isEntryEvent(animalEventTransfer) {
+		t.Error("a transfer neither enters nor leaves the herd")
+	}
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// auditRedactedFields never leave their tables, not even into the audit log.
+var auditRedactedFields = []string{
+	"password_hash", "email_verify_token_hash", "reset_token_hash",
+	"reset_code_hash", "reset_code_expires_at", "reset_code_attempts", "passkey",
+}
+
+// auditSnapshotExtras adds rows kept in a join table to a table's snapshot,
+// so that a role's permissions show up in its audit trail.
+var auditSnapshotExtras = map[string]string{
+	"roles": `jsonb_build_object('permissions', COALESCE((
+		SELECT jsonb_agg(p.key ORDER BY p.key)
+		FROM role_permissions rp JOIN permissions p ON p.id = rp.permission_id
+		WHERE rp.role_id = t.id
+	), '[]'::jsonb))`,
+}
+
+// auditSnapshot returns the row with the given id as JSON, or nil when it does
+// not exist. table is always a constant from the calling handler.
+func auditSnapshot(ctx context.Context, tx pgx.Tx, table string, id int64) ([]byte, error) {
+	extra := `'{}'::jsonb`
+	if e, ok := auditSnapshotExtras[table]; ok {
+		extra = e
+	}
+	var data []byte
+	err := tx.QueryRow(ctx, `SELECT (to_jsonb(t) - $2::text[]) || `+extra+` FROM `+table+` t WHERE t.id = $1 FOR UPDATE`, id, auditRedactedFields).Scan(&data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// auditSystem is the permission recorded for writes the server makes on its
+// own, such as payment callbacks and background retries.
+const auditSystem = "system"
+
+// recordAudit appends an audit event inside tx, so the entry commits or rolls
+// back together with the change it describes. The after image is read back
+// from the table; it is empty for hard deletes. A nil r records a system
+// write, without a user, on the farm set in ctx.
+func (s *Server) recordAudit(ctx context.Context, tx pgx.Tx, r *http.Request, action, table string, id int64, before []byte) error {
+	after, err := auditSnapshot(ctx, tx, table, id)
+	if err != nil {
+		return err
+	}
+	a := auditActorOf(ctx, r)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO audit_events(farm_id, user_id, permission, action, entity, entity_id, before_data, after_data, ip)
+		VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, $7::jsonb, $8::jsonb, $9)
+	`, a.farmID, a.userID, a.permission, action, table, id, nullableJSON(before), nullableJSON(after), a.ip)
+	return err
+}
+
+// auditActor is who an audit event is recorded against.
+type auditActor struct {
+	farmID     int64
+	userID     int64
+	permission string
+	ip         string
+}
+
+// auditActorOf returns the caller of r, or the server itself on the farm set
+// in ctx when r is nil.
+func auditActorOf(ctx context.Context, r *http.Request) auditActor {
+	if r == nil {
+		return auditActor{farmID: farmIDFrom(ctx), permission: auditSystem}
+	}
+	a := auditActor{farmID: farmIDFrom(r.Context()), ip: clientIP(r)}
+	a.userID, _ = r.Context().Value(userIDContextKey).(int64)
+	a.permission, _ = r.Context().Value(grantedPermissionContextKey).(string)
+	return a
+}
+
+// systemContext carries the farm a system write belongs to, for recordAudit.
+func systemContext(ctx context.Context, farmID int64) context.Context {
+	return context.WithValue(ctx, farmIDContextKey, farmID)
+}
+
+// auditedWrite runs change in a transaction and records it in the audit log.
+// For updates and deletes id names the row up front so its before image can be
+// captured; change returns the affected id, or 0 when nothing matched, in which
+// case the transaction is rolled back and no event is written.
+func (s *Server) auditedWrite(ctx context.Context, r *http.Request, action, table string, id int64, change func(tx pgx.Tx) (int64, error)) (int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var before []byte
+	if action != auditCreate {
+		before, err = auditSnapshot(ctx, tx, table, id)
+		if err != nil || before == nil {
+			return 0, err
+		}
+	}
+	id, err = change(tx)
+	if err != nil || id == 0 {
+		return 0, err
+	}
+	if err := s.recordAudit(ctx, tx, r, action, table, id, before); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func nullableJSON(data []byte) *string {
+	if len(data) == 0 {
+		return nil
+	}
+	v := string(data)
+	return &v
+}
+
+func (s *Server) handleAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	entity := strings.ToLower(strings.TrimSpace(q.Get("entity")))
+	var entityID, userID int64
+	if v := strings.TrimSpace(q.Get("entityId")); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid entityId"})
+			return
+		}
+		entityID = n
+	}
+	if v := strings.TrimSpace(q.Get("userId")); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid userId"})
+			return
+		}
+		userID = n
+	}
+	from, err := optionalDate(q.Get("from"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+		return
+	}
+	to, err := optionalDate(q.Get("to"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be on or after from"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+
+	const filter = `
+		WHERE e.farm_id = $1
+			AND ($2 = '' OR e.entity = $2)
+			AND ($3 = 0 OR e.entity_id = $3)
+			AND ($4 = 0 OR e.user_id = $4)
+			AND ($5::date IS NULL OR e.created_at >= $5::date)
+			AND ($6::date IS NULL OR e.created_at < $6::date + 1)
+	`
+	var totalRows int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM audit_events e`+filter, farmID, entity, entityID, userID, from, to).Scan(&totalRows)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT e.id, e.user_id, COALESCE(u.name, ''), e.permission, e.action, e.entity, e.entity_id,
+		       e.before_data, e.after_data, e.ip, e.created_at
+		FROM audit_events e
+		LEFT JOIN users u ON u.id = e.user_id
+	`+filter+`
+		ORDER BY e.created_at DESC, e.id DESC
+		LIMIT $7 OFFSET $8
+	`, farmID, entity, entityID, userID, from, to, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load audit log"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, entityID int64
+		var userID *int64
+		var userName, permission, action, entity, ip string
+		var before, after []byte
+		var createdAt time.Time
+		if err := rows.Scan(&id, &userID, &userName, &permission, &action, &entity, &entityID, &before, &after, &ip, &createdAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse audit log"})
+			return
+		}
+		item := map[string]any{
+			"id":         id,
+			"userId":     userID,
+			"userName":   userName,
+			"permission": permission,
+			"action":     action,
+			"entity":     entity,
+			"entityId":   entityID,
+			"before":     nil,
+			"after":      nil,
+			"ip":         ip,
+			"createdAt":  createdAt.Format("2006-01-02T15:04:05"),
+		}
+		if len(before) > 0 {
+			item["before"] = json.RawMessage(before)
+		}
+		if len(after) > 0 {
+			item["after"] = json.RawMessage(after)
+		}
+		out = append(out, item)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    totalRows,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
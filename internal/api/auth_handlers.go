@@ -9,11 +9,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"regexp"
 	"strings"
 	"time"
 
+	"farmpro/backend/internal/sms"
+
 	"github.com/jackc/pgx/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -57,7 +60,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	roleID, roleName, err := s.resolveRole(ctx, publicRegistrationRole)
+	roleID, roleName, err := s.resolveRole(ctx, 0, publicRegistrationRole)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "registration role is not configured"})
 		return
@@ -88,8 +91,8 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 
 	if s.mailer != nil {
 		verifyURL := s.frontendURL("/verify-email?token=" + verifyToken)
-		body := fmt.Sprintf("Hi %s,\n\nVerify your FarmPro account by opening this link:\n%s\n\nThis link expires in 24 hours.", in.Name, verifyURL)
-		if mailErr := s.mailer.send(in.Email, "Verify your FarmPro account", body); mailErr != nil {
+		data := verifyEmailData{Name: in.Name, URL: verifyURL}
+		if mailErr := s.mailer.sendTemplate(in.Email, "Verify your FarmPro account", "verify", data); mailErr != nil {
 			log.Printf("verify email send failed for %s: %v", in.Email, mailErr)
 			respondJSON(w, http.StatusCreated, map[string]any{
 				"user":   map[string]any{"id": id, "name": in.Name, "email": in.Email, "role": role},
@@ -127,13 +130,12 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	var id int64
-	var name, email, role, passwordHash string
+	var name, email, passwordHash string
 	err := s.db.QueryRow(ctx, `
-		SELECT u.id, u.name, u.email, r.name, u.password_hash
+		SELECT u.id, u.name, u.email, u.password_hash
 		FROM users u
-		JOIN roles r ON r.id = u.role_id
 		WHERE u.email = $1 AND u.status = 'active' AND u.email_verified = true
-		`, loginEmail).Scan(&id, &name, &email, &role, &passwordHash)
+		`, loginEmail).Scan(&id, &name, &email, &passwordHash)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid email or password"})
@@ -148,16 +150,20 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := s.signToken(id, email)
+	// A new session has no farm yet; requests start on the first one the
+	// user belongs to, and so does the role reported here.
+	_, role, _, err := s.loadAuthContext(ctx, id, 0)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to sign token"})
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load user"})
 		return
 	}
-
-	respondJSON(w, http.StatusOK, map[string]any{
-		"token": token,
-		"user":  map[string]any{"id": id, "name": name, "email": email, "role": role},
-	})
+	out, err := s.startSession(ctx, r, id, email)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start session"})
+		return
+	}
+	out["user"] = map[string]any{"id": id, "name": name, "email": email, "role": role}
+	respondJSON(w, http.StatusOK, out)
 }
 
 func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
@@ -177,14 +183,18 @@ func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
 		Role   string `json:"role"`
 		Phone  string `json:"phone"`
 		Status string `json:"status"`
+		FarmID int64  `json:"farmId"`
+		Farm   string `json:"farm"`
 	}
+	out.FarmID = farmIDFrom(ctx)
+	out.Role, _ = ctx.Value(userRoleContextKey).(string)
 	err := s.db.QueryRow(ctx, `
-		SELECT u.id, u.name, u.email, r.name, u.phone, u.status
+		SELECT u.id, u.name, u.email, u.phone, u.status, COALESCE(f.name, '')
 		FROM users u
-		JOIN roles r ON r.id = u.role_id
+		LEFT JOIN farms f ON f.id = $2
 		WHERE u.id = $1
-	`, userID).
-		Scan(&out.ID, &out.Name, &out.Email, &out.Role, &out.Phone, &out.Status)
+	`, userID, out.FarmID).
+		Scan(&out.ID, &out.Name, &out.Email, &out.Phone, &out.Status, &out.Farm)
 	if err != nil {
 		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "user not found"})
 		return
@@ -233,38 +243,121 @@ func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
 	authCtx, authCancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer authCancel()
 
-	var name, role string
-	err = s.db.QueryRow(authCtx, `
-		SELECT u.name, r.name
-		FROM users u
-		JOIN roles r ON r.id = u.role_id
-		WHERE u.id = $1
-	`, id).Scan(&name, &role)
+	var name string
+	if err := s.db.QueryRow(authCtx, `SELECT name FROM users WHERE id = $1`, id).Scan(&name); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load user"})
+		return
+	}
+	_, role, _, err := s.loadAuthContext(authCtx, id, 0)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load user"})
 		return
 	}
 
-	tokenOut, err := s.signToken(id, email)
+	out, err := s.startSession(authCtx, r, id, email)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to sign token"})
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start session"})
 		return
 	}
+	out["user"] = map[string]any{"id": id, "name": name, "email": email, "role": role}
+	respondJSON(w, http.StatusOK, out)
+}
 
-	respondJSON(w, http.StatusOK, map[string]any{
-		"token": tokenOut,
-		"user":  map[string]any{"id": id, "name": name, "email": email, "role": role},
-	})
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	refreshToken := strings.TrimSpace(in.RefreshToken)
+	if refreshToken == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "refreshToken is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	userID, farmID, out, err := s.rotateRefreshToken(ctx, refreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, errRefreshTokenReused):
+			log.Printf("refresh token reuse detected from %s, session revoked", clientIP(r))
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "refresh token already used, session revoked"})
+		case errors.Is(err, errInvalidRefreshToken):
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired refresh token"})
+		default:
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to refresh session"})
+		}
+		return
+	}
+
+	var name, email string
+	if err := s.db.QueryRow(ctx, `SELECT name, email FROM users WHERE id = $1`, userID).Scan(&name, &email); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load user"})
+		return
+	}
+	// The role is the one on the session's farm, as /api/auth/me reports it.
+	_, role, _, err := s.loadAuthContext(ctx, userID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load user"})
+		return
+	}
+	out["user"] = map[string]any{"id": userID, "name": name, "email": email, "role": role}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDContextKey).(int64)
+	sessionID, sok := r.Context().Value(sessionIDContextKey).(int64)
+	if !ok || !sok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid auth context"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.revokeSession(ctx, sessionID, userID, "logout"); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to log out"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDContextKey).(int64)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid auth context"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	revoked, err := s.revokeUserSessions(ctx, userID, "logout_all")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to log out sessions"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "revokedSessions": revoked})
 }
 
 func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
 	var in struct {
 		Email string `json:"email"`
+		Phone string `json:"phone"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 		return
 	}
+	if strings.TrimSpace(in.Phone) != "" {
+		s.sendResetCode(w, r, in.Phone)
+		return
+	}
 	email := strings.ToLower(strings.TrimSpace(in.Email))
 	if email == "" || !emailRe.MatchString(email) {
 		respondJSON(w, http.StatusOK, map[string]string{"message": "if the email exists, reset instructions were sent"})
@@ -307,8 +400,7 @@ func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resetURL := s.frontendURL("/reset-password?token=" + resetToken)
-	body := fmt.Sprintf("Use this link to reset your FarmPro password:\n%s\n\nThis link expires in 30 minutes.", resetURL)
-	if err := s.mailer.send(email, "FarmPro password reset", body); err != nil {
+	if err := s.mailer.sendTemplate(email, "FarmPro password reset", "reset", resetPasswordData{URL: resetURL}); err != nil {
 		log.Printf("password reset email send failed for %s: %v", email, err)
 	}
 
@@ -318,6 +410,8 @@ func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
 	var in struct {
 		Token       string `json:"token"`
+		Phone       string `json:"phone"`
+		Code        string `json:"code"`
 		NewPassword string `json:"newPassword"`
 		Password    string `json:"password"`
 	}
@@ -331,6 +425,10 @@ func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
 	if password == "" {
 		password = strings.TrimSpace(in.Password)
 	}
+	if strings.TrimSpace(in.Code) != "" {
+		s.resetPasswordWithCode(w, r, in.Phone, in.Code, password)
+		return
+	}
 	if token == "" || len(password) < 6 {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "token and password(min 6) are required"})
 		return
@@ -345,25 +443,147 @@ func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	res, err := s.db.Exec(ctx, `
+	var userID int64
+	err = s.db.QueryRow(ctx, `
 		UPDATE users
 		SET password_hash = $1, reset_token_hash = NULL, reset_token_expires_at = NULL
 		WHERE reset_token_hash = $2
 			AND reset_token_expires_at IS NOT NULL
 			AND reset_token_expires_at > NOW()
-	`, string(hash), hashToken(token))
+		RETURNING id
+	`, string(hash), hashToken(token)).Scan(&userID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired token"})
+			return
+		}
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reset password"})
 		return
 	}
-	if res.RowsAffected() == 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired token"})
+	if _, err := s.revokeUserSessions(ctx, userID, "password_reset"); err != nil {
+		log.Printf("session revoke after password reset failed for user %d: %v", userID, err)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// sendResetCode is the text message variant of forgot-password for members
+// who have a phone but no reliable email. Like the email flow it never says
+// whether the number is known.
+func (s *Server) sendResetCode(w http.ResponseWriter, r *http.Request, rawPhone string) {
+	const sent = "if the phone number exists, a reset code was sent"
+	phone, ok := normalizeKenyaPhone(rawPhone)
+	if !ok || phone == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid phone number"})
+		return
+	}
+	if !s.resetLimiter.allow("reset-sms:" + phone) {
+		respondJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many reset attempts, try again later"})
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// A number shared by several accounts cannot say whose password to reset.
+	var id int64
+	err := s.db.QueryRow(ctx, `
+		SELECT MIN(id)
+		FROM users
+		WHERE phone = $1 AND status = 'active'
+		HAVING COUNT(*) = 1
+	`, phone).Scan(&id)
+	if err != nil || s.sms == nil {
+		respondJSON(w, http.StatusOK, map[string]string{"message": sent})
+		return
+	}
+
+	code, err := generateResetCode()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to prepare reset code"})
+		return
+	}
+	_, err = s.db.Exec(ctx, `
+		UPDATE users
+		SET reset_code_hash = $1, reset_code_expires_at = $2, reset_code_attempts = 0
+		WHERE id = $3
+	`, hashToken(code), s.now().Add(15*time.Minute), id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start reset flow"})
+		return
+	}
+
+	status, reason := s.sendSMS(ctx, smsRequest{
+		userID:   id,
+		phone:    phone,
+		template: "reset_code",
+		data:     map[string]string{"code": code},
+	})
+	if status != sms.StatusSent {
+		log.Printf("password reset code send failed for user %d: %s: %s", id, status, reason)
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": sent})
+}
+
+// resetPasswordWithCode allows five guesses per code; after that a new code
+// has to be requested.
+func (s *Server) resetPasswordWithCode(w http.ResponseWriter, r *http.Request, rawPhone, code, password string) {
+	phone, ok := normalizeKenyaPhone(rawPhone)
+	code = strings.TrimSpace(code)
+	if !ok || phone == "" || len(password) < 6 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "phone, code and password(min 6) are required"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "password processing failed"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var userID int64
+	err = s.db.QueryRow(ctx, `
+		UPDATE users
+		SET password_hash = $1, reset_code_hash = NULL, reset_code_expires_at = NULL, reset_code_attempts = 0
+		WHERE phone = $2
+			AND status = 'active'
+			AND reset_code_hash = $3
+			AND reset_code_expires_at > NOW()
+			AND reset_code_attempts < 5
+		RETURNING id
+	`, string(hash), phone, hashToken(code)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if _, err := s.db.Exec(ctx, `
+				UPDATE users SET reset_code_attempts = reset_code_attempts + 1
+				WHERE phone = $1 AND reset_code_hash IS NOT NULL
+			`, phone); err != nil {
+				log.Printf("reset code attempt count failed for %s: %v", phone, err)
+			}
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired code"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reset password"})
+		return
+	}
+	if _, err := s.revokeUserSessions(ctx, userID, "password_reset"); err != nil {
+		log.Printf("session revoke after password reset failed for user %d: %v", userID, err)
+	}
+
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+func generateResetCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
 func generateTokenPair(n int) (string, string, error) {
 	buf := make([]byte, n)
 	if _, err := rand.Read(buf); err != nil {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,63 +13,84 @@ import (
 func (s *Server) handleExpensesSummary(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var total, dailyAvg float64
 	var category string
 	var categoryAmount float64
+	var supplier string
+	var supplierAmount float64
 
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(amount), 0)
 		FROM expenses
-		WHERE DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&total)
+		WHERE farm_id = $1 AND DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&total)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(AVG(day_total), 0)
 		FROM (
 			SELECT expense_date, SUM(amount) AS day_total
 			FROM expenses
-			WHERE DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
+			WHERE farm_id = $1 AND DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
 			GROUP BY expense_date
 		) t
-	`).Scan(&dailyAvg)
+	`, farmID).Scan(&dailyAvg)
 	_ = s.db.QueryRow(ctx, `
 		SELECT category, SUM(amount) AS total
 		FROM expenses
+		WHERE farm_id = $1
 		GROUP BY category
 		ORDER BY total DESC
 		LIMIT 1
-	`).Scan(&category, &categoryAmount)
+	`, farmID).Scan(&category, &categoryAmount)
+	_ = s.db.QueryRow(ctx, `
+		SELECT s.name, SUM(e.amount) AS total
+		FROM expenses e
+		JOIN suppliers s ON s.id = e.supplier_id
+		WHERE e.farm_id = $1 AND DATE_TRUNC('month', e.expense_date) = DATE_TRUNC('month', CURRENT_DATE)
+		GROUP BY s.id
+		ORDER BY total DESC
+		LIMIT 1
+	`, farmID).Scan(&supplier, &supplierAmount)
 
 	respondJSON(w, http.StatusOK, map[string]any{
-		"totalExpenses":   total,
-		"dailyAverage":    dailyAvg,
-		"largestCategory": category,
-		"largestAmount":   categoryAmount,
+		"totalExpenses":     total,
+		"dailyAverage":      dailyAvg,
+		"largestCategory":   category,
+		"largestAmount":     categoryAmount,
+		"topSupplier":       supplier,
+		"topSupplierAmount": supplierAmount,
 	})
 }
 
 func (s *Server) handleExpenses(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
 	offset := (page - 1) * pageSize
+	supplierID, _ := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("supplierId")), 10, 64)
 
 	var total int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM expenses
-		WHERE ($1 = '' OR category ILIKE '%' || $1 || '%' OR item ILIKE '%' || $1 || '%' OR vendor ILIKE '%' || $1 || '%')
-	`, search).Scan(&total)
+		WHERE farm_id = $2 AND ($3 = 0 OR supplier_id = $3)
+			AND ($1 = '' OR category ILIKE '%' || $1 || '%' OR item ILIKE '%' || $1 || '%' OR vendor ILIKE '%' || $1 || '%')
+	`, search, farmID, supplierID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
-		SELECT id, expense_date, category, item, vendor, amount
-		FROM expenses
-		WHERE ($1 = '' OR category ILIKE '%' || $1 || '%' OR item ILIKE '%' || $1 || '%' OR vendor ILIKE '%' || $1 || '%')
-		ORDER BY expense_date DESC, id DESC
+		SELECT e.id, e.expense_date, e.category, e.item, e.vendor, e.amount, e.supplier_id, COALESCE(g.grn_number, '')
+		FROM expenses e
+		LEFT JOIN goods_receipt_lines gl ON gl.id = e.goods_receipt_line_id
+		LEFT JOIN goods_receipts g ON g.id = gl.goods_receipt_id
+		WHERE e.farm_id = $4 AND ($5 = 0 OR e.supplier_id = $5)
+			AND ($1 = '' OR e.category ILIKE '%' || $1 || '%' OR e.item ILIKE '%' || $1 || '%' OR e.vendor ILIKE '%' || $1 || '%')
+		ORDER BY e.expense_date DESC, e.id DESC
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID, supplierID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load expenses"})
 		return
@@ -79,21 +101,24 @@ func (s *Server) handleExpenses(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var id int64
 		var d time.Time
-		var category, item, vendor string
+		var category, item, vendor, grnNumber string
 		var amount float64
-		if err := rows.Scan(&id, &d, &category, &item, &vendor, &amount); err != nil {
+		var supplierID *int64
+		if err := rows.Scan(&id, &d, &category, &item, &vendor, &amount, &supplierID, &grnNumber); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse expenses"})
 			return
 		}
 		out = append(out, map[string]any{
-			"id":        id,
-			"date":      s.formatDateCompact(d),
-			"dateRaw":   s.formatISODate(d),
-			"category":  category,
-			"item":      item,
-			"vendor":    vendor,
-			"amount":    formatKES(amount),
-			"amountRaw": amount,
+			"id":         id,
+			"date":       s.formatDateCompact(d),
+			"dateRaw":    s.formatISODate(d),
+			"category":   category,
+			"item":       item,
+			"vendor":     vendor,
+			"supplierId": supplierID,
+			"grnNumber":  grnNumber,
+			"amount":     formatKES(amount),
+			"amountRaw":  amount,
 		})
 	}
 	respondJSON(w, http.StatusOK, map[string]any{
@@ -107,6 +132,7 @@ func (s *Server) handleExpenses(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleFeedingSummary(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var total, dailyAvg float64
 	var topFeed string
@@ -115,24 +141,25 @@ func (s *Server) handleFeedingSummary(w http.ResponseWriter, r *http.Request) {
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(cost), 0)
 		FROM feeding_records
-		WHERE DATE_TRUNC('month', feed_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&total)
+		WHERE farm_id = $1 AND DATE_TRUNC('month', feed_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&total)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(AVG(day_total), 0)
 		FROM (
 			SELECT feed_date, SUM(cost) AS day_total
 			FROM feeding_records
-			WHERE DATE_TRUNC('month', feed_date) = DATE_TRUNC('month', CURRENT_DATE)
+			WHERE farm_id = $1 AND DATE_TRUNC('month', feed_date) = DATE_TRUNC('month', CURRENT_DATE)
 			GROUP BY feed_date
 		) t
-	`).Scan(&dailyAvg)
+	`, farmID).Scan(&dailyAvg)
 	_ = s.db.QueryRow(ctx, `
 		SELECT feed_type, SUM(cost) AS total
 		FROM feeding_records
+		WHERE farm_id = $1
 		GROUP BY feed_type
 		ORDER BY total DESC
 		LIMIT 1
-	`).Scan(&topFeed, &topCost)
+	`, farmID).Scan(&topFeed, &topCost)
 
 	respondJSON(w, http.StatusOK, map[string]any{
 		"totalCost":  total,
@@ -145,6 +172,7 @@ func (s *Server) handleFeedingSummary(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleFeeding(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
@@ -155,19 +183,21 @@ func (s *Server) handleFeeding(w http.ResponseWriter, r *http.Request) {
 		SELECT COUNT(*)
 		FROM feeding_records f
 		LEFT JOIN animals a ON a.id = f.animal_id
-		WHERE ($1 = '' OR f.feed_type ILIKE '%' || $1 || '%' OR f.supplier ILIKE '%' || $1 || '%' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%')
-	`, search).Scan(&total)
+		WHERE f.farm_id = $2
+			AND ($1 = '' OR f.feed_type ILIKE '%' || $1 || '%' OR f.supplier ILIKE '%' || $1 || '%' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%')
+	`, search, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT f.id, f.feed_date, COALESCE(a.tag_id, ''), f.feed_type, f.quantity_value, f.quantity_unit, f.supplier, f.cost, COALESCE(f.notes,''),
-		       f.ration_id, COALESCE(r.name, ''), f.plan_id
+		       f.ration_id, COALESCE(r.name, ''), f.plan_id, f.supplier_id
 		FROM feeding_records f
 		LEFT JOIN animals a ON a.id = f.animal_id
 		LEFT JOIN feeding_rations r ON r.id = f.ration_id
-		WHERE ($1 = '' OR f.feed_type ILIKE '%' || $1 || '%' OR f.supplier ILIKE '%' || $1 || '%' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%')
+		WHERE f.farm_id = $4
+			AND ($1 = '' OR f.feed_type ILIKE '%' || $1 || '%' OR f.supplier ILIKE '%' || $1 || '%' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%')
 		ORDER BY f.feed_date DESC, f.id DESC
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load feeding records"})
 		return
@@ -180,8 +210,8 @@ func (s *Server) handleFeeding(w http.ResponseWriter, r *http.Request) {
 		var d time.Time
 		var animalTag, feedType, unit, supplier, notes, rationName string
 		var qty, cost float64
-		var rationID, planID *int64
-		if err := rows.Scan(&id, &d, &animalTag, &feedType, &qty, &unit, &supplier, &cost, &notes, &rationID, &rationName, &planID); err != nil {
+		var rationID, planID, supplierID *int64
+		if err := rows.Scan(&id, &d, &animalTag, &feedType, &qty, &unit, &supplier, &cost, &notes, &rationID, &rationName, &planID, &supplierID); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse feeding records"})
 			return
 		}
@@ -203,6 +233,7 @@ func (s *Server) handleFeeding(w http.ResponseWriter, r *http.Request) {
 			"quantityValue": qty,
 			"quantityUnit":  unit,
 			"supplier":   supplier,
+			"supplierId": supplierID,
 			"cost":       formatKES(cost),
 			"costRaw":    cost,
 			"notes":      notes,
@@ -222,6 +253,7 @@ func (s *Server) handleFeeding(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleFeedingRations(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
@@ -231,16 +263,18 @@ func (s *Server) handleFeedingRations(w http.ResponseWriter, r *http.Request) {
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM feeding_rations
-		WHERE ($1 = '' OR name ILIKE '%' || $1 || '%' OR species ILIKE '%' || $1 || '%' OR state ILIKE '%' || $1 || '%')
-	`, search).Scan(&total)
+		WHERE farm_id = $2
+			AND ($1 = '' OR name ILIKE '%' || $1 || '%' OR species ILIKE '%' || $1 || '%' OR state ILIKE '%' || $1 || '%')
+	`, search, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT id, name, species, state, COALESCE(notes,'')
 		FROM feeding_rations
-		WHERE ($1 = '' OR name ILIKE '%' || $1 || '%' OR species ILIKE '%' || $1 || '%' OR state ILIKE '%' || $1 || '%')
+		WHERE farm_id = $4
+			AND ($1 = '' OR name ILIKE '%' || $1 || '%' OR species ILIKE '%' || $1 || '%' OR state ILIKE '%' || $1 || '%')
 		ORDER BY name
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load rations"})
 		return
@@ -269,7 +303,7 @@ func (s *Server) handleFeedingRations(w http.ResponseWriter, r *http.Request) {
 
 	if len(rationIDs) > 0 {
 		rows, err = s.db.Query(ctx, `
-			SELECT ration_id, ingredient, quantity_value, quantity_unit
+			SELECT ration_id, ingredient, quantity_value, quantity_unit, feed_item_id
 			FROM feeding_ration_items
 			WHERE ration_id = ANY($1)
 			ORDER BY id
@@ -280,13 +314,15 @@ func (s *Server) handleFeedingRations(w http.ResponseWriter, r *http.Request) {
 				var rid int64
 				var ingredient, unit string
 				var qty float64
-				if err := rows.Scan(&rid, &ingredient, &qty, &unit); err != nil {
+				var feedItemID *int64
+				if err := rows.Scan(&rid, &ingredient, &qty, &unit, &feedItemID); err != nil {
 					continue
 				}
 				itemsByRation[rid] = append(itemsByRation[rid], map[string]any{
 					"ingredient": ingredient,
 					"quantity":   qty,
 					"unit":       unit,
+					"feedItemId": feedItemID,
 				})
 			}
 			rows.Close()
@@ -308,6 +344,7 @@ func (s *Server) handleFeedingRations(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleFeedingPlans(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
@@ -319,8 +356,9 @@ func (s *Server) handleFeedingPlans(w http.ResponseWriter, r *http.Request) {
 		FROM feeding_plans p
 		LEFT JOIN animals a ON a.id = p.animal_id
 		LEFT JOIN feeding_rations r ON r.id = p.ration_id
-		WHERE ($1 = '' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%' OR COALESCE(r.name,'') ILIKE '%' || $1 || '%' OR p.animal_state ILIKE '%' || $1 || '%')
-	`, search).Scan(&total)
+		WHERE p.farm_id = $2
+			AND ($1 = '' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%' OR COALESCE(r.name,'') ILIKE '%' || $1 || '%' OR p.animal_state ILIKE '%' || $1 || '%')
+	`, search, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT p.id, COALESCE(a.tag_id,''), COALESCE(r.name,''), p.ration_id, p.animal_state, p.daily_quantity_value, p.daily_quantity_unit,
@@ -328,10 +366,11 @@ func (s *Server) handleFeedingPlans(w http.ResponseWriter, r *http.Request) {
 		FROM feeding_plans p
 		LEFT JOIN animals a ON a.id = p.animal_id
 		LEFT JOIN feeding_rations r ON r.id = p.ration_id
-		WHERE ($1 = '' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%' OR COALESCE(r.name,'') ILIKE '%' || $1 || '%' OR p.animal_state ILIKE '%' || $1 || '%')
+		WHERE p.farm_id = $4
+			AND ($1 = '' OR COALESCE(a.tag_id,'') ILIKE '%' || $1 || '%' OR COALESCE(r.name,'') ILIKE '%' || $1 || '%' OR p.animal_state ILIKE '%' || $1 || '%')
 		ORDER BY p.start_date DESC, p.id DESC
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load feeding plans"})
 		return
@@ -385,46 +424,73 @@ func (s *Server) handleFeedingPlans(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleSalesSummary(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	var total, netRevenue, vatCollected, dailyAvg float64
+	var total, netRevenue, vatCollected, credited, dailyAvg float64
 	var topProduct string
 	var topAmount float64
 
 	_ = s.db.QueryRow(ctx, `
-		SELECT COALESCE(SUM(total_amount), 0), COALESCE(SUM(net_amount), 0), COALESCE(SUM(vat_amount), 0)
-		FROM sales
-		WHERE DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&total, &netRevenue, &vatCollected)
+		SELECT COALESCE(SUM(total_amount), 0), COALESCE(SUM(net_amount), 0), COALESCE(SUM(vat_amount), 0),
+		       COALESCE(-SUM(total_amount) FILTER (WHERE kind = 'credit_note'), 0)
+		FROM sales_lines
+		WHERE farm_id = $1 AND DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&total, &netRevenue, &vatCollected, &credited)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(AVG(day_total), 0)
 		FROM (
 			SELECT sale_date, SUM(total_amount) AS day_total
-			FROM sales
-			WHERE DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+			FROM sales_lines
+			WHERE farm_id = $1 AND DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
 			GROUP BY sale_date
 		) t
-	`).Scan(&dailyAvg)
+	`, farmID).Scan(&dailyAvg)
 	_ = s.db.QueryRow(ctx, `
 		SELECT product, SUM(total_amount) AS total
-		FROM sales
+		FROM sales_lines
+		WHERE farm_id = $1
 		GROUP BY product
 		ORDER BY total DESC
 		LIMIT 1
-	`).Scan(&topProduct, &topAmount)
+	`, farmID).Scan(&topProduct, &topAmount)
+
+	var topCustomer string
+	var topCustomerAmount, outstanding, overdue float64
+	_ = s.db.QueryRow(ctx, `
+		SELECT c.name, SUM(l.total_amount) AS total
+		FROM sales_lines l
+		JOIN customers c ON c.id = l.customer_id
+		WHERE l.farm_id = $1 AND DATE_TRUNC('month', l.sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+		GROUP BY c.id, c.name
+		ORDER BY total DESC
+		LIMIT 1
+	`, farmID).Scan(&topCustomer, &topCustomerAmount)
+	_ = s.db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(balance) FILTER (WHERE balance > 0), 0),
+		       COALESCE(SUM(balance) FILTER (WHERE balance > 0 AND due_date < CURRENT_DATE), 0)
+		FROM invoice_balances
+		WHERE farm_id = $1
+	`, farmID).Scan(&outstanding, &overdue)
 
 	respondJSON(w, http.StatusOK, map[string]any{
-		"totalRevenue": total,
-		"netRevenue":   netRevenue,
-		"vatCollected": vatCollected,
-		"dailyAverage": dailyAvg,
-		"topProduct":   topProduct,
-		"topAmount":    topAmount,
+		"totalRevenue":      total,
+		"netRevenue":        netRevenue,
+		"vatCollected":      vatCollected,
+		"creditNotes":       credited,
+		"dailyAverage":      dailyAvg,
+		"topProduct":        topProduct,
+		"topAmount":         topAmount,
+		"topCustomer":       topCustomer,
+		"topCustomerAmount": topCustomerAmount,
+		"outstanding":       outstanding,
+		"overdue":           overdue,
 	})
 }
 
 func (s *Server) handleSales(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
@@ -433,18 +499,18 @@ func (s *Server) handleSales(w http.ResponseWriter, r *http.Request) {
 	var totalRows int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
-		FROM sales
-		WHERE ($1 = '' OR product ILIKE '%' || $1 || '%' OR buyer ILIKE '%' || $1 || '%')
-	`, search).Scan(&totalRows)
+		FROM sales_lines
+		WHERE farm_id = $2 AND ($1 = '' OR product ILIKE '%' || $1 || '%' OR buyer ILIKE '%' || $1 || '%' OR invoice_number ILIKE '%' || $1 || '%')
+	`, search, farmID).Scan(&totalRows)
 
 	rows, err := s.db.Query(ctx, `
-		SELECT id, sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin, delivery_county, delivery_subcounty,
-		       vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount
-		FROM sales
-		WHERE ($1 = '' OR product ILIKE '%' || $1 || '%' OR buyer ILIKE '%' || $1 || '%')
-		ORDER BY sale_date DESC, id DESC
+		SELECT id, invoice_id, invoice_number, kind, sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin, delivery_county, delivery_subcounty,
+		       vat_class, vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount
+		FROM sales_lines
+		WHERE farm_id = $4 AND ($1 = '' OR product ILIKE '%' || $1 || '%' OR buyer ILIKE '%' || $1 || '%' OR invoice_number ILIKE '%' || $1 || '%')
+		ORDER BY sale_date DESC, invoice_id DESC, line_no
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load sales"})
 		return
@@ -453,20 +519,23 @@ func (s *Server) handleSales(w http.ResponseWriter, r *http.Request) {
 
 	out := make([]map[string]any, 0)
 	for rows.Next() {
-		var id int64
+		var id, invoiceID int64
 		var d time.Time
-		var product, unit, buyer, buyerPIN, county, subcounty string
+		var invoiceNumber, kind, product, unit, buyer, buyerPIN, county, subcounty, vatClass string
 		var qty, price, total, vatRate, vatAmount, netAmount float64
 		var vatApplicable bool
 		if err := rows.Scan(
-			&id, &d, &product, &qty, &unit, &buyer, &buyerPIN, &county, &subcounty,
-			&vatApplicable, &vatRate, &vatAmount, &netAmount, &price, &total,
+			&id, &invoiceID, &invoiceNumber, &kind, &d, &product, &qty, &unit, &buyer, &buyerPIN, &county, &subcounty,
+			&vatClass, &vatApplicable, &vatRate, &vatAmount, &netAmount, &price, &total,
 		); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse sales"})
 			return
 		}
 		out = append(out, map[string]any{
 			"id":                id,
+			"invoiceId":         invoiceID,
+			"invoiceNumber":     invoiceNumber,
+			"kind":              kind,
 			"date":              s.formatDateCompact(d),
 			"dateRaw":           s.formatISODate(d),
 			"product":           product,
@@ -477,6 +546,7 @@ func (s *Server) handleSales(w http.ResponseWriter, r *http.Request) {
 			"buyerPIN":          buyerPIN,
 			"deliveryCounty":    county,
 			"deliverySubcounty": subcounty,
+			"vatClass":          vatClass,
 			"vatApplicable":     vatApplicable,
 			"vatRate":           vatRate,
 			"vatAmount":         vatAmount,
@@ -499,16 +569,17 @@ func (s *Server) handleSales(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleReportStats(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var grossRevenue, netRevenue, vatCollected, expense float64
 	var animals int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(total_amount),0), COALESCE(SUM(net_amount),0), COALESCE(SUM(vat_amount),0)
-		FROM sales
-		WHERE DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&grossRevenue, &netRevenue, &vatCollected)
-	_ = s.db.QueryRow(ctx, `SELECT COALESCE(SUM(amount),0) FROM expenses WHERE DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)`).Scan(&expense)
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE is_active = true`).Scan(&animals)
+		FROM sales_lines
+		WHERE farm_id = $1 AND DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&grossRevenue, &netRevenue, &vatCollected)
+	_ = s.db.QueryRow(ctx, `SELECT COALESCE(SUM(amount),0) FROM expenses WHERE farm_id = $1 AND DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)`, farmID).Scan(&expense)
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE farm_id = $1 AND is_active = true`, farmID).Scan(&animals)
 
 	profit := netRevenue - expense
 	productivity := 0
@@ -530,18 +601,20 @@ func (s *Server) handleReportStats(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleReports(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	offset := (page - 1) * pageSize
 	var total int64
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM reports`).Scan(&total)
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM reports WHERE farm_id = $1`, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT id, title, description, category, last_generated
 		FROM reports
+		WHERE farm_id = $3
 		ORDER BY last_generated DESC, id DESC
 		LIMIT $1 OFFSET $2
-	`, pageSize, offset)
+	`, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load reports"})
 		return
@@ -580,22 +653,27 @@ func (s *Server) handleReports(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUserStats(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var total, active, managers, vets int64
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&total)
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE status = 'active'`).Scan(&active)
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM farm_members WHERE farm_id = $1`, farmID).Scan(&total)
+	_ = s.db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM farm_members fm
+		WHERE fm.farm_id = $1 AND fm.status = 'active'
+	`, farmID).Scan(&active)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
-		FROM users u
-		JOIN roles r ON r.id = u.role_id
-		WHERE r.name = 'manager'
-	`).Scan(&managers)
+		FROM farm_members fm
+		JOIN roles r ON r.id = fm.role_id
+		WHERE fm.farm_id = $1 AND r.name = 'manager'
+	`, farmID).Scan(&managers)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
-		FROM users u
-		JOIN roles r ON r.id = u.role_id
-		WHERE r.name = 'veterinarian'
-	`).Scan(&vets)
+		FROM farm_members fm
+		JOIN roles r ON r.id = fm.role_id
+		WHERE fm.farm_id = $1 AND r.name = 'veterinarian'
+	`, farmID).Scan(&vets)
 
 	respondJSON(w, http.StatusOK, map[string]any{
 		"totalStaff":    total,
@@ -608,6 +686,7 @@ func (s *Server) handleUserStats(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
@@ -616,19 +695,23 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 	var total int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
-		FROM users u
-		JOIN roles r ON r.id = u.role_id
-		WHERE ($1 = '' OR u.name ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%' OR r.name ILIKE '%' || $1 || '%')
-	`, search).Scan(&total)
+		FROM farm_members fm
+		JOIN users u ON u.id = fm.user_id
+		JOIN roles r ON r.id = fm.role_id
+		WHERE fm.farm_id = $2
+			AND ($1 = '' OR u.name ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%' OR r.name ILIKE '%' || $1 || '%')
+	`, search, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
-		SELECT u.id, u.name, r.name, u.email, u.phone, u.status
-		FROM users u
-		JOIN roles r ON r.id = u.role_id
-		WHERE ($1 = '' OR u.name ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%' OR r.name ILIKE '%' || $1 || '%')
+		SELECT u.id, u.name, r.name, u.email, u.phone, fm.status
+		FROM farm_members fm
+		JOIN users u ON u.id = fm.user_id
+		JOIN roles r ON r.id = fm.role_id
+		WHERE fm.farm_id = $4
+			AND ($1 = '' OR u.name ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%' OR r.name ILIKE '%' || $1 || '%')
 		ORDER BY u.id
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load users"})
 		return
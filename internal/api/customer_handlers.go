@@ -0,0 +1,823 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	customerKeyStripRe  = regexp.MustCompile(`[^a-z0-9]+`)
+	customerKeySuffixRe = regexp.MustCompile(`(limited|ltd|plc)$`)
+)
+
+// customerKey folds a buyer name the same way the customers migration did, so
+// "KCC", "K.C.C." and "Kcc Ltd" resolve to one customer.
+func customerKey(name string) string {
+	base := customerKeyStripRe.ReplaceAllString(strings.ToLower(name), "")
+	if key := customerKeySuffixRe.ReplaceAllString(base, ""); key != "" {
+		return key
+	}
+	return base
+}
+
+var paymentMethods = map[string]bool{"cash": true, "mpesa": true, "bank": true, "cheque": true, "other": true}
+
+type customerInput struct {
+	Name        string   `json:"name"`
+	KRAPIN      string   `json:"kraPin"`
+	Phone       string   `json:"phone"`
+	Email       string   `json:"email"`
+	County      string   `json:"county"`
+	Subcounty   string   `json:"subcounty"`
+	CreditDays  int      `json:"creditDays"`
+	CreditLimit *float64 `json:"creditLimit"`
+	Notes       string   `json:"notes"`
+	IsActive    *bool    `json:"isActive"`
+}
+
+type customer struct {
+	id          int64
+	name        string
+	kraPIN      string
+	phone       string
+	email       string
+	county      string
+	subcounty   string
+	creditDays  int
+	creditLimit *float64
+	notes       string
+	isActive    bool
+}
+
+func normalizeCustomerInput(in customerInput) (customer, string) {
+	c := customer{
+		name:        strings.Join(strings.Fields(in.Name), " "),
+		email:       strings.ToLower(strings.TrimSpace(in.Email)),
+		subcounty:   strings.TrimSpace(in.Subcounty),
+		creditDays:  in.CreditDays,
+		creditLimit: in.CreditLimit,
+		notes:       strings.TrimSpace(in.Notes),
+		isActive:    in.IsActive == nil || *in.IsActive,
+	}
+	if c.name == "" || customerKey(c.name) == "" {
+		return c, "name is required"
+	}
+	var ok bool
+	if c.kraPIN, ok = normalizeKRAPIN(in.KRAPIN); !ok {
+		return c, "KRA PIN must be valid KRA format (e.g. A012345678Z)"
+	}
+	if c.phone, ok = normalizeKenyaPhone(in.Phone); !ok {
+		return c, "phone must be a valid Kenya number"
+	}
+	if c.county, ok = normalizeCounty(in.County); !ok {
+		return c, "county must be a valid Kenya county"
+	}
+	if c.subcounty != "" && c.county == "" {
+		return c, "county is required when subcounty is provided"
+	}
+	if c.email != "" && !strings.Contains(c.email, "@") {
+		return c, "email is invalid"
+	}
+	if c.creditDays < 0 || c.creditDays > 365 {
+		return c, "creditDays must be between 0 and 365"
+	}
+	if c.creditLimit != nil && *c.creditLimit < 0 {
+		return c, "creditLimit cannot be negative"
+	}
+	return c, ""
+}
+
+func customerConflictMessage(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "idx_customers_kra_pin"):
+		return "another customer already has this KRA PIN"
+	case strings.Contains(msg, "duplicate key"):
+		return "a customer with this name already exists"
+	}
+	return ""
+}
+
+// resolveInvoiceCustomer fills the invoice's customer. An explicit customer id
+// wins; otherwise the buyer is matched by KRA PIN, then by name, and created
+// when it is new. Buyer details left blank on the invoice come from the
+// customer record.
+func resolveInvoiceCustomer(ctx context.Context, tx pgx.Tx, farmID, customerID int64, h *invoiceHeader) error {
+	var c customer
+	var active bool
+	const cols = `id, name, kra_pin, county, subcounty, credit_days, credit_limit, is_active`
+	var err error
+	switch {
+	case customerID > 0:
+		err = tx.QueryRow(ctx, `SELECT `+cols+` FROM customers WHERE id = $1 AND farm_id = $2`, customerID, farmID).
+			Scan(&c.id, &c.name, &c.kraPIN, &c.county, &c.subcounty, &c.creditDays, &c.creditLimit, &active)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &httpError{http.StatusBadRequest, "customer not found"}
+		}
+	case h.buyer != "":
+		err = tx.QueryRow(ctx, `
+			SELECT `+cols+`
+			FROM customers
+			WHERE farm_id = $1 AND (($2 <> '' AND kra_pin = $2) OR name_key = $3)
+			ORDER BY ($2 <> '' AND kra_pin = $2) DESC
+			LIMIT 1
+		`, farmID, h.buyerPIN, customerKey(h.buyer)).
+			Scan(&c.id, &c.name, &c.kraPIN, &c.county, &c.subcounty, &c.creditDays, &c.creditLimit, &active)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c = customer{name: h.buyer, kraPIN: h.buyerPIN, county: h.county, subcounty: h.subcounty}
+			active = true
+			err = tx.QueryRow(ctx, `
+				INSERT INTO customers(farm_id, name, name_key, kra_pin, county, subcounty)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING id
+			`, farmID, c.name, customerKey(c.name), c.kraPIN, c.county, c.subcounty).Scan(&c.id)
+		}
+	default:
+		return &httpError{http.StatusBadRequest, "customerId or buyer is required"}
+	}
+	if err != nil {
+		return err
+	}
+	if !active {
+		return &httpError{http.StatusConflict, "customer " + c.name + " is inactive"}
+	}
+
+	h.customerID = c.id
+	h.buyer = c.name
+	if h.buyerPIN == "" {
+		h.buyerPIN = c.kraPIN
+	}
+	if h.county == "" {
+		h.county, h.subcounty = c.county, c.subcounty
+	}
+	h.dueDate = h.date.AddDate(0, 0, c.creditDays)
+	h.creditLimit = c.creditLimit
+	return nil
+}
+
+// checkCreditLimit refuses an invoice that would take the customer's unpaid
+// balance past their credit limit. exceptInvoiceID leaves out the invoice
+// being edited.
+func checkCreditLimit(ctx context.Context, tx pgx.Tx, h invoiceHeader, total float64, exceptInvoiceID int64) error {
+	if h.creditLimit == nil {
+		return nil
+	}
+	var outstanding float64
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(balance), 0)
+		FROM invoice_balances
+		WHERE customer_id = $1 AND invoice_id <> $2
+	`, h.customerID, exceptInvoiceID).Scan(&outstanding)
+	if err != nil {
+		return err
+	}
+	if outstanding+total > *h.creditLimit+0.005 {
+		return &httpError{http.StatusConflict, fmt.Sprintf(
+			"%s owes %s; this invoice would exceed their credit limit of %s",
+			h.buyer, formatKES(roundCents(outstanding)), formatKES(*h.creditLimit),
+		)}
+	}
+	return nil
+}
+
+func (s *Server) handleCustomers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	search := parseSearch(r)
+	offset := (page - 1) * pageSize
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	const filter = `
+		c.farm_id = $1 AND (NOT $2 OR c.is_active)
+		AND ($3 = '' OR c.name ILIKE '%' || $3 || '%' OR c.kra_pin ILIKE '%' || $3 || '%' OR c.phone ILIKE '%' || $3 || '%')
+	`
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM customers c WHERE `+filter, farmID, activeOnly, search).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT c.id, c.name, c.kra_pin, c.phone, c.email, c.county, c.subcounty, c.credit_days, c.credit_limit, c.notes, c.is_active,
+		       COALESCE(b.balance, 0), COALESCE(b.overdue, 0)
+		FROM customers c
+		LEFT JOIN (
+			SELECT customer_id, SUM(balance) AS balance, SUM(balance) FILTER (WHERE due_date < CURRENT_DATE AND balance > 0) AS overdue
+			FROM invoice_balances
+			GROUP BY customer_id
+		) b ON b.customer_id = c.id
+		WHERE `+filter+`
+		ORDER BY c.name
+		LIMIT $4 OFFSET $5
+	`, farmID, activeOnly, search, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load customers"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var c customer
+		var balance, overdue float64
+		if err := rows.Scan(
+			&c.id, &c.name, &c.kraPIN, &c.phone, &c.email, &c.county, &c.subcounty, &c.creditDays, &c.creditLimit, &c.notes, &c.isActive,
+			&balance, &overdue,
+		); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse customers"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":          c.id,
+			"name":        c.name,
+			"kraPin":      c.kraPIN,
+			"phone":       c.phone,
+			"email":       c.email,
+			"county":      c.county,
+			"subcounty":   c.subcounty,
+			"creditDays":  c.creditDays,
+			"creditLimit": c.creditLimit,
+			"notes":       c.notes,
+			"isActive":    c.isActive,
+			"balance":     balance,
+			"overdue":     overdue,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (s *Server) handleCreateCustomer(w http.ResponseWriter, r *http.Request) {
+	var in customerInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	c, msg := normalizeCustomerInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "customers", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO customers(farm_id, name, name_key, kra_pin, phone, email, county, subcounty, credit_days, credit_limit, notes, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id
+		`, farmID, c.name, customerKey(c.name), c.kraPIN, c.phone, c.email, c.county, c.subcounty, c.creditDays, c.creditLimit, c.notes, c.isActive).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if msg := customerConflictMessage(err); msg != "" {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": msg})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create customer"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+// handleUpdateCustomer edits the customer record. Invoices keep the buyer
+// details they were issued with.
+func (s *Server) handleUpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	customerID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid customer id"})
+		return
+	}
+	var in customerInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	c, msg := normalizeCustomerInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "customers", customerID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE customers
+			SET name = $1, name_key = $2, kra_pin = $3, phone = $4, email = $5, county = $6, subcounty = $7,
+				credit_days = $8, credit_limit = $9, notes = $10, is_active = $11
+			WHERE id = $12 AND farm_id = $13
+		`, c.name, customerKey(c.name), c.kraPIN, c.phone, c.email, c.county, c.subcounty, c.creditDays, c.creditLimit, c.notes, c.isActive, customerID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return customerID, nil
+	})
+	if err != nil {
+		if msg := customerConflictMessage(err); msg != "" {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": msg})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update customer"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "customer not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	customerID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid customer id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var invoiced bool
+	_ = s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM invoices WHERE customer_id = $1 AND farm_id = $2)`, customerID, farmID).Scan(&invoiced)
+	if invoiced {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "this customer has invoices; mark them inactive instead"})
+		return
+	}
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "customers", customerID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM customers WHERE id = $1 AND farm_id = $2`, customerID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return customerID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete customer"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "customer not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handlePayments(w http.ResponseWriter, r *http.Request) {
+	customerID, _ := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("customerId")), 10, 64)
+	invoiceID, _ := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("invoiceId")), 10, 64)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+
+	const filter = `p.farm_id = $1 AND ($2 = 0 OR p.customer_id = $2) AND ($3 = 0 OR p.invoice_id = $3)`
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM payments p WHERE `+filter, farmID, customerID, invoiceID).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT p.id, p.payment_date, p.amount, p.method, p.reference, p.notes,
+		       p.customer_id, c.name, p.invoice_id, i.invoice_number
+		FROM payments p
+		JOIN customers c ON c.id = p.customer_id
+		JOIN invoices i ON i.id = p.invoice_id
+		WHERE `+filter+`
+		ORDER BY p.payment_date DESC, p.id DESC
+		LIMIT $4 OFFSET $5
+	`, farmID, customerID, invoiceID, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load payments"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, custID, invID int64
+		var d time.Time
+		var amount float64
+		var method, reference, notes, customerName, invoiceNumber string
+		if err := rows.Scan(&id, &d, &amount, &method, &reference, &notes, &custID, &customerName, &invID, &invoiceNumber); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse payments"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":            id,
+			"date":          s.formatDateCompact(d),
+			"dateRaw":       s.formatISODate(d),
+			"amount":        amount,
+			"method":        method,
+			"reference":     reference,
+			"notes":         notes,
+			"customerId":    custID,
+			"customer":      customerName,
+			"invoiceId":     invID,
+			"invoiceNumber": invoiceNumber,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// handleCreatePayment records money received against an invoice. A payment
+// can settle the balance but not exceed it.
+func (s *Server) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
+		return
+	}
+	var in struct {
+		Date      string  `json:"date"`
+		Amount    float64 `json:"amount"`
+		Method    string  `json:"method"`
+		Reference string  `json:"reference"`
+		Notes     string  `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Amount = roundCents(in.Amount)
+	if in.Amount <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "amount must be greater than zero"})
+		return
+	}
+	in.Method = strings.ToLower(strings.TrimSpace(in.Method))
+	if in.Method == "" {
+		in.Method = "cash"
+	}
+	if !paymentMethods[in.Method] {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "method must be one of cash, mpesa, bank, cheque, other"})
+		return
+	}
+	if strings.TrimSpace(in.Date) == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(in.Date))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "payments", 0, func(tx pgx.Tx) (int64, error) {
+		// Locking the invoice serialises payments against it.
+		var customerID int64
+		var kind string
+		err := tx.QueryRow(ctx, `SELECT customer_id, kind FROM invoices WHERE id = $1 AND farm_id = $2 FOR UPDATE`, invoiceID, farmID).Scan(&customerID, &kind)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if kind != invoiceKindInvoice {
+			return 0, &httpError{http.StatusConflict, "payments can only be recorded against invoices"}
+		}
+		var balance float64
+		if err := tx.QueryRow(ctx, `SELECT balance FROM invoice_balances WHERE invoice_id = $1`, invoiceID).Scan(&balance); err != nil {
+			return 0, err
+		}
+		if in.Amount > balance+0.005 {
+			return 0, &httpError{http.StatusConflict, "payment exceeds the outstanding balance of " + formatKES(roundCents(balance))}
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO payments(farm_id, customer_id, invoice_id, payment_date, amount, method, reference, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`, farmID, customerID, invoiceID, d, in.Amount, in.Method, strings.TrimSpace(in.Reference), strings.TrimSpace(in.Notes)).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to record payment")
+		return
+	}
+	if id == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+func (s *Server) handleDeletePayment(w http.ResponseWriter, r *http.Request) {
+	paymentID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payment id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "payments", paymentID, func(tx pgx.Tx) (int64, error) {
+		// An M-Pesa receipt behind the payment goes back to the reconciliation
+		// queue rather than being lost.
+		_, err := tx.Exec(ctx, `
+			UPDATE mpesa_transactions
+			SET status = 'unmatched', match_method = '', invoice_id = NULL, payment_id = NULL, resolved_by = NULL, resolved_at = NULL
+			WHERE payment_id = $1 AND farm_id = $2
+		`, paymentID, farmID)
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM payments WHERE id = $1 AND farm_id = $2`, paymentID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return paymentID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete payment"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "payment not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// agingBucket names how far past its due date an unpaid balance is.
+func agingBucket(daysOverdue int) string {
+	switch {
+	case daysOverdue <= 0:
+		return "current"
+	case daysOverdue <= 30:
+		return "days1to30"
+	case daysOverdue <= 60:
+		return "days31to60"
+	case daysOverdue <= 90:
+		return "days61to90"
+	default:
+		return "over90"
+	}
+}
+
+var agingBuckets = []string{"current", "days1to30", "days31to60", "days61to90", "over90"}
+
+// handleReceivablesAging groups every open balance by customer and by how
+// overdue it is. Credit balances are reported separately.
+func (s *Server) handleReceivablesAging(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT b.customer_id, c.name, b.balance, (CURRENT_DATE - b.due_date)
+		FROM invoice_balances b
+		JOIN customers c ON c.id = b.customer_id
+		WHERE b.farm_id = $1 AND b.balance <> 0
+		ORDER BY c.name, b.due_date
+	`, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load receivables"})
+		return
+	}
+	defer rows.Close()
+
+	var balances []agedBalance
+	for rows.Next() {
+		var b agedBalance
+		if err := rows.Scan(&b.customerID, &b.customer, &b.balance, &b.daysOverdue); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse receivables"})
+			return
+		}
+		balances = append(balances, b)
+	}
+	totals, customers := receivablesAging(balances)
+	respondJSON(w, http.StatusOK, map[string]any{
+		"asOf":      s.formatISODate(s.now()),
+		"buckets":   agingBuckets,
+		"totals":    totals,
+		"customers": customers,
+	})
+}
+
+// agedBalance is what is left to pay on one invoice, or a credit the customer
+// holds when negative.
+type agedBalance struct {
+	customerID  int64
+	customer    string
+	balance     float64
+	daysOverdue int
+}
+
+// receivablesAging totals balances, in the order given, per customer and per
+// aging bucket. Credits go to their own bucket and never count as overdue.
+func receivablesAging(balances []agedBalance) (map[string]float64, []map[string]any) {
+	newBuckets := func() map[string]float64 {
+		m := make(map[string]float64, len(agingBuckets)+2)
+		for _, b := range agingBuckets {
+			m[b] = 0
+		}
+		m["credit"] = 0
+		m["total"] = 0
+		return m
+	}
+	totals := newBuckets()
+	var order []int64
+	byCustomer := map[int64]map[string]any{}
+	for _, b := range balances {
+		entry, ok := byCustomer[b.customerID]
+		if !ok {
+			entry = map[string]any{"customerId": b.customerID, "customer": b.customer, "buckets": newBuckets(), "oldestDaysOverdue": 0}
+			byCustomer[b.customerID] = entry
+			order = append(order, b.customerID)
+		}
+		buckets := entry["buckets"].(map[string]float64)
+		bucket := "credit"
+		if b.balance > 0 {
+			bucket = agingBucket(b.daysOverdue)
+			if b.daysOverdue > entry["oldestDaysOverdue"].(int) {
+				entry["oldestDaysOverdue"] = b.daysOverdue
+			}
+		}
+		buckets[bucket] = roundCents(buckets[bucket] + b.balance)
+		buckets["total"] = roundCents(buckets["total"] + b.balance)
+		totals[bucket] = roundCents(totals[bucket] + b.balance)
+		totals["total"] = roundCents(totals["total"] + b.balance)
+	}
+
+	customers := make([]map[string]any, 0, len(order))
+	for _, id := range order {
+		customers = append(customers, byCustomer[id])
+	}
+	return totals, customers
+}
+
+// handleCustomerStatement lists a customer's invoices, credit notes and
+// payments over a period with a running balance, as PDF, CSV or JSON.
+func (s *Server) handleCustomerStatement(w http.ResponseWriter, r *http.Request) {
+	customerID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid customer id"})
+		return
+	}
+	from, err := optionalDate(r.URL.Query().Get("from"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+		return
+	}
+	to, err := optionalDate(r.URL.Query().Get("to"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+	end := s.now()
+	if to != nil {
+		end = *to
+	}
+	end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	start := end.AddDate(0, 0, -89)
+	if from != nil {
+		start = *from
+	}
+	if start.After(end) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must not be after to"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var name, kraPIN, phone string
+	err = s.db.QueryRow(ctx, `SELECT name, kra_pin, phone FROM customers WHERE id = $1 AND farm_id = $2`, customerID, farmID).Scan(&name, &kraPIN, &phone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "customer not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load customer"})
+		return
+	}
+
+	// Invoices add to the balance; credit notes and payments reduce it.
+	const entries = `
+		SELECT invoice_date AS entry_date, CASE WHEN kind = 'credit_note' THEN 'credit note' ELSE 'invoice' END AS entry_type,
+		       invoice_number AS document, reason AS detail,
+		       CASE WHEN kind = 'credit_note' THEN -total_amount ELSE total_amount END AS amount, created_at
+		FROM invoices
+		WHERE customer_id = $1 AND farm_id = $2
+		UNION ALL
+		SELECT p.payment_date, 'payment', i.invoice_number, TRIM(p.method || ' ' || p.reference), -p.amount, p.created_at
+		FROM payments p
+		JOIN invoices i ON i.id = p.invoice_id
+		WHERE p.customer_id = $1 AND p.farm_id = $2
+	`
+	var opening float64
+	if err := s.db.QueryRow(ctx, `SELECT COALESCE(SUM(amount), 0) FROM (`+entries+`) e WHERE entry_date < $3`, customerID, farmID, start).Scan(&opening); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to build statement"})
+		return
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT entry_date, entry_type, document, detail, amount
+		FROM (`+entries+`) e
+		WHERE entry_date BETWEEN $3 AND $4
+		ORDER BY entry_date, created_at
+	`, customerID, farmID, start, end)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to build statement"})
+		return
+	}
+	defer rows.Close()
+
+	balance := roundCents(opening)
+	var invoiced, credited, paid float64
+	records := make([]map[string]any, 0)
+	for rows.Next() {
+		var d time.Time
+		var entryType, document, detail string
+		var amount float64
+		if err := rows.Scan(&d, &entryType, &document, &detail, &amount); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to build statement"})
+			return
+		}
+		balance = roundCents(balance + amount)
+		switch entryType {
+		case "invoice":
+			invoiced += amount
+		case "credit note":
+			credited -= amount
+		case "payment":
+			paid -= amount
+		}
+		records = append(records, map[string]any{
+			"date":     s.formatISODate(d),
+			"type":     entryType,
+			"document": document,
+			"detail":   detail,
+			"amount":   amount,
+			"balance":  balance,
+		})
+	}
+
+	format := "PDF"
+	if strings.TrimSpace(r.URL.Query().Get("format")) != "" {
+		format = normalizeReportFormat(r.URL.Query().Get("format"))
+	}
+	report := reportContent{
+		ID:          customerID,
+		Title:       "Statement " + name,
+		Category:    "Statement",
+		DateRange:   s.formatISODate(start) + " to " + s.formatISODate(end),
+		Format:      format,
+		GeneratedOn: s.formatISODate(s.now()),
+		Summary: map[string]any{
+			"customer":       name,
+			"kraPin":         kraPIN,
+			"phone":          phone,
+			"openingBalance": roundCents(opening),
+			"invoiced":       roundCents(invoiced),
+			"credited":       roundCents(credited),
+			"paid":           roundCents(paid),
+			"closingBalance": balance,
+		},
+		Records: records,
+	}
+	switch report.Format {
+	case "CSV":
+		if err := writeCSVReport(w, report); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to write csv statement"})
+		}
+	case "PDF":
+		if err := writePDFReport(w, report); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to write pdf statement"})
+		}
+	default:
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.json\"", reportFilename(report.Title)))
+		respondJSON(w, http.StatusOK, report)
+	}
+}
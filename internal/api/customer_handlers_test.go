@@ -0,0 +1,104 @@
+package api
+
+import "testing"
+
+func TestAgingBucket(t *testing.T) {
+	tests := map[int]string{
+		-5: "current",
+		0:  "current",
+		1:  "days1to30",
+		30: "days1to30",
+		31: "days31to60",
+		60: "days31to60",
+		61: "days61to90",
+		90: "days61to90",
+		91: "over90",
+	}
+	for days, want := range tests {
+		if got := agingBucket(days); got != want {
+			t.Errorf("%d days overdue: %s, want %s", days, got, want)
+		}
+	}
+}
+
+func TestReceivablesAging(t *testing.T) {
+	totals, customers := receivablesAging([]agedBalance{
+		{customerID: 2, customer: "Brookside", balance: 1000, daysOverdue: -3},
+		{customerID: 2, customer: "Brookside", balance: 250.5, daysOverdue: 45},
+		{customerID: 2, customer: "Brookside", balance: -100, daysOverdue: 120},
+		{customerID: 1, customer: "KCC", balance: 300, daysOverdue: 95},
+		{customerID: 1, customer: "KCC", balance: 0.1, daysOverdue: 10},
+		{customerID: 1, customer: "KCC", balance: 0.2, daysOverdue: 12},
+	})
+
+	wantTotals := map[string]float64{
+		"current": 1000, "days1to30": 0.3, "days31to60": 250.5, "days61to90": 0, "over90": 300,
+		"credit": -100, "total": 1450.8,
+	}
+	for bucket, want := range wantTotals {
+		if totals[bucket] != want {
+			t.Errorf("total %s = %v, want %v", bucket, totals[bucket], want)
+		}
+	}
+
+	if len(customers) != 2 {
+		t.Fatalf("%d customers, want 2", len(customers))
+	}
+	// Customers keep the order their balances came in.
+	brookside, kcc := customers[0], customers[1]
+	if brookside["customer"] != "Brookside" || kcc["customer"] != "KCC" {
+		t.Fatalf("customers out of order: %v, %v", brookside["customer"], kcc["customer"])
+	}
+	// A credit does not make the customer overdue, however old it is.
+	if got := brookside["oldestDaysOverdue"]; got != 45 {
+		t.Errorf("Brookside oldest overdue = %v, want 45", got)
+	}
+	if got := kcc["oldestDaysOverdue"]; got != 95 {
+		t.Errorf("KCC oldest overdue = %v, want 95", got)
+	}
+	b := brookside["buckets"].(map[string]float64)
+	if b["current"] != 1000 || b["days31to60"] != 250.5 || b["credit"] != -100 || b["total"] != 1150.5 {
+		t.Errorf("Brookside buckets = %v", b)
+	}
+	if k := kcc["buckets"].(map[string]float64); k["days1to30"] != 0.3 || k["total"] != 300.3 {
+		t.Errorf("KCC buckets = %v", k)
+	}
+}
+
+func TestCustomerKey(t *testing.T) {
+	for _, name := range []string{"KCC", "K.C.C.", "Kcc Ltd", "kcc limited"} {
+		if got := customerKey(name); got != "kcc" {
+			t.Errorf("%q: key %q, want kcc", name, got)
+		}
+	}
+	// A name that is nothing but a suffix keeps it.
+	if got := customerKey("Ltd"); got != "ltd" {
+		t.Errorf("Ltd: key %q, want ltd", got)
+	}
+}
+
+func TestNormalizeCustomerInput(t *testing.T) {
+	limit := -1.0
+	tests := []struct {
+		name string
+		in   customerInput
+		msg  string
+	}{
+		{"valid", customerInput{Name: "  Brookside   Dairy ", Phone: "0712345678", Email: "Accounts@Brookside.co.ke", CreditDays: 30}, ""},
+		{"no name", customerInput{Name: " ... "}, "name is required"},
+		{"bad PIN", customerInput{Name: "KCC", KRAPIN: "12345"}, "KRA PIN must be valid KRA format (e.g. A012345678Z)"},
+		{"subcounty without county", customerInput{Name: "KCC", Subcounty: "Kikuyu"}, "county is required when subcounty is provided"},
+		{"bad email", customerInput{Name: "KCC", Email: "accounts"}, "email is invalid"},
+		{"credit days", customerInput{Name: "KCC", CreditDays: 400}, "creditDays must be between 0 and 365"},
+		{"credit limit", customerInput{Name: "KCC", CreditLimit: &limit}, "creditLimit cannot be negative"},
+	}
+	for _, tt := range tests {
+		c, msg := normalizeCustomerInput(tt.in)
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+		}
+		if tt.msg == "" && (c.name != "Brookside Dairy" || c.email != "accounts@brookside.co.ke" || !c.isActive) {
+			t.Errorf("%s: got %+v", tt.name, c)
+		}
+	}
+}
@@ -0,0 +1,711 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const maxCaseSymptoms = 20
+
+var caseSeverities = map[string]bool{"mild": true, "moderate": true, "severe": true}
+
+var caseOutcomes = map[string]bool{"recovered": true, "chronic": true, "died": true, "culled": true}
+
+// notifiableDiseaseNames and notifiableDiseaseCodes are the diseases the
+// Animal Diseases Act requires a farmer to report to the county veterinary
+// officer, by name and by the abbreviations vets write them as.
+var notifiableDiseaseNames = []string{
+	"foot and mouth", "foot-and-mouth", "anthrax", "lumpy skin", "pleuropneumonia", "peste des petits ruminants",
+	"rinderpest", "rift valley fever", "african swine fever", "classical swine fever", "newcastle", "avian influenza",
+	"bird flu", "rabies", "brucellosis", "tuberculosis", "sheep pox", "goat pox", "bluetongue", "glanders",
+}
+
+var notifiableDiseaseCodes = map[string]struct{}{
+	"fmd": {}, "lsd": {}, "cbpp": {}, "ccpp": {}, "ppr": {}, "rvf": {}, "asf": {}, "csf": {}, "ncd": {}, "hpai": {}, "tb": {},
+}
+
+func isNotifiableDisease(diagnosis string) bool {
+	d := strings.ToLower(diagnosis)
+	for _, name := range notifiableDiseaseNames {
+		if strings.Contains(d, name) {
+			return true
+		}
+	}
+	words := strings.FieldsFunc(d, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, w := range words {
+		if _, ok := notifiableDiseaseCodes[w]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type diseaseCaseInput struct {
+	AnimalTagID  string   `json:"animalTagId"`
+	Diagnosis    string   `json:"diagnosis"`
+	OnsetDate    string   `json:"onsetDate"`
+	Symptoms     []string `json:"symptoms"`
+	Severity     string   `json:"severity"`
+	Notifiable   *bool    `json:"notifiable"`
+	ReportedOn   string   `json:"reportedOn"`
+	Veterinarian string   `json:"veterinarian"`
+	Outcome      string   `json:"outcome"`
+	ClosedOn     string   `json:"closedOn"`
+	Notes        string   `json:"notes"`
+}
+
+type diseaseCase struct {
+	diseaseCaseInput
+	onset      time.Time
+	notifiable bool
+	reportedOn *time.Time
+	outcome    *string
+	closedOn   *time.Time
+}
+
+// normalizeDiseaseCaseInput validates a case. Notifiable defaults from the
+// diagnosis; a case with an outcome is closed, on closedOn or else on closed,
+// the day it was already closed, or today.
+func normalizeDiseaseCaseInput(in diseaseCaseInput, today time.Time, closed *time.Time) (diseaseCase, string) {
+	c := diseaseCase{diseaseCaseInput: in}
+	tagID, ok := normalizeAnimalTag(in.AnimalTagID)
+	if !ok {
+		return c, "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"
+	}
+	c.AnimalTagID = tagID
+	c.Diagnosis = strings.Join(strings.Fields(in.Diagnosis), " ")
+	c.Veterinarian = strings.TrimSpace(in.Veterinarian)
+	c.Notes = strings.TrimSpace(in.Notes)
+	if c.Diagnosis == "" {
+		return c, "diagnosis is required"
+	}
+
+	c.Symptoms = make([]string, 0, len(in.Symptoms))
+	seen := map[string]bool{}
+	for _, raw := range in.Symptoms {
+		v := strings.Join(strings.Fields(raw), " ")
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		c.Symptoms = append(c.Symptoms, v)
+	}
+	if len(c.Symptoms) > maxCaseSymptoms {
+		return c, fmt.Sprintf("a case can list at most %d symptoms", maxCaseSymptoms)
+	}
+
+	c.Severity = strings.ToLower(strings.TrimSpace(in.Severity))
+	if c.Severity == "" {
+		c.Severity = "moderate"
+	}
+	if !caseSeverities[c.Severity] {
+		return c, "severity must be mild, moderate or severe"
+	}
+
+	c.onset = today
+	if strings.TrimSpace(in.OnsetDate) != "" {
+		d, err := time.Parse("2006-01-02", strings.TrimSpace(in.OnsetDate))
+		if err != nil {
+			return c, "onsetDate must be YYYY-MM-DD"
+		}
+		c.onset = d
+	}
+	if c.onset.After(today) {
+		return c, "onsetDate cannot be in the future"
+	}
+
+	var err error
+	if c.reportedOn, err = optionalDate(in.ReportedOn); err != nil {
+		return c, "reportedOn must be YYYY-MM-DD"
+	}
+	if c.reportedOn != nil && (c.reportedOn.Before(c.onset) || c.reportedOn.After(today)) {
+		return c, "reportedOn must be between the onset and today"
+	}
+	c.notifiable = isNotifiableDisease(c.Diagnosis)
+	if in.Notifiable != nil {
+		c.notifiable = *in.Notifiable
+	}
+
+	outcome := strings.ToLower(strings.TrimSpace(in.Outcome))
+	if c.closedOn, err = optionalDate(in.ClosedOn); err != nil {
+		return c, "closedOn must be YYYY-MM-DD"
+	}
+	if outcome == "" {
+		if c.closedOn != nil {
+			return c, "an outcome is required to close a case"
+		}
+		return c, ""
+	}
+	if !caseOutcomes[outcome] {
+		return c, "outcome must be recovered, chronic, died or culled"
+	}
+	c.outcome = &outcome
+	if c.closedOn == nil {
+		c.closedOn = closed
+	}
+	if c.closedOn == nil {
+		c.closedOn = &today
+	}
+	if c.closedOn.Before(c.onset) || c.closedOn.After(today) {
+		return c, "closedOn must be between the onset and today"
+	}
+	return c, ""
+}
+
+// syncHealthStatus derives an animal's health status from its open cases
+// and quarantine: sick with a moderate or severe case, needing attention
+// with a mild one or while quarantined, and healthy otherwise.
+func syncHealthStatus(ctx context.Context, tx pgx.Tx, animalID int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE animals a
+		SET health_status = d.status
+		FROM (
+			SELECT CASE
+				WHEN EXISTS (SELECT 1 FROM disease_cases WHERE animal_id = $1 AND outcome IS NULL AND severity <> 'mild') THEN 'sick'
+				WHEN EXISTS (SELECT 1 FROM disease_cases WHERE animal_id = $1 AND outcome IS NULL)
+					OR EXISTS (SELECT 1 FROM quarantine_animals WHERE animal_id = $1 AND released_on IS NULL) THEN 'attention'
+				ELSE 'healthy'
+			END AS status
+		) d
+		WHERE a.id = $1 AND a.health_status <> d.status
+	`, animalID)
+	return err
+}
+
+// checkRecordCase makes sure the case a health record treats is the
+// animal's own.
+func checkRecordCase(ctx context.Context, tx pgx.Tx, farmID, animalID int64, caseID *int64) error {
+	if caseID == nil {
+		return nil
+	}
+	var caseAnimal int64
+	err := tx.QueryRow(ctx, `SELECT animal_id FROM disease_cases WHERE id = $1 AND farm_id = $2`, *caseID, farmID).Scan(&caseAnimal)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &httpError{http.StatusBadRequest, "disease case not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if caseAnimal != animalID {
+		return &httpError{http.StatusBadRequest, "the disease case is for another animal"}
+	}
+	return nil
+}
+
+const diseaseCaseColumns = `c.id, a.tag_id, a.type, c.diagnosis, c.onset_date, c.symptoms, c.severity, c.notifiable, c.reported_on,
+	c.veterinarian, COALESCE(c.outcome, ''), c.closed_on, c.notes,
+	(SELECT COUNT(*) FROM health_records h WHERE h.case_id = c.id),
+	COALESCE((
+		SELECT g.name FROM quarantine_animals q JOIN quarantine_groups g ON g.id = q.group_id
+		WHERE q.animal_id = c.animal_id AND q.released_on IS NULL
+	), '')`
+
+func (s *Server) scanDiseaseCase(row pgx.Row) (map[string]any, error) {
+	var id, treatments int64
+	var tag, animalType, diagnosis, severity, vet, outcome, notes, quarantine string
+	var symptoms []string
+	var onset time.Time
+	var reportedOn, closedOn *time.Time
+	var notifiable bool
+	if err := row.Scan(&id, &tag, &animalType, &diagnosis, &onset, &symptoms, &severity, &notifiable, &reportedOn,
+		&vet, &outcome, &closedOn, &notes, &treatments, &quarantine); err != nil {
+		return nil, err
+	}
+	end := s.today()
+	status := "open"
+	var reported, closed any
+	if reportedOn != nil {
+		reported = s.formatISODate(*reportedOn)
+	}
+	if closedOn != nil {
+		status = "closed"
+		closed = s.formatISODate(*closedOn)
+		end = *closedOn
+	}
+	return map[string]any{
+		"id":          id,
+		"animalTagId": tag,
+		"animalType":  animalType,
+		"species":     normalizeSpeciesName(animalType),
+		"diagnosis":   diagnosis,
+		"onsetDate":   s.formatISODate(onset),
+		"symptoms":    symptoms,
+		"severity":    severity,
+		"notifiable":  notifiable,
+		"reportedOn":  reported,
+		"reportDue":   notifiable && reportedOn == nil,
+		"vet":         vet,
+		"status":      status,
+		"outcome":     outcome,
+		"closedOn":    closed,
+		"daysOpen":    int(end.Sub(onset).Hours() / 24),
+		"notes":       notes,
+		"treatments":  treatments,
+		"quarantine":  quarantine,
+	}, nil
+}
+
+// handleDiseaseCases lists cases, newest onset first. status is open or
+// closed; notifiable=true keeps the ones that must be reported.
+func (s *Server) handleDiseaseCases(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := strings.ToLower(strings.TrimSpace(q.Get("status")))
+	if status != "" && status != "open" && status != "closed" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be open or closed"})
+		return
+	}
+	tagID := ""
+	if raw := strings.TrimSpace(q.Get("animalTagId")); raw != "" {
+		normalized, ok := normalizeAnimalTag(raw)
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
+			return
+		}
+		tagID = normalized
+	}
+	notifiableOnly := q.Get("notifiable") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+
+	const filter = `
+		WHERE c.farm_id = $1
+			AND ($2 = '' OR ($2 = 'open') = (c.outcome IS NULL))
+			AND ($3 = '' OR a.tag_id = $3)
+			AND ($4 = false OR c.notifiable)
+	`
+	var total, open, reportDue int64
+	_ = s.db.QueryRow(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE c.outcome IS NULL),
+			COUNT(*) FILTER (WHERE c.notifiable AND c.reported_on IS NULL)
+		FROM disease_cases c
+		JOIN animals a ON a.id = c.animal_id
+	`+filter, farmID, status, tagID, notifiableOnly).Scan(&total, &open, &reportDue)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+diseaseCaseColumns+`
+		FROM disease_cases c
+		JOIN animals a ON a.id = c.animal_id
+	`+filter+`
+		ORDER BY c.onset_date DESC, c.id DESC
+		LIMIT $5 OFFSET $6
+	`, farmID, status, tagID, notifiableOnly, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load disease cases"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		item, err := s.scanDiseaseCase(rows)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse disease cases"})
+			return
+		}
+		out = append(out, item)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+		"summary": map[string]any{
+			"open":      open,
+			"reportDue": reportDue,
+		},
+	})
+}
+
+// handleDiseaseCase returns one case with the treatments recorded for it.
+func (s *Server) handleDiseaseCase(w http.ResponseWriter, r *http.Request) {
+	caseID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid case id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	item, err := s.scanDiseaseCase(s.db.QueryRow(ctx, `
+		SELECT `+diseaseCaseColumns+`
+		FROM disease_cases c
+		JOIN animals a ON a.id = c.animal_id
+		WHERE c.id = $1 AND c.farm_id = $2
+	`, caseID, farmID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "disease case not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load disease case"})
+		return
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, action, treatment, record_date, veterinarian, dose_quantity::float8, dose_unit
+		FROM health_records
+		WHERE case_id = $1 AND farm_id = $2
+		ORDER BY record_date, id
+	`, caseID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load case treatments"})
+		return
+	}
+	defer rows.Close()
+	treatments := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var action, treatment, vet, doseUnit string
+		var date time.Time
+		var dose *float64
+		if err := rows.Scan(&id, &action, &treatment, &date, &vet, &dose, &doseUnit); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse case treatments"})
+			return
+		}
+		treatments = append(treatments, map[string]any{
+			"id":           id,
+			"action":       action,
+			"treatment":    treatment,
+			"date":         s.formatISODate(date),
+			"vet":          vet,
+			"doseQuantity": dose,
+			"doseUnit":     doseUnit,
+		})
+	}
+	item["treatmentRecords"] = treatments
+	respondJSON(w, http.StatusOK, item)
+}
+
+func (s *Server) handleCreateDiseaseCase(w http.ResponseWriter, r *http.Request) {
+	var in diseaseCaseInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	c, msg := normalizeDiseaseCaseInput(in, s.today(), nil)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "disease_cases", 0, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		err := tx.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, c.AnimalTagID, farmID).Scan(&animalID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusBadRequest, "animal not found"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO disease_cases(
+				farm_id, animal_id, diagnosis, onset_date, symptoms, severity, notifiable, reported_on,
+				veterinarian, outcome, closed_on, notes, created_by
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, 0))
+			RETURNING id
+		`, farmID, animalID, c.Diagnosis, c.onset, c.Symptoms, c.Severity, c.notifiable, c.reportedOn,
+			c.Veterinarian, c.outcome, c.closedOn, c.Notes, userID).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, syncHealthStatus(ctx, tx, animalID)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to create disease case")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "notifiable": c.notifiable})
+}
+
+// handleUpdateDiseaseCase edits a case, closing it when an outcome is given.
+// A death or cull is still recorded as a lifecycle event on the animal.
+func (s *Server) handleUpdateDiseaseCase(w http.ResponseWriter, r *http.Request) {
+	caseID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid case id"})
+		return
+	}
+	var in diseaseCaseInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	if _, msg := normalizeDiseaseCaseInput(in, s.today(), nil); msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "disease_cases", caseID, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		var tag string
+		var closedOn *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT a.id, a.tag_id, c.closed_on
+			FROM disease_cases c
+			JOIN animals a ON a.id = c.animal_id
+			WHERE c.id = $1 AND c.farm_id = $2
+			FOR UPDATE OF c
+		`, caseID, farmID).Scan(&animalID, &tag, &closedOn)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		// A closed case keeps the day it was closed unless a new one is given.
+		c, msg := normalizeDiseaseCaseInput(in, s.today(), closedOn)
+		if msg != "" {
+			return 0, &httpError{http.StatusBadRequest, msg}
+		}
+		// Its treatments were given to this animal, so the case stays with it.
+		if tag != c.AnimalTagID {
+			return 0, &httpError{http.StatusBadRequest, "a case cannot move to another animal; open a new case instead"}
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE disease_cases
+			SET diagnosis = $1, onset_date = $2, symptoms = $3, severity = $4, notifiable = $5, reported_on = $6,
+				veterinarian = $7, outcome = $8, closed_on = $9, notes = $10
+			WHERE id = $11
+		`, c.Diagnosis, c.onset, c.Symptoms, c.Severity, c.notifiable, c.reportedOn,
+			c.Veterinarian, c.outcome, c.closedOn, c.Notes, caseID)
+		if err != nil {
+			return 0, err
+		}
+		return caseID, syncHealthStatus(ctx, tx, animalID)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to update disease case")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "disease case not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleDeleteDiseaseCase removes a case opened in error. Its treatments
+// stay as plain health records.
+func (s *Server) handleDeleteDiseaseCase(w http.ResponseWriter, r *http.Request) {
+	caseID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid case id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "disease_cases", caseID, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		err := tx.QueryRow(ctx, `DELETE FROM disease_cases WHERE id = $1 AND farm_id = $2 RETURNING animal_id`, caseID, farmID).Scan(&animalID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return caseID, syncHealthStatus(ctx, tx, animalID)
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete disease case"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "disease case not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+type outbreakCase struct {
+	tag, species, diagnosis string
+	onset                   time.Time
+	outcome                 string
+	notifiable, reported    bool
+}
+
+type outbreak struct {
+	species           string
+	from, to          time.Time
+	cases, open, lost int
+	notifiable        int
+	unreported        int
+	diagnoses         map[string]int
+	animals           []string
+}
+
+// groupOutbreaks chains cases, in onset order, into one outbreak per
+// species until the gap to the previous onset is more than gapDays.
+func groupOutbreaks(cases []outbreakCase, gapDays int) []*outbreak {
+	current := map[string]*outbreak{}
+	var all []*outbreak
+	for _, c := range cases {
+		o := current[c.species]
+		if o == nil || c.onset.Sub(o.to) > time.Duration(gapDays)*24*time.Hour {
+			o = &outbreak{species: c.species, from: c.onset, diagnoses: map[string]int{}}
+			current[c.species] = o
+			all = append(all, o)
+		}
+		o.to = c.onset
+		o.cases++
+		o.diagnoses[c.diagnosis]++
+		o.animals = append(o.animals, c.tag)
+		switch c.outcome {
+		case "":
+			o.open++
+		case "died", "culled":
+			o.lost++
+		}
+		if c.notifiable {
+			o.notifiable++
+			if !c.reported {
+				o.unreported++
+			}
+		}
+	}
+	return all
+}
+
+// handleOutbreaks groups the cases of the last `days` days into outbreaks:
+// cases in one species whose onsets follow each other within gapDays. Only
+// groups of at least minCases are returned, ongoing ones first.
+func (s *Server) handleOutbreaks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	intParam := func(name string, def, min, max int) (int, bool) {
+		v := strings.TrimSpace(q.Get(name))
+		if v == "" {
+			return def, true
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s must be between %d and %d", name, min, max)})
+			return 0, false
+		}
+		return n, true
+	}
+	days, ok := intParam("days", 180, 1, 3650)
+	if !ok {
+		return
+	}
+	gapDays, ok := intParam("gapDays", 14, 1, 90)
+	if !ok {
+		return
+	}
+	minCases, ok := intParam("minCases", 2, 1, 1000)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	today := s.today()
+
+	rows, err := s.db.Query(ctx, `
+		SELECT a.tag_id, a.type, c.diagnosis, c.onset_date, COALESCE(c.outcome, ''), c.notifiable, c.reported_on IS NOT NULL
+		FROM disease_cases c
+		JOIN animals a ON a.id = c.animal_id
+		WHERE c.farm_id = $1 AND c.onset_date > $2
+		ORDER BY c.onset_date, c.id
+	`, farmID, today.AddDate(0, 0, -days))
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load disease cases"})
+		return
+	}
+	defer rows.Close()
+
+	var cases []outbreakCase
+	for rows.Next() {
+		var c outbreakCase
+		var animalType string
+		if err := rows.Scan(&c.tag, &animalType, &c.diagnosis, &c.onset, &c.outcome, &c.notifiable, &c.reported); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse disease cases"})
+			return
+		}
+		c.species = normalizeSpeciesName(animalType)
+		cases = append(cases, c)
+	}
+	if err := rows.Err(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse disease cases"})
+		return
+	}
+
+	all := groupOutbreaks(cases, gapDays)
+	sort.SliceStable(all, func(i, j int) bool {
+		ai, aj := all[i].open > 0, all[j].open > 0
+		if ai != aj {
+			return ai
+		}
+		return all[i].to.After(all[j].to)
+	})
+	out := make([]map[string]any, 0)
+	for _, o := range all {
+		if o.cases < minCases {
+			continue
+		}
+		names := make([]string, 0, len(o.diagnoses))
+		for name := range o.diagnoses {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if o.diagnoses[names[i]] != o.diagnoses[names[j]] {
+				return o.diagnoses[names[i]] > o.diagnoses[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		diagnoses := make([]map[string]any, 0, len(names))
+		for _, name := range names {
+			diagnoses = append(diagnoses, map[string]any{"diagnosis": name, "cases": o.diagnoses[name]})
+		}
+		out = append(out, map[string]any{
+			"species":    o.species,
+			"from":       s.formatISODate(o.from),
+			"to":         s.formatISODate(o.to),
+			"cases":      o.cases,
+			"open":       o.open,
+			"lost":       o.lost,
+			"notifiable": o.notifiable,
+			"unreported": o.unreported,
+			"active":     o.open > 0 || !o.to.Before(today.AddDate(0, 0, -gapDays)),
+			"diagnoses":  diagnoses,
+			"animals":    o.animals,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"days":     days,
+		"gapDays":  gapDays,
+		"minCases": minCases,
+		"items":    out,
+	})
+}
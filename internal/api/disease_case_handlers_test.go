@@ -0,0 +1,203 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsNotifiableDisease(t *testing.T) {
+	tests := map[string]bool{
+		"Foot and Mouth Disease":   true,
+		"suspected foot-and-mouth": true,
+		"Lumpy skin disease":       true,
+		"FMD (type O)":             true,
+		"CBPP":                     true,
+		"bovine TB":                true,
+		"Newcastle disease":        true,
+		"Mastitis":                 false,
+		"East Coast Fever":         false,
+		"Pneumonia":                false,
+		"stb":                      false,
+		"":                         false,
+	}
+	for diagnosis, want := range tests {
+		if got := isNotifiableDisease(diagnosis); got != want {
+			t.Errorf("isNotifiableDisease(%q) = %v, want %v", diagnosis, got, want)
+		}
+	}
+}
+
+func TestNormalizeDiseaseCaseInput(t *testing.T) {
+	today := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	day := func(s string) *time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return &d
+	}
+	no := false
+	tests := []struct {
+		name       string
+		in         diseaseCaseInput
+		closed     *time.Time
+		msg        string
+		onset      string
+		notifiable bool
+		closedOn   string
+	}{
+		{
+			name:  "open case with defaults",
+			in:    diseaseCaseInput{AnimalTagID: " ke-001 ", Diagnosis: "  mastitis "},
+			onset: "2024-06-10",
+		},
+		{
+			name:       "notifiable from the diagnosis",
+			in:         diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "FMD", OnsetDate: "2024-06-01"},
+			onset:      "2024-06-01",
+			notifiable: true,
+		},
+		{
+			name:  "notifiable overridden",
+			in:    diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "anthrax", OnsetDate: "2024-06-01", Notifiable: &no},
+			onset: "2024-06-01",
+		},
+		{
+			name:     "outcome closes today",
+			in:       diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", OnsetDate: "2024-06-01", Outcome: "Recovered"},
+			onset:    "2024-06-01",
+			closedOn: "2024-06-10",
+		},
+		{
+			name:     "edit keeps the stored closing date",
+			in:       diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", OnsetDate: "2024-06-01", Outcome: "recovered", Notes: "fixed typo"},
+			closed:   day("2024-06-04"),
+			onset:    "2024-06-01",
+			closedOn: "2024-06-04",
+		},
+		{
+			name:     "a given closing date wins",
+			in:       diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", OnsetDate: "2024-06-01", Outcome: "recovered", ClosedOn: "2024-06-06"},
+			closed:   day("2024-06-04"),
+			onset:    "2024-06-01",
+			closedOn: "2024-06-06",
+		},
+		{
+			name:   "stored closing date before a moved onset",
+			in:     diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", OnsetDate: "2024-06-05", Outcome: "recovered"},
+			closed: day("2024-06-04"),
+			msg:    "closedOn must be between the onset and today",
+		},
+		{
+			name:   "reopened case drops the closing date",
+			in:     diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", OnsetDate: "2024-06-01"},
+			closed: day("2024-06-04"),
+			onset:  "2024-06-01",
+		},
+		{
+			name: "closing date without outcome",
+			in:   diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", ClosedOn: "2024-06-10"},
+			msg:  "an outcome is required to close a case",
+		},
+		{
+			name: "future onset",
+			in:   diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", OnsetDate: "2024-06-11"},
+			msg:  "onsetDate cannot be in the future",
+		},
+		{
+			name: "unknown severity",
+			in:   diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", Severity: "fatal"},
+			msg:  "severity must be mild, moderate or severe",
+		},
+		{
+			name: "unknown outcome",
+			in:   diseaseCaseInput{AnimalTagID: "KE-001", Diagnosis: "mastitis", Outcome: "sold"},
+			msg:  "outcome must be recovered, chronic, died or culled",
+		},
+		{
+			name: "missing diagnosis",
+			in:   diseaseCaseInput{AnimalTagID: "KE-001"},
+			msg:  "diagnosis is required",
+		},
+	}
+	for _, tt := range tests {
+		c, msg := normalizeDiseaseCaseInput(tt.in, today, tt.closed)
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg != "" {
+			continue
+		}
+		if got := c.onset.Format(time.DateOnly); got != tt.onset {
+			t.Errorf("%s: onset %s, want %s", tt.name, got, tt.onset)
+		}
+		if c.notifiable != tt.notifiable {
+			t.Errorf("%s: notifiable %v, want %v", tt.name, c.notifiable, tt.notifiable)
+		}
+		closedOn := ""
+		if c.closedOn != nil {
+			closedOn = c.closedOn.Format(time.DateOnly)
+		}
+		if closedOn != tt.closedOn {
+			t.Errorf("%s: closedOn %q, want %q", tt.name, closedOn, tt.closedOn)
+		}
+		if (c.outcome != nil) != (tt.closedOn != "") {
+			t.Errorf("%s: outcome %v with closedOn %q", tt.name, c.outcome, closedOn)
+		}
+	}
+
+	c, _ := normalizeDiseaseCaseInput(diseaseCaseInput{
+		AnimalTagID: "ke-001",
+		Diagnosis:   "mastitis",
+		Symptoms:    []string{" Fever ", "fever", "", "swollen  udder"},
+		Severity:    "SEVERE",
+	}, today, nil)
+	if c.AnimalTagID != "KE-001" || c.Severity != "severe" || !reflect.DeepEqual(c.Symptoms, []string{"Fever", "swollen udder"}) {
+		t.Errorf("normalized case = %q, %q, %q", c.AnimalTagID, c.Severity, c.Symptoms)
+	}
+}
+
+func TestGroupOutbreaks(t *testing.T) {
+	onset := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	cases := []outbreakCase{
+		{tag: "C1", species: "Cow", diagnosis: "FMD", onset: onset("2024-03-01"), outcome: "recovered", notifiable: true, reported: true},
+		{tag: "G1", species: "Goat", diagnosis: "PPR", onset: onset("2024-03-02"), notifiable: true},
+		{tag: "C2", species: "Cow", diagnosis: "FMD", onset: onset("2024-03-08"), outcome: "died", notifiable: true},
+		{tag: "C3", species: "Cow", diagnosis: "mastitis", onset: onset("2024-03-15")},
+		// 15 days after C3: past the gap, so a new outbreak starts.
+		{tag: "C4", species: "Cow", diagnosis: "mastitis", onset: onset("2024-03-30"), outcome: "culled"},
+	}
+	got := groupOutbreaks(cases, 14)
+	want := []*outbreak{
+		{
+			species: "Cow", from: onset("2024-03-01"), to: onset("2024-03-15"),
+			cases: 3, open: 1, lost: 1, notifiable: 2, unreported: 1,
+			diagnoses: map[string]int{"FMD": 2, "mastitis": 1},
+			animals:   []string{"C1", "C2", "C3"},
+		},
+		{
+			species: "Goat", from: onset("2024-03-02"), to: onset("2024-03-02"),
+			cases: 1, open: 1, notifiable: 1, unreported: 1,
+			diagnoses: map[string]int{"PPR": 1},
+			animals:   []string{"G1"},
+		},
+		{
+			species: "Cow", from: onset("2024-03-30"), to: onset("2024-03-30"),
+			cases: 1, lost: 1,
+			diagnoses: map[string]int{"mastitis": 1},
+			animals:   []string{"C4"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for i, o := range got {
+			t.Logf("outbreak %d: %+v", i, *o)
+		}
+		t.Errorf("groupOutbreaks returned %d outbreaks, want %d as listed", len(got), len(want))
+	}
+
+	if got := groupOutbreaks(cases, 15); len(got) != 2 || got[0].cases != 4 {
+		t.Errorf("with a 15 day gap C4 should join the first cow outbreak, got %d outbreaks", len(got))
+	}
+}
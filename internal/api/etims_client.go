@@ -0,0 +1,390 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"farmpro/backend/internal/etims"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	etimsPending   = "pending"
+	etimsSubmitted = "submitted"
+	etimsAccepted  = "accepted"
+	etimsRejected  = "rejected"
+	etimsFailed    = "failed"
+
+	etimsMaxAttempts = 8
+)
+
+var errEtimsNotSubmittable = errors.New("etims submission is not pending or failed")
+
+type etimsResult struct {
+	status  string
+	receipt *etims.Receipt
+	message string
+}
+
+// etimsRetryDelay backs off exponentially from one minute, capped at an hour.
+func etimsRetryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// etimsNextAttempt is when a submission that failed its attempts-th try goes
+// out again, or nil once it has used up etimsMaxAttempts.
+func etimsNextAttempt(attempts int, now time.Time) *time.Time {
+	if attempts >= etimsMaxAttempts {
+		return nil
+	}
+	next := now.Add(etimsRetryDelay(attempts))
+	return &next
+}
+
+// etimsOutcome is the status an attempt's error leaves a submission in. A
+// transient failure may still have reached KRA, so after one KRA's duplicate
+// rejection means the invoice was signed and only the answer was lost.
+func etimsOutcome(err error, attempts int) string {
+	switch {
+	case err == nil:
+		return etimsAccepted
+	case etims.IsDuplicate(err) && attempts > 1:
+		return etimsAccepted
+	case etims.IsRejected(err):
+		return etimsRejected
+	default:
+		return etimsFailed
+	}
+}
+
+// submitEtimsReceipt sends a pending or failed submission to eTIMS and records
+// the outcome. The row is claimed as submitted first so a concurrent retry
+// cannot send the same invoice twice. The returned error is only set when the
+// outcome could not be stored; KRA failures are reported in the result. The
+// claim and the outcome are audited as r's, or as system writes when r is nil.
+func (s *Server) submitEtimsReceipt(ctx context.Context, r *http.Request, farmID, id int64) (etimsResult, error) {
+	if r == nil {
+		ctx = systemContext(ctx, farmID)
+	}
+	var sequence, originalSequence int64
+	var payload []byte
+	var attempts int
+	claimed, err := s.auditedWrite(ctx, r, auditUpdate, "etims_submissions", id, func(tx pgx.Tx) (int64, error) {
+		err := tx.QueryRow(ctx, `
+			UPDATE etims_submissions e
+			SET status = 'submitted', attempts = e.attempts + 1, next_attempt_at = NULL, updated_at = NOW()
+			FROM invoices i
+			WHERE e.id = $1 AND e.farm_id = $2 AND e.status IN ('pending', 'failed') AND i.id = e.invoice_id
+			RETURNING i.sequence, COALESCE((SELECT o.sequence FROM invoices o WHERE o.id = i.original_invoice_id), 0),
+				e.payload, e.attempts
+		`, id, farmID).Scan(&sequence, &originalSequence, &payload, &attempts)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		return id, err
+	})
+	if err != nil {
+		return etimsResult{}, err
+	}
+	if claimed == 0 {
+		return etimsResult{}, errEtimsNotSubmittable
+	}
+
+	var inv etims.Invoice
+	var receipt etims.Receipt
+	if err = json.Unmarshal(payload, &inv); err == nil {
+		inv.Sequence = sequence
+		inv.OriginalSequence = originalSequence
+		var device etims.Device
+		device, err = s.etimsDevice(ctx, farmID, inv.SupplierPIN)
+		if err == nil {
+			receipt, err = s.etims.SubmitInvoice(ctx, device, inv)
+		}
+	}
+
+	// The outcome must be stored even when the request context ran out while
+	// waiting on KRA, or the row would stay claimed.
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	switch etimsOutcome(err, attempts) {
+	case etimsAccepted:
+		if err != nil {
+			// KRA's signature went out with the lost answer; the receipt
+			// keeps the duplicate notice instead.
+			response, _ := json.Marshal(map[string]any{"duplicate": true, "message": err.Error()})
+			dbErr := s.saveEtimsOutcome(saveCtx, r, id, `
+				UPDATE etims_submissions
+				SET status = 'accepted', response = $1::jsonb, last_error = '', updated_at = NOW()
+				WHERE id = $2
+			`, string(response))
+			return etimsResult{status: etimsAccepted, message: "KRA already holds this invoice from an earlier attempt"}, dbErr
+		}
+		response, _ := json.Marshal(receipt)
+		err = s.saveEtimsOutcome(saveCtx, r, id, `
+			UPDATE etims_submissions
+			SET status = 'accepted', response = $1::jsonb, last_error = '', updated_at = NOW()
+			WHERE id = $2
+		`, string(response))
+		return etimsResult{status: etimsAccepted, receipt: &receipt}, err
+	case etimsRejected:
+		var rejected *etims.RejectedError
+		errors.As(err, &rejected)
+		response, _ := json.Marshal(map[string]string{"code": rejected.Code, "message": rejected.Message})
+		dbErr := s.saveEtimsOutcome(saveCtx, r, id, `
+			UPDATE etims_submissions
+			SET status = 'rejected', response = $1::jsonb, last_error = $2, updated_at = NOW()
+			WHERE id = $3
+		`, string(response), err.Error())
+		return etimsResult{status: etimsRejected, message: err.Error()}, dbErr
+	default:
+		dbErr := s.saveEtimsOutcome(saveCtx, r, id, `
+			UPDATE etims_submissions
+			SET status = 'failed', last_error = $1, next_attempt_at = $2, updated_at = NOW()
+			WHERE id = $3
+		`, err.Error(), etimsNextAttempt(attempts, time.Now()))
+		return etimsResult{status: etimsFailed, message: err.Error()}, dbErr
+	}
+}
+
+// saveEtimsOutcome runs update, whose last parameter is the submission id, on
+// submission id and audits the change.
+func (s *Server) saveEtimsOutcome(ctx context.Context, r *http.Request, id int64, update string, args ...any) error {
+	_, err := s.auditedWrite(ctx, r, auditUpdate, "etims_submissions", id, func(tx pgx.Tx) (int64, error) {
+		_, err := tx.Exec(ctx, update, append(args, id)...)
+		return id, err
+	})
+	return err
+}
+
+// etimsDevice returns the farm's initialised device, initialising it with KRA
+// the first time or when the farm's PIN changed.
+func (s *Server) etimsDevice(ctx context.Context, farmID int64, tin string) (etims.Device, error) {
+	var d etims.Device
+	err := s.db.QueryRow(ctx, `
+		SELECT tin, branch_id, device_serial, device_id, sdc_id, mrc_number, cmc_key
+		FROM etims_devices
+		WHERE farm_id = $1
+	`, farmID).Scan(&d.TIN, &d.BranchID, &d.DeviceSerial, &d.DeviceID, &d.SDCID, &d.MRCNumber, &d.CMCKey)
+	if err == nil && d.TIN == tin {
+		return d, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return etims.Device{}, err
+	}
+	return s.initEtimsDevice(ctx, farmID, tin)
+}
+
+func (s *Server) initEtimsDevice(ctx context.Context, farmID int64, tin string) (etims.Device, error) {
+	d, err := s.etims.Initialize(ctx, etims.DeviceRequest{
+		TIN:          tin,
+		BranchID:     s.etimsBranchID,
+		DeviceSerial: s.etimsDeviceSerial,
+	})
+	if err != nil {
+		return etims.Device{}, err
+	}
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO etims_devices(farm_id, tin, branch_id, device_serial, device_id, sdc_id, mrc_number, cmc_key, initialized_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		ON CONFLICT (farm_id) DO UPDATE SET
+			tin = EXCLUDED.tin,
+			branch_id = EXCLUDED.branch_id,
+			device_serial = EXCLUDED.device_serial,
+			device_id = EXCLUDED.device_id,
+			sdc_id = EXCLUDED.sdc_id,
+			mrc_number = EXCLUDED.mrc_number,
+			cmc_key = EXCLUDED.cmc_key,
+			initialized_at = EXCLUDED.initialized_at
+	`, farmID, d.TIN, d.BranchID, d.DeviceSerial, d.DeviceID, d.SDCID, d.MRCNumber, d.CMCKey)
+	return d, err
+}
+
+func (s *Server) handleEtimsDevice(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var d etims.Device
+	var initializedAt time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT tin, branch_id, device_serial, device_id, sdc_id, mrc_number, initialized_at
+		FROM etims_devices
+		WHERE farm_id = $1
+	`, farmID).Scan(&d.TIN, &d.BranchID, &d.DeviceSerial, &d.DeviceID, &d.SDCID, &d.MRCNumber, &initializedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusOK, map[string]any{"initialized": false, "environment": s.etimsEnvironment()})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load eTIMS device"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"initialized":   true,
+		"environment":   s.etimsEnvironment(),
+		"device":        d,
+		"initializedAt": s.formatDateLong(initializedAt),
+	})
+}
+
+func (s *Server) handleEtimsInitDevice(w http.ResponseWriter, r *http.Request) {
+	if s.etims == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "eTIMS is not configured"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var tin string
+	if err := s.db.QueryRow(ctx, `SELECT kra_pin FROM farms WHERE id = $1`, farmID).Scan(&tin); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load farm"})
+		return
+	}
+	if tin == "" {
+		tin = s.kraPIN
+	}
+	if tin == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "set the farm's KRA PIN before initialising eTIMS"})
+		return
+	}
+
+	d, err := s.initEtimsDevice(ctx, farmID, tin)
+	if err != nil {
+		if etims.IsRejected(err) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		respondJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to reach eTIMS"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "environment": s.etimsEnvironment(), "device": d})
+}
+
+func (s *Server) etimsEnvironment() string {
+	if s.etims == nil {
+		return "disabled"
+	}
+	if _, ok := s.etims.(*etims.Fake); ok {
+		return "local"
+	}
+	return "kra"
+}
+
+// RunEtimsRetries resubmits failed eTIMS submissions whose backoff has passed,
+// checking every interval until ctx is cancelled.
+func (s *Server) RunEtimsRetries(ctx context.Context, interval time.Duration) {
+	if s.etims == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.retryDueEtims(ctx)
+		}
+	}
+}
+
+func (s *Server) retryDueEtims(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	// A submission still marked submitted this long after its last attempt
+	// lost its answer. It goes back into the retry queue like any other
+	// failed attempt, within the same cap and backoff.
+	rows, err := s.db.Query(ctx, `
+		SELECT id, farm_id, attempts
+		FROM etims_submissions
+		WHERE status = 'submitted' AND updated_at < NOW() - INTERVAL '10 minutes'
+	`)
+	if err != nil {
+		log.Printf("etims retry: load stale submissions: %v", err)
+		return
+	}
+	type stale struct {
+		id, farmID int64
+		attempts   int
+	}
+	var lost []stale
+	for rows.Next() {
+		var st stale
+		if err := rows.Scan(&st.id, &st.farmID, &st.attempts); err != nil {
+			rows.Close()
+			log.Printf("etims retry: parse stale submissions: %v", err)
+			return
+		}
+		lost = append(lost, st)
+	}
+	rows.Close()
+	for _, st := range lost {
+		_, err := s.auditedWrite(systemContext(ctx, st.farmID), nil, auditUpdate, "etims_submissions", st.id, func(tx pgx.Tx) (int64, error) {
+			tag, err := tx.Exec(ctx, `
+				UPDATE etims_submissions
+				SET status = 'failed', last_error = 'no response from eTIMS', next_attempt_at = $1, updated_at = NOW()
+				WHERE id = $2 AND status = 'submitted'
+			`, etimsNextAttempt(st.attempts, time.Now()), st.id)
+			if err != nil || tag.RowsAffected() == 0 {
+				return 0, err
+			}
+			return st.id, nil
+		})
+		if err != nil {
+			log.Printf("etims retry: release stale submission %d: %v", st.id, err)
+			return
+		}
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT id, farm_id
+		FROM etims_submissions
+		WHERE status = 'failed' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT 50
+	`)
+	if err != nil {
+		log.Printf("etims retry: load due submissions: %v", err)
+		return
+	}
+	type due struct{ id, farmID int64 }
+	var pending []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.farmID); err != nil {
+			rows.Close()
+			log.Printf("etims retry: parse due submissions: %v", err)
+			return
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+
+	for _, d := range pending {
+		result, err := s.submitEtimsReceipt(ctx, nil, d.farmID, d.id)
+		if err != nil {
+			if !errors.Is(err, errEtimsNotSubmittable) {
+				log.Printf("etims retry: submission %d: %v", d.id, err)
+			}
+			continue
+		}
+		if result.status != etimsAccepted {
+			log.Printf("etims retry: submission %d %s: %s", d.id, result.status, result.message)
+		}
+	}
+}
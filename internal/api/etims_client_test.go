@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"farmpro/backend/internal/etims"
+)
+
+func TestEtimsRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{etimsMaxAttempts, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := etimsRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("etimsRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestEtimsUnconfigured(t *testing.T) {
+	s := &Server{}
+	handlers := map[string]http.HandlerFunc{
+		"generate": s.handleEtimsGenerateReceipt,
+		"retry":    s.handleEtimsRetryReceipt,
+		"init":     s.handleEtimsInitDevice,
+	}
+	for name, h := range handlers {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.SetPathValue("invoiceId", "1")
+		r.SetPathValue("id", "1")
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s without a client: status %d, want %d", name, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if got := s.etimsEnvironment(); got != "disabled" {
+		t.Errorf("environment without a client = %q, want disabled", got)
+	}
+}
+
+func TestEtimsNotice(t *testing.T) {
+	fake := &Server{etims: etims.NewFake()}
+	kra := &Server{etims: etims.NewHTTPClient("https://etims.example")}
+	if got := fake.etimsNotice(etimsAccepted); strings.Contains(got, "by KRA") {
+		t.Errorf("fake acceptance notice %q claims a KRA signature", got)
+	}
+	if got := kra.etimsNotice(etimsAccepted); got != "Tax receipt signed by KRA." {
+		t.Errorf("KRA acceptance notice = %q", got)
+	}
+	if got := fake.etimsNotice(etimsFailed); !strings.Contains(got, "retried") {
+		t.Errorf("failure notice = %q", got)
+	}
+}
+
+func TestEtimsNextAttempt(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := etimsNextAttempt(1, now); got == nil || !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("after the first attempt: %v, want %v", got, now.Add(time.Minute))
+	}
+	if got := etimsNextAttempt(etimsMaxAttempts-1, now); got == nil || !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("after attempt %d: %v, want %v", etimsMaxAttempts-1, got, now.Add(time.Hour))
+	}
+	for _, attempts := range []int{etimsMaxAttempts, etimsMaxAttempts + 3} {
+		if got := etimsNextAttempt(attempts, now); got != nil {
+			t.Errorf("after attempt %d: %v, want no retry", attempts, got)
+		}
+	}
+}
+
+func TestEtimsOutcome(t *testing.T) {
+	duplicate := &etims.RejectedError{Code: etims.ResultDuplicate, Message: "overlapped data"}
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+		want     string
+	}{
+		{"signed", nil, 1, etimsAccepted},
+		{"refused", &etims.RejectedError{Code: "910", Message: "request parameter error"}, 3, etimsRejected},
+		{"duplicate on the first attempt", duplicate, 1, etimsRejected},
+		{"duplicate after a lost answer", duplicate, 2, etimsAccepted},
+		{"transient", errors.New("etims request failed: timeout"), 2, etimsFailed},
+	}
+	for _, tt := range tests {
+		if got := etimsOutcome(tt.err, tt.attempts); got != tt.want {
+			t.Errorf("%s: outcome %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
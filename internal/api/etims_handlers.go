@@ -4,148 +4,277 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
+
+	"farmpro/backend/internal/etims"
+
+	"github.com/jackc/pgx/v5"
 )
 
 func (s *Server) handleEtimsReceipts(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	rows, err := s.db.Query(ctx, `
-		SELECT e.id, e.sale_id, e.invoice_number, e.status, e.submitted_at
+		SELECT e.id, e.invoice_id, i.kind, e.invoice_number, e.status, e.submitted_at, e.attempts, e.last_error,
+		       e.next_attempt_at, COALESCE(e.response->>'receiptNumber', '')
 		FROM etims_submissions e
+		JOIN invoices i ON i.id = e.invoice_id
+		WHERE e.farm_id = $1
 		ORDER BY e.submitted_at DESC, e.id DESC
 		LIMIT 100
-	`)
+	`, farmID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load local receipts"})
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load tax receipts"})
 		return
 	}
 	defer rows.Close()
 
 	out := make([]map[string]any, 0)
 	for rows.Next() {
-		var id, saleID int64
-		var invoiceNo, status string
+		var id, invoiceID int64
+		var kind, invoiceNo, status, lastError, receiptNo string
 		var generated time.Time
-		if err := rows.Scan(&id, &saleID, &invoiceNo, &status, &generated); err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse local receipts"})
+		var attempts int
+		var nextAttempt *time.Time
+		if err := rows.Scan(&id, &invoiceID, &kind, &invoiceNo, &status, &generated, &attempts, &lastError, &nextAttempt, &receiptNo); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse tax receipts"})
 			return
 		}
-		out = append(out, map[string]any{
+		item := map[string]any{
 			"id":            id,
-			"saleId":        saleID,
+			"invoiceId":     invoiceID,
+			"kind":          kind,
 			"invoiceNumber": invoiceNo,
 			"status":        status,
 			"generatedAt":   s.formatDateLong(generated),
-		})
+			"attempts":      attempts,
+			"lastError":     lastError,
+			"receiptNumber": receiptNo,
+			"nextAttemptAt": nil,
+		}
+		if nextAttempt != nil {
+			item["nextAttemptAt"] = nextAttempt.Format("2006-01-02T15:04:05")
+		}
+		out = append(out, item)
 	}
 	respondJSON(w, http.StatusOK, out)
 }
 
+// handleEtimsGenerateReceipt builds the eTIMS invoice for an invoice or credit
+// note and submits it straight away. A transient failure leaves it queued for
+// retry.
 func (s *Server) handleEtimsGenerateReceipt(w http.ResponseWriter, r *http.Request) {
-	saleID, err := parsePathID(r, "saleId")
-	if err != nil || saleID <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid sale id"})
+	if s.etims == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "eTIMS is not configured"})
+		return
+	}
+	invoiceID, err := parsePathID(r, "invoiceId")
+	if err != nil || invoiceID <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	var saleDate time.Time
-	var product, quantityUnit, buyer, buyerPIN, county, subcounty string
-	var quantityValue, pricePerUnit, totalAmount, vatRate, vatAmount, netAmount float64
-	var vatApplicable bool
+	var invoiceDate time.Time
+	var kind, invoiceNumber, reason, buyer, buyerPIN, county, subcounty, supplierPIN, originalNumber, originalStatus string
+	var netAmount, vatAmount, totalAmount float64
 	err = s.db.QueryRow(ctx, `
-		SELECT sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin, delivery_county, delivery_subcounty,
-		       vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount
-		FROM sales
-		WHERE id = $1
-	`, saleID).Scan(
-		&saleDate, &product, &quantityValue, &quantityUnit, &buyer, &buyerPIN, &county, &subcounty,
-		&vatApplicable, &vatRate, &vatAmount, &netAmount, &pricePerUnit, &totalAmount,
+		SELECT i.kind, i.invoice_number, i.invoice_date, i.reason, i.buyer, i.buyer_pin, i.delivery_county, i.delivery_subcounty,
+		       i.net_amount, i.vat_amount, i.total_amount, COALESCE(f.kra_pin, ''),
+		       COALESCE(o.invoice_number, ''), COALESCE(oe.status, '')
+		FROM invoices i
+		JOIN farms f ON f.id = i.farm_id
+		LEFT JOIN invoices o ON o.id = i.original_invoice_id
+		LEFT JOIN etims_submissions oe ON oe.invoice_id = o.id
+		WHERE i.id = $1 AND i.farm_id = $2
+	`, invoiceID, farmID).Scan(
+		&kind, &invoiceNumber, &invoiceDate, &reason, &buyer, &buyerPIN, &county, &subcounty,
+		&netAmount, &vatAmount, &totalAmount, &supplierPIN, &originalNumber, &originalStatus,
 	)
 	if err != nil {
-		respondJSON(w, http.StatusNotFound, map[string]string{"error": "sale not found"})
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+		return
+	}
+	lines, err := s.loadInvoiceLines(ctx, invoiceID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load invoice lines"})
 		return
 	}
 
-	invoiceNumber := fmt.Sprintf("FP-%06d", saleID)
-	taxableAmount := netAmount
-	if !vatApplicable || vatRate <= 0 {
-		vatRate = 0
-		vatAmount = 0
-		taxableAmount = totalAmount
+	if supplierPIN == "" {
+		supplierPIN = s.kraPIN
+	}
+	if supplierPIN == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "set the farm's KRA PIN before submitting to eTIMS"})
+		return
+	}
+	// KRA only accepts a credit note against an invoice it has signed.
+	if kind == invoiceKindCreditNote && originalStatus != etimsAccepted {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "submit invoice " + originalNumber + " to eTIMS before its credit notes"})
+		return
 	}
 
-	payload := map[string]any{
-		"invoiceNumber": invoiceNumber,
-		"invoiceDate":   s.formatISODate(saleDate),
-		"currency":      "KES",
-		"supplierPin":   s.kraPIN,
-		"buyerName":     buyer,
-		"buyerPin":      buyerPIN,
-		"county":        county,
-		"subcounty":     subcounty,
-		"items": []map[string]any{
-			{
-				"description":   product,
-				"quantity":      quantityValue,
-				"unit":          quantityUnit,
-				"unitPrice":     pricePerUnit,
-				"vatApplicable": vatApplicable,
-				"taxRate":       vatRate,
-				"total":         totalAmount,
-			},
+	invoice := etims.Invoice{
+		ReceiptType:   etims.ReceiptSale,
+		InvoiceNumber: invoiceNumber,
+		InvoiceDate:   s.formatISODate(invoiceDate),
+		Currency:      "KES",
+		SupplierPIN:   supplierPIN,
+		BuyerName:     buyer,
+		BuyerPIN:      buyerPIN,
+		County:        county,
+		Subcounty:     subcounty,
+		Items:         make([]etims.InvoiceItem, 0, len(lines)),
+		Summary: etims.Summary{
+			TaxableAmount: netAmount,
+			VATAmount:     vatAmount,
+			GrossAmount:   totalAmount,
 		},
-		"summary": map[string]any{
-			"taxableAmount": taxableAmount,
-			"vatAmount":     vatAmount,
-			"grossAmount":   totalAmount,
-		},
-		"environment": "local",
-		"mode":        "receipt_only",
 	}
-	payloadJSON, err := json.Marshal(payload)
+	if kind == invoiceKindCreditNote {
+		invoice.ReceiptType = etims.ReceiptCreditNote
+		invoice.OriginalInvoiceNumber = originalNumber
+		invoice.Reason = reason
+	}
+	for _, l := range lines {
+		invoice.Items = append(invoice.Items, etims.InvoiceItem{
+			Description:   l.product,
+			Quantity:      l.quantity,
+			Unit:          l.unit,
+			UnitPrice:     l.price,
+			VATApplicable: l.vatClass != "A",
+			TaxType:       l.vatClass,
+			TaxRate:       l.vatRate,
+			Total:         l.total,
+		})
+	}
+	payloadJSON, err := json.Marshal(invoice)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to encode local receipt"})
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to encode tax receipt"})
 		return
 	}
 
-	responseJSON, _ := json.Marshal(map[string]any{
-		"message": "generated locally",
-		"at":      s.now().Format(time.RFC3339),
+	var receiptID int64
+	var current string
+	err = s.db.QueryRow(ctx, `SELECT id, status FROM etims_submissions WHERE invoice_id = $1 AND farm_id = $2`, invoiceID, farmID).Scan(&receiptID, &current)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save tax receipt"})
+		return
+	}
+	switch current {
+	case etimsAccepted:
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "this invoice was already accepted by KRA"})
+		return
+	case etimsSubmitted:
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "this invoice is being submitted to KRA"})
+		return
+	}
+
+	// A failed attempt may have reached KRA, which then answers the next one
+	// as a duplicate, so regenerating a failed receipt keeps its count.
+	action := auditUpdate
+	if receiptID == 0 {
+		action = auditCreate
+	}
+	receiptID, err = s.auditedWrite(ctx, r, action, "etims_submissions", receiptID, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO etims_submissions(invoice_id, invoice_number, status, payload, submitted_at, farm_id)
+			VALUES ($1, $2, 'pending', $3::jsonb, NOW(), $4)
+			ON CONFLICT (invoice_id) DO UPDATE SET
+				invoice_number = EXCLUDED.invoice_number,
+				status = EXCLUDED.status,
+				payload = EXCLUDED.payload,
+				submitted_at = EXCLUDED.submitted_at,
+				response = NULL,
+				attempts = CASE WHEN etims_submissions.status = 'failed' THEN etims_submissions.attempts ELSE 0 END,
+				last_error = '',
+				next_attempt_at = NULL,
+				updated_at = NOW()
+			RETURNING id
+		`, invoiceID, invoiceNumber, string(payloadJSON), farmID).Scan(&id)
+		return id, err
 	})
+	if err != nil || receiptID == 0 {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save tax receipt"})
+		return
+	}
 
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO etims_submissions(sale_id, invoice_number, status, payload, submitted_at, response)
-		VALUES ($1, $2, 'local_generated', $3::jsonb, NOW(), $4::jsonb)
-		ON CONFLICT (sale_id) DO UPDATE SET
-			invoice_number = EXCLUDED.invoice_number,
-			status = EXCLUDED.status,
-			payload = EXCLUDED.payload,
-			submitted_at = EXCLUDED.submitted_at,
-			response = EXCLUDED.response
-	`, saleID, invoiceNumber, string(payloadJSON), string(responseJSON))
+	result, err := s.submitEtimsReceipt(ctx, r, farmID, receiptID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save local receipt"})
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to submit tax receipt"})
 		return
 	}
 
 	respondJSON(w, http.StatusOK, map[string]any{
-		"ok":            true,
-		"saleId":        saleID,
+		"ok":            result.status != etimsRejected,
+		"id":            receiptID,
+		"invoiceId":     invoiceID,
 		"invoiceNumber": invoiceNumber,
-		"status":        "local_generated",
-		"payload":       payload,
-		"notice":        "Tax receipt generated.",
+		"status":        result.status,
+		"payload":       invoice,
+		"receipt":       result.receipt,
+		"error":         result.message,
+		"notice":        s.etimsNotice(result.status),
 	})
 }
 
+func (s *Server) handleEtimsRetryReceipt(w http.ResponseWriter, r *http.Request) {
+	if s.etims == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "eTIMS is not configured"})
+		return
+	}
+	receiptID, err := parsePathID(r, "id")
+	if err != nil || receiptID <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid receipt id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	result, err := s.submitEtimsReceipt(ctx, r, farmID, receiptID)
+	if err != nil {
+		if errors.Is(err, errEtimsNotSubmittable) {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "only pending or failed receipts can be submitted"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to submit tax receipt"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"ok":      result.status != etimsRejected,
+		"id":      receiptID,
+		"status":  result.status,
+		"receipt": result.receipt,
+		"error":   result.message,
+		"notice":  s.etimsNotice(result.status),
+	})
+}
+
+func (s *Server) etimsNotice(status string) string {
+	switch {
+	case status == etimsAccepted && s.etimsEnvironment() == "local":
+		return "Tax receipt signed locally for testing. It was not sent to KRA."
+	case status == etimsAccepted:
+		return "Tax receipt signed by KRA."
+	case status == etimsRejected:
+		return "KRA rejected the invoice. Correct it and generate the receipt again."
+	default:
+		return "KRA could not be reached. The receipt will be retried automatically."
+	}
+}
+
 func (s *Server) handleEtimsDownloadReceipt(w http.ResponseWriter, r *http.Request) {
 	receiptID, err := parsePathID(r, "id")
 	if err != nil || receiptID <= 0 {
@@ -155,14 +284,15 @@ func (s *Server) handleEtimsDownloadReceipt(w http.ResponseWriter, r *http.Reque
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	var invoiceNumber string
-	var payloadRaw []byte
+	var invoiceNumber, status string
+	var payloadRaw, responseRaw []byte
 	err = s.db.QueryRow(ctx, `
-		SELECT invoice_number, payload
+		SELECT invoice_number, payload, status, response
 		FROM etims_submissions
-		WHERE id = $1
-	`, receiptID).Scan(&invoiceNumber, &payloadRaw)
+		WHERE id = $1 AND farm_id = $2
+	`, receiptID, farmID).Scan(&invoiceNumber, &payloadRaw, &status, &responseRaw)
 	if err != nil {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "receipt not found"})
 		return
@@ -178,6 +308,20 @@ func (s *Server) handleEtimsDownloadReceipt(w http.ResponseWriter, r *http.Reque
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "invalid receipt payload"})
 		return
 	}
+	// Only an accepted receipt carries KRA's signature and QR data.
+	payload["status"] = status
+	if status == etimsAccepted && len(responseRaw) > 0 {
+		var receipt etims.Receipt
+		// A duplicate acceptance has no signature; its answer was lost.
+		if err := json.Unmarshal(responseRaw, &receipt); err == nil && receipt.Signature != "" {
+			payload["kraReceiptNumber"] = receipt.ReceiptNumber
+			payload["kraSignature"] = receipt.Signature
+			payload["kraInternalData"] = receipt.InternalData
+			payload["kraSdcId"] = receipt.SDCID
+			payload["kraQrData"] = receipt.QRData
+			payload["kraSignedAt"] = receipt.SignedAt.Format("2006-01-02T15:04:05")
+		}
+	}
 
 	filenameBase := reportFilename("receipt-" + strings.ToLower(invoiceNumber))
 	switch format {
@@ -201,8 +345,18 @@ func (s *Server) handleEtimsDownloadReceipt(w http.ResponseWriter, r *http.Reque
 		_ = cw.Write([]string{"buyerPin", asString(payload["buyerPin"])})
 		_ = cw.Write([]string{"county", asString(payload["county"])})
 		_ = cw.Write([]string{"subcounty", asString(payload["subcounty"])})
+		if original := asString(payload["originalInvoiceNumber"]); original != "" {
+			_ = cw.Write([]string{"originalInvoiceNumber", original})
+			_ = cw.Write([]string{"reason", asString(payload["reason"])})
+		}
+		_ = cw.Write([]string{"status", asString(payload["status"])})
+		for _, key := range etimsReceiptFields {
+			if v, ok := payload[key]; ok {
+				_ = cw.Write([]string{key, asString(v)})
+			}
+		}
 		_ = cw.Write([]string{})
-		_ = cw.Write([]string{"description", "quantity", "unit", "unitPrice", "taxRate", "total"})
+		_ = cw.Write([]string{"description", "quantity", "unit", "unitPrice", "taxType", "taxRate", "total"})
 
 		if items, ok := payload["items"].([]any); ok {
 			for _, it := range items {
@@ -212,6 +366,7 @@ func (s *Server) handleEtimsDownloadReceipt(w http.ResponseWriter, r *http.Reque
 					asString(row["quantity"]),
 					asString(row["unit"]),
 					asString(row["unitPrice"]),
+					asString(row["taxType"]),
 					asString(row["taxRate"]),
 					asString(row["total"]),
 				})
@@ -234,6 +389,8 @@ func (s *Server) handleEtimsDownloadReceipt(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+var etimsReceiptFields = []string{"kraReceiptNumber", "kraSignature", "kraInternalData", "kraSdcId", "kraQrData", "kraSignedAt"}
+
 func receiptPayloadAsReport(receiptID int64, invoiceNumber string, payload map[string]any) reportContent {
 	summary := map[string]any{
 		"invoiceNumber": asString(payload["invoiceNumber"]),
@@ -244,6 +401,18 @@ func receiptPayloadAsReport(receiptID int64, invoiceNumber string, payload map[s
 		"buyerPin":      asString(payload["buyerPin"]),
 		"county":        asString(payload["county"]),
 		"subcounty":     asString(payload["subcounty"]),
+		"status":        asString(payload["status"]),
+	}
+	title := "Tax Receipt " + invoiceNumber
+	if original := asString(payload["originalInvoiceNumber"]); original != "" {
+		title = "Credit Note " + invoiceNumber
+		summary["originalInvoiceNumber"] = original
+		summary["reason"] = asString(payload["reason"])
+	}
+	for _, key := range etimsReceiptFields {
+		if v, ok := payload[key]; ok {
+			summary[key] = asString(v)
+		}
 	}
 	if totals, ok := payload["summary"].(map[string]any); ok {
 		for k, v := range totals {
@@ -261,6 +430,7 @@ func receiptPayloadAsReport(receiptID int64, invoiceNumber string, payload map[s
 					"unit":          asString(row["unit"]),
 					"unitPrice":     asString(row["unitPrice"]),
 					"vatApplicable": asString(row["vatApplicable"]),
+					"taxType":       asString(row["taxType"]),
 					"taxRate":       asString(row["taxRate"]),
 					"total":         asString(row["total"]),
 				})
@@ -270,7 +440,7 @@ func receiptPayloadAsReport(receiptID int64, invoiceNumber string, payload map[s
 
 	return reportContent{
 		ID:          receiptID,
-		Title:       "Tax Receipt " + invoiceNumber,
+		Title:       title,
 		Category:    "Financial",
 		DateRange:   "Single receipt",
 		Format:      "PDF",
@@ -5,33 +5,46 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"strings"
 	"time"
 )
 
 func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var totalAnimals, sickAnimals, upcomingVaccines int64
 	var monthlyGrossRevenue, monthlyNetRevenue, monthlyVATCollected float64
 
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE is_active = true`).Scan(&totalAnimals)
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE health_status <> 'healthy' AND is_active = true`).Scan(&sickAnimals)
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM health_records WHERE next_due >= CURRENT_DATE AND next_due <= CURRENT_DATE + INTERVAL '7 days'`).Scan(&upcomingVaccines)
+	// ?date rebuilds the herd counts from lifecycle events as of that day.
+	var asOf *time.Time
+	if raw := strings.TrimSpace(r.URL.Query().Get("date")); raw != "" {
+		d, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		asOf = &d
+	}
+
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE farm_id = $1 AND is_active = true`, farmID).Scan(&totalAnimals)
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE farm_id = $1 AND health_status <> 'healthy' AND is_active = true`, farmID).Scan(&sickAnimals)
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM health_tasks t JOIN animals a ON a.id = t.animal_id WHERE t.farm_id = $1 AND t.status = 'scheduled' AND a.is_active AND t.due_date >= CURRENT_DATE AND t.due_date <= CURRENT_DATE + INTERVAL '7 days'`, farmID).Scan(&upcomingVaccines)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(total_amount), 0), COALESCE(SUM(net_amount), 0), COALESCE(SUM(vat_amount), 0)
-		FROM sales
-		WHERE DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&monthlyGrossRevenue, &monthlyNetRevenue, &monthlyVATCollected)
+		FROM sales_lines
+		WHERE farm_id = $1 AND DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&monthlyGrossRevenue, &monthlyNetRevenue, &monthlyVATCollected)
 
 	typeCounts := make([]map[string]any, 0)
 	rows, err := s.db.Query(ctx, `
 		SELECT type, COUNT(*)
 		FROM animals
-		WHERE is_active = true
+		WHERE farm_id = $1 AND is_active = true
 		GROUP BY type
 		ORDER BY type
-	`)
+	`, farmID)
 	if err == nil {
 		for rows.Next() {
 			var typ string
@@ -47,14 +60,23 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		rows.Close()
 	}
 
+	herdDate := s.now()
+	if asOf != nil {
+		herdDate = *asOf
+		if total, byType, err := s.herdCounts(ctx, farmID, herdDate); err == nil {
+			totalAnimals = total
+			typeCounts = byType
+		}
+	}
+
 	typeAttention := make([]map[string]any, 0)
 	rows, err = s.db.Query(ctx, `
 		SELECT type, COUNT(*)
 		FROM animals
-		WHERE is_active = true AND health_status <> 'healthy'
+		WHERE farm_id = $1 AND is_active = true AND health_status <> 'healthy'
 		GROUP BY type
 		ORDER BY type
-	`)
+	`, farmID)
 	if err == nil {
 		for rows.Next() {
 			var typ string
@@ -80,12 +102,14 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		"monthlyVATCollected":  monthlyVATCollected,
 		"animalTypeCounts":     typeCounts,
 		"animalTypeAttention":  typeAttention,
+		"herdDate":             s.formatISODate(herdDate),
 	})
 }
 
 func (s *Server) handleAnimals(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	search := parseSearch(r)
@@ -95,9 +119,9 @@ func (s *Server) handleAnimals(w http.ResponseWriter, r *http.Request) {
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM animals
-		WHERE is_active = true
+		WHERE farm_id = $2 AND is_active = true
 			AND ($1 = '' OR tag_id ILIKE '%' || $1 || '%' OR type ILIKE '%' || $1 || '%' OR breed ILIKE '%' || $1 || '%')
-	`, search).Scan(&total)
+	`, search, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT id, tag_id, type, breed, birth_date,
@@ -108,13 +132,17 @@ func (s *Server) handleAnimals(w http.ResponseWriter, r *http.Request) {
 				ELSE EXTRACT(YEAR FROM AGE(CURRENT_DATE, birth_date))::int::text || ' years'
 			END AS age,
 			COALESCE(weight_kg::text || ' kg', 'N/A') AS weight,
-			health_status, status
+			health_status, status, sex,
+			COALESCE((SELECT m.tag_id FROM animals m WHERE m.id = animals.mother_id), ''),
+			COALESCE((SELECT f.tag_id FROM animals f WHERE f.id = animals.father_id), sire_name),
+			(SELECT MAX(h.milk_withdrawal_until) FROM health_records h WHERE h.animal_id = animals.id AND h.milk_withdrawal_until >= $5),
+			(SELECT MAX(h.meat_withdrawal_until) FROM health_records h WHERE h.animal_id = animals.id AND h.meat_withdrawal_until >= $5)
 		FROM animals
-		WHERE is_active = true
+		WHERE farm_id = $4 AND is_active = true
 			AND ($1 = '' OR tag_id ILIKE '%' || $1 || '%' OR type ILIKE '%' || $1 || '%' OR breed ILIKE '%' || $1 || '%')
 		ORDER BY tag_id
 		LIMIT $2 OFFSET $3
-	`, search, pageSize, offset)
+	`, search, pageSize, offset, farmID, s.today())
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load animals"})
 		return
@@ -125,8 +153,9 @@ func (s *Server) handleAnimals(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var id int64
 		var birthDate *time.Time
-		var tagID, typ, breed, age, weight, health, status string
-		if err := rows.Scan(&id, &tagID, &typ, &breed, &birthDate, &age, &weight, &health, &status); err != nil {
+		var tagID, typ, breed, age, weight, health, status, sex, dam, sire string
+		var milkUntil, meatUntil *time.Time
+		if err := rows.Scan(&id, &tagID, &typ, &breed, &birthDate, &age, &weight, &health, &status, &sex, &dam, &sire, &milkUntil, &meatUntil); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse animals"})
 			return
 		}
@@ -134,16 +163,28 @@ func (s *Server) handleAnimals(w http.ResponseWriter, r *http.Request) {
 		if birthDate != nil {
 			birthDateRaw = s.formatISODate(*birthDate)
 		}
+		var milk, meat any
+		if milkUntil != nil {
+			milk = s.formatISODate(*milkUntil)
+		}
+		if meatUntil != nil {
+			meat = s.formatISODate(*meatUntil)
+		}
 		out = append(out, map[string]any{
-			"id":        id,
-			"tagId":     tagID,
-			"type":      typ,
-			"breed":     breed,
-			"birthDate": birthDateRaw,
-			"age":       age,
-			"weight":    weight,
-			"health":    health,
-			"status":    status,
+			"id":                  id,
+			"tagId":               tagID,
+			"type":                typ,
+			"breed":               breed,
+			"birthDate":           birthDateRaw,
+			"age":                 age,
+			"weight":              weight,
+			"health":              health,
+			"status":              status,
+			"sex":                 sex,
+			"dam":                 dam,
+			"sire":                sire,
+			"milkWithdrawalUntil": milk,
+			"meatWithdrawalUntil": meat,
 		})
 	}
 
@@ -158,16 +199,18 @@ func (s *Server) handleAnimals(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUpcomingVaccinations(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
+	// Overdue tasks stay on the list until they are done or skipped.
 	rows, err := s.db.Query(ctx, `
-		SELECT a.tag_id, a.type, h.treatment, h.next_due,
-			(h.next_due - CURRENT_DATE)::int
-		FROM health_records h
-		JOIN animals a ON a.id = h.animal_id
-		WHERE h.next_due >= CURRENT_DATE
-		ORDER BY h.next_due
+		SELECT t.id, a.tag_id, a.type, t.action, t.treatment, t.due_date,
+			(t.due_date - CURRENT_DATE)::int
+		FROM health_tasks t
+		JOIN animals a ON a.id = t.animal_id
+		WHERE t.farm_id = $1 AND t.status = 'scheduled' AND a.is_active
+		ORDER BY t.due_date, t.id
 		LIMIT 20
-	`)
+	`, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load upcoming vaccinations"})
 		return
@@ -176,19 +219,23 @@ func (s *Server) handleUpcomingVaccinations(w http.ResponseWriter, r *http.Reque
 
 	out := make([]map[string]any, 0)
 	for rows.Next() {
-		var id, animal, treatment string
+		var taskID int64
+		var id, animal, action, treatment string
 		var due time.Time
 		var days int
-		if err := rows.Scan(&id, &animal, &treatment, &due, &days); err != nil {
+		if err := rows.Scan(&taskID, &id, &animal, &action, &treatment, &due, &days); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse upcoming vaccinations"})
 			return
 		}
 		out = append(out, map[string]any{
 			"id":        id,
+			"taskId":    taskID,
 			"animal":    animal,
+			"action":    action,
 			"treatment": treatment,
 			"dueDate":   s.formatDate(due),
-			"remaining": fmt.Sprintf("%d days remaining", days),
+			"remaining": formatDaysRemaining(days),
+			"overdue":   days < 0,
 		})
 	}
 
@@ -198,13 +245,17 @@ func (s *Server) handleUpcomingVaccinations(w http.ResponseWriter, r *http.Reque
 func (s *Server) handleHealthRecords(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	rows, err := s.db.Query(ctx, `
-		SELECT h.id, a.tag_id, h.action, h.treatment, h.record_date, h.veterinarian, h.next_due
+		SELECT h.id, a.tag_id, h.action, h.treatment, h.record_date, h.veterinarian, h.next_due,
+			h.medicine_id, h.batch_id, h.dose_quantity::float8, h.dose_unit, h.milk_withdrawal_until, h.meat_withdrawal_until,
+			h.case_id
 		FROM health_records h
 		JOIN animals a ON a.id = h.animal_id
+		WHERE h.farm_id = $1
 		ORDER BY h.record_date DESC
-	`)
+	`, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load health records"})
 		return
@@ -217,7 +268,11 @@ func (s *Server) handleHealthRecords(w http.ResponseWriter, r *http.Request) {
 		var animalID, action, treatment, vet string
 		var date time.Time
 		var nextDue *time.Time
-		if err := rows.Scan(&id, &animalID, &action, &treatment, &date, &vet, &nextDue); err != nil {
+		var medicineID, batchID, caseID *int64
+		var dose *float64
+		var doseUnit string
+		var milkUntil, meatUntil *time.Time
+		if err := rows.Scan(&id, &animalID, &action, &treatment, &date, &vet, &nextDue, &medicineID, &batchID, &dose, &doseUnit, &milkUntil, &meatUntil, &caseID); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse health records"})
 			return
 		}
@@ -225,14 +280,28 @@ func (s *Server) handleHealthRecords(w http.ResponseWriter, r *http.Request) {
 		if nextDue != nil {
 			next = s.formatDate(*nextDue)
 		}
+		var milk, meat any
+		if milkUntil != nil {
+			milk = s.formatISODate(*milkUntil)
+		}
+		if meatUntil != nil {
+			meat = s.formatISODate(*meatUntil)
+		}
 		out = append(out, map[string]any{
-			"id":        id,
-			"animalId":  animalID,
-			"action":    action,
-			"treatment": treatment,
-			"date":      s.formatDate(date),
-			"vet":       vet,
-			"nextDue":   next,
+			"id":                  id,
+			"animalId":            animalID,
+			"action":              action,
+			"treatment":           treatment,
+			"date":                s.formatDate(date),
+			"vet":                 vet,
+			"nextDue":             next,
+			"medicineId":          medicineID,
+			"batchId":             batchID,
+			"doseQuantity":        dose,
+			"doseUnit":            doseUnit,
+			"milkWithdrawalUntil": milk,
+			"meatWithdrawalUntil": meat,
+			"caseId":              caseID,
 		})
 	}
 
@@ -242,6 +311,7 @@ func (s *Server) handleHealthRecords(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleBreedingActive(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT b.id, m.tag_id, COALESCE(f.tag_id, ''), b.species, b.breeding_date, b.heat_date, b.ai_date, b.on_heat,
@@ -249,9 +319,9 @@ func (s *Server) handleBreedingActive(w http.ResponseWriter, r *http.Request) {
 		FROM breeding_records b
 		JOIN animals m ON m.id = b.mother_animal_id
 		LEFT JOIN animals f ON f.id = b.father_animal_id
-		WHERE b.status = 'active'
+		WHERE b.farm_id = $1 AND b.status = 'active'
 		ORDER BY COALESCE(b.expected_birth_date, b.breeding_date) DESC
-	`)
+	`, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load breeding records"})
 		return
@@ -327,6 +397,7 @@ func (s *Server) handleBreedingActive(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleBreedingPoultryActive(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT p.id, h.tag_id, COALESCE(r.tag_id, ''), p.species, p.egg_set_date, p.hatch_date,
@@ -334,9 +405,9 @@ func (s *Server) handleBreedingPoultryActive(w http.ResponseWriter, r *http.Requ
 		FROM poultry_breeding_records p
 		JOIN animals h ON h.id = p.hen_animal_id
 		LEFT JOIN animals r ON r.id = p.rooster_animal_id
-		WHERE p.status = 'active'
+		WHERE p.farm_id = $1 AND p.status = 'active'
 		ORDER BY p.egg_set_date DESC
-	`)
+	`, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load poultry breeding records"})
 		return
@@ -378,15 +449,16 @@ func (s *Server) handleBreedingPoultryActive(w http.ResponseWriter, r *http.Requ
 func (s *Server) handleBreedingBirths(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	rows, err := s.db.Query(ctx, `
 		SELECT m.tag_id, b.species, b.actual_birth_date, COALESCE(b.offspring_count, 0), m.health_status
 		FROM breeding_records b
 		JOIN animals m ON m.id = b.mother_animal_id
-		WHERE b.actual_birth_date IS NOT NULL
+		WHERE b.farm_id = $1 AND b.actual_birth_date IS NOT NULL
 		ORDER BY b.actual_birth_date DESC
 		LIMIT 20
-	`)
+	`, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load recent births"})
 		return
@@ -417,6 +489,7 @@ func (s *Server) handleBreedingBirths(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleProductionSummary(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var milk, eggs, wool, meat, value float64
 	var milkCow, milkGoat float64
@@ -429,9 +502,9 @@ func (s *Server) handleProductionSummary(w http.ResponseWriter, r *http.Request)
 			COALESCE(SUM(wool_kg),0),
 			COALESCE(SUM(meat_kg),0),
 			COALESCE(SUM(total_value),0)
-		FROM production_logs
-		WHERE log_date >= CURRENT_DATE - INTERVAL '6 days'
-	`).Scan(&milk, &milkCow, &milkGoat, &eggs, &wool, &meat, &value)
+		FROM production_daily
+		WHERE farm_id = $1 AND log_date >= CURRENT_DATE - INTERVAL '6 days'
+	`, farmID).Scan(&milk, &milkCow, &milkGoat, &eggs, &wool, &meat, &value)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load production summary"})
 		return
@@ -440,10 +513,11 @@ func (s *Server) handleProductionSummary(w http.ResponseWriter, r *http.Request)
 	var previousValue float64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(total_value),0)
-		FROM production_logs
-		WHERE log_date >= CURRENT_DATE - INTERVAL '13 days'
+		FROM production_daily
+		WHERE farm_id = $1
+			AND log_date >= CURRENT_DATE - INTERVAL '13 days'
 			AND log_date < CURRENT_DATE - INTERVAL '6 days'
-	`).Scan(&previousValue)
+	`, farmID).Scan(&previousValue)
 
 	productivityChange := 0.0
 	if previousValue > 0 {
@@ -466,18 +540,20 @@ func (s *Server) handleProductionSummary(w http.ResponseWriter, r *http.Request)
 func (s *Server) handleProductionLogs(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	page, pageSize := parsePagination(r)
 	offset := (page - 1) * pageSize
 	var total int64
-	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM production_logs`).Scan(&total)
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM production_daily WHERE farm_id = $1`, farmID).Scan(&total)
 
 	rows, err := s.db.Query(ctx, `
-		SELECT id, log_date, milk_liters, milk_cow_liters, milk_goat_liters, eggs_count, wool_kg, meat_kg, total_value
-		FROM production_logs
+		SELECT log_date, milk_liters, milk_cow_liters, milk_goat_liters, eggs_count, wool_kg, meat_kg, total_value, record_count, herd_record_count
+		FROM production_daily
+		WHERE farm_id = $3
 		ORDER BY log_date DESC
 		LIMIT $1 OFFSET $2
-	`, pageSize, offset)
+	`, pageSize, offset, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load production logs"})
 		return
@@ -486,15 +562,14 @@ func (s *Server) handleProductionLogs(w http.ResponseWriter, r *http.Request) {
 
 	out := make([]map[string]any, 0)
 	for rows.Next() {
-		var id int64
 		var d time.Time
 		var milk, milkCow, milkGoat, eggs, wool, meat, total float64
-		if err := rows.Scan(&id, &d, &milk, &milkCow, &milkGoat, &eggs, &wool, &meat, &total); err != nil {
+		var records, herdRecords int64
+		if err := rows.Scan(&d, &milk, &milkCow, &milkGoat, &eggs, &wool, &meat, &total, &records, &herdRecords); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse production logs"})
 			return
 		}
 		out = append(out, map[string]any{
-			"id":         id,
 			"date":       s.formatDateCompact(d),
 			"dateRaw":    s.formatISODate(d),
 			"milk":       fmt.Sprintf("%.0f L", milk),
@@ -511,6 +586,8 @@ func (s *Server) handleProductionLogs(w http.ResponseWriter, r *http.Request) {
 			"meatValue":  meat,
 			"total":      formatKES(total),
 			"totalValue": total,
+			"records":    records,
+			"herdRecords": herdRecords,
 		})
 	}
 
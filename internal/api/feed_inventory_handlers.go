@@ -0,0 +1,820 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	feedMoveReceipt    = "receipt"
+	feedMoveIssue      = "issue"
+	feedMoveAdjustment = "adjustment"
+	feedMoveCount      = "count"
+
+	// An item projected to run out within this many days is reported low even
+	// when it is still above its reorder level.
+	feedLowStockDays = 7
+)
+
+// rationPart is one ingredient of a ration as the feed store sees it: the
+// recipe amount and the unit the store counts the ingredient in.
+type rationPart struct {
+	feedItemID int64
+	quantity   float64
+	unit       string
+	stockUnit  string
+	density    float64
+}
+
+const rationPartsQuery = `
+	SELECT i.ration_id, i.feed_item_id, i.quantity_value, i.quantity_unit, f.unit, f.name_key, COALESCE(f.density, 0)
+	FROM feeding_ration_items i
+	JOIN feed_items f ON f.id = i.feed_item_id
+	WHERE i.ration_id = ANY($1) AND i.quantity_value > 0
+	ORDER BY i.id
+`
+
+func scanRationParts(rows pgx.Rows) (map[int64][]rationPart, error) {
+	defer rows.Close()
+	out := map[int64][]rationPart{}
+	for rows.Next() {
+		var rationID int64
+		var p rationPart
+		var nameKey string
+		if err := rows.Scan(&rationID, &p.feedItemID, &p.quantity, &p.unit, &p.stockUnit, &nameKey, &p.density); err != nil {
+			return nil, err
+		}
+		p.density = feedDensity(nameKey, p.density)
+		out[rationID] = append(out[rationID], p)
+	}
+	return out, rows.Err()
+}
+
+// splitRation shares qty of a ration, in unit, across its ingredients by
+// their share of the recipe, and returns what each draws from stock in the
+// unit the store counts it in. Ingredients that cannot be converted to unit
+// take no share; those the store cannot convert to are left out of the issue.
+func splitRation(parts []rationPart, qty float64, unit string) map[int64]float64 {
+	shares := make([]float64, len(parts))
+	var total float64
+	for i, p := range parts {
+		if v, ok := convertQuantity(p.quantity, p.unit, unit, p.density); ok && v > 0 {
+			shares[i] = v
+			total += v
+		}
+	}
+	out := map[int64]float64{}
+	if total <= 0 {
+		return out
+	}
+	for i, p := range parts {
+		if shares[i] == 0 {
+			continue
+		}
+		if v, ok := convertQuantity(qty*shares[i]/total, unit, p.stockUnit, p.density); ok {
+			out[p.feedItemID] += v
+		}
+	}
+	return out
+}
+
+type feedStock struct {
+	id           int64
+	name         string
+	unit         string
+	reorderLevel float64
+	notes        string
+	density      *float64
+	onHand       float64
+	averageCost  float64
+	lastCount    *time.Time
+	dailyUse     float64
+}
+
+func (f feedStock) daysRemaining() *float64 {
+	if f.dailyUse <= 0 {
+		return nil
+	}
+	days := f.onHand / f.dailyUse
+	if days < 0 {
+		days = 0
+	}
+	days = roundCents(days)
+	return &days
+}
+
+func (f feedStock) status() string {
+	if f.onHand <= 0 {
+		return "out"
+	}
+	if f.reorderLevel > 0 && f.onHand <= f.reorderLevel {
+		return "low"
+	}
+	if days := f.daysRemaining(); days != nil && *days < feedLowStockDays {
+		return "low"
+	}
+	return "ok"
+}
+
+func (s *Server) formatFeedStock(f feedStock) map[string]any {
+	lastCount := ""
+	if f.lastCount != nil {
+		lastCount = s.formatISODate(*f.lastCount)
+	}
+	return map[string]any{
+		"id":            f.id,
+		"name":          f.name,
+		"unit":          f.unit,
+		"reorderLevel":  f.reorderLevel,
+		"notes":         f.notes,
+		"density":       f.density,
+		"onHand":        roundCents(f.onHand),
+		"averageCost":   roundCents(f.averageCost),
+		"value":         roundCents(f.onHand * f.averageCost),
+		"lastCount":     lastCount,
+		"dailyUse":      roundCents(f.dailyUse),
+		"daysRemaining": f.daysRemaining(),
+		"status":        f.status(),
+	}
+}
+
+// feedStockLevels returns every feed store item with what is on hand and how
+// fast active feeding plans are drawing it down.
+func (s *Server) feedStockLevels(ctx context.Context, farmID int64) ([]feedStock, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT f.id, f.name, f.unit, f.reorder_level, f.notes, f.density,
+		       COALESCE(SUM(m.quantity), 0),
+		       COALESCE(SUM(m.quantity * m.unit_cost) FILTER (WHERE m.kind = 'receipt')
+		                / NULLIF(SUM(m.quantity) FILTER (WHERE m.kind = 'receipt'), 0), 0),
+		       MAX(m.movement_date) FILTER (WHERE m.kind = 'count')
+		FROM feed_items f
+		LEFT JOIN feed_stock_movements m ON m.feed_item_id = f.id
+		WHERE f.farm_id = $1
+		GROUP BY f.id
+		ORDER BY f.name
+	`, farmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]feedStock, 0)
+	byID := map[int64]int{}
+	for rows.Next() {
+		var f feedStock
+		if err := rows.Scan(&f.id, &f.name, &f.unit, &f.reorderLevel, &f.notes, &f.density, &f.onHand, &f.averageCost, &f.lastCount); err != nil {
+			return nil, err
+		}
+		byID[f.id] = len(items)
+		items = append(items, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Daily use comes from the plans running today, each split across its
+	// ration the way a feeding record would be.
+	type plan struct {
+		rationID int64
+		qty      float64
+		unit     string
+	}
+	rows, err = s.db.Query(ctx, `
+		SELECT ration_id, daily_quantity_value, daily_quantity_unit
+		FROM feeding_plans
+		WHERE farm_id = $1 AND status = 'active' AND ration_id IS NOT NULL AND daily_quantity_value > 0
+			AND start_date <= $2 AND (end_date IS NULL OR end_date >= $2)
+	`, farmID, s.now())
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]plan, 0)
+	rationIDs := make([]int64, 0)
+	for rows.Next() {
+		var p plan
+		if err := rows.Scan(&p.rationID, &p.qty, &p.unit); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		plans = append(plans, p)
+		rationIDs = append(rationIDs, p.rationID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return items, nil
+	}
+
+	rows, err = s.db.Query(ctx, rationPartsQuery, rationIDs)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := scanRationParts(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plans {
+		for id, use := range splitRation(parts[p.rationID], p.qty, p.unit) {
+			if i, ok := byID[id]; ok {
+				items[i].dailyUse += use
+			}
+		}
+	}
+	return items, nil
+}
+
+// issueFeedingRecord draws what a feeding record fed out of the feed store,
+// replacing whatever it issued before. Ration feeds issue each ingredient by
+// its share of the ration; other feeds issue the store item of the same name.
+func issueFeedingRecord(ctx context.Context, tx pgx.Tx, recordID int64, feedType string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM feed_stock_movements WHERE feeding_record_id = $1`, recordID); err != nil {
+		return err
+	}
+	var farmID int64
+	var d time.Time
+	var rationID *int64
+	var qty float64
+	var unit string
+	err := tx.QueryRow(ctx, `
+		SELECT farm_id, feed_date, ration_id, quantity_value, quantity_unit
+		FROM feeding_records
+		WHERE id = $1
+	`, recordID).Scan(&farmID, &d, &rationID, &qty, &unit)
+	if err != nil || qty <= 0 {
+		return err
+	}
+
+	var parts []rationPart
+	if rationID != nil {
+		rows, err := tx.Query(ctx, rationPartsQuery, []int64{*rationID})
+		if err != nil {
+			return err
+		}
+		byRation, err := scanRationParts(rows)
+		if err != nil {
+			return err
+		}
+		parts = byRation[*rationID]
+	} else {
+		p := rationPart{quantity: 1, unit: unit}
+		var nameKey string
+		err := tx.QueryRow(ctx, `
+			SELECT id, unit, name_key, COALESCE(density, 0)
+			FROM feed_items
+			WHERE farm_id = $1 AND name_key = $2
+		`, farmID, feedItemKey(feedType)).Scan(&p.feedItemID, &p.stockUnit, &nameKey, &p.density)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p.density = feedDensity(nameKey, p.density)
+		parts = []rationPart{p}
+	}
+
+	for id, issued := range splitRation(parts, qty, unit) {
+		issued = roundCents(issued)
+		if issued <= 0 {
+			continue
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO feed_stock_movements(farm_id, feed_item_id, movement_date, kind, quantity, feeding_record_id)
+			VALUES ($1, $2, $3, 'issue', $4, $5)
+		`, farmID, id, d, -issued, recordID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkFeedItem finds the feed store item a ration ingredient is drawn from,
+// creating it in the ingredient's unit when the store does not have it yet.
+func linkFeedItem(ctx context.Context, tx pgx.Tx, farmID int64, ingredient, unit string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO feed_items(farm_id, name, name_key, unit)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (farm_id, name_key) DO UPDATE SET name_key = EXCLUDED.name_key
+		RETURNING id
+	`, farmID, strings.Join(strings.Fields(ingredient), " "), feedItemKey(ingredient), unit).Scan(&id)
+	return id, err
+}
+
+type feedItemInput struct {
+	Name         string   `json:"name"`
+	Unit         string   `json:"unit"`
+	ReorderLevel float64  `json:"reorderLevel"`
+	Density      *float64 `json:"density"`
+	Notes        string   `json:"notes"`
+}
+
+func normalizeFeedItemInput(in feedItemInput) (feedItemInput, string) {
+	in.Name = strings.Join(strings.Fields(in.Name), " ")
+	in.Notes = strings.TrimSpace(in.Notes)
+	var ok bool
+	if in.Unit, ok = normalizeUnit(in.Unit, "kg"); !ok {
+		return in, "unit must be one of " + unitCodes()
+	}
+	if in.Name == "" {
+		return in, "name is required"
+	}
+	if in.ReorderLevel < 0 {
+		return in, "reorderLevel must be non-negative"
+	}
+	if in.Density != nil && *in.Density <= 0 {
+		return in, "density must be positive kg per litre"
+	}
+	return in, ""
+}
+
+func (s *Server) handleFeedStock(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	items, err := s.feedStockLevels(ctx, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load feed stock"})
+		return
+	}
+
+	search := strings.ToLower(parseSearch(r))
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	out := make([]map[string]any, 0, len(items))
+	var value float64
+	var low, empty int
+	for _, f := range items {
+		value += f.onHand * f.averageCost
+		switch f.status() {
+		case "low":
+			low++
+		case "out":
+			empty++
+		}
+		if search != "" && !strings.Contains(strings.ToLower(f.name), search) {
+			continue
+		}
+		if status != "" && f.status() != status {
+			continue
+		}
+		out = append(out, s.formatFeedStock(f))
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items": out,
+		"total": len(out),
+		"summary": map[string]any{
+			"items": len(items),
+			"low":   low,
+			"out":   empty,
+			"value": roundCents(value),
+		},
+	})
+}
+
+func (s *Server) handleCreateFeedItem(w http.ResponseWriter, r *http.Request) {
+	var in feedItemInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in, msg := normalizeFeedItemInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "feed_items", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO feed_items(farm_id, name, name_key, unit, reorder_level, density, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, farmID, in.Name, feedItemKey(in.Name), in.Unit, in.ReorderLevel, in.Density, in.Notes).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a feed item with this name already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create feed item"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+// handleUpdateFeedItem edits a store item. Stock already booked is in the
+// item's unit, so the unit is fixed once anything has moved.
+func (s *Server) handleUpdateFeedItem(w http.ResponseWriter, r *http.Request) {
+	itemID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid feed item id"})
+		return
+	}
+	var in feedItemInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in, msg := normalizeFeedItemInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "feed_items", itemID, func(tx pgx.Tx) (int64, error) {
+		var unit string
+		var used bool
+		err := tx.QueryRow(ctx, `
+			SELECT unit,
+			       EXISTS (SELECT 1 FROM feed_stock_movements WHERE feed_item_id = f.id)
+			    OR EXISTS (SELECT 1 FROM purchase_order_lines WHERE feed_item_id = f.id)
+			FROM feed_items f
+			WHERE id = $1 AND farm_id = $2
+			FOR UPDATE
+		`, itemID, farmID).Scan(&unit, &used)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if used && unit != in.Unit {
+			return 0, &httpError{http.StatusConflict, "stock of this item is already kept in " + unit + "; the unit can no longer change"}
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE feed_items
+			SET name = $1, name_key = $2, unit = $3, reorder_level = $4, density = $5, notes = $6
+			WHERE id = $7
+		`, in.Name, feedItemKey(in.Name), in.Unit, in.ReorderLevel, in.Density, in.Notes, itemID)
+		if err != nil {
+			return 0, err
+		}
+		return itemID, nil
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a feed item with this name already exists"})
+			return
+		}
+		respondHTTPError(w, err, "failed to update feed item")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "feed item not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeleteFeedItem(w http.ResponseWriter, r *http.Request) {
+	itemID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid feed item id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var used bool
+	_ = s.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM feed_stock_movements WHERE feed_item_id = $1)
+		    OR EXISTS (SELECT 1 FROM purchase_order_lines WHERE feed_item_id = $1)
+	`, itemID).Scan(&used)
+	if used {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "this feed item has stock movements or orders and cannot be deleted"})
+		return
+	}
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "feed_items", itemID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM feed_items WHERE id = $1 AND farm_id = $2`, itemID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return itemID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete feed item"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "feed item not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleFeedItemMovements is an item's stock ledger, newest first, with the
+// balance after each movement.
+func (s *Server) handleFeedItemMovements(w http.ResponseWriter, r *http.Request) {
+	itemID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid feed item id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+
+	var name, unit string
+	if err := s.db.QueryRow(ctx, `SELECT name, unit FROM feed_items WHERE id = $1 AND farm_id = $2`, itemID, farmID).Scan(&name, &unit); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "feed item not found"})
+		return
+	}
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM feed_stock_movements WHERE feed_item_id = $1`, itemID).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, movement_date, kind, quantity, counted_quantity, unit_cost, notes, feeding_record_id, grn_number, balance
+		FROM (
+			SELECT m.id, m.movement_date, m.kind, m.quantity, m.counted_quantity, m.unit_cost, m.notes, m.feeding_record_id,
+			       COALESCE(g.grn_number, '') AS grn_number,
+			       SUM(m.quantity) OVER (ORDER BY m.movement_date, m.id) AS balance
+			FROM feed_stock_movements m
+			LEFT JOIN goods_receipt_lines gl ON gl.id = m.goods_receipt_line_id
+			LEFT JOIN goods_receipts g ON g.id = gl.goods_receipt_id
+			WHERE m.feed_item_id = $1
+		) t
+		ORDER BY movement_date DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, itemID, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load stock movements"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var d time.Time
+		var kind, notes, grnNumber string
+		var qty, cost, balance float64
+		var counted *float64
+		var recordID *int64
+		if err := rows.Scan(&id, &d, &kind, &qty, &counted, &cost, &notes, &recordID, &grnNumber, &balance); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse stock movements"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":              id,
+			"date":            s.formatDateCompact(d),
+			"dateRaw":         s.formatISODate(d),
+			"kind":            kind,
+			"quantity":        qty,
+			"countedQuantity": counted,
+			"unitCost":        cost,
+			"notes":           notes,
+			"feedingRecordId": recordID,
+			"grnNumber":       grnNumber,
+			"balance":         roundCents(balance),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"feedItem": map[string]any{"id": itemID, "name": name, "unit": unit},
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// handleCreateFeedStockMovement books stock in or out of the store by hand.
+// Receipts and issues take a positive quantity, adjustments a signed one. A
+// count takes what was found on the shelf and books the difference from the
+// balance on that date.
+func (s *Server) handleCreateFeedStockMovement(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		FeedItemID int64   `json:"feedItemId"`
+		Date       string  `json:"date"`
+		Kind       string  `json:"kind"`
+		Quantity   float64 `json:"quantity"`
+		UnitCost   float64 `json:"unitCost"`
+		Notes      string  `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	in.Notes = strings.TrimSpace(in.Notes)
+	if in.FeedItemID <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "feedItemId is required"})
+		return
+	}
+	if in.UnitCost < 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "unitCost must be non-negative"})
+		return
+	}
+	quantity := in.Quantity
+	switch in.Kind {
+	case feedMoveReceipt, feedMoveIssue:
+		if in.Quantity <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must be positive"})
+			return
+		}
+		if in.Kind == feedMoveIssue {
+			quantity = -in.Quantity
+		}
+	case feedMoveAdjustment:
+		if in.Quantity == 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must not be zero"})
+			return
+		}
+		if in.Notes == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "notes must give the reason for an adjustment"})
+			return
+		}
+	case feedMoveCount:
+		if in.Quantity < 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must be non-negative"})
+			return
+		}
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be receipt, issue, adjustment or count"})
+		return
+	}
+	d := s.now()
+	if in.Date != "" {
+		parsed, err := time.Parse("2006-01-02", in.Date)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		d = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var booked float64
+	id, err := s.auditedWrite(ctx, r, auditCreate, "feed_stock_movements", 0, func(tx pgx.Tx) (int64, error) {
+		// Lock the item so a count and a concurrent movement cannot both use
+		// the same balance.
+		var balance float64
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE((SELECT SUM(quantity) FROM feed_stock_movements WHERE feed_item_id = f.id AND movement_date <= $3), 0)
+			FROM feed_items f
+			WHERE f.id = $1 AND f.farm_id = $2
+			FOR UPDATE
+		`, in.FeedItemID, farmID, d).Scan(&balance)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusBadRequest, "feed item not found"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		var counted *float64
+		if in.Kind == feedMoveCount {
+			counted = &in.Quantity
+			quantity = roundCents(in.Quantity - balance)
+		}
+		var createdBy *int64
+		if userID > 0 {
+			createdBy = &userID
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO feed_stock_movements(farm_id, feed_item_id, movement_date, kind, quantity, counted_quantity, unit_cost, notes, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id
+		`, farmID, in.FeedItemID, d, in.Kind, quantity, counted, in.UnitCost, in.Notes, createdBy).Scan(&id)
+		booked = quantity
+		return id, err
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to record stock movement")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "quantity": booked})
+}
+
+// handleDeleteFeedStockMovement removes a movement booked by hand. Movements
+// booked by goods received notes and feeding records follow their source.
+func (s *Server) handleDeleteFeedStockMovement(w http.ResponseWriter, r *http.Request) {
+	movementID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid movement id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "feed_stock_movements", movementID, func(tx pgx.Tx) (int64, error) {
+		var fromReceipt, fromFeeding bool
+		err := tx.QueryRow(ctx, `
+			SELECT goods_receipt_line_id IS NOT NULL, feeding_record_id IS NOT NULL
+			FROM feed_stock_movements
+			WHERE id = $1 AND farm_id = $2
+			FOR UPDATE
+		`, movementID, farmID).Scan(&fromReceipt, &fromFeeding)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if fromReceipt {
+			return 0, &httpError{http.StatusConflict, "this movement was booked by a goods received note; reverse the note instead"}
+		}
+		if fromFeeding {
+			return 0, &httpError{http.StatusConflict, "this movement was booked by a feeding record; edit or delete the record instead"}
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM feed_stock_movements WHERE id = $1`, movementID); err != nil {
+			return 0, err
+		}
+		return movementID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete stock movement")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "movement not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// feedDensities returns kg per litre by feed name key: the built-in
+// densities overridden by what the farm has set on its store items.
+func (s *Server) feedDensities(ctx context.Context, farmID int64) map[string]float64 {
+	out := make(map[string]float64, len(ingredientDensities))
+	for k, v := range ingredientDensities {
+		out[k] = v
+	}
+	rows, err := s.db.Query(ctx, `SELECT name_key, density FROM feed_items WHERE farm_id = $1 AND density IS NOT NULL`, farmID)
+	if err != nil {
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var density float64
+		if err := rows.Scan(&key, &density); err == nil {
+			out[key] = density
+		}
+	}
+	return out
+}
+
+// feedQuantityKg totals what feeding records fed between start and end in
+// kg. Quantities that cannot be weighed, such as counts or liquids with no
+// known density, are totalled separately in their base unit.
+func (s *Server) feedQuantityKg(ctx context.Context, farmID int64, start, end time.Time) (float64, map[string]float64, error) {
+	densities := s.feedDensities(ctx, farmID)
+	rows, err := s.db.Query(ctx, `
+		SELECT feed_type, quantity_unit, SUM(quantity_value)
+		FROM feeding_records
+		WHERE farm_id = $1 AND feed_date BETWEEN $2 AND $3
+		GROUP BY feed_type, quantity_unit
+	`, farmID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	var kg float64
+	other := map[string]float64{}
+	for rows.Next() {
+		var feedType, unit string
+		var qty float64
+		if err := rows.Scan(&feedType, &unit, &qty); err != nil {
+			return 0, nil, err
+		}
+		if v, ok := convertQuantity(qty, unit, "kg", densities[feedItemKey(feedType)]); ok {
+			kg += v
+		} else if v, base, ok := toBaseUnit(qty, unit); ok {
+			other[base] += v
+		} else {
+			other[unit] += qty
+		}
+	}
+	return roundCents(kg), other, rows.Err()
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitRation(t *testing.T) {
+	// A dairy ration of 3 kg maize germ, 1 kg cotton cake and 1 L molasses,
+	// with the molasses counted in litres and the cake in 50 kg bags.
+	parts := []rationPart{
+		{feedItemID: 1, quantity: 3, unit: "kg", stockUnit: "kg"},
+		{feedItemID: 2, quantity: 1, unit: "kg", stockUnit: "bag-50kg"},
+		{feedItemID: 3, quantity: 1, unit: "L", stockUnit: "L", density: 1.4},
+	}
+	tests := []struct {
+		name  string
+		parts []rationPart
+		qty   float64
+		unit  string
+		want  map[int64]float64
+	}{
+		{"fed by weight", parts, 54, "kg", map[int64]float64{1: 30, 2: 0.2, 3: 10}},
+		{"fed by the bag", parts, 1.08, "bag-50kg", map[int64]float64{1: 30, 2: 0.2, 3: 10}},
+		// Without a density the molasses cannot be weighed, so the rest of
+		// the ration takes the whole feed.
+		{"ingredient without density", []rationPart{parts[0], parts[1], {feedItemID: 3, quantity: 1, unit: "L", stockUnit: "L"}}, 40, "kg", map[int64]float64{1: 30, 2: 0.2}},
+		{"fed by the head", parts, 10, "head", map[int64]float64{}},
+		{"empty ration", nil, 10, "kg", map[int64]float64{}},
+	}
+	for _, tt := range tests {
+		got := splitRation(tt.parts, tt.qty, tt.unit)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for id, want := range tt.want {
+			if math.Abs(got[id]-want) > 0.001 {
+				t.Errorf("%s: item %d draws %v, want %v", tt.name, id, got[id], want)
+			}
+		}
+	}
+}
+
+func TestFeedStockStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		stock  feedStock
+		days   float64 // -1 when nothing draws on the item
+		status string
+	}{
+		{"nothing on hand", feedStock{onHand: 0, dailyUse: 5}, 0, "out"},
+		{"overdrawn", feedStock{onHand: -12, dailyUse: 5}, 0, "out"},
+		{"at the reorder level", feedStock{onHand: 100, reorderLevel: 100}, -1, "low"},
+		{"runs out within a week", feedStock{onHand: 60, reorderLevel: 20, dailyUse: 10}, 6, "low"},
+		{"a week left", feedStock{onHand: 70, dailyUse: 10}, 7, "ok"},
+		{"not in use", feedStock{onHand: 5}, -1, "ok"},
+	}
+	for _, tt := range tests {
+		days := tt.stock.daysRemaining()
+		switch {
+		case tt.days < 0 && days != nil:
+			t.Errorf("%s: %v days remaining, want none", tt.name, *days)
+		case tt.days >= 0 && (days == nil || *days != tt.days):
+			t.Errorf("%s: days remaining %v, want %v", tt.name, days, tt.days)
+		}
+		if got := tt.stock.status(); got != tt.status {
+			t.Errorf("%s: status %s, want %s", tt.name, got, tt.status)
+		}
+	}
+}
+
+func TestNormalizeFeedItemInput(t *testing.T) {
+	zero := 0.0
+	tests := []struct {
+		name string
+		in   feedItemInput
+		unit string
+		msg  string
+	}{
+		{"default unit", feedItemInput{Name: "  Dairy   meal "}, "kg", ""},
+		{"alias", feedItemInput{Name: "Molasses", Unit: "litres"}, "L", ""},
+		{"no name", feedItemInput{Unit: "kg"}, "kg", "name is required"},
+		{"unknown unit", feedItemInput{Name: "Hay", Unit: "bales"}, "", "unit must be one of " + unitCodes()},
+		{"negative reorder level", feedItemInput{Name: "Hay", ReorderLevel: -1}, "kg", "reorderLevel must be non-negative"},
+		{"zero density", feedItemInput{Name: "Molasses", Unit: "L", Density: &zero}, "L", "density must be positive kg per litre"},
+	}
+	for _, tt := range tests {
+		got, msg := normalizeFeedItemInput(tt.in)
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg == "" && got.Unit != tt.unit {
+			t.Errorf("%s: unit %q, want %q", tt.name, got.Unit, tt.unit)
+		}
+	}
+	if got, _ := normalizeFeedItemInput(feedItemInput{Name: "  Dairy   meal "}); got.Name != "Dairy meal" {
+		t.Errorf("name %q, want %q", got.Name, "Dairy meal")
+	}
+}
+
+// A movement is checked before the store is touched.
+func TestCreateFeedStockMovementRejects(t *testing.T) {
+	s := &Server{}
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"kind":"receipt","quantity":5}`, "feedItemId is required"},
+		{`{"feedItemId":1,"kind":"receipt","quantity":5,"unitCost":-1}`, "unitCost must be non-negative"},
+		{`{"feedItemId":1,"kind":"issue","quantity":-5}`, "quantity must be positive"},
+		{`{"feedItemId":1,"kind":"adjustment","quantity":0,"notes":"spilt"}`, "quantity must not be zero"},
+		{`{"feedItemId":1,"kind":"adjustment","quantity":-3}`, "notes must give the reason for an adjustment"},
+		{`{"feedItemId":1,"kind":"count","quantity":-1}`, "quantity must be non-negative"},
+		{`{"feedItemId":1,"kind":"transfer","quantity":5}`, "kind must be receipt, issue, adjustment or count"},
+		{`{"feedItemId":1,"kind":"receipt","quantity":5,"date":"12/03/2026"}`, "date must be YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.handleCreateFeedStockMovement(w, httptest.NewRequest(http.MethodPost, "/api/feeding/stock/movements", strings.NewReader(tt.body)))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.err) {
+			t.Errorf("%s: %d %s, want 400 %q", tt.body, w.Code, strings.TrimSpace(w.Body.String()), tt.err)
+		}
+	}
+}
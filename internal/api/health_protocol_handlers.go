@@ -0,0 +1,1074 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type healthProtocolStep struct {
+	id         int64
+	action     string
+	treatment  string
+	everyCount int
+	everyUnit  string
+	minAgeDays int
+}
+
+// after is when the step falls due again if it was last given on d.
+func (st healthProtocolStep) after(d time.Time) time.Time {
+	switch st.everyUnit {
+	case "day":
+		return d.AddDate(0, 0, st.everyCount)
+	case "week":
+		return d.AddDate(0, 0, 7*st.everyCount)
+	case "year":
+		return d.AddDate(st.everyCount, 0, 0)
+	default:
+		return d.AddDate(0, st.everyCount, 0)
+	}
+}
+
+// firstDue is when an animal first needs the step: one interval after it
+// last had the treatment, or today, held back until it is old enough.
+func (st healthProtocolStep) firstDue(today time.Time, last, birthDate *time.Time) time.Time {
+	switch {
+	case last != nil:
+		return st.after(*last)
+	case birthDate != nil && st.minAgeDays > 0:
+		if old := birthDate.AddDate(0, 0, st.minAgeDays); old.After(today) {
+			return old
+		}
+	}
+	return today
+}
+
+func (st healthProtocolStep) label() string {
+	if st.everyCount == 1 {
+		return "every " + st.everyUnit
+	}
+	return fmt.Sprintf("every %d %ss", st.everyCount, st.everyUnit)
+}
+
+type healthProtocol struct {
+	id         int64
+	name       string
+	species    string
+	autoAssign bool
+	isActive   bool
+	notes      string
+	steps      []healthProtocolStep
+}
+
+// matches reports whether the protocol is meant for an animal of type
+// animalType; "Cattle", "Cow" and "Heifer" are all the same herd.
+func (p healthProtocol) matches(animalType string) bool {
+	return normalizeSpeciesName(p.species) == normalizeSpeciesName(animalType)
+}
+
+func healthProtocolJSON(p healthProtocol) map[string]any {
+	steps := make([]map[string]any, 0, len(p.steps))
+	for _, st := range p.steps {
+		steps = append(steps, map[string]any{
+			"id":         st.id,
+			"action":     st.action,
+			"treatment":  st.treatment,
+			"everyCount": st.everyCount,
+			"everyUnit":  st.everyUnit,
+			"minAgeDays": st.minAgeDays,
+			"label":      st.label(),
+		})
+	}
+	return map[string]any{
+		"id":         p.id,
+		"name":       p.name,
+		"species":    p.species,
+		"autoAssign": p.autoAssign,
+		"isActive":   p.isActive,
+		"notes":      p.notes,
+		"steps":      steps,
+	}
+}
+
+type rowQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// loadHealthProtocols returns the farm's protocols with their steps, or just
+// protocol id when it is not 0.
+func loadHealthProtocols(ctx context.Context, q rowQuerier, farmID, id int64) ([]healthProtocol, error) {
+	rows, err := q.Query(ctx, `
+		SELECT p.id, p.name, p.species, p.auto_assign, p.is_active, p.notes,
+			s.id, s.action, s.treatment, s.every_count, s.every_unit, s.min_age_days
+		FROM health_protocols p
+		LEFT JOIN health_protocol_steps s ON s.protocol_id = p.id
+		WHERE p.farm_id = $1 AND ($2 = 0 OR p.id = $2)
+		ORDER BY p.species, p.name, p.id, s.position, s.id
+	`, farmID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []healthProtocol
+	for rows.Next() {
+		var p healthProtocol
+		var stepID *int64
+		var action, treatment, unit *string
+		var count, minAge *int
+		if err := rows.Scan(&p.id, &p.name, &p.species, &p.autoAssign, &p.isActive, &p.notes,
+			&stepID, &action, &treatment, &count, &unit, &minAge); err != nil {
+			return nil, err
+		}
+		if len(out) == 0 || out[len(out)-1].id != p.id {
+			out = append(out, p)
+		}
+		if stepID != nil {
+			last := &out[len(out)-1]
+			last.steps = append(last.steps, healthProtocolStep{
+				id: *stepID, action: *action, treatment: *treatment, everyCount: *count, everyUnit: *unit, minAgeDays: *minAge,
+			})
+		}
+	}
+	return out, rows.Err()
+}
+
+type healthProtocolInput struct {
+	Name       string `json:"name"`
+	Species    string `json:"species"`
+	AutoAssign *bool  `json:"autoAssign"`
+	IsActive   *bool  `json:"isActive"`
+	Notes      string `json:"notes"`
+	Steps      []struct {
+		ID         int64  `json:"id"`
+		Action     string `json:"action"`
+		Treatment  string `json:"treatment"`
+		EveryCount int    `json:"everyCount"`
+		EveryUnit  string `json:"everyUnit"`
+		MinAgeDays int    `json:"minAgeDays"`
+	} `json:"steps"`
+}
+
+func normalizeHealthProtocolInput(in healthProtocolInput) (healthProtocol, string) {
+	p := healthProtocol{
+		name:       strings.Join(strings.Fields(in.Name), " "),
+		species:    strings.TrimSpace(in.Species),
+		autoAssign: true,
+		isActive:   true,
+		notes:      strings.TrimSpace(in.Notes),
+	}
+	if in.AutoAssign != nil {
+		p.autoAssign = *in.AutoAssign
+	}
+	if in.IsActive != nil {
+		p.isActive = *in.IsActive
+	}
+	if p.name == "" || p.species == "" {
+		return p, "name and species are required"
+	}
+	if len(p.name) > 120 {
+		return p, "name is too long"
+	}
+	if len(in.Steps) == 0 {
+		return p, "a protocol needs at least one step"
+	}
+	if len(in.Steps) > 50 {
+		return p, "a protocol can have at most 50 steps"
+	}
+	for i, raw := range in.Steps {
+		st := healthProtocolStep{
+			id:         raw.ID,
+			action:     strings.TrimSpace(raw.Action),
+			treatment:  strings.TrimSpace(raw.Treatment),
+			everyCount: raw.EveryCount,
+			everyUnit:  strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw.EveryUnit)), "s"),
+			minAgeDays: raw.MinAgeDays,
+		}
+		if st.action == "" {
+			st.action = "Vaccination"
+		}
+		if st.treatment == "" {
+			return p, fmt.Sprintf("step %d: treatment is required", i+1)
+		}
+		switch st.everyUnit {
+		case "day", "week", "month", "year":
+		default:
+			return p, fmt.Sprintf("step %d: everyUnit must be day, week, month or year", i+1)
+		}
+		if st.everyCount < 1 || st.everyCount > 400 {
+			return p, fmt.Sprintf("step %d: everyCount must be between 1 and 400", i+1)
+		}
+		if st.minAgeDays < 0 || st.minAgeDays > 3650 {
+			return p, fmt.Sprintf("step %d: minAgeDays must be between 0 and 3650", i+1)
+		}
+		p.steps = append(p.steps, st)
+	}
+	return p, ""
+}
+
+// saveHealthProtocolSteps makes protocolID's steps match steps. Steps sent
+// with an id are edited in place so the tasks they already scheduled stay
+// attached; steps left out are removed and their open tasks cancelled.
+func saveHealthProtocolSteps(ctx context.Context, tx pgx.Tx, protocolID int64, steps []healthProtocolStep) error {
+	keep := make([]int64, 0, len(steps))
+	for i, st := range steps {
+		if st.id != 0 {
+			res, err := tx.Exec(ctx, `
+				UPDATE health_protocol_steps
+				SET action = $1, treatment = $2, every_count = $3, every_unit = $4, min_age_days = $5, position = $6
+				WHERE id = $7 AND protocol_id = $8
+			`, st.action, st.treatment, st.everyCount, st.everyUnit, st.minAgeDays, i, st.id, protocolID)
+			if err != nil {
+				return err
+			}
+			if res.RowsAffected() == 0 {
+				return &httpError{http.StatusBadRequest, fmt.Sprintf("step %d does not belong to this protocol", st.id)}
+			}
+			// Open tasks take the new name; their due dates stand.
+			if _, err := tx.Exec(ctx, `
+				UPDATE health_tasks SET action = $1, treatment = $2
+				WHERE step_id = $3 AND status = 'scheduled'
+			`, st.action, st.treatment, st.id); err != nil {
+				return err
+			}
+			keep = append(keep, st.id)
+			continue
+		}
+		var id int64
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO health_protocol_steps(protocol_id, action, treatment, every_count, every_unit, min_age_days, position)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, protocolID, st.action, st.treatment, st.everyCount, st.everyUnit, st.minAgeDays, i).Scan(&id); err != nil {
+			return err
+		}
+		keep = append(keep, id)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE health_tasks SET status = 'cancelled'
+		WHERE protocol_id = $1 AND status = 'scheduled' AND NOT (step_id = ANY($2))
+	`, protocolID, keep); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM health_protocol_steps WHERE protocol_id = $1 AND NOT (id = ANY($2))`, protocolID, keep)
+	return err
+}
+
+// assignHealthProtocol puts an animal on p and schedules each step it has no
+// open task for. The first dose falls one interval after the animal last had
+// that treatment, or today, held back until the animal is old enough. An
+// animal out of the herd gets no tasks until it comes back. It returns how
+// many tasks were scheduled.
+func assignHealthProtocol(ctx context.Context, tx pgx.Tx, farmID, animalID int64, p healthProtocol, today time.Time) (int, error) {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO animal_health_protocols(animal_id, protocol_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, animalID, p.id); err != nil {
+		return 0, err
+	}
+	if !p.isActive {
+		return 0, nil
+	}
+	var birthDate *time.Time
+	var active bool
+	if err := tx.QueryRow(ctx, `SELECT birth_date, is_active FROM animals WHERE id = $1`, animalID).Scan(&birthDate, &active); err != nil {
+		return 0, err
+	}
+	if !active {
+		return 0, nil
+	}
+	scheduled := 0
+	for _, st := range p.steps {
+		var last *time.Time
+		if err := tx.QueryRow(ctx, `
+			SELECT MAX(record_date) FROM health_records
+			WHERE animal_id = $1 AND LOWER(treatment) = LOWER($2)
+		`, animalID, st.treatment).Scan(&last); err != nil {
+			return 0, err
+		}
+		due := st.firstDue(today, last, birthDate)
+		res, err := tx.Exec(ctx, `
+			INSERT INTO health_tasks(farm_id, animal_id, protocol_id, step_id, action, treatment, due_date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT DO NOTHING
+		`, farmID, animalID, p.id, st.id, st.action, st.treatment, due)
+		if err != nil {
+			return 0, err
+		}
+		scheduled += int(res.RowsAffected())
+	}
+	return scheduled, nil
+}
+
+// cancelAnimalHealthTasks closes the open tasks of an animal that left the
+// herd, so nothing is due for it and none of them can be completed.
+func cancelAnimalHealthTasks(ctx context.Context, tx pgx.Tx, animalID int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE health_tasks SET status = 'cancelled'
+		WHERE animal_id = $1 AND status = 'scheduled'
+	`, animalID)
+	return err
+}
+
+// rescheduleAnimalHealthTasks schedules the protocols an animal is still on
+// again when it comes back into the herd.
+func rescheduleAnimalHealthTasks(ctx context.Context, tx pgx.Tx, farmID, animalID int64, today time.Time) error {
+	protocols, err := loadHealthProtocols(ctx, tx, farmID, 0)
+	if err != nil {
+		return err
+	}
+	for _, p := range protocols {
+		var on bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM animal_health_protocols WHERE animal_id = $1 AND protocol_id = $2)
+		`, animalID, p.id).Scan(&on); err != nil {
+			return err
+		}
+		if !on {
+			continue
+		}
+		if _, err := assignHealthProtocol(ctx, tx, farmID, animalID, p, today); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAutoHealthProtocols puts a new animal on every active protocol for its
+// species that is set to assign itself.
+func (s *Server) applyAutoHealthProtocols(ctx context.Context, tx pgx.Tx, farmID, animalID int64, animalType string) error {
+	protocols, err := loadHealthProtocols(ctx, tx, farmID, 0)
+	if err != nil {
+		return err
+	}
+	for _, p := range protocols {
+		if !p.isActive || !p.autoAssign || !p.matches(animalType) {
+			continue
+		}
+		if _, err := assignHealthProtocol(ctx, tx, farmID, animalID, p, s.today()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleHealthProtocols(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	protocols, err := loadHealthProtocols(ctx, s.db, farmID, 0)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load health protocols"})
+		return
+	}
+	counts := map[int64][2]int64{}
+	rows, err := s.db.Query(ctx, `
+		SELECT p.id,
+			(SELECT COUNT(*) FROM animal_health_protocols ap JOIN animals a ON a.id = ap.animal_id
+				WHERE ap.protocol_id = p.id AND a.is_active),
+			(SELECT COUNT(*) FROM health_tasks t WHERE t.protocol_id = p.id AND t.status = 'scheduled')
+		FROM health_protocols p
+		WHERE p.farm_id = $1
+	`, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load health protocols"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, animals, open int64
+		if err := rows.Scan(&id, &animals, &open); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse health protocols"})
+			return
+		}
+		counts[id] = [2]int64{animals, open}
+	}
+
+	out := make([]map[string]any, 0, len(protocols))
+	for _, p := range protocols {
+		item := healthProtocolJSON(p)
+		item["animals"] = counts[p.id][0]
+		item["openTasks"] = counts[p.id][1]
+		out = append(out, item)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+func (s *Server) handleCreateHealthProtocol(w http.ResponseWriter, r *http.Request) {
+	var in healthProtocolInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	p, msg := normalizeHealthProtocolInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	for i := range p.steps {
+		p.steps[i].id = 0
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "health_protocols", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO health_protocols(farm_id, name, species, auto_assign, is_active, notes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, farmID, p.name, p.species, p.autoAssign, p.isActive, p.notes).Scan(&id)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+				return 0, &httpError{http.StatusConflict, "a protocol with this name already exists"}
+			}
+			return 0, err
+		}
+		return id, saveHealthProtocolSteps(ctx, tx, id, p.steps)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to create health protocol")
+		return
+	}
+	protocols, err := loadHealthProtocols(ctx, s.db, farmID, id)
+	if err != nil || len(protocols) == 0 {
+		respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "protocol": healthProtocolJSON(protocols[0])})
+}
+
+// handleUpdateHealthProtocol edits a protocol. Animals already on it get
+// tasks for any steps they have nothing open for, so adding a step or
+// switching the protocol back on takes effect right away.
+func (s *Server) handleUpdateHealthProtocol(w http.ResponseWriter, r *http.Request) {
+	protocolID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid protocol id"})
+		return
+	}
+	var in healthProtocolInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	p, msg := normalizeHealthProtocolInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	scheduled := 0
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "health_protocols", protocolID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE health_protocols
+			SET name = $1, species = $2, auto_assign = $3, is_active = $4, notes = $5
+			WHERE id = $6 AND farm_id = $7
+		`, p.name, p.species, p.autoAssign, p.isActive, p.notes, protocolID, farmID)
+		if err != nil {
+			if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+				return 0, &httpError{http.StatusConflict, "a protocol with this name already exists"}
+			}
+			return 0, err
+		}
+		if res.RowsAffected() == 0 {
+			return 0, nil
+		}
+		if err := saveHealthProtocolSteps(ctx, tx, protocolID, p.steps); err != nil {
+			return 0, err
+		}
+		protocols, err := loadHealthProtocols(ctx, tx, farmID, protocolID)
+		if err != nil || len(protocols) == 0 {
+			return 0, err
+		}
+		p = protocols[0]
+		animals, err := protocolAnimals(ctx, tx, protocolID)
+		if err != nil {
+			return 0, err
+		}
+		today := s.today()
+		for _, animalID := range animals {
+			n, err := assignHealthProtocol(ctx, tx, farmID, animalID, p, today)
+			if err != nil {
+				return 0, err
+			}
+			scheduled += n
+		}
+		return protocolID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to update health protocol")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "health protocol not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "protocol": healthProtocolJSON(p), "scheduled": scheduled})
+}
+
+func protocolAnimals(ctx context.Context, tx pgx.Tx, protocolID int64) ([]int64, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT ap.animal_id
+		FROM animal_health_protocols ap
+		JOIN animals a ON a.id = ap.animal_id
+		WHERE ap.protocol_id = $1 AND a.is_active
+		ORDER BY ap.animal_id
+	`, protocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleDeleteHealthProtocol(w http.ResponseWriter, r *http.Request) {
+	protocolID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid protocol id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	// Done tasks keep their history; open ones go with the protocol.
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "health_protocols", protocolID, func(tx pgx.Tx) (int64, error) {
+		if _, err := tx.Exec(ctx, `
+			UPDATE health_tasks SET status = 'cancelled'
+			WHERE protocol_id = $1 AND farm_id = $2 AND status = 'scheduled'
+		`, protocolID, farmID); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM health_protocols WHERE id = $1 AND farm_id = $2`, protocolID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return protocolID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete health protocol"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "health protocol not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleAssignHealthProtocol puts the listed animals, or with allOfSpecies
+// every active animal of the protocol's species, on the protocol.
+func (s *Server) handleAssignHealthProtocol(w http.ResponseWriter, r *http.Request) {
+	protocolID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid protocol id"})
+		return
+	}
+	var in struct {
+		AnimalTagIDs []string `json:"animalTagIds"`
+		AllOfSpecies bool     `json:"allOfSpecies"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	tags := make([]string, 0, len(in.AnimalTagIDs))
+	for _, raw := range in.AnimalTagIDs {
+		tag, ok := normalizeAnimalTag(raw)
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid tag ID %q", raw)})
+			return
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 && !in.AllOfSpecies {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagIds or allOfSpecies is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var assigned, scheduled int
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "health_protocols", protocolID, func(tx pgx.Tx) (int64, error) {
+		protocols, err := loadHealthProtocols(ctx, tx, farmID, protocolID)
+		if err != nil || len(protocols) == 0 {
+			return 0, err
+		}
+		p := protocols[0]
+		if !p.isActive {
+			return 0, &httpError{http.StatusConflict, "this protocol is switched off"}
+		}
+		rows, err := tx.Query(ctx, `
+			SELECT id, tag_id, type
+			FROM animals
+			WHERE farm_id = $1 AND is_active AND ($2 OR tag_id = ANY($3))
+			ORDER BY id
+		`, farmID, in.AllOfSpecies, tags)
+		if err != nil {
+			return 0, err
+		}
+		type candidate struct {
+			id  int64
+			tag string
+		}
+		var animals []candidate
+		found := map[string]bool{}
+		for rows.Next() {
+			var c candidate
+			var animalType string
+			if err := rows.Scan(&c.id, &c.tag, &animalType); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			found[c.tag] = true
+			if !p.matches(animalType) {
+				if !in.AllOfSpecies {
+					rows.Close()
+					return 0, &httpError{http.StatusBadRequest, fmt.Sprintf("%s is not %s", c.tag, p.species)}
+				}
+				continue
+			}
+			animals = append(animals, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		for _, tag := range tags {
+			if !found[tag] {
+				return 0, &httpError{http.StatusBadRequest, fmt.Sprintf("animal %s not found", tag)}
+			}
+		}
+		today := s.today()
+		for _, a := range animals {
+			n, err := assignHealthProtocol(ctx, tx, farmID, a.id, p, today)
+			if err != nil {
+				return 0, err
+			}
+			assigned++
+			scheduled += n
+		}
+		return protocolID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to assign health protocol")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "health protocol not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "assigned": assigned, "scheduled": scheduled})
+}
+
+func (s *Server) handleUnassignHealthProtocol(w http.ResponseWriter, r *http.Request) {
+	protocolID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid protocol id"})
+		return
+	}
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "health_protocols", protocolID, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		err := tx.QueryRow(ctx, `
+			DELETE FROM animal_health_protocols ap
+			USING animals a, health_protocols p
+			WHERE ap.animal_id = a.id AND ap.protocol_id = p.id
+				AND p.id = $1 AND p.farm_id = $2 AND a.tag_id = $3 AND a.farm_id = $2
+			RETURNING a.id
+		`, protocolID, farmID, tagID).Scan(&animalID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE health_tasks SET status = 'cancelled'
+			WHERE protocol_id = $1 AND animal_id = $2 AND status = 'scheduled'
+		`, protocolID, animalID); err != nil {
+			return 0, err
+		}
+		return protocolID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to remove animal from protocol"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal is not on this protocol"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleHealthTasks lists health tasks, by default the open ones soonest
+// first. status=all includes closed tasks.
+func (s *Server) handleHealthTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := strings.ToLower(strings.TrimSpace(q.Get("status")))
+	switch status {
+	case "":
+		status = "scheduled"
+	case "all":
+		status = ""
+	case "scheduled", "done", "skipped", "cancelled":
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be scheduled, done, skipped, cancelled or all"})
+		return
+	}
+	var tagID string
+	if raw := strings.TrimSpace(q.Get("animal")); raw != "" {
+		var ok bool
+		if tagID, ok = normalizeAnimalTag(raw); !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+			return
+		}
+	}
+	to, err := optionalDate(q.Get("to"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	const filter = `
+		FROM health_tasks t
+		JOIN animals a ON a.id = t.animal_id
+		LEFT JOIN health_protocols p ON p.id = t.protocol_id
+		WHERE t.farm_id = $1 AND ($2 = '' OR t.status = $2) AND ($3 = '' OR a.tag_id = $3)
+			AND ($4::date IS NULL OR t.due_date <= $4::date)
+	`
+	var total int64
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) `+filter, farmID, status, tagID, to).Scan(&total); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load health tasks"})
+		return
+	}
+	order := "t.due_date, t.id"
+	if status != "scheduled" {
+		order = "t.due_date DESC, t.id DESC"
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT t.id, a.tag_id, a.type, t.action, t.treatment, t.due_date, t.status,
+			COALESCE(p.id, 0), COALESCE(p.name, ''), t.health_record_id, t.completed_at
+		`+filter+`
+		ORDER BY `+order+`
+		LIMIT $5 OFFSET $6
+	`, farmID, status, tagID, to, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load health tasks"})
+		return
+	}
+	defer rows.Close()
+
+	today := s.today()
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, protocolID int64
+		var tag, animalType, action, treatment, taskStatus, protocol string
+		var due time.Time
+		var recordID *int64
+		var completedAt *time.Time
+		if err := rows.Scan(&id, &tag, &animalType, &action, &treatment, &due, &taskStatus, &protocolID, &protocol, &recordID, &completedAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse health tasks"})
+			return
+		}
+		item := map[string]any{
+			"id":             id,
+			"animalId":       tag,
+			"animal":         animalType,
+			"action":         action,
+			"treatment":      treatment,
+			"dueDate":        s.formatISODate(due),
+			"status":         taskStatus,
+			"overdue":        taskStatus == "scheduled" && due.Before(today),
+			"protocolId":     protocolID,
+			"protocol":       protocol,
+			"healthRecordId": recordID,
+			"completedAt":    nil,
+		}
+		if completedAt != nil {
+			item["completedAt"] = completedAt.Format("2006-01-02T15:04:05")
+		}
+		out = append(out, item)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+type openHealthTask struct {
+	animalID  int64
+	tag       string
+	action    string
+	treatment string
+	due       time.Time
+	// step is set while the task's protocol is active and the animal is
+	// still on it; only then does closing the task schedule the next one.
+	protocolID int64
+	step       *healthProtocolStep
+}
+
+// lockOpenHealthTask loads a scheduled task for closing, or returns
+// pgx.ErrNoRows if there is no such task left open.
+func lockOpenHealthTask(ctx context.Context, tx pgx.Tx, farmID, taskID int64) (openHealthTask, error) {
+	var t openHealthTask
+	var stepID *int64
+	var action, treatment, unit *string
+	var count, minAge *int
+	err := tx.QueryRow(ctx, `
+		SELECT t.animal_id, a.tag_id, t.action, t.treatment, t.due_date, COALESCE(p.id, 0),
+			s.id, s.action, s.treatment, s.every_count, s.every_unit, s.min_age_days
+		FROM health_tasks t
+		JOIN animals a ON a.id = t.animal_id
+		LEFT JOIN health_protocols p ON p.id = t.protocol_id AND p.is_active
+			AND EXISTS (SELECT 1 FROM animal_health_protocols ap WHERE ap.animal_id = t.animal_id AND ap.protocol_id = p.id)
+		LEFT JOIN health_protocol_steps s ON s.id = t.step_id AND p.id IS NOT NULL
+		WHERE t.id = $1 AND t.farm_id = $2 AND t.status = 'scheduled' AND a.is_active
+		FOR UPDATE OF t
+	`, taskID, farmID).Scan(&t.animalID, &t.tag, &t.action, &t.treatment, &t.due, &t.protocolID,
+		&stepID, &action, &treatment, &count, &unit, &minAge)
+	if err != nil {
+		return t, err
+	}
+	if stepID != nil {
+		t.step = &healthProtocolStep{id: *stepID, action: *action, treatment: *treatment, everyCount: *count, everyUnit: *unit, minAgeDays: *minAge}
+	}
+	return t, nil
+}
+
+// handleCompleteHealthTask records the treatment a task asked for as a
+// health record and, for protocol tasks, schedules the next dose one
+// interval after the date it was given. nextDue overrides that date, or sets
+// one for a task that does not repeat.
+func (s *Server) handleCompleteHealthTask(w http.ResponseWriter, r *http.Request) {
+	taskID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid task id"})
+		return
+	}
+	var in struct {
+		RecordDate   string `json:"recordDate"`
+		Veterinarian string `json:"veterinarian"`
+		NextDue      string `json:"nextDue"`
+		Notes        string `json:"notes"`
+		recordMedicineInput
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	if strings.TrimSpace(in.RecordDate) == "" {
+		in.RecordDate = s.formatISODate(s.now())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var recordID int64
+	var nextDue *time.Time
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "health_tasks", taskID, func(tx pgx.Tx) (int64, error) {
+		t, err := lockOpenHealthTask(ctx, tx, farmID, taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		h, msg := normalizeHealthRecordInput(healthRecordInput{
+			AnimalTagID:  t.tag,
+			Action:       t.action,
+			Treatment:    t.treatment,
+			RecordDate:   in.RecordDate,
+			Veterinarian: in.Veterinarian,
+			NextDue:      in.NextDue,
+			Notes:        in.Notes,
+
+			recordMedicineInput: in.recordMedicineInput,
+		})
+		if msg != "" {
+			return 0, &httpError{http.StatusBadRequest, msg}
+		}
+		if h.recordDate.After(s.today()) {
+			return 0, &httpError{http.StatusBadRequest, "recordDate cannot be in the future"}
+		}
+		if t.step != nil {
+			h.protocolID, h.stepID = t.protocolID, t.step.id
+			if h.nextDue == nil {
+				next := t.step.after(h.recordDate)
+				h.nextDue = &next
+			}
+		}
+		// Close this task before the record schedules the next one; a step
+		// has only one open task per animal.
+		if _, err := tx.Exec(ctx, `
+			UPDATE health_tasks SET status = 'done', completed_at = NOW(), completed_by = NULLIF($1, 0)
+			WHERE id = $2
+		`, userID, taskID); err != nil {
+			return 0, err
+		}
+		if recordID, err = insertHealthRecord(ctx, tx, farmID, h); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE health_tasks SET health_record_id = $1 WHERE id = $2`, recordID, taskID); err != nil {
+			return 0, err
+		}
+		if err := s.recordAudit(ctx, tx, r, auditCreate, "health_records", recordID, nil); err != nil {
+			return 0, err
+		}
+		nextDue = h.nextDue
+		return taskID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to complete health task")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "open health task not found"})
+		return
+	}
+	var next any
+	if nextDue != nil {
+		next = s.formatISODate(*nextDue)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "healthRecordId": recordID, "nextDue": next})
+}
+
+// handleSkipHealthTask closes a task without treating the animal. A protocol
+// task moves on to the following occurrence, counted from the skipped due
+// date so the schedule does not drift.
+func (s *Server) handleSkipHealthTask(w http.ResponseWriter, r *http.Request) {
+	taskID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid task id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var nextDue *time.Time
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "health_tasks", taskID, func(tx pgx.Tx) (int64, error) {
+		t, err := lockOpenHealthTask(ctx, tx, farmID, taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE health_tasks SET status = 'skipped', completed_at = NOW(), completed_by = NULLIF($1, 0)
+			WHERE id = $2
+		`, userID, taskID); err != nil {
+			return 0, err
+		}
+		if t.step == nil {
+			return taskID, nil
+		}
+		next := t.step.after(t.due)
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO health_tasks(farm_id, animal_id, protocol_id, step_id, action, treatment, due_date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, farmID, t.animalID, t.protocolID, t.step.id, t.step.action, t.step.treatment, next); err != nil {
+			return 0, err
+		}
+		nextDue = &next
+		return taskID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to skip health task"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "open health task not found"})
+		return
+	}
+	var next any
+	if nextDue != nil {
+		next = s.formatISODate(*nextDue)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "nextDue": next})
+}
+
+// syncRecordTask keeps the open task a health record's next due date stands
+// for in line with the record after it is edited.
+func syncRecordTask(ctx context.Context, tx pgx.Tx, farmID, recordID, animalID int64, action, treatment string, nextDue *time.Time) error {
+	if nextDue == nil {
+		_, err := tx.Exec(ctx, `DELETE FROM health_tasks WHERE source_record_id = $1 AND status = 'scheduled'`, recordID)
+		return err
+	}
+	res, err := tx.Exec(ctx, `
+		UPDATE health_tasks
+		SET animal_id = $1, action = $2, treatment = $3, due_date = $4
+		WHERE source_record_id = $5 AND status = 'scheduled'
+	`, animalID, action, treatment, *nextDue, recordID)
+	if err != nil || res.RowsAffected() > 0 {
+		return err
+	}
+	// An animal out of the herd has nothing scheduled.
+	var active bool
+	if err := tx.QueryRow(ctx, `SELECT is_active FROM animals WHERE id = $1`, animalID).Scan(&active); err != nil || !active {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO health_tasks(farm_id, animal_id, source_record_id, action, treatment, due_date)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
+	`, farmID, animalID, recordID, action, treatment, *nextDue)
+	return err
+}
+
+func formatDaysRemaining(days int) string {
+	switch {
+	case days < 0:
+		return fmt.Sprintf("%d days overdue", -days)
+	case days == 0:
+		return "due today"
+	default:
+		return fmt.Sprintf("%d days remaining", days)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthProtocolStepAfter(t *testing.T) {
+	tests := []struct {
+		step healthProtocolStep
+		last string
+		want string
+	}{
+		{healthProtocolStep{everyCount: 21, everyUnit: "day"}, "2026-03-01", "2026-03-22"},
+		{healthProtocolStep{everyCount: 2, everyUnit: "week"}, "2026-03-01", "2026-03-15"},
+		{healthProtocolStep{everyCount: 3, everyUnit: "month"}, "2026-03-01", "2026-06-01"},
+		{healthProtocolStep{everyCount: 1, everyUnit: "year"}, "2026-03-01", "2027-03-01"},
+		// Months run on from the same day, so the end of a short month spills over.
+		{healthProtocolStep{everyCount: 1, everyUnit: "month"}, "2026-01-31", "2026-03-03"},
+	}
+	for _, tt := range tests {
+		if got := tt.step.after(testDate(tt.last)); !got.Equal(testDate(tt.want)) {
+			t.Errorf("%s after %s: %s, want %s", tt.step.label(), tt.last, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestHealthProtocolStepFirstDue(t *testing.T) {
+	date := func(s string) *time.Time {
+		d := testDate(s)
+		return &d
+	}
+	today := testDate("2026-03-10")
+	deworm := healthProtocolStep{treatment: "Albendazole", everyCount: 3, everyUnit: "month"}
+	calfVaccine := healthProtocolStep{treatment: "Blackleg", everyCount: 1, everyUnit: "year", minAgeDays: 90}
+	tests := []struct {
+		name      string
+		step      healthProtocolStep
+		last      *time.Time
+		birthDate *time.Time
+		want      string
+	}{
+		{"never treated", deworm, nil, nil, "2026-03-10"},
+		{"treated recently", deworm, date("2026-02-01"), nil, "2026-05-01"},
+		// A dose missed long ago is due at once, not pushed to today.
+		{"overdue", deworm, date("2025-06-01"), nil, "2025-09-01"},
+		{"too young", calfVaccine, nil, date("2026-02-01"), "2026-05-02"},
+		{"old enough", calfVaccine, nil, date("2025-01-01"), "2026-03-10"},
+		{"no birth date", calfVaccine, nil, nil, "2026-03-10"},
+		// A past treatment counts even if the animal is young.
+		{"treated young", calfVaccine, date("2026-03-01"), date("2026-02-01"), "2027-03-01"},
+	}
+	for _, tt := range tests {
+		if got := tt.step.firstDue(today, tt.last, tt.birthDate); !got.Equal(testDate(tt.want)) {
+			t.Errorf("%s: due %s, want %s", tt.name, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestHealthProtocolStepLabel(t *testing.T) {
+	if got := (healthProtocolStep{everyCount: 1, everyUnit: "year"}).label(); got != "every year" {
+		t.Errorf("label %q, want every year", got)
+	}
+	if got := (healthProtocolStep{everyCount: 6, everyUnit: "month"}).label(); got != "every 6 months" {
+		t.Errorf("label %q, want every 6 months", got)
+	}
+}
+
+func TestHealthProtocolMatches(t *testing.T) {
+	p := healthProtocol{species: "Cattle"}
+	for _, animalType := range []string{"Cow", "heifer", "Dairy cattle"} {
+		if !p.matches(animalType) {
+			t.Errorf("a cattle protocol should match %q", animalType)
+		}
+	}
+	if p.matches("Goat") {
+		t.Error("a cattle protocol matched a goat")
+	}
+}
+
+func TestNormalizeHealthProtocolInput(t *testing.T) {
+	type step = struct {
+		ID         int64  `json:"id"`
+		Action     string `json:"action"`
+		Treatment  string `json:"treatment"`
+		EveryCount int    `json:"everyCount"`
+		EveryUnit  string `json:"everyUnit"`
+		MinAgeDays int    `json:"minAgeDays"`
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		msg   string
+	}{
+		{"valid", []step{{Treatment: "Albendazole", EveryCount: 3, EveryUnit: " Months "}}, ""},
+		{"no steps", nil, "a protocol needs at least one step"},
+		{"no treatment", []step{{EveryCount: 3, EveryUnit: "month"}}, "step 1: treatment is required"},
+		{"unknown unit", []step{{Treatment: "Albendazole", EveryCount: 3, EveryUnit: "fortnight"}}, "step 1: everyUnit must be day, week, month or year"},
+		{"zero interval", []step{{Treatment: "Albendazole", EveryUnit: "month"}}, "step 1: everyCount must be between 1 and 400"},
+		{"negative age", []step{{Treatment: "Blackleg", EveryCount: 1, EveryUnit: "year", MinAgeDays: -1}}, "step 1: minAgeDays must be between 0 and 3650"},
+	}
+	for _, tt := range tests {
+		p, msg := normalizeHealthProtocolInput(healthProtocolInput{Name: " Dairy  herd ", Species: "Cattle", Steps: tt.steps})
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg != "" {
+			continue
+		}
+		// Protocols assign themselves and start active unless told otherwise,
+		// and a step without an action is a vaccination.
+		if p.name != "Dairy herd" || !p.autoAssign || !p.isActive || len(p.steps) != 1 ||
+			p.steps[0].everyUnit != "month" || p.steps[0].action != "Vaccination" {
+			t.Errorf("%s: got %+v", tt.name, p)
+		}
+	}
+	if _, msg := normalizeHealthProtocolInput(healthProtocolInput{Name: "Dairy herd"}); msg != "name and species are required" {
+		t.Errorf("no species: message %q", msg)
+	}
+}
+
+func TestFormatDaysRemaining(t *testing.T) {
+	tests := map[int]string{-3: "3 days overdue", 0: "due today", 5: "5 days remaining"}
+	for days, want := range tests {
+		if got := formatDaysRemaining(days); got != want {
+			t.Errorf("%d: %q, want %q", days, got, want)
+		}
+	}
+}
@@ -0,0 +1,642 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	importMaxBytes = 10 << 20
+	importMaxRows  = 5000
+)
+
+// importColumn is one column an import file may carry. key is the JSON field
+// of the single-record endpoint, so rows decode into the same input structs.
+type importColumn struct {
+	key  string
+	kind string // text, number, int, bool or date
+}
+
+var importColumns = map[string][]importColumn{
+	"animals": {
+		{"tagId", "text"}, {"type", "text"}, {"breed", "text"}, {"birthDate", "date"},
+		{"weightKg", "number"}, {"status", "text"}, {"sex", "text"},
+		{"motherTagId", "text"}, {"fatherTagId", "text"}, {"locationId", "int"},
+		{"entryType", "text"}, {"entryDate", "date"}, {"entryReason", "text"},
+		{"purchasePrice", "number"}, {"expenseId", "int"},
+	},
+	"health": {
+		{"animalTagId", "text"}, {"action", "text"}, {"treatment", "text"}, {"recordDate", "date"},
+		{"veterinarian", "text"}, {"nextDue", "date"}, {"notes", "text"},
+	},
+	"feeding": {
+		{"date", "date"}, {"animalTagId", "text"}, {"rationId", "int"}, {"planId", "int"},
+		{"feedType", "text"}, {"quantityValue", "number"}, {"quantityUnit", "text"},
+		{"supplier", "text"}, {"supplierId", "int"}, {"cost", "number"}, {"notes", "text"},
+	},
+	"expenses": {
+		{"date", "date"}, {"category", "text"}, {"item", "text"}, {"vendor", "text"},
+		{"supplierId", "int"}, {"amount", "number"},
+	},
+	"production": {
+		{"date", "date"}, {"milkLiters", "number"}, {"milkCowLiters", "number"}, {"milkGoatLiters", "number"},
+		{"eggsCount", "int"}, {"woolKg", "number"}, {"meatKg", "number"},
+		{"milkRate", "number"}, {"milkCowRate", "number"}, {"milkGoatRate", "number"},
+		{"eggRate", "number"}, {"woolRate", "number"}, {"meatRate", "number"},
+		{"totalValue", "number"}, {"manualTotalOverride", "bool"},
+	},
+}
+
+var importTables = map[string]string{
+	"animals":    "animals",
+	"health":     "health_records",
+	"feeding":    "feeding_records",
+	"expenses":   "expenses",
+	"production": "production_records",
+}
+
+// importPermissions are what the import routes for each kind require; the
+// template for a kind needs the same.
+var importPermissions = map[string]string{
+	"animals":    "animals.write",
+	"health":     "health.write",
+	"feeding":    "feeding.write",
+	"expenses":   "expenses.write",
+	"production": "production.create",
+}
+
+type importResult struct {
+	Row    int      `json:"row"`
+	OK     bool     `json:"ok"`
+	ID     int64    `json:"id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// sheetRow is a row of an uploaded file with the line it came from, so the
+// report points at the line the user sees in their spreadsheet.
+type sheetRow struct {
+	line  int
+	cells []string
+}
+
+// importState carries what rows of one file share.
+type importState struct {
+	prices priceList
+	days   map[string]int
+}
+
+func headerKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// readImportFile takes the file from a multipart "file" field or, failing
+// that, the raw request body. XLSX is told apart from CSV by its zip header.
+func readImportFile(w http.ResponseWriter, r *http.Request) ([]sheetRow, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errors.New("upload the file in a form field named file")
+		}
+		defer file.Close()
+		src = file
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, errors.New("file must be at most 10 MB")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return readXLSX(data)
+	}
+	return readCSV(data)
+}
+
+func readCSV(data []byte) ([]sheetRow, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var rows []sheetRow
+	for {
+		cells, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("file is not valid CSV: %v", err)
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, sheetRow{line: line, cells: cells})
+	}
+	return rows, nil
+}
+
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text string `xml:"t"`
+	} `xml:"is"`
+}
+
+type xlsxRow struct {
+	Num   int        `xml:"r,attr"`
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	for _, run := range t.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+// readXLSX reads the first worksheet of a workbook. Only cell values are
+// read; styles are not, so dates typed into Excel arrive as serial numbers
+// and are converted per column later.
+func readXLSX(data []byte) ([]sheetRow, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("file is not a valid XLSX workbook")
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v any) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%s missing", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return xml.NewDecoder(rc).Decode(v)
+	}
+
+	sheet := "xl/worksheets/sheet1.xml"
+	var workbook struct {
+		Sheets []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if decode("xl/workbook.xml", &workbook) == nil && len(workbook.Sheets) > 0 &&
+		decode("xl/_rels/workbook.xml.rels", &rels) == nil {
+		for _, rel := range rels.Rels {
+			if rel.ID != workbook.Sheets[0].RelID {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				sheet = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				sheet = path.Join("xl", rel.Target)
+			}
+		}
+	}
+
+	var shared struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, errors.New("file is not a valid XLSX workbook")
+		}
+	}
+	var ws struct {
+		Rows []xlsxRow `xml:"sheetData>row"`
+	}
+	if err := decode(sheet, &ws); err != nil {
+		return nil, errors.New("file is not a valid XLSX workbook")
+	}
+
+	rows := make([]sheetRow, 0, len(ws.Rows))
+	for i, xr := range ws.Rows {
+		line := xr.Num
+		if line == 0 {
+			line = i + 1
+		}
+		var cells []string
+		for j, c := range xr.Cells {
+			col := j
+			if n := columnIndex(c.Ref); n >= 0 {
+				col = n
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(c.Value)
+				if err != nil || n < 0 || n >= len(shared.Items) {
+					return nil, errors.New("file is not a valid XLSX workbook")
+				}
+				cells[col] = shared.Items[n].String()
+			case "inlineStr":
+				cells[col] = c.Inline.Text
+			case "b":
+				cells[col] = map[string]string{"1": "true", "0": "false"}[c.Value]
+			default:
+				cells[col] = c.Value
+			}
+		}
+		rows = append(rows, sheetRow{line: line, cells: cells})
+	}
+	return rows, nil
+}
+
+// columnIndex turns a cell reference such as "AB12" into a zero-based column.
+func columnIndex(ref string) int {
+	n := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+	return n - 1
+}
+
+// importDate accepts YYYY-MM-DD and Excel serial day numbers.
+func importDate(v string) (string, bool) {
+	if _, err := time.Parse("2006-01-02", v); err == nil {
+		return v, true
+	}
+	serial, err := strconv.ParseFloat(v, 64)
+	if err != nil || serial < 1 || serial > 2958465 {
+		return "", false
+	}
+	return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(serial)).Format("2006-01-02"), true
+}
+
+// importFields turns the cells of a row into the JSON object the
+// single-record endpoint takes. Blank cells are left out, as if the field had
+// not been sent.
+func importFields(index map[int]importColumn, cells []string) (map[string]any, []string) {
+	fields := map[string]any{}
+	var problems []string
+	for i, raw := range cells {
+		col, ok := index[i]
+		v := strings.TrimSpace(raw)
+		if !ok || v == "" {
+			continue
+		}
+		switch col.kind {
+		case "number":
+			n, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", ""), 64)
+			if err != nil {
+				problems = append(problems, col.key+" must be a number")
+				continue
+			}
+			fields[col.key] = n
+		case "int":
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n != float64(int64(n)) {
+				problems = append(problems, col.key+" must be a whole number")
+				continue
+			}
+			fields[col.key] = int64(n)
+		case "bool":
+			switch strings.ToLower(v) {
+			case "true", "yes", "y", "1":
+				fields[col.key] = true
+			case "false", "no", "n", "0":
+				fields[col.key] = false
+			default:
+				problems = append(problems, col.key+" must be true or false")
+			}
+		case "date":
+			d, ok := importDate(v)
+			if !ok {
+				problems = append(problems, col.key+" must be YYYY-MM-DD")
+				continue
+			}
+			fields[col.key] = d
+		default:
+			fields[col.key] = v
+		}
+	}
+	return fields, problems
+}
+
+// importRow validates and stores one row exactly as the matching create
+// endpoint would. Anything wrong with the row itself comes back as an
+// httpError.
+func (s *Server) importRow(ctx context.Context, tx pgx.Tx, r *http.Request, farmID int64, kind string, line int, state *importState, fields map[string]any) (int64, error) {
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return 0, err
+	}
+	invalid := func(msg string) error {
+		return &httpError{http.StatusBadRequest, msg}
+	}
+
+	var id int64
+	switch kind {
+	case "animals":
+		var in animalInput
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return 0, invalid("row does not match the animal columns")
+		}
+		a, msg := normalizeAnimalInput(in, s.now())
+		if msg != "" {
+			return 0, invalid(msg)
+		}
+		id, err = s.insertAnimal(ctx, tx, r, farmID, a)
+	case "health":
+		var in healthRecordInput
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return 0, invalid("row does not match the health record columns")
+		}
+		h, msg := normalizeHealthRecordInput(in)
+		if msg != "" {
+			return 0, invalid(msg)
+		}
+		id, err = insertHealthRecord(ctx, tx, farmID, h)
+	case "feeding":
+		var in feedingRecordInput
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return 0, invalid("row does not match the feeding record columns")
+		}
+		f, msg := normalizeFeedingRecordInput(in)
+		if msg != "" {
+			return 0, invalid(msg)
+		}
+		id, err = insertFeedingRecord(ctx, tx, farmID, f)
+	case "expenses":
+		var in expenseInput
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return 0, invalid("row does not match the expense columns")
+		}
+		e, msg := normalizeExpenseInput(in)
+		if msg != "" {
+			return 0, invalid(msg)
+		}
+		id, err = insertExpense(ctx, tx, farmID, e)
+	case "production":
+		var in productionLogInput
+		if err := json.Unmarshal(raw, &in); err != nil {
+			return 0, invalid("row does not match the production columns")
+		}
+		p, msg := normalizeProductionLogInput(in)
+		if msg != "" {
+			return 0, invalid(msg)
+		}
+		day := p.date.Format("2006-01-02")
+		if line, seen := state.days[day]; seen {
+			return 0, invalid(fmt.Sprintf("%s is already on row %d", day, line))
+		}
+		// replaceHerdDay audits the records itself and has no single id.
+		if _, err := s.replaceHerdDay(ctx, tx, r, farmID, p.date, p.date, p.entries(state.prices)); err != nil {
+			return 0, err
+		}
+		state.days[day] = line
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, s.recordAudit(ctx, tx, r, auditCreate, importTables[kind], id, nil)
+}
+
+// rowProblem reports whether err is down to the row rather than the server:
+// a rejected value or a constraint the row breaks.
+func rowProblem(err error) (string, bool) {
+	var ie *httpError
+	if errors.As(err, &ie) {
+		return ie.message, true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")) {
+		return pgErr.Message, true
+	}
+	return "", false
+}
+
+// handleImport runs every row of an uploaded CSV or XLSX file through the
+// create endpoint's validation inside one transaction, each row in its own
+// savepoint so one bad row does not hide problems in the rest. Without
+// ?commit=true the transaction is always rolled back and the report is a dry
+// run; with it, the file is committed only if every row is valid.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request, kind string) {
+	commit := r.URL.Query().Get("commit") == "true"
+	rows, err := readImportFile(w, r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "file has no header row"})
+		return
+	}
+	if len(rows)-1 > importMaxRows {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("files are limited to %d rows", importMaxRows)})
+		return
+	}
+
+	columns := importColumns[kind]
+	known := map[string]importColumn{}
+	for _, col := range columns {
+		known[headerKey(col.key)] = col
+	}
+	index := map[int]importColumn{}
+	unknown := []string{}
+	for i, h := range rows[0].cells {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		col, ok := known[headerKey(h)]
+		if !ok {
+			unknown = append(unknown, h)
+			continue
+		}
+		index[i] = col
+	}
+	if len(index) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "no recognised columns; download the template for " + kind})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	state := &importState{days: map[string]int{}}
+	if kind == "production" {
+		if state.prices, err = s.loadPriceList(ctx, farmID); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load prices"})
+			return
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to import file"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	results := []importResult{}
+	valid := 0
+	for _, row := range rows[1:] {
+		blank := true
+		for _, c := range row.cells {
+			if strings.TrimSpace(c) != "" {
+				blank = false
+				break
+			}
+		}
+		if blank {
+			continue
+		}
+		res := importResult{Row: row.line}
+		fields, problems := importFields(index, row.cells)
+		if len(problems) > 0 {
+			res.Errors = problems
+			results = append(results, res)
+			continue
+		}
+
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to import file"})
+			return
+		}
+		id, err := s.importRow(ctx, sp, r, farmID, kind, row.line, state, fields)
+		if err != nil {
+			if rbErr := sp.Rollback(ctx); rbErr != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to import file"})
+				return
+			}
+			msg, ok := rowProblem(err)
+			if !ok {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("failed to import row %d", row.line)})
+				return
+			}
+			res.Errors = []string{msg}
+			results = append(results, res)
+			continue
+		}
+		if err := sp.Commit(ctx); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to import file"})
+			return
+		}
+		res.OK, res.ID = true, id
+		results = append(results, res)
+		valid++
+	}
+
+	invalid := len(results) - valid
+	status := http.StatusOK
+	committed := false
+	switch {
+	case !commit:
+	case invalid > 0:
+		status = http.StatusUnprocessableEntity
+	case valid > 0:
+		if err := tx.Commit(ctx); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to import file"})
+			return
+		}
+		status, committed = http.StatusCreated, true
+	}
+
+	respondJSON(w, status, map[string]any{
+		"kind":           kind,
+		"dryRun":         !commit,
+		"committed":      committed,
+		"rows":           len(results),
+		"valid":          valid,
+		"invalid":        invalid,
+		"results":        results,
+		"unknownColumns": unknown,
+	})
+}
+
+func (s *Server) handleImportAnimals(w http.ResponseWriter, r *http.Request) {
+	s.handleImport(w, r, "animals")
+}
+
+func (s *Server) handleImportHealthRecords(w http.ResponseWriter, r *http.Request) {
+	s.handleImport(w, r, "health")
+}
+
+func (s *Server) handleImportFeedingRecords(w http.ResponseWriter, r *http.Request) {
+	s.handleImport(w, r, "feeding")
+}
+
+func (s *Server) handleImportExpenses(w http.ResponseWriter, r *http.Request) {
+	s.handleImport(w, r, "expenses")
+}
+
+func (s *Server) handleImportProductionLogs(w http.ResponseWriter, r *http.Request) {
+	s.handleImport(w, r, "production")
+}
+
+// handleImportTemplate serves a CSV with just the header row for kind.
+func (s *Server) handleImportTemplate(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	columns, ok := importColumns[kind]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "kind must be animals, health, feeding, expenses or production"})
+		return
+	}
+	held, _ := r.Context().Value(userPermissionsContextKey).(map[string]struct{})
+	if _, allowed := held[importPermissions[kind]]; !allowed {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "insufficient permissions"})
+		return
+	}
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.key
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", kind+"-import.csv"))
+	cw := csv.NewWriter(w)
+	_ = cw.Write(header)
+	cw.Flush()
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestImportFields(t *testing.T) {
+	index := map[int]importColumn{
+		0: {"tagId", "text"},
+		1: {"weightKg", "number"},
+		2: {"locationId", "int"},
+		3: {"manualTotalOverride", "bool"},
+		4: {"birthDate", "date"},
+	}
+	tests := []struct {
+		name     string
+		cells    []string
+		fields   map[string]any
+		problems []string
+	}{
+		{
+			name:   "every kind",
+			cells:  []string{" KE-001 ", "1,250.5", "3", "Yes", "2024-02-29"},
+			fields: map[string]any{"tagId": "KE-001", "weightKg": 1250.5, "locationId": int64(3), "manualTotalOverride": true, "birthDate": "2024-02-29"},
+		},
+		{
+			name:   "blank cells are left out",
+			cells:  []string{"KE-002", "", "  ", "", ""},
+			fields: map[string]any{"tagId": "KE-002"},
+		},
+		{
+			name:   "excel serial date and whole float",
+			cells:  []string{"", "", "4.0", "0", "45000"},
+			fields: map[string]any{"locationId": int64(4), "manualTotalOverride": false, "birthDate": "2023-03-15"},
+		},
+		{
+			name:   "extra cells are ignored",
+			cells:  []string{"KE-003", "", "", "", "", "stray"},
+			fields: map[string]any{"tagId": "KE-003"},
+		},
+		{
+			name:     "bad values",
+			cells:    []string{"KE-004", "heavy", "2.5", "maybe", "29/02/2024"},
+			fields:   map[string]any{"tagId": "KE-004"},
+			problems: []string{"weightKg must be a number", "locationId must be a whole number", "manualTotalOverride must be true or false", "birthDate must be YYYY-MM-DD"},
+		},
+	}
+	for _, tt := range tests {
+		fields, problems := importFields(index, tt.cells)
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("%s: fields = %v, want %v", tt.name, fields, tt.fields)
+		}
+		if !reflect.DeepEqual(problems, tt.problems) {
+			t.Errorf("%s: problems = %q, want %q", tt.name, problems, tt.problems)
+		}
+	}
+}
+
+func TestImportDate(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"2024-01-31", "2024-01-31", true},
+		{"45000", "2023-03-15", true},
+		{"45000.75", "2023-03-15", true},
+		{"1", "1899-12-31", true},
+		{"0", "", false},
+		{"2024-13-01", "", false},
+		{"31/01/2024", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := importDate(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("importDate(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHeaderKeyAndColumnIndex(t *testing.T) {
+	for in, want := range map[string]string{"Tag ID": "tagid", "tag_id": "tagid", " Weight (kg) ": "weightkg"} {
+		if got := headerKey(in); got != want {
+			t.Errorf("headerKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+	for ref, want := range map[string]int{"A1": 0, "Z9": 25, "AA10": 26, "ab3": 27, "": -1} {
+		if got := columnIndex(ref); got != want {
+			t.Errorf("columnIndex(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	data := []byte("\xef\xbb\xbftagId,weightKg\n\"KE-001\", 250\n\nKE-002\n")
+	rows, err := readCSV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sheetRow{
+		{line: 1, cells: []string{"tagId", "weightKg"}},
+		{line: 2, cells: []string{"KE-001", "250"}},
+		{line: 4, cells: []string{"KE-002"}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("readCSV = %+v, want %+v", rows, want)
+	}
+	if _, err := readCSV([]byte("a,\"b\nc")); err == nil {
+		t.Error("readCSV accepted an unterminated quote")
+	}
+}
+
+func buildXLSX(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	data := buildXLSX(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+			xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Animals" sheetId="1" r:id="rId7"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId7" Target="worksheets/animals.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>tagId</t></si><si><t>birthDate</t></si><si><r><t>KE-</t></r><r><t>001</t></r></si></sst>`,
+		"xl/worksheets/animals.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+			<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+			<row r="3"><c r="A3" t="s"><v>2</v></c><c r="B3"><v>45000</v></c><c r="D3" t="b"><v>1</v></c></row>
+			<row r="4"><c r="A4" t="inlineStr"><is><t>KE-002</t></is></c></row>
+		</sheetData></worksheet>`,
+		// The default sheet path must not be read when the workbook names
+		// another first sheet.
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>wrong</t></is></c></row></sheetData></worksheet>`,
+	})
+	rows, err := readXLSX(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sheetRow{
+		{line: 1, cells: []string{"tagId", "birthDate"}},
+		{line: 3, cells: []string{"KE-001", "45000", "", "true"}},
+		{line: 4, cells: []string{"KE-002"}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("readXLSX = %+v, want %+v", rows, want)
+	}
+
+	broken := buildXLSX(t, map[string]string{
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>5</v></c></row></sheetData></worksheet>`,
+	})
+	if _, err := readXLSX(broken); err == nil {
+		t.Error("readXLSX accepted a shared string index past the table")
+	}
+	if _, err := readXLSX([]byte("PK\x03\x04 not a zip")); err == nil {
+		t.Error("readXLSX accepted a corrupt archive")
+	}
+}
+
+func TestImportTemplatePermission(t *testing.T) {
+	s := &Server{}
+	tests := []struct {
+		kind   string
+		held   []string
+		status int
+	}{
+		{"animals", []string{"animals.write"}, http.StatusOK},
+		{"animals", []string{"health.write"}, http.StatusForbidden},
+		{"production", []string{"production.create"}, http.StatusOK},
+		{"production", nil, http.StatusForbidden},
+		{"invoices", []string{"animals.write"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		held := map[string]struct{}{}
+		for _, key := range tt.held {
+			held[key] = struct{}{}
+		}
+		r := httptest.NewRequest(http.MethodGet, "/api/import/"+tt.kind+"/template", nil)
+		r.SetPathValue("kind", tt.kind)
+		r = r.WithContext(context.WithValue(r.Context(), userPermissionsContextKey, held))
+		w := httptest.NewRecorder()
+		s.handleImportTemplate(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s template with %v: status %d, want %d", tt.kind, tt.held, w.Code, tt.status)
+		}
+	}
+	for kind := range importColumns {
+		if importPermissions[kind] == "" {
+			t.Errorf("import kind %q has no permission", kind)
+		}
+	}
+}
@@ -40,6 +40,7 @@ type healthMonthRow struct {
 func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 6*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var totalAnimals, activeAnimals, sickAnimals, attentionAnimals int64
 	_ = s.db.QueryRow(ctx, `
@@ -49,46 +50,48 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 			COUNT(*) FILTER (WHERE health_status = 'sick') AS sick,
 			COUNT(*) FILTER (WHERE health_status = 'attention') AS attention
 		FROM animals
-	`).Scan(&totalAnimals, &activeAnimals, &sickAnimals, &attentionAnimals)
+		WHERE farm_id = $1
+	`, farmID).Scan(&totalAnimals, &activeAnimals, &sickAnimals, &attentionAnimals)
 
 	var vaccinesDue7 int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
-		FROM health_records
-		WHERE next_due IS NOT NULL
-			AND next_due >= CURRENT_DATE
-			AND next_due <= CURRENT_DATE + INTERVAL '7 days'
-	`).Scan(&vaccinesDue7)
+		FROM health_tasks t
+		JOIN animals a ON a.id = t.animal_id
+		WHERE t.farm_id = $1 AND t.status = 'scheduled' AND a.is_active
+			AND t.due_date >= CURRENT_DATE
+			AND t.due_date <= CURRENT_DATE + INTERVAL '7 days'
+	`, farmID).Scan(&vaccinesDue7)
 
 	var breedingActive, breedingOnHeat, aiRecent30, expectedBirths30 int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM breeding_records
-		WHERE status = 'active'
-	`).Scan(&breedingActive)
+		WHERE farm_id = $1 AND status = 'active'
+	`, farmID).Scan(&breedingActive)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM breeding_records
-		WHERE status = 'active' AND on_heat = true
-	`).Scan(&breedingOnHeat)
+		WHERE farm_id = $1 AND status = 'active' AND on_heat = true
+	`, farmID).Scan(&breedingOnHeat)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM breeding_records
-		WHERE ai_date >= CURRENT_DATE - INTERVAL '30 days'
-	`).Scan(&aiRecent30)
+		WHERE farm_id = $1 AND ai_date >= CURRENT_DATE - INTERVAL '30 days'
+	`, farmID).Scan(&aiRecent30)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COUNT(*)
 		FROM breeding_records
-		WHERE expected_birth_date >= CURRENT_DATE
+		WHERE farm_id = $1 AND expected_birth_date >= CURRENT_DATE
 			AND expected_birth_date <= CURRENT_DATE + INTERVAL '30 days'
-	`).Scan(&expectedBirths30)
+	`, farmID).Scan(&expectedBirths30)
 
 	var eggsSet90, chicksHatched90 int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(eggs_set), 0), COALESCE(SUM(chicks_hatched), 0)
 		FROM poultry_breeding_records
-		WHERE egg_set_date >= CURRENT_DATE - INTERVAL '90 days'
-	`).Scan(&eggsSet90, &chicksHatched90)
+		WHERE farm_id = $1 AND egg_set_date >= CURRENT_DATE - INTERVAL '90 days'
+	`, farmID).Scan(&eggsSet90, &chicksHatched90)
 
 	var milk30, milkCow30, milkGoat30, eggs30, wool30, meat30, productionValue30 float64
 	var productionLogs30 int64
@@ -102,86 +105,92 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 			COALESCE(SUM(meat_kg), 0),
 			COALESCE(SUM(total_value), 0),
 			COUNT(*)
-		FROM production_logs
-		WHERE log_date >= CURRENT_DATE - INTERVAL '30 days'
-	`).Scan(&milk30, &milkCow30, &milkGoat30, &eggs30, &wool30, &meat30, &productionValue30, &productionLogs30)
+		FROM production_daily
+		WHERE farm_id = $1 AND log_date >= CURRENT_DATE - INTERVAL '30 days'
+	`, farmID).Scan(&milk30, &milkCow30, &milkGoat30, &eggs30, &wool30, &meat30, &productionValue30, &productionLogs30)
 
-	var feedCost30, feedQty30 float64
+	var feedCost30 float64
 	var feedRecords30 int64
 	_ = s.db.QueryRow(ctx, `
 		SELECT
 			COALESCE(SUM(cost), 0),
-			COALESCE(SUM(quantity_value), 0),
 			COUNT(*)
 		FROM feeding_records
-		WHERE feed_date >= CURRENT_DATE - INTERVAL '30 days'
-	`).Scan(&feedCost30, &feedQty30, &feedRecords30)
+		WHERE farm_id = $1 AND feed_date >= CURRENT_DATE - INTERVAL '30 days'
+	`, farmID).Scan(&feedCost30, &feedRecords30)
+	// Quantities are entered in many units; add them up by weight.
+	today := s.now()
+	feedQty30, feedQtyOther30, _ := s.feedQuantityKg(ctx, farmID, today.AddDate(0, 0, -30), today)
+	feedDensities := s.feedDensities(ctx, farmID)
 
 	var topFeed string
 	var topFeedCost float64
 	_ = s.db.QueryRow(ctx, `
 		SELECT feed_type, SUM(cost) AS total
 		FROM feeding_records
+		WHERE farm_id = $1
 		GROUP BY feed_type
 		ORDER BY total DESC
 		LIMIT 1
-	`).Scan(&topFeed, &topFeedCost)
+	`, farmID).Scan(&topFeed, &topFeedCost)
 
 	var expensesMonth, salesMonth float64
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(amount), 0)
 		FROM expenses
-		WHERE DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&expensesMonth)
+		WHERE farm_id = $1 AND DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&expensesMonth)
 	_ = s.db.QueryRow(ctx, `
 		SELECT COALESCE(SUM(total_amount), 0)
-		FROM sales
-		WHERE DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&salesMonth)
+		FROM sales_lines
+		WHERE farm_id = $1 AND DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+	`, farmID).Scan(&salesMonth)
 
 	var topExpenseCategory string
 	var topExpenseAmount float64
 	_ = s.db.QueryRow(ctx, `
 		SELECT category, SUM(amount) AS total
 		FROM expenses
-		WHERE DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
+		WHERE farm_id = $1 AND DATE_TRUNC('month', expense_date) = DATE_TRUNC('month', CURRENT_DATE)
 		GROUP BY category
 		ORDER BY total DESC
 		LIMIT 1
-	`).Scan(&topExpenseCategory, &topExpenseAmount)
+	`, farmID).Scan(&topExpenseCategory, &topExpenseAmount)
 
 	var topSalesProduct string
 	var topSalesAmount float64
 	_ = s.db.QueryRow(ctx, `
 		SELECT product, SUM(total_amount) AS total
-		FROM sales
-		WHERE DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
+		FROM sales_lines
+		WHERE farm_id = $1 AND DATE_TRUNC('month', sale_date) = DATE_TRUNC('month', CURRENT_DATE)
 		GROUP BY product
 		ORDER BY total DESC
 		LIMIT 1
-	`).Scan(&topSalesProduct, &topSalesAmount)
+	`, farmID).Scan(&topSalesProduct, &topSalesAmount)
 
 	// Feed costs by species (last 30 days, only where animal_id exists)
 	feedCostBySpecies := make(map[string]float64)
 	feedQtyBySpecies := make(map[string]float64)
 	{
 		rows, err := s.db.Query(ctx, `
-			SELECT a.type, COALESCE(SUM(f.cost), 0), COALESCE(SUM(f.quantity_value), 0)
+			SELECT a.type, f.feed_type, f.quantity_unit, COALESCE(SUM(f.cost), 0), COALESCE(SUM(f.quantity_value), 0)
 			FROM feeding_records f
 			JOIN animals a ON a.id = f.animal_id
-			WHERE f.feed_date >= CURRENT_DATE - INTERVAL '30 days'
-			GROUP BY a.type
-		`)
+			WHERE f.farm_id = $1 AND f.feed_date >= CURRENT_DATE - INTERVAL '30 days'
+			GROUP BY a.type, f.feed_type, f.quantity_unit
+		`, farmID)
 		if err == nil {
 			for rows.Next() {
-				var t string
+				var t, feedType, unit string
 				var cost, qty float64
-				if err := rows.Scan(&t, &cost, &qty); err != nil {
+				if err := rows.Scan(&t, &feedType, &unit, &cost, &qty); err != nil {
 					continue
 				}
 				key := normalizeSpeciesName(t)
 				feedCostBySpecies[key] += cost
-				feedQtyBySpecies[key] += qty
+				if kg, ok := convertQuantity(qty, unit, "kg", feedDensities[feedItemKey(feedType)]); ok {
+					feedQtyBySpecies[key] += kg
+				}
 			}
 			rows.Close()
 		}
@@ -192,10 +201,10 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 	{
 		rows, err := s.db.Query(ctx, `
 			SELECT product, COALESCE(SUM(total_amount), 0)
-			FROM sales
-			WHERE sale_date >= CURRENT_DATE - INTERVAL '30 days'
+			FROM sales_lines
+			WHERE farm_id = $1 AND sale_date >= CURRENT_DATE - INTERVAL '30 days'
 			GROUP BY product
-		`)
+		`, farmID)
 		if err == nil {
 			for rows.Next() {
 				var prod string
@@ -215,9 +224,9 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 		rows, err := s.db.Query(ctx, `
 			SELECT type, COUNT(*)
 			FROM animals
-			WHERE is_active
+			WHERE farm_id = $1 AND is_active
 			GROUP BY type
-		`)
+		`, farmID)
 		if err == nil {
 			for rows.Next() {
 				var t string
@@ -238,18 +247,18 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 			COUNT(*) FILTER (WHERE ai_date IS NOT NULL) AS total,
 			COUNT(*) FILTER (WHERE ai_date IS NOT NULL AND (actual_birth_date IS NOT NULL OR COALESCE(offspring_count, 0) > 0 OR status = 'completed')) AS success
 		FROM breeding_records
-		WHERE ai_date >= CURRENT_DATE - INTERVAL '365 days'
-	`).Scan(&aiTotal, &aiSuccess)
+		WHERE farm_id = $1 AND ai_date >= CURRENT_DATE - INTERVAL '365 days'
+	`, farmID).Scan(&aiTotal, &aiSuccess)
 
 	healthIncidents := make([]healthMonthRow, 0)
 	{
 		rows, err := s.db.Query(ctx, `
 			SELECT DATE_TRUNC('month', record_date)::date, COUNT(*)
 			FROM health_records
-			WHERE record_date >= DATE_TRUNC('month', CURRENT_DATE) - INTERVAL '5 months'
+			WHERE farm_id = $1 AND record_date >= DATE_TRUNC('month', CURRENT_DATE) - INTERVAL '5 months'
 			GROUP BY DATE_TRUNC('month', record_date)
 			ORDER BY DATE_TRUNC('month', record_date)
-		`)
+		`, farmID)
 		if err == nil {
 			for rows.Next() {
 				var month time.Time
@@ -471,6 +480,43 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	// Feed store items at or below their reorder level, or about to run out at
+	// the rate active feeding plans use them.
+	feedStock, _ := s.feedStockLevels(ctx, farmID)
+	lowFeed := make([]string, 0)
+	var feedOut, feedLow int
+	for _, f := range feedStock {
+		switch f.status() {
+		case "out":
+			feedOut++
+			lowFeed = append(lowFeed, f.name+" (out)")
+		case "low":
+			feedLow++
+			if days := f.daysRemaining(); days != nil && *days < feedLowStockDays {
+				lowFeed = append(lowFeed, f.name+" ("+trimZero(*days)+" days left)")
+			} else {
+				lowFeed = append(lowFeed, f.name+" ("+trimZero(roundCents(f.onHand))+" "+f.unit+")")
+			}
+		}
+	}
+	if len(lowFeed) > 0 {
+		severity := "warn"
+		if feedOut > 0 {
+			severity = "alert"
+		}
+		insights = append(insights, insightItem{
+			Category: "Feeding",
+			Title:    "Feed Stock Below Reorder Level",
+			Detail:   "Reorder now: " + strings.Join(lowFeed, ", ") + ".",
+			Severity: severity,
+			Metrics: map[string]float64{
+				"items_low": float64(feedLow),
+				"items_out": float64(feedOut),
+			},
+			Action: "Raise purchase orders for these items or record a stock count if the balance is wrong.",
+		})
+	}
+
 	if aiTotal > 0 {
 		rate := float64(aiSuccess) / float64(aiTotal)
 		severity := "good"
@@ -542,9 +588,13 @@ func (s *Server) handleInsights(w http.ResponseWriter, r *http.Request) {
 		"feeding": map[string]any{
 			"cost":     feedCost30,
 			"quantity": feedQty30,
+			"quantityUnit": "kg",
+			"quantityOther": feedQtyOther30,
 			"records":  feedRecords30,
 			"topFeed":  topFeed,
 			"topCost":  topFeedCost,
+			"stockLow": feedLow,
+			"stockOut": feedOut,
 		},
 		"finance": map[string]any{
 			"salesMonth":    salesMonth,
@@ -0,0 +1,894 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	invoiceKindInvoice    = "invoice"
+	invoiceKindCreditNote = "credit_note"
+
+	maxInvoiceLines = 100
+)
+
+// vatClassRates maps KRA tax types onto the VAT they charge: A exempt,
+// B standard, C zero rated, E the reduced 8%.
+var vatClassRates = map[string]float64{
+	"A": 0,
+	"B": 0.16,
+	"C": 0,
+	"E": 0.08,
+}
+
+type invoiceHeader struct {
+	date        time.Time
+	dueDate     time.Time
+	customerID  int64
+	buyer       string
+	buyerPIN    string
+	county      string
+	subcounty   string
+	creditLimit *float64
+}
+
+type invoiceLine struct {
+	id             int64
+	lineNo         int
+	product        string
+	quantity       float64
+	unit           string
+	price          float64
+	vatClass       string
+	vatRate        float64
+	net            float64
+	vat            float64
+	total          float64
+	originalLineID *int64
+	credited       float64
+	creditedTotal  float64
+}
+
+type invoiceLineInput struct {
+	Product       string   `json:"product"`
+	QuantityValue float64  `json:"quantityValue"`
+	QuantityUnit  string   `json:"quantityUnit"`
+	PricePerUnit  float64  `json:"pricePerUnit"`
+	VATClass      string   `json:"vatClass"`
+	TotalAmount   *float64 `json:"totalAmount"`
+}
+
+type invoiceInput struct {
+	Date              string             `json:"date"`
+	CustomerID        int64              `json:"customerId"`
+	Buyer             string             `json:"buyer"`
+	BuyerPIN          string             `json:"buyerPIN"`
+	DeliveryCounty    string             `json:"deliveryCounty"`
+	DeliverySubcounty string             `json:"deliverySubcounty"`
+	Lines             []invoiceLineInput `json:"lines"`
+}
+
+// httpError is returned from inside a handler transaction when the
+// request cannot be applied; status and message go to the client as is.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// splitVAT splits a VAT-inclusive amount into its net and VAT parts.
+func splitVAT(total, rate float64) (float64, float64) {
+	if rate <= 0 {
+		return total, 0
+	}
+	net := roundCents(total / (1 + rate))
+	return net, roundCents(total - net)
+}
+
+func normalizeInvoiceInput(in invoiceInput) (invoiceHeader, []invoiceLine, string) {
+	var h invoiceHeader
+	if strings.TrimSpace(in.Date) == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(in.Date))
+	if err != nil {
+		return h, nil, "date must be YYYY-MM-DD"
+	}
+	h.date = d
+	h.buyer = strings.Join(strings.Fields(in.Buyer), " ")
+	if in.CustomerID <= 0 && h.buyer == "" {
+		return h, nil, "customerId or buyer is required"
+	}
+	var ok bool
+	if h.buyerPIN, ok = normalizeKRAPIN(in.BuyerPIN); !ok {
+		return h, nil, "buyer PIN must be valid KRA format (e.g. A012345678Z)"
+	}
+	if h.county, ok = normalizeCounty(in.DeliveryCounty); !ok {
+		return h, nil, "delivery county must be a valid Kenya county"
+	}
+	h.subcounty = strings.TrimSpace(in.DeliverySubcounty)
+	if h.subcounty != "" && h.county == "" {
+		return h, nil, "delivery county is required when subcounty is provided"
+	}
+
+	if len(in.Lines) == 0 {
+		return h, nil, "an invoice needs at least one line"
+	}
+	if len(in.Lines) > maxInvoiceLines {
+		return h, nil, fmt.Sprintf("an invoice can have at most %d lines", maxInvoiceLines)
+	}
+	lines := make([]invoiceLine, 0, len(in.Lines))
+	for i, l := range in.Lines {
+		line := invoiceLine{
+			lineNo:   i + 1,
+			product:  strings.TrimSpace(l.Product),
+			quantity: l.QuantityValue,
+			unit:     strings.TrimSpace(l.QuantityUnit),
+			price:    l.PricePerUnit,
+			vatClass: strings.ToUpper(strings.TrimSpace(l.VATClass)),
+		}
+		if line.product == "" || line.unit == "" || line.quantity <= 0 || line.price <= 0 {
+			return h, nil, fmt.Sprintf("line %d: product, quantity, unit and price are required", i+1)
+		}
+		if line.unit, ok = normalizeUnit(line.unit, ""); !ok {
+			return h, nil, fmt.Sprintf("line %d: unit must be one of %s", i+1, unitCodes())
+		}
+		if line.vatClass == "" {
+			line.vatClass = "A"
+		}
+		rate, ok := vatClassRates[line.vatClass]
+		if !ok {
+			return h, nil, fmt.Sprintf("line %d: vatClass must be A (exempt), B (16%%), C (zero rated) or E (8%%)", i+1)
+		}
+		line.vatRate = rate
+		line.total = roundCents(line.quantity * line.price)
+		if l.TotalAmount != nil && *l.TotalAmount > 0 {
+			line.total = roundCents(*l.TotalAmount)
+		}
+		line.net, line.vat = splitVAT(line.total, rate)
+		lines = append(lines, line)
+	}
+	return h, lines, ""
+}
+
+func invoiceTotals(lines []invoiceLine) (net, vat, total float64) {
+	for _, l := range lines {
+		net += l.net
+		vat += l.vat
+		total += l.total
+	}
+	return roundCents(net), roundCents(vat), roundCents(total)
+}
+
+// nextInvoiceNumber takes the farm's next number. The counter row stays
+// locked until tx ends, so numbers are handed out without gaps.
+func nextInvoiceNumber(ctx context.Context, tx pgx.Tx, farmID int64, kind string) (int64, string, error) {
+	var seq int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO invoice_sequences(farm_id, last_number)
+		VALUES ($1, 1)
+		ON CONFLICT (farm_id) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number
+	`, farmID).Scan(&seq)
+	if err != nil {
+		return 0, "", err
+	}
+	prefix := "FP"
+	if kind == invoiceKindCreditNote {
+		prefix = "CN"
+	}
+	return seq, fmt.Sprintf("%s-%06d", prefix, seq), nil
+}
+
+func insertInvoiceLines(ctx context.Context, tx pgx.Tx, invoiceID int64, lines []invoiceLine) error {
+	for _, l := range lines {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO invoice_lines(
+				invoice_id, line_no, product, quantity_value, quantity_unit, price_per_unit,
+				vat_class, vat_rate, net_amount, vat_amount, total_amount, original_line_id
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		`, invoiceID, l.lineNo, l.product, l.quantity, l.unit, l.price, l.vatClass, l.vatRate, l.net, l.vat, l.total, l.originalLineID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadInvoiceLines returns an invoice's lines with how much of each has been
+// credited so far.
+func (s *Server) loadInvoiceLines(ctx context.Context, invoiceID int64) ([]invoiceLine, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT l.id, l.line_no, l.product, l.quantity_value, l.quantity_unit, l.price_per_unit, l.vat_class, l.vat_rate,
+		       l.net_amount, l.vat_amount, l.total_amount, l.original_line_id,
+		       COALESCE(c.quantity, 0), COALESCE(c.total, 0)
+		FROM invoice_lines l
+		LEFT JOIN (
+			SELECT original_line_id, SUM(quantity_value) AS quantity, SUM(total_amount) AS total
+			FROM invoice_lines
+			WHERE original_line_id IS NOT NULL
+			GROUP BY original_line_id
+		) c ON c.original_line_id = l.id
+		WHERE l.invoice_id = $1
+		ORDER BY l.line_no
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanInvoiceLines(rows)
+}
+
+func scanInvoiceLines(rows pgx.Rows) ([]invoiceLine, error) {
+	var out []invoiceLine
+	for rows.Next() {
+		var l invoiceLine
+		if err := rows.Scan(
+			&l.id, &l.lineNo, &l.product, &l.quantity, &l.unit, &l.price, &l.vatClass, &l.vatRate,
+			&l.net, &l.vat, &l.total, &l.originalLineID, &l.credited, &l.creditedTotal,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func (l invoiceLine) asMap() map[string]any {
+	return map[string]any{
+		"id":               l.id,
+		"lineNo":           l.lineNo,
+		"product":          l.product,
+		"quantity":         trimZero(l.quantity) + " " + l.unit,
+		"quantityValue":    l.quantity,
+		"quantityUnit":     l.unit,
+		"pricePerUnit":     l.price,
+		"vatClass":         l.vatClass,
+		"vatRate":          l.vatRate,
+		"netAmount":        l.net,
+		"vatAmount":        l.vat,
+		"totalAmount":      l.total,
+		"originalLineId":   l.originalLineID,
+		"creditedQuantity": l.credited,
+		"creditedAmount":   l.creditedTotal,
+	}
+}
+
+// lockInvoiceForChange checks that an invoice may still be edited or deleted:
+// nothing against it has reached KRA and no credit note or payment references
+// it.
+func lockInvoiceForChange(ctx context.Context, tx pgx.Tx, farmID, id int64, editing bool) error {
+	var kind, status string
+	var credited, paid bool
+	err := tx.QueryRow(ctx, `
+		SELECT i.kind,
+		       EXISTS (SELECT 1 FROM invoices c WHERE c.original_invoice_id = i.id),
+		       EXISTS (SELECT 1 FROM payments p WHERE p.invoice_id = i.id),
+		       COALESCE((SELECT e.status FROM etims_submissions e WHERE e.invoice_id = i.id), '')
+		FROM invoices i
+		WHERE i.id = $1 AND i.farm_id = $2
+		FOR UPDATE
+	`, id, farmID).Scan(&kind, &credited, &paid, &status)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == etimsAccepted || status == etimsSubmitted:
+		return &httpError{http.StatusConflict, "this invoice has been sent to KRA; issue a credit note instead"}
+	case credited:
+		return &httpError{http.StatusConflict, "this invoice has credit notes against it"}
+	case paid:
+		return &httpError{http.StatusConflict, "this invoice has payments recorded against it"}
+	case editing && kind == invoiceKindCreditNote:
+		return &httpError{http.StatusConflict, "credit notes cannot be edited; delete it and issue a new one"}
+	}
+	return nil
+}
+
+// paymentStatus summarises an invoice's balance for display; credit notes have
+// none.
+func paymentStatus(kind string, balance, due float64, dueDate, today time.Time) string {
+	switch {
+	case kind != invoiceKindInvoice:
+		return ""
+	case balance <= 0.005:
+		return "paid"
+	case dueDate.Format("2006-01-02") < today.Format("2006-01-02"):
+		return "overdue"
+	case balance < due-0.005:
+		return "partial"
+	default:
+		return "unpaid"
+	}
+}
+
+func respondHTTPError(w http.ResponseWriter, err error, fallback string) {
+	var ie *httpError
+	if errors.As(err, &ie) {
+		respondJSON(w, ie.status, map[string]string{"error": ie.message})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, map[string]string{"error": fallback})
+}
+
+func (s *Server) handleInvoices(w http.ResponseWriter, r *http.Request) {
+	kind := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("kind")))
+	if kind != "" && kind != invoiceKindInvoice && kind != invoiceKindCreditNote {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be invoice or credit_note"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	search := parseSearch(r)
+	offset := (page - 1) * pageSize
+
+	customerID, _ := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("customerId")), 10, 64)
+	const filter = `
+		i.farm_id = $1 AND ($2 = '' OR i.kind = $2) AND ($4 = 0 OR i.customer_id = $4)
+		AND ($3 = '' OR i.invoice_number ILIKE '%' || $3 || '%' OR i.buyer ILIKE '%' || $3 || '%'
+			OR EXISTS (SELECT 1 FROM invoice_lines l WHERE l.invoice_id = i.id AND l.product ILIKE '%' || $3 || '%'))
+	`
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM invoices i WHERE `+filter, farmID, kind, search, customerID).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT i.id, i.kind, i.invoice_number, i.invoice_date, i.due_date, i.customer_id, i.buyer, i.buyer_pin, i.reason,
+		       i.net_amount, i.vat_amount, i.total_amount, i.original_invoice_id, COALESCE(o.invoice_number, ''),
+		       (SELECT COUNT(*) FROM invoice_lines l WHERE l.invoice_id = i.id),
+		       COALESCE(b.credited_amount, 0), COALESCE(b.paid_amount, 0), COALESCE(b.balance, 0),
+		       COALESCE(e.status, '')
+		FROM invoices i
+		LEFT JOIN invoices o ON o.id = i.original_invoice_id
+		LEFT JOIN invoice_balances b ON b.invoice_id = i.id
+		LEFT JOIN etims_submissions e ON e.invoice_id = i.id
+		WHERE `+filter+`
+		ORDER BY i.invoice_date DESC, i.sequence DESC
+		LIMIT $5 OFFSET $6
+	`, farmID, kind, search, customerID, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load invoices"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, customerID int64
+		var originalID *int64
+		var kind, number, buyer, buyerPIN, reason, originalNumber, etimsStatus string
+		var d, due time.Time
+		var net, vat, gross, credited, paid, balance float64
+		var lineCount int
+		if err := rows.Scan(
+			&id, &kind, &number, &d, &due, &customerID, &buyer, &buyerPIN, &reason,
+			&net, &vat, &gross, &originalID, &originalNumber, &lineCount, &credited, &paid, &balance, &etimsStatus,
+		); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse invoices"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":                    id,
+			"kind":                  kind,
+			"invoiceNumber":         number,
+			"date":                  s.formatDateCompact(d),
+			"dateRaw":               s.formatISODate(d),
+			"dueDate":               s.formatISODate(due),
+			"customerId":            customerID,
+			"buyer":                 buyer,
+			"buyerPIN":              buyerPIN,
+			"reason":                reason,
+			"lineCount":             lineCount,
+			"netAmount":             net,
+			"vatAmount":             vat,
+			"totalAmount":           gross,
+			"total":                 formatKES(gross),
+			"creditedAmount":        credited,
+			"paidAmount":            paid,
+			"balance":               balance,
+			"paymentStatus":         paymentStatus(kind, balance, gross-credited, due, s.now()),
+			"originalInvoiceId":     originalID,
+			"originalInvoiceNumber": originalNumber,
+			"etimsStatus":           etimsStatus,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (s *Server) handleInvoice(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var kind, number, buyer, buyerPIN, county, subcounty, reason, originalNumber, etimsStatus string
+	var d, due time.Time
+	var customerID int64
+	var net, vat, gross, credited, paid, balance float64
+	var originalID *int64
+	err = s.db.QueryRow(ctx, `
+		SELECT i.kind, i.invoice_number, i.invoice_date, i.due_date, i.customer_id, i.buyer, i.buyer_pin, i.delivery_county, i.delivery_subcounty, i.reason,
+		       i.net_amount, i.vat_amount, i.total_amount, i.original_invoice_id, COALESCE(o.invoice_number, ''),
+		       COALESCE(b.credited_amount, 0), COALESCE(b.paid_amount, 0), COALESCE(b.balance, 0),
+		       COALESCE(e.status, '')
+		FROM invoices i
+		LEFT JOIN invoices o ON o.id = i.original_invoice_id
+		LEFT JOIN invoice_balances b ON b.invoice_id = i.id
+		LEFT JOIN etims_submissions e ON e.invoice_id = i.id
+		WHERE i.id = $1 AND i.farm_id = $2
+	`, invoiceID, farmID).Scan(
+		&kind, &number, &d, &due, &customerID, &buyer, &buyerPIN, &county, &subcounty, &reason,
+		&net, &vat, &gross, &originalID, &originalNumber, &credited, &paid, &balance, &etimsStatus,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load invoice"})
+		return
+	}
+
+	lines, err := s.loadInvoiceLines(ctx, invoiceID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load invoice lines"})
+		return
+	}
+	lineItems := make([]map[string]any, 0, len(lines))
+	for _, l := range lines {
+		lineItems = append(lineItems, l.asMap())
+	}
+
+	creditNotes := make([]map[string]any, 0)
+	rows, err := s.db.Query(ctx, `
+		SELECT id, invoice_number, invoice_date, reason, total_amount
+		FROM invoices
+		WHERE original_invoice_id = $1 AND farm_id = $2
+		ORDER BY sequence
+	`, invoiceID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load credit notes"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var cnNumber, cnReason string
+		var cnDate time.Time
+		var cnTotal float64
+		if err := rows.Scan(&id, &cnNumber, &cnDate, &cnReason, &cnTotal); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse credit notes"})
+			return
+		}
+		creditNotes = append(creditNotes, map[string]any{
+			"id":            id,
+			"invoiceNumber": cnNumber,
+			"dateRaw":       s.formatISODate(cnDate),
+			"reason":        cnReason,
+			"totalAmount":   cnTotal,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"id":                    invoiceID,
+		"kind":                  kind,
+		"invoiceNumber":         number,
+		"date":                  s.formatDateCompact(d),
+		"dateRaw":               s.formatISODate(d),
+		"dueDate":               s.formatISODate(due),
+		"customerId":            customerID,
+		"buyer":                 buyer,
+		"buyerPIN":              buyerPIN,
+		"deliveryCounty":        county,
+		"deliverySubcounty":     subcounty,
+		"reason":                reason,
+		"netAmount":             net,
+		"vatAmount":             vat,
+		"totalAmount":           gross,
+		"creditedAmount":        credited,
+		"paidAmount":            paid,
+		"balance":               balance,
+		"paymentStatus":         paymentStatus(kind, balance, gross-credited, due, s.now()),
+		"originalInvoiceId":     originalID,
+		"originalInvoiceNumber": originalNumber,
+		"etimsStatus":           etimsStatus,
+		"lines":                 lineItems,
+		"creditNotes":           creditNotes,
+	})
+}
+
+func (s *Server) handleCreateInvoice(w http.ResponseWriter, r *http.Request) {
+	var in invoiceInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	h, lines, msg := normalizeInvoiceInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	net, vat, total := invoiceTotals(lines)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var number string
+	id, err := s.auditedWrite(ctx, r, auditCreate, "invoices", 0, func(tx pgx.Tx) (int64, error) {
+		if err := resolveInvoiceCustomer(ctx, tx, farmID, in.CustomerID, &h); err != nil {
+			return 0, err
+		}
+		if err := checkCreditLimit(ctx, tx, h, total, 0); err != nil {
+			return 0, err
+		}
+		var seq int64
+		var err error
+		seq, number, err = nextInvoiceNumber(ctx, tx, farmID, invoiceKindInvoice)
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO invoices(
+				farm_id, kind, sequence, invoice_number, invoice_date, due_date, customer_id, buyer, buyer_pin,
+				delivery_county, delivery_subcounty, net_amount, vat_amount, total_amount
+			)
+			VALUES ($1, 'invoice', $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING id
+		`, farmID, seq, number, h.date, h.dueDate, h.customerID, h.buyer, h.buyerPIN, h.county, h.subcounty, net, vat, total).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, insertInvoiceLines(ctx, tx, id, lines)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to create invoice")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"ok":            true,
+		"id":            id,
+		"invoiceNumber": number,
+		"totalAmount":   total,
+		"warnings":      s.invoiceWithdrawalWarnings(ctx, farmID, h.date, lines),
+	})
+}
+
+// invoiceWithdrawalWarnings flags milk or meat sold on day while animals
+// are under a withdrawal. An invoice does not say which animals the produce
+// came from, so the sale goes through and the seller is reminded.
+func (s *Server) invoiceWithdrawalWarnings(ctx context.Context, farmID int64, day time.Time, lines []invoiceLine) []string {
+	warnings := make([]string, 0)
+	seen := map[string]bool{}
+	for _, l := range lines {
+		kind := withdrawalProduct(l.product)
+		if kind == "" || seen[kind] {
+			continue
+		}
+		seen[kind] = true
+		warnings = append(warnings, s.withdrawalWarnings(ctx, farmID, day, kind)...)
+	}
+	return warnings
+}
+
+// handleUpdateInvoice replaces an invoice's header and lines. Once KRA has the
+// invoice, or a credit note references it, it can only be corrected with a
+// credit note. A receipt that never got through is discarded with the old
+// lines and has to be generated again.
+func (s *Server) handleUpdateInvoice(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
+		return
+	}
+	var in invoiceInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	h, lines, msg := normalizeInvoiceInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	net, vat, total := invoiceTotals(lines)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "invoices", invoiceID, func(tx pgx.Tx) (int64, error) {
+		if err := lockInvoiceForChange(ctx, tx, farmID, invoiceID, true); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if err := resolveInvoiceCustomer(ctx, tx, farmID, in.CustomerID, &h); err != nil {
+			return 0, err
+		}
+		if err := checkCreditLimit(ctx, tx, h, total, invoiceID); err != nil {
+			return 0, err
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE invoices
+			SET invoice_date = $1, due_date = $2, customer_id = $3, buyer = $4, buyer_pin = $5, delivery_county = $6, delivery_subcounty = $7,
+				net_amount = $8, vat_amount = $9, total_amount = $10
+			WHERE id = $11 AND farm_id = $12
+		`, h.date, h.dueDate, h.customerID, h.buyer, h.buyerPIN, h.county, h.subcounty, net, vat, total, invoiceID, farmID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM invoice_lines WHERE invoice_id = $1`, invoiceID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM etims_submissions WHERE invoice_id = $1`, invoiceID); err != nil {
+			return 0, err
+		}
+		return invoiceID, insertInvoiceLines(ctx, tx, invoiceID, lines)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to update invoice")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "warnings": s.invoiceWithdrawalWarnings(ctx, farmID, h.date, lines)})
+}
+
+func (s *Server) handleDeleteInvoice(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "invoices", invoiceID, func(tx pgx.Tx) (int64, error) {
+		if err := lockInvoiceForChange(ctx, tx, farmID, invoiceID, false); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM invoices WHERE id = $1 AND farm_id = $2`, invoiceID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return invoiceID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete invoice")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// creditLines prices a credit note against the original lines, which carry
+// what earlier credit notes already took. requested maps line ids to the
+// quantity to credit, zero meaning whatever is left; when it is empty every
+// line is credited in full. Entries are removed as they are used.
+func creditLines(original []invoiceLine, requested map[int64]float64) ([]invoiceLine, error) {
+	credits := make([]invoiceLine, 0, len(original))
+	for _, o := range original {
+		remaining := roundCents(o.quantity - o.credited)
+		qty, wanted := requested[o.id]
+		if len(requested) > 0 && !wanted {
+			continue
+		}
+		delete(requested, o.id)
+		if qty == 0 {
+			qty = remaining
+		}
+		if qty <= 0 {
+			if wanted {
+				return nil, &httpError{http.StatusConflict, fmt.Sprintf("line %d has already been fully credited", o.lineNo)}
+			}
+			continue
+		}
+		if qty > remaining {
+			return nil, &httpError{http.StatusConflict, fmt.Sprintf("line %d has only %s %s left to credit", o.lineNo, trimZero(remaining), o.unit)}
+		}
+		lineTotal := roundCents(o.total * qty / o.quantity)
+		if qty == remaining {
+			// The last credit takes whatever is left so rounding never
+			// leaves a few cents behind.
+			lineTotal = roundCents(o.total - o.creditedTotal)
+		}
+		originalLineID := o.id
+		line := invoiceLine{
+			lineNo:         len(credits) + 1,
+			product:        o.product,
+			quantity:       qty,
+			unit:           o.unit,
+			price:          o.price,
+			vatClass:       o.vatClass,
+			vatRate:        o.vatRate,
+			total:          lineTotal,
+			originalLineID: &originalLineID,
+		}
+		line.net, line.vat = splitVAT(line.total, line.vatRate)
+		credits = append(credits, line)
+	}
+	if len(requested) > 0 {
+		return nil, &httpError{http.StatusBadRequest, "some lines are not on this invoice"}
+	}
+	if len(credits) == 0 {
+		return nil, &httpError{http.StatusConflict, "this invoice has already been fully credited"}
+	}
+	return credits, nil
+}
+
+// handleCreateCreditNote issues a credit note against an invoice. Without
+// lines everything not yet credited is reversed; otherwise each line names an
+// original line and the quantity to credit, priced pro rata.
+func (s *Server) handleCreateCreditNote(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
+		return
+	}
+	var in struct {
+		Date   string `json:"date"`
+		Reason string `json:"reason"`
+		Lines  []struct {
+			LineID        int64   `json:"lineId"`
+			QuantityValue float64 `json:"quantityValue"`
+		} `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	if in.Reason == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "reason is required"})
+		return
+	}
+	if strings.TrimSpace(in.Date) == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(in.Date))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	requested := make(map[int64]float64, len(in.Lines))
+	for _, l := range in.Lines {
+		if l.LineID <= 0 || l.QuantityValue < 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "each line needs a lineId and a positive quantityValue"})
+			return
+		}
+		if _, dup := requested[l.LineID]; dup {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "each line can only be credited once per credit note"})
+			return
+		}
+		requested[l.LineID] = l.QuantityValue
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var number string
+	var total float64
+	id, err := s.auditedWrite(ctx, r, auditCreate, "invoices", 0, func(tx pgx.Tx) (int64, error) {
+		var kind string
+		var h invoiceHeader
+		var invoiceDate time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT kind, invoice_date, customer_id, buyer, buyer_pin, delivery_county, delivery_subcounty
+			FROM invoices
+			WHERE id = $1 AND farm_id = $2
+			FOR UPDATE
+		`, invoiceID, farmID).Scan(&kind, &invoiceDate, &h.customerID, &h.buyer, &h.buyerPIN, &h.county, &h.subcounty)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if kind != invoiceKindInvoice {
+			return 0, &httpError{http.StatusConflict, "credit notes can only be issued against invoices"}
+		}
+		if d.Before(invoiceDate) {
+			return 0, &httpError{http.StatusBadRequest, "a credit note cannot be dated before its invoice"}
+		}
+		h.date = d
+
+		rows, err := tx.Query(ctx, `
+			SELECT l.id, l.line_no, l.product, l.quantity_value, l.quantity_unit, l.price_per_unit, l.vat_class, l.vat_rate,
+			       l.net_amount, l.vat_amount, l.total_amount, l.original_line_id,
+			       COALESCE(SUM(c.quantity_value), 0), COALESCE(SUM(c.total_amount), 0)
+			FROM invoice_lines l
+			LEFT JOIN invoice_lines c ON c.original_line_id = l.id
+			WHERE l.invoice_id = $1
+			GROUP BY l.id
+			ORDER BY l.line_no
+		`, invoiceID)
+		if err != nil {
+			return 0, err
+		}
+		original, err := scanInvoiceLines(rows)
+		rows.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		credits, err := creditLines(original, requested)
+		if err != nil {
+			return 0, err
+		}
+
+		var net, vat float64
+		net, vat, total = invoiceTotals(credits)
+		var seq int64
+		seq, number, err = nextInvoiceNumber(ctx, tx, farmID, invoiceKindCreditNote)
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO invoices(
+				farm_id, kind, sequence, invoice_number, invoice_date, due_date, original_invoice_id, reason, customer_id, buyer, buyer_pin,
+				delivery_county, delivery_subcounty, net_amount, vat_amount, total_amount
+			)
+			VALUES ($1, 'credit_note', $2, $3, $4, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id
+		`, farmID, seq, number, h.date, invoiceID, in.Reason, h.customerID, h.buyer, h.buyerPIN, h.county, h.subcounty, net, vat, total).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, insertInvoiceLines(ctx, tx, id, credits)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to create credit note")
+		return
+	}
+	if id == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "invoiceNumber": number, "totalAmount": total})
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSplitVAT(t *testing.T) {
+	tests := []struct {
+		total, rate float64
+		net, vat    float64
+	}{
+		{116, 0.16, 100, 16},
+		{100, 0.16, 86.21, 13.79},
+		{108, 0.08, 100, 8},
+		{50, 0, 50, 0},
+		{0.01, 0.16, 0.01, 0},
+		{-116, 0.16, -100, -16},
+	}
+	for _, tt := range tests {
+		net, vat := splitVAT(tt.total, tt.rate)
+		if net != tt.net || vat != tt.vat {
+			t.Errorf("splitVAT(%v, %v) = %v, %v, want %v, %v", tt.total, tt.rate, net, vat, tt.net, tt.vat)
+		}
+		if got := roundCents(net + vat); got != tt.total {
+			t.Errorf("splitVAT(%v, %v) parts add up to %v", tt.total, tt.rate, got)
+		}
+	}
+}
+
+func TestCreditLines(t *testing.T) {
+	milk := invoiceLine{id: 10, lineNo: 1, product: "Milk", quantity: 3, unit: "l", price: 33.3333, vatClass: "A", total: 100}
+	feed := invoiceLine{id: 11, lineNo: 2, product: "Feed", quantity: 2, unit: "kg", price: 58, vatClass: "B", vatRate: 0.16, total: 116}
+
+	partly := milk
+	partly.credited, partly.creditedTotal = 2, 66.66
+	spent := feed
+	spent.credited, spent.creditedTotal = 2, 116
+
+	type want struct {
+		lineID     int64
+		qty, total float64
+		net, vat   float64
+	}
+	tests := []struct {
+		name      string
+		original  []invoiceLine
+		requested map[int64]float64
+		want      []want
+		status    int
+	}{
+		{
+			name:     "everything left",
+			original: []invoiceLine{milk, feed},
+			want:     []want{{10, 3, 100, 100, 0}, {11, 2, 116, 100, 16}},
+		},
+		{
+			name:      "pro rata",
+			original:  []invoiceLine{milk, feed},
+			requested: map[int64]float64{10: 1, 11: 1},
+			want:      []want{{10, 1, 33.33, 33.33, 0}, {11, 1, 58, 50, 8}},
+		},
+		{
+			name:      "zero quantity takes the rest",
+			original:  []invoiceLine{milk, feed},
+			requested: map[int64]float64{11: 0},
+			want:      []want{{11, 2, 116, 100, 16}},
+		},
+		{
+			name:     "last credit absorbs rounding",
+			original: []invoiceLine{partly},
+			want:     []want{{10, 1, 33.34, 33.34, 0}},
+		},
+		{
+			name:     "fully credited lines are skipped",
+			original: []invoiceLine{partly, spent},
+			want:     []want{{10, 1, 33.34, 33.34, 0}},
+		},
+		{
+			name:     "nothing left",
+			original: []invoiceLine{spent},
+			status:   http.StatusConflict,
+		},
+		{
+			name:      "requested line already credited",
+			original:  []invoiceLine{partly, spent},
+			requested: map[int64]float64{11: 1},
+			status:    http.StatusConflict,
+		},
+		{
+			name:      "more than remains",
+			original:  []invoiceLine{partly},
+			requested: map[int64]float64{10: 2},
+			status:    http.StatusConflict,
+		},
+		{
+			name:      "line from another invoice",
+			original:  []invoiceLine{milk},
+			requested: map[int64]float64{10: 1, 99: 1},
+			status:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := creditLines(tt.original, tt.requested)
+			if tt.status != 0 {
+				var he *httpError
+				if !errors.As(err, &he) || he.status != tt.status {
+					t.Fatalf("err = %v, want status %d", err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				l := got[i]
+				if l.lineNo != i+1 || l.originalLineID == nil || *l.originalLineID != w.lineID {
+					t.Errorf("line %d: numbered %d against %v, want line %d", i, l.lineNo, l.originalLineID, w.lineID)
+				}
+				if l.quantity != w.qty || l.total != w.total || l.net != w.net || l.vat != w.vat {
+					t.Errorf("line %d: qty %v total %v net %v vat %v, want %v %v %v %v", i, l.quantity, l.total, l.net, l.vat, w.qty, w.total, w.net, w.vat)
+				}
+			}
+		})
+	}
+}
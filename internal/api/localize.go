@@ -29,6 +29,13 @@ func (s *Server) now() time.Time {
 	return time.Now().In(s.location)
 }
 
+// today is the farm's current date at midnight UTC, the way DATE columns
+// scan, so it compares directly with them.
+func (s *Server) today() time.Time {
+	now := s.now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
 func (s *Server) formatDate(d time.Time) string {
 	if s.location == nil {
 		return d.Format("02/01/2006")
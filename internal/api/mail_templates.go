@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+// Every email has a plain text and an HTML rendering of the same template.
+// The HTML templates are wrapped in mailLayoutHTML.
+
+const mailLayoutHTML = `<!DOCTYPE html>
+<html>
+<body style="margin:0;padding:24px;background:#f4f6f3;font-family:Arial,Helvetica,sans-serif;color:#1f2a1f;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0">
+<tr><td align="center">
+<table role="presentation" width="560" cellpadding="0" cellspacing="0" style="background:#ffffff;border-radius:8px;">
+<tr><td style="padding:20px 28px;background:#2f6b3a;border-radius:8px 8px 0 0;color:#ffffff;font-size:20px;font-weight:bold;">FarmPro</td></tr>
+<tr><td style="padding:28px;font-size:15px;line-height:1.5;">{{template "content" .}}</td></tr>
+<tr><td style="padding:16px 28px;font-size:12px;color:#6b776b;border-top:1px solid #e3e8e2;">{{block "footer" .}}You received this email because of your FarmPro account.{{end}}</td></tr>
+</table>
+</td></tr>
+</table>
+</body>
+</html>`
+
+const mailButtonHTML = `{{define "button"}}<p style="margin:24px 0;"><a href="{{.}}" style="display:inline-block;padding:12px 22px;background:#2f6b3a;color:#ffffff;text-decoration:none;border-radius:6px;font-weight:bold;">{{template "buttonLabel"}}</a></p>
+<p style="font-size:13px;color:#6b776b;">If the button does not work, copy this link into your browser:<br><a href="{{.}}" style="color:#2f6b3a;word-break:break-all;">{{.}}</a></p>{{end}}`
+
+type mailTemplate struct {
+	text string
+	html string
+}
+
+var mailTemplateSources = map[string]mailTemplate{
+	"verify": {
+		text: `Hi {{.Name}},
+
+Verify your FarmPro account by opening this link:
+{{.URL}}
+
+This link expires in 24 hours.`,
+		html: `{{define "buttonLabel"}}Verify email{{end}}{{define "content"}}<p>Hi {{.Name}},</p>
+<p>Welcome to FarmPro. Confirm your email address to finish setting up your account.</p>
+{{template "button" .URL}}
+<p>This link expires in 24 hours.</p>{{end}}`,
+	},
+	"reset": {
+		text: `Use this link to reset your FarmPro password:
+{{.URL}}
+
+This link expires in 30 minutes.`,
+		html: `{{define "buttonLabel"}}Reset password{{end}}{{define "content"}}<p>Someone asked to reset the password for your FarmPro account.</p>
+{{template "button" .URL}}
+<p>This link expires in 30 minutes. If you did not ask for a reset you can ignore this email.</p>{{end}}`,
+	},
+	"notification": {
+		text: `{{.Intro}}
+{{if .Facts}}
+{{range .Facts}}{{.Label}}: {{.Value}}
+{{end}}{{end}}{{if .Footer}}
+{{.Footer}}{{end}}`,
+		html: `{{define "content"}}<h2 style="margin:0 0 12px;font-size:18px;">{{.Title}}</h2>
+<p>{{.Intro}}</p>
+{{if .Facts}}<table role="presentation" cellpadding="0" cellspacing="0" style="width:100%;border-collapse:collapse;margin-top:12px;">
+{{range .Facts}}<tr><td style="padding:6px 0;border-bottom:1px solid #e3e8e2;color:#6b776b;">{{.Label}}</td><td style="padding:6px 0;border-bottom:1px solid #e3e8e2;text-align:right;font-weight:bold;">{{.Value}}</td></tr>
+{{end}}</table>{{end}}{{end}}{{define "footer"}}{{if .Footer}}{{.Footer}}{{else}}You received this email because of your FarmPro account.{{end}}{{end}}`,
+	},
+}
+
+type verifyEmailData struct {
+	Name string
+	URL  string
+}
+
+type resetPasswordData struct {
+	URL string
+}
+
+type mailFact struct {
+	Label string
+	Value string
+}
+
+type notificationData struct {
+	Title  string
+	Intro  string
+	Facts  []mailFact
+	Footer string
+}
+
+var (
+	mailTextTemplates = map[string]*template.Template{}
+	mailHTMLTemplates = map[string]*htmltemplate.Template{}
+)
+
+func init() {
+	for name, src := range mailTemplateSources {
+		mailTextTemplates[name] = template.Must(template.New(name).Parse(src.text))
+		layout := htmltemplate.Must(htmltemplate.New(name).Parse(mailLayoutHTML))
+		htmltemplate.Must(layout.Parse(mailButtonHTML))
+		mailHTMLTemplates[name] = htmltemplate.Must(layout.Parse(src.html))
+	}
+}
+
+// renderMail renders the named template into a message for to.
+func renderMail(to, subject, name string, data any) (mailMessage, error) {
+	msg := mailMessage{to: to, subject: subject}
+	var text, html bytes.Buffer
+	if err := mailTextTemplates[name].Execute(&text, data); err != nil {
+		return msg, err
+	}
+	if err := mailHTMLTemplates[name].Execute(&html, data); err != nil {
+		return msg, err
+	}
+	msg.text = strings.TrimSpace(text.String()) + "\n"
+	msg.html = html.String()
+	return msg, nil
+}
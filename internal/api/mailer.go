@@ -1,9 +1,32 @@
 package api
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
 	"strings"
+	"time"
+)
+
+const (
+	// mailTLSStartTLS connects in plain text and upgrades with STARTTLS,
+	// which the server must offer.
+	mailTLSStartTLS = "starttls"
+	// mailTLSImplicit speaks TLS from the first byte, as on port 465.
+	mailTLSImplicit = "tls"
+
+	mailTimeout = 30 * time.Second
 )
 
 type smtpMailer struct {
@@ -13,19 +36,47 @@ type smtpMailer struct {
 	password string
 	fromName string
 	fromAddr string
+	tlsMode  string
+}
+
+// mailAttachment is a file sent along with an email, such as a report.
+type mailAttachment struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// mailMessage is one email to one recipient. html is optional; when set the
+// message carries both renderings as multipart/alternative.
+type mailMessage struct {
+	to          string
+	subject     string
+	text        string
+	html        string
+	attachments []mailAttachment
 }
 
-func NewSMTPMailer(host, port, username, password, fromName, fromAddr string) *smtpMailer {
+// NewSMTPMailer returns nil unless the server, credentials and sender are all
+// set. tlsMode is "starttls" or "tls"; empty picks implicit TLS on port 465
+// and STARTTLS everywhere else.
+func NewSMTPMailer(host, port, username, password, fromName, fromAddr, tlsMode string) *smtpMailer {
 	host = strings.TrimSpace(host)
 	port = strings.TrimSpace(port)
 	username = strings.TrimSpace(username)
 	password = strings.TrimSpace(password)
 	fromAddr = strings.TrimSpace(fromAddr)
 	fromName = strings.TrimSpace(fromName)
+	tlsMode = strings.ToLower(strings.TrimSpace(tlsMode))
 
 	if host == "" || port == "" || username == "" || password == "" || fromAddr == "" {
 		return nil
 	}
+	if tlsMode == "" {
+		tlsMode = mailTLSStartTLS
+		if port == "465" {
+			tlsMode = mailTLSImplicit
+		}
+	}
 	return &smtpMailer{
 		host:     host,
 		port:     port,
@@ -33,34 +84,220 @@ func NewSMTPMailer(host, port, username, password, fromName, fromAddr string) *s
 		password: password,
 		fromName: fromName,
 		fromAddr: fromAddr,
+		tlsMode:  tlsMode,
+	}
+}
+
+// sendTemplate renders the named mail template for data and sends it with
+// any attachments.
+func (m *smtpMailer) sendTemplate(toEmail, subject, name string, data any, attachments ...mailAttachment) error {
+	if m == nil {
+		return nil
 	}
+	msg, err := renderMail(toEmail, subject, name, data)
+	if err != nil {
+		return fmt.Errorf("render %s email: %w", name, err)
+	}
+	msg.attachments = attachments
+	return m.deliver(msg)
 }
 
-func (m *smtpMailer) send(toEmail, subject, plainBody string) error {
+func (m *smtpMailer) deliver(msg mailMessage) error {
 	if m == nil {
 		return nil
 	}
-	toEmail = strings.TrimSpace(toEmail)
-	if toEmail == "" {
+	msg.to = strings.TrimSpace(msg.to)
+	if msg.to == "" {
 		return fmt.Errorf("missing recipient")
 	}
+	raw, err := m.buildMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	c, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("AUTH"); ok {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.fromAddr); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *smtpMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.host, m.port)
+	tlsConfig := &tls.Config{ServerName: m.host}
+	dialer := &net.Dialer{Timeout: mailTimeout}
+
+	var conn net.Conn
+	var err error
+	if m.tlsMode == mailTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(mailTimeout))
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if m.tlsMode == mailTLSImplicit {
+		return c, nil
+	}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		c.Close()
+		return nil, fmt.Errorf("%s does not offer STARTTLS", addr)
+	}
+	if err := c.StartTLS(tlsConfig); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// buildMessage writes the headers and MIME body of msg. The text and HTML
+// bodies are quoted-printable, attachments base64, and the subject and sender
+// name are RFC 2047 encoded when they are not plain ASCII.
+func (m *smtpMailer) buildMessage(msg mailMessage) ([]byte, error) {
+	var out bytes.Buffer
+	from := mail.Address{Name: m.fromName, Address: m.fromAddr}
+	out.WriteString("From: " + from.String() + "\r\n")
+	out.WriteString("To: " + msg.to + "\r\n")
+	out.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", msg.subject) + "\r\n")
+	out.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	messageID, err := m.messageID()
+	if err != nil {
+		return nil, err
+	}
+	out.WriteString("Message-ID: " + messageID + "\r\n")
+	out.WriteString("MIME-Version: 1.0\r\n")
+
+	var body bytes.Buffer
+	bodyHeader, err := writeMailBody(&body, msg.text, msg.html)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.attachments) == 0 {
+		for _, k := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if v := bodyHeader.Get(k); v != "" {
+				out.WriteString(k + ": " + v + "\r\n")
+			}
+		}
+		out.WriteString("\r\n")
+		out.Write(body.Bytes())
+		return out.Bytes(), nil
+	}
 
-	auth := smtp.PlainAuth("", m.username, m.password, m.host)
-	addr := m.host + ":" + m.port
-	fromHeader := m.fromAddr
-	if m.fromName != "" {
-		fromHeader = fmt.Sprintf("%s <%s>", m.fromName, m.fromAddr)
+	mixed := multipart.NewWriter(&out)
+	out.WriteString("Content-Type: multipart/mixed; boundary=" + mixed.Boundary() + "\r\n\r\n")
+	part, err := mixed.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, err
 	}
+	if _, err := part.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+	for _, a := range msg.attachments {
+		contentType := a.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.filename}))
+		part, err := mixed.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.data)
+		for len(encoded) > 76 {
+			if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+				return nil, err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := part.Write([]byte(encoded + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
 
-	msg := strings.Join([]string{
-		fmt.Sprintf("From: %s", fromHeader),
-		fmt.Sprintf("To: %s", toEmail),
-		fmt.Sprintf("Subject: %s", subject),
-		"MIME-Version: 1.0",
-		"Content-Type: text/plain; charset=UTF-8",
-		"",
-		plainBody,
-	}, "\r\n")
+// writeMailBody writes the text body, or the text and HTML bodies as
+// multipart/alternative, and returns the header that describes it.
+func writeMailBody(w io.Writer, text, html string) (textproto.MIMEHeader, error) {
+	h := textproto.MIMEHeader{}
+	if html == "" {
+		h.Set("Content-Type", "text/plain; charset=UTF-8")
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		return h, writeQuotedPrintable(w, text)
+	}
 
-	return smtp.SendMail(addr, auth, m.fromAddr, []string{toEmail}, []byte(msg))
+	alt := multipart.NewWriter(w)
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	} {
+		ph := textproto.MIMEHeader{}
+		ph.Set("Content-Type", body.contentType)
+		ph.Set("Content-Transfer-Encoding", "quoted-printable")
+		part, err := alt.CreatePart(ph)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(part, body.content); err != nil {
+			return nil, err
+		}
+	}
+	h.Set("Content-Type", "multipart/alternative; boundary="+alt.Boundary())
+	return h, alt.Close()
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func (m *smtpMailer) messageID() (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", err
+	}
+	domain := m.host
+	if _, d, ok := strings.Cut(m.fromAddr, "@"); ok {
+		domain = d
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(token[:]), domain), nil
 }
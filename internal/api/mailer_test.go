@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestNewSMTPMailerTLSMode(t *testing.T) {
+	tests := []struct {
+		port, mode string
+		want       string
+	}{
+		{"587", "", mailTLSStartTLS},
+		{"465", "", mailTLSImplicit},
+		{"2525", " TLS ", mailTLSImplicit},
+		{"465", "starttls", mailTLSStartTLS},
+	}
+	for _, tt := range tests {
+		m := NewSMTPMailer("smtp.example.com", tt.port, "user", "secret", "FarmPro", "noreply@example.com", tt.mode)
+		if m == nil || m.tlsMode != tt.want {
+			t.Errorf("port %s mode %q: got %+v, want %s", tt.port, tt.mode, m, tt.want)
+		}
+	}
+	if m := NewSMTPMailer("smtp.example.com", "587", "user", "", "FarmPro", "noreply@example.com", ""); m != nil {
+		t.Error("a mailer without a password should be nil")
+	}
+}
+
+type mimePart struct {
+	contentType string
+	encoding    string
+	filename    string
+	body        string
+}
+
+// readParts flattens msg's body into its leaf parts, undoing the transfer
+// encodings.
+func readParts(t *testing.T, contentType, encoding string, body io.Reader) []mimePart {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("bad content type %q: %v", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding == "base64" {
+			data, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(data), "\r\n", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return []mimePart{{contentType: mediaType, encoding: encoding, body: string(data)}}
+	}
+	var parts []mimePart
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NextPart decodes quoted-printable and drops the header.
+		enc := p.Header.Get("Content-Transfer-Encoding")
+		if enc == "" && strings.HasPrefix(p.Header.Get("Content-Type"), "text/") {
+			enc = "quoted-printable"
+		}
+		sub := readParts(t, p.Header.Get("Content-Type"), enc, p)
+		if _, params, err := mime.ParseMediaType(p.Header.Get("Content-Disposition")); err == nil {
+			sub[0].filename = params["filename"]
+		}
+		parts = append(parts, sub...)
+	}
+	return parts
+}
+
+func TestBuildMessage(t *testing.T) {
+	m := NewSMTPMailer("smtp.example.com", "587", "user", "secret", "Shamba Bora", "reports@farm.example", "")
+	csv := mailAttachment{filename: "herd report.csv", contentType: "text/csv", data: []byte(strings.Repeat("tag,weight\n", 20))}
+	pdf := mailAttachment{filename: "summary.pdf", data: []byte{0x25, 0x50, 0x44, 0x46, 0x00, 0xff}}
+	longLine := strings.Repeat("maziwa ", 20) + "— lita 12"
+
+	tests := []struct {
+		name  string
+		msg   mailMessage
+		outer string
+		parts []mimePart
+	}{
+		{
+			name:  "text only",
+			msg:   mailMessage{text: longLine},
+			outer: "text/plain",
+			parts: []mimePart{{contentType: "text/plain", encoding: "quoted-printable", body: longLine}},
+		},
+		{
+			name:  "text and html",
+			msg:   mailMessage{text: "Hello", html: "<p>Hello</p>"},
+			outer: "multipart/alternative",
+			parts: []mimePart{
+				{contentType: "text/plain", encoding: "quoted-printable", body: "Hello"},
+				{contentType: "text/html", encoding: "quoted-printable", body: "<p>Hello</p>"},
+			},
+		},
+		{
+			name:  "attachments",
+			msg:   mailMessage{text: "Attached.", html: "<p>Attached.</p>", attachments: []mailAttachment{csv, pdf}},
+			outer: "multipart/mixed",
+			parts: []mimePart{
+				{contentType: "text/plain", encoding: "quoted-printable", body: "Attached."},
+				{contentType: "text/html", encoding: "quoted-printable", body: "<p>Attached.</p>"},
+				{contentType: "text/csv", encoding: "base64", filename: "herd report.csv", body: string(csv.data)},
+				{contentType: "application/octet-stream", encoding: "base64", filename: "summary.pdf", body: string(pdf.data)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.msg.to = "owner@farm.example"
+			tt.msg.subject = "Ripoti ya wiki – Machi"
+			raw, err := m.buildMessage(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range bytes.Split(raw, []byte("\r\n")) {
+				if len(line) > 998 {
+					t.Fatalf("line of %d bytes", len(line))
+				}
+			}
+			parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := parsed.Header
+			subject, err := new(mime.WordDecoder).DecodeHeader(h.Get("Subject"))
+			if err != nil || subject != tt.msg.subject {
+				t.Errorf("subject = %q (%v), want %q", subject, err, tt.msg.subject)
+			}
+			from, err := mail.ParseAddress(h.Get("From"))
+			if err != nil || from.Name != "Shamba Bora" || from.Address != "reports@farm.example" {
+				t.Errorf("from = %q", h.Get("From"))
+			}
+			if h.Get("To") != "owner@farm.example" || h.Get("MIME-Version") != "1.0" {
+				t.Errorf("headers = %v", h)
+			}
+			if id := h.Get("Message-Id"); !strings.HasSuffix(id, "@farm.example>") {
+				t.Errorf("Message-ID = %q", id)
+			}
+			if _, err := h.Date(); err != nil {
+				t.Errorf("Date: %v", err)
+			}
+			if mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mediaType != tt.outer {
+				t.Errorf("Content-Type = %q, want %s", h.Get("Content-Type"), tt.outer)
+			}
+
+			body := parsed.Body
+			if h.Get("Content-Transfer-Encoding") == "quoted-printable" {
+				body = quotedprintable.NewReader(body)
+			}
+			parts := readParts(t, h.Get("Content-Type"), h.Get("Content-Transfer-Encoding"), body)
+			if len(parts) != len(tt.parts) {
+				t.Fatalf("got %d parts, want %d: %+v", len(parts), len(tt.parts), parts)
+			}
+			for i, want := range tt.parts {
+				if parts[i] != want {
+					t.Errorf("part %d = %+v, want %+v", i, parts[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,804 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	medicineMoveAdjustment = "adjustment"
+	medicineMoveDisposal   = "disposal"
+
+	// medicineExpiryWarningDays is how close to expiry stock is flagged.
+	medicineExpiryWarningDays = 30
+)
+
+type medicineInput struct {
+	Name                string `json:"name"`
+	ActiveIngredient    string `json:"activeIngredient"`
+	Unit                string `json:"unit"`
+	MilkWithdrawalHours int    `json:"milkWithdrawalHours"`
+	MeatWithdrawalDays  int    `json:"meatWithdrawalDays"`
+	Notes               string `json:"notes"`
+	IsActive            *bool  `json:"isActive"`
+}
+
+func normalizeMedicineInput(in medicineInput) (medicineInput, string) {
+	in.Name = strings.Join(strings.Fields(in.Name), " ")
+	in.ActiveIngredient = strings.Join(strings.Fields(in.ActiveIngredient), " ")
+	in.Notes = strings.TrimSpace(in.Notes)
+	if in.Name == "" {
+		return in, "name is required"
+	}
+	var ok bool
+	if in.Unit, ok = normalizeUnit(in.Unit, "ml"); !ok {
+		return in, "unit must be one of " + unitCodes()
+	}
+	if in.MilkWithdrawalHours < 0 || in.MeatWithdrawalDays < 0 {
+		return in, "withdrawal periods cannot be negative"
+	}
+	if in.IsActive == nil {
+		active := true
+		in.IsActive = &active
+	}
+	return in, ""
+}
+
+// withdrawalEnd is the last day produce must be kept out after a dose given
+// on d, or nil when the label has no withdrawal.
+func withdrawalEnd(d time.Time, days int) *time.Time {
+	if days <= 0 {
+		return nil
+	}
+	end := d.AddDate(0, 0, days)
+	return &end
+}
+
+// recordMedicineInput is what a health record says was given. A batch
+// implies its medicine; a dose drawn from a batch comes off its stock.
+type recordMedicineInput struct {
+	MedicineID   *int64   `json:"medicineId"`
+	BatchID      *int64   `json:"batchId"`
+	DoseQuantity *float64 `json:"doseQuantity"`
+	DoseUnit     string   `json:"doseUnit"`
+}
+
+func (in recordMedicineInput) given() bool {
+	return in.MedicineID != nil || in.BatchID != nil
+}
+
+func normalizeRecordMedicineInput(in recordMedicineInput) (recordMedicineInput, string) {
+	if in.DoseQuantity != nil && *in.DoseQuantity <= 0 {
+		return in, "doseQuantity must be positive"
+	}
+	if in.BatchID != nil && in.DoseQuantity == nil {
+		return in, "doseQuantity is required to draw from a batch"
+	}
+	var ok bool
+	if in.DoseUnit, ok = normalizeUnit(in.DoseUnit, ""); !ok {
+		return in, "doseUnit must be one of " + unitCodes()
+	}
+	return in, ""
+}
+
+type recordMedicine struct {
+	medicineID *int64
+	batchID    *int64
+	name       string
+	dose       *float64
+	doseUnit   string
+	// issued is the dose in the medicine's own unit, as it comes off stock.
+	issued    float64
+	milkUntil *time.Time
+	meatUntil *time.Time
+}
+
+// resolveRecordMedicine checks the medicine a record dated on says was used
+// and works out the withdrawal it starts. The batch is locked so two doses
+// cannot both take its last stock.
+func resolveRecordMedicine(ctx context.Context, tx pgx.Tx, farmID int64, on time.Time, in recordMedicineInput) (recordMedicine, error) {
+	m := recordMedicine{dose: in.DoseQuantity, doseUnit: in.DoseUnit}
+	if !in.given() {
+		return m, nil
+	}
+	medicineID := in.MedicineID
+	if in.BatchID != nil {
+		var batchMedicine int64
+		var expiry time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT medicine_id, expiry_date FROM medicine_batches WHERE id = $1 AND farm_id = $2 FOR UPDATE
+		`, *in.BatchID, farmID).Scan(&batchMedicine, &expiry)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return m, &httpError{http.StatusBadRequest, "medicine batch not found"}
+		}
+		if err != nil {
+			return m, err
+		}
+		if medicineID != nil && *medicineID != batchMedicine {
+			return m, &httpError{http.StatusBadRequest, "the batch is not of that medicine"}
+		}
+		if expiry.Before(on) {
+			return m, &httpError{http.StatusBadRequest, "the batch expired on " + expiry.Format("2006-01-02")}
+		}
+		medicineID = &batchMedicine
+	}
+
+	var unit string
+	var milkHours, meatDays int
+	err := tx.QueryRow(ctx, `
+		SELECT name, unit, milk_withdrawal_hours, meat_withdrawal_days FROM medicines WHERE id = $1 AND farm_id = $2
+	`, *medicineID, farmID).Scan(&m.name, &unit, &milkHours, &meatDays)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return m, &httpError{http.StatusBadRequest, "medicine not found"}
+	}
+	if err != nil {
+		return m, err
+	}
+	m.medicineID = medicineID
+	if m.dose != nil {
+		if m.doseUnit == "" {
+			m.doseUnit = unit
+		}
+		issued, ok := convertQuantity(*m.dose, m.doseUnit, unit, 0)
+		if !ok {
+			return m, &httpError{http.StatusBadRequest, fmt.Sprintf("a dose in %s cannot be taken from stock kept in %s", m.doseUnit, unit)}
+		}
+		m.issued = issued
+	}
+	if in.BatchID != nil {
+		var onHand float64
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(quantity), 0)::float8 FROM medicine_stock_movements WHERE batch_id = $1
+		`, *in.BatchID).Scan(&onHand); err != nil {
+			return m, err
+		}
+		if onHand+1e-9 < m.issued {
+			return m, &httpError{http.StatusConflict, fmt.Sprintf("the batch has only %s %s left", trimZero(onHand), unit)}
+		}
+		m.batchID = in.BatchID
+	}
+	// A milk withdrawal in hours runs to the end of the day it finishes on,
+	// since the record does not say when in the day the dose was given.
+	m.milkUntil = withdrawalEnd(on, (milkHours+23)/24)
+	m.meatUntil = withdrawalEnd(on, meatDays)
+	return m, nil
+}
+
+// issueRecordMedicine takes the dose a health record gave off its batch.
+func issueRecordMedicine(ctx context.Context, tx pgx.Tx, farmID, recordID int64, on time.Time, m recordMedicine) error {
+	if m.batchID == nil || m.issued <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO medicine_stock_movements(farm_id, batch_id, kind, quantity, movement_date, health_record_id)
+		VALUES ($1, $2, 'issue', $3, $4, $5)
+	`, farmID, *m.batchID, -m.issued, on, recordID)
+	return err
+}
+
+type animalWithdrawal struct {
+	animalID   int64
+	tag        string
+	animalType string
+	milkUntil  *time.Time
+	meatUntil  *time.Time
+	treatments string
+}
+
+// activeWithdrawals returns the animals whose milk or meat must still be kept
+// out on day, or just animalID's when it is set.
+func activeWithdrawals(ctx context.Context, q rowQuerier, farmID, animalID int64, day time.Time) ([]animalWithdrawal, error) {
+	rows, err := q.Query(ctx, `
+		SELECT a.id, a.tag_id, a.type,
+			MAX(h.milk_withdrawal_until) FILTER (WHERE h.milk_withdrawal_until >= $3),
+			MAX(h.meat_withdrawal_until) FILTER (WHERE h.meat_withdrawal_until >= $3),
+			string_agg(DISTINCT h.treatment, ', ')
+		FROM health_records h
+		JOIN animals a ON a.id = h.animal_id
+		WHERE h.farm_id = $1 AND h.record_date <= $3
+			AND ($2::bigint = 0 AND a.is_active = true OR h.animal_id = $2)
+			AND (h.milk_withdrawal_until >= $3 OR h.meat_withdrawal_until >= $3)
+		GROUP BY a.id, a.tag_id, a.type
+		ORDER BY a.tag_id
+	`, farmID, animalID, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]animalWithdrawal, 0)
+	for rows.Next() {
+		var a animalWithdrawal
+		if err := rows.Scan(&a.animalID, &a.tag, &a.animalType, &a.milkUntil, &a.meatUntil, &a.treatments); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// withdrawalProduct says whether an invoice line sells milk or meat, the
+// produce withdrawal periods cover.
+func withdrawalProduct(product string) string {
+	p := strings.ToLower(product)
+	if strings.Contains(p, "milk") {
+		return "milk"
+	}
+	for _, word := range []string{"meat", "beef", "mutton", "chevon", "pork", "carcass"} {
+		if strings.Contains(p, word) {
+			return "meat"
+		}
+	}
+	return ""
+}
+
+// withdrawalWarnings lists the animals whose milk or meat (kind) must still
+// be kept out on day. It is for writes that cannot tell which animals the
+// produce came from, so they are warned rather than refused.
+func (s *Server) withdrawalWarnings(ctx context.Context, farmID int64, day time.Time, kind string) []string {
+	warnings := make([]string, 0)
+	list, err := activeWithdrawals(ctx, s.db, farmID, 0, day)
+	if err != nil {
+		log.Printf("withdrawals: farm %d: %v", farmID, err)
+		return warnings
+	}
+	for _, a := range list {
+		until := a.milkUntil
+		if kind == "meat" {
+			until = a.meatUntil
+		}
+		if until == nil {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s is under %s withdrawal until %s after %s; keep its %s out of sale", a.tag, kind, s.formatDate(*until), a.treatments, kind))
+	}
+	return warnings
+}
+
+func (s *Server) handleMedicines(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	today := s.today()
+
+	rows, err := s.db.Query(ctx, `
+		WITH stock AS (
+			SELECT b.id, b.medicine_id, b.expiry_date, COALESCE(SUM(m.quantity), 0) AS on_hand
+			FROM medicine_batches b
+			LEFT JOIN medicine_stock_movements m ON m.batch_id = b.id
+			WHERE b.farm_id = $1
+			GROUP BY b.id
+		)
+		SELECT d.id, d.name, d.active_ingredient, d.unit, d.milk_withdrawal_hours, d.meat_withdrawal_days, d.notes, d.is_active,
+			COALESCE(SUM(st.on_hand) FILTER (WHERE st.expiry_date >= $2), 0)::float8,
+			COALESCE(SUM(st.on_hand) FILTER (WHERE st.expiry_date < $2), 0)::float8,
+			MIN(st.expiry_date) FILTER (WHERE st.expiry_date >= $2 AND st.on_hand > 0)
+		FROM medicines d
+		LEFT JOIN stock st ON st.medicine_id = d.id
+		WHERE d.farm_id = $1
+		GROUP BY d.id
+		ORDER BY d.name
+	`, farmID, today)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load medicines"})
+		return
+	}
+	defer rows.Close()
+
+	search := strings.ToLower(parseSearch(r))
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var name, ingredient, unit, notes string
+		var milkHours, meatDays int
+		var active bool
+		var onHand, expired float64
+		var nextExpiry *time.Time
+		if err := rows.Scan(&id, &name, &ingredient, &unit, &milkHours, &meatDays, &notes, &active, &onHand, &expired, &nextExpiry); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse medicines"})
+			return
+		}
+		if search != "" && !strings.Contains(strings.ToLower(name), search) && !strings.Contains(strings.ToLower(ingredient), search) {
+			continue
+		}
+		var expiry any
+		expiringSoon := false
+		if nextExpiry != nil {
+			expiry = s.formatISODate(*nextExpiry)
+			expiringSoon = nextExpiry.Before(today.AddDate(0, 0, medicineExpiryWarningDays+1))
+		}
+		out = append(out, map[string]any{
+			"id":                  id,
+			"name":                name,
+			"activeIngredient":    ingredient,
+			"unit":                unit,
+			"milkWithdrawalHours": milkHours,
+			"meatWithdrawalDays":  meatDays,
+			"notes":               notes,
+			"isActive":            active,
+			"onHand":              onHand,
+			"expiredOnHand":       expired,
+			"nextExpiry":          expiry,
+			"expiringSoon":        expiringSoon,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"items": out, "total": len(out)})
+}
+
+func (s *Server) handleCreateMedicine(w http.ResponseWriter, r *http.Request) {
+	var in medicineInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in, msg := normalizeMedicineInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "medicines", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO medicines(farm_id, name, active_ingredient, unit, milk_withdrawal_hours, meat_withdrawal_days, notes, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`, farmID, in.Name, in.ActiveIngredient, in.Unit, in.MilkWithdrawalHours, in.MeatWithdrawalDays, in.Notes, *in.IsActive).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a medicine with this name already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create medicine"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+// handleUpdateMedicine edits a medicine. New withdrawal periods apply to
+// doses recorded from now on; the unit is fixed once stock is kept in it.
+func (s *Server) handleUpdateMedicine(w http.ResponseWriter, r *http.Request) {
+	medicineID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid medicine id"})
+		return
+	}
+	var in medicineInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in, msg := normalizeMedicineInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "medicines", medicineID, func(tx pgx.Tx) (int64, error) {
+		var unit string
+		var stocked bool
+		err := tx.QueryRow(ctx, `
+			SELECT unit, EXISTS (SELECT 1 FROM medicine_batches WHERE medicine_id = d.id)
+			FROM medicines d
+			WHERE id = $1 AND farm_id = $2
+			FOR UPDATE
+		`, medicineID, farmID).Scan(&unit, &stocked)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if stocked && unit != in.Unit {
+			return 0, &httpError{http.StatusConflict, "stock of this medicine is already kept in " + unit + "; the unit can no longer change"}
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE medicines
+			SET name = $1, active_ingredient = $2, unit = $3, milk_withdrawal_hours = $4, meat_withdrawal_days = $5, notes = $6, is_active = $7
+			WHERE id = $8
+		`, in.Name, in.ActiveIngredient, in.Unit, in.MilkWithdrawalHours, in.MeatWithdrawalDays, in.Notes, *in.IsActive, medicineID)
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				return 0, &httpError{http.StatusConflict, "a medicine with this name already exists"}
+			}
+			return 0, err
+		}
+		return medicineID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to update medicine")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "medicine not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleDeleteMedicine removes a medicine and its stock. One that treatments
+// were recorded with stays, so the records keep their withdrawal history;
+// it can be marked inactive instead.
+func (s *Server) handleDeleteMedicine(w http.ResponseWriter, r *http.Request) {
+	medicineID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid medicine id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "medicines", medicineID, func(tx pgx.Tx) (int64, error) {
+		var used bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM health_records WHERE medicine_id = $1)`, medicineID).Scan(&used); err != nil {
+			return 0, err
+		}
+		if used {
+			return 0, &httpError{http.StatusConflict, "treatments were recorded with this medicine; mark it inactive instead"}
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM medicines WHERE id = $1 AND farm_id = $2`, medicineID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return medicineID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete medicine")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "medicine not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleMedicineBatches(w http.ResponseWriter, r *http.Request) {
+	medicineID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid medicine id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	today := s.today()
+
+	rows, err := s.db.Query(ctx, `
+		SELECT b.id, b.batch_number, b.expiry_date, b.received_date, b.supplier_id, COALESCE(sp.name, ''), b.cost::float8,
+			COALESCE((SELECT SUM(quantity) FROM medicine_stock_movements WHERE batch_id = b.id), 0)::float8
+		FROM medicine_batches b
+		LEFT JOIN suppliers sp ON sp.id = b.supplier_id
+		WHERE b.medicine_id = $1 AND b.farm_id = $2
+		ORDER BY b.expiry_date, b.id
+	`, medicineID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load medicine batches"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var supplierID *int64
+		var number, supplier string
+		var expiry, received time.Time
+		var cost, onHand float64
+		if err := rows.Scan(&id, &number, &expiry, &received, &supplierID, &supplier, &cost, &onHand); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse medicine batches"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":           id,
+			"batchNumber":  number,
+			"expiryDate":   s.formatISODate(expiry),
+			"receivedDate": s.formatISODate(received),
+			"supplierId":   supplierID,
+			"supplier":     supplier,
+			"cost":         cost,
+			"onHand":       onHand,
+			"expired":      expiry.Before(today),
+			"expiringSoon": !expiry.Before(today) && expiry.Before(today.AddDate(0, 0, medicineExpiryWarningDays+1)),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"items": out, "total": len(out)})
+}
+
+// handleCreateMedicineBatch books a delivery of a medicine as a new batch.
+// quantity may be given in any unit that converts to the medicine's own.
+func (s *Server) handleCreateMedicineBatch(w http.ResponseWriter, r *http.Request) {
+	medicineID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid medicine id"})
+		return
+	}
+	var in struct {
+		BatchNumber  string  `json:"batchNumber"`
+		ExpiryDate   string  `json:"expiryDate"`
+		ReceivedDate string  `json:"receivedDate"`
+		SupplierID   *int64  `json:"supplierId"`
+		Quantity     float64 `json:"quantity"`
+		Unit         string  `json:"unit"`
+		Cost         float64 `json:"cost"`
+		Notes        string  `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.BatchNumber = strings.ToUpper(strings.TrimSpace(in.BatchNumber))
+	in.Notes = strings.TrimSpace(in.Notes)
+	if in.BatchNumber == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "batchNumber is required"})
+		return
+	}
+	if in.Quantity <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must be positive"})
+		return
+	}
+	if in.Cost < 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "cost must be non-negative"})
+		return
+	}
+	expiry, err := time.Parse("2006-01-02", strings.TrimSpace(in.ExpiryDate))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "expiryDate must be YYYY-MM-DD"})
+		return
+	}
+	received := s.today()
+	if strings.TrimSpace(in.ReceivedDate) != "" {
+		if received, err = time.Parse("2006-01-02", strings.TrimSpace(in.ReceivedDate)); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "receivedDate must be YYYY-MM-DD"})
+			return
+		}
+	}
+	unit, ok := normalizeUnit(in.Unit, "")
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "unit must be one of " + unitCodes()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "medicine_batches", 0, func(tx pgx.Tx) (int64, error) {
+		var stockUnit string
+		err := tx.QueryRow(ctx, `SELECT unit FROM medicines WHERE id = $1 AND farm_id = $2`, medicineID, farmID).Scan(&stockUnit)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusNotFound, "medicine not found"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		quantity := in.Quantity
+		if unit != "" {
+			if quantity, ok = convertQuantity(in.Quantity, unit, stockUnit, 0); !ok {
+				return 0, &httpError{http.StatusBadRequest, fmt.Sprintf("%s cannot be converted to %s, the unit this medicine is kept in", unit, stockUnit)}
+			}
+		}
+		if in.SupplierID != nil {
+			var exists bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM suppliers WHERE id = $1 AND farm_id = $2)`, *in.SupplierID, farmID).Scan(&exists); err != nil {
+				return 0, err
+			}
+			if !exists {
+				return 0, &httpError{http.StatusBadRequest, "supplier not found"}
+			}
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO medicine_batches(farm_id, medicine_id, batch_number, expiry_date, received_date, supplier_id, cost)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, farmID, medicineID, in.BatchNumber, expiry, received, in.SupplierID, roundCents(in.Cost)).Scan(&id)
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				return 0, &httpError{http.StatusConflict, "this batch number is already recorded for the medicine"}
+			}
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO medicine_stock_movements(farm_id, batch_id, kind, quantity, movement_date, notes, created_by)
+			VALUES ($1, $2, 'receipt', $3, $4, $5, NULLIF($6, 0))
+		`, farmID, id, quantity, received, in.Notes, userID)
+		return id, err
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to record medicine batch")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+// handleDeleteMedicineBatch removes a batch booked in error. Once doses have
+// been given from it, what is left has to be disposed of instead.
+func (s *Server) handleDeleteMedicineBatch(w http.ResponseWriter, r *http.Request) {
+	batchID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid batch id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "medicine_batches", batchID, func(tx pgx.Tx) (int64, error) {
+		var used bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM health_records WHERE batch_id = $1)`, batchID).Scan(&used); err != nil {
+			return 0, err
+		}
+		if used {
+			return 0, &httpError{http.StatusConflict, "doses were given from this batch; dispose of the remaining stock instead"}
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM medicine_batches WHERE id = $1 AND farm_id = $2`, batchID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return batchID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete medicine batch")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "batch not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleCreateMedicineStockMovement corrects a batch by hand: an adjustment
+// after a count, or a disposal of expired or spoilt stock. Doses given come
+// off through health records.
+func (s *Server) handleCreateMedicineStockMovement(w http.ResponseWriter, r *http.Request) {
+	batchID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid batch id"})
+		return
+	}
+	var in struct {
+		Date     string  `json:"date"`
+		Kind     string  `json:"kind"`
+		Quantity float64 `json:"quantity"`
+		Notes    string  `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	in.Notes = strings.TrimSpace(in.Notes)
+	quantity := in.Quantity
+	switch in.Kind {
+	case medicineMoveDisposal:
+		if in.Quantity <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must be positive"})
+			return
+		}
+		quantity = -in.Quantity
+	case medicineMoveAdjustment:
+		if in.Quantity == 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantity must not be zero"})
+			return
+		}
+		if in.Notes == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "notes must give the reason for an adjustment"})
+			return
+		}
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be adjustment or disposal"})
+		return
+	}
+	d := s.today()
+	if in.Date != "" {
+		parsed, err := time.Parse("2006-01-02", in.Date)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		d = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var left float64
+	id, err := s.auditedWrite(ctx, r, auditCreate, "medicine_stock_movements", 0, func(tx pgx.Tx) (int64, error) {
+		var balance float64
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE((SELECT SUM(quantity) FROM medicine_stock_movements WHERE batch_id = b.id), 0)::float8
+			FROM medicine_batches b
+			WHERE b.id = $1 AND b.farm_id = $2
+			FOR UPDATE
+		`, batchID, farmID).Scan(&balance)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusNotFound, "batch not found"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if balance+quantity < -1e-9 {
+			return 0, &httpError{http.StatusConflict, fmt.Sprintf("the batch has only %s left", trimZero(balance))}
+		}
+		left = balance + quantity
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO medicine_stock_movements(farm_id, batch_id, kind, quantity, movement_date, notes, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))
+			RETURNING id
+		`, farmID, batchID, in.Kind, quantity, d, in.Notes, userID).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to record stock movement")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "onHand": left})
+}
+
+// handleWithdrawals lists the animals whose milk or meat must be kept out on
+// date, today by default.
+func (s *Server) handleWithdrawals(w http.ResponseWriter, r *http.Request) {
+	day := s.today()
+	if v := strings.TrimSpace(r.URL.Query().Get("date")); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		day = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	list, err := activeWithdrawals(ctx, s.db, farmID, 0, day)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load withdrawals"})
+		return
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, a := range list {
+		item := map[string]any{
+			"animalTagId": a.tag,
+			"animalType":  a.animalType,
+			"treatments":  a.treatments,
+			"milkUntil":   nil,
+			"meatUntil":   nil,
+		}
+		if a.milkUntil != nil {
+			item["milkUntil"] = s.formatISODate(*a.milkUntil)
+		}
+		if a.meatUntil != nil {
+			item["meatUntil"] = s.formatISODate(*a.meatUntil)
+		}
+		out = append(out, item)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"date": s.formatISODate(day), "items": out, "total": len(out)})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithdrawalEnd(t *testing.T) {
+	dosed := time.Date(2024, 2, 27, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		days int
+		want string
+	}{
+		{0, ""},
+		{-3, ""},
+		{1, "2024-02-28"},
+		{3, "2024-03-01"},
+		{28, "2024-03-26"},
+	}
+	for _, tt := range tests {
+		got := withdrawalEnd(dosed, tt.days)
+		switch {
+		case tt.want == "" && got != nil:
+			t.Errorf("withdrawalEnd(%d days) = %s, want nil", tt.days, got.Format(time.DateOnly))
+		case tt.want != "" && (got == nil || got.Format(time.DateOnly) != tt.want):
+			t.Errorf("withdrawalEnd(%d days) = %v, want %s", tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestWithdrawalProduct(t *testing.T) {
+	tests := map[string]string{
+		"Fresh Milk":    "milk",
+		"MILK (bulk)":   "milk",
+		"Beef carcass":  "meat",
+		"Goat chevon":   "meat",
+		"Mutton":        "meat",
+		"Pork sausages": "meat",
+		"Minced meat":   "meat",
+		"Milk-fed pork": "milk",
+		"Eggs":          "",
+		"Hay bales":     "",
+		"":              "",
+	}
+	for product, want := range tests {
+		if got := withdrawalProduct(product); got != want {
+			t.Errorf("withdrawalProduct(%q) = %q, want %q", product, got, want)
+		}
+	}
+}
+
+func TestNormalizeRecordMedicineInput(t *testing.T) {
+	id := int64(7)
+	dose := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		in   recordMedicineInput
+		unit string
+		msg  string
+	}{
+		{"medicine without dose", recordMedicineInput{MedicineID: &id}, "", ""},
+		{"batch with dose and alias unit", recordMedicineInput{BatchID: &id, DoseQuantity: dose(2), DoseUnit: "Tablets"}, "pcs", ""},
+		{"batch without dose", recordMedicineInput{BatchID: &id}, "", "doseQuantity is required to draw from a batch"},
+		{"zero dose", recordMedicineInput{MedicineID: &id, DoseQuantity: dose(0)}, "", "doseQuantity must be positive"},
+		{"unknown unit", recordMedicineInput{MedicineID: &id, DoseQuantity: dose(5), DoseUnit: "spoon"}, "", "doseUnit must be one of " + unitCodes()},
+	}
+	for _, tt := range tests {
+		got, msg := normalizeRecordMedicineInput(tt.in)
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg == "" && got.DoseUnit != tt.unit {
+			t.Errorf("%s: doseUnit %q, want %q", tt.name, got.DoseUnit, tt.unit)
+		}
+	}
+}
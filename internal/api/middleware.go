@@ -40,18 +40,38 @@ func (s *Server) authRequired(next http.Handler) http.Handler {
 			return
 		}
 
+		sessionID, err := parseTokenUserID(claims["sid"])
+		if err != nil {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token session"})
+			return
+		}
+
 		dbCtx, cancel := context.WithTimeout(r.Context(), 4*time.Second)
 		defer cancel()
 
-		role, permissions, err := s.loadAuthContext(dbCtx, uid)
+		sessionFarmID, ok := s.sessionFarm(dbCtx, sessionID, uid)
+		if !ok {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "session revoked or expired"})
+			return
+		}
+
+		farmID, role, permissions, err := s.loadAuthContext(dbCtx, uid, sessionFarmID)
 		if err != nil {
 			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid user session"})
 			return
 		}
+		if farmID != sessionFarmID && farmID > 0 {
+			if err := s.setSessionFarm(dbCtx, sessionID, farmID); err != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to select farm"})
+				return
+			}
+		}
 
 		authCtx := context.WithValue(r.Context(), userIDContextKey, uid)
 		authCtx = context.WithValue(authCtx, userRoleContextKey, role)
 		authCtx = context.WithValue(authCtx, userPermissionsContextKey, permissions)
+		authCtx = context.WithValue(authCtx, sessionIDContextKey, sessionID)
+		authCtx = context.WithValue(authCtx, farmIDContextKey, farmID)
 		next.ServeHTTP(w, r.WithContext(authCtx))
 	})
 }
@@ -73,7 +93,7 @@ func (s *Server) permissionRequired(next http.Handler, requiredPermission string
 			respondJSON(w, http.StatusForbidden, map[string]string{"error": "insufficient permissions"})
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grantedPermissionContextKey, requiredPermission)))
 	})
 }
 
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -12,11 +13,12 @@ func (s *Server) handleMLInsights(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "ml service is not configured"})
 		return
 	}
-	days := r.URL.Query().Get("days")
-	targetURL := s.mlBaseURL + "/insights"
-	if days != "" {
-		targetURL += "?days=" + url.QueryEscape(days)
+	query := url.Values{}
+	query.Set("farmId", strconv.FormatInt(farmIDFrom(r.Context()), 10))
+	if days := r.URL.Query().Get("days"); days != "" {
+		query.Set("days", days)
 	}
+	targetURL := s.mlBaseURL + "/insights?" + query.Encode()
 
 	client := &http.Client{Timeout: 8 * time.Second}
 	resp, err := client.Get(targetURL)
@@ -37,11 +39,12 @@ func (s *Server) handleMLTrain(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "ml service is not configured"})
 		return
 	}
-	days := r.URL.Query().Get("days")
-	targetURL := s.mlBaseURL + "/train"
-	if days != "" {
-		targetURL += "?days=" + url.QueryEscape(days)
+	query := url.Values{}
+	query.Set("farmId", strconv.FormatInt(farmIDFrom(r.Context()), 10))
+	if days := r.URL.Query().Get("days"); days != "" {
+		query.Set("days", days)
 	}
+	targetURL := s.mlBaseURL + "/train?" + query.Encode()
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	req, err := http.NewRequest(http.MethodPost, targetURL, nil)
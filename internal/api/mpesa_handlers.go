@@ -0,0 +1,989 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"farmpro/backend/internal/mpesa"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	mpesaMatched   = "matched"
+	mpesaUnmatched = "unmatched"
+	mpesaIgnored   = "ignored"
+
+	mpesaMatchSTK       = "stk"
+	mpesaMatchReference = "reference"
+	mpesaMatchPhone     = "phone"
+	mpesaMatchManual    = "manual"
+)
+
+var mpesaReferenceStripRe = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// mpesaReferenceKey folds a typed account reference so "fp 00012" finds
+// invoice FP-00012.
+func mpesaReferenceKey(ref string) string {
+	return mpesaReferenceStripRe.ReplaceAllString(strings.ToUpper(ref), "")
+}
+
+// mpesaReceipt is a payment Daraja reported, before it is stored.
+type mpesaReceipt struct {
+	farmID    int64
+	source    string
+	receipt   string
+	at        time.Time
+	amount    float64
+	phone     string
+	payerName string
+	billRef   string
+	shortcode string
+	raw       []byte
+}
+
+func (s *Server) mpesaAccount(ctx context.Context, farmID int64) (mpesa.Account, error) {
+	var a mpesa.Account
+	err := s.db.QueryRow(ctx, `
+		SELECT shortcode, account_type, passkey
+		FROM mpesa_accounts
+		WHERE farm_id = $1
+	`, farmID).Scan(&a.ShortCode, &a.Type, &a.Passkey)
+	return a, err
+}
+
+// mpesaCallbackURLFor is where Daraja posts results. The shared token in the path
+// is what tells a real callback from a forged one, since Daraja does not sign
+// its requests. Daraja refuses URLs mentioning M-Pesa, hence the neutral path.
+func (s *Server) mpesaCallbackURLFor(kind string) string {
+	return s.mpesaCallbackURL + "/api/payments/callbacks/" + s.mpesaCallbackToken + "/" + kind
+}
+
+func (s *Server) mpesaCallbackAuthorized(r *http.Request) bool {
+	token := r.PathValue("token")
+	return s.mpesaCallbackToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.mpesaCallbackToken)) == 1
+}
+
+// respondDaraja acknowledges a callback in the shape Daraja expects.
+func respondDaraja(w http.ResponseWriter, code string, desc string) {
+	respondJSON(w, http.StatusOK, map[string]string{"ResultCode": code, "ResultDesc": desc})
+}
+
+// recordMpesaReceipt stores rec and, while it is unmatched, tries to settle an
+// invoice with it. invoiceID names the invoice an STK push was for; other
+// payments are matched by reference, then by the payer's phone. A receipt
+// reported twice is stored once. Both are audited as system writes.
+func (s *Server) recordMpesaReceipt(ctx context.Context, rec mpesaReceipt, invoiceID int64) (int64, string, error) {
+	ctx = systemContext(ctx, rec.farmID)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	var before []byte
+	status := mpesaUnmatched
+	action := auditCreate
+	err = tx.QueryRow(ctx, `
+		INSERT INTO mpesa_transactions(farm_id, source, receipt_number, transaction_time, amount, phone, payer_name, bill_ref, shortcode, raw)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::jsonb)
+		ON CONFLICT (receipt_number) DO NOTHING
+		RETURNING id
+	`, rec.farmID, rec.source, rec.receipt, rec.at.In(s.location), roundCents(rec.amount), rec.phone, rec.payerName, rec.billRef, rec.shortcode, string(rec.raw)).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		action = ""
+		err = tx.QueryRow(ctx, `
+			SELECT id, status FROM mpesa_transactions WHERE receipt_number = $1 AND farm_id = $2 FOR UPDATE
+		`, rec.receipt, rec.farmID).Scan(&id, &status)
+		if err == nil {
+			before, err = auditSnapshot(ctx, tx, "mpesa_transactions", id)
+		}
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if status == mpesaUnmatched {
+		method := mpesaMatchSTK
+		if invoiceID == 0 {
+			invoiceID, method, err = findMpesaInvoice(ctx, tx, rec)
+			if err != nil {
+				return 0, "", err
+			}
+		}
+		if invoiceID != 0 {
+			_, err = s.settleWithMpesa(ctx, tx, nil, rec.farmID, id, invoiceID, method, 0)
+			var ie *httpError
+			switch {
+			case err == nil:
+				status = mpesaMatched
+			case errors.As(err, &ie):
+				_, err = tx.Exec(ctx, `UPDATE mpesa_transactions SET note = $1 WHERE id = $2`, "not matched: "+ie.message, id)
+				if err != nil {
+					return 0, "", err
+				}
+			default:
+				return 0, "", err
+			}
+			if action == "" {
+				action = auditUpdate
+			}
+		}
+	}
+	// A receipt reported again with nothing left to match changes nothing.
+	if action != "" {
+		if err := s.recordAudit(ctx, tx, nil, action, "mpesa_transactions", id, before); err != nil {
+			return 0, "", err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", err
+	}
+	return id, status, nil
+}
+
+// findMpesaInvoice picks the open invoice a payment is for. A reference naming
+// an invoice that can take the amount wins. Otherwise the payer's phone must
+// belong to a customer with exactly one open invoice of that amount, or with
+// a single open invoice the amount fits in. Anything less certain is left for
+// manual reconciliation.
+func findMpesaInvoice(ctx context.Context, tx pgx.Tx, rec mpesaReceipt) (int64, string, error) {
+	if ref := mpesaReferenceKey(rec.billRef); ref != "" {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			SELECT invoice_id
+			FROM invoice_balances
+			WHERE farm_id = $1 AND regexp_replace(upper(invoice_number), '[^A-Z0-9]+', '', 'g') = $2 AND balance >= $3 - 0.005
+		`, rec.farmID, ref, rec.amount).Scan(&id)
+		if err == nil {
+			return id, mpesaMatchReference, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", err
+		}
+	}
+	if rec.phone == "" {
+		return 0, "", nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT b.invoice_id, b.balance
+		FROM invoice_balances b
+		JOIN customers c ON c.id = b.customer_id
+		WHERE b.farm_id = $1 AND c.phone = $2 AND b.balance > 0.005
+		ORDER BY b.due_date, b.invoice_id
+	`, rec.farmID, rec.phone)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+	var open []openBalance
+	for rows.Next() {
+		var b openBalance
+		if err := rows.Scan(&b.invoiceID, &b.balance); err != nil {
+			return 0, "", err
+		}
+		open = append(open, b)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if id := phoneMatch(open, rec.amount); id != 0 {
+		return id, mpesaMatchPhone, nil
+	}
+	return 0, "", nil
+}
+
+type openBalance struct {
+	invoiceID int64
+	balance   float64
+}
+
+// phoneMatch picks which of a customer's open invoices a payment of amount
+// settles: the only one owing exactly that, or else their only open invoice
+// when the amount fits in it. It returns 0 when neither holds.
+func phoneMatch(open []openBalance, amount float64) int64 {
+	var exact []int64
+	for _, b := range open {
+		if math.Abs(b.balance-amount) < 0.005 {
+			exact = append(exact, b.invoiceID)
+		}
+	}
+	switch {
+	case len(exact) == 1:
+		return exact[0]
+	case len(open) == 1 && amount <= open[0].balance+0.005:
+		return open[0].invoiceID
+	}
+	return 0
+}
+
+// settleWithMpesa records transaction txID as a payment against the invoice
+// and marks it matched. It refuses with an httpError when the invoice is
+// missing, is a credit note or cannot take the whole amount. The payment is
+// audited as r's, or as a system write when r is nil.
+func (s *Server) settleWithMpesa(ctx context.Context, tx pgx.Tx, r *http.Request, farmID, txID, invoiceID int64, method string, userID int64) (int64, error) {
+	var customerID int64
+	var kind string
+	err := tx.QueryRow(ctx, `SELECT customer_id, kind FROM invoices WHERE id = $1 AND farm_id = $2 FOR UPDATE`, invoiceID, farmID).Scan(&customerID, &kind)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusNotFound, "invoice not found"}
+		}
+		return 0, err
+	}
+	if kind != invoiceKindInvoice {
+		return 0, &httpError{http.StatusConflict, "payments can only be recorded against invoices"}
+	}
+
+	var amount float64
+	var at time.Time
+	var receipt, payer, phone string
+	if err := tx.QueryRow(ctx, `
+		SELECT amount, transaction_time, receipt_number, payer_name, phone
+		FROM mpesa_transactions
+		WHERE id = $1
+	`, txID).Scan(&amount, &at, &receipt, &payer, &phone); err != nil {
+		return 0, err
+	}
+	var balance float64
+	if err := tx.QueryRow(ctx, `SELECT balance FROM invoice_balances WHERE invoice_id = $1`, invoiceID).Scan(&balance); err != nil {
+		return 0, err
+	}
+	if amount > balance+0.005 {
+		return 0, &httpError{http.StatusConflict, "payment of " + formatKES(amount) + " exceeds the outstanding balance of " + formatKES(roundCents(balance))}
+	}
+
+	notes := "M-Pesa from " + strings.TrimSpace(payer+" "+phone)
+	var paymentID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO payments(farm_id, customer_id, invoice_id, payment_date, amount, method, reference, notes)
+		VALUES ($1, $2, $3, $4, $5, 'mpesa', $6, $7)
+		RETURNING id
+	`, farmID, customerID, invoiceID, at, amount, receipt, notes).Scan(&paymentID)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.recordAudit(ctx, tx, r, auditCreate, "payments", paymentID, nil); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE mpesa_transactions
+		SET status = 'matched', match_method = $1, invoice_id = $2, payment_id = $3, note = '',
+		    resolved_by = NULLIF($4, 0), resolved_at = NOW()
+		WHERE id = $5
+	`, method, invoiceID, paymentID, userID, txID)
+	return paymentID, err
+}
+
+// handleMpesaSTKPush prompts the customer's phone to pay an invoice. The
+// amount defaults to the outstanding balance in whole shillings.
+func (s *Server) handleMpesaSTKPush(w http.ResponseWriter, r *http.Request) {
+	if s.mpesa == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "M-Pesa is not configured"})
+		return
+	}
+	invoiceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid invoice id"})
+		return
+	}
+	var in struct {
+		Phone  string  `json:"phone"`
+		Amount float64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	account, err := s.mpesaAccount(ctx, farmID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "set up the farm's M-Pesa shortcode first"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load M-Pesa account"})
+		return
+	}
+
+	var invoiceNumber, customerPhone string
+	var balance float64
+	err = s.db.QueryRow(ctx, `
+		SELECT b.invoice_number, b.balance, c.phone
+		FROM invoice_balances b
+		JOIN customers c ON c.id = b.customer_id
+		WHERE b.invoice_id = $1 AND b.farm_id = $2
+	`, invoiceID, farmID).Scan(&invoiceNumber, &balance, &customerPhone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "invoice not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load invoice"})
+		return
+	}
+
+	if strings.TrimSpace(in.Phone) == "" {
+		in.Phone = customerPhone
+	}
+	phone, ok := normalizeKenyaPhone(in.Phone)
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "phone must be a valid Kenya number"})
+		return
+	}
+	if phone == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "phone is required, the customer has none on record"})
+		return
+	}
+	if balance < 1 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "invoice has no outstanding balance"})
+		return
+	}
+	if in.Amount == 0 {
+		in.Amount = math.Floor(balance + 0.005)
+	}
+	if in.Amount < 1 || in.Amount != math.Trunc(in.Amount) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "amount must be whole shillings"})
+		return
+	}
+	if in.Amount > balance+0.005 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "amount exceeds the outstanding balance of " + formatKES(roundCents(balance))})
+		return
+	}
+
+	// Daraja caps the reference at 12 characters and the description at 13.
+	reference := invoiceNumber
+	if len(reference) > 12 {
+		reference = reference[:12]
+	}
+	resp, err := s.mpesa.STKPush(ctx, account, mpesa.STKRequest{
+		Phone:            mpesa.MSISDN(phone),
+		Amount:           int64(in.Amount),
+		AccountReference: reference,
+		Description:      "Farm invoice",
+		CallbackURL:      s.mpesaCallbackURLFor("stk"),
+	})
+	if err != nil {
+		if mpesa.IsRejected(err) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		respondJSON(w, http.StatusBadGateway, map[string]string{"error": "failed to reach M-Pesa"})
+		return
+	}
+
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+	id, err := s.auditedWrite(ctx, r, auditCreate, "mpesa_stk_requests", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO mpesa_stk_requests(farm_id, invoice_id, phone, amount, account_reference, merchant_request_id, checkout_request_id, requested_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
+			RETURNING id
+		`, farmID, invoiceID, phone, in.Amount, reference, resp.MerchantRequestID, resp.CheckoutRequestID, userID).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		log.Printf("mpesa: stk push %s sent but not saved: %v", resp.CheckoutRequestID, err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save M-Pesa request"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"ok":                true,
+		"id":                id,
+		"checkoutRequestId": resp.CheckoutRequestID,
+		"customerMessage":   resp.CustomerMessage,
+	})
+}
+
+// handleMpesaSTKRequest reports how a push is going. A push nobody answered
+// is checked with Daraja after a while so the prompt does not stay pending
+// when its callback was lost.
+func (s *Server) handleMpesaSTKRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var invoiceID, transactionID *int64
+	var checkoutID, phone, status, resultDesc, receipt string
+	var amount float64
+	var createdAt time.Time
+	err = s.db.QueryRow(ctx, `
+		SELECT q.invoice_id, q.transaction_id, q.checkout_request_id, q.phone, q.amount, q.status, q.result_desc, q.created_at,
+		       COALESCE(t.receipt_number, '')
+		FROM mpesa_stk_requests q
+		LEFT JOIN mpesa_transactions t ON t.id = q.transaction_id
+		WHERE q.id = $1 AND q.farm_id = $2
+	`, id, farmID).Scan(&invoiceID, &transactionID, &checkoutID, &phone, &amount, &status, &resultDesc, &createdAt, &receipt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "request not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load request"})
+		return
+	}
+
+	if status == "pending" && s.mpesa != nil && time.Since(createdAt) > time.Minute {
+		if account, err := s.mpesaAccount(ctx, farmID); err == nil {
+			result, err := s.mpesa.STKQuery(ctx, account, checkoutID)
+			// A paid push waits for its callback, which carries the receipt.
+			if err == nil && result.ResultCode != mpesa.ResultSuccess {
+				status, resultDesc = stkFailureStatus(result.ResultCode), result.ResultDesc
+				if _, err := s.db.Exec(ctx, `
+					UPDATE mpesa_stk_requests
+					SET status = $1, result_code = $2, result_desc = $3, updated_at = NOW()
+					WHERE id = $4 AND status = 'pending'
+				`, status, result.ResultCode, resultDesc, id); err != nil {
+					log.Printf("mpesa: stk request %d: %v", id, err)
+				}
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"id":            id,
+		"invoiceId":     invoiceID,
+		"phone":         phone,
+		"amount":        amount,
+		"status":        status,
+		"resultDesc":    resultDesc,
+		"transactionId": transactionID,
+		"receiptNumber": receipt,
+		"createdAt":     s.formatDateLong(createdAt),
+	})
+}
+
+func stkFailureStatus(code int) string {
+	if code == mpesa.ResultCancelled {
+		return "cancelled"
+	}
+	return "failed"
+}
+
+// handleMpesaSTKCallback receives the outcome of a push. Callbacks are not
+// signed, so a reported payment is confirmed with Daraja's query endpoint
+// before any money is credited.
+func (s *Server) handleMpesaSTKCallback(w http.ResponseWriter, r *http.Request) {
+	if !s.mpesaCallbackAuthorized(r) || s.mpesa == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	var cb mpesa.STKCallback
+	if err := json.Unmarshal(raw, &cb); err != nil || cb.Body.StkCallback.CheckoutRequestID == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	result := cb.Body.StkCallback
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var id, farmID, invoiceID int64
+	var amount float64
+	var reference, status string
+	err = s.db.QueryRow(ctx, `
+		SELECT id, farm_id, COALESCE(invoice_id, 0), amount, account_reference, status
+		FROM mpesa_stk_requests
+		WHERE checkout_request_id = $1
+	`, result.CheckoutRequestID).Scan(&id, &farmID, &invoiceID, &amount, &reference, &status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("mpesa: callback for unknown checkout %s", result.CheckoutRequestID)
+			respondDaraja(w, "0", "Accepted")
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load request"})
+		return
+	}
+	if status != "pending" {
+		respondDaraja(w, "0", "Accepted")
+		return
+	}
+
+	account, err := s.mpesaAccount(ctx, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load M-Pesa account"})
+		return
+	}
+	confirmed, err := s.mpesa.STKQuery(ctx, account, result.CheckoutRequestID)
+	if err != nil {
+		log.Printf("mpesa: verify checkout %s: %v", result.CheckoutRequestID, err)
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "could not verify the payment"})
+		return
+	}
+	if confirmed.ResultCode != result.ResultCode {
+		log.Printf("mpesa: checkout %s reported result %d but Daraja has %d", result.CheckoutRequestID, result.ResultCode, confirmed.ResultCode)
+	}
+
+	if confirmed.ResultCode != mpesa.ResultSuccess {
+		if _, err := s.db.Exec(ctx, `
+			UPDATE mpesa_stk_requests
+			SET status = $1, result_code = $2, result_desc = $3, updated_at = NOW()
+			WHERE id = $4 AND status = 'pending'
+		`, stkFailureStatus(confirmed.ResultCode), confirmed.ResultCode, confirmed.ResultDesc, id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save result"})
+			return
+		}
+		respondDaraja(w, "0", "Accepted")
+		return
+	}
+
+	payment, err := cb.Payment()
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	phone, ok := normalizeKenyaPhone(payment.Phone)
+	if !ok {
+		phone = ""
+	}
+	// A payment for a different amount than was asked is kept but left for
+	// the usual matching rather than forced onto the invoice.
+	if math.Abs(payment.Amount-amount) >= 0.005 {
+		invoiceID = 0
+	}
+	txID, txStatus, err := s.recordMpesaReceipt(ctx, mpesaReceipt{
+		farmID:    farmID,
+		source:    "stk",
+		receipt:   payment.ReceiptNumber,
+		at:        payment.PaidAt,
+		amount:    payment.Amount,
+		phone:     phone,
+		billRef:   reference,
+		shortcode: account.ShortCode,
+		raw:       raw,
+	}, invoiceID)
+	if err != nil {
+		log.Printf("mpesa: record receipt %s: %v", payment.ReceiptNumber, err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record payment"})
+		return
+	}
+	if _, err := s.db.Exec(ctx, `
+		UPDATE mpesa_stk_requests
+		SET status = 'paid', result_code = 0, result_desc = $1, transaction_id = $2, updated_at = NOW()
+		WHERE id = $3
+	`, confirmed.ResultDesc, txID, id); err != nil {
+		log.Printf("mpesa: stk request %d paid as transaction %d but not updated: %v", id, txID, err)
+	}
+	if txStatus != mpesaMatched {
+		log.Printf("mpesa: receipt %s queued for reconciliation", payment.ReceiptNumber)
+	}
+	respondDaraja(w, "0", "Accepted")
+}
+
+// handleMpesaC2BValidation lets Daraja know whether to accept a payment to a
+// shortcode. Only shortcodes a farm has set up are accepted.
+func (s *Server) handleMpesaC2BValidation(w http.ResponseWriter, r *http.Request) {
+	if !s.mpesaCallbackAuthorized(r) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	var c mpesa.C2BConfirmation
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&c); err != nil {
+		respondDaraja(w, "C2B00016", "Rejected")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	var farmID int64
+	if err := s.db.QueryRow(ctx, `SELECT farm_id FROM mpesa_accounts WHERE shortcode = $1`, strings.TrimSpace(c.BusinessShortCode)).Scan(&farmID); err != nil {
+		respondDaraja(w, "C2B00016", "Rejected")
+		return
+	}
+	respondDaraja(w, "0", "Accepted")
+}
+
+// handleMpesaC2BConfirmation stores money that reached a farm's shortcode and
+// matches it to an open invoice where it can.
+func (s *Server) handleMpesaC2BConfirmation(w http.ResponseWriter, r *http.Request) {
+	if !s.mpesaCallbackAuthorized(r) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	var c mpesa.C2BConfirmation
+	if err := json.Unmarshal(raw, &c); err != nil || strings.TrimSpace(c.TransID) == "" || c.Amount() <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	shortcode := strings.TrimSpace(c.BusinessShortCode)
+	var farmID int64
+	err = s.db.QueryRow(ctx, `SELECT farm_id FROM mpesa_accounts WHERE shortcode = $1`, shortcode).Scan(&farmID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("mpesa: confirmation %s for unknown shortcode %s", c.TransID, shortcode)
+			respondDaraja(w, "0", "Accepted")
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load M-Pesa account"})
+		return
+	}
+	at, err := mpesa.ParseTime(c.TransTime)
+	if err != nil {
+		at = time.Now()
+	}
+	// Daraja masks the payer's number on some accounts; a masked number
+	// simply does not take part in matching.
+	phone, ok := normalizeKenyaPhone(c.MSISDN)
+	if !ok {
+		phone = ""
+	}
+	_, status, err := s.recordMpesaReceipt(ctx, mpesaReceipt{
+		farmID:    farmID,
+		source:    "c2b",
+		receipt:   strings.ToUpper(strings.TrimSpace(c.TransID)),
+		at:        at,
+		amount:    c.Amount(),
+		phone:     phone,
+		payerName: c.PayerName(),
+		billRef:   strings.TrimSpace(c.BillRefNumber),
+		shortcode: shortcode,
+		raw:       raw,
+	}, 0)
+	if err != nil {
+		log.Printf("mpesa: record receipt %s: %v", c.TransID, err)
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record payment"})
+		return
+	}
+	if status == mpesaUnmatched {
+		log.Printf("mpesa: receipt %s queued for reconciliation", c.TransID)
+	}
+	respondDaraja(w, "0", "Accepted")
+}
+
+// handleMpesaTransactions lists received M-Pesa payments. status=unmatched is
+// the reconciliation queue.
+func (s *Server) handleMpesaTransactions(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	if status != "" && status != mpesaMatched && status != mpesaUnmatched && status != mpesaIgnored {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be matched, unmatched or ignored"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	search := parseSearch(r)
+	offset := (page - 1) * pageSize
+
+	const filter = `
+		t.farm_id = $1 AND ($2 = '' OR t.status = $2)
+		AND ($3 = '' OR t.receipt_number ILIKE '%' || $3 || '%' OR t.phone ILIKE '%' || $3 || '%'
+			OR t.payer_name ILIKE '%' || $3 || '%' OR t.bill_ref ILIKE '%' || $3 || '%')
+	`
+	var total int64
+	var unmatched float64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM mpesa_transactions t WHERE `+filter, farmID, status, search).Scan(&total)
+	_ = s.db.QueryRow(ctx, `SELECT COALESCE(SUM(amount), 0) FROM mpesa_transactions WHERE farm_id = $1 AND status = 'unmatched'`, farmID).Scan(&unmatched)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT t.id, t.source, t.receipt_number, t.transaction_time, t.amount, t.phone, t.payer_name, t.bill_ref,
+		       t.status, t.match_method, t.invoice_id, COALESCE(i.invoice_number, ''), t.payment_id, t.note
+		FROM mpesa_transactions t
+		LEFT JOIN invoices i ON i.id = t.invoice_id
+		WHERE `+filter+`
+		ORDER BY t.transaction_time DESC, t.id DESC
+		LIMIT $4 OFFSET $5
+	`, farmID, status, search, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load M-Pesa payments"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var invoiceID, paymentID *int64
+		var at time.Time
+		var amount float64
+		var source, receipt, phone, payer, billRef, txStatus, method, invoiceNumber, note string
+		if err := rows.Scan(&id, &source, &receipt, &at, &amount, &phone, &payer, &billRef, &txStatus, &method, &invoiceID, &invoiceNumber, &paymentID, &note); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse M-Pesa payments"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":            id,
+			"source":        source,
+			"receiptNumber": receipt,
+			"date":          s.formatDateLong(at),
+			"dateRaw":       s.formatISODate(at),
+			"amount":        amount,
+			"phone":         phone,
+			"payerName":     payer,
+			"billRef":       billRef,
+			"status":        txStatus,
+			"matchMethod":   method,
+			"invoiceId":     invoiceID,
+			"invoiceNumber": invoiceNumber,
+			"paymentId":     paymentID,
+			"note":          note,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":           out,
+		"total":           total,
+		"unmatchedAmount": unmatched,
+		"page":            page,
+		"pageSize":        pageSize,
+	})
+}
+
+// handleMatchMpesaTransaction settles an invoice with a queued payment by hand.
+func (s *Server) handleMatchMpesaTransaction(w http.ResponseWriter, r *http.Request) {
+	txID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid transaction id"})
+		return
+	}
+	var in struct {
+		InvoiceID int64 `json:"invoiceId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	if in.InvoiceID <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invoiceId is required"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "mpesa_transactions", txID, func(tx pgx.Tx) (int64, error) {
+		var status string
+		err := tx.QueryRow(ctx, `SELECT status FROM mpesa_transactions WHERE id = $1 AND farm_id = $2`, txID, farmID).Scan(&status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if status == mpesaMatched {
+			return 0, &httpError{http.StatusConflict, "payment is already matched to an invoice"}
+		}
+		if _, err := s.settleWithMpesa(ctx, tx, r, farmID, txID, in.InvoiceID, mpesaMatchManual, userID); err != nil {
+			return 0, err
+		}
+		return txID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to match payment")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "transaction not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleIgnoreMpesaTransaction takes a payment that belongs to no invoice,
+// such as a refund or a personal transfer, out of the queue.
+func (s *Server) handleIgnoreMpesaTransaction(w http.ResponseWriter, r *http.Request) {
+	txID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid transaction id"})
+		return
+	}
+	var in struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Note = strings.TrimSpace(in.Note)
+	if in.Note == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "note is required"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "mpesa_transactions", txID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE mpesa_transactions
+			SET status = 'ignored', note = $1, resolved_by = NULLIF($2, 0), resolved_at = NOW()
+			WHERE id = $3 AND farm_id = $4 AND status = 'unmatched'
+		`, in.Note, userID, txID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return txID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update payment"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "unmatched transaction not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleMpesaAccount(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var shortcode, accountType string
+	var registeredAt *time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT shortcode, account_type, urls_registered_at
+		FROM mpesa_accounts
+		WHERE farm_id = $1
+	`, farmID).Scan(&shortcode, &accountType, &registeredAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusOK, map[string]any{"enabled": s.mpesa != nil, "account": nil})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load M-Pesa account"})
+		return
+	}
+	registered := ""
+	if registeredAt != nil {
+		registered = s.formatDateLong(*registeredAt)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"enabled": s.mpesa != nil,
+		"account": map[string]any{
+			"shortcode":        shortcode,
+			"accountType":      accountType,
+			"urlsRegisteredAt": registered,
+		},
+	})
+}
+
+// handleUpdateMpesaAccount saves the farm's shortcode and registers the C2B
+// URLs for it with Daraja. The passkey may be left out to keep the saved one.
+func (s *Server) handleUpdateMpesaAccount(w http.ResponseWriter, r *http.Request) {
+	if s.mpesa == nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "M-Pesa is not configured"})
+		return
+	}
+	var in struct {
+		Shortcode   string `json:"shortcode"`
+		AccountType string `json:"accountType"`
+		Passkey     string `json:"passkey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Shortcode = strings.TrimSpace(in.Shortcode)
+	in.AccountType = strings.ToLower(strings.TrimSpace(in.AccountType))
+	in.Passkey = strings.TrimSpace(in.Passkey)
+	if in.AccountType == "" {
+		in.AccountType = mpesa.AccountPaybill
+	}
+	if in.Shortcode == "" || strings.Trim(in.Shortcode, "0123456789") != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "shortcode must be digits"})
+		return
+	}
+	if in.AccountType != mpesa.AccountPaybill && in.AccountType != mpesa.AccountTill {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "accountType must be paybill or till"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var accountID int64
+	err := s.db.QueryRow(ctx, `SELECT id FROM mpesa_accounts WHERE farm_id = $1`, farmID).Scan(&accountID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load M-Pesa account"})
+		return
+	}
+	if accountID == 0 && in.Passkey == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "passkey is required"})
+		return
+	}
+	action := auditUpdate
+	if accountID == 0 {
+		action = auditCreate
+	}
+	// Moving to another shortcode needs its URLs registered again.
+	_, err = s.auditedWrite(ctx, r, action, "mpesa_accounts", accountID, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO mpesa_accounts(farm_id, shortcode, account_type, passkey)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (farm_id) DO UPDATE SET
+				shortcode = EXCLUDED.shortcode,
+				account_type = EXCLUDED.account_type,
+				passkey = COALESCE(NULLIF(EXCLUDED.passkey, ''), mpesa_accounts.passkey),
+				urls_registered_at = CASE WHEN mpesa_accounts.shortcode = EXCLUDED.shortcode THEN mpesa_accounts.urls_registered_at END,
+				updated_at = NOW()
+			RETURNING id
+		`, farmID, in.Shortcode, in.AccountType, in.Passkey).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "mpesa_accounts_shortcode_key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "shortcode is already used by another farm"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save M-Pesa account"})
+		return
+	}
+
+	account, err := s.mpesaAccount(ctx, farmID)
+	if err == nil {
+		err = s.mpesa.RegisterURLs(ctx, account, s.mpesaCallbackURLFor("c2b/confirmation"), s.mpesaCallbackURLFor("c2b/validation"))
+	}
+	if err != nil {
+		message := "failed to reach M-Pesa"
+		if mpesa.IsRejected(err) {
+			message = err.Error()
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"ok": true, "registered": false, "error": message})
+		return
+	}
+	if _, err := s.db.Exec(ctx, `UPDATE mpesa_accounts SET urls_registered_at = NOW() WHERE farm_id = $1`, farmID); err != nil {
+		log.Printf("mpesa: farm %d urls registered but not saved: %v", farmID, err)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "registered": true})
+}
@@ -0,0 +1,55 @@
+package api
+
+import "testing"
+
+func TestMpesaReferenceKey(t *testing.T) {
+	tests := []struct {
+		ref, want string
+	}{
+		{"FP-00012", "FP00012"},
+		{"fp 00012", "FP00012"},
+		{" fp/00012. ", "FP00012"},
+		{"INV_2024-7", "INV20247"},
+		{"", ""},
+		{" - ", ""},
+	}
+	for _, tt := range tests {
+		if got := mpesaReferenceKey(tt.ref); got != tt.want {
+			t.Errorf("mpesaReferenceKey(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestMpesaPhoneKey(t *testing.T) {
+	// Daraja reports payers as 2547XXXXXXXX while customers are stored as
+	// +2547XXXXXXXX; both must fold to the same key.
+	for _, raw := range []string{"254712345678", "+254712345678", "0712345678", "0712 345 678"} {
+		got, ok := normalizeKenyaPhone(raw)
+		if !ok || got != "+254712345678" {
+			t.Errorf("normalizeKenyaPhone(%q) = %q, %v", raw, got, ok)
+		}
+	}
+}
+
+func TestPhoneMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		open   []openBalance
+		amount float64
+		want   int64
+	}{
+		{"no open invoices", nil, 500, 0},
+		{"single exact", []openBalance{{1, 500}}, 500, 1},
+		{"single with room", []openBalance{{1, 800}}, 500, 1},
+		{"single too small", []openBalance{{1, 300}}, 500, 0},
+		{"rounding tolerance", []openBalance{{1, 499.999}}, 500, 1},
+		{"one exact among several", []openBalance{{1, 800}, {2, 500}, {3, 200}}, 500, 2},
+		{"two exact", []openBalance{{1, 500}, {2, 500}}, 500, 0},
+		{"several without exact", []openBalance{{1, 800}, {2, 900}}, 500, 0},
+	}
+	for _, tt := range tests {
+		if got := phoneMatch(tt.open, tt.amount); got != tt.want {
+			t.Errorf("%s: phoneMatch = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
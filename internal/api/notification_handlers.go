@@ -0,0 +1,439 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// farmNotice is an alert for everyone on a farm. kind picks which of each
+// user's channel preferences apply.
+type farmNotice struct {
+	ruleID int64
+	kind   string
+	// permission, when set, limits the notice to members whose role holds it.
+	permission string
+	title      string
+	body       string
+	entity     string
+	entityID   int64
+	// data fills the kind's text message template, if it has one.
+	data map[string]string
+}
+
+type noticeRecipient struct {
+	userID         int64
+	email          string
+	phone          string
+	inApp          bool
+	byMail         bool
+	bySMS          bool
+	notificationID int64
+}
+
+// noticeMember is an active farm member a notice may go to. permitted tells
+// whether their role holds the notice's permission.
+type noticeMember struct {
+	noticeRecipient
+	permitted bool
+}
+
+// notifyFarm stores n in tx for every active member of the farm allowed to
+// see it and returns them, for deliverNotice to send on the channels each of
+// them chose once tx is committed.
+func notifyFarm(ctx context.Context, tx pgx.Tx, farmID int64, n farmNotice) ([]noticeRecipient, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT u.id, u.email, u.phone,
+			COALESCE(p.in_app, true), COALESCE(p.email, false), COALESCE(p.sms, false),
+			EXISTS (
+				SELECT 1
+				FROM role_permissions rp
+				JOIN permissions k ON k.id = rp.permission_id
+				WHERE rp.role_id = m.role_id AND k.key = $3
+			)
+		FROM farm_members m
+		JOIN users u ON u.id = m.user_id AND u.status = 'active'
+		LEFT JOIN notification_preferences p ON p.farm_id = m.farm_id AND p.user_id = u.id AND p.kind = $2
+		WHERE m.farm_id = $1 AND m.status = 'active'
+	`, farmID, n.kind, n.permission)
+	if err != nil {
+		return nil, err
+	}
+	var members []noticeMember
+	for rows.Next() {
+		var m noticeMember
+		if err := rows.Scan(&m.userID, &m.email, &m.phone, &m.inApp, &m.byMail, &m.bySMS, &m.permitted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	recipients := noticeRecipients(n, members)
+
+	var ruleID, entityID *int64
+	if n.ruleID > 0 {
+		ruleID = &n.ruleID
+	}
+	if n.entityID > 0 {
+		entityID = &n.entityID
+	}
+	for i, rc := range recipients {
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO notifications(farm_id, user_id, rule_id, kind, title, body, entity, entity_id, in_app)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id
+		`, farmID, rc.userID, ruleID, n.kind, n.title, n.body, n.entity, entityID, rc.inApp).Scan(&recipients[i].notificationID); err != nil {
+			return nil, err
+		}
+	}
+	return recipients, nil
+}
+
+// noticeRecipients keeps the members n goes to: those whose role holds
+// n.permission, when it is set, and who get it on at least one channel.
+func noticeRecipients(n farmNotice, members []noticeMember) []noticeRecipient {
+	var out []noticeRecipient
+	for _, m := range members {
+		if n.permission != "" && !m.permitted {
+			continue
+		}
+		if m.inApp || m.byMail || m.bySMS {
+			out = append(out, m.noticeRecipient)
+		}
+	}
+	return out
+}
+
+// deliverNotice sends n by email and text message to the recipients who
+// chose those channels. Failures are recorded against each notification
+// rather than returned.
+func (s *Server) deliverNotice(ctx context.Context, farmID int64, n farmNotice, recipients []noticeRecipient) {
+	for _, rc := range recipients {
+		if rc.byMail {
+			status, reason := "sent", ""
+			switch {
+			case s.mailer == nil:
+				status, reason = "skipped", "email is not configured on this server"
+			default:
+				data := notificationData{
+					Title:  n.title,
+					Intro:  n.body,
+					Footer: "You get this email because of your notification settings in FarmPro.",
+				}
+				if err := s.mailer.sendTemplate(rc.email, "FarmPro: "+n.title, "notification", data); err != nil {
+					status, reason = "failed", err.Error()
+				}
+			}
+			s.recordDelivery(ctx, rc.notificationID, "email", rc.email, status, reason)
+		}
+		if rc.bySMS {
+			data := map[string]string{"title": n.title, "body": n.body}
+			for k, v := range n.data {
+				data[k] = v
+			}
+			status, reason := s.sendSMS(ctx, smsRequest{
+				farmID:         farmID,
+				userID:         rc.userID,
+				notificationID: rc.notificationID,
+				phone:          rc.phone,
+				template:       n.kind,
+				data:           data,
+			})
+			if status == "opted_out" {
+				status = "skipped"
+			}
+			s.recordDelivery(ctx, rc.notificationID, "sms", rc.phone, status, reason)
+		}
+	}
+}
+
+func (s *Server) recordDelivery(ctx context.Context, notificationID int64, channel, destination, status, reason string) {
+	_, _ = s.db.Exec(ctx, `
+		INSERT INTO notification_deliveries(notification_id, channel, destination, status, error)
+		VALUES ($1, $2, $3, $4, $5)
+	`, notificationID, channel, destination, status, reason)
+}
+
+func (s *Server) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	var total, unread int64
+	_ = s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE NOT $3 OR read_at IS NULL), COUNT(*) FILTER (WHERE read_at IS NULL)
+		FROM notifications
+		WHERE farm_id = $1 AND user_id = $2 AND in_app
+	`, farmID, userID, unreadOnly).Scan(&total, &unread)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, kind, title, body, entity, COALESCE(entity_id, 0), read_at, created_at
+		FROM notifications
+		WHERE farm_id = $1 AND user_id = $2 AND in_app AND (NOT $3 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4 OFFSET $5
+	`, farmID, userID, unreadOnly, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load notifications"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, entityID int64
+		var kind, title, body, entity string
+		var readAt *time.Time
+		var createdAt time.Time
+		if err := rows.Scan(&id, &kind, &title, &body, &entity, &entityID, &readAt, &createdAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse notifications"})
+			return
+		}
+		item := map[string]any{
+			"id":        id,
+			"kind":      kind,
+			"title":     title,
+			"body":      body,
+			"entity":    entity,
+			"entityId":  entityID,
+			"read":      readAt != nil,
+			"readAt":    nil,
+			"createdAt": createdAt.Format("2006-01-02T15:04:05"),
+		}
+		if readAt != nil {
+			item["readAt"] = readAt.Format("2006-01-02T15:04:05")
+		}
+		out = append(out, item)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"unread":   unread,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (s *Server) handleUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var unread int64
+	if err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM notifications
+		WHERE farm_id = $1 AND user_id = $2 AND in_app AND read_at IS NULL
+	`, farmID, userID).Scan(&unread); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to count notifications"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"unread": unread})
+}
+
+func (s *Server) handleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	notificationID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid notification id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	id, err := s.auditedWrite(ctx, r, auditUpdate, "notifications", notificationID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+			WHERE id = $1 AND farm_id = $2 AND user_id = $3 AND in_app
+		`, notificationID, farmID, userID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return notificationID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notification"})
+		return
+	}
+	if id == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "notification not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM notifications
+		WHERE farm_id = $1 AND user_id = $2 AND in_app AND read_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, farmID, userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+		return
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+		return
+	}
+	for _, id := range ids {
+		before, err := auditSnapshot(ctx, tx, "notifications", id)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+			return
+		}
+		if _, err := tx.Exec(ctx, `UPDATE notifications SET read_at = NOW() WHERE id = $1`, id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+			return
+		}
+		if err := s.recordAudit(ctx, tx, r, auditUpdate, "notifications", id, before); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update notifications"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "updated": len(ids)})
+}
+
+func (s *Server) handleNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	type channels struct{ inApp, email, sms bool }
+	saved := map[string]channels{}
+	rows, err := s.db.Query(ctx, `
+		SELECT kind, in_app, email, sms FROM notification_preferences
+		WHERE farm_id = $1 AND user_id = $2
+	`, farmID, userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load notification preferences"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var c channels
+		if err := rows.Scan(&kind, &c.inApp, &c.email, &c.sms); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse notification preferences"})
+			return
+		}
+		saved[kind] = c
+	}
+
+	out := make([]map[string]any, 0, len(alertConditions))
+	for _, cond := range alertConditions {
+		c, ok := saved[cond.name]
+		if !ok {
+			c = channels{inApp: true}
+		}
+		out = append(out, map[string]any{
+			"kind":  cond.name,
+			"label": cond.label,
+			"inApp": c.inApp,
+			"email": c.email,
+			"sms":   c.sms,
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+func (s *Server) handleUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Items []struct {
+			Kind  string `json:"kind"`
+			InApp bool   `json:"inApp"`
+			Email bool   `json:"email"`
+			SMS   bool   `json:"sms"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	for _, item := range in.Items {
+		if _, ok := alertConditionLabel(item.Kind); !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown notification kind " + item.Kind})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save notification preferences"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	for _, item := range in.Items {
+		var before []byte
+		action := auditCreate
+		var id int64
+		err := tx.QueryRow(ctx, `
+			SELECT id FROM notification_preferences WHERE farm_id = $1 AND user_id = $2 AND kind = $3
+		`, farmID, userID, item.Kind).Scan(&id)
+		if err == nil {
+			action = auditUpdate
+			before, err = auditSnapshot(ctx, tx, "notification_preferences", id)
+		}
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save notification preferences"})
+			return
+		}
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO notification_preferences(farm_id, user_id, kind, in_app, email, sms)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (farm_id, user_id, kind) DO UPDATE
+			SET in_app = EXCLUDED.in_app, email = EXCLUDED.email, sms = EXCLUDED.sms
+			RETURNING id
+		`, farmID, userID, item.Kind, item.InApp, item.Email, item.SMS).Scan(&id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save notification preferences"})
+			return
+		}
+		if err := s.recordAudit(ctx, tx, r, action, "notification_preferences", id, before); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save notification preferences"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save notification preferences"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,422 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultPedigreeGenerations = 3
+	maxPedigreeGenerations     = 6
+	// Coefficients look further back than a pedigree is usually drawn.
+	inbreedingDepth = 8
+	// Matings as close as first cousins (1/16) or closer are flagged.
+	inbreedingWarnLevel = 0.0625
+)
+
+type pedigreeAnimal struct {
+	id        int64
+	tagID     string
+	typ       string
+	breed     string
+	sex       string
+	sireName  string
+	status    string
+	birthDate *time.Time
+	motherID  *int64
+	fatherID  *int64
+}
+
+// pedigree is a set of animals and their known ancestors, with Wright's
+// coefficients worked out from it by the recursive coancestry method.
+type pedigree struct {
+	animals map[int64]pedigreeAnimal
+	gens    map[int64]int
+	kinship map[[2]int64]float64
+}
+
+func normalizeSex(raw string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return "", true
+	case "f", "female":
+		return "female", true
+	case "m", "male":
+		return "male", true
+	}
+	return "", false
+}
+
+// loadPedigree loads the animals in ids and their ancestors up to depth
+// generations back.
+func (s *Server) loadPedigree(ctx context.Context, farmID int64, ids []int64, depth int) (*pedigree, error) {
+	rows, err := s.db.Query(ctx, `
+		WITH RECURSIVE anc AS (
+			SELECT id, mother_id, father_id, 0 AS depth
+			FROM animals
+			WHERE id = ANY($1) AND farm_id = $2
+			UNION
+			SELECT a.id, a.mother_id, a.father_id, anc.depth + 1
+			FROM animals a
+			JOIN anc ON a.id = anc.mother_id OR a.id = anc.father_id
+			WHERE anc.depth < $3
+		)
+		SELECT id, tag_id, type, breed, sex, sire_name, status, birth_date, mother_id, father_id
+		FROM animals
+		WHERE id IN (SELECT id FROM anc) AND farm_id = $2
+	`, ids, farmID, depth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	p := &pedigree{
+		animals: map[int64]pedigreeAnimal{},
+		gens:    map[int64]int{},
+		kinship: map[[2]int64]float64{},
+	}
+	for rows.Next() {
+		var a pedigreeAnimal
+		if err := rows.Scan(&a.id, &a.tagID, &a.typ, &a.breed, &a.sex, &a.sireName, &a.status, &a.birthDate, &a.motherID, &a.fatherID); err != nil {
+			return nil, err
+		}
+		p.animals[a.id] = a
+	}
+	return p, rows.Err()
+}
+
+// parents returns the animal's dam and sire as far as the pedigree knows
+// them; 0 stands for unknown.
+func (p *pedigree) parents(id int64) (int64, int64) {
+	a, ok := p.animals[id]
+	if !ok {
+		return 0, 0
+	}
+	var dam, sire int64
+	if a.motherID != nil {
+		if _, ok := p.animals[*a.motherID]; ok {
+			dam = *a.motherID
+		}
+	}
+	if a.fatherID != nil {
+		if _, ok := p.animals[*a.fatherID]; ok {
+			sire = *a.fatherID
+		}
+	}
+	return dam, sire
+}
+
+// generation counts known generations behind an animal. An ancestor is
+// always of a lower generation than its descendants.
+func (p *pedigree) generation(id int64) int {
+	if g, ok := p.gens[id]; ok {
+		return g
+	}
+	p.gens[id] = 0
+	g := 0
+	dam, sire := p.parents(id)
+	for _, parent := range []int64{dam, sire} {
+		if parent != 0 {
+			if pg := p.generation(parent) + 1; pg > g {
+				g = pg
+			}
+		}
+	}
+	p.gens[id] = g
+	return g
+}
+
+// coancestry is the probability that a gene drawn from a and one drawn from
+// b are identical by descent. It is also the inbreeding coefficient of their
+// offspring.
+func (p *pedigree) coancestry(a, b int64) float64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a == b {
+		return (1 + p.inbreeding(a)) / 2
+	}
+	key := [2]int64{a, b}
+	if a > b {
+		key = [2]int64{b, a}
+	}
+	if v, ok := p.kinship[key]; ok {
+		return v
+	}
+	// Expand the younger of the two, which cannot be an ancestor of the other.
+	if p.generation(a) < p.generation(b) {
+		a, b = b, a
+	}
+	dam, sire := p.parents(a)
+	v := (p.coancestry(dam, b) + p.coancestry(sire, b)) / 2
+	p.kinship[key] = v
+	return v
+}
+
+func (p *pedigree) inbreeding(id int64) float64 {
+	dam, sire := p.parents(id)
+	return p.coancestry(dam, sire)
+}
+
+func relationshipLabel(coefficient float64) string {
+	switch {
+	case coefficient >= 0.25:
+		return "parent and offspring or full siblings"
+	case coefficient >= 0.125:
+		return "half siblings, or grandparent and grandchild"
+	case coefficient >= inbreedingWarnLevel:
+		return "first cousins"
+	}
+	return "distant relatives"
+}
+
+// matingInbreeding is the inbreeding coefficient offspring of dam and sire
+// would have.
+func (s *Server) matingInbreeding(ctx context.Context, farmID, damID, sireID int64) (float64, error) {
+	p, err := s.loadPedigree(ctx, farmID, []int64{damID, sireID}, inbreedingDepth)
+	if err != nil {
+		return 0, err
+	}
+	return p.coancestry(damID, sireID), nil
+}
+
+// isDescendant reports whether candidate is id itself or descends from it,
+// which would make it an impossible parent for id.
+func isDescendant(ctx context.Context, tx pgx.Tx, farmID, id, candidate int64) (bool, error) {
+	var found bool
+	err := tx.QueryRow(ctx, `
+		WITH RECURSIVE d AS (
+			SELECT id FROM animals WHERE id = $1 AND farm_id = $2
+			UNION
+			SELECT a.id FROM animals a JOIN d ON a.mother_id = d.id OR a.father_id = d.id
+		)
+		SELECT EXISTS (SELECT 1 FROM d WHERE id = $3)
+	`, id, farmID, candidate).Scan(&found)
+	return found, err
+}
+
+func (s *Server) pedigreeNode(p *pedigree, id int64, generations int) map[string]any {
+	a := p.animals[id]
+	birthDate := ""
+	if a.birthDate != nil {
+		birthDate = s.formatISODate(*a.birthDate)
+	}
+	node := map[string]any{
+		"tagId":      a.tagID,
+		"type":       a.typ,
+		"breed":      a.breed,
+		"sex":        a.sex,
+		"status":     a.status,
+		"birthDate":  birthDate,
+		"inbreeding": roundInbreeding(p.inbreeding(id)),
+	}
+	if generations <= 0 {
+		return node
+	}
+	dam, sire := p.parents(id)
+	node["dam"] = nil
+	node["sire"] = nil
+	if dam != 0 {
+		node["dam"] = s.pedigreeNode(p, dam, generations-1)
+	}
+	if sire != 0 {
+		node["sire"] = s.pedigreeNode(p, sire, generations-1)
+	} else if a.sireName != "" {
+		node["sire"] = map[string]any{"name": a.sireName, "external": true}
+	}
+	return node
+}
+
+func roundInbreeding(v float64) float64 {
+	return float64(int64(v*10000+0.5)) / 10000
+}
+
+func parseGenerations(r *http.Request) (int, bool) {
+	raw := strings.TrimSpace(r.URL.Query().Get("generations"))
+	if raw == "" {
+		return defaultPedigreeGenerations, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxPedigreeGenerations {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Server) handleAnimalPedigree(w http.ResponseWriter, r *http.Request) {
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+	generations, ok := parseGenerations(r)
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "generations must be between 1 and " + strconv.Itoa(maxPedigreeGenerations)})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var animalID int64
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, tagID, farmID).Scan(&animalID); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
+		return
+	}
+	depth := generations
+	if depth < inbreedingDepth {
+		depth = inbreedingDepth
+	}
+	p, err := s.loadPedigree(ctx, farmID, []int64{animalID}, depth)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load pedigree"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"animal":                s.pedigreeNode(p, animalID, generations),
+		"generations":           generations,
+		"knownAncestors":        len(p.animals) - 1,
+		"inbreedingCoefficient": roundInbreeding(p.inbreeding(animalID)),
+	})
+}
+
+func (s *Server) handleAnimalDescendants(w http.ResponseWriter, r *http.Request) {
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+	generations, ok := parseGenerations(r)
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "generations must be between 1 and " + strconv.Itoa(maxPedigreeGenerations)})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var animalID int64
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, tagID, farmID).Scan(&animalID); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
+		return
+	}
+
+	rows, err := s.db.Query(ctx, `
+		WITH RECURSIVE d AS (
+			SELECT id, 0 AS generation FROM animals WHERE id = $1
+			UNION
+			SELECT a.id, d.generation + 1
+			FROM animals a
+			JOIN d ON a.mother_id = d.id OR a.father_id = d.id
+			WHERE d.generation < $2
+		)
+		SELECT a.tag_id, a.type, a.breed, a.sex, a.status, a.birth_date, MIN(d.generation),
+		       COALESCE(m.tag_id, ''), COALESCE(f.tag_id, ''), a.sire_name
+		FROM d
+		JOIN animals a ON a.id = d.id
+		LEFT JOIN animals m ON m.id = a.mother_id
+		LEFT JOIN animals f ON f.id = a.father_id
+		WHERE d.generation > 0 AND a.farm_id = $3
+		GROUP BY a.id, m.tag_id, f.tag_id
+		ORDER BY MIN(d.generation), a.birth_date NULLS LAST, a.tag_id
+	`, animalID, generations, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load descendants"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	byGeneration := map[string]int{}
+	for rows.Next() {
+		var tag, typ, breed, sex, status, damTag, sireTag, sireName string
+		var birthDate *time.Time
+		var generation int
+		if err := rows.Scan(&tag, &typ, &breed, &sex, &status, &birthDate, &generation, &damTag, &sireTag, &sireName); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse descendants"})
+			return
+		}
+		birth := ""
+		if birthDate != nil {
+			birth = s.formatISODate(*birthDate)
+		}
+		if sireTag == "" {
+			sireTag = sireName
+		}
+		byGeneration[strconv.Itoa(generation)]++
+		out = append(out, map[string]any{
+			"tagId":      tag,
+			"type":       typ,
+			"breed":      breed,
+			"sex":        sex,
+			"status":     status,
+			"birthDate":  birth,
+			"generation": generation,
+			"dam":        damTag,
+			"sire":       sireTag,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"tagId":        tagID,
+		"generations":  generations,
+		"items":        out,
+		"total":        len(out),
+		"byGeneration": byGeneration,
+	})
+}
+
+// resolveParent looks up a dam or sire by tag for animalID, which is 0 for
+// an animal not yet saved. A blank tag clears the link.
+func resolveParent(ctx context.Context, tx pgx.Tx, farmID, animalID int64, raw, wantSex, field string) (*int64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	tag, ok := normalizeAnimalTag(raw)
+	if !ok {
+		return nil, &httpError{http.StatusBadRequest, field + " must be 2-24 chars (A-Z, 0-9, hyphen)"}
+	}
+	var id int64
+	var sex string
+	err := tx.QueryRow(ctx, `SELECT id, sex FROM animals WHERE tag_id = $1 AND farm_id = $2`, tag, farmID).Scan(&id, &sex)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &httpError{http.StatusBadRequest, field + " " + tag + " not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sex != "" && sex != wantSex {
+		return nil, &httpError{http.StatusBadRequest, tag + " is recorded as " + sex}
+	}
+	if animalID != 0 {
+		descendant, err := isDescendant(ctx, tx, farmID, animalID, id)
+		if err != nil {
+			return nil, err
+		}
+		if descendant {
+			return nil, &httpError{http.StatusBadRequest, tag + " cannot be a parent of itself or its own descendant"}
+		}
+	}
+	return &id, nil
+}
+
+func resolveParents(ctx context.Context, tx pgx.Tx, farmID, animalID int64, damTag, sireTag string) (*int64, *int64, error) {
+	motherID, err := resolveParent(ctx, tx, farmID, animalID, damTag, "female", "motherTagId")
+	if err != nil {
+		return nil, nil, err
+	}
+	fatherID, err := resolveParent(ctx, tx, farmID, animalID, sireTag, "male", "fatherTagId")
+	if err != nil {
+		return nil, nil, err
+	}
+	if motherID != nil && fatherID != nil && *motherID == *fatherID {
+		return nil, nil, &httpError{http.StatusBadRequest, "motherTagId and fatherTagId must be different"}
+	}
+	return motherID, fatherID, nil
+}
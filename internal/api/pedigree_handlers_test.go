@@ -0,0 +1,124 @@
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+// testPedigree builds a pedigree from id: {dam, sire} pairs, 0 meaning an
+// unknown parent.
+func testPedigree(parents map[int64][2]int64) *pedigree {
+	p := &pedigree{
+		animals: map[int64]pedigreeAnimal{},
+		gens:    map[int64]int{},
+		kinship: map[[2]int64]float64{},
+	}
+	for id, dp := range parents {
+		a := pedigreeAnimal{id: id}
+		if dp[0] != 0 {
+			dam := dp[0]
+			a.motherID = &dam
+		}
+		if dp[1] != 0 {
+			sire := dp[1]
+			a.fatherID = &sire
+		}
+		p.animals[id] = a
+	}
+	return p
+}
+
+func TestCoancestry(t *testing.T) {
+	// 1 and 2 are unrelated founders with full siblings 3 and 4. 5 is a half
+	// sibling of 3 through 1. 7 and 9 are first cousins through 3 and 4.
+	// 11 is the offspring of a full-sibling mating and 12 of a backcross.
+	// 13 names a dam that is not on the farm.
+	p := testPedigree(map[int64][2]int64{
+		1:  {},
+		2:  {},
+		3:  {1, 2},
+		4:  {1, 2},
+		5:  {1, 6},
+		6:  {},
+		7:  {3, 8},
+		8:  {},
+		9:  {10, 4},
+		10: {},
+		11: {3, 4},
+		12: {3, 1},
+		13: {99, 2},
+	})
+	tests := []struct {
+		name string
+		a, b int64
+		want float64
+	}{
+		{"unrelated founders", 1, 2, 0},
+		{"founder with itself", 1, 1, 0.5},
+		{"parent and offspring", 1, 3, 0.25},
+		{"order does not matter", 3, 1, 0.25},
+		{"full siblings", 3, 4, 0.25},
+		{"half siblings", 3, 5, 0.125},
+		{"grandparent and grandchild", 1, 7, 0.125},
+		{"first cousins", 7, 9, 0.0625},
+		{"inbred animal with itself", 11, 11, 0.625},
+		{"unknown animal", 0, 3, 0},
+		{"missing ancestor counts as unknown", 13, 8, 0},
+		{"shared known parent only", 13, 3, 0.125},
+	}
+	for _, tt := range tests {
+		if got := p.coancestry(tt.a, tt.b); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: coancestry(%d, %d) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestInbreeding(t *testing.T) {
+	p := testPedigree(map[int64][2]int64{
+		1:  {},
+		2:  {},
+		3:  {1, 2},
+		4:  {1, 2},
+		11: {3, 4},
+		12: {3, 1},
+		14: {11, 3},
+		15: {},
+	})
+	tests := []struct {
+		id   int64
+		want float64
+	}{
+		{1, 0},
+		{3, 0},
+		{11, 0.25},
+		{12, 0.25},
+		// 14's sire 3 is both a parent of its dam 11 and a full sibling
+		// of her other parent.
+		{14, 0.375},
+		{15, 0},
+		{99, 0},
+	}
+	for _, tt := range tests {
+		if got := p.inbreeding(tt.id); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("inbreeding(%d) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRelationshipLabel(t *testing.T) {
+	tests := []struct {
+		coefficient float64
+		want        string
+	}{
+		{0.25, "parent and offspring or full siblings"},
+		{0.375, "parent and offspring or full siblings"},
+		{0.125, "half siblings, or grandparent and grandchild"},
+		{0.0625, "first cousins"},
+		{0.03, "distant relatives"},
+	}
+	for _, tt := range tests {
+		if got := relationshipLabel(tt.coefficient); got != tt.want {
+			t.Errorf("relationshipLabel(%v) = %q, want %q", tt.coefficient, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type priceEntry struct {
+	product       string
+	species       string
+	unit          string
+	price         float64
+	effectiveFrom time.Time
+}
+
+// priceList holds a farm's prices ordered newest first within each product.
+type priceList []priceEntry
+
+func (s *Server) loadPriceList(ctx context.Context, farmID int64) (priceList, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT product, species, unit, price, effective_from
+		FROM commodity_prices
+		WHERE farm_id = $1
+		ORDER BY product, effective_from DESC
+	`, farmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out priceList
+	for rows.Next() {
+		var p priceEntry
+		if err := rows.Scan(&p.product, &p.species, &p.unit, &p.price, &p.effectiveFrom); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// rate returns the price in effect on the given day. A species-specific price
+// wins over the product's general price.
+func (l priceList) rate(product, species, unit string, on time.Time) (float64, bool) {
+	var general *priceEntry
+	for i := range l {
+		p := &l[i]
+		if p.product != product || p.unit != unit || p.effectiveFrom.After(on) {
+			continue
+		}
+		if species != "" && p.species == species {
+			return p.price, true
+		}
+		if p.species == "" && general == nil {
+			general = p
+		}
+	}
+	if general != nil {
+		return general.price, true
+	}
+	return 0, false
+}
+
+// seedDefaultPrices gives a new farm the opening price list from
+// commodity_price_defaults, the one existing farms received when the price
+// list was introduced.
+func seedDefaultPrices(ctx context.Context, tx pgx.Tx, farmID int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO commodity_prices(farm_id, product, species, unit, price, effective_from, notes)
+		SELECT $1, product, species, unit, price, DATE '2000-01-01', 'Opening price'
+		FROM commodity_price_defaults
+	`, farmID)
+	return err
+}
+
+func normalizePriceInput(product, species, unit, effectiveFrom string, price float64) (string, string, string, time.Time, string) {
+	product = strings.ToLower(strings.TrimSpace(product))
+	defaultUnit, ok := productionUnits[product]
+	if !ok || product == "weight" {
+		return "", "", "", time.Time{}, "product must be one of milk, eggs, wool, meat"
+	}
+	species = strings.ToLower(strings.TrimSpace(species))
+	unit = strings.TrimSpace(unit)
+	if unit == "" {
+		unit = defaultUnit
+	}
+	if len(species) > 40 || len(unit) > 20 {
+		return "", "", "", time.Time{}, "species or unit is too long"
+	}
+	if price < 0 {
+		return "", "", "", time.Time{}, "price cannot be negative"
+	}
+	if strings.TrimSpace(effectiveFrom) == "" {
+		effectiveFrom = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", effectiveFrom)
+	if err != nil {
+		return "", "", "", time.Time{}, "effectiveFrom must be YYYY-MM-DD"
+	}
+	return product, species, unit, d, ""
+}
+
+func (s *Server) handlePrices(w http.ResponseWriter, r *http.Request) {
+	product := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("product")))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, product, species, unit, price, effective_from, notes,
+			effective_from = MAX(effective_from) FILTER (WHERE effective_from <= CURRENT_DATE)
+				OVER (PARTITION BY product, species, unit)
+		FROM commodity_prices
+		WHERE farm_id = $1 AND ($2 = '' OR product = $2)
+		ORDER BY product, species, unit, effective_from DESC
+	`, farmID, product)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load prices"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var product, species, unit, notes string
+		var price float64
+		var effectiveFrom time.Time
+		var current *bool
+		if err := rows.Scan(&id, &product, &species, &unit, &price, &effectiveFrom, &notes, &current); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse prices"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":               id,
+			"product":          product,
+			"species":          species,
+			"unit":             unit,
+			"price":            formatKES(price),
+			"priceValue":       price,
+			"effectiveFrom":    s.formatDateCompact(effectiveFrom),
+			"effectiveFromRaw": s.formatISODate(effectiveFrom),
+			"current":          current != nil && *current,
+			"notes":            notes,
+		})
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleCreatePrice(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Product       string  `json:"product"`
+		Species       string  `json:"species"`
+		Unit          string  `json:"unit"`
+		Price         float64 `json:"price"`
+		EffectiveFrom string  `json:"effectiveFrom"`
+		Notes         string  `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	product, species, unit, effectiveFrom, msg := normalizePriceInput(in.Product, in.Species, in.Unit, in.EffectiveFrom, in.Price)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "commodity_prices", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO commodity_prices(farm_id, product, species, unit, price, effective_from, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, farmID, product, species, unit, in.Price, effectiveFrom, strings.TrimSpace(in.Notes)).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a price for this product already starts on that date"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create price"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+func (s *Server) handleUpdatePrice(w http.ResponseWriter, r *http.Request) {
+	priceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid price id"})
+		return
+	}
+	var in struct {
+		Product       string  `json:"product"`
+		Species       string  `json:"species"`
+		Unit          string  `json:"unit"`
+		Price         float64 `json:"price"`
+		EffectiveFrom string  `json:"effectiveFrom"`
+		Notes         string  `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	product, species, unit, effectiveFrom, msg := normalizePriceInput(in.Product, in.Species, in.Unit, in.EffectiveFrom, in.Price)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "commodity_prices", priceID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE commodity_prices
+			SET product = $1, species = $2, unit = $3, price = $4, effective_from = $5, notes = $6
+			WHERE id = $7 AND farm_id = $8
+		`, product, species, unit, in.Price, effectiveFrom, strings.TrimSpace(in.Notes), priceID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return priceID, nil
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a price for this product already starts on that date"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update price"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "price not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeletePrice(w http.ResponseWriter, r *http.Request) {
+	priceID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid price id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "commodity_prices", priceID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM commodity_prices WHERE id = $1 AND farm_id = $2`, priceID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return priceID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete price"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "price not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleRevalueProduction recomputes the value of production records from the
+// price list, typically after a price was corrected. Records valued by hand are
+// only touched when includeManual is set.
+func (s *Server) handleRevalueProduction(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		From          string `json:"from"`
+		To            string `json:"to"`
+		Product       string `json:"product"`
+		IncludeManual bool   `json:"includeManual"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	from, err := optionalDate(in.From)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+		return
+	}
+	to, err := optionalDate(in.To)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+	product := strings.ToLower(strings.TrimSpace(in.Product))
+	if _, ok := productionUnits[product]; product != "" && (!ok || product == "weight") {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "product must be one of milk, eggs, wool, meat"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	prices, err := s.loadPriceList(ctx, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load prices"})
+		return
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, kind, species, unit, quantity, value, record_date
+		FROM production_records
+		WHERE farm_id = $1 AND kind <> 'weight' AND NOT withheld
+			AND ($2 = '' OR kind = $2)
+			AND ($3 = true OR value_source = 'price')
+			AND ($4::date IS NULL OR record_date >= $4::date)
+			AND ($5::date IS NULL OR record_date <= $5::date)
+		ORDER BY record_date, id
+		FOR UPDATE
+	`, farmID, product, in.IncludeManual, from, to)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+		return
+	}
+	type revaluation struct {
+		id    int64
+		value float64
+	}
+	var changes []revaluation
+	var before, after float64
+	var unpriced int
+	for rows.Next() {
+		var id int64
+		var kind, species, unit string
+		var quantity, value float64
+		var d time.Time
+		if err := rows.Scan(&id, &kind, &species, &unit, &quantity, &value, &d); err != nil {
+			rows.Close()
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+			return
+		}
+		before += value
+		rate, ok := prices.rate(kind, species, unit, d)
+		if !ok {
+			unpriced++
+			after += value
+			continue
+		}
+		newValue := math.Round(quantity*rate*100) / 100
+		after += newValue
+		if math.Abs(newValue-value) >= 0.005 {
+			changes = append(changes, revaluation{id: id, value: newValue})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+		return
+	}
+
+	for _, c := range changes {
+		snapshot, err := auditSnapshot(ctx, tx, "production_records", c.id)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+			return
+		}
+		if _, err := tx.Exec(ctx, `UPDATE production_records SET value = $1, value_source = 'price' WHERE id = $2`, c.value, c.id); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+			return
+		}
+		if err := s.recordAudit(ctx, tx, r, auditUpdate, "production_records", c.id, snapshot); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revalue production"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"ok":            true,
+		"updated":       len(changes),
+		"unpriced":      unpriced,
+		"previousTotal": before,
+		"newTotal":      after,
+		"difference":    after - before,
+	})
+}
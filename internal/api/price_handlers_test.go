@@ -0,0 +1,107 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testDate(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// testPrices is ordered newest first within each product, as loadPriceList
+// returns it.
+var testPrices = priceList{
+	{product: "milk", species: "goat", unit: "L", price: 90, effectiveFrom: testDate("2026-03-01")},
+	{product: "milk", species: "", unit: "L", price: 65, effectiveFrom: testDate("2026-02-01")},
+	{product: "milk", species: "goat", unit: "L", price: 80, effectiveFrom: testDate("2000-01-01")},
+	{product: "milk", species: "", unit: "L", price: 60, effectiveFrom: testDate("2000-01-01")},
+	{product: "eggs", species: "", unit: "eggs", price: 15, effectiveFrom: testDate("2000-01-01")},
+}
+
+func TestPriceListRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		product string
+		species string
+		unit    string
+		on      string
+		price   float64
+		ok      bool
+	}{
+		{"general price before a change", "milk", "", "L", "2026-01-31", 60, true},
+		{"general price on the day it changes", "milk", "", "L", "2026-02-01", 65, true},
+		{"species price wins", "milk", "goat", "L", "2026-02-15", 80, true},
+		{"species price changes later", "milk", "goat", "L", "2026-03-01", 90, true},
+		{"species without its own price", "milk", "cow", "L", "2026-02-15", 65, true},
+		{"other unit", "milk", "", "ml", "2026-02-15", 0, false},
+		{"before any price", "eggs", "", "eggs", "1999-12-31", 0, false},
+		{"unpriced product", "wool", "", "kg", "2026-02-15", 0, false},
+	}
+	for _, tt := range tests {
+		price, ok := testPrices.rate(tt.product, tt.species, tt.unit, testDate(tt.on))
+		if price != tt.price || ok != tt.ok {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, price, ok, tt.price, tt.ok)
+		}
+	}
+}
+
+// A day's production is valued at the prices in effect on that day, except
+// where a one-off rate was entered.
+func TestHerdDayEntries(t *testing.T) {
+	goatRate := 100.0
+	h := herdDay{milkCow: 10, milkGoat: 4, eggs: 30, wool: 2, overrides: map[string]*float64{"milk:goat": &goatRate}}
+	got := h.entries(testPrices, testDate("2026-02-15"))
+	want := []productionEntry{
+		{kind: "milk", species: "cow", unit: "L", quantity: 10, value: 650, source: "price"},
+		{kind: "milk", species: "goat", unit: "L", quantity: 4, value: 400, source: "manual"},
+		{kind: "eggs", unit: "eggs", quantity: 30, value: 450, source: "price"},
+		// Nothing prices wool, so it is valued at zero until revalued.
+		{kind: "wool", unit: "kg", quantity: 2, value: 0, source: "price"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].kind != want[i].kind || got[i].species != want[i].species || got[i].unit != want[i].unit ||
+			got[i].quantity != want[i].quantity || math.Abs(got[i].value-want[i].value) > 0.001 || got[i].source != want[i].source {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Unsplit milk uses the general price.
+	got = herdDay{milk: 20}.entries(testPrices, testDate("2026-01-10"))
+	if len(got) != 1 || got[0].species != "" || got[0].value != 1200 {
+		t.Errorf("unsplit milk = %+v, want 20 L at 60", got)
+	}
+}
+
+func TestNormalizePriceInput(t *testing.T) {
+	tests := []struct {
+		name                   string
+		product, species, unit string
+		effectiveFrom          string
+		price                  float64
+		wantUnit, msg          string
+	}{
+		{"default unit", " Milk ", "Goat", "", "2026-03-01", 90, "L", ""},
+		{"weight is not sold", "weight", "", "", "2026-03-01", 1, "", "product must be one of milk, eggs, wool, meat"},
+		{"negative price", "eggs", "", "", "2026-03-01", -1, "", "price cannot be negative"},
+		{"bad date", "wool", "", "", "01-03-2026", 500, "", "effectiveFrom must be YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		product, species, unit, d, msg := normalizePriceInput(tt.product, tt.species, tt.unit, tt.effectiveFrom, tt.price)
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg == "" && (product != "milk" || species != "goat" || unit != tt.wantUnit || !d.Equal(testDate(tt.effectiveFrom))) {
+			t.Errorf("%s: got %q %q %q %v", tt.name, product, species, unit, d)
+		}
+	}
+}
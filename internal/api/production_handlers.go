@@ -0,0 +1,680 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var productionUnits = map[string]string{
+	"milk":   "L",
+	"eggs":   "eggs",
+	"wool":   "kg",
+	"meat":   "kg",
+	"weight": "kg",
+}
+
+type productionEntry struct {
+	kind     string
+	species  string
+	unit     string
+	quantity float64
+	value    float64
+	// source is "price" when value came from the price list and "manual"
+	// when it was typed in or computed from a one-off rate.
+	source string
+}
+
+type productionRecordInput struct {
+	Date        string   `json:"date"`
+	AnimalTagID string   `json:"animalTagId"`
+	Kind        string   `json:"kind"`
+	Session     string   `json:"session"`
+	Species     string   `json:"species"`
+	Quantity    float64  `json:"quantity"`
+	Rate        *float64 `json:"rate"`
+	Value       *float64 `json:"value"`
+	Notes       string   `json:"notes"`
+	// Withheld records produce kept out of sale, such as milk from an
+	// animal under a drug withdrawal.
+	Withheld bool `json:"withheld"`
+}
+
+type productionRecord struct {
+	date     time.Time
+	animalID *int64
+	session  string
+	notes    string
+	withheld bool
+	productionEntry
+}
+
+// productionSpecies maps an animal type onto the species the daily totals
+// split milk by. Types without a split keep their own name.
+func productionSpecies(animalType string) string {
+	v := strings.ToLower(strings.TrimSpace(animalType))
+	switch v {
+	case "cattle", "cow", "bull", "heifer":
+		return "cow"
+	case "goat", "goats":
+		return "goat"
+	default:
+		return v
+	}
+}
+
+// resolveProductionRecord validates in and looks up the animal it belongs to.
+// An empty animalTagId records against the herd. A non-empty message is a
+// validation failure for the client.
+func (s *Server) resolveProductionRecord(ctx context.Context, farmID int64, in productionRecordInput, activeOnly bool) (productionRecord, string, error) {
+	var rec productionRecord
+	if strings.TrimSpace(in.Date) == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", in.Date)
+	if err != nil {
+		return rec, "date must be YYYY-MM-DD", nil
+	}
+	rec.date = d
+	rec.kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	unit, ok := productionUnits[rec.kind]
+	if !ok {
+		return rec, "kind must be one of milk, eggs, wool, meat, weight", nil
+	}
+	rec.unit = unit
+	rec.session = strings.ToLower(strings.TrimSpace(in.Session))
+	if rec.session != "" && (rec.kind != "milk" || (rec.session != "am" && rec.session != "pm")) {
+		return rec, "session must be am or pm and only applies to milk", nil
+	}
+	if in.Quantity < 0 || (rec.kind == "weight" && in.Quantity == 0) {
+		return rec, "quantity must be positive", nil
+	}
+	if rec.kind == "eggs" && in.Quantity != math.Trunc(in.Quantity) {
+		return rec, "eggs must be a whole number", nil
+	}
+	rec.quantity = in.Quantity
+	rec.notes = strings.TrimSpace(in.Notes)
+	rec.withheld = in.Withheld
+	if rec.withheld && rec.kind == "weight" {
+		return rec, "a weight cannot be withheld", nil
+	}
+
+	if strings.TrimSpace(in.AnimalTagID) == "" {
+		if rec.kind == "weight" {
+			return rec, "weight must be recorded for an animal", nil
+		}
+		rec.species = strings.ToLower(strings.TrimSpace(in.Species))
+	} else {
+		tagID, ok := normalizeAnimalTag(in.AnimalTagID)
+		if !ok {
+			return rec, "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)", nil
+		}
+		var animalID int64
+		var animalType string
+		err := s.db.QueryRow(ctx, `
+			SELECT id, type
+			FROM animals
+			WHERE tag_id = $1 AND farm_id = $2 AND ($3 = false OR is_active = true)
+		`, tagID, farmID, activeOnly).Scan(&animalID, &animalType)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return rec, "animal not found", nil
+			}
+			return rec, "", err
+		}
+		profile := speciesProfile(animalType)
+		if rec.kind == "eggs" && profile != "poultry" {
+			return rec, "eggs can only be recorded for a poultry flock", nil
+		}
+		if (rec.kind == "milk" || rec.kind == "wool") && profile != "mammal" {
+			return rec, rec.kind + " can only be recorded for a mammal", nil
+		}
+		rec.animalID = &animalID
+		rec.species = productionSpecies(animalType)
+
+		if (rec.kind == "milk" || rec.kind == "meat") && !rec.withheld {
+			list, err := activeWithdrawals(ctx, s.db, farmID, animalID, rec.date)
+			if err != nil {
+				return rec, "", err
+			}
+			for _, a := range list {
+				until := a.milkUntil
+				if rec.kind == "meat" {
+					until = a.meatUntil
+				}
+				if until != nil {
+					return rec, fmt.Sprintf("%s is under %s withdrawal until %s after %s; record it as withheld", a.tag, rec.kind, s.formatISODate(*until), a.treatments), nil
+				}
+			}
+		}
+	}
+
+	rec.source = "manual"
+	switch {
+	case rec.withheld:
+		// Withheld produce is not for sale, so it has no value to revalue.
+	case in.Value != nil:
+		if *in.Value < 0 {
+			return rec, "value cannot be negative", nil
+		}
+		rec.value = *in.Value
+	case in.Rate != nil:
+		if *in.Rate < 0 {
+			return rec, "rate cannot be negative", nil
+		}
+		rec.value = rec.quantity * *in.Rate
+	case rec.kind != "weight":
+		// Without a price in effect the record is valued at zero and picked
+		// up by the next revaluation once a price is added.
+		prices, err := s.loadPriceList(ctx, farmID)
+		if err != nil {
+			return rec, "", err
+		}
+		rate, _ := prices.rate(rec.kind, rec.species, rec.unit, rec.date)
+		rec.value = rec.quantity * rate
+		rec.source = "price"
+	}
+	return rec, "", nil
+}
+
+// syncAnimalWeight copies a weight record onto the animal when it is the most
+// recent one, so the animal list keeps showing the current weight.
+func syncAnimalWeight(ctx context.Context, tx pgx.Tx, rec productionRecord) error {
+	if rec.kind != "weight" || rec.animalID == nil {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		UPDATE animals
+		SET weight_kg = $1
+		WHERE id = $2 AND NOT EXISTS (
+			SELECT 1 FROM production_records
+			WHERE animal_id = $2 AND kind = 'weight' AND record_date > $3
+		)
+	`, rec.quantity, *rec.animalID, rec.date)
+	return err
+}
+
+func (s *Server) handleProductionRecords(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	kind := strings.ToLower(strings.TrimSpace(q.Get("kind")))
+	if _, ok := productionUnits[kind]; kind != "" && !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be one of milk, eggs, wool, meat, weight"})
+		return
+	}
+	// animalTagId=herd selects the herd pseudo-entry.
+	tagID := strings.TrimSpace(q.Get("animalTagId"))
+	herdOnly := strings.EqualFold(tagID, "herd")
+	if herdOnly {
+		tagID = ""
+	} else if tagID != "" {
+		normalized, ok := normalizeAnimalTag(tagID)
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
+			return
+		}
+		tagID = normalized
+	}
+	from, err := optionalDate(q.Get("from"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+		return
+	}
+	to, err := optionalDate(q.Get("to"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+
+	const filter = `
+		WHERE p.farm_id = $1
+			AND ($2 = '' OR p.kind = $2)
+			AND ($3 = '' OR a.tag_id = $3)
+			AND ($4 = false OR p.animal_id IS NULL)
+			AND ($5::date IS NULL OR p.record_date >= $5::date)
+			AND ($6::date IS NULL OR p.record_date <= $6::date)
+	`
+	var total int64
+	_ = s.db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM production_records p
+		LEFT JOIN animals a ON a.id = p.animal_id
+	`+filter, farmID, kind, tagID, herdOnly, from, to).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT p.id, p.record_date, COALESCE(a.tag_id, ''), COALESCE(a.type, ''), p.kind, p.session, p.species,
+		       p.quantity, p.unit, p.value, p.notes, p.withheld
+		FROM production_records p
+		LEFT JOIN animals a ON a.id = p.animal_id
+	`+filter+`
+		ORDER BY p.record_date DESC, a.tag_id NULLS FIRST, p.session, p.id
+		LIMIT $7 OFFSET $8
+	`, farmID, kind, tagID, herdOnly, from, to, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load production records"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var d time.Time
+		var tag, animalType, kind, session, species, unit, notes string
+		var quantity, value float64
+		var withheld bool
+		if err := rows.Scan(&id, &d, &tag, &animalType, &kind, &session, &species, &quantity, &unit, &value, &notes, &withheld); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse production records"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":            id,
+			"date":          s.formatDateCompact(d),
+			"dateRaw":       s.formatISODate(d),
+			"herd":          tag == "",
+			"animalTagId":   tag,
+			"animalType":    animalType,
+			"kind":          kind,
+			"session":       session,
+			"species":       species,
+			"quantity":      fmt.Sprintf("%s %s", trimZero(quantity), unit),
+			"quantityValue": quantity,
+			"unit":          unit,
+			"amount":        formatKES(value),
+			"amountValue":   value,
+			"notes":         notes,
+			"withheld":      withheld,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (s *Server) handleCreateProductionRecord(w http.ResponseWriter, r *http.Request) {
+	var in productionRecordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rec, msg, err := s.resolveProductionRecord(ctx, farmID, in, true)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create production record"})
+		return
+	}
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "production_records", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO production_records(farm_id, animal_id, record_date, kind, session, species, quantity, unit, value, value_source, notes, withheld)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id
+		`, farmID, rec.animalID, rec.date, rec.kind, rec.session, rec.species, rec.quantity, rec.unit, rec.value, rec.source, rec.notes, rec.withheld).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, syncAnimalWeight(ctx, tx, rec)
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "milk for this animal and session is already recorded"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create production record"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+func (s *Server) handleUpdateProductionRecord(w http.ResponseWriter, r *http.Request) {
+	recordID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid record id"})
+		return
+	}
+	var in productionRecordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rec, msg, err := s.resolveProductionRecord(ctx, farmID, in, false)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update production record"})
+		return
+	}
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "production_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE production_records
+			SET animal_id = $1, record_date = $2, kind = $3, session = $4, species = $5,
+				quantity = $6, unit = $7, value = $8, value_source = $9, notes = $10, withheld = $11
+			WHERE id = $12 AND farm_id = $13
+		`, rec.animalID, rec.date, rec.kind, rec.session, rec.species, rec.quantity, rec.unit, rec.value, rec.source, rec.notes, rec.withheld, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, syncAnimalWeight(ctx, tx, rec)
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "milk for this animal and session is already recorded"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update production record"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeleteProductionRecord(w http.ResponseWriter, r *http.Request) {
+	recordID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid record id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "production_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM production_records WHERE id = $1 AND farm_id = $2`, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete production record"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleAnimalYields compares each animal's average daily yield over the last
+// `days` days with the period before it, biggest drop first.
+func (s *Server) handleAnimalYields(w http.ResponseWriter, r *http.Request) {
+	kind := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("kind")))
+	if kind == "" {
+		kind = "milk"
+	}
+	if _, ok := productionUnits[kind]; !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be one of milk, eggs, wool, meat, weight"})
+		return
+	}
+	days := 14
+	if v := strings.TrimSpace(r.URL.Query().Get("days")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 365"})
+			return
+		}
+		days = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT a.tag_id, a.type,
+			COALESCE(SUM(p.quantity) FILTER (WHERE p.record_date > CURRENT_DATE - $3::int), 0),
+			COUNT(DISTINCT p.record_date) FILTER (WHERE p.record_date > CURRENT_DATE - $3::int),
+			COALESCE(SUM(p.quantity) FILTER (WHERE p.record_date <= CURRENT_DATE - $3::int), 0),
+			COUNT(DISTINCT p.record_date) FILTER (WHERE p.record_date <= CURRENT_DATE - $3::int),
+			MAX(p.record_date)
+		FROM production_records p
+		JOIN animals a ON a.id = p.animal_id
+		WHERE p.farm_id = $1 AND p.kind = $2 AND p.record_date > CURRENT_DATE - 2 * $3::int
+		GROUP BY a.id, a.tag_id, a.type
+	`, farmID, kind, days)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load animal yields"})
+		return
+	}
+	defer rows.Close()
+
+	type yield struct {
+		item   map[string]any
+		change *float64
+		tag    string
+	}
+	yields := make([]yield, 0)
+	unit := productionUnits[kind]
+	for rows.Next() {
+		var tag, animalType string
+		var current, previous float64
+		var currentDays, previousDays int64
+		var last time.Time
+		if err := rows.Scan(&tag, &animalType, &current, &currentDays, &previous, &previousDays, &last); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse animal yields"})
+			return
+		}
+		var currentAvg, previousAvg float64
+		if currentDays > 0 {
+			currentAvg = current / float64(currentDays)
+		}
+		if previousDays > 0 {
+			previousAvg = previous / float64(previousDays)
+		}
+		var change *float64
+		if previousAvg > 0 && currentDays > 0 {
+			v := ((currentAvg - previousAvg) / previousAvg) * 100
+			change = &v
+		}
+		yields = append(yields, yield{
+			tag:    tag,
+			change: change,
+			item: map[string]any{
+				"animalTagId":     tag,
+				"animalType":      animalType,
+				"total":           current,
+				"daysRecorded":    currentDays,
+				"averagePerDay":   fmt.Sprintf("%.1f %s", currentAvg, unit),
+				"averageValue":    currentAvg,
+				"previousAverage": previousAvg,
+				"changePercent":   change,
+				"lastRecorded":    s.formatDateCompact(last),
+				"lastRecordedRaw": s.formatISODate(last),
+			},
+		})
+	}
+
+	sort.SliceStable(yields, func(i, j int) bool {
+		a, b := yields[i].change, yields[j].change
+		if a == nil || b == nil {
+			if a == nil && b == nil {
+				return yields[i].tag < yields[j].tag
+			}
+			return b == nil
+		}
+		if *a != *b {
+			return *a < *b
+		}
+		return yields[i].tag < yields[j].tag
+	})
+	out := make([]map[string]any, 0, len(yields))
+	for _, y := range yields {
+		out = append(out, y.item)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"kind":  kind,
+		"unit":  unit,
+		"days":  days,
+		"items": out,
+	})
+}
+
+// herdDay is one day's farm-wide production as entered on the daily log form.
+type herdDay struct {
+	milk, milkCow, milkGoat, wool, meat float64
+	eggs                                int
+	// overrides holds one-off rates keyed by product, or product:species for
+	// split milk. Everything else is valued from the price list.
+	overrides map[string]*float64
+}
+
+// entries splits the day into herd records, one per product. Milk is split by
+// species when cow or goat litres are given.
+func (h herdDay) entries(prices priceList, on time.Time) []productionEntry {
+	out := make([]productionEntry, 0, 6)
+	add := func(kind, species string, quantity float64) {
+		if quantity <= 0 {
+			return
+		}
+		e := productionEntry{kind: kind, species: species, unit: productionUnits[kind], quantity: quantity, source: "price"}
+		key := kind
+		if species != "" {
+			key += ":" + species
+		}
+		if rate := h.overrides[key]; rate != nil {
+			e.value = quantity * *rate
+			e.source = "manual"
+		} else {
+			rate, _ := prices.rate(kind, species, e.unit, on)
+			e.value = quantity * rate
+		}
+		out = append(out, e)
+	}
+	if h.milkCow+h.milkGoat > 0 {
+		add("milk", "cow", h.milkCow)
+		add("milk", "goat", h.milkGoat)
+	} else {
+		add("milk", "", h.milk)
+	}
+	add("eggs", "", float64(h.eggs))
+	add("wool", "", h.wool)
+	add("meat", "", h.meat)
+	return out
+}
+
+func negativeRate(rates map[string]*float64) bool {
+	for _, rate := range rates {
+		if rate != nil && *rate < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// withTotalValue spreads a manually entered day total over the entries in
+// proportion to their computed values. A total with nothing to attach it to
+// is kept on an empty milk entry so the daily value is not lost.
+func withTotalValue(entries []productionEntry, total float64) []productionEntry {
+	if len(entries) == 0 {
+		if total > 0 {
+			entries = append(entries, productionEntry{kind: "milk", unit: productionUnits["milk"], value: total, source: "manual"})
+		}
+		return entries
+	}
+	computed := 0.0
+	for _, e := range entries {
+		computed += e.value
+	}
+	remaining := total
+	for i := range entries {
+		share := 0.0
+		switch {
+		case i == len(entries)-1:
+			share = math.Max(remaining, 0)
+		case computed > 0:
+			share = math.Round(total*entries[i].value/computed*100) / 100
+		case i == 0:
+			share = total
+		}
+		entries[i].value = share
+		entries[i].source = "manual"
+		remaining -= share
+	}
+	return entries
+}
+
+// replaceHerdDay swaps the herd records on day for entries recorded on
+// newDay, auditing each removed and added record. It reports how many herd
+// records day had before.
+func (s *Server) replaceHerdDay(ctx context.Context, tx pgx.Tx, r *http.Request, farmID int64, day, newDay time.Time, entries []productionEntry) (int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM production_records
+		WHERE farm_id = $1 AND record_date = $2 AND animal_id IS NULL
+		ORDER BY id
+	`, farmID, day)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		before, err := auditSnapshot(ctx, tx, "production_records", id)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM production_records WHERE id = $1`, id); err != nil {
+			return 0, err
+		}
+		if err := s.recordAudit(ctx, tx, r, auditDelete, "production_records", id, before); err != nil {
+			return 0, err
+		}
+	}
+	for _, e := range entries {
+		var id int64
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO production_records(farm_id, animal_id, record_date, kind, species, quantity, unit, value, value_source)
+			VALUES ($1, NULL, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`, farmID, newDay, e.kind, e.species, e.quantity, e.unit, e.value, e.source).Scan(&id); err != nil {
+			return 0, err
+		}
+		if err := s.recordAudit(ctx, tx, r, auditCreate, "production_records", id, nil); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
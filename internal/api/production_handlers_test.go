@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestProductionSpecies(t *testing.T) {
+	tests := map[string]string{
+		"Cattle":  "cow",
+		" heifer": "cow",
+		"Goats":   "goat",
+		"sheep":   "sheep",
+		"Chicken": "chicken",
+	}
+	for animalType, want := range tests {
+		if got := productionSpecies(animalType); got != want {
+			t.Errorf("%q: species %q, want %q", animalType, got, want)
+		}
+	}
+}
+
+// Herd records need no animal lookup, so their validation runs without a
+// database as long as the value is given.
+func TestResolveHerdProductionRecord(t *testing.T) {
+	s := &Server{}
+	rate := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name  string
+		in    productionRecordInput
+		msg   string
+		value float64
+	}{
+		{"milk at a one-off rate", productionRecordInput{Date: "2026-03-12", Kind: "Milk", Session: "AM", Quantity: 12.5, Rate: rate(60)}, "", 750},
+		{"typed value wins", productionRecordInput{Date: "2026-03-12", Kind: "eggs", Quantity: 30, Value: rate(420)}, "", 420},
+		{"withheld has no value", productionRecordInput{Date: "2026-03-12", Kind: "milk", Quantity: 8, Rate: rate(60), Withheld: true}, "", 0},
+		{"bad date", productionRecordInput{Date: "12/03/2026", Kind: "milk", Quantity: 1, Rate: rate(1)}, "date must be YYYY-MM-DD", 0},
+		{"unknown kind", productionRecordInput{Date: "2026-03-12", Kind: "honey", Quantity: 1, Rate: rate(1)}, "kind must be one of milk, eggs, wool, meat, weight", 0},
+		{"session on eggs", productionRecordInput{Date: "2026-03-12", Kind: "eggs", Session: "am", Quantity: 1, Rate: rate(1)}, "session must be am or pm and only applies to milk", 0},
+		{"negative quantity", productionRecordInput{Date: "2026-03-12", Kind: "wool", Quantity: -1, Rate: rate(1)}, "quantity must be positive", 0},
+		{"half an egg", productionRecordInput{Date: "2026-03-12", Kind: "eggs", Quantity: 2.5, Rate: rate(1)}, "eggs must be a whole number", 0},
+		{"herd weight", productionRecordInput{Date: "2026-03-12", Kind: "weight", Quantity: 310}, "weight must be recorded for an animal", 0},
+		{"negative rate", productionRecordInput{Date: "2026-03-12", Kind: "meat", Quantity: 3, Rate: rate(-5)}, "rate cannot be negative", 0},
+	}
+	for _, tt := range tests {
+		rec, msg, err := s.resolveProductionRecord(context.Background(), 1, tt.in, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg == "" && (math.Abs(rec.value-tt.value) > 0.001 || rec.source != "manual") {
+			t.Errorf("%s: value %v (%s), want %v (manual)", tt.name, rec.value, rec.source, tt.value)
+		}
+	}
+}
+
+func TestWithTotalValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []productionEntry
+		total   float64
+		want    []float64
+	}{
+		{
+			name:    "split by computed value",
+			entries: []productionEntry{{kind: "milk", value: 600}, {kind: "eggs", value: 200}},
+			total:   1000,
+			want:    []float64{750, 250},
+		},
+		{
+			// The last entry takes the rounding remainder so the day adds up.
+			name:    "rounding goes to the last entry",
+			entries: []productionEntry{{kind: "milk", value: 1}, {kind: "eggs", value: 1}, {kind: "wool", value: 1}},
+			total:   100,
+			want:    []float64{33.33, 33.33, 33.34},
+		},
+		{
+			name:    "nothing priced puts it on the first entry",
+			entries: []productionEntry{{kind: "milk"}, {kind: "eggs"}},
+			total:   500,
+			want:    []float64{500, 0},
+		},
+		{
+			name:  "total without production is kept on milk",
+			total: 250,
+			want:  []float64{250},
+		},
+	}
+	for _, tt := range tests {
+		got := withTotalValue(tt.entries, tt.total)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d entries, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		sum := 0.0
+		for i, e := range got {
+			if math.Abs(e.value-tt.want[i]) > 0.001 || e.source != "manual" {
+				t.Errorf("%s: entry %d = %v (%s), want %v (manual)", tt.name, i, e.value, e.source, tt.want[i])
+			}
+			sum += e.value
+		}
+		if math.Abs(sum-tt.total) > 0.001 {
+			t.Errorf("%s: entries add up to %v, want %v", tt.name, sum, tt.total)
+		}
+	}
+}
+
+func TestNegativeRate(t *testing.T) {
+	ok, bad := 60.0, -1.0
+	if negativeRate(map[string]*float64{"milk": &ok, "eggs": nil}) {
+		t.Error("valid rates reported negative")
+	}
+	if !negativeRate(map[string]*float64{"milk": &ok, "milk:goat": &bad}) {
+		t.Error("negative rate accepted")
+	}
+}
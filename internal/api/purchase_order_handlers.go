@@ -0,0 +1,958 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	purchaseOrderOpen      = "open"
+	purchaseOrderPartial   = "partial"
+	purchaseOrderReceived  = "received"
+	purchaseOrderCancelled = "cancelled"
+
+	maxPurchaseOrderLines = 100
+)
+
+type purchaseOrderLineInput struct {
+	Item          string  `json:"item"`
+	Category      string  `json:"category"`
+	IsFeed        bool    `json:"isFeed"`
+	FeedItemID    int64   `json:"feedItemId"`
+	QuantityValue float64 `json:"quantityValue"`
+	QuantityUnit  string  `json:"quantityUnit"`
+	UnitPrice     float64 `json:"unitPrice"`
+}
+
+type purchaseOrderInput struct {
+	SupplierID   int64                    `json:"supplierId"`
+	Supplier     string                   `json:"supplier"`
+	OrderDate    string                   `json:"orderDate"`
+	ExpectedDate string                   `json:"expectedDate"`
+	Notes        string                   `json:"notes"`
+	Lines        []purchaseOrderLineInput `json:"lines"`
+}
+
+type purchaseOrderHeader struct {
+	orderDate    time.Time
+	expectedDate *time.Time
+	notes        string
+}
+
+type purchaseOrderLine struct {
+	id             int64
+	lineNo         int
+	item           string
+	category       string
+	isFeed         bool
+	feedItemID     *int64
+	quantity       float64
+	received       float64
+	unit           string
+	price          float64
+	total          float64
+	receivedAmount float64
+}
+
+func normalizePurchaseOrderInput(in purchaseOrderInput) (purchaseOrderHeader, []purchaseOrderLine, string) {
+	var h purchaseOrderHeader
+	if strings.TrimSpace(in.OrderDate) == "" {
+		in.OrderDate = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(in.OrderDate))
+	if err != nil {
+		return h, nil, "orderDate must be YYYY-MM-DD"
+	}
+	h.orderDate = d
+	if h.expectedDate, err = optionalDate(in.ExpectedDate); err != nil {
+		return h, nil, "expectedDate must be YYYY-MM-DD"
+	}
+	if h.expectedDate != nil && h.expectedDate.Before(h.orderDate) {
+		return h, nil, "expectedDate cannot be before orderDate"
+	}
+	h.notes = strings.TrimSpace(in.Notes)
+	if in.SupplierID <= 0 && strings.TrimSpace(in.Supplier) == "" {
+		return h, nil, "supplierId or supplier is required"
+	}
+
+	if len(in.Lines) == 0 {
+		return h, nil, "an order needs at least one line"
+	}
+	if len(in.Lines) > maxPurchaseOrderLines {
+		return h, nil, fmt.Sprintf("an order can have at most %d lines", maxPurchaseOrderLines)
+	}
+	lines := make([]purchaseOrderLine, 0, len(in.Lines))
+	for i, l := range in.Lines {
+		line := purchaseOrderLine{
+			lineNo:   i + 1,
+			item:     strings.Join(strings.Fields(l.Item), " "),
+			category: strings.TrimSpace(l.Category),
+			isFeed:   l.IsFeed || l.FeedItemID > 0,
+			quantity: roundCents(l.QuantityValue),
+			unit:     strings.TrimSpace(l.QuantityUnit),
+			price:    roundCents(l.UnitPrice),
+		}
+		if l.FeedItemID > 0 {
+			id := l.FeedItemID
+			line.feedItemID = &id
+		}
+		var ok bool
+		if line.unit, ok = normalizeUnit(line.unit, "kg"); !ok {
+			return h, nil, fmt.Sprintf("line %d: unit must be one of %s", i+1, unitCodes())
+		}
+		if line.category == "" && line.isFeed {
+			line.category = "Feed"
+		}
+		if line.item == "" || line.category == "" || line.quantity <= 0 || line.price < 0 {
+			return h, nil, fmt.Sprintf("line %d: item, category, quantity and a non-negative unit price are required", i+1)
+		}
+		line.total = roundCents(line.quantity * line.price)
+		lines = append(lines, line)
+	}
+	return h, lines, ""
+}
+
+func purchaseOrderTotal(lines []purchaseOrderLine) float64 {
+	var total float64
+	for _, l := range lines {
+		total += l.total
+	}
+	return roundCents(total)
+}
+
+func feedItemKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// resolveFeedItem finds the feed store item a feed line is bought into, by id
+// or by name, and creates it in the line's unit when it is new. Stock is
+// counted in one unit per item, so the line's unit must convert to it.
+func resolveFeedItem(ctx context.Context, tx pgx.Tx, farmID int64, l *purchaseOrderLine) error {
+	var id int64
+	var name, unit string
+	var density float64
+	var err error
+	if l.feedItemID != nil {
+		err = tx.QueryRow(ctx, `SELECT id, name, unit, COALESCE(density, 0) FROM feed_items WHERE id = $1 AND farm_id = $2`, *l.feedItemID, farmID).Scan(&id, &name, &unit, &density)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &httpError{http.StatusBadRequest, fmt.Sprintf("line %d: feed item not found", l.lineNo)}
+		}
+	} else {
+		err = tx.QueryRow(ctx, `SELECT id, name, unit, COALESCE(density, 0) FROM feed_items WHERE farm_id = $1 AND name_key = $2`, farmID, feedItemKey(l.item)).Scan(&id, &name, &unit, &density)
+		if errors.Is(err, pgx.ErrNoRows) {
+			name, unit = l.item, l.unit
+			err = tx.QueryRow(ctx, `
+				INSERT INTO feed_items(farm_id, name, name_key, unit)
+				VALUES ($1, $2, $3, $4)
+				RETURNING id
+			`, farmID, name, feedItemKey(name), unit).Scan(&id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := convertQuantity(1, l.unit, unit, feedDensity(feedItemKey(name), density)); !ok {
+		return &httpError{http.StatusBadRequest, fmt.Sprintf("line %d: %s is stocked in %s and %s does not convert to it", l.lineNo, name, unit, l.unit)}
+	}
+	l.feedItemID = &id
+	return nil
+}
+
+func insertPurchaseOrderLines(ctx context.Context, tx pgx.Tx, farmID, orderID int64, lines []purchaseOrderLine) error {
+	for i := range lines {
+		l := &lines[i]
+		if l.isFeed {
+			if err := resolveFeedItem(ctx, tx, farmID, l); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO purchase_order_lines(
+				purchase_order_id, line_no, item, category, feed_item_id, quantity_ordered, quantity_unit, unit_price, total_amount
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, orderID, l.lineNo, l.item, l.category, l.feedItemID, l.quantity, l.unit, l.price, l.total)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextDocumentNumber takes the farm's next number for a kind of purchasing
+// document, e.g. PO-000001. Like invoice numbers they are gapless: the counter
+// row stays locked until tx ends.
+func nextDocumentNumber(ctx context.Context, tx pgx.Tx, farmID int64, kind string) (string, error) {
+	var seq int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO document_sequences(farm_id, kind, last_number)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (farm_id, kind) DO UPDATE SET last_number = document_sequences.last_number + 1
+		RETURNING last_number
+	`, farmID, kind).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%06d", kind, seq), nil
+}
+
+// purchaseOrderLinesQuery loads an order's lines with what has been received
+// against each; $1 is the order id.
+const purchaseOrderLinesQuery = `
+	SELECT l.id, l.line_no, l.item, l.category, l.feed_item_id, l.quantity_ordered, l.quantity_received, l.quantity_unit,
+	       l.unit_price, l.total_amount, COALESCE((SELECT SUM(g.amount) FROM goods_receipt_lines g WHERE g.purchase_order_line_id = l.id), 0)
+	FROM purchase_order_lines l
+	WHERE l.purchase_order_id = $1
+	ORDER BY l.line_no
+`
+
+func scanPurchaseOrderLines(rows pgx.Rows) ([]purchaseOrderLine, error) {
+	defer rows.Close()
+	var out []purchaseOrderLine
+	for rows.Next() {
+		var l purchaseOrderLine
+		if err := rows.Scan(
+			&l.id, &l.lineNo, &l.item, &l.category, &l.feedItemID, &l.quantity, &l.received, &l.unit,
+			&l.price, &l.total, &l.receivedAmount,
+		); err != nil {
+			return nil, err
+		}
+		l.isFeed = l.feedItemID != nil
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func (l purchaseOrderLine) asMap() map[string]any {
+	return map[string]any{
+		"id":                l.id,
+		"lineNo":            l.lineNo,
+		"item":              l.item,
+		"category":          l.category,
+		"isFeed":            l.isFeed,
+		"feedItemId":        l.feedItemID,
+		"quantity":          trimZero(l.quantity) + " " + l.unit,
+		"quantityValue":     l.quantity,
+		"quantityUnit":      l.unit,
+		"quantityReceived":  l.received,
+		"quantityRemaining": roundCents(l.quantity - l.received),
+		"unitPrice":         l.price,
+		"totalAmount":       l.total,
+		"receivedAmount":    l.receivedAmount,
+	}
+}
+
+// lockPurchaseOrder locks an order for a change and returns its status and
+// whether anything has been received against it.
+func lockPurchaseOrder(ctx context.Context, tx pgx.Tx, farmID, orderID int64) (string, bool, error) {
+	var status string
+	var received bool
+	err := tx.QueryRow(ctx, `
+		SELECT status, EXISTS (SELECT 1 FROM goods_receipts g WHERE g.purchase_order_id = o.id)
+		FROM purchase_orders o
+		WHERE id = $1 AND farm_id = $2
+		FOR UPDATE
+	`, orderID, farmID).Scan(&status, &received)
+	return status, received, err
+}
+
+// refreshPurchaseOrderStatus derives an order's status from what has been
+// received against its lines. Cancelled orders stay cancelled.
+func refreshPurchaseOrderStatus(ctx context.Context, tx pgx.Tx, orderID int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE purchase_orders o
+		SET status = CASE
+			WHEN NOT EXISTS (SELECT 1 FROM purchase_order_lines l WHERE l.purchase_order_id = o.id AND l.quantity_received < l.quantity_ordered) THEN 'received'
+			WHEN EXISTS (SELECT 1 FROM purchase_order_lines l WHERE l.purchase_order_id = o.id AND l.quantity_received > 0) THEN 'partial'
+			ELSE 'open'
+		END
+		WHERE o.id = $1 AND o.status <> 'cancelled'
+	`, orderID)
+	return err
+}
+
+func (s *Server) handlePurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	switch status {
+	case "", purchaseOrderOpen, purchaseOrderPartial, purchaseOrderReceived, purchaseOrderCancelled:
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be open, partial, received or cancelled"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	search := parseSearch(r)
+	offset := (page - 1) * pageSize
+
+	supplierID, _ := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("supplierId")), 10, 64)
+	const filter = `
+		o.farm_id = $1 AND ($2 = '' OR o.status = $2) AND ($4 = 0 OR o.supplier_id = $4)
+		AND ($3 = '' OR o.po_number ILIKE '%' || $3 || '%' OR s.name ILIKE '%' || $3 || '%'
+			OR EXISTS (SELECT 1 FROM purchase_order_lines l WHERE l.purchase_order_id = o.id AND l.item ILIKE '%' || $3 || '%'))
+	`
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM purchase_orders o JOIN suppliers s ON s.id = o.supplier_id WHERE `+filter, farmID, status, search, supplierID).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT o.id, o.po_number, o.order_date, o.expected_date, o.status, o.supplier_id, s.name, o.total_amount,
+		       (SELECT COUNT(*) FROM purchase_order_lines l WHERE l.purchase_order_id = o.id),
+		       COALESCE((SELECT SUM(g.total_amount) FROM goods_receipts g WHERE g.purchase_order_id = o.id), 0)
+		FROM purchase_orders o
+		JOIN suppliers s ON s.id = o.supplier_id
+		WHERE `+filter+`
+		ORDER BY o.order_date DESC, o.id DESC
+		LIMIT $5 OFFSET $6
+	`, farmID, status, search, supplierID, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load purchase orders"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, supplierID int64
+		var number, status, supplierName string
+		var d time.Time
+		var expected *time.Time
+		var amount, received float64
+		var lineCount int
+		if err := rows.Scan(&id, &number, &d, &expected, &status, &supplierID, &supplierName, &amount, &lineCount, &received); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse purchase orders"})
+			return
+		}
+		expectedDate := ""
+		if expected != nil {
+			expectedDate = s.formatISODate(*expected)
+		}
+		out = append(out, map[string]any{
+			"id":             id,
+			"poNumber":       number,
+			"date":           s.formatDateCompact(d),
+			"dateRaw":        s.formatISODate(d),
+			"expectedDate":   expectedDate,
+			"status":         status,
+			"supplierId":     supplierID,
+			"supplier":       supplierName,
+			"lineCount":      lineCount,
+			"totalAmount":    amount,
+			"total":          formatKES(amount),
+			"receivedAmount": received,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (s *Server) handlePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid purchase order id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var number, status, supplierName, notes string
+	var d time.Time
+	var expected *time.Time
+	var supplierID int64
+	var amount float64
+	err = s.db.QueryRow(ctx, `
+		SELECT o.po_number, o.order_date, o.expected_date, o.status, o.supplier_id, s.name, o.notes, o.total_amount
+		FROM purchase_orders o
+		JOIN suppliers s ON s.id = o.supplier_id
+		WHERE o.id = $1 AND o.farm_id = $2
+	`, orderID, farmID).Scan(&number, &d, &expected, &status, &supplierID, &supplierName, &notes, &amount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "purchase order not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load purchase order"})
+		return
+	}
+
+	rows, err := s.db.Query(ctx, purchaseOrderLinesQuery, orderID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load purchase order lines"})
+		return
+	}
+	lines, err := scanPurchaseOrderLines(rows)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load purchase order lines"})
+		return
+	}
+	lineItems := make([]map[string]any, 0, len(lines))
+	for _, l := range lines {
+		lineItems = append(lineItems, l.asMap())
+	}
+
+	receipts := make([]map[string]any, 0)
+	rows, err = s.db.Query(ctx, `
+		SELECT id, grn_number, received_date, delivery_note, notes, total_amount
+		FROM goods_receipts
+		WHERE purchase_order_id = $1 AND farm_id = $2
+		ORDER BY received_date, id
+	`, orderID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load goods received notes"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var grnNumber, deliveryNote, grnNotes string
+		var received time.Time
+		var grnTotal float64
+		if err := rows.Scan(&id, &grnNumber, &received, &deliveryNote, &grnNotes, &grnTotal); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse goods received notes"})
+			return
+		}
+		receipts = append(receipts, map[string]any{
+			"id":           id,
+			"grnNumber":    grnNumber,
+			"dateRaw":      s.formatISODate(received),
+			"deliveryNote": deliveryNote,
+			"notes":        grnNotes,
+			"totalAmount":  grnTotal,
+		})
+	}
+
+	expectedDate := ""
+	if expected != nil {
+		expectedDate = s.formatISODate(*expected)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"id":           orderID,
+		"poNumber":     number,
+		"date":         s.formatDateCompact(d),
+		"dateRaw":      s.formatISODate(d),
+		"expectedDate": expectedDate,
+		"status":       status,
+		"supplierId":   supplierID,
+		"supplier":     supplierName,
+		"notes":        notes,
+		"totalAmount":  amount,
+		"lines":        lineItems,
+		"receipts":     receipts,
+	})
+}
+
+func (s *Server) handleCreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var in purchaseOrderInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	h, lines, msg := normalizePurchaseOrderInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	total := purchaseOrderTotal(lines)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var number string
+	id, err := s.auditedWrite(ctx, r, auditCreate, "purchase_orders", 0, func(tx pgx.Tx) (int64, error) {
+		sp, err := resolveSupplier(ctx, tx, farmID, in.SupplierID, in.Supplier)
+		if err != nil {
+			return 0, err
+		}
+		if !sp.isActive {
+			return 0, &httpError{http.StatusConflict, "supplier " + sp.name + " is inactive"}
+		}
+		if number, err = nextDocumentNumber(ctx, tx, farmID, "PO"); err != nil {
+			return 0, err
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO purchase_orders(farm_id, supplier_id, po_number, order_date, expected_date, notes, total_amount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, farmID, sp.id, number, h.orderDate, h.expectedDate, h.notes, total).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, insertPurchaseOrderLines(ctx, tx, farmID, id, lines)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to create purchase order")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "poNumber": number, "totalAmount": total})
+}
+
+// handleUpdatePurchaseOrder replaces an order's header and lines. Once goods
+// have been received against it the order can only be cancelled.
+func (s *Server) handleUpdatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid purchase order id"})
+		return
+	}
+	var in purchaseOrderInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	h, lines, msg := normalizePurchaseOrderInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	total := purchaseOrderTotal(lines)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "purchase_orders", orderID, func(tx pgx.Tx) (int64, error) {
+		status, received, err := lockPurchaseOrder(ctx, tx, farmID, orderID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		switch {
+		case received:
+			return 0, &httpError{http.StatusConflict, "goods have been received against this order; cancel it instead"}
+		case status != purchaseOrderOpen:
+			return 0, &httpError{http.StatusConflict, "only open orders can be edited"}
+		}
+		sp, err := resolveSupplier(ctx, tx, farmID, in.SupplierID, in.Supplier)
+		if err != nil {
+			return 0, err
+		}
+		if !sp.isActive {
+			return 0, &httpError{http.StatusConflict, "supplier " + sp.name + " is inactive"}
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE purchase_orders
+			SET supplier_id = $1, order_date = $2, expected_date = $3, notes = $4, total_amount = $5
+			WHERE id = $6 AND farm_id = $7
+		`, sp.id, h.orderDate, h.expectedDate, h.notes, total, orderID, farmID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM purchase_order_lines WHERE purchase_order_id = $1`, orderID); err != nil {
+			return 0, err
+		}
+		return orderID, insertPurchaseOrderLines(ctx, tx, farmID, orderID, lines)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to update purchase order")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "purchase order not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeletePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid purchase order id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "purchase_orders", orderID, func(tx pgx.Tx) (int64, error) {
+		_, received, err := lockPurchaseOrder(ctx, tx, farmID, orderID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if received {
+			return 0, &httpError{http.StatusConflict, "goods have been received against this order; cancel it instead"}
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM purchase_orders WHERE id = $1 AND farm_id = $2`, orderID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return orderID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to delete purchase order")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "purchase order not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleCancelPurchaseOrder closes an order that will not be delivered in
+// full. Goods already received stay booked.
+func (s *Server) handleCancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid purchase order id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "purchase_orders", orderID, func(tx pgx.Tx) (int64, error) {
+		status, _, err := lockPurchaseOrder(ctx, tx, farmID, orderID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if status != purchaseOrderOpen && status != purchaseOrderPartial {
+			return 0, &httpError{http.StatusConflict, "this order is already " + status}
+		}
+		_, err = tx.Exec(ctx, `UPDATE purchase_orders SET status = 'cancelled' WHERE id = $1`, orderID)
+		if err != nil {
+			return 0, err
+		}
+		return orderID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to cancel purchase order")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "purchase order not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// goodsReceiptLine is one order line on a goods-received note: the quantity
+// that arrived and the amount it books.
+type goodsReceiptLine struct {
+	line   purchaseOrderLine
+	qty    float64
+	amount float64
+}
+
+// planGoodsReceipt works out what a goods-received note receives. With no
+// requested lines everything outstanding arrives; otherwise only the named
+// lines, a zero quantity meaning whatever is left on that line.
+func planGoodsReceipt(lines []purchaseOrderLine, requested map[int64]float64) ([]goodsReceiptLine, error) {
+	pending := make(map[int64]bool, len(requested))
+	for id := range requested {
+		pending[id] = true
+	}
+	var receipts []goodsReceiptLine
+	for _, l := range lines {
+		remaining := roundCents(l.quantity - l.received)
+		qty, wanted := requested[l.id]
+		if len(requested) > 0 && !wanted {
+			continue
+		}
+		delete(pending, l.id)
+		if qty == 0 {
+			qty = remaining
+		}
+		if qty <= 0 {
+			if wanted {
+				return nil, &httpError{http.StatusConflict, fmt.Sprintf("line %d has already been fully received", l.lineNo)}
+			}
+			continue
+		}
+		if qty > remaining {
+			return nil, &httpError{http.StatusConflict, fmt.Sprintf("line %d has only %s %s outstanding", l.lineNo, trimZero(remaining), l.unit)}
+		}
+		amount := roundCents(qty * l.price)
+		if qty == remaining {
+			// The last delivery takes whatever is left so rounding never
+			// leaves a few cents behind.
+			amount = roundCents(l.total - l.receivedAmount)
+		}
+		receipts = append(receipts, goodsReceiptLine{line: l, qty: qty, amount: amount})
+	}
+	if len(pending) > 0 {
+		return nil, &httpError{http.StatusBadRequest, "some lines are not on this order"}
+	}
+	if len(receipts) == 0 {
+		return nil, &httpError{http.StatusConflict, "everything on this order has been received"}
+	}
+	return receipts, nil
+}
+
+// receiptStock converts a received feed line into the feed store's unit and
+// the cost of one unit of stock.
+func receiptStock(rc goodsReceiptLine, stockUnit string, density float64) (qty, unitCost float64, ok bool) {
+	qty, ok = convertQuantity(rc.qty, rc.line.unit, stockUnit, density)
+	qty = roundCents(qty)
+	if !ok || qty <= 0 {
+		return 0, 0, false
+	}
+	return qty, roundCents(rc.qty * rc.line.price / qty), true
+}
+
+// handleReceivePurchaseOrder records a goods-received note against an order.
+// Without lines everything still outstanding is received; otherwise each line
+// names an order line and the quantity that arrived. Every received line books
+// an expense against the supplier, and feed lines go into the feed store.
+func (s *Server) handleReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid purchase order id"})
+		return
+	}
+	var in struct {
+		Date         string `json:"date"`
+		DeliveryNote string `json:"deliveryNote"`
+		Notes        string `json:"notes"`
+		Lines        []struct {
+			LineID        int64   `json:"lineId"`
+			QuantityValue float64 `json:"quantityValue"`
+		} `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	if strings.TrimSpace(in.Date) == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	d, err := time.Parse("2006-01-02", strings.TrimSpace(in.Date))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	requested := make(map[int64]float64, len(in.Lines))
+	for _, l := range in.Lines {
+		if l.LineID <= 0 || l.QuantityValue < 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "each line needs a lineId and a positive quantityValue"})
+			return
+		}
+		if _, dup := requested[l.LineID]; dup {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "each line can only be received once per note"})
+			return
+		}
+		requested[l.LineID] = roundCents(l.QuantityValue)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var number string
+	var total float64
+	id, err := s.auditedWrite(ctx, r, auditCreate, "goods_receipts", 0, func(tx pgx.Tx) (int64, error) {
+		var status, poNumber, supplierName string
+		var supplierID int64
+		var orderDate time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT o.status, o.po_number, o.order_date, o.supplier_id, s.name
+			FROM purchase_orders o
+			JOIN suppliers s ON s.id = o.supplier_id
+			WHERE o.id = $1 AND o.farm_id = $2
+			FOR UPDATE OF o
+		`, orderID, farmID).Scan(&status, &poNumber, &orderDate, &supplierID, &supplierName)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if status != purchaseOrderOpen && status != purchaseOrderPartial {
+			return 0, &httpError{http.StatusConflict, "this order is " + status + "; nothing more can be received"}
+		}
+		if d.Before(orderDate) {
+			return 0, &httpError{http.StatusBadRequest, "goods cannot be received before the order date"}
+		}
+
+		rows, err := tx.Query(ctx, purchaseOrderLinesQuery, orderID)
+		if err != nil {
+			return 0, err
+		}
+		lines, err := scanPurchaseOrderLines(rows)
+		if err != nil {
+			return 0, err
+		}
+		receipts, err := planGoodsReceipt(lines, requested)
+		if err != nil {
+			return 0, err
+		}
+		for _, rc := range receipts {
+			total += rc.amount
+		}
+		total = roundCents(total)
+
+		if number, err = nextDocumentNumber(ctx, tx, farmID, "GRN"); err != nil {
+			return 0, err
+		}
+		var receivedBy *int64
+		if userID > 0 {
+			receivedBy = &userID
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO goods_receipts(farm_id, purchase_order_id, grn_number, received_date, delivery_note, notes, total_amount, received_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`, farmID, orderID, number, d, strings.TrimSpace(in.DeliveryNote), strings.TrimSpace(in.Notes), total, receivedBy).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		for _, rc := range receipts {
+			var lineID int64
+			err := tx.QueryRow(ctx, `
+				INSERT INTO goods_receipt_lines(goods_receipt_id, purchase_order_line_id, quantity, amount)
+				VALUES ($1, $2, $3, $4)
+				RETURNING id
+			`, id, rc.line.id, rc.qty, rc.amount).Scan(&lineID)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE purchase_order_lines SET quantity_received = quantity_received + $1 WHERE id = $2`, rc.qty, rc.line.id); err != nil {
+				return 0, err
+			}
+			// Free goods still go into stock but book no expense.
+			if rc.amount > 0 {
+				_, err = tx.Exec(ctx, `
+					INSERT INTO expenses(expense_date, category, item, vendor, amount, farm_id, supplier_id, goods_receipt_line_id)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				`, d, rc.line.category, rc.line.item+" ("+poNumber+")", supplierName, rc.amount, farmID, supplierID, lineID)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if rc.line.feedItemID != nil {
+				// Stock is booked in the store's unit, whatever it was
+				// ordered in.
+				var stockUnit, nameKey string
+				var density float64
+				err := tx.QueryRow(ctx, `SELECT unit, name_key, COALESCE(density, 0) FROM feed_items WHERE id = $1`, *rc.line.feedItemID).Scan(&stockUnit, &nameKey, &density)
+				if err != nil {
+					return 0, err
+				}
+				stockQty, unitCost, ok := receiptStock(rc, stockUnit, feedDensity(nameKey, density))
+				if !ok {
+					return 0, &httpError{http.StatusConflict, fmt.Sprintf("line %d: %s does not convert to %s", rc.line.lineNo, rc.line.unit, stockUnit)}
+				}
+				_, err = tx.Exec(ctx, `
+					INSERT INTO feed_stock_movements(farm_id, feed_item_id, movement_date, kind, quantity, unit_cost, goods_receipt_line_id, notes)
+					VALUES ($1, $2, $3, 'receipt', $4, $5, $6, $7)
+				`, farmID, *rc.line.feedItemID, d, stockQty, unitCost, lineID, number)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		return id, refreshPurchaseOrderStatus(ctx, tx, orderID)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to receive goods")
+		return
+	}
+	if id == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "purchase order not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "grnNumber": number, "totalAmount": total})
+}
+
+// handleDeleteGoodsReceipt reverses a goods-received note: its expenses and
+// feed store entries go with it and the quantities become outstanding again.
+func (s *Server) handleDeleteGoodsReceipt(w http.ResponseWriter, r *http.Request) {
+	receiptID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid goods received note id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "goods_receipts", receiptID, func(tx pgx.Tx) (int64, error) {
+		var orderID int64
+		err := tx.QueryRow(ctx, `SELECT purchase_order_id FROM goods_receipts WHERE id = $1 AND farm_id = $2`, receiptID, farmID).Scan(&orderID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if _, _, err := lockPurchaseOrder(ctx, tx, farmID, orderID); err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE purchase_order_lines l
+			SET quantity_received = l.quantity_received - g.quantity
+			FROM goods_receipt_lines g
+			WHERE g.purchase_order_line_id = l.id AND g.goods_receipt_id = $1
+		`, receiptID)
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM goods_receipts WHERE id = $1 AND farm_id = $2`, receiptID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return receiptID, refreshPurchaseOrderStatus(ctx, tx, orderID)
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to reverse goods received note")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "goods received note not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// lockExpenseForChange refuses changes to an expense booked by a goods-received
+// note; the note has to be reversed instead so the order stays in step.
+func lockExpenseForChange(ctx context.Context, tx pgx.Tx, farmID, expenseID int64) (bool, error) {
+	var grnNumber string
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(g.grn_number, '')
+		FROM expenses e
+		LEFT JOIN goods_receipt_lines gl ON gl.id = e.goods_receipt_line_id
+		LEFT JOIN goods_receipts g ON g.id = gl.goods_receipt_id
+		WHERE e.id = $1 AND e.farm_id = $2
+		FOR UPDATE OF e
+	`, expenseID, farmID).Scan(&grnNumber)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if grnNumber != "" {
+		return true, &httpError{http.StatusConflict, "this expense was booked by " + grnNumber + "; reverse the goods received note instead"}
+	}
+	return true, nil
+}
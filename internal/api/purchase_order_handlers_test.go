@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestNormalizePurchaseOrderInput(t *testing.T) {
+	line := purchaseOrderLineInput{Item: "Dairy meal", IsFeed: true, QuantityValue: 3, QuantityUnit: "bags", UnitPrice: 2850.004}
+	tests := []struct {
+		name string
+		in   purchaseOrderInput
+		msg  string
+	}{
+		{"valid", purchaseOrderInput{Supplier: "Unga Feeds", OrderDate: "2026-03-01", Lines: []purchaseOrderLineInput{line}}, ""},
+		{"bad date", purchaseOrderInput{Supplier: "Unga Feeds", OrderDate: "01/03/2026", Lines: []purchaseOrderLineInput{line}}, "orderDate must be YYYY-MM-DD"},
+		{"expected before ordered", purchaseOrderInput{Supplier: "Unga Feeds", OrderDate: "2026-03-01", ExpectedDate: "2026-02-28", Lines: []purchaseOrderLineInput{line}}, "expectedDate cannot be before orderDate"},
+		{"no supplier", purchaseOrderInput{OrderDate: "2026-03-01", Lines: []purchaseOrderLineInput{line}}, "supplierId or supplier is required"},
+		{"no lines", purchaseOrderInput{Supplier: "Unga Feeds", OrderDate: "2026-03-01"}, "an order needs at least one line"},
+		{"unknown unit", purchaseOrderInput{Supplier: "Unga Feeds", OrderDate: "2026-03-01", Lines: []purchaseOrderLineInput{{Item: "Salt", Category: "Feed", QuantityValue: 1, QuantityUnit: "sacks"}}}, "line 1: unit must be one of " + unitCodes()},
+		{"no category", purchaseOrderInput{Supplier: "Unga Feeds", OrderDate: "2026-03-01", Lines: []purchaseOrderLineInput{{Item: "Gloves", QuantityValue: 1, QuantityUnit: "pcs"}}}, "line 1: item, category, quantity and a non-negative unit price are required"},
+	}
+	for _, tt := range tests {
+		h, lines, msg := normalizePurchaseOrderInput(tt.in)
+		if msg != tt.msg {
+			t.Errorf("%s: message %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if msg != "" {
+			continue
+		}
+		if !h.orderDate.Equal(testDate("2026-03-01")) || len(lines) != 1 {
+			t.Fatalf("%s: got %+v, %+v", tt.name, h, lines)
+		}
+		// Feed lines default to the Feed category and prices round to cents.
+		l := lines[0]
+		if l.category != "Feed" || !l.isFeed || l.unit != "bag-50kg" || l.price != 2850 || l.total != 8550 {
+			t.Errorf("%s: line %+v", tt.name, l)
+		}
+	}
+}
+
+// testOrderLines is an order of 10 bags of dairy meal, 4 of them already
+// received, and 3 rolls of twine at a price that does not split evenly.
+var testOrderLines = []purchaseOrderLine{
+	{id: 11, lineNo: 1, item: "Dairy meal", category: "Feed", quantity: 10, received: 4, unit: "bag-50kg", price: 2850, total: 28500, receivedAmount: 11400},
+	{id: 12, lineNo: 2, item: "Baling twine", category: "Supplies", quantity: 3, unit: "pcs", price: 333.33, total: 1000, receivedAmount: 0},
+}
+
+func TestPlanGoodsReceipt(t *testing.T) {
+	type booked struct {
+		lineID int64
+		qty    float64
+		amount float64
+	}
+	tests := []struct {
+		name      string
+		lines     []purchaseOrderLine
+		requested map[int64]float64
+		want      []booked
+		status    int
+	}{
+		{
+			name:  "everything outstanding",
+			lines: testOrderLines,
+			want:  []booked{{11, 6, 17100}, {12, 3, 1000}},
+		},
+		{
+			name:      "part of a line",
+			lines:     testOrderLines,
+			requested: map[int64]float64{12: 1},
+			want:      []booked{{12, 1, 333.33}},
+		},
+		{
+			// The last delivery clears the line's total, not quantity
+			// times price, so the order never ends a few cents short.
+			name:      "rest of a line",
+			lines:     []purchaseOrderLine{{id: 12, lineNo: 2, quantity: 3, received: 2, unit: "pcs", price: 333.33, total: 1000, receivedAmount: 666.66}},
+			requested: map[int64]float64{12: 0},
+			want:      []booked{{12, 1, 333.34}},
+		},
+		{
+			name:      "more than outstanding",
+			lines:     testOrderLines,
+			requested: map[int64]float64{11: 7},
+			status:    409,
+		},
+		{
+			name:      "line not on the order",
+			lines:     testOrderLines,
+			requested: map[int64]float64{11: 1, 99: 1},
+			status:    400,
+		},
+		{
+			name:      "line already received",
+			lines:     []purchaseOrderLine{{id: 11, lineNo: 1, quantity: 10, received: 10, unit: "kg"}},
+			requested: map[int64]float64{11: 0},
+			status:    409,
+		},
+		{
+			name:   "order fully received",
+			lines:  []purchaseOrderLine{{id: 11, lineNo: 1, quantity: 10, received: 10, unit: "kg"}},
+			status: 409,
+		},
+	}
+	for _, tt := range tests {
+		got, err := planGoodsReceipt(tt.lines, tt.requested)
+		if tt.status != 0 {
+			var he *httpError
+			if !errors.As(err, &he) || he.status != tt.status {
+				t.Errorf("%s: error %v, want status %d", tt.name, err, tt.status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d lines, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			if got[i].line.id != w.lineID || got[i].qty != w.qty || math.Abs(got[i].amount-w.amount) > 0.001 {
+				t.Errorf("%s: line %d = %d %v %v, want %+v", tt.name, i, got[i].line.id, got[i].qty, got[i].amount, w)
+			}
+		}
+	}
+}
+
+func TestReceiptStock(t *testing.T) {
+	tests := []struct {
+		name      string
+		rc        goodsReceiptLine
+		stockUnit string
+		density   float64
+		qty       float64
+		unitCost  float64
+		ok        bool
+	}{
+		{"bags into kg", goodsReceiptLine{line: purchaseOrderLine{unit: "bag-50kg", price: 2850}, qty: 2}, "kg", 0, 100, 57, true},
+		{"same unit", goodsReceiptLine{line: purchaseOrderLine{unit: "kg", price: 40}, qty: 25}, "kg", 0, 25, 40, true},
+		{"litres by density", goodsReceiptLine{line: purchaseOrderLine{unit: "L", price: 120}, qty: 20}, "kg", 1.4, 28, 85.71, true},
+		{"litres without density", goodsReceiptLine{line: purchaseOrderLine{unit: "L", price: 120}, qty: 20}, "kg", 0, 0, 0, false},
+		{"count into mass", goodsReceiptLine{line: purchaseOrderLine{unit: "pcs", price: 10}, qty: 5}, "kg", 0, 0, 0, false},
+		{"rounds to nothing", goodsReceiptLine{line: purchaseOrderLine{unit: "mg", price: 1}, qty: 1}, "kg", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		qty, unitCost, ok := receiptStock(tt.rc, tt.stockUnit, tt.density)
+		if ok != tt.ok || math.Abs(qty-tt.qty) > 0.001 || math.Abs(unitCost-tt.unitCost) > 0.001 {
+			t.Errorf("%s: got %v at %v (%v), want %v at %v (%v)", tt.name, qty, unitCost, ok, tt.qty, tt.unitCost, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type quarantineGroupInput struct {
+	Name       string `json:"name"`
+	Reason     string `json:"reason"`
+	LocationID *int64 `json:"locationId"`
+	StartedOn  string `json:"startedOn"`
+	EndedOn    string `json:"endedOn"`
+	Notes      string `json:"notes"`
+}
+
+type quarantineGroup struct {
+	quarantineGroupInput
+	startedOn time.Time
+	endedOn   *time.Time
+}
+
+func normalizeQuarantineGroupInput(in quarantineGroupInput, today time.Time) (quarantineGroup, string) {
+	g := quarantineGroup{quarantineGroupInput: in}
+	g.Name = strings.Join(strings.Fields(in.Name), " ")
+	g.Reason = strings.TrimSpace(in.Reason)
+	g.Notes = strings.TrimSpace(in.Notes)
+	if g.Name == "" {
+		return g, "name is required"
+	}
+	g.startedOn = today
+	if strings.TrimSpace(in.StartedOn) != "" {
+		d, err := time.Parse("2006-01-02", strings.TrimSpace(in.StartedOn))
+		if err != nil {
+			return g, "startedOn must be YYYY-MM-DD"
+		}
+		g.startedOn = d
+	}
+	var err error
+	if g.endedOn, err = optionalDate(in.EndedOn); err != nil {
+		return g, "endedOn must be YYYY-MM-DD"
+	}
+	if g.endedOn != nil && (g.endedOn.Before(g.startedOn) || g.endedOn.After(today)) {
+		return g, "endedOn must be between the start and today"
+	}
+	return g, ""
+}
+
+func checkQuarantineLocation(ctx context.Context, tx pgx.Tx, farmID int64, locationID *int64) error {
+	if locationID == nil {
+		return nil
+	}
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM animal_locations WHERE id = $1 AND farm_id = $2)`, *locationID, farmID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return &httpError{http.StatusBadRequest, "location not found"}
+	}
+	return nil
+}
+
+// releaseQuarantine lets the animals still in group out on day, or only
+// animalID when it is set, and reports the animals released.
+func releaseQuarantine(ctx context.Context, tx pgx.Tx, groupID, animalID int64, day time.Time) ([]int64, error) {
+	rows, err := tx.Query(ctx, `
+		UPDATE quarantine_animals
+		SET released_on = GREATEST($3::date, entered_on)
+		WHERE group_id = $1 AND ($2::bigint = 0 OR animal_id = $2) AND released_on IS NULL
+		RETURNING animal_id
+	`, groupID, animalID, day)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := syncHealthStatus(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// handleQuarantineGroups lists quarantine groups with the animals in them,
+// open groups first. status is active or ended.
+func (s *Server) handleQuarantineGroups(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	if status != "" && status != "active" && status != "ended" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be active or ended"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT g.id, g.name, g.reason, g.location_id, COALESCE(l.name, ''), g.started_on, g.ended_on, g.notes
+		FROM quarantine_groups g
+		LEFT JOIN animal_locations l ON l.id = g.location_id
+		WHERE g.farm_id = $1 AND ($2 = '' OR ($2 = 'active') = (g.ended_on IS NULL))
+		ORDER BY g.ended_on IS NOT NULL, g.started_on DESC, g.id DESC
+	`, farmID, status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load quarantine groups"})
+		return
+	}
+	groups := make([]map[string]any, 0)
+	byID := map[int64]map[string]any{}
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		var locationID *int64
+		var name, reason, location, notes string
+		var startedOn time.Time
+		var endedOn *time.Time
+		if err := rows.Scan(&id, &name, &reason, &locationID, &location, &startedOn, &endedOn, &notes); err != nil {
+			rows.Close()
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse quarantine groups"})
+			return
+		}
+		var ended any
+		if endedOn != nil {
+			ended = s.formatISODate(*endedOn)
+		}
+		g := map[string]any{
+			"id":         id,
+			"name":       name,
+			"reason":     reason,
+			"locationId": locationID,
+			"location":   location,
+			"startedOn":  s.formatISODate(startedOn),
+			"endedOn":    ended,
+			"active":     endedOn == nil,
+			"notes":      notes,
+			"animals":    make([]map[string]any, 0),
+		}
+		groups = append(groups, g)
+		byID[id] = g
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse quarantine groups"})
+		return
+	}
+
+	// Ended groups show who was in them; open ones only who still is.
+	rows, err = s.db.Query(ctx, `
+		SELECT q.group_id, a.tag_id, a.type, q.case_id, q.entered_on, q.released_on
+		FROM quarantine_animals q
+		JOIN quarantine_groups g ON g.id = q.group_id
+		JOIN animals a ON a.id = q.animal_id
+		WHERE q.group_id = ANY($1) AND (g.ended_on IS NOT NULL OR q.released_on IS NULL)
+		ORDER BY a.tag_id
+	`, ids)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load quarantined animals"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var groupID int64
+		var caseID *int64
+		var tag, animalType string
+		var enteredOn time.Time
+		var releasedOn *time.Time
+		if err := rows.Scan(&groupID, &tag, &animalType, &caseID, &enteredOn, &releasedOn); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse quarantined animals"})
+			return
+		}
+		var released any
+		if releasedOn != nil {
+			released = s.formatISODate(*releasedOn)
+		}
+		g := byID[groupID]
+		g["animals"] = append(g["animals"].([]map[string]any), map[string]any{
+			"animalTagId": tag,
+			"animalType":  animalType,
+			"caseId":      caseID,
+			"enteredOn":   s.formatISODate(enteredOn),
+			"releasedOn":  released,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"items": groups, "total": len(groups)})
+}
+
+func (s *Server) handleCreateQuarantineGroup(w http.ResponseWriter, r *http.Request) {
+	var in quarantineGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	g, msg := normalizeQuarantineGroupInput(in, s.today())
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+	if g.endedOn != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "a new quarantine group cannot be ended already"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "quarantine_groups", 0, func(tx pgx.Tx) (int64, error) {
+		if err := checkQuarantineLocation(ctx, tx, farmID, g.LocationID); err != nil {
+			return 0, err
+		}
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO quarantine_groups(farm_id, name, reason, location_id, started_on, notes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, farmID, g.Name, g.Reason, g.LocationID, g.startedOn, g.Notes).Scan(&id)
+		if err != nil && strings.Contains(err.Error(), "duplicate key") {
+			return 0, &httpError{http.StatusConflict, "a quarantine group with this name already exists"}
+		}
+		return id, err
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to create quarantine group")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+// handleUpdateQuarantineGroup edits a group. Setting endedOn closes it and
+// releases every animal still in it on that day.
+func (s *Server) handleUpdateQuarantineGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid quarantine group id"})
+		return
+	}
+	var in quarantineGroupInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	g, msg := normalizeQuarantineGroupInput(in, s.today())
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var released int
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "quarantine_groups", groupID, func(tx pgx.Tx) (int64, error) {
+		var endedOn *time.Time
+		var firstEntry *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT ended_on, (SELECT MIN(entered_on) FROM quarantine_animals WHERE group_id = g.id)
+			FROM quarantine_groups g
+			WHERE id = $1 AND farm_id = $2
+			FOR UPDATE
+		`, groupID, farmID).Scan(&endedOn, &firstEntry)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if endedOn != nil && g.endedOn == nil {
+			return 0, &httpError{http.StatusConflict, "an ended quarantine cannot be reopened; start a new group instead"}
+		}
+		if firstEntry != nil && firstEntry.Before(g.startedOn) {
+			return 0, &httpError{http.StatusBadRequest, "animals entered this quarantine on " + s.formatISODate(*firstEntry) + ", before startedOn"}
+		}
+		if err := checkQuarantineLocation(ctx, tx, farmID, g.LocationID); err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE quarantine_groups
+			SET name = $1, reason = $2, location_id = $3, started_on = $4, ended_on = $5, notes = $6
+			WHERE id = $7
+		`, g.Name, g.Reason, g.LocationID, g.startedOn, g.endedOn, g.Notes, groupID)
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") {
+				return 0, &httpError{http.StatusConflict, "a quarantine group with this name already exists"}
+			}
+			return 0, err
+		}
+		if endedOn == nil && g.endedOn != nil {
+			ids, err := releaseQuarantine(ctx, tx, groupID, 0, *g.endedOn)
+			if err != nil {
+				return 0, err
+			}
+			released = len(ids)
+		}
+		return groupID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to update quarantine group")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "quarantine group not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "released": released})
+}
+
+func (s *Server) handleDeleteQuarantineGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid quarantine group id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "quarantine_groups", groupID, func(tx pgx.Tx) (int64, error) {
+		rows, err := tx.Query(ctx, `
+			SELECT q.animal_id FROM quarantine_animals q
+			JOIN quarantine_groups g ON g.id = q.group_id
+			WHERE g.id = $1 AND g.farm_id = $2 AND q.released_on IS NULL
+		`, groupID, farmID)
+		if err != nil {
+			return 0, err
+		}
+		inside, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM quarantine_groups WHERE id = $1 AND farm_id = $2`, groupID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		for _, id := range inside {
+			if err := syncHealthStatus(ctx, tx, id); err != nil {
+				return 0, err
+			}
+		}
+		return groupID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete quarantine group"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "quarantine group not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleQuarantineAnimals puts animals into a group. caseId ties them to the
+// case they are isolated for, which must be one of theirs; contacts of a
+// case go in without one.
+func (s *Server) handleQuarantineAnimals(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid quarantine group id"})
+		return
+	}
+	var in struct {
+		AnimalTagIDs []string `json:"animalTagIds"`
+		Date         string   `json:"date"`
+		CaseID       *int64   `json:"caseId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	tags := make([]string, 0, len(in.AnimalTagIDs))
+	for _, raw := range in.AnimalTagIDs {
+		tag, ok := normalizeAnimalTag(raw)
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid tag ID %q", raw)})
+			return
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagIds is required"})
+		return
+	}
+	if in.CaseID != nil && len(tags) > 1 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "a caseId goes with a single animal"})
+		return
+	}
+	today := s.today()
+	d := today
+	if strings.TrimSpace(in.Date) != "" {
+		parsed, err := time.Parse("2006-01-02", strings.TrimSpace(in.Date))
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		d = parsed
+	}
+	if d.After(today) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date cannot be in the future"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "quarantine_groups", groupID, func(tx pgx.Tx) (int64, error) {
+		var startedOn time.Time
+		var endedOn *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT started_on, ended_on FROM quarantine_groups WHERE id = $1 AND farm_id = $2 FOR UPDATE
+		`, groupID, farmID).Scan(&startedOn, &endedOn)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if endedOn != nil {
+			return 0, &httpError{http.StatusConflict, "this quarantine has ended"}
+		}
+		if d.Before(startedOn) {
+			return 0, &httpError{http.StatusBadRequest, "date is before the quarantine started on " + s.formatISODate(startedOn)}
+		}
+		for _, tag := range tags {
+			var animalID int64
+			var current string
+			err := tx.QueryRow(ctx, `
+				SELECT a.id, COALESCE((
+					SELECT g.name FROM quarantine_animals q JOIN quarantine_groups g ON g.id = q.group_id
+					WHERE q.animal_id = a.id AND q.released_on IS NULL
+				), '')
+				FROM animals a
+				WHERE a.tag_id = $1 AND a.farm_id = $2 AND a.is_active = true
+			`, tag, farmID).Scan(&animalID, &current)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, &httpError{http.StatusBadRequest, fmt.Sprintf("animal %s not found", tag)}
+			}
+			if err != nil {
+				return 0, err
+			}
+			if current != "" {
+				return 0, &httpError{http.StatusConflict, fmt.Sprintf("%s is already in quarantine (%s)", tag, current)}
+			}
+			if err := checkRecordCase(ctx, tx, farmID, animalID, in.CaseID); err != nil {
+				return 0, err
+			}
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO quarantine_animals(group_id, animal_id, case_id, entered_on)
+				VALUES ($1, $2, $3, $4)
+			`, groupID, animalID, in.CaseID, d); err != nil {
+				return 0, err
+			}
+			if err := syncHealthStatus(ctx, tx, animalID); err != nil {
+				return 0, err
+			}
+		}
+		return groupID, nil
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to quarantine animals")
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "quarantine group not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "quarantined": len(tags)})
+}
+
+// handleReleaseQuarantineAnimal lets one animal out of a group, today unless
+// ?date= says otherwise.
+func (s *Server) handleReleaseQuarantineAnimal(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid quarantine group id"})
+		return
+	}
+	tagID, ok := normalizeAnimalTag(r.PathValue("tagId"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tag ID"})
+		return
+	}
+	today := s.today()
+	d := today
+	if v := strings.TrimSpace(r.URL.Query().Get("date")); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		d = parsed
+	}
+	if d.After(today) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date cannot be in the future"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "quarantine_groups", groupID, func(tx pgx.Tx) (int64, error) {
+		var animalID int64
+		err := tx.QueryRow(ctx, `
+			SELECT a.id
+			FROM quarantine_animals q
+			JOIN quarantine_groups g ON g.id = q.group_id
+			JOIN animals a ON a.id = q.animal_id
+			WHERE g.id = $1 AND g.farm_id = $2 AND a.tag_id = $3 AND q.released_on IS NULL
+		`, groupID, farmID, tagID).Scan(&animalID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := releaseQuarantine(ctx, tx, groupID, animalID, d); err != nil {
+			return 0, err
+		}
+		return groupID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to release animal"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal is not in this quarantine"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
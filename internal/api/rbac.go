@@ -11,7 +11,8 @@ import (
 
 const publicRegistrationRole = "worker"
 
-func (s *Server) resolveRole(ctx context.Context, role string) (int64, string, error) {
+// resolveRole finds a role by name among the system roles and farmID's own.
+func (s *Server) resolveRole(ctx context.Context, farmID int64, role string) (int64, string, error) {
 	roleName := strings.ToLower(strings.TrimSpace(role))
 	if roleName == "" {
 		return 0, "", errors.New("missing role")
@@ -19,7 +20,9 @@ func (s *Server) resolveRole(ctx context.Context, role string) (int64, string, e
 
 	var roleID int64
 	var canonical string
-	err := s.db.QueryRow(ctx, `SELECT id, name FROM roles WHERE name = $1`, roleName).Scan(&roleID, &canonical)
+	err := s.db.QueryRow(ctx, `
+		SELECT id, name FROM roles WHERE name = $1 AND (farm_id IS NULL OR farm_id = $2)
+	`, roleName, farmID).Scan(&roleID, &canonical)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, "", errors.New("invalid role")
@@ -29,37 +32,66 @@ func (s *Server) resolveRole(ctx context.Context, role string) (int64, string, e
 	return roleID, canonical, nil
 }
 
-func (s *Server) loadAuthContext(ctx context.Context, userID int64) (string, map[string]struct{}, error) {
+// loadAuthContext resolves the caller's role and permissions on a farm. When
+// farmID is zero or the user is no longer a member there, the lowest-numbered
+// farm they belong to is used instead. Users without any membership get an
+// empty permission set and farm 0.
+func (s *Server) loadAuthContext(ctx context.Context, userID int64, farmID int64) (int64, string, map[string]struct{}, error) {
 	if userID <= 0 {
-		return "", nil, errors.New("invalid user")
+		return 0, "", nil, errors.New("invalid user")
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 4*time.Second)
 	defer cancel()
 
-	var roleID int64
-	var role, status string
+	var status string
 	err := s.db.QueryRow(ctx, `
-		SELECT u.role_id, r.name, u.status
-		FROM users u
-		JOIN roles r ON r.id = u.role_id
-		WHERE u.id = $1 AND u.email_verified = true
-	`, userID).Scan(&roleID, &role, &status)
+		SELECT status
+		FROM users
+		WHERE id = $1 AND email_verified = true
+	`, userID).Scan(&status)
 	if err != nil {
-		return "", nil, err
+		return 0, "", nil, err
 	}
 	if strings.ToLower(strings.TrimSpace(status)) != "active" {
-		return "", nil, errors.New("inactive user")
+		return 0, "", nil, errors.New("inactive user")
 	}
 
 	rows, err := s.db.Query(ctx, `
+		SELECT fm.farm_id, fm.role_id, r.name
+		FROM farm_members fm
+		JOIN roles r ON r.id = fm.role_id
+		WHERE fm.user_id = $1 AND fm.status = 'active'
+	`, userID)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	var memberships []farmMembership
+	for rows.Next() {
+		var m farmMembership
+		if err := rows.Scan(&m.farmID, &m.roleID, &m.role); err != nil {
+			rows.Close()
+			return 0, "", nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", nil, err
+	}
+	member, ok := pickFarmMembership(memberships, farmID)
+	if !ok {
+		return 0, "", map[string]struct{}{}, nil
+	}
+
+	rows, err = s.db.Query(ctx, `
 		SELECT p.key
 		FROM role_permissions rp
 		JOIN permissions p ON p.id = rp.permission_id
 		WHERE rp.role_id = $1
-	`, roleID)
+	`, member.roleID)
 	if err != nil {
-		return "", nil, err
+		return 0, "", nil, err
 	}
 	defer rows.Close()
 
@@ -67,13 +99,41 @@ func (s *Server) loadAuthContext(ctx context.Context, userID int64) (string, map
 	for rows.Next() {
 		var key string
 		if err := rows.Scan(&key); err != nil {
-			return "", nil, err
+			return 0, "", nil, err
 		}
 		perms[strings.TrimSpace(key)] = struct{}{}
 	}
 	if err := rows.Err(); err != nil {
-		return "", nil, err
+		return 0, "", nil, err
+	}
+
+	return member.farmID, strings.ToLower(strings.TrimSpace(member.role)), perms, nil
+}
+
+// farmMembership is a user's active role on one farm.
+type farmMembership struct {
+	farmID int64
+	roleID int64
+	role   string
+}
+
+// pickFarmMembership returns the membership on farmID, or the one on the
+// lowest-numbered farm when there is none. ok is false without memberships.
+func pickFarmMembership(memberships []farmMembership, farmID int64) (farmMembership, bool) {
+	var picked farmMembership
+	found := false
+	for _, m := range memberships {
+		if m.farmID == farmID {
+			return m, true
+		}
+		if !found || m.farmID < picked.farmID {
+			picked, found = m, true
+		}
 	}
+	return picked, found
+}
 
-	return strings.ToLower(strings.TrimSpace(role)), perms, nil
+func farmIDFrom(ctx context.Context) int64 {
+	farmID, _ := ctx.Value(farmIDContextKey).(int64)
+	return farmID
 }
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPickFarmMembership(t *testing.T) {
+	memberships := []farmMembership{
+		{farmID: 5, roleID: 2, role: "manager"},
+		{farmID: 3, roleID: 9, role: "milkers"},
+		{farmID: 8, roleID: 1, role: "owner"},
+	}
+	tests := []struct {
+		name    string
+		members []farmMembership
+		farmID  int64
+		want    farmMembership
+		ok      bool
+	}{
+		{"session farm", memberships, 8, memberships[2], true},
+		{"another farm's role is not used", memberships, 5, memberships[0], true},
+		{"no farm chosen takes the lowest", memberships, 0, memberships[1], true},
+		{"left the session farm", memberships, 4, memberships[1], true},
+		{"no memberships", nil, 5, farmMembership{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := pickFarmMembership(tt.members, tt.farmID)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %+v, %v; want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPermissionRequired(t *testing.T) {
+	s := &Server{}
+	tests := []struct {
+		name   string
+		perms  map[string]struct{}
+		status int
+	}{
+		{"holds it", map[string]struct{}{"animals.write": {}}, http.StatusOK},
+		{"lacks it", map[string]struct{}{"animals.read": {}}, http.StatusForbidden},
+		{"no auth context", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		var granted string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted, _ = r.Context().Value(grantedPermissionContextKey).(string)
+			w.WriteHeader(http.StatusOK)
+		})
+		r := httptest.NewRequest(http.MethodPost, "/api/animals", nil)
+		if tt.perms != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userPermissionsContextKey, tt.perms))
+		}
+		w := httptest.NewRecorder()
+		s.permissionRequired(next, "animals.write").ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		// The audit log records the permission a write was allowed under.
+		if tt.status == http.StatusOK && granted != "animals.write" {
+			t.Errorf("%s: granted %q, want animals.write", tt.name, granted)
+		}
+	}
+}
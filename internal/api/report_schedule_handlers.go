@@ -0,0 +1,581 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	reportDaily   = "daily"
+	reportWeekly  = "weekly"
+	reportMonthly = "monthly"
+)
+
+type reportSchedule struct {
+	id         int64
+	farmID     int64
+	title      string
+	category   string
+	dateRange  string
+	format     string
+	frequency  string
+	hour       int
+	weekday    int
+	monthDay   int
+	recipients []string
+	isActive   bool
+	nextRunAt  time.Time
+	lastRunAt  *time.Time
+}
+
+const reportScheduleColumns = `id, farm_id, title, category, date_range, format, frequency, hour, weekday, month_day, recipients, is_active, next_run_at, last_run_at`
+
+func scanReportSchedule(row pgx.Row) (reportSchedule, error) {
+	var sc reportSchedule
+	err := row.Scan(&sc.id, &sc.farmID, &sc.title, &sc.category, &sc.dateRange, &sc.format, &sc.frequency,
+		&sc.hour, &sc.weekday, &sc.monthDay, &sc.recipients, &sc.isActive, &sc.nextRunAt, &sc.lastRunAt)
+	return sc, err
+}
+
+func (s *Server) reportLocation() *time.Location {
+	if s.location == nil {
+		return time.UTC
+	}
+	return s.location
+}
+
+// nextReportRun is the first time after after that sc is due, in UTC.
+func nextReportRun(sc reportSchedule, after time.Time, loc *time.Location) time.Time {
+	t := after.In(loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), sc.hour, 0, 0, 0, loc)
+	switch sc.frequency {
+	case reportWeekly:
+		next = next.AddDate(0, 0, (sc.weekday-int(next.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case reportMonthly:
+		next = time.Date(t.Year(), t.Month(), sc.monthDay, sc.hour, 0, 0, 0, loc)
+		if !next.After(t) {
+			next = time.Date(t.Year(), t.Month()+1, sc.monthDay, sc.hour, 0, 0, 0, loc)
+		}
+	default:
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next.UTC()
+}
+
+func (s *Server) reportScheduleJSON(sc reportSchedule) map[string]any {
+	loc := s.reportLocation()
+	var lastRun any
+	if sc.lastRunAt != nil {
+		lastRun = sc.lastRunAt.In(loc).Format(time.RFC3339)
+	}
+	return map[string]any{
+		"id":         sc.id,
+		"title":      sc.title,
+		"category":   sc.category,
+		"dateRange":  sc.dateRange,
+		"format":     sc.format,
+		"frequency":  sc.frequency,
+		"hour":       sc.hour,
+		"weekday":    sc.weekday,
+		"monthDay":   sc.monthDay,
+		"recipients": sc.recipients,
+		"isActive":   sc.isActive,
+		"nextRunAt":  sc.nextRunAt.In(loc).Format(time.RFC3339),
+		"lastRunAt":  lastRun,
+	}
+}
+
+type reportScheduleInput struct {
+	Title      string   `json:"title"`
+	Category   string   `json:"category"`
+	DateRange  string   `json:"dateRange"`
+	Format     string   `json:"format"`
+	Frequency  string   `json:"frequency"`
+	Hour       *int     `json:"hour"`
+	Weekday    *int     `json:"weekday"`
+	MonthDay   *int     `json:"monthDay"`
+	Recipients []string `json:"recipients"`
+	IsActive   *bool    `json:"isActive"`
+}
+
+func normalizeReportScheduleInput(in reportScheduleInput) (reportSchedule, string) {
+	sc := reportSchedule{
+		category:  normalizeReportType(in.Category),
+		dateRange: normalizeDateRange(in.DateRange),
+		format:    strings.ToUpper(strings.TrimSpace(in.Format)),
+		frequency: strings.ToLower(strings.TrimSpace(in.Frequency)),
+		hour:      6,
+		weekday:   1,
+		monthDay:  1,
+		isActive:  true,
+	}
+	if sc.format == "" {
+		sc.format = "PDF"
+	}
+	if sc.format != "PDF" && sc.format != "CSV" {
+		return sc, "format must be PDF or CSV"
+	}
+	if sc.frequency != reportDaily && sc.frequency != reportWeekly && sc.frequency != reportMonthly {
+		return sc, "frequency must be daily, weekly or monthly"
+	}
+	if in.Hour != nil {
+		sc.hour = *in.Hour
+	}
+	if in.Weekday != nil {
+		sc.weekday = *in.Weekday
+	}
+	if in.MonthDay != nil {
+		sc.monthDay = *in.MonthDay
+	}
+	if sc.hour < 0 || sc.hour > 23 {
+		return sc, "hour must be between 0 and 23"
+	}
+	if sc.weekday < 0 || sc.weekday > 6 {
+		return sc, "weekday must be between 0 (Sunday) and 6"
+	}
+	if sc.monthDay < 1 || sc.monthDay > 28 {
+		return sc, "monthDay must be between 1 and 28"
+	}
+	if in.IsActive != nil {
+		sc.isActive = *in.IsActive
+	}
+
+	seen := map[string]bool{}
+	for _, raw := range in.Recipients {
+		email := strings.ToLower(strings.TrimSpace(raw))
+		if email == "" || seen[email] {
+			continue
+		}
+		if !emailRe.MatchString(email) {
+			return sc, fmt.Sprintf("%q is not a valid email address", raw)
+		}
+		seen[email] = true
+		sc.recipients = append(sc.recipients, email)
+	}
+	if len(sc.recipients) == 0 {
+		return sc, "at least one recipient is required"
+	}
+
+	sc.title = strings.Join(strings.Fields(in.Title), " ")
+	if sc.title == "" {
+		sc.title = fmt.Sprintf("%s %s Report", strings.ToUpper(sc.frequency[:1])+sc.frequency[1:], sc.category)
+	}
+	return sc, ""
+}
+
+func (s *Server) handleReportSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+reportScheduleColumns+`
+		FROM report_schedules
+		WHERE farm_id = $1
+		ORDER BY title, id
+	`, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load report schedules"})
+		return
+	}
+	defer rows.Close()
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		sc, err := scanReportSchedule(rows)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse report schedules"})
+			return
+		}
+		out = append(out, s.reportScheduleJSON(sc))
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+func (s *Server) handleCreateReportSchedule(w http.ResponseWriter, r *http.Request) {
+	var in reportScheduleInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	sc, msg := normalizeReportScheduleInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+	next := nextReportRun(sc, time.Now(), s.reportLocation())
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "report_schedules", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO report_schedules(farm_id, title, category, date_range, format, frequency, hour, weekday, month_day, recipients, is_active, next_run_at, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, 0))
+			RETURNING id
+		`, farmID, sc.title, sc.category, sc.dateRange, sc.format, sc.frequency, sc.hour, sc.weekday, sc.monthDay, sc.recipients, sc.isActive, next, userID).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create report schedule"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id, "nextRunAt": next.In(s.reportLocation()).Format(time.RFC3339)})
+}
+
+func (s *Server) handleUpdateReportSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+		return
+	}
+	var in reportScheduleInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	sc, msg := normalizeReportScheduleInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+	// The timetable may have changed, so the next run is worked out afresh.
+	next := nextReportRun(sc, time.Now(), s.reportLocation())
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "report_schedules", scheduleID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE report_schedules
+			SET title = $1, category = $2, date_range = $3, format = $4, frequency = $5, hour = $6,
+				weekday = $7, month_day = $8, recipients = $9, is_active = $10, next_run_at = $11
+			WHERE id = $12 AND farm_id = $13
+		`, sc.title, sc.category, sc.dateRange, sc.format, sc.frequency, sc.hour, sc.weekday, sc.monthDay, sc.recipients, sc.isActive, next, scheduleID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return scheduleID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update report schedule"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "report schedule not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "nextRunAt": next.In(s.reportLocation()).Format(time.RFC3339)})
+}
+
+func (s *Server) handleDeleteReportSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "report_schedules", scheduleID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM report_schedules WHERE id = $1 AND farm_id = $2`, scheduleID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return scheduleID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete report schedule"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "report schedule not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleReportScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM report_runs WHERE schedule_id = $1 AND farm_id = $2`, scheduleID, farmID).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, COALESCE(report_id, 0), status, recipients, error, started_at, finished_at
+		FROM report_runs
+		WHERE schedule_id = $1 AND farm_id = $2
+		ORDER BY started_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, scheduleID, farmID, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load report runs"})
+		return
+	}
+	defer rows.Close()
+	loc := s.reportLocation()
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, reportID int64
+		var status, runErr string
+		var recipients []string
+		var started, finished time.Time
+		if err := rows.Scan(&id, &reportID, &status, &recipients, &runErr, &started, &finished); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse report runs"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":         id,
+			"reportId":   reportID,
+			"status":     status,
+			"recipients": recipients,
+			"error":      runErr,
+			"startedAt":  started.In(loc).Format(time.RFC3339),
+			"finishedAt": finished.In(loc).Format(time.RFC3339),
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// handleRunReportSchedule runs a schedule straight away, outside its
+// timetable, and answers with how the run went.
+func (s *Server) handleRunReportSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	sc, err := scanReportSchedule(s.db.QueryRow(ctx, `SELECT `+reportScheduleColumns+` FROM report_schedules WHERE id = $1 AND farm_id = $2`, scheduleID, farmID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "report schedule not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load report schedule"})
+		return
+	}
+	run, err := s.runReportSchedule(ctx, sc)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record report run"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"ok":       run.status == "sent",
+		"runId":    run.id,
+		"reportId": run.reportID,
+		"status":   run.status,
+		"error":    run.err,
+	})
+}
+
+// RunReportSchedules generates and emails the reports whose schedules are due,
+// checking every interval until ctx is done.
+func (s *Server) RunReportSchedules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.runDueReportSchedules(ctx)
+		}
+	}
+}
+
+func (s *Server) runDueReportSchedules(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	now := time.Now().UTC()
+	rows, err := s.db.Query(ctx, `
+		SELECT `+reportScheduleColumns+`
+		FROM report_schedules
+		WHERE is_active AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT 20
+	`, now)
+	if err != nil {
+		log.Printf("report schedules: load due schedules: %v", err)
+		return
+	}
+	var due []reportSchedule
+	for rows.Next() {
+		sc, err := scanReportSchedule(rows)
+		if err != nil {
+			rows.Close()
+			log.Printf("report schedules: parse due schedules: %v", err)
+			return
+		}
+		due = append(due, sc)
+	}
+	rows.Close()
+
+	for _, sc := range due {
+		// Moving next_run_at on claims the run, so another server process
+		// polling at the same moment skips it. Runs missed while the server
+		// was down collapse into this one.
+		next := nextReportRun(sc, now, s.reportLocation())
+		res, err := s.db.Exec(ctx, `
+			UPDATE report_schedules SET next_run_at = $1, last_run_at = $2
+			WHERE id = $3 AND next_run_at = $4
+		`, next, now, sc.id, sc.nextRunAt)
+		if err != nil {
+			log.Printf("report schedules: claim schedule %d: %v", sc.id, err)
+			continue
+		}
+		if res.RowsAffected() == 0 {
+			continue
+		}
+		run, err := s.runReportSchedule(ctx, sc)
+		if err != nil {
+			log.Printf("report schedules: schedule %d: %v", sc.id, err)
+			continue
+		}
+		if run.status != "sent" {
+			log.Printf("report schedules: schedule %d %s: %s", sc.id, run.status, run.err)
+		}
+	}
+}
+
+type reportRun struct {
+	id       int64
+	reportID int64
+	status   string
+	err      string
+}
+
+// runReportSchedule generates sc's report, emails it to every recipient and
+// records the run. The returned error is only for failing to record the run;
+// everything else is the run's outcome.
+func (s *Server) runReportSchedule(ctx context.Context, sc reportSchedule) (reportRun, error) {
+	started := time.Now().UTC()
+	run := reportRun{status: "failed"}
+	var failures []string
+	sent := 0
+
+	attachment, notice, err := s.renderScheduledReport(ctx, sc, &run.reportID)
+	switch {
+	case err != nil:
+		failures = append(failures, err.Error())
+	case s.mailer == nil:
+		failures = append(failures, "email is not configured on this server")
+	default:
+		subject := fmt.Sprintf("FarmPro: %s (%s)", sc.title, s.formatDateLong(s.now()))
+		for _, to := range sc.recipients {
+			if err := s.mailer.sendTemplate(to, subject, "notification", notice, attachment); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", to, err))
+				continue
+			}
+			sent++
+		}
+	}
+	if sent > 0 {
+		run.status = "sent"
+		if len(failures) > 0 {
+			run.status = "partial"
+		}
+	}
+	run.err = strings.Join(failures, "; ")
+
+	var reportID *int64
+	if run.reportID > 0 {
+		reportID = &run.reportID
+	}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO report_runs(farm_id, schedule_id, report_id, status, recipients, error, started_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, sc.farmID, sc.id, reportID, run.status, sc.recipients, run.err, started, time.Now().UTC()).Scan(&run.id)
+	return run, err
+}
+
+// renderScheduledReport stores a report row for the run, the way
+// handleGenerateReport does, and renders it for attaching. reportID is set as
+// soon as the row exists.
+func (s *Server) renderScheduledReport(ctx context.Context, sc reportSchedule, reportID *int64) (mailAttachment, notificationData, error) {
+	var a mailAttachment
+	var notice notificationData
+	description := fmt.Sprintf("Generated %s report | range=%s | format=%s", strings.ToLower(sc.category), sc.dateRange, sc.format)
+	generated := s.now()
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO reports(title, description, category, last_generated, farm_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, sc.title, description, sc.category, s.formatISODate(generated), sc.farmID).Scan(reportID)
+	if err != nil {
+		return a, notice, fmt.Errorf("store report: %v", err)
+	}
+
+	report, err := s.buildReportContent(ctx, sc.farmID, *reportID, sc.title, sc.category, sc.dateRange, generated, sc.format)
+	if err != nil {
+		return a, notice, fmt.Errorf("build report: %v", err)
+	}
+	var buf reportBuffer
+	if sc.format == "CSV" {
+		err = writeCSVReport(&buf, report)
+	} else {
+		err = writePDFReport(&buf, report)
+	}
+	if err != nil {
+		return a, notice, fmt.Errorf("render report: %v", err)
+	}
+	a = mailAttachment{
+		filename:    reportFilename(sc.title) + "." + strings.ToLower(sc.format),
+		contentType: buf.Header().Get("Content-Type"),
+		data:        buf.Bytes(),
+	}
+
+	var farmName string
+	_ = s.db.QueryRow(ctx, `SELECT name FROM farms WHERE id = $1`, sc.farmID).Scan(&farmName)
+	keys := make([]string, 0, len(report.Summary))
+	for k := range report.Summary {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	notice = notificationData{
+		Title:  sc.title,
+		Intro:  fmt.Sprintf("Your %s report for %s (%s) is attached.", sc.frequency, farmName, strings.ToLower(sc.dateRange)),
+		Footer: "You get this email because you are on the report's recipient list in FarmPro.",
+	}
+	for _, k := range orderedReportSummaryKeys(report.Category, keys) {
+		notice.Facts = append(notice.Facts, mailFact{Label: prettyMetricLabel(k), Value: formatSummaryValue(k, report.Summary[k])})
+	}
+	return a, notice, nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextReportRun(t *testing.T) {
+	eat := time.FixedZone("EAT", 3*60*60)
+	at := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, eat)
+	}
+	daily := reportSchedule{frequency: reportDaily, hour: 6}
+	// 2024-03-05 is a Tuesday; weekday 1 is Monday.
+	weekly := reportSchedule{frequency: reportWeekly, hour: 8, weekday: 1}
+	monthly := reportSchedule{frequency: reportMonthly, hour: 6, monthDay: 15}
+
+	tests := []struct {
+		name  string
+		sc    reportSchedule
+		after time.Time
+		want  time.Time
+	}{
+		{"daily before the hour", daily, at(2024, 3, 5, 5, 0), at(2024, 3, 5, 6, 0)},
+		{"daily on the hour", daily, at(2024, 3, 5, 6, 0), at(2024, 3, 6, 6, 0)},
+		{"daily after the hour", daily, at(2024, 3, 5, 7, 30), at(2024, 3, 6, 6, 0)},
+		{"daily across the UTC date", daily, time.Date(2024, 3, 5, 22, 0, 0, 0, time.UTC), at(2024, 3, 6, 6, 0)},
+		{"weekly later in the week", weekly, at(2024, 3, 5, 9, 0), at(2024, 3, 11, 8, 0)},
+		{"weekly same day before the hour", weekly, at(2024, 3, 11, 7, 0), at(2024, 3, 11, 8, 0)},
+		{"weekly same day after the hour", weekly, at(2024, 3, 11, 8, 0), at(2024, 3, 18, 8, 0)},
+		{"monthly later this month", monthly, at(2024, 3, 5, 0, 0), at(2024, 3, 15, 6, 0)},
+		{"monthly on the hour", monthly, at(2024, 3, 15, 6, 0), at(2024, 4, 15, 6, 0)},
+		{"monthly into the new year", monthly, at(2024, 12, 20, 0, 0), at(2025, 1, 15, 6, 0)},
+		{"monthly on the 28th in February", reportSchedule{frequency: reportMonthly, hour: 0, monthDay: 28}, at(2024, 2, 28, 1, 0), at(2024, 3, 28, 0, 0)},
+	}
+	for _, tt := range tests {
+		got := nextReportRun(tt.sc, tt.after, eat)
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("%s: nextReportRun = %v, want %v in UTC", tt.name, got, tt.want.UTC())
+		}
+	}
+}
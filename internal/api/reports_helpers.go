@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"sort"
@@ -23,6 +24,25 @@ type reportContent struct {
 	Records     []map[string]any `json:"records"`
 }
 
+// reportWriter is where a rendered report goes: the HTTP response, or a
+// reportBuffer when the report is emailed instead.
+type reportWriter interface {
+	io.Writer
+	Header() http.Header
+}
+
+type reportBuffer struct {
+	bytes.Buffer
+	header http.Header
+}
+
+func (b *reportBuffer) Header() http.Header {
+	if b.header == nil {
+		b.header = http.Header{}
+	}
+	return b.header
+}
+
 func normalizeReportType(v string) string {
 	switch strings.ToLower(strings.TrimSpace(v)) {
 	case "financial":
@@ -37,6 +57,10 @@ func normalizeReportType(v string) string {
 		return "Sales"
 	case "feeding":
 		return "Feeding"
+	case "suppliers":
+		return "Suppliers"
+	case "herd":
+		return "Herd"
 	default:
 		return "Financial"
 	}
@@ -117,7 +141,7 @@ func resolveDateRangeWindow(dateRange string, now time.Time) (time.Time, time.Ti
 	}
 }
 
-func (s *Server) buildReportContent(ctx context.Context, id int64, title string, category string, dateRange string, generated time.Time, format string) (reportContent, error) {
+func (s *Server) buildReportContent(ctx context.Context, farmID int64, id int64, title string, category string, dateRange string, generated time.Time, format string) (reportContent, error) {
 	start, end := resolveDateRangeWindow(dateRange, time.Now())
 	c := reportContent{
 		ID:          id,
@@ -133,9 +157,9 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 	switch c.Category {
 	case "Health":
 		var healthy, attention, sick int64
-		_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE is_active = true AND health_status = 'healthy'`).Scan(&healthy)
-		_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE is_active = true AND health_status = 'attention'`).Scan(&attention)
-		_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE is_active = true AND health_status = 'sick'`).Scan(&sick)
+		_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE farm_id = $1 AND is_active = true AND health_status = 'healthy'`, farmID).Scan(&healthy)
+		_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE farm_id = $1 AND is_active = true AND health_status = 'attention'`, farmID).Scan(&attention)
+		_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE farm_id = $1 AND is_active = true AND health_status = 'sick'`, farmID).Scan(&sick)
 		c.Summary["healthy"] = healthy
 		c.Summary["attention"] = attention
 		c.Summary["sick"] = sick
@@ -144,10 +168,10 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 			SELECT h.record_date, a.tag_id, h.action, h.treatment, h.veterinarian
 			FROM health_records h
 			JOIN animals a ON a.id = h.animal_id
-			WHERE h.record_date BETWEEN $1 AND $2
+			WHERE h.record_date BETWEEN $1 AND $2 AND h.farm_id = $3
 			ORDER BY h.record_date DESC, h.id DESC
 			LIMIT 250
-		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID)
 		if err != nil {
 			return c, err
 		}
@@ -180,9 +204,9 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 				COALESCE(SUM(wool_kg), 0),
 				COALESCE(SUM(meat_kg), 0),
 				COALESCE(SUM(total_value), 0)
-			FROM production_logs
-			WHERE log_date BETWEEN $1 AND $2
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&milk, &milkCow, &milkGoat, &eggs, &wool, &meat, &value)
+			FROM production_daily
+			WHERE log_date BETWEEN $1 AND $2 AND farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&milk, &milkCow, &milkGoat, &eggs, &wool, &meat, &value)
 		c.Summary["milkLiters"] = milk
 		c.Summary["milkCowLiters"] = milkCow
 		c.Summary["milkGoatLiters"] = milkGoat
@@ -193,11 +217,11 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 
 		rows, err := s.db.Query(ctx, `
 			SELECT log_date, milk_liters, milk_cow_liters, milk_goat_liters, eggs_count, wool_kg, meat_kg, total_value
-			FROM production_logs
-			WHERE log_date BETWEEN $1 AND $2
+			FROM production_daily
+			WHERE log_date BETWEEN $1 AND $2 AND farm_id = $3
 			ORDER BY log_date DESC
 			LIMIT 250
-		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID)
 		if err != nil {
 			return c, err
 		}
@@ -229,39 +253,42 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 		_ = s.db.QueryRow(ctx, `
 			SELECT COALESCE(SUM(cost), 0), COUNT(*)
 			FROM feeding_records
-			WHERE feed_date BETWEEN $1 AND $2
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&totalCost, &feedRecords)
+			WHERE feed_date BETWEEN $1 AND $2 AND farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&totalCost, &feedRecords)
 		_ = s.db.QueryRow(ctx, `
 			SELECT COALESCE(AVG(day_total), 0)
 			FROM (
 				SELECT feed_date, SUM(cost) AS day_total
 				FROM feeding_records
-				WHERE feed_date BETWEEN $1 AND $2
+				WHERE feed_date BETWEEN $1 AND $2 AND farm_id = $3
 				GROUP BY feed_date
 			) t
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&avgDaily)
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&avgDaily)
 		_ = s.db.QueryRow(ctx, `
 			SELECT feed_type, SUM(cost) AS total
 			FROM feeding_records
-			WHERE feed_date BETWEEN $1 AND $2
+			WHERE feed_date BETWEEN $1 AND $2 AND farm_id = $3
 			GROUP BY feed_type
 			ORDER BY total DESC
 			LIMIT 1
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&topFeed, &topFeedCost)
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&topFeed, &topFeedCost)
 		c.Summary["totalFeedCost"] = totalCost
 		c.Summary["feedRecords"] = feedRecords
 		c.Summary["averageDailyCost"] = avgDaily
 		c.Summary["topFeed"] = topFeed
 		c.Summary["topFeedCost"] = topFeedCost
+		if kg, _, err := s.feedQuantityKg(ctx, farmID, start, end); err == nil {
+			c.Summary["totalFeedKg"] = kg
+		}
 
 		rows, err := s.db.Query(ctx, `
 			SELECT f.feed_date, COALESCE(a.tag_id, ''), f.feed_type, f.quantity_value, f.quantity_unit, f.cost, COALESCE(f.notes, '')
 			FROM feeding_records f
 			LEFT JOIN animals a ON a.id = f.animal_id
-			WHERE f.feed_date BETWEEN $1 AND $2
+			WHERE f.feed_date BETWEEN $1 AND $2 AND f.farm_id = $3
 			ORDER BY f.feed_date DESC, f.id DESC
 			LIMIT 250
-		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID)
 		if err != nil {
 			return c, err
 		}
@@ -295,14 +322,15 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 			       ),
 			       COUNT(*) FILTER (WHERE expected_birth_date BETWEEN $1 AND $2)
 			FROM breeding_records
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&active, &onHeat, &aiAttempts, &aiSuccess, &expectedBirths)
+			WHERE farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&active, &onHeat, &aiAttempts, &aiSuccess, &expectedBirths)
 
 		var eggsSet, chicksHatched int64
 		_ = s.db.QueryRow(ctx, `
 			SELECT COALESCE(SUM(eggs_set), 0), COALESCE(SUM(chicks_hatched), 0)
 			FROM poultry_breeding_records
-			WHERE egg_set_date BETWEEN $1 AND $2
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&eggsSet, &chicksHatched)
+			WHERE egg_set_date BETWEEN $1 AND $2 AND farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&eggsSet, &chicksHatched)
 
 		c.Summary["activeBreeding"] = active
 		c.Summary["onHeat"] = onHeat
@@ -328,7 +356,7 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 				FROM breeding_records b
 				LEFT JOIN animals m ON m.id = b.mother_animal_id
 				LEFT JOIN animals f ON f.id = b.father_animal_id
-				WHERE b.breeding_date BETWEEN $1 AND $2
+				WHERE b.breeding_date BETWEEN $1 AND $2 AND b.farm_id = $3
 				UNION ALL
 				SELECT p.egg_set_date AS breed_date,
 				       COALESCE(h.tag_id, '') AS mother_tag,
@@ -343,11 +371,11 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 				FROM poultry_breeding_records p
 				LEFT JOIN animals h ON h.id = p.hen_animal_id
 				LEFT JOIN animals r ON r.id = p.rooster_animal_id
-				WHERE p.egg_set_date BETWEEN $1 AND $2
+				WHERE p.egg_set_date BETWEEN $1 AND $2 AND p.farm_id = $3
 			) t
 			ORDER BY breed_date DESC
 			LIMIT 250
-		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID)
 		if err != nil {
 			return c, err
 		}
@@ -383,46 +411,111 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 			c.Records = append(c.Records, record)
 		}
 
+	case "Suppliers":
+		from := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		to := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+		items, unassigned, err := s.supplierSpend(ctx, farmID, from, to)
+		if err != nil {
+			return c, err
+		}
+		var totalSpend, openOrders float64
+		for _, it := range items {
+			totalSpend += it["spend"].(float64)
+		}
+		_ = s.db.QueryRow(ctx, `
+			SELECT COALESCE(SUM(l.total_amount - ROUND(l.unit_price * l.quantity_received, 2)), 0)
+			FROM purchase_orders o
+			JOIN purchase_order_lines l ON l.purchase_order_id = o.id
+			WHERE o.farm_id = $1 AND o.status IN ('open', 'partial')
+		`, farmID).Scan(&openOrders)
+		c.Summary["totalSpend"] = roundCents(totalSpend + unassigned)
+		c.Summary["suppliers"] = len(items)
+		c.Summary["unassignedSpend"] = unassigned
+		c.Summary["openOrderValue"] = roundCents(openOrders)
+		if len(items) > 0 {
+			c.Summary["topSupplier"] = items[0]["supplier"]
+			c.Summary["topSupplierSpend"] = items[0]["spend"]
+		}
+		c.Records = items
+
+	case "Herd":
+		from := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		to := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+		m, err := s.herdMovementBetween(ctx, farmID, from, to)
+		if err != nil {
+			return c, err
+		}
+		c.Summary["openingHerd"] = m.opening
+		c.Summary["closingHerd"] = m.closing
+		c.Summary["births"] = m.counts[animalEventBirth]
+		c.Summary["purchases"] = m.counts[animalEventPurchase]
+		c.Summary["animalsSold"] = m.counts[animalEventSale]
+		c.Summary["deaths"] = m.counts[animalEventDeath]
+		c.Summary["culls"] = m.counts[animalEventCull]
+		c.Summary["mortalityRate"] = m.rate(animalEventDeath)
+		c.Summary["cullRate"] = m.rate(animalEventCull)
+		if cause := m.topReason(animalEventDeath); cause != "" {
+			c.Summary["topDeathCause"] = cause
+		}
+		if reason := m.topReason(animalEventCull); reason != "" {
+			c.Summary["topCullReason"] = reason
+		}
+		rows, err := s.db.Query(ctx, `SELECT `+animalEventColumns+animalEventJoins+`
+			WHERE e.farm_id = $1 AND a.status <> 'inactive' AND e.event_date BETWEEN $2 AND $3
+			ORDER BY e.event_date DESC, e.id DESC
+		`, farmID, from, to)
+		if err != nil {
+			return c, err
+		}
+		if c.Records, err = s.scanAnimalEvents(rows); err != nil {
+			return c, err
+		}
+
 	case "Sales":
-		var grossRevenue, netRevenue, vatCollected float64
+		var grossRevenue, netRevenue, vatCollected, credited float64
 		var transactions int64
 		_ = s.db.QueryRow(ctx, `
-			SELECT COALESCE(SUM(total_amount), 0), COALESCE(SUM(net_amount), 0), COALESCE(SUM(vat_amount), 0), COUNT(*)
-			FROM sales
-			WHERE sale_date BETWEEN $1 AND $2
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&grossRevenue, &netRevenue, &vatCollected, &transactions)
+			SELECT COALESCE(SUM(total_amount), 0), COALESCE(SUM(net_amount), 0), COALESCE(SUM(vat_amount), 0),
+			       COUNT(DISTINCT invoice_id) FILTER (WHERE kind = 'invoice'), COALESCE(-SUM(total_amount) FILTER (WHERE kind = 'credit_note'), 0)
+			FROM sales_lines
+			WHERE sale_date BETWEEN $1 AND $2 AND farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&grossRevenue, &netRevenue, &vatCollected, &transactions, &credited)
 		c.Summary["totalRevenue"] = grossRevenue
 		c.Summary["grossRevenue"] = grossRevenue
 		c.Summary["netRevenue"] = netRevenue
 		c.Summary["vatCollected"] = vatCollected
 		c.Summary["transactions"] = transactions
+		c.Summary["creditNotes"] = credited
 
 		rows, err := s.db.Query(ctx, `
-			SELECT sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin, vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount
-			FROM sales
-			WHERE sale_date BETWEEN $1 AND $2
-			ORDER BY sale_date DESC, id DESC
+			SELECT sale_date, invoice_number, kind, product, quantity_value, quantity_unit, buyer, buyer_pin, vat_class, vat_applicable, vat_rate, vat_amount, net_amount, price_per_unit, total_amount
+			FROM sales_lines
+			WHERE sale_date BETWEEN $1 AND $2 AND farm_id = $3
+			ORDER BY sale_date DESC, invoice_id DESC, line_no
 			LIMIT 250
-		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID)
 		if err != nil {
 			return c, err
 		}
 		defer rows.Close()
 		for rows.Next() {
 			var d time.Time
-			var product, unit, buyer, buyerPIN string
+			var invoiceNumber, kind, product, unit, buyer, buyerPIN, vatClass string
 			var qty, price, total, vatRate, vatAmount, netAmount float64
 			var vatApplicable bool
-			if err := rows.Scan(&d, &product, &qty, &unit, &buyer, &buyerPIN, &vatApplicable, &vatRate, &vatAmount, &netAmount, &price, &total); err != nil {
+			if err := rows.Scan(&d, &invoiceNumber, &kind, &product, &qty, &unit, &buyer, &buyerPIN, &vatClass, &vatApplicable, &vatRate, &vatAmount, &netAmount, &price, &total); err != nil {
 				return c, err
 			}
 			c.Records = append(c.Records, map[string]any{
 				"date":          s.formatISODate(d),
+				"invoiceNumber": invoiceNumber,
+				"kind":          kind,
 				"product":       product,
 				"quantityValue": qty,
 				"quantityUnit":  unit,
 				"buyer":         buyer,
 				"buyerPIN":      buyerPIN,
+				"vatClass":      vatClass,
 				"vatApplicable": vatApplicable,
 				"vatRate":       vatRate,
 				"vatAmount":     vatAmount,
@@ -436,14 +529,14 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 		var grossRevenue, netRevenue, vatCollected, expense float64
 		_ = s.db.QueryRow(ctx, `
 			SELECT COALESCE(SUM(total_amount), 0), COALESCE(SUM(net_amount), 0), COALESCE(SUM(vat_amount), 0)
-			FROM sales
-			WHERE sale_date BETWEEN $1 AND $2
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&grossRevenue, &netRevenue, &vatCollected)
+			FROM sales_lines
+			WHERE sale_date BETWEEN $1 AND $2 AND farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&grossRevenue, &netRevenue, &vatCollected)
 		_ = s.db.QueryRow(ctx, `
 			SELECT COALESCE(SUM(amount), 0)
 			FROM expenses
-			WHERE expense_date BETWEEN $1 AND $2
-		`, start.Format("2006-01-02"), end.Format("2006-01-02")).Scan(&expense)
+			WHERE expense_date BETWEEN $1 AND $2 AND farm_id = $3
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID).Scan(&expense)
 		c.Summary["totalRevenue"] = grossRevenue
 		c.Summary["grossRevenue"] = grossRevenue
 		c.Summary["netRevenue"] = netRevenue
@@ -455,16 +548,16 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 			SELECT entry_date, entry_type, item, amount
 			FROM (
 				SELECT sale_date AS entry_date, 'sale' AS entry_type, product AS item, total_amount AS amount
-				FROM sales
-				WHERE sale_date BETWEEN $1 AND $2
+				FROM sales_lines
+				WHERE sale_date BETWEEN $1 AND $2 AND farm_id = $3
 				UNION ALL
 				SELECT expense_date AS entry_date, 'expense' AS entry_type, item, amount * -1
 				FROM expenses
-				WHERE expense_date BETWEEN $1 AND $2
+				WHERE expense_date BETWEEN $1 AND $2 AND farm_id = $3
 			) t
 			ORDER BY entry_date DESC
 			LIMIT 250
-		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), farmID)
 		if err != nil {
 			return c, err
 		}
@@ -488,7 +581,7 @@ func (s *Server) buildReportContent(ctx context.Context, id int64, title string,
 	return c, nil
 }
 
-func writePDFReport(w http.ResponseWriter, report reportContent) error {
+func writePDFReport(w reportWriter, report reportContent) error {
 	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.pdf\"", reportFilename(report.Title)))
 
@@ -727,11 +820,14 @@ func shortenPDFText(s string, max int) string {
 func orderedReportSummaryKeys(category string, summaryKeys []string) []string {
 	priorityByCategory := map[string][]string{
 		"financial": {"grossRevenue", "netRevenue", "profit", "totalExpenses", "totalRevenue", "vatCollected"},
-		"sales":     {"grossRevenue", "netRevenue", "totalRevenue", "vatCollected", "transactions"},
+		"sales":     {"grossRevenue", "netRevenue", "totalRevenue", "vatCollected", "transactions", "creditNotes"},
 		"resources": {"totalValue", "milkLiters", "milkCowLiters", "milkGoatLiters", "eggsCount", "woolKg", "meatKg"},
 		"health":    {"healthy", "attention", "sick"},
-		"feeding":   {"totalFeedCost", "averageDailyCost", "feedRecords", "topFeed", "topFeedCost"},
+		"feeding":   {"totalFeedCost", "averageDailyCost", "feedRecords", "totalFeedKg", "topFeed", "topFeedCost"},
 		"breeding":  {"activeBreeding", "onHeat", "aiAttempts", "aiSuccess", "expectedBirths", "poultryEggsSet", "poultryChicksHatched"},
+		"statement": {"customer", "kraPin", "phone", "openingBalance", "invoiced", "credited", "paid", "closingBalance"},
+		"suppliers": {"totalSpend", "suppliers", "topSupplier", "topSupplierSpend", "unassignedSpend", "openOrderValue"},
+		"herd":      {"openingHerd", "closingHerd", "births", "purchases", "animalsSold", "deaths", "culls", "mortalityRate", "cullRate", "topDeathCause", "topCullReason"},
 	}
 	priority := priorityByCategory[strings.ToLower(strings.TrimSpace(category))]
 	if len(priority) == 0 {
@@ -780,6 +876,7 @@ func prettyMetricLabel(raw string) string {
 		"totalFeedCost": "Total Feed Cost",
 		"averageDailyCost": "Average Daily Feed Cost",
 		"feedRecords":   "Feeding Records",
+		"totalFeedKg":   "Feed Given (Kg)",
 		"topFeed":       "Top Feed",
 		"topFeedCost":   "Top Feed Cost",
 		"activeBreeding": "Active Breeding",
@@ -789,6 +886,23 @@ func prettyMetricLabel(raw string) string {
 		"expectedBirths": "Expected Births",
 		"poultryEggsSet": "Poultry Eggs Set",
 		"poultryChicksHatched": "Poultry Chicks Hatched",
+		"totalSpend":    "Total Spend",
+		"suppliers":     "Suppliers",
+		"topSupplier":   "Top Supplier",
+		"topSupplierSpend": "Top Supplier Spend",
+		"unassignedSpend": "Spend Without Supplier",
+		"openOrderValue": "Open Orders",
+		"openingHerd":   "Opening Herd",
+		"closingHerd":   "Closing Herd",
+		"births":        "Births",
+		"purchases":     "Purchases",
+		"animalsSold":   "Animals Sold",
+		"deaths":        "Deaths",
+		"culls":         "Culls",
+		"mortalityRate": "Mortality Rate (%)",
+		"cullRate":      "Cull Rate (%)",
+		"topDeathCause": "Top Cause of Death",
+		"topCullReason": "Top Cull Reason",
 	}
 	if v, ok := known[raw]; ok {
 		return v
@@ -801,14 +915,16 @@ func prettyMetricLabel(raw string) string {
 
 func formatSummaryValue(key string, value any) string {
 	switch strings.ToLower(strings.TrimSpace(key)) {
-	case "grossrevenue", "netrevenue", "profit", "totalexpenses", "totalrevenue", "vatcollected", "totalvalue", "totalfeedcost", "topfeedcost", "averagedailycost":
+	case "grossrevenue", "netrevenue", "profit", "totalexpenses", "totalrevenue", "vatcollected", "totalvalue", "totalfeedcost", "topfeedcost", "averagedailycost",
+		"creditnotes", "openingbalance", "invoiced", "credited", "paid", "closingbalance", "totalspend", "topsupplierspend", "unassignedspend", "openordervalue":
 		return formatAnyCurrency(value)
-	case "transactions", "healthy", "attention", "sick", "eggscount", "feedrecords", "activebreeding", "onheat", "aiattempts", "aisuccess", "expectedbirths", "poultryeggsset", "poultrychickshatched":
+	case "transactions", "healthy", "attention", "sick", "eggscount", "feedrecords", "activebreeding", "onheat", "aiattempts", "aisuccess", "expectedbirths", "poultryeggsset", "poultrychickshatched", "suppliers",
+		"openingherd", "closingherd", "births", "purchases", "animalssold", "deaths", "culls":
 		if n, ok := asFloat64(value); ok {
 			return fmt.Sprintf("%d", int64(math.Round(n)))
 		}
 		return fmt.Sprint(value)
-	case "milkliters", "milkcowliters", "milkgoatliters", "woolkg", "meatkg":
+	case "milkliters", "milkcowliters", "milkgoatliters", "woolkg", "meatkg", "totalfeedkg":
 		return formatAnyNumber(value)
 	default:
 		if n, ok := asFloat64(value); ok {
@@ -831,6 +947,9 @@ func buildRecordHighlights(report reportContent) ([]string, [][]string, string)
 		"feeding":   {"#", "Date", "Tag", "Feed", "Qty", "Cost"},
 		"breeding":  {"#", "Date", "Species", "Mother", "Father", "Status"},
 		"health":    {"#", "Date", "Tag", "Action", "Treatment", "Vet"},
+		"statement": {"#", "Date", "Document", "Type", "Amount", "Balance"},
+		"suppliers": {"#", "Supplier", "KRA PIN", "Expenses", "Spend", "Share"},
+		"herd":      {"#", "Date", "Tag", "Event", "Reason", "Details"},
 	}
 	category := strings.ToLower(strings.TrimSpace(report.Category))
 	headers := headersByCategory[category]
@@ -899,6 +1018,37 @@ func buildRecordHighlights(report reportContent) ([]string, [][]string, string)
 				fmt.Sprint(r["treatment"]),
 				fmt.Sprint(r["veterinarian"]),
 			})
+		case "statement":
+			rows = append(rows, []string{
+				fmt.Sprintf("%d", i+1),
+				fmt.Sprint(r["date"]),
+				fmt.Sprint(r["document"]),
+				titleWord(fmt.Sprint(r["type"])),
+				formatAnyCurrency(r["amount"]),
+				formatAnyCurrency(r["balance"]),
+			})
+		case "suppliers":
+			rows = append(rows, []string{
+				fmt.Sprintf("%d", i+1),
+				fmt.Sprint(r["supplier"]),
+				fmt.Sprint(r["kraPin"]),
+				formatAnyNumber(r["expenses"]),
+				formatAnyCurrency(r["spend"]),
+				formatAnyNumber(r["share"]) + "%",
+			})
+		case "herd":
+			details := fmt.Sprint(r["invoiceNumber"])
+			if to := fmt.Sprint(r["toLocation"]); to != "" {
+				details = strings.TrimSpace(fmt.Sprint(r["fromLocation"]) + " -> " + to)
+			}
+			rows = append(rows, []string{
+				fmt.Sprintf("%d", i+1),
+				fmt.Sprint(r["date"]),
+				fmt.Sprint(r["tagId"]),
+				titleWord(fmt.Sprint(r["type"])),
+				strings.ReplaceAll(fmt.Sprint(r["reason"]), "_", " "),
+				details,
+			})
 		default:
 			rows = append(rows, []string{
 				fmt.Sprintf("%d", i+1),
@@ -928,6 +1078,12 @@ func highlightColumnWidths(category string, headerCount int) []int {
 		return []int{24, 70, 86, 88, 88, 90}
 	case "health":
 		return []int{24, 66, 64, 88, 142, 112}
+	case "statement":
+		return []int{24, 70, 110, 100, 96, 96}
+	case "suppliers":
+		return []int{24, 150, 96, 70, 86, 70}
+	case "herd":
+		return []int{24, 70, 80, 70, 110, 142}
 	default:
 		if headerCount <= 2 {
 			return []int{24, 472}
@@ -1070,7 +1226,7 @@ func pdfEscape(s string) string {
 	return s
 }
 
-func writeCSVReport(w http.ResponseWriter, report reportContent) error {
+func writeCSVReport(w reportWriter, report reportContent) error {
 	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.csv\"", reportFilename(report.Title)))
 
@@ -0,0 +1,608 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const ownerRole = "owner"
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+func (s *Server) handlePermissions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, `SELECT key, description FROM permissions ORDER BY key`)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load permissions"})
+		return
+	}
+	defer rows.Close()
+
+	held, _ := r.Context().Value(userPermissionsContextKey).(map[string]struct{})
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var key, description string
+		if err := rows.Scan(&key, &description); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse permissions"})
+			return
+		}
+		_, grantable := held[key]
+		out = append(out, map[string]any{
+			"key":         key,
+			"description": description,
+			"group":       strings.SplitN(key, ".", 2)[0],
+			"grantable":   grantable,
+		})
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleRoles(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT r.id, r.name, r.description, r.is_system,
+		       (SELECT COUNT(*) FROM farm_members fm WHERE fm.role_id = r.id AND fm.farm_id = $1),
+		       COALESCE(ARRAY_AGG(p.key ORDER BY p.key) FILTER (WHERE p.key IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE r.farm_id IS NULL OR r.farm_id = $1
+		GROUP BY r.id
+		ORDER BY r.is_system DESC, r.name
+	`, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load roles"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id, users int64
+		var name, description string
+		var system bool
+		var permissions []string
+		if err := rows.Scan(&id, &name, &description, &system, &users, &permissions); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse roles"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":          id,
+			"name":        name,
+			"label":       capitalizeRole(name),
+			"description": description,
+			"isSystem":    system,
+			"userCount":   users,
+			"permissions": permissions,
+		})
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleCreateRole(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	in.Description = strings.TrimSpace(in.Description)
+	if !roleNameRe.MatchString(in.Name) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "role name must be 2-32 lowercase letters, digits, '-' or '_'"})
+		return
+	}
+	keys := normalizePermissionKeys(in.Permissions)
+	if missing := missingGrants(r.Context(), keys); len(missing) > 0 {
+		respondJSON(w, http.StatusForbidden, map[string]any{"error": "cannot grant permissions you do not hold", "permissions": missing})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create role"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if taken, err := roleNameTaken(ctx, tx, farmID, 0, in.Name); err != nil || taken {
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create role"})
+			return
+		}
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "role already exists"})
+		return
+	}
+	var roleID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO roles(name, description, is_system, farm_id)
+		VALUES ($1, $2, false, $3)
+		RETURNING id
+	`, in.Name, in.Description, farmID).Scan(&roleID)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "role already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create role"})
+		return
+	}
+	if err := setRolePermissions(ctx, tx, roleID, keys); err != nil {
+		respondRolePermissionError(w, err)
+		return
+	}
+	if err := s.recordAudit(ctx, tx, r, auditCreate, "roles", roleID, nil); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create role"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create role"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": roleID})
+}
+
+func (s *Server) handleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role id"})
+		return
+	}
+	var in struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Permissions *[]string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	in.Description = strings.TrimSpace(in.Description)
+	if !roleNameRe.MatchString(in.Name) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "role name must be 2-32 lowercase letters, digits, '-' or '_'"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	current, system, err := lockRole(ctx, tx, farmID, roleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "role not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+		return
+	}
+	if system {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "system roles are shared by every farm and cannot be changed"})
+		return
+	}
+	before, err := auditSnapshot(ctx, tx, "roles", roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+		return
+	}
+	if in.Name != current {
+		taken, err := roleNameTaken(ctx, tx, farmID, roleID, in.Name)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+			return
+		}
+		if taken {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "role already exists"})
+			return
+		}
+	}
+
+	if in.Permissions != nil {
+		keys := normalizePermissionKeys(*in.Permissions)
+		existing, err := rolePermissionKeys(ctx, tx, roleID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+			return
+		}
+		if missing := missingGrants(r.Context(), addedPermissions(keys, existing)); len(missing) > 0 {
+			respondJSON(w, http.StatusForbidden, map[string]any{"error": "cannot grant permissions you do not hold", "permissions": missing})
+			return
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, roleID); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+			return
+		}
+		if err := setRolePermissions(ctx, tx, roleID, keys); err != nil {
+			respondRolePermissionError(w, err)
+			return
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE roles SET name = $1, description = $2 WHERE id = $3`, in.Name, in.Description, roleID); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "role already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+		return
+	}
+	if in.Name != current {
+		if _, err := tx.Exec(ctx, `UPDATE users SET role = $1 WHERE role_id = $2`, in.Name, roleID); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+			return
+		}
+	}
+	if err := s.recordAudit(ctx, tx, r, auditUpdate, "roles", roleID, before); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update role"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, system, err := lockRole(ctx, tx, farmID, roleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "role not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	if system {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "system roles cannot be deleted"})
+		return
+	}
+
+	var assigned int64
+	if err := tx.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM users WHERE role_id = $1) + (SELECT COUNT(*) FROM farm_members WHERE role_id = $1)
+	`, roleID).Scan(&assigned); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	if assigned > 0 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "role is still assigned to users"})
+		return
+	}
+
+	before, err := auditSnapshot(ctx, tx, "roles", roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM roles WHERE id = $1`, roleID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	if err := s.recordAudit(ctx, tx, r, auditDelete, "roles", roleID, before); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete role"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleAttachRolePermission(w http.ResponseWriter, r *http.Request) {
+	roleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role id"})
+		return
+	}
+	key := strings.ToLower(strings.TrimSpace(r.PathValue("key")))
+	if missing := missingGrants(r.Context(), []string{key}); len(missing) > 0 {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "cannot grant permissions you do not hold"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to attach permission"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, system, err := lockRole(ctx, tx, farmID, roleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "role not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to attach permission"})
+		return
+	}
+	if system {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": systemRoleLockedMsg})
+		return
+	}
+	before, err := auditSnapshot(ctx, tx, "roles", roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to attach permission"})
+		return
+	}
+	if err := setRolePermissions(ctx, tx, roleID, []string{key}); err != nil {
+		respondRolePermissionError(w, err)
+		return
+	}
+	if err := s.recordAudit(ctx, tx, r, auditUpdate, "roles", roleID, before); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to attach permission"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to attach permission"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDetachRolePermission(w http.ResponseWriter, r *http.Request) {
+	roleID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role id"})
+		return
+	}
+	key := strings.ToLower(strings.TrimSpace(r.PathValue("key")))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to detach permission"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, system, err := lockRole(ctx, tx, farmID, roleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "role not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to detach permission"})
+		return
+	}
+	if system {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": systemRoleLockedMsg})
+		return
+	}
+	before, err := auditSnapshot(ctx, tx, "roles", roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to detach permission"})
+		return
+	}
+	res, err := tx.Exec(ctx, `
+		DELETE FROM role_permissions rp
+		USING permissions p
+		WHERE rp.permission_id = p.id AND rp.role_id = $1 AND p.key = $2
+	`, roleID, key)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to detach permission"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "permission not attached to role"})
+		return
+	}
+	if err := s.recordAudit(ctx, tx, r, auditUpdate, "roles", roleID, before); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to detach permission"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to detach permission"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+var errUnknownPermission = errors.New("unknown permission")
+
+// System roles are shared by every farm, so their permissions are fixed;
+// a farm that wants different access creates a role of its own.
+const systemRoleLockedMsg = "system role permissions cannot be changed; create a custom role instead"
+
+// lockRole locks a role the farm can see: a system role or one of its own.
+func lockRole(ctx context.Context, tx pgx.Tx, farmID, roleID int64) (string, bool, error) {
+	var name string
+	var system bool
+	err := tx.QueryRow(ctx, `
+		SELECT name, is_system FROM roles WHERE id = $1 AND (farm_id IS NULL OR farm_id = $2) FOR UPDATE
+	`, roleID, farmID).Scan(&name, &system)
+	return name, system, err
+}
+
+// roleNameTaken reports whether name is a system role or another of the
+// farm's roles.
+func roleNameTaken(ctx context.Context, tx pgx.Tx, farmID, roleID int64, name string) (bool, error) {
+	var taken bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1 AND id <> $2 AND (farm_id IS NULL OR farm_id = $3))
+	`, name, roleID, farmID).Scan(&taken)
+	return taken, err
+}
+
+func rolePermissionKeys(ctx context.Context, tx pgx.Tx, roleID int64) (map[string]struct{}, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT p.key
+		FROM role_permissions rp
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE rp.role_id = $1
+	`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]struct{})
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		out[key] = struct{}{}
+	}
+	return out, rows.Err()
+}
+
+func setRolePermissions(ctx context.Context, tx pgx.Tx, roleID int64, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	res, err := tx.Exec(ctx, `
+		INSERT INTO role_permissions(role_id, permission_id)
+		SELECT $1, p.id
+		FROM permissions p
+		WHERE p.key = ANY($2)
+		ON CONFLICT DO NOTHING
+	`, roleID, keys)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() < int64(len(keys)) {
+		var known int64
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM permissions WHERE key = ANY($1)`, keys).Scan(&known); err != nil {
+			return err
+		}
+		if known < int64(len(keys)) {
+			return errUnknownPermission
+		}
+	}
+	return nil
+}
+
+func respondRolePermissionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnknownPermission) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown permission key"})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save role permissions"})
+}
+
+func normalizePermissionKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, raw := range keys {
+		key := strings.ToLower(strings.TrimSpace(raw))
+		if key == "" {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// addedPermissions returns the keys a role does not hold yet. Only those need
+// the caller to hold them; keeping a permission the caller lacks is allowed.
+func addedPermissions(keys []string, existing map[string]struct{}) []string {
+	added := make([]string, 0)
+	for _, key := range keys {
+		if _, ok := existing[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	return added
+}
+
+// missingGrants returns the keys the caller cannot hand out because they do
+// not hold them, which stops a role manager from escalating their own access.
+func missingGrants(ctx context.Context, keys []string) []string {
+	held, _ := ctx.Value(userPermissionsContextKey).(map[string]struct{})
+	missing := make([]string, 0)
+	for _, key := range keys {
+		if _, ok := held[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+func (s *Server) missingRoleGrants(ctx context.Context, roleID int64) ([]string, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT p.key
+		FROM role_permissions rp
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE rp.role_id = $1
+		ORDER BY p.key
+	`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return missingGrants(ctx, keys), nil
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePermissionKeys(t *testing.T) {
+	got := normalizePermissionKeys([]string{" Health.Read", "animals.write", "", "health.read", "ANIMALS.WRITE "})
+	want := []string{"animals.write", "health.read"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+func TestRoleGrants(t *testing.T) {
+	held := map[string]struct{}{"animals.read": {}, "roles.manage": {}}
+	ctx := context.WithValue(context.Background(), userPermissionsContextKey, held)
+	existing := map[string]struct{}{"audit.read": {}, "animals.read": {}}
+	tests := []struct {
+		name    string
+		keys    []string
+		missing []string
+	}{
+		{"holds every key", []string{"animals.read", "roles.manage"}, nil},
+		{"cannot escalate", []string{"animals.read", "users.manage"}, []string{"users.manage"}},
+		// The role already had audit.read, so keeping it grants nothing new.
+		{"keeps a key the caller lacks", []string{"audit.read", "animals.read"}, nil},
+		{"adds and keeps", []string{"audit.read", "farms.manage"}, []string{"farms.manage"}},
+	}
+	for _, tt := range tests {
+		got := missingGrants(ctx, addedPermissions(tt.keys, existing))
+		if strings.Join(got, ",") != strings.Join(tt.missing, ",") {
+			t.Errorf("%s: missing %v, want %v", tt.name, got, tt.missing)
+		}
+	}
+	if got := missingGrants(context.Background(), []string{"animals.read"}); len(got) != 1 {
+		t.Errorf("caller without permissions may grant %v", got)
+	}
+}
+
+func TestRoleNames(t *testing.T) {
+	tests := map[string]bool{
+		"vet":                   true,
+		"night-shift_2":         true,
+		"a":                     false,
+		"2nd-shift":             false,
+		"Milkers":               false,
+		"milkers team":          false,
+		strings.Repeat("r", 32): true,
+		strings.Repeat("r", 33): false,
+	}
+	for name, ok := range tests {
+		if roleNameRe.MatchString(name) != ok {
+			t.Errorf("%q valid = %v, want %v", name, !ok, ok)
+		}
+	}
+}
+
+// Requests that would hand out a permission the caller lacks, or carry a bad
+// name, are refused before any role is loaded.
+func TestRoleHandlersRefuseEarly(t *testing.T) {
+	s := &Server{}
+	held := map[string]struct{}{"roles.manage": {}}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		status  int
+	}{
+		{"create with a permission not held", s.handleCreateRole, "/api/roles", `{"name":"milkers","permissions":["users.manage"]}`, http.StatusForbidden},
+		{"create with a bad name", s.handleCreateRole, "/api/roles", `{"name":"Milk Team"}`, http.StatusBadRequest},
+		{"attach a permission not held", s.handleAttachRolePermission, "/api/roles/3/permissions/users.manage", "", http.StatusForbidden},
+		{"update with a bad name", s.handleUpdateRole, "/api/roles/3", `{"name":"x"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+		r = r.WithContext(context.WithValue(r.Context(), userPermissionsContextKey, held))
+		r.SetPathValue("id", "3")
+		r.SetPathValue("key", "users.manage")
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
@@ -1,17 +1,227 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
 )
 
-func (s *Server) signToken(userID int64, email string) (string, error) {
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var errRefreshTokenReused = errors.New("refresh token reuse detected")
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+func (s *Server) signToken(userID int64, email string, sessionID int64) (string, error) {
+	jti, _, err := generateTokenPair(16)
+	if err != nil {
+		return "", err
+	}
 	claims := jwt.MapClaims{
 		"sub":   userID,
 		"email": email,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+		"sid":   sessionID,
+		"jti":   jti,
+		"exp":   time.Now().Add(accessTokenTTL).Unix(),
 		"iat":   time.Now().Unix(),
 	}
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtSecret)
 }
+
+// startSession opens a new session (token family) for the user and returns an
+// access token plus the first refresh token of the family.
+func (s *Server) startSession(ctx context.Context, r *http.Request, userID int64, email string) (map[string]any, error) {
+	refreshToken, refreshHash, err := generateTokenPair(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO auth_sessions(user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, userID, r.UserAgent(), clientIP(r), now.Add(refreshTokenTTL)).Scan(&sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO auth_refresh_tokens(session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, sessionID, refreshHash, now.Add(refreshTokenTTL)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.signToken(userID, email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(accessToken, refreshToken), nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new pair and returns the
+// user and the farm the session last had active. Presenting a token that was
+// already rotated revokes the whole session, since it means the token was
+// copied and used by someone else.
+func (s *Server) rotateRefreshToken(ctx context.Context, refreshToken string) (int64, int64, map[string]any, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var tokenID, sessionID, userID int64
+	var farmID *int64
+	var usedAt, revokedAt *time.Time
+	var tokenExpires, sessionExpires time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT t.id, t.session_id, t.used_at, t.expires_at, s.user_id, s.revoked_at, s.expires_at, s.farm_id
+		FROM auth_refresh_tokens t
+		JOIN auth_sessions s ON s.id = t.session_id
+		WHERE t.token_hash = $1
+		FOR UPDATE OF t, s
+	`, hashToken(refreshToken)).Scan(&tokenID, &sessionID, &usedAt, &tokenExpires, &userID, &revokedAt, &sessionExpires, &farmID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, 0, nil, errInvalidRefreshToken
+		}
+		return 0, 0, nil, err
+	}
+
+	revoke, err := checkRefreshToken(usedAt, revokedAt, tokenExpires, sessionExpires, time.Now())
+	if revoke {
+		if _, err := tx.Exec(ctx, `
+			UPDATE auth_sessions
+			SET revoked_at = NOW(), revoked_reason = 'refresh_token_reuse'
+			WHERE id = $1
+		`, sessionID); err != nil {
+			return 0, 0, nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var email, status string
+	var verified bool
+	if err := tx.QueryRow(ctx, `SELECT email, status, email_verified FROM users WHERE id = $1`, userID).Scan(&email, &status, &verified); err != nil {
+		return 0, 0, nil, errInvalidRefreshToken
+	}
+	if status != "active" || !verified {
+		return 0, 0, nil, errInvalidRefreshToken
+	}
+
+	nextToken, nextHash, err := generateTokenPair(32)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE auth_refresh_tokens SET used_at = NOW() WHERE id = $1`, tokenID); err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO auth_refresh_tokens(session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, sessionID, nextHash, sessionExpires); err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE auth_sessions SET last_used_at = NOW() WHERE id = $1`, sessionID); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, nil, err
+	}
+
+	accessToken, err := s.signToken(userID, email, sessionID)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if farmID == nil {
+		return userID, 0, tokenResponse(accessToken, nextToken), nil
+	}
+	return userID, *farmID, tokenResponse(accessToken, nextToken), nil
+}
+
+// checkRefreshToken returns nil when a refresh token may be rotated. A token
+// that was already used is refused as reused, and revoke reports whether its
+// session is still open and must be revoked now.
+func checkRefreshToken(usedAt, revokedAt *time.Time, tokenExpires, sessionExpires, now time.Time) (revoke bool, err error) {
+	if usedAt != nil {
+		return revokedAt == nil, errRefreshTokenReused
+	}
+	if revokedAt != nil || now.After(tokenExpires) || now.After(sessionExpires) {
+		return false, errInvalidRefreshToken
+	}
+	return false, nil
+}
+
+// sessionFarm reports whether the session is still usable and which farm it
+// last had active (0 when none was chosen yet).
+func (s *Server) sessionFarm(ctx context.Context, sessionID, userID int64) (int64, bool) {
+	var active bool
+	var farmID *int64
+	err := s.db.QueryRow(ctx, `
+		SELECT revoked_at IS NULL AND expires_at > NOW(), farm_id
+		FROM auth_sessions
+		WHERE id = $1 AND user_id = $2
+	`, sessionID, userID).Scan(&active, &farmID)
+	if err != nil || !active {
+		return 0, false
+	}
+	if farmID == nil {
+		return 0, true
+	}
+	return *farmID, true
+}
+
+func (s *Server) setSessionFarm(ctx context.Context, sessionID, farmID int64) error {
+	_, err := s.db.Exec(ctx, `UPDATE auth_sessions SET farm_id = $2 WHERE id = $1`, sessionID, farmID)
+	return err
+}
+
+func (s *Server) revokeSession(ctx context.Context, sessionID, userID int64, reason string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE auth_sessions
+		SET revoked_at = NOW(), revoked_reason = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID, reason)
+	return err
+}
+
+func (s *Server) revokeUserSessions(ctx context.Context, userID int64, reason string) (int64, error) {
+	res, err := s.db.Exec(ctx, `
+		UPDATE auth_sessions
+		SET revoked_at = NOW(), revoked_reason = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, reason)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+func tokenResponse(accessToken, refreshToken string) map[string]any {
+	return map[string]any{
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int64(accessTokenTTL.Seconds()),
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCheckRefreshToken(t *testing.T) {
+	now := time.Date(2026, 3, 12, 8, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Minute)
+	later := now.Add(time.Hour)
+	tests := []struct {
+		name           string
+		usedAt         *time.Time
+		revokedAt      *time.Time
+		tokenExpires   time.Time
+		sessionExpires time.Time
+		revoke         bool
+		err            error
+	}{
+		{"fresh token rotates", nil, nil, later, later, false, nil},
+		{"reused token revokes its session", &earlier, nil, later, later, true, errRefreshTokenReused},
+		{"reuse on a revoked session", &earlier, &earlier, later, later, false, errRefreshTokenReused},
+		// Reuse is reported even once the token has expired, so the family stays dead.
+		{"reused expired token", &earlier, nil, earlier, later, true, errRefreshTokenReused},
+		{"revoked session", nil, &earlier, later, later, false, errInvalidRefreshToken},
+		{"expired token", nil, nil, earlier, later, false, errInvalidRefreshToken},
+		{"expired session", nil, nil, later, earlier, false, errInvalidRefreshToken},
+	}
+	for _, tt := range tests {
+		revoke, err := checkRefreshToken(tt.usedAt, tt.revokedAt, tt.tokenExpires, tt.sessionExpires, now)
+		if revoke != tt.revoke || !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, revoke, err, tt.revoke, tt.err)
+		}
+	}
+}
+
+func TestSignToken(t *testing.T) {
+	s := &Server{jwtSecret: []byte("test-secret")}
+	signed, err := s.signToken(7, "owner@example.com", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (any, error) { return s.jwtSecret, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if sid, err := parseTokenUserID(claims["sid"]); err != nil || sid != 42 {
+		t.Errorf("sid = %v, want 42", claims["sid"])
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp.After(time.Now().Add(accessTokenTTL+time.Second)) {
+		t.Errorf("access token expires at %v, want within %v", exp, accessTokenTTL)
+	}
+}
+
+// Tokens from before sessions existed, or signed with another secret, never
+// reach the session lookup.
+func TestAuthRequiredRejectsTokens(t *testing.T) {
+	s := &Server{jwtSecret: []byte("test-secret")}
+	sign := func(secret string, claims jwt.MapClaims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+	tests := []struct {
+		name   string
+		header string
+		err    string
+	}{
+		{"no header", "", "missing bearer token"},
+		{"other secret", "Bearer " + sign("other", jwt.MapClaims{"sub": 7, "sid": 1, "exp": exp}), "invalid token"},
+		{"expired", "Bearer " + sign("test-secret", jwt.MapClaims{"sub": 7, "sid": 1, "exp": time.Now().Add(-time.Minute).Unix()}), "invalid token"},
+		{"without session", "Bearer " + sign("test-secret", jwt.MapClaims{"sub": 7, "exp": exp}), "invalid token session"},
+	}
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { t.Error("handler reached") })
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/animals", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		s.authRequired(next).ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"`+tt.err+`"`) {
+			t.Errorf("%s: %d %s, want 401 %q", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.err)
+		}
+	}
+}
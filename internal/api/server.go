@@ -5,6 +5,10 @@ import (
 	"strings"
 	"time"
 
+	"farmpro/backend/internal/etims"
+	"farmpro/backend/internal/mpesa"
+	"farmpro/backend/internal/sms"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -15,11 +19,23 @@ type Server struct {
 	allowedOrigins  map[string]struct{}
 	loginLimiter    *attemptLimiter
 	registerLimiter *attemptLimiter
+	resetLimiter    *attemptLimiter
 	mailer          *smtpMailer
 	frontendBaseURL string
 	kraPIN          string
 	location        *time.Location
 	mlBaseURL       string
+
+	etims             etims.Client
+	etimsBranchID     string
+	etimsDeviceSerial string
+
+	mpesa              mpesa.Client
+	mpesaCallbackURL   string
+	mpesaCallbackToken string
+
+	sms              sms.Sender
+	smsCallbackToken string
 }
 
 type authContextKey string
@@ -27,8 +43,11 @@ type authContextKey string
 const userIDContextKey authContextKey = "user_id"
 const userRoleContextKey authContextKey = "user_role"
 const userPermissionsContextKey authContextKey = "user_permissions"
+const sessionIDContextKey authContextKey = "session_id"
+const farmIDContextKey authContextKey = "farm_id"
+const grantedPermissionContextKey authContextKey = "granted_permission"
 
-func NewServer(db *pgxpool.Pool, jwtSecret string, corsAllowedOrigins []string, mailer *smtpMailer, frontendBaseURL string, appTimezone string, kraPIN string, mlBaseURL string) *Server {
+func NewServer(db *pgxpool.Pool, jwtSecret string, corsAllowedOrigins []string, mailer *smtpMailer, frontendBaseURL string, appTimezone string, kraPIN string, mlBaseURL string, etimsClient etims.Client, etimsBranchID string, etimsDeviceSerial string, mpesaClient mpesa.Client, mpesaCallbackURL string, mpesaCallbackToken string, smsSender sms.Sender, smsCallbackToken string) *Server {
 	allowedOrigins := make(map[string]struct{}, len(corsAllowedOrigins))
 	allowAnyOrigin := false
 	for _, raw := range corsAllowedOrigins {
@@ -54,11 +73,23 @@ func NewServer(db *pgxpool.Pool, jwtSecret string, corsAllowedOrigins []string,
 		allowedOrigins:  allowedOrigins,
 		loginLimiter:    newAttemptLimiter(12, time.Minute),
 		registerLimiter: newAttemptLimiter(5, 15*time.Minute),
+		resetLimiter:    newAttemptLimiter(3, 15*time.Minute),
 		mailer:          mailer,
 		frontendBaseURL: strings.TrimRight(strings.TrimSpace(frontendBaseURL), "/"),
 		kraPIN:          strings.ToUpper(strings.TrimSpace(kraPIN)),
 		location:        loc,
 		mlBaseURL:       strings.TrimRight(strings.TrimSpace(mlBaseURL), "/"),
+
+		etims:             etimsClient,
+		etimsBranchID:     strings.TrimSpace(etimsBranchID),
+		etimsDeviceSerial: strings.TrimSpace(etimsDeviceSerial),
+
+		mpesa:              mpesaClient,
+		mpesaCallbackURL:   strings.TrimRight(strings.TrimSpace(mpesaCallbackURL), "/"),
+		mpesaCallbackToken: strings.TrimSpace(mpesaCallbackToken),
+
+		sms:              smsSender,
+		smsCallbackToken: strings.TrimSpace(smsCallbackToken),
 	}
 }
 
@@ -74,14 +105,64 @@ func (s *Server) Mux() http.Handler {
 	mux.HandleFunc("POST /api/auth/forgot-password", s.handleForgotPassword)
 	mux.HandleFunc("POST /api/auth/reset-password", s.handleResetPassword)
 	mux.HandleFunc("POST /api/auth/verify-email", s.handleVerifyEmail)
+	mux.HandleFunc("POST /api/auth/refresh", s.handleRefreshToken)
+	mux.Handle("POST /api/auth/logout", s.authRequired(http.HandlerFunc(s.handleLogout)))
+	mux.Handle("POST /api/auth/logout-all", s.authRequired(http.HandlerFunc(s.handleLogoutAll)))
 	mux.Handle("GET /api/auth/me", s.authRequired(http.HandlerFunc(s.handleMe)))
+	mux.Handle("GET /api/farms", s.authRequired(http.HandlerFunc(s.handleFarms)))
+	mux.Handle("POST /api/farms", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateFarm), "farms.create")))
+	mux.Handle("POST /api/farms/{id}/switch", s.authRequired(http.HandlerFunc(s.handleSwitchFarm)))
+	mux.Handle("PUT /api/farms/current", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateFarm), "farms.manage")))
+	mux.Handle("GET /api/farms/current/export", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleExportFarm), "farms.manage")))
 
 	mux.Handle("GET /api/dashboard", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDashboard), "dashboard.read")))
 	mux.Handle("GET /api/animals", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimals), "animals.read")))
 	mux.Handle("POST /api/animals", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateAnimal), "animals.write")))
 	mux.Handle("PUT /api/animals/{tagId}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateAnimal), "animals.write")))
 	mux.Handle("DELETE /api/animals/{tagId}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteAnimal), "animals.write")))
+	mux.Handle("GET /api/animals/{tagId}/pedigree", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimalPedigree), "animals.read")))
+	mux.Handle("GET /api/animals/{tagId}/descendants", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimalDescendants), "animals.read")))
+	mux.Handle("GET /api/animals/{tagId}/events", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimalEvents), "animals.read")))
+	mux.Handle("POST /api/animals/{tagId}/events", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateAnimalEvent), "animals.write")))
+	mux.Handle("DELETE /api/animals/{tagId}/events/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteAnimalEvent), "animals.write")))
+	mux.Handle("GET /api/herd", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleHerd), "animals.read")))
+	mux.Handle("GET /api/herd/events", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleHerdEvents), "animals.read")))
+	mux.Handle("GET /api/herd/reasons", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimalEventReasons), "animals.read")))
+	mux.Handle("GET /api/herd/locations", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimalLocations), "animals.read")))
+	mux.Handle("POST /api/herd/locations", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateAnimalLocation), "animals.write")))
+	mux.Handle("PUT /api/herd/locations/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateAnimalLocation), "animals.write")))
+	mux.Handle("DELETE /api/herd/locations/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteAnimalLocation), "animals.write")))
 	mux.Handle("GET /api/health/upcoming", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpcomingVaccinations), "health.read")))
+	mux.Handle("GET /api/health/tasks", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleHealthTasks), "health.read")))
+	mux.Handle("POST /api/health/tasks/{id}/complete", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCompleteHealthTask), "health.write")))
+	mux.Handle("POST /api/health/tasks/{id}/skip", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleSkipHealthTask), "health.write")))
+	mux.Handle("GET /api/health/protocols", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleHealthProtocols), "health.read")))
+	mux.Handle("POST /api/health/protocols", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateHealthProtocol), "health.write")))
+	mux.Handle("PUT /api/health/protocols/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateHealthProtocol), "health.write")))
+	mux.Handle("DELETE /api/health/protocols/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteHealthProtocol), "health.write")))
+	mux.Handle("POST /api/health/protocols/{id}/assign", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAssignHealthProtocol), "health.write")))
+	mux.Handle("DELETE /api/health/protocols/{id}/animals/{tagId}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUnassignHealthProtocol), "health.write")))
+	mux.Handle("GET /api/health/cases", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDiseaseCases), "health.read")))
+	mux.Handle("POST /api/health/cases", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateDiseaseCase), "health.write")))
+	mux.Handle("GET /api/health/cases/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDiseaseCase), "health.read")))
+	mux.Handle("PUT /api/health/cases/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateDiseaseCase), "health.write")))
+	mux.Handle("DELETE /api/health/cases/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteDiseaseCase), "health.write")))
+	mux.Handle("GET /api/health/outbreaks", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleOutbreaks), "health.read")))
+	mux.Handle("GET /api/health/quarantine", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleQuarantineGroups), "health.read")))
+	mux.Handle("POST /api/health/quarantine", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateQuarantineGroup), "health.write")))
+	mux.Handle("PUT /api/health/quarantine/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateQuarantineGroup), "health.write")))
+	mux.Handle("DELETE /api/health/quarantine/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteQuarantineGroup), "health.write")))
+	mux.Handle("POST /api/health/quarantine/{id}/animals", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleQuarantineAnimals), "health.write")))
+	mux.Handle("DELETE /api/health/quarantine/{id}/animals/{tagId}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReleaseQuarantineAnimal), "health.write")))
+	mux.Handle("GET /api/health/withdrawals", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleWithdrawals), "health.read")))
+	mux.Handle("GET /api/medicines", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMedicines), "health.read")))
+	mux.Handle("POST /api/medicines", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateMedicine), "health.write")))
+	mux.Handle("PUT /api/medicines/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateMedicine), "health.write")))
+	mux.Handle("DELETE /api/medicines/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteMedicine), "health.write")))
+	mux.Handle("GET /api/medicines/{id}/batches", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMedicineBatches), "health.read")))
+	mux.Handle("POST /api/medicines/{id}/batches", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateMedicineBatch), "health.write")))
+	mux.Handle("DELETE /api/medicine-batches/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteMedicineBatch), "health.write")))
+	mux.Handle("POST /api/medicine-batches/{id}/movements", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateMedicineStockMovement), "health.write")))
 	mux.Handle("GET /api/health/records", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleHealthRecords), "health.read")))
 	mux.Handle("POST /api/health/records", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateHealthRecord), "health.write")))
 	mux.Handle("PUT /api/health/records/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateHealthRecord), "health.write")))
@@ -99,13 +180,44 @@ func (s *Server) Mux() http.Handler {
 	mux.Handle("GET /api/production/summary", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleProductionSummary), "production.read")))
 	mux.Handle("GET /api/production/logs", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleProductionLogs), "production.read")))
 	mux.Handle("POST /api/production/logs", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateProductionLog), "production.create")))
-	mux.Handle("PUT /api/production/logs/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateProductionLog), "production.manage")))
-	mux.Handle("DELETE /api/production/logs/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteProductionLog), "production.manage")))
+	mux.Handle("PUT /api/production/logs/{date}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateProductionLog), "production.manage")))
+	mux.Handle("DELETE /api/production/logs/{date}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteProductionLog), "production.manage")))
+	mux.Handle("GET /api/production/records", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleProductionRecords), "production.read")))
+	mux.Handle("POST /api/production/records", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateProductionRecord), "production.create")))
+	mux.Handle("PUT /api/production/records/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateProductionRecord), "production.manage")))
+	mux.Handle("DELETE /api/production/records/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteProductionRecord), "production.manage")))
+	mux.Handle("GET /api/production/animals", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAnimalYields), "production.read")))
+	mux.Handle("GET /api/prices", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handlePrices), "production.read")))
+	mux.Handle("POST /api/prices", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreatePrice), "prices.manage")))
+	mux.Handle("PUT /api/prices/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdatePrice), "prices.manage")))
+	mux.Handle("DELETE /api/prices/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeletePrice), "prices.manage")))
+	mux.Handle("POST /api/prices/revalue", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleRevalueProduction), "prices.manage")))
 	mux.Handle("GET /api/expenses/summary", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleExpensesSummary), "expenses.read")))
 	mux.Handle("GET /api/expenses", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleExpenses), "expenses.read")))
 	mux.Handle("POST /api/expenses", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateExpense), "expenses.write")))
 	mux.Handle("PUT /api/expenses/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateExpense), "expenses.write")))
 	mux.Handle("DELETE /api/expenses/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteExpense), "expenses.write")))
+	mux.Handle("GET /api/suppliers", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleSuppliers), "expenses.read")))
+	mux.Handle("GET /api/suppliers/spend", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleSupplierSpend), "expenses.read")))
+	mux.Handle("POST /api/suppliers", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateSupplier), "expenses.write")))
+	mux.Handle("PUT /api/suppliers/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateSupplier), "expenses.write")))
+	mux.Handle("DELETE /api/suppliers/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteSupplier), "expenses.write")))
+	mux.Handle("GET /api/purchase-orders", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handlePurchaseOrders), "expenses.read")))
+	mux.Handle("GET /api/purchase-orders/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handlePurchaseOrder), "expenses.read")))
+	mux.Handle("POST /api/purchase-orders", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreatePurchaseOrder), "expenses.write")))
+	mux.Handle("PUT /api/purchase-orders/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdatePurchaseOrder), "expenses.write")))
+	mux.Handle("DELETE /api/purchase-orders/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeletePurchaseOrder), "expenses.write")))
+	mux.Handle("POST /api/purchase-orders/{id}/cancel", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCancelPurchaseOrder), "expenses.write")))
+	mux.Handle("POST /api/purchase-orders/{id}/receipts", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReceivePurchaseOrder), "expenses.write")))
+	mux.Handle("DELETE /api/goods-receipts/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteGoodsReceipt), "expenses.write")))
+	mux.Handle("GET /api/units", s.authRequired(http.HandlerFunc(s.handleUnits)))
+	mux.Handle("GET /api/feeding/stock", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleFeedStock), "feeding.read")))
+	mux.Handle("POST /api/feeding/stock/items", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateFeedItem), "feeding.write")))
+	mux.Handle("PUT /api/feeding/stock/items/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateFeedItem), "feeding.write")))
+	mux.Handle("DELETE /api/feeding/stock/items/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteFeedItem), "feeding.write")))
+	mux.Handle("GET /api/feeding/stock/items/{id}/movements", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleFeedItemMovements), "feeding.read")))
+	mux.Handle("POST /api/feeding/stock/movements", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateFeedStockMovement), "feeding.write")))
+	mux.Handle("DELETE /api/feeding/stock/movements/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteFeedStockMovement), "feeding.write")))
 	mux.Handle("GET /api/feeding/summary", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleFeedingSummary), "feeding.read")))
 	mux.Handle("GET /api/feeding", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleFeeding), "feeding.read")))
 	mux.Handle("POST /api/feeding", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateFeedingRecord), "feeding.write")))
@@ -124,21 +236,82 @@ func (s *Server) Mux() http.Handler {
 	mux.Handle("POST /api/ml/train", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMLTrain), "dashboard.read")))
 	mux.Handle("GET /api/sales/summary", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleSalesSummary), "sales.read")))
 	mux.Handle("GET /api/sales", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleSales), "sales.read")))
-	mux.Handle("POST /api/sales", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateSale), "sales.write")))
-	mux.Handle("PUT /api/sales/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateSale), "sales.write")))
-	mux.Handle("DELETE /api/sales/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteSale), "sales.write")))
+	mux.Handle("GET /api/invoices", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleInvoices), "sales.read")))
+	mux.Handle("GET /api/invoices/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleInvoice), "sales.read")))
+	mux.Handle("POST /api/invoices", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateInvoice), "sales.write")))
+	mux.Handle("PUT /api/invoices/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateInvoice), "sales.write")))
+	mux.Handle("DELETE /api/invoices/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteInvoice), "sales.write")))
+	mux.Handle("POST /api/invoices/{id}/credit-notes", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateCreditNote), "sales.write")))
+	mux.Handle("POST /api/invoices/{id}/payments", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreatePayment), "sales.write")))
+	mux.Handle("GET /api/payments", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handlePayments), "sales.read")))
+	mux.Handle("DELETE /api/payments/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeletePayment), "sales.write")))
+	mux.Handle("POST /api/invoices/{id}/mpesa/stk-push", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMpesaSTKPush), "sales.write")))
+	mux.Handle("GET /api/mpesa/stk-requests/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMpesaSTKRequest), "sales.read")))
+	mux.Handle("GET /api/mpesa/transactions", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMpesaTransactions), "sales.read")))
+	mux.Handle("POST /api/mpesa/transactions/{id}/match", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMatchMpesaTransaction), "sales.write")))
+	mux.Handle("POST /api/mpesa/transactions/{id}/ignore", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleIgnoreMpesaTransaction), "sales.write")))
+	mux.Handle("GET /api/mpesa/account", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleMpesaAccount), "farms.manage")))
+	mux.Handle("PUT /api/mpesa/account", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateMpesaAccount), "farms.manage")))
+	mux.HandleFunc("POST /api/payments/callbacks/{token}/stk", s.handleMpesaSTKCallback)
+	mux.HandleFunc("POST /api/payments/callbacks/{token}/c2b/validation", s.handleMpesaC2BValidation)
+	mux.HandleFunc("POST /api/payments/callbacks/{token}/c2b/confirmation", s.handleMpesaC2BConfirmation)
+	mux.Handle("GET /api/customers", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCustomers), "sales.read")))
+	mux.Handle("POST /api/customers", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateCustomer), "sales.write")))
+	mux.Handle("PUT /api/customers/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateCustomer), "sales.write")))
+	mux.Handle("DELETE /api/customers/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteCustomer), "sales.write")))
+	mux.Handle("GET /api/customers/{id}/statement", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCustomerStatement), "sales.read")))
+	mux.Handle("GET /api/receivables/aging", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReceivablesAging), "sales.read")))
+	mux.Handle("GET /api/notifications", s.authRequired(http.HandlerFunc(s.handleNotifications)))
+	mux.Handle("GET /api/notifications/unread-count", s.authRequired(http.HandlerFunc(s.handleUnreadNotificationCount)))
+	mux.Handle("POST /api/notifications/{id}/read", s.authRequired(http.HandlerFunc(s.handleMarkNotificationRead)))
+	mux.Handle("POST /api/notifications/read-all", s.authRequired(http.HandlerFunc(s.handleMarkAllNotificationsRead)))
+	mux.Handle("GET /api/notifications/preferences", s.authRequired(http.HandlerFunc(s.handleNotificationPreferences)))
+	mux.Handle("PUT /api/notifications/preferences", s.authRequired(http.HandlerFunc(s.handleUpdateNotificationPreferences)))
+	mux.HandleFunc("POST /api/sms/callbacks/{token}/delivery", s.handleSMSDeliveryReport)
+	mux.HandleFunc("POST /api/sms/callbacks/{token}/opt-out", s.handleSMSOptOutCallback)
+	mux.Handle("GET /api/sms/opt-out", s.authRequired(http.HandlerFunc(s.handleSMSOptOut)))
+	mux.Handle("PUT /api/sms/opt-out", s.authRequired(http.HandlerFunc(s.handleUpdateSMSOptOut)))
+	mux.Handle("GET /api/sms/messages", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleSMSMessages), "farms.manage")))
+	mux.Handle("GET /api/alert-rules", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAlertRules), "alerts.manage")))
+	mux.Handle("POST /api/alert-rules", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateAlertRule), "alerts.manage")))
+	mux.Handle("PUT /api/alert-rules/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateAlertRule), "alerts.manage")))
+	mux.Handle("DELETE /api/alert-rules/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteAlertRule), "alerts.manage")))
 	mux.Handle("GET /api/reports/stats", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReportStats), "reports.read")))
 	mux.Handle("GET /api/reports", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReports), "reports.read")))
 	mux.Handle("POST /api/reports/generate", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleGenerateReport), "reports.generate")))
 	mux.Handle("GET /api/reports/{id}/download", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDownloadReport), "reports.read")))
+	mux.Handle("GET /api/reports/schedules", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReportSchedules), "reports.read")))
+	mux.Handle("POST /api/reports/schedules", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateReportSchedule), "reports.generate")))
+	mux.Handle("PUT /api/reports/schedules/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateReportSchedule), "reports.generate")))
+	mux.Handle("DELETE /api/reports/schedules/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteReportSchedule), "reports.generate")))
+	mux.Handle("GET /api/reports/schedules/{id}/runs", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleReportScheduleRuns), "reports.read")))
+	mux.Handle("POST /api/reports/schedules/{id}/run", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleRunReportSchedule), "reports.generate")))
 	mux.Handle("GET /api/etims/receipts", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsReceipts), "etims.manage")))
-	mux.Handle("POST /api/etims/receipts/generate/{saleId}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsGenerateReceipt), "etims.manage")))
+	mux.Handle("POST /api/etims/receipts/generate/{invoiceId}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsGenerateReceipt), "etims.manage")))
 	mux.Handle("GET /api/etims/receipts/{id}/download", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsDownloadReceipt), "etims.manage")))
+	mux.Handle("POST /api/etims/receipts/retry/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsRetryReceipt), "etims.manage")))
+	mux.Handle("GET /api/etims/device", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsDevice), "etims.manage")))
+	mux.Handle("POST /api/etims/device/init", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleEtimsInitDevice), "etims.manage")))
 	mux.Handle("GET /api/users/stats", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUserStats), "users.read")))
 	mux.Handle("GET /api/users", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUsers), "users.read")))
 	mux.Handle("POST /api/users", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateUser), "users.manage")))
+	mux.Handle("POST /api/users/members", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAddFarmMember), "users.manage")))
 	mux.Handle("PUT /api/users/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateUser), "users.manage")))
 	mux.Handle("DELETE /api/users/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteUser), "users.manage")))
+	mux.Handle("GET /api/permissions", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handlePermissions), "roles.read")))
+	mux.Handle("GET /api/roles", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleRoles), "roles.read")))
+	mux.Handle("POST /api/roles", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleCreateRole), "roles.manage")))
+	mux.Handle("PUT /api/roles/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleUpdateRole), "roles.manage")))
+	mux.Handle("DELETE /api/roles/{id}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDeleteRole), "roles.manage")))
+	mux.Handle("POST /api/roles/{id}/permissions/{key}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAttachRolePermission), "roles.manage")))
+	mux.Handle("DELETE /api/roles/{id}/permissions/{key}", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleDetachRolePermission), "roles.manage")))
+	mux.Handle("GET /api/audit", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleAuditEvents), "audit.read")))
+	mux.Handle("GET /api/import/{kind}/template", s.authRequired(http.HandlerFunc(s.handleImportTemplate)))
+	mux.Handle("POST /api/import/animals", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleImportAnimals), "animals.write")))
+	mux.Handle("POST /api/import/health", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleImportHealthRecords), "health.write")))
+	mux.Handle("POST /api/import/feeding", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleImportFeedingRecords), "feeding.write")))
+	mux.Handle("POST /api/import/expenses", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleImportExpenses), "expenses.write")))
+	mux.Handle("POST /api/import/production", s.authRequired(s.permissionRequired(http.HandlerFunc(s.handleImportProductionLogs), "production.create")))
 
 	return s.withCORS(mux)
 }
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"farmpro/backend/internal/sms"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// smsTemplates are kept under 160 characters once filled in, so each message
+// is billed as one. alert covers the notification kinds without their own.
+var smsTemplates = map[string]*template.Template{
+	"vaccination_due": template.Must(template.New("vaccination_due").Parse(
+		`FarmPro: {{.treatment}} for {{.tag}} is due on {{.date}}.`)),
+	"birth_expected": template.Must(template.New("birth_expected").Parse(
+		`FarmPro: {{.tag}} is expected to give birth on {{.date}}. Prepare the birthing area.`)),
+	"reset_code": template.Must(template.New("reset_code").Parse(
+		`Your FarmPro password reset code is {{.code}}. It expires in 15 minutes. Do not share it with anyone.`)),
+	"alert": template.Must(template.New("alert").Parse(
+		`FarmPro: {{.title}}. {{.body}}`)),
+}
+
+// smsSecretFields are template values that must not outlive the send. The
+// copy of the message kept in sms_messages shows them masked.
+var smsSecretFields = map[string][]string{
+	"reset_code": {"code"},
+}
+
+type smsRequest struct {
+	farmID         int64
+	userID         int64
+	notificationID int64
+	phone          string
+	template       string
+	data           map[string]string
+}
+
+// sendSMS renders and sends req, keeping a record of the message. It returns
+// the status the message ended in (sent, failed, opted_out or skipped when
+// it never reached the gateway) and, unless it was sent, why not.
+func (s *Server) sendSMS(ctx context.Context, req smsRequest) (string, string) {
+	if s.sms == nil {
+		return "skipped", "SMS is not configured on this server"
+	}
+	phone, ok := normalizeKenyaPhone(req.phone)
+	if !ok || phone == "" {
+		return "skipped", "no valid phone number"
+	}
+	text, stored, err := renderSMS(req.template, req.data)
+	if err != nil {
+		return "skipped", "render message: " + err.Error()
+	}
+
+	var optedOut bool
+	_ = s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sms_opt_outs WHERE phone = $1)`, phone).Scan(&optedOut)
+	status := "queued"
+	if optedOut {
+		status = "opted_out"
+	}
+	var id int64
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO sms_messages(farm_id, user_id, notification_id, phone, template, body, status)
+		VALUES (NULLIF($1, 0), NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, $7)
+		RETURNING id
+	`, req.farmID, req.userID, req.notificationID, phone, req.template, stored, status).Scan(&id)
+	if err != nil {
+		return "failed", "record message: " + err.Error()
+	}
+	if optedOut {
+		return "opted_out", "recipient has opted out of text messages"
+	}
+
+	receipt, err := s.sms.Send(ctx, sms.Message{To: phone, Text: text})
+	status, reason := sms.StatusSent, ""
+	switch {
+	case errors.Is(err, sms.ErrOptedOut):
+		status, reason = "opted_out", err.Error()
+		s.recordSMSOptOut(ctx, phone, "gateway")
+	case err != nil:
+		status, reason = sms.StatusFailed, err.Error()
+	}
+	if _, err := s.db.Exec(ctx, `
+		UPDATE sms_messages
+		SET status = $1, failure_reason = $2, provider_message_id = $3, cost = $4,
+			currency = COALESCE(NULLIF($5, ''), currency), updated_at = NOW()
+		WHERE id = $6
+	`, status, reason, receipt.MessageID, receipt.Cost, receipt.Currency, id); err != nil {
+		log.Printf("sms: update message %d: %v", id, err)
+	}
+	return status, reason
+}
+
+// renderSMS fills in the named template, falling back to alert. It returns
+// the text to send and the copy to keep, with any secret values masked.
+func renderSMS(name string, data map[string]string) (string, string, error) {
+	tmpl, ok := smsTemplates[name]
+	if !ok {
+		tmpl = smsTemplates["alert"]
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, data); err != nil {
+		return "", "", err
+	}
+	secrets := smsSecretFields[name]
+	if len(secrets) == 0 {
+		return text.String(), text.String(), nil
+	}
+	masked := make(map[string]string, len(data))
+	for k, v := range data {
+		masked[k] = v
+	}
+	for _, k := range secrets {
+		masked[k] = "******"
+	}
+	var stored strings.Builder
+	if err := tmpl.Execute(&stored, masked); err != nil {
+		return "", "", err
+	}
+	return text.String(), stored.String(), nil
+}
+
+func (s *Server) recordSMSOptOut(ctx context.Context, phone, source string) {
+	if _, err := s.db.Exec(ctx, `
+		INSERT INTO sms_opt_outs(phone, source) VALUES ($1, $2)
+		ON CONFLICT (phone) DO NOTHING
+	`, phone, source); err != nil {
+		log.Printf("sms: record opt-out for %s: %v", phone, err)
+	}
+}
+
+// smsCallbackAuthorized checks the shared token in the callback path; like
+// Daraja, the gateway does not sign what it posts.
+func (s *Server) smsCallbackAuthorized(r *http.Request) bool {
+	token := r.PathValue("token")
+	return s.smsCallbackToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.smsCallbackToken)) == 1
+}
+
+func (s *Server) handleSMSDeliveryReport(w http.ResponseWriter, r *http.Request) {
+	if !s.smsCallbackAuthorized(r) || s.sms == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	report, err := sms.ParseDeliveryReport(r.PostForm)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	status := report.Status
+	if report.OptedOut {
+		status = "opted_out"
+	}
+	// Reports can arrive out of order; one that is already final stays.
+	var phone string
+	err = s.db.QueryRow(ctx, `
+		UPDATE sms_messages
+		SET status = $1, failure_reason = $2, updated_at = NOW()
+		WHERE provider_message_id = $3 AND status IN ('queued', 'sent')
+		RETURNING phone
+	`, status, report.FailureReason, report.MessageID).Scan(&phone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record delivery report"})
+		return
+	}
+	if report.OptedOut && phone != "" {
+		s.recordSMSOptOut(ctx, phone, "gateway")
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleSMSOptOutCallback(w http.ResponseWriter, r *http.Request) {
+	if !s.smsCallbackAuthorized(r) || s.sms == nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	raw, err := sms.ParseOptOut(r.PostForm)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	phone, ok := normalizeKenyaPhone(raw)
+	if !ok || phone == "" {
+		phone = raw
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	s.recordSMSOptOut(ctx, phone, "gateway")
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleSMSOptOut reports whether the caller's phone number receives text
+// messages.
+func (s *Server) handleSMSOptOut(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var phone string
+	var optedOut bool
+	err := s.db.QueryRow(ctx, `
+		SELECT u.phone, EXISTS (SELECT 1 FROM sms_opt_outs o WHERE o.phone = u.phone AND u.phone <> '')
+		FROM users u WHERE u.id = $1
+	`, userID).Scan(&phone, &optedOut)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load text message settings"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"phone": phone, "optedOut": optedOut})
+}
+
+func (s *Server) handleUpdateSMSOptOut(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		OptOut bool `json:"optOut"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	userID, _ := r.Context().Value(userIDContextKey).(int64)
+
+	var phone string
+	if err := s.db.QueryRow(ctx, `SELECT phone FROM users WHERE id = $1`, userID).Scan(&phone); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load text message settings"})
+		return
+	}
+	if phone == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "add a phone number to your account first"})
+		return
+	}
+	var err error
+	if in.OptOut {
+		_, err = s.auditedWrite(ctx, r, auditCreate, "sms_opt_outs", 0, func(tx pgx.Tx) (int64, error) {
+			var id int64
+			err := tx.QueryRow(ctx, `
+				INSERT INTO sms_opt_outs(phone, source) VALUES ($1, 'user')
+				ON CONFLICT (phone) DO NOTHING
+				RETURNING id
+			`, phone).Scan(&id)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+			return id, err
+		})
+	} else {
+		var id int64
+		if err = s.db.QueryRow(ctx, `SELECT id FROM sms_opt_outs WHERE phone = $1`, phone).Scan(&id); err == nil {
+			_, err = s.auditedWrite(ctx, r, auditDelete, "sms_opt_outs", id, func(tx pgx.Tx) (int64, error) {
+				res, err := tx.Exec(ctx, `DELETE FROM sms_opt_outs WHERE id = $1`, id)
+				if err != nil || res.RowsAffected() == 0 {
+					return 0, err
+				}
+				return id, nil
+			})
+		} else if errors.Is(err, pgx.ErrNoRows) {
+			err = nil
+		}
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save text message settings"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "phone": phone, "optedOut": in.OptOut})
+}
+
+// handleSMSMessages lists the farm's text messages with what they cost.
+func (s *Server) handleSMSMessages(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	offset := (page - 1) * pageSize
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+
+	var total, delivered, failed int64
+	var cost float64
+	_ = s.db.QueryRow(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'delivered'),
+			COUNT(*) FILTER (WHERE status IN ('failed', 'opted_out')),
+			COALESCE(SUM(cost), 0)::float8
+		FROM sms_messages
+		WHERE farm_id = $1 AND ($2 = '' OR status = $2)
+	`, farmID, status).Scan(&total, &delivered, &failed, &cost)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, phone, template, body, status, failure_reason, cost::float8, currency, created_at, updated_at
+		FROM sms_messages
+		WHERE farm_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, farmID, status, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load text messages"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var phone, tmpl, body, msgStatus, reason, currency string
+		var msgCost float64
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&id, &phone, &tmpl, &body, &msgStatus, &reason, &msgCost, &currency, &createdAt, &updatedAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse text messages"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":            id,
+			"phone":         phone,
+			"template":      tmpl,
+			"body":          body,
+			"status":        msgStatus,
+			"failureReason": reason,
+			"cost":          msgCost,
+			"currency":      currency,
+			"createdAt":     createdAt.Format("2006-01-02T15:04:05"),
+			"updatedAt":     updatedAt.Format("2006-01-02T15:04:05"),
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+		"summary": map[string]any{
+			"delivered": delivered,
+			"failed":    failed,
+			"cost":      cost,
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSMS(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		data         map[string]string
+		text, stored string
+	}{
+		{
+			name:     "reset code is masked in the stored copy",
+			template: "reset_code",
+			data:     map[string]string{"code": "482913"},
+			text:     "Your FarmPro password reset code is 482913. It expires in 15 minutes. Do not share it with anyone.",
+			stored:   "Your FarmPro password reset code is ******. It expires in 15 minutes. Do not share it with anyone.",
+		},
+		{
+			name:     "other templates are stored as sent",
+			template: "vaccination_due",
+			data:     map[string]string{"treatment": "FMD vaccine", "tag": "KE-001", "date": "5 Mar"},
+			text:     "FarmPro: FMD vaccine for KE-001 is due on 5 Mar.",
+			stored:   "FarmPro: FMD vaccine for KE-001 is due on 5 Mar.",
+		},
+		{
+			name:     "unknown kinds fall back to alert",
+			template: "milk_drop",
+			data:     map[string]string{"title": "Milk yield down: KE-001", "body": "Down 25%."},
+			text:     "FarmPro: Milk yield down: KE-001. Down 25%.",
+			stored:   "FarmPro: Milk yield down: KE-001. Down 25%.",
+		},
+	}
+	for _, tt := range tests {
+		text, stored, err := renderSMS(tt.template, tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if text != tt.text || stored != tt.stored {
+			t.Errorf("%s: got %q / %q, want %q / %q", tt.name, text, stored, tt.text, tt.stored)
+		}
+	}
+	data := map[string]string{"code": "482913"}
+	if _, _, err := renderSMS("reset_code", data); err != nil || data["code"] != "482913" {
+		t.Errorf("renderSMS changed the caller's data: %v, %v", data, err)
+	}
+}
+
+func TestSMSSecretFieldsHaveTemplates(t *testing.T) {
+	for name, fields := range smsSecretFields {
+		tmpl, ok := smsTemplates[name]
+		if !ok {
+			t.Errorf("secret fields for %s, which has no template", name)
+			continue
+		}
+		for _, f := range fields {
+			if !strings.Contains(tmpl.Root.String(), "."+f) {
+				t.Errorf("template %s does not use secret field %s", name, f)
+			}
+		}
+	}
+}
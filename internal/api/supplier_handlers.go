@@ -0,0 +1,426 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type supplierInput struct {
+	Name        string `json:"name"`
+	KRAPIN      string `json:"kraPin"`
+	Phone       string `json:"phone"`
+	Email       string `json:"email"`
+	County      string `json:"county"`
+	PaymentDays int    `json:"paymentDays"`
+	Notes       string `json:"notes"`
+	IsActive    *bool  `json:"isActive"`
+}
+
+type supplier struct {
+	id          int64
+	name        string
+	kraPIN      string
+	phone       string
+	email       string
+	county      string
+	paymentDays int
+	notes       string
+	isActive    bool
+}
+
+func normalizeSupplierInput(in supplierInput) (supplier, string) {
+	s := supplier{
+		name:        strings.Join(strings.Fields(in.Name), " "),
+		email:       strings.ToLower(strings.TrimSpace(in.Email)),
+		paymentDays: in.PaymentDays,
+		notes:       strings.TrimSpace(in.Notes),
+		isActive:    in.IsActive == nil || *in.IsActive,
+	}
+	if s.name == "" || customerKey(s.name) == "" {
+		return s, "name is required"
+	}
+	var ok bool
+	if s.kraPIN, ok = normalizeKRAPIN(in.KRAPIN); !ok {
+		return s, "KRA PIN must be valid KRA format (e.g. A012345678Z)"
+	}
+	if s.phone, ok = normalizeKenyaPhone(in.Phone); !ok {
+		return s, "phone must be a valid Kenya number"
+	}
+	if s.county, ok = normalizeCounty(in.County); !ok {
+		return s, "county must be a valid Kenya county"
+	}
+	if s.email != "" && !strings.Contains(s.email, "@") {
+		return s, "email is invalid"
+	}
+	if s.paymentDays < 0 || s.paymentDays > 365 {
+		return s, "paymentDays must be between 0 and 365"
+	}
+	return s, ""
+}
+
+func supplierConflictMessage(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "idx_suppliers_kra_pin"):
+		return "another supplier already has this KRA PIN"
+	case strings.Contains(msg, "duplicate key"):
+		return "a supplier with this name already exists"
+	}
+	return ""
+}
+
+// resolveSupplier finds the supplier an expense, feeding record or order is
+// from: by id when one is given, otherwise by name, creating it when the name
+// is new. Names fold the same way customer names do.
+func resolveSupplier(ctx context.Context, tx pgx.Tx, farmID, supplierID int64, name string) (supplier, error) {
+	var sp supplier
+	const cols = `id, name, payment_days, is_active`
+	var err error
+	name = strings.Join(strings.Fields(name), " ")
+	switch {
+	case supplierID > 0:
+		err = tx.QueryRow(ctx, `SELECT `+cols+` FROM suppliers WHERE id = $1 AND farm_id = $2`, supplierID, farmID).
+			Scan(&sp.id, &sp.name, &sp.paymentDays, &sp.isActive)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return sp, &httpError{http.StatusBadRequest, "supplier not found"}
+		}
+	case customerKey(name) != "":
+		err = tx.QueryRow(ctx, `SELECT `+cols+` FROM suppliers WHERE farm_id = $1 AND name_key = $2`, farmID, customerKey(name)).
+			Scan(&sp.id, &sp.name, &sp.paymentDays, &sp.isActive)
+		if errors.Is(err, pgx.ErrNoRows) {
+			sp = supplier{name: name, isActive: true}
+			err = tx.QueryRow(ctx, `
+				INSERT INTO suppliers(farm_id, name, name_key)
+				VALUES ($1, $2, $3)
+				RETURNING id
+			`, farmID, name, customerKey(name)).Scan(&sp.id)
+		}
+	default:
+		return sp, &httpError{http.StatusBadRequest, "supplierId or supplier name is required"}
+	}
+	return sp, err
+}
+
+func (s *Server) handleSuppliers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	page, pageSize := parsePagination(r)
+	search := parseSearch(r)
+	offset := (page - 1) * pageSize
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	const filter = `
+		s.farm_id = $1 AND (NOT $2 OR s.is_active)
+		AND ($3 = '' OR s.name ILIKE '%' || $3 || '%' OR s.kra_pin ILIKE '%' || $3 || '%' OR s.phone ILIKE '%' || $3 || '%')
+	`
+	var total int64
+	_ = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM suppliers s WHERE `+filter, farmID, activeOnly, search).Scan(&total)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT s.id, s.name, s.kra_pin, s.phone, s.email, s.county, s.payment_days, s.notes, s.is_active,
+		       COALESCE(e.spend, 0), e.last_purchase,
+		       COALESCE((
+		           SELECT SUM(l.total_amount - ROUND(l.unit_price * l.quantity_received, 2))
+		           FROM purchase_orders o
+		           JOIN purchase_order_lines l ON l.purchase_order_id = o.id
+		           WHERE o.supplier_id = s.id AND o.status IN ('open', 'partial')
+		       ), 0)
+		FROM suppliers s
+		LEFT JOIN (
+			SELECT supplier_id, SUM(amount) AS spend, MAX(expense_date) AS last_purchase
+			FROM expenses
+			WHERE supplier_id IS NOT NULL
+			GROUP BY supplier_id
+		) e ON e.supplier_id = s.id
+		WHERE `+filter+`
+		ORDER BY s.name
+		LIMIT $4 OFFSET $5
+	`, farmID, activeOnly, search, pageSize, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load suppliers"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var sp supplier
+		var spend, onOrder float64
+		var lastPurchase *time.Time
+		if err := rows.Scan(
+			&sp.id, &sp.name, &sp.kraPIN, &sp.phone, &sp.email, &sp.county, &sp.paymentDays, &sp.notes, &sp.isActive,
+			&spend, &lastPurchase, &onOrder,
+		); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse suppliers"})
+			return
+		}
+		last := ""
+		if lastPurchase != nil {
+			last = s.formatISODate(*lastPurchase)
+		}
+		out = append(out, map[string]any{
+			"id":           sp.id,
+			"name":         sp.name,
+			"kraPin":       sp.kraPIN,
+			"phone":        sp.phone,
+			"email":        sp.email,
+			"county":       sp.county,
+			"paymentDays":  sp.paymentDays,
+			"notes":        sp.notes,
+			"isActive":     sp.isActive,
+			"spend":        roundCents(spend),
+			"lastPurchase": last,
+			"onOrder":      roundCents(onOrder),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":    out,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func (s *Server) handleCreateSupplier(w http.ResponseWriter, r *http.Request) {
+	var in supplierInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	sp, msg := normalizeSupplierInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	id, err := s.auditedWrite(ctx, r, auditCreate, "suppliers", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO suppliers(farm_id, name, name_key, kra_pin, phone, email, county, payment_days, notes, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id
+		`, farmID, sp.name, customerKey(sp.name), sp.kraPIN, sp.phone, sp.email, sp.county, sp.paymentDays, sp.notes, sp.isActive).Scan(&id)
+		return id, err
+	})
+	if err != nil {
+		if msg := supplierConflictMessage(err); msg != "" {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": msg})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create supplier"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "id": id})
+}
+
+// handleUpdateSupplier edits the supplier record. Expenses and feeding records
+// keep the vendor name they were entered with.
+func (s *Server) handleUpdateSupplier(w http.ResponseWriter, r *http.Request) {
+	supplierID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid supplier id"})
+		return
+	}
+	var in supplierInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	sp, msg := normalizeSupplierInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "suppliers", supplierID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE suppliers
+			SET name = $1, name_key = $2, kra_pin = $3, phone = $4, email = $5, county = $6, payment_days = $7, notes = $8, is_active = $9
+			WHERE id = $10 AND farm_id = $11
+		`, sp.name, customerKey(sp.name), sp.kraPIN, sp.phone, sp.email, sp.county, sp.paymentDays, sp.notes, sp.isActive, supplierID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return supplierID, nil
+	})
+	if err != nil {
+		if msg := supplierConflictMessage(err); msg != "" {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": msg})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update supplier"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "supplier not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleDeleteSupplier(w http.ResponseWriter, r *http.Request) {
+	supplierID, err := parsePathID(r, "id")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid supplier id"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	var used bool
+	_ = s.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM expenses WHERE supplier_id = $1)
+		    OR EXISTS (SELECT 1 FROM feeding_records WHERE supplier_id = $1)
+		    OR EXISTS (SELECT 1 FROM purchase_orders WHERE supplier_id = $1)
+	`, supplierID).Scan(&used)
+	if used {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "this supplier has expenses, feeding records or orders; mark them inactive instead"})
+		return
+	}
+
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "suppliers", supplierID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM suppliers WHERE id = $1 AND farm_id = $2`, supplierID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return supplierID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete supplier"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "supplier not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleSupplierSpend totals expenses by supplier over a period, this year by
+// default. Expenses with no supplier are reported as one unassigned figure.
+func (s *Server) handleSupplierSpend(w http.ResponseWriter, r *http.Request) {
+	from, err := optionalDate(r.URL.Query().Get("from"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+		return
+	}
+	to, err := optionalDate(r.URL.Query().Get("to"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+		return
+	}
+	end := s.now()
+	if to != nil {
+		end = *to
+	}
+	end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(end.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	if from != nil {
+		start = *from
+	}
+	if start.After(end) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must not be after to"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	items, unassigned, err := s.supplierSpend(ctx, farmID, start, end)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load supplier spend"})
+		return
+	}
+	var total float64
+	for _, it := range items {
+		total += it["spend"].(float64)
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"from":       s.formatISODate(start),
+		"to":         s.formatISODate(end),
+		"items":      items,
+		"total":      roundCents(total + unassigned),
+		"unassigned": unassigned,
+	})
+}
+
+// supplierSpend is shared by the spend endpoint and the Suppliers report.
+// Each item's share is of all spend in the period, unassigned included.
+func (s *Server) supplierSpend(ctx context.Context, farmID int64, start, end time.Time) ([]map[string]any, float64, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT s.id, s.name, s.kra_pin, COUNT(*), SUM(e.amount),
+		       COALESCE(SUM(e.amount) FILTER (WHERE e.goods_receipt_line_id IS NOT NULL), 0), MAX(e.expense_date)
+		FROM expenses e
+		JOIN suppliers s ON s.id = e.supplier_id
+		WHERE e.farm_id = $1 AND e.expense_date BETWEEN $2 AND $3
+		GROUP BY s.id
+		ORDER BY SUM(e.amount) DESC, s.name
+	`, farmID, start, end)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := make([]map[string]any, 0)
+	var total float64
+	for rows.Next() {
+		var id, count int64
+		var name, kraPIN string
+		var spend, ordered float64
+		var last time.Time
+		if err := rows.Scan(&id, &name, &kraPIN, &count, &spend, &ordered, &last); err != nil {
+			return nil, 0, err
+		}
+		total += spend
+		items = append(items, map[string]any{
+			"supplierId":   id,
+			"supplier":     name,
+			"kraPin":       kraPIN,
+			"expenses":     count,
+			"spend":        roundCents(spend),
+			"viaOrders":    roundCents(ordered),
+			"lastPurchase": s.formatISODate(last),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var unassigned float64
+	err = s.db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM expenses
+		WHERE farm_id = $1 AND supplier_id IS NULL AND expense_date BETWEEN $2 AND $3
+	`, farmID, start, end).Scan(&unassigned)
+	if err != nil {
+		return nil, 0, err
+	}
+	total += unassigned
+	for _, it := range items {
+		share := 0.0
+		if total > 0 {
+			share = roundCents(it["spend"].(float64) / total * 100)
+		}
+		it["share"] = share
+	}
+	return items, roundCents(unassigned), nil
+}
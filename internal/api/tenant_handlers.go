@@ -0,0 +1,282 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"farmpro/backend/internal/backup"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Server) handleFarms(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDContextKey).(int64)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid auth context"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT f.id, f.name, f.county, f.kra_pin, r.name
+		FROM farm_members fm
+		JOIN farms f ON f.id = fm.farm_id
+		JOIN roles r ON r.id = fm.role_id
+		WHERE fm.user_id = $1 AND fm.status = 'active'
+		ORDER BY f.name, f.id
+	`, userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load farms"})
+		return
+	}
+	defer rows.Close()
+
+	out := make([]map[string]any, 0)
+	for rows.Next() {
+		var id int64
+		var name, county, kraPIN, role string
+		if err := rows.Scan(&id, &name, &county, &kraPIN, &role); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to parse farms"})
+			return
+		}
+		out = append(out, map[string]any{
+			"id":     id,
+			"name":   name,
+			"county": county,
+			"kraPin": kraPIN,
+			"role":   role,
+			"active": id == farmID,
+		})
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleCreateFarm(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDContextKey).(int64)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid auth context"})
+		return
+	}
+	var in struct {
+		Name   string `json:"name"`
+		County string `json:"county"`
+		KRAPIN string `json:"kraPin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	name, county, kraPIN, msg := normalizeFarmInput(in.Name, in.County, in.KRAPIN)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	ownerID, _, err := s.resolveRole(ctx, 0, ownerRole)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "owner role is not configured"})
+		return
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO farms(name, county, kra_pin)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, name, county, kraPIN).Scan(&id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO farm_members(farm_id, user_id, role_id)
+		VALUES ($1, $2, $3)
+	`, id, userID, ownerID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+	if err := seedDefaultPrices(ctx, tx, id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+	if err := seedDefaultAlertRules(ctx, tx, id); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+	// The event belongs to the new farm's trail, not the one the caller is on.
+	farmReq := r.WithContext(context.WithValue(r.Context(), farmIDContextKey, id))
+	if err := s.recordAudit(ctx, tx, farmReq, auditCreate, "farms", id, nil); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create farm"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"ok": true,
+		"farm": map[string]any{
+			"id":     id,
+			"name":   name,
+			"county": county,
+			"kraPin": kraPIN,
+			"role":   ownerRole,
+		},
+	})
+}
+
+// handleSwitchFarm moves the current session to another farm the caller
+// belongs to. Access tokens carry no farm, so the next request already runs
+// against the new farm without a fresh login.
+func (s *Server) handleSwitchFarm(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(userIDContextKey).(int64)
+	sessionID, sok := r.Context().Value(sessionIDContextKey).(int64)
+	if !ok || !sok {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid auth context"})
+		return
+	}
+	farmID, err := parsePathID(r, "id")
+	if err != nil || farmID <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid farm id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var name string
+	err = s.db.QueryRow(ctx, `
+		SELECT f.name
+		FROM farm_members fm
+		JOIN farms f ON f.id = fm.farm_id
+		WHERE fm.farm_id = $1 AND fm.user_id = $2 AND fm.status = 'active'
+	`, farmID, userID).Scan(&name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "farm not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to switch farm"})
+		return
+	}
+	if err := s.setSessionFarm(ctx, sessionID, farmID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to switch farm"})
+		return
+	}
+
+	_, role, perms, err := s.loadAuthContext(ctx, userID, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load farm permissions"})
+		return
+	}
+	permissions := make([]string, 0, len(perms))
+	for key := range perms {
+		permissions = append(permissions, key)
+	}
+	sort.Strings(permissions)
+
+	respondJSON(w, http.StatusOK, map[string]any{
+		"ok":          true,
+		"farmId":      farmID,
+		"farm":        name,
+		"role":        role,
+		"permissions": permissions,
+	})
+}
+
+func (s *Server) handleUpdateFarm(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name   string `json:"name"`
+		County string `json:"county"`
+		KRAPIN string `json:"kraPin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	name, county, kraPIN, msg := normalizeFarmInput(in.Name, in.County, in.KRAPIN)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "farms", farmID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE farms
+			SET name = $1, county = $2, kra_pin = $3
+			WHERE id = $4
+		`, name, county, kraPIN, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return farmID, nil
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update farm"})
+		return
+	}
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "farm not found"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// handleExportFarm downloads the current farm as a backup archive, the same
+// one "server export" writes, for keeping offline or restoring elsewhere.
+func (s *Server) handleExportFarm(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	// Built in memory so a failure part way still gets a JSON error.
+	var buf bytes.Buffer
+	if _, err := backup.Export(ctx, s.db, &buf, []int64{farmID}); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to export farm"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"farmpro-farm-%d-%s.zip\"", farmID, s.now().Format("20060102")))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func normalizeFarmInput(name, county, kraPIN string) (string, string, string, string) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 120 {
+		return "", "", "", "farm name is required (max 120 chars)"
+	}
+	county, ok := normalizeCounty(county)
+	if !ok {
+		return "", "", "", "county must be a valid Kenya county"
+	}
+	kraPIN, ok = normalizeKRAPIN(kraPIN)
+	if !ok {
+		return "", "", "", "KRA PIN must be valid KRA format (e.g. P051234567X)"
+	}
+	return name, county, kraPIN, ""
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Quantities keep the unit they were entered in. The registry says which
+// units are allowed and how they convert; anything that adds quantities up
+// converts them to one unit first.
+
+const (
+	dimensionMass   = "mass"
+	dimensionVolume = "volume"
+	dimensionCount  = "count"
+)
+
+type unitOfMeasure struct {
+	code      string
+	label     string
+	dimension string
+	factor    float64 // base units in one of this unit
+}
+
+var unitRegistry = []unitOfMeasure{
+	{"kg", "Kilogram", dimensionMass, 1},
+	{"g", "Gram", dimensionMass, 0.001},
+	{"mg", "Milligram", dimensionMass, 0.000001},
+	{"t", "Tonne", dimensionMass, 1000},
+	{"bag-50kg", "50 kg bag", dimensionMass, 50},
+	{"L", "Litre", dimensionVolume, 1},
+	{"ml", "Millilitre", dimensionVolume, 0.001},
+	{"pcs", "Piece", dimensionCount, 1},
+	{"head", "Head", dimensionCount, 1},
+	{"dozen", "Dozen", dimensionCount, 12},
+	{"tray-30", "Tray of 30", dimensionCount, 30},
+}
+
+var baseUnits = map[string]string{
+	dimensionMass:   "kg",
+	dimensionVolume: "L",
+	dimensionCount:  "pcs",
+}
+
+// unitAliases are the spellings people type for registry units. 0014_units
+// folds stored units with them, so a new alias needs a migration too.
+var unitAliases = map[string]string{
+	"kgs":        "kg",
+	"kilo":       "kg",
+	"kilos":      "kg",
+	"kilogram":   "kg",
+	"kilograms":  "kg",
+	"gm":         "g",
+	"gms":        "g",
+	"gram":       "g",
+	"grams":      "g",
+	"ton":        "t",
+	"tons":       "t",
+	"tonne":      "t",
+	"tonnes":     "t",
+	"bag":        "bag-50kg",
+	"bags":       "bag-50kg",
+	"50kg bag":   "bag-50kg",
+	"bag 50kg":   "bag-50kg",
+	"l":          "L",
+	"ltr":        "L",
+	"ltrs":       "L",
+	"litre":      "L",
+	"litres":     "L",
+	"liter":      "L",
+	"liters":     "L",
+	"millilitre": "ml",
+	"milliliter": "ml",
+	"mls":        "ml",
+	"milligram":  "mg",
+	"milligrams": "mg",
+	"pc":         "pcs",
+	"piece":      "pcs",
+	"pieces":     "pcs",
+	"each":       "pcs",
+	"tablet":     "pcs",
+	"tablets":    "pcs",
+	"bolus":      "pcs",
+	"boluses":    "pcs",
+	"dose":       "pcs",
+	"doses":      "pcs",
+	"heads":      "head",
+	"doz":        "dozen",
+	"dozens":     "dozen",
+	"tray":       "tray-30",
+	"trays":      "tray-30",
+}
+
+// ingredientDensities are kg per litre for feeds that get measured both by
+// volume and by weight. A feed item's own density overrides these.
+var ingredientDensities = map[string]float64{
+	"molasses":      1.4,
+	"water":         1,
+	"milk":          1.03,
+	"whey":          1.03,
+	"vegetable oil": 0.92,
+	"cooking oil":   0.92,
+}
+
+var unitIndex = buildUnitIndex()
+
+func buildUnitIndex() map[string]unitOfMeasure {
+	index := make(map[string]unitOfMeasure, len(unitRegistry)+len(unitAliases))
+	for _, u := range unitRegistry {
+		index[strings.ToLower(u.code)] = u
+	}
+	for alias, code := range unitAliases {
+		index[alias] = index[strings.ToLower(code)]
+	}
+	return index
+}
+
+func lookupUnit(raw string) (unitOfMeasure, bool) {
+	u, ok := unitIndex[strings.ToLower(strings.Join(strings.Fields(raw), " "))]
+	return u, ok
+}
+
+// normalizeUnit returns the registry code for raw, or fallback when raw is
+// blank.
+func normalizeUnit(raw, fallback string) (string, bool) {
+	if strings.TrimSpace(raw) == "" {
+		return fallback, true
+	}
+	u, ok := lookupUnit(raw)
+	return u.code, ok
+}
+
+func unitCodes() string {
+	codes := make([]string, 0, len(unitRegistry))
+	for _, u := range unitRegistry {
+		codes = append(codes, u.code)
+	}
+	return strings.Join(codes, ", ")
+}
+
+func feedDensity(nameKey string, stored float64) float64 {
+	if stored > 0 {
+		return stored
+	}
+	return ingredientDensities[nameKey]
+}
+
+// convertQuantity converts qty from one unit to another. Weight and volume
+// only convert through a density in kg per litre; counts never convert to
+// either.
+func convertQuantity(qty float64, from, to string, density float64) (float64, bool) {
+	f, ok := lookupUnit(from)
+	if !ok {
+		return 0, false
+	}
+	t, ok := lookupUnit(to)
+	if !ok {
+		return 0, false
+	}
+	base := qty * f.factor
+	switch {
+	case f.dimension == t.dimension:
+	case density > 0 && f.dimension == dimensionVolume && t.dimension == dimensionMass:
+		base *= density
+	case density > 0 && f.dimension == dimensionMass && t.dimension == dimensionVolume:
+		base /= density
+	default:
+		return 0, false
+	}
+	return base / t.factor, true
+}
+
+// toBaseUnit converts qty to the base unit of its own dimension.
+func toBaseUnit(qty float64, unit string) (float64, string, bool) {
+	u, ok := lookupUnit(unit)
+	if !ok {
+		return 0, "", false
+	}
+	return qty * u.factor, baseUnits[u.dimension], true
+}
+
+func (s *Server) handleUnits(w http.ResponseWriter, r *http.Request) {
+	out := make([]map[string]any, 0, len(unitRegistry))
+	for _, u := range unitRegistry {
+		out = append(out, map[string]any{
+			"code":      u.code,
+			"label":     u.label,
+			"dimension": u.dimension,
+			"baseUnit":  baseUnits[u.dimension],
+			"factor":    u.factor,
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"items":     out,
+		"densities": ingredientDensities,
+	})
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUnit(t *testing.T) {
+	tests := []struct {
+		raw, fallback string
+		want          string
+		ok            bool
+	}{
+		{"kg", "", "kg", true},
+		{"KGS", "", "kg", true},
+		{" Kilograms ", "", "kg", true},
+		{"50kg  bag", "", "bag-50kg", true},
+		{"l", "", "L", true},
+		{"Litres", "", "L", true},
+		{"ML", "", "ml", true},
+		{"Tray", "", "tray-30", true},
+		{"", "kg", "kg", true},
+		{"   ", "L", "L", true},
+		{"bushel", "", "", false},
+		{"kg bag", "", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeUnit(tt.raw, tt.fallback)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeUnit(%q, %q) = %q, %v, want %q, %v", tt.raw, tt.fallback, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConvertQuantity(t *testing.T) {
+	tests := []struct {
+		qty      float64
+		from, to string
+		density  float64
+		want     float64
+		ok       bool
+	}{
+		{2.5, "kg", "g", 0, 2500, true},
+		{1500, "g", "kg", 0, 1.5, true},
+		{3, "bags", "kg", 0, 150, true},
+		{2, "t", "bag-50kg", 0, 40, true},
+		{750, "ml", "L", 0, 0.75, true},
+		{2, "dozen", "pcs", 0, 24, true},
+		{3, "trays", "dozen", 0, 7.5, true},
+		{10, "L", "kg", 1.4, 14, true},
+		{14, "kg", "L", 1.4, 10, true},
+		{500, "ml", "g", 1.03, 515, true},
+		{10, "L", "kg", 0, 0, false},
+		{10, "kg", "pcs", 1, 0, false},
+		{10, "pcs", "L", 1, 0, false},
+		{1, "kg", "bushel", 0, 0, false},
+		{1, "bushel", "kg", 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := convertQuantity(tt.qty, tt.from, tt.to, tt.density)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("convertQuantity(%v, %q, %q, %v) = %v, %v, want %v, %v", tt.qty, tt.from, tt.to, tt.density, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestToBaseUnit(t *testing.T) {
+	tests := []struct {
+		qty  float64
+		unit string
+		want float64
+		base string
+	}{
+		{250, "g", 0.25, "kg"},
+		{2, "L", 2, "L"},
+		{500, "ml", 0.5, "L"},
+		{2, "tray-30", 60, "pcs"},
+		{4, "head", 4, "pcs"},
+	}
+	for _, tt := range tests {
+		got, base, ok := toBaseUnit(tt.qty, tt.unit)
+		if !ok || base != tt.base || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("toBaseUnit(%v, %q) = %v, %q, %v", tt.qty, tt.unit, got, base, ok)
+		}
+	}
+	if _, _, ok := toBaseUnit(1, "bushel"); ok {
+		t.Error("toBaseUnit accepted an unknown unit")
+	}
+}
+
+func TestUnitAliasesResolve(t *testing.T) {
+	for alias, code := range unitAliases {
+		if _, ok := unitIndex[alias]; !ok {
+			t.Errorf("alias %q is not indexed", alias)
+		}
+		if u, ok := lookupUnit(code); !ok || u.code != code {
+			t.Errorf("alias %q points at %q, which is not a registry code", alias, code)
+		}
+	}
+}
+
+// Stored units are folded by migrations, so every alias must be in one.
+func TestUnitAliasesFoldedByMigrations(t *testing.T) {
+	files, err := filepath.Glob("../../db/migrations/*.up.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("migrations not found: %v", err)
+	}
+	pairRe := regexp.MustCompile(`\('([^']+)', '([^']+)'\)`)
+	folded := map[string]string{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, inserts, ok := strings.Cut(string(data), "INSERT INTO unit_aliases")
+		if !ok {
+			continue
+		}
+		values, _, _ := strings.Cut(inserts, ";")
+		for _, m := range pairRe.FindAllStringSubmatch(values, -1) {
+			folded[m[1]] = m[2]
+		}
+	}
+	for alias, code := range unitAliases {
+		if folded[alias] != code {
+			t.Errorf("alias %q -> %q is not folded by any migration", alias, code)
+		}
+	}
+}
@@ -3,79 +3,203 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (s *Server) handleCreateAnimal(w http.ResponseWriter, r *http.Request) {
-	var in struct {
-		TagID        string   `json:"tagId"`
-		Type         string   `json:"type"`
-		Breed        string   `json:"breed"`
-		BirthDate    string   `json:"birthDate"`
-		WeightKg     *float64 `json:"weightKg"`
-		HealthStatus string   `json:"healthStatus"`
-		Status       string   `json:"status"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
+type animalInput struct {
+	TagID         string   `json:"tagId"`
+	Type          string   `json:"type"`
+	Breed         string   `json:"breed"`
+	BirthDate     string   `json:"birthDate"`
+	WeightKg      *float64 `json:"weightKg"`
+	HealthStatus  string   `json:"healthStatus"`
+	Status        string   `json:"status"`
+	Sex           string   `json:"sex"`
+	MotherTagID   string   `json:"motherTagId"`
+	FatherTagID   string   `json:"fatherTagId"`
+	LocationID    *int64   `json:"locationId"`
+	EntryType     string   `json:"entryType"`
+	EntryDate     string   `json:"entryDate"`
+	EntryReason   string   `json:"entryReason"`
+	PurchasePrice float64  `json:"purchasePrice"`
+	ExpenseID     *int64   `json:"expenseId"`
+}
+
+type newAnimal struct {
+	animalInput
+	birthDate   *time.Time
+	sex         string
+	entryType   string
+	entryReason string
+	entryDate   time.Time
+}
 
+func normalizeAnimalInput(in animalInput, today time.Time) (newAnimal, string) {
+	a := newAnimal{animalInput: in}
 	normalizedTag, ok := normalizeAnimalTag(in.TagID)
 	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "tagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
-		return
-	}
-	in.TagID = normalizedTag
-	in.Type = strings.TrimSpace(in.Type)
-	in.Breed = strings.TrimSpace(in.Breed)
-	if in.HealthStatus == "" {
-		in.HealthStatus = "healthy"
+		return a, "tagId must be 2-24 chars (A-Z, 0-9, hyphen)"
 	}
-	if in.Status == "" {
-		in.Status = "active"
+	a.TagID = normalizedTag
+	a.Type = strings.TrimSpace(in.Type)
+	a.Breed = strings.TrimSpace(in.Breed)
+	if a.Status == "" {
+		a.Status = "active"
 	}
 
-	if in.TagID == "" || in.Type == "" || in.Breed == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "tagId, type, and breed are required"})
-		return
+	if a.TagID == "" || a.Type == "" || a.Breed == "" {
+		return a, "tagId, type, and breed are required"
 	}
-	if prefix, ok := expectedTagPrefixByType(in.Type); ok {
-		if !strings.HasPrefix(in.TagID, prefix) {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("tagId must start with %s for %s", prefix, in.Type)})
-			return
+	if a.Status == "sold" || a.Status == "dead" || a.Status == "culled" {
+		return a, "add the animal first, then record its sale, death or cull"
+	}
+	// Health status follows disease cases, which need the animal to exist.
+	if h := strings.ToLower(strings.TrimSpace(a.HealthStatus)); h != "" && h != "healthy" {
+		return a, "a new animal starts healthy; open a disease case to record an illness"
+	}
+	if prefix, ok := expectedTagPrefixByType(a.Type); ok {
+		if !strings.HasPrefix(a.TagID, prefix) {
+			return a, fmt.Sprintf("tagId must start with %s for %s", prefix, a.Type)
 		}
 	}
 
 	birthDate, err := optionalDate(in.BirthDate)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "birthDate must be YYYY-MM-DD"})
-		return
+		return a, "birthDate must be YYYY-MM-DD"
 	}
 	if birthDate != nil && birthDate.After(time.Now()) {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "birthDate cannot be in the future"})
+		return a, "birthDate cannot be in the future"
+	}
+	a.birthDate = birthDate
+	if a.sex, ok = normalizeSex(in.Sex); !ok {
+		return a, "sex must be female or male"
+	}
+
+	// Every animal enters the herd through a purchase or a birth.
+	a.entryType = strings.ToLower(strings.TrimSpace(in.EntryType))
+	if a.entryType == "" {
+		a.entryType = animalEventPurchase
+	}
+	if !isEntryEvent(a.entryType) {
+		return a, "entryType must be purchase or birth"
+	}
+	if a.entryReason, ok = normalizeEventReason(a.entryType, in.EntryReason); !ok {
+		return a, "entryReason must be one of " + strings.Join(animalEventReasons[a.entryType], ", ")
+	}
+	entryDate, err := optionalDate(in.EntryDate)
+	if err != nil {
+		return a, "entryDate must be YYYY-MM-DD"
+	}
+	if a.entryType == animalEventBirth {
+		if birthDate == nil {
+			return a, "birthDate is required for an animal born on the farm"
+		}
+		entryDate = birthDate
+	}
+	a.entryDate = today
+	if entryDate != nil {
+		a.entryDate = *entryDate
+	}
+	if a.entryDate.After(time.Now()) {
+		return a, "entryDate cannot be in the future"
+	}
+	if in.PurchasePrice < 0 {
+		return a, "purchasePrice cannot be negative"
+	}
+	if a.entryType != animalEventPurchase && (in.PurchasePrice > 0 || in.ExpenseID != nil) {
+		return a, "purchasePrice and expenseId only apply to purchases"
+	}
+	return a, ""
+}
+
+// insertAnimal adds a and books its entry into the herd. Problems with the
+// row's references come back as httpErrors.
+func (s *Server) insertAnimal(ctx context.Context, tx pgx.Tx, r *http.Request, farmID int64, a newAnimal) (int64, error) {
+	motherID, fatherID, err := resolveParents(ctx, tx, farmID, 0, a.MotherTagID, a.FatherTagID)
+	if err != nil {
+		return 0, err
+	}
+	if a.LocationID != nil {
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM animal_locations WHERE id = $1 AND farm_id = $2)`, *a.LocationID, farmID).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, &httpError{http.StatusBadRequest, "location not found"}
+		}
+	}
+	if a.ExpenseID != nil {
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM expenses WHERE id = $1 AND farm_id = $2)`, *a.ExpenseID, farmID).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, &httpError{http.StatusBadRequest, "expense not found"}
+		}
+	}
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO animals(farm_id, tag_id, type, breed, birth_date, weight_kg, status, is_active, sex, mother_id, father_id, location_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`, farmID, a.TagID, a.Type, a.Breed, a.birthDate, a.WeightKg, a.Status, a.Status == "active", a.sex, motherID, fatherID, a.LocationID).Scan(&id)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+			return 0, &httpError{http.StatusConflict, "tag ID already exists"}
+		}
+		return 0, err
+	}
+	eventID, err := insertAnimalEvent(ctx, tx, r, farmID, animalEvent{
+		animalID:  id,
+		eventType: a.entryType,
+		date:      a.entryDate,
+		reason:    a.entryReason,
+		amount:    roundCents(a.PurchasePrice),
+		expenseID: a.ExpenseID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := s.recordAudit(ctx, tx, r, auditCreate, "animal_events", eventID, nil); err != nil {
+		return 0, err
+	}
+	if a.Status == "active" {
+		if err := s.applyAutoHealthProtocols(ctx, tx, farmID, id, a.Type); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+func (s *Server) handleCreateAnimal(w http.ResponseWriter, r *http.Request) {
+	var in animalInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	a, msg := normalizeAnimalInput(in, s.now())
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO animals(tag_id, type, breed, birth_date, weight_kg, health_status, status, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, in.TagID, in.Type, in.Breed, birthDate, in.WeightKg, in.HealthStatus, in.Status, in.Status == "active")
+	_, err := s.auditedWrite(ctx, r, auditCreate, "animals", 0, func(tx pgx.Tx) (int64, error) {
+		return s.insertAnimal(ctx, tx, r, farmID, a)
+	})
 	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
-			respondJSON(w, http.StatusConflict, map[string]string{"error": "tag ID already exists"})
-			return
-		}
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create animal"})
+		respondHTTPError(w, err, "failed to create animal")
 		return
 	}
 
@@ -96,6 +220,9 @@ func (s *Server) handleUpdateAnimal(w http.ResponseWriter, r *http.Request) {
 		WeightKg     *float64 `json:"weightKg"`
 		HealthStatus string   `json:"healthStatus"`
 		Status       string   `json:"status"`
+		Sex          *string  `json:"sex"`
+		MotherTagID  *string  `json:"motherTagId"`
+		FatherTagID  *string  `json:"fatherTagId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
@@ -104,12 +231,8 @@ func (s *Server) handleUpdateAnimal(w http.ResponseWriter, r *http.Request) {
 
 	in.Type = strings.TrimSpace(in.Type)
 	in.Breed = strings.TrimSpace(in.Breed)
-	if in.HealthStatus == "" {
-		in.HealthStatus = "healthy"
-	}
-	if in.Status == "" {
-		in.Status = "active"
-	}
+	in.HealthStatus = strings.ToLower(strings.TrimSpace(in.HealthStatus))
+	in.Status = strings.TrimSpace(in.Status)
 	if in.Type == "" || in.Breed == "" {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "type and breed are required"})
 		return
@@ -132,16 +255,76 @@ func (s *Server) handleUpdateAnimal(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE animals
-		SET type = $1, breed = $2, birth_date = $3, weight_kg = $4, health_status = $5, status = $6, is_active = $7
-		WHERE tag_id = $8
-	`, in.Type, in.Breed, birthDate, in.WeightKg, in.HealthStatus, in.Status, in.Status == "active", tagID)
+	farmID := farmIDFrom(ctx)
+	var animalID int64
+	var status, healthStatus, sex, motherTag, fatherTag string
+	if err := s.db.QueryRow(ctx, `
+		SELECT a.id, a.status, a.health_status, a.sex, COALESCE(m.tag_id, ''), COALESCE(f.tag_id, '')
+		FROM animals a
+		LEFT JOIN animals m ON m.id = a.mother_id
+		LEFT JOIN animals f ON f.id = a.father_id
+		WHERE a.tag_id = $1 AND a.farm_id = $2
+	`, tagID, farmID).Scan(&animalID, &status, &healthStatus, &sex, &motherTag, &fatherTag); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
+		return
+	}
+	// Leaving the herd, and coming back, goes through lifecycle events.
+	if in.Status == "" {
+		in.Status = status
+	}
+	if in.Status != status && (status == "sold" || status == "dead" || status == "culled") {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": fmt.Sprintf("this animal is %s; remove that event to bring it back", status)})
+		return
+	}
+	if in.Status != status && (in.Status == "sold" || in.Status == "dead" || in.Status == "culled") {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "record a sale, death or cull event instead of setting the status"})
+		return
+	}
+	// Health status is derived from the animal's open disease cases.
+	if in.HealthStatus != "" && in.HealthStatus != healthStatus {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "health status follows the animal's disease cases; open or close a case instead"})
+		return
+	}
+	// Sex and parents are left alone unless the request sends them.
+	if in.Sex != nil {
+		if sex, ok = normalizeSex(*in.Sex); !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "sex must be female or male"})
+			return
+		}
+	}
+	if in.MotherTagID != nil {
+		motherTag = *in.MotherTagID
+	}
+	if in.FatherTagID != nil {
+		fatherTag = *in.FatherTagID
+	}
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "animals", animalID, func(tx pgx.Tx) (int64, error) {
+		motherID, fatherID, err := resolveParents(ctx, tx, farmID, animalID, motherTag, fatherTag)
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `
+			UPDATE animals
+			SET type = $1, breed = $2, birth_date = $3, weight_kg = $4, status = $5, is_active = $6,
+				sex = $7, mother_id = $8, father_id = $9
+			WHERE id = $10 AND farm_id = $11
+		`, in.Type, in.Breed, birthDate, in.WeightKg, in.Status, in.Status == "active", sex, motherID, fatherID, animalID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		switch {
+		case status == "active" && in.Status != "active":
+			err = cancelAnimalHealthTasks(ctx, tx, animalID)
+		case status != "active" && in.Status == "active":
+			err = rescheduleAnimalHealthTasks(ctx, tx, farmID, animalID, s.today())
+		}
+		return animalID, err
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update animal"})
+		respondHTTPError(w, err, "failed to update animal")
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
 		return
 	}
@@ -158,82 +341,154 @@ func (s *Server) handleDeleteAnimal(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE animals
-		SET is_active = false, status = 'inactive'
-		WHERE tag_id = $1
-	`, tagID)
+	farmID := farmIDFrom(ctx)
+	var animalID int64
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, tagID, farmID).Scan(&animalID); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
+		return
+	}
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "animals", animalID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE animals
+			SET is_active = false, status = 'inactive'
+			WHERE id = $1 AND farm_id = $2
+		`, animalID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return animalID, cancelAnimalHealthTasks(ctx, tx, animalID)
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete animal"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "animal not found"})
 		return
 	}
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (s *Server) handleCreateHealthRecord(w http.ResponseWriter, r *http.Request) {
-	var in struct {
-		AnimalTagID  string `json:"animalTagId"`
-		Action       string `json:"action"`
-		Treatment    string `json:"treatment"`
-		RecordDate   string `json:"recordDate"`
-		Veterinarian string `json:"veterinarian"`
-		NextDue      string `json:"nextDue"`
-		Notes        string `json:"notes"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
+type healthRecordInput struct {
+	AnimalTagID  string `json:"animalTagId"`
+	Action       string `json:"action"`
+	Treatment    string `json:"treatment"`
+	RecordDate   string `json:"recordDate"`
+	Veterinarian string `json:"veterinarian"`
+	NextDue      string `json:"nextDue"`
+	Notes        string `json:"notes"`
+	CaseID       *int64 `json:"caseId"`
+	recordMedicineInput
+}
 
+type healthRecord struct {
+	healthRecordInput
+	recordDate time.Time
+	nextDue    *time.Time
+	// protocolID and stepID mark the task for nextDue as the protocol's.
+	protocolID int64
+	stepID     int64
+}
+
+func normalizeHealthRecordInput(in healthRecordInput) (healthRecord, string) {
+	h := healthRecord{healthRecordInput: in}
 	tagID, ok := normalizeAnimalTag(in.AnimalTagID)
 	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
-		return
+		return h, "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"
 	}
-	in.AnimalTagID = tagID
-	in.Action = strings.TrimSpace(in.Action)
-	in.Treatment = strings.TrimSpace(in.Treatment)
-	in.Veterinarian = strings.TrimSpace(in.Veterinarian)
-	in.Notes = strings.TrimSpace(in.Notes)
-	if in.RecordDate == "" {
-		in.RecordDate = time.Now().Format("2006-01-02")
+	h.AnimalTagID = tagID
+	h.Action = strings.TrimSpace(in.Action)
+	h.Treatment = strings.TrimSpace(in.Treatment)
+	h.Veterinarian = strings.TrimSpace(in.Veterinarian)
+	h.Notes = strings.TrimSpace(in.Notes)
+	if h.RecordDate == "" {
+		h.RecordDate = time.Now().Format("2006-01-02")
 	}
 
-	if in.AnimalTagID == "" || in.Action == "" || in.Treatment == "" || in.Veterinarian == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId, action, treatment, and veterinarian are required"})
-		return
+	if h.AnimalTagID == "" || h.Action == "" || (h.Treatment == "" && !h.given()) || h.Veterinarian == "" {
+		return h, "animalTagId, action, treatment, and veterinarian are required"
+	}
+	var msg string
+	if h.recordMedicineInput, msg = normalizeRecordMedicineInput(in.recordMedicineInput); msg != "" {
+		return h, msg
+	}
+
+	var err error
+	if h.recordDate, err = time.Parse("2006-01-02", h.RecordDate); err != nil {
+		return h, "recordDate must be YYYY-MM-DD"
 	}
+	if h.nextDue, err = optionalDate(in.NextDue); err != nil {
+		return h, "nextDue must be YYYY-MM-DD"
+	}
+	return h, ""
+}
 
-	recordDate, err := time.Parse("2006-01-02", in.RecordDate)
+func insertHealthRecord(ctx context.Context, tx pgx.Tx, farmID int64, h healthRecord) (int64, error) {
+	var animalID int64
+	err := tx.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, h.AnimalTagID, farmID).Scan(&animalID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, &httpError{http.StatusBadRequest, "animal not found"}
+	}
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "recordDate must be YYYY-MM-DD"})
-		return
+		return 0, err
+	}
+	if err := checkRecordCase(ctx, tx, farmID, animalID, h.CaseID); err != nil {
+		return 0, err
+	}
+	m, err := resolveRecordMedicine(ctx, tx, farmID, h.recordDate, h.recordMedicineInput)
+	if err != nil {
+		return 0, err
+	}
+	if h.Treatment == "" {
+		h.Treatment = m.name
 	}
-	nextDue, err := optionalDate(in.NextDue)
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO health_records(
+			farm_id, animal_id, action, treatment, record_date, veterinarian, next_due, notes,
+			medicine_id, batch_id, dose_quantity, dose_unit, milk_withdrawal_until, meat_withdrawal_until, case_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id
+	`, farmID, animalID, h.Action, h.Treatment, h.recordDate, h.Veterinarian, h.nextDue, h.Notes,
+		m.medicineID, m.batchID, m.dose, m.doseUnit, m.milkUntil, m.meatUntil, h.CaseID).Scan(&id)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "nextDue must be YYYY-MM-DD"})
+		return 0, err
+	}
+	if err := issueRecordMedicine(ctx, tx, farmID, id, h.recordDate, m); err != nil {
+		return 0, err
+	}
+	if h.nextDue == nil {
+		return id, nil
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO health_tasks(farm_id, animal_id, protocol_id, step_id, source_record_id, action, treatment, due_date)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0), $5, $6, $7, $8)
+	`, farmID, animalID, h.protocolID, h.stepID, id, h.Action, h.Treatment, *h.nextDue)
+	return id, err
+}
+
+func (s *Server) handleCreateHealthRecord(w http.ResponseWriter, r *http.Request) {
+	var in healthRecordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	h, msg := normalizeHealthRecordInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	var animalID int64
-	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, in.AnimalTagID).Scan(&animalID); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animal not found"})
-		return
-	}
-
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO health_records(animal_id, action, treatment, record_date, veterinarian, next_due, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, animalID, in.Action, in.Treatment, recordDate, in.Veterinarian, nextDue, in.Notes)
+	_, err := s.auditedWrite(ctx, r, auditCreate, "health_records", 0, func(tx pgx.Tx) (int64, error) {
+		return insertHealthRecord(ctx, tx, farmID, h)
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create health record"})
+		respondHTTPError(w, err, "failed to create health record")
 		return
 	}
 
@@ -247,61 +502,70 @@ func (s *Server) handleUpdateHealthRecord(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var in struct {
-		AnimalTagID  string `json:"animalTagId"`
-		Action       string `json:"action"`
-		Treatment    string `json:"treatment"`
-		RecordDate   string `json:"recordDate"`
-		Veterinarian string `json:"veterinarian"`
-		NextDue      string `json:"nextDue"`
-		Notes        string `json:"notes"`
-	}
+	var in healthRecordInput
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 		return
 	}
-	tagID, ok := normalizeAnimalTag(in.AnimalTagID)
-	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
-		return
-	}
-	in.AnimalTagID = tagID
-	in.Action = strings.TrimSpace(in.Action)
-	in.Treatment = strings.TrimSpace(in.Treatment)
-	in.Veterinarian = strings.TrimSpace(in.Veterinarian)
-	if in.AnimalTagID == "" || in.Action == "" || in.Treatment == "" || in.Veterinarian == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId, action, treatment, and veterinarian are required"})
-		return
-	}
-	recordDate, err := time.Parse("2006-01-02", in.RecordDate)
-	if err != nil {
+	// A blank date would otherwise default to today and move the record.
+	if strings.TrimSpace(in.RecordDate) == "" {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "recordDate must be YYYY-MM-DD"})
 		return
 	}
-	nextDue, err := optionalDate(in.NextDue)
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "nextDue must be YYYY-MM-DD"})
+	h, msg := normalizeHealthRecordInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 	var animalID int64
-	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, in.AnimalTagID).Scan(&animalID); err != nil {
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, h.AnimalTagID, farmID).Scan(&animalID); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animal not found"})
 		return
 	}
 
-	res, err := s.db.Exec(ctx, `
-		UPDATE health_records
-		SET animal_id = $1, action = $2, treatment = $3, record_date = $4, veterinarian = $5, next_due = $6, notes = $7
-		WHERE id = $8
-	`, animalID, in.Action, in.Treatment, recordDate, in.Veterinarian, nextDue, strings.TrimSpace(in.Notes), recordID)
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "health_records", recordID, func(tx pgx.Tx) (int64, error) {
+		if err := checkRecordCase(ctx, tx, farmID, animalID, h.CaseID); err != nil {
+			return 0, err
+		}
+		// Put the old dose back before checking the batch can cover the new one.
+		if _, err := tx.Exec(ctx, `DELETE FROM medicine_stock_movements WHERE health_record_id = $1 AND farm_id = $2`, recordID, farmID); err != nil {
+			return 0, err
+		}
+		m, err := resolveRecordMedicine(ctx, tx, farmID, h.recordDate, h.recordMedicineInput)
+		if err != nil {
+			return 0, err
+		}
+		if h.Treatment == "" {
+			h.Treatment = m.name
+		}
+		res, err := tx.Exec(ctx, `
+			UPDATE health_records
+			SET animal_id = $1, action = $2, treatment = $3, record_date = $4, veterinarian = $5, next_due = $6, notes = $7,
+				medicine_id = $8, batch_id = $9, dose_quantity = $10, dose_unit = $11, milk_withdrawal_until = $12, meat_withdrawal_until = $13,
+				case_id = $14
+			WHERE id = $15 AND farm_id = $16
+		`, animalID, h.Action, h.Treatment, h.recordDate, h.Veterinarian, h.nextDue, h.Notes,
+			m.medicineID, m.batchID, m.dose, m.doseUnit, m.milkUntil, m.meatUntil, h.CaseID, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		if err := issueRecordMedicine(ctx, tx, farmID, recordID, h.recordDate, m); err != nil {
+			return 0, err
+		}
+		if err := syncRecordTask(ctx, tx, farmID, recordID, animalID, h.Action, h.Treatment, h.nextDue); err != nil {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update health record"})
+		respondHTTPError(w, err, "failed to update health record")
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -316,12 +580,31 @@ func (s *Server) handleDeleteHealthRecord(w http.ResponseWriter, r *http.Request
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM health_records WHERE id = $1`, recordID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "health_records", recordID, func(tx pgx.Tx) (int64, error) {
+		// Deleting the record of a completed task undoes the completion: the
+		// dose it scheduled goes and the task it closed is open again.
+		if _, err := tx.Exec(ctx, `DELETE FROM health_tasks WHERE source_record_id = $1 AND farm_id = $2 AND status = 'scheduled'`, recordID, farmID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE health_tasks
+			SET status = 'scheduled', health_record_id = NULL, completed_at = NULL, completed_by = NULL
+			WHERE health_record_id = $1 AND farm_id = $2 AND status = 'done'
+		`, recordID, farmID); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `DELETE FROM health_records WHERE id = $1 AND farm_id = $2`, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete health record"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -408,9 +691,10 @@ func (s *Server) handleCreateBreedingRecord(w http.ResponseWriter, r *http.Reque
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var motherID int64
-	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, in.MotherTagID).Scan(&motherID); err != nil {
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, in.MotherTagID, farmID).Scan(&motherID); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "mother animal not found"})
 		return
 	}
@@ -426,11 +710,12 @@ func (s *Server) handleCreateBreedingRecord(w http.ResponseWriter, r *http.Reque
 			return
 		}
 		var father int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, fatherTag).Scan(&father); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, fatherTag, farmID).Scan(&father); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "father animal not found"})
 			return
 		}
 		fatherID = &father
+		in.FatherTagID = fatherTag
 	}
 	if aiSource == "internal" || aiSource == "" {
 		if fatherID == nil {
@@ -443,17 +728,33 @@ func (s *Server) handleCreateBreedingRecord(w http.ResponseWriter, r *http.Reque
 		aiSourcePtr = &aiSource
 	}
 
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO breeding_records(mother_animal_id, father_animal_id, species, breeding_date, heat_date, ai_date, on_heat,
-			ai_sire_source, ai_sire_name, ai_sire_code, expected_birth_date, status, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'active', $12)
-	`, motherID, fatherID, in.Species, breedDate, heatDate, aiDate, onHeat, aiSourcePtr, in.AISireName, in.AISireCode, expectedDate, in.Notes)
+	_, err = s.auditedWrite(ctx, r, auditCreate, "breeding_records", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO breeding_records(mother_animal_id, father_animal_id, species, breeding_date, heat_date, ai_date, on_heat,
+				ai_sire_source, ai_sire_name, ai_sire_code, expected_birth_date, status, notes, farm_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'active', $12, $13)
+			RETURNING id
+		`, motherID, fatherID, in.Species, breedDate, heatDate, aiDate, onHeat, aiSourcePtr, in.AISireName, in.AISireCode, expectedDate, in.Notes, farmID).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create breeding record"})
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, map[string]any{"ok": true})
+	out := map[string]any{"ok": true}
+	if fatherID != nil {
+		// The mating is already recorded; a close relationship only warns.
+		if coefficient, err := s.matingInbreeding(ctx, farmID, motherID, *fatherID); err == nil {
+			out["inbreedingCoefficient"] = roundInbreeding(coefficient)
+			if coefficient >= inbreedingWarnLevel {
+				out["warning"] = fmt.Sprintf("%s and %s are closely related (%s); offspring would have an inbreeding coefficient of %s",
+					motherTag, in.FatherTagID, relationshipLabel(coefficient), strconv.FormatFloat(roundInbreeding(coefficient), 'f', -1, 64))
+			}
+		}
+	}
+	respondJSON(w, http.StatusCreated, out)
 }
 
 func (s *Server) handleUpdateBreedingRecord(w http.ResponseWriter, r *http.Request) {
@@ -540,8 +841,9 @@ func (s *Server) handleUpdateBreedingRecord(w http.ResponseWriter, r *http.Reque
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 	var motherID int64
-	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, in.MotherTagID).Scan(&motherID); err != nil {
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, in.MotherTagID, farmID).Scan(&motherID); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "mother animal not found"})
 		return
 	}
@@ -557,7 +859,7 @@ func (s *Server) handleUpdateBreedingRecord(w http.ResponseWriter, r *http.Reque
 			return
 		}
 		var father int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, fatherTag).Scan(&father); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, fatherTag, farmID).Scan(&father); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "father animal not found"})
 			return
 		}
@@ -573,17 +875,23 @@ func (s *Server) handleUpdateBreedingRecord(w http.ResponseWriter, r *http.Reque
 	if aiSource != "" {
 		aiSourcePtr = &aiSource
 	}
-	res, err := s.db.Exec(ctx, `
-		UPDATE breeding_records
-		SET mother_animal_id = $1, father_animal_id = $2, species = $3, breeding_date = $4, heat_date = $5, ai_date = $6, on_heat = $7,
-			ai_sire_source = $8, ai_sire_name = $9, ai_sire_code = $10, expected_birth_date = $11, status = $12, notes = $13
-		WHERE id = $14
-	`, motherID, fatherID, in.Species, breedingDate, heatDate, aiDate, onHeat, aiSourcePtr, in.AISireName, in.AISireCode, expectedDate, in.Status, strings.TrimSpace(in.Notes), recordID)
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "breeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE breeding_records
+			SET mother_animal_id = $1, father_animal_id = $2, species = $3, breeding_date = $4, heat_date = $5, ai_date = $6, on_heat = $7,
+				ai_sire_source = $8, ai_sire_name = $9, ai_sire_code = $10, expected_birth_date = $11, status = $12, notes = $13
+			WHERE id = $14 AND farm_id = $15
+		`, motherID, fatherID, in.Species, breedingDate, heatDate, aiDate, onHeat, aiSourcePtr, in.AISireName, in.AISireCode, expectedDate, in.Status, strings.TrimSpace(in.Notes), recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update breeding record"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -598,12 +906,19 @@ func (s *Server) handleDeleteBreedingRecord(w http.ResponseWriter, r *http.Reque
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM breeding_records WHERE id = $1`, recordID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "breeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM breeding_records WHERE id = $1 AND farm_id = $2`, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete breeding record"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -676,8 +991,9 @@ func (s *Server) handleCreatePoultryBreedingRecord(w http.ResponseWriter, r *htt
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 	var henID int64
-	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, in.HenTagID).Scan(&henID); err != nil {
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, in.HenTagID, farmID).Scan(&henID); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "hen animal not found"})
 		return
 	}
@@ -693,17 +1009,22 @@ func (s *Server) handleCreatePoultryBreedingRecord(w http.ResponseWriter, r *htt
 			return
 		}
 		var rooster int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, roosterTag).Scan(&rooster); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, roosterTag, farmID).Scan(&rooster); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "rooster animal not found"})
 			return
 		}
 		roosterID = &rooster
 	}
 
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO poultry_breeding_records(hen_animal_id, rooster_animal_id, species, egg_set_date, hatch_date, eggs_set, chicks_hatched, status, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`, henID, roosterID, in.Species, eggSetDate, hatchDate, eggsSet, chicksHatched, in.Status, in.Notes)
+	_, err = s.auditedWrite(ctx, r, auditCreate, "poultry_breeding_records", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO poultry_breeding_records(hen_animal_id, rooster_animal_id, species, egg_set_date, hatch_date, eggs_set, chicks_hatched, status, notes, farm_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id
+		`, henID, roosterID, in.Species, eggSetDate, hatchDate, eggsSet, chicksHatched, in.Status, in.Notes, farmID).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create poultry breeding record"})
 		return
@@ -779,8 +1100,9 @@ func (s *Server) handleUpdatePoultryBreedingRecord(w http.ResponseWriter, r *htt
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 	var henID int64
-	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, in.HenTagID).Scan(&henID); err != nil {
+	if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, in.HenTagID, farmID).Scan(&henID); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "hen animal not found"})
 		return
 	}
@@ -796,23 +1118,29 @@ func (s *Server) handleUpdatePoultryBreedingRecord(w http.ResponseWriter, r *htt
 			return
 		}
 		var rooster int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, roosterTag).Scan(&rooster); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, roosterTag, farmID).Scan(&rooster); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "rooster animal not found"})
 			return
 		}
 		roosterID = &rooster
 	}
 
-	res, err := s.db.Exec(ctx, `
-		UPDATE poultry_breeding_records
-		SET hen_animal_id = $1, rooster_animal_id = $2, species = $3, egg_set_date = $4, hatch_date = $5, eggs_set = $6, chicks_hatched = $7, status = $8, notes = $9
-		WHERE id = $10
-	`, henID, roosterID, in.Species, eggSetDate, hatchDate, eggsSet, chicksHatched, in.Status, in.Notes, recordID)
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "poultry_breeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE poultry_breeding_records
+			SET hen_animal_id = $1, rooster_animal_id = $2, species = $3, egg_set_date = $4, hatch_date = $5, eggs_set = $6, chicks_hatched = $7, status = $8, notes = $9
+			WHERE id = $10 AND farm_id = $11
+		`, henID, roosterID, in.Species, eggSetDate, hatchDate, eggsSet, chicksHatched, in.Status, in.Notes, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update poultry breeding record"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -827,12 +1155,19 @@ func (s *Server) handleDeletePoultryBreedingRecord(w http.ResponseWriter, r *htt
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM poultry_breeding_records WHERE id = $1`, recordID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "poultry_breeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM poultry_breeding_records WHERE id = $1 AND farm_id = $2`, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete poultry breeding record"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -849,6 +1184,12 @@ func (s *Server) handleRecordBirth(w http.ResponseWriter, r *http.Request) {
 	var in struct {
 		ActualBirthDate string `json:"actualBirthDate"`
 		OffspringCount  *int   `json:"offspringCount"`
+		Offspring       []struct {
+			TagID    string   `json:"tagId"`
+			Sex      string   `json:"sex"`
+			Breed    string   `json:"breed"`
+			WeightKg *float64 `json:"weightKg"`
+		} `json:"offspring"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
@@ -865,7 +1206,7 @@ func (s *Server) handleRecordBirth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	offspringCount := 0
+	offspringCount := len(in.Offspring)
 	if in.OffspringCount != nil {
 		offspringCount = *in.OffspringCount
 	}
@@ -873,79 +1214,182 @@ func (s *Server) handleRecordBirth(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "offspringCount cannot be negative"})
 		return
 	}
+	if offspringCount < len(in.Offspring) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "offspringCount cannot be less than the offspring listed"})
+		return
+	}
+	seen := map[string]bool{}
+	for i := range in.Offspring {
+		o := &in.Offspring[i]
+		tag, ok := normalizeAnimalTag(o.TagID)
+		if !ok || tag == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "offspring tagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
+			return
+		}
+		if seen[tag] {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("offspring tag %s is listed twice", tag)})
+			return
+		}
+		seen[tag] = true
+		o.TagID = tag
+		sex, ok := normalizeSex(o.Sex)
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "offspring sex must be female or male"})
+			return
+		}
+		o.Sex = sex
+		o.Breed = strings.TrimSpace(o.Breed)
+		if o.WeightKg != nil && *o.WeightKg <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "offspring weightKg must be positive"})
+			return
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "breeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		var motherID int64
+		var fatherID *int64
+		var animalType, breed, sireName, sireCode string
+		err := tx.QueryRow(ctx, `
+			SELECT b.mother_animal_id, b.father_animal_id, m.type, m.breed, b.ai_sire_name, b.ai_sire_code
+			FROM breeding_records b
+			JOIN animals m ON m.id = b.mother_animal_id
+			WHERE b.id = $1 AND b.farm_id = $2
+			FOR UPDATE OF b
+		`, recordID, farmID).Scan(&motherID, &fatherID, &animalType, &breed, &sireName, &sireCode)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if len(in.Offspring) > 0 {
+			var registered int
+			if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM animals WHERE birth_record_id = $1`, recordID).Scan(&registered); err != nil {
+				return 0, err
+			}
+			if registered > 0 {
+				return 0, &httpError{http.StatusConflict, "offspring are already registered for this birth"}
+			}
+			prefix, checkPrefix := expectedTagPrefixByType(animalType)
+			if fatherID == nil && sireCode != "" {
+				if sireName == "" {
+					sireName = sireCode
+				} else {
+					sireName += " (" + sireCode + ")"
+				}
+			}
+			if fatherID != nil {
+				sireName = ""
+			}
+			for _, o := range in.Offspring {
+				if checkPrefix && !strings.HasPrefix(o.TagID, prefix) {
+					return 0, &httpError{http.StatusBadRequest, fmt.Sprintf("offspring tagId must start with %s for %s", prefix, animalType)}
+				}
+				offspringBreed := o.Breed
+				if offspringBreed == "" {
+					offspringBreed = breed
+				}
+				var id int64
+				err := tx.QueryRow(ctx, `
+					INSERT INTO animals(farm_id, tag_id, type, breed, birth_date, weight_kg, health_status, status, is_active,
+						sex, mother_id, father_id, sire_name, birth_record_id)
+					VALUES ($1, $2, $3, $4, $5, $6, 'healthy', 'active', true, $7, $8, $9, $10, $11)
+					RETURNING id
+				`, farmID, o.TagID, animalType, offspringBreed, actualBirthDate, o.WeightKg, o.Sex, motherID, fatherID, sireName, recordID).Scan(&id)
+				if err != nil {
+					if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
+						return 0, &httpError{http.StatusConflict, fmt.Sprintf("tag ID %s already exists", o.TagID)}
+					}
+					return 0, err
+				}
+				if err := s.recordAudit(ctx, tx, r, auditCreate, "animals", id, nil); err != nil {
+					return 0, err
+				}
+				eventID, err := insertAnimalEvent(ctx, tx, r, farmID, animalEvent{
+					animalID:         id,
+					eventType:        animalEventBirth,
+					date:             actualBirthDate,
+					breedingRecordID: &recordID,
+				})
+				if err != nil {
+					return 0, err
+				}
+				if err := s.recordAudit(ctx, tx, r, auditCreate, "animal_events", eventID, nil); err != nil {
+					return 0, err
+				}
+				if err := s.applyAutoHealthProtocols(ctx, tx, farmID, id, animalType); err != nil {
+					return 0, err
+				}
+			}
+		}
 
-	res, err := s.db.Exec(ctx, `
-		UPDATE breeding_records
-		SET actual_birth_date = $1, offspring_count = $2, status = 'completed'
-		WHERE id = $3
-	`, actualBirthDate, offspringCount, recordID)
+		res, err := tx.Exec(ctx, `
+			UPDATE breeding_records
+			SET actual_birth_date = $1, offspring_count = $2, status = 'completed'
+			WHERE id = $3 AND farm_id = $4
+		`, actualBirthDate, offspringCount, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record birth"})
+		respondHTTPError(w, err, "failed to record birth")
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "registered": len(in.Offspring)})
 }
 
-func (s *Server) handleCreateProductionLog(w http.ResponseWriter, r *http.Request) {
-	const (
-		defaultMilkRate     = 60.0
-		defaultMilkCowRate  = 60.0
-		defaultMilkGoatRate = 80.0
-		defaultEggRate      = 15.0
-		defaultWoolRate     = 500.0
-		defaultMeatRate     = 450.0
-	)
+type productionLogInput struct {
+	Date                string   `json:"date"`
+	MilkLiters          *float64 `json:"milkLiters"`
+	MilkCowLiters       *float64 `json:"milkCowLiters"`
+	MilkGoatLiters      *float64 `json:"milkGoatLiters"`
+	EggsCount           *int     `json:"eggsCount"`
+	WoolKg              *float64 `json:"woolKg"`
+	MeatKg              *float64 `json:"meatKg"`
+	MilkRate            *float64 `json:"milkRate"`
+	MilkCowRate         *float64 `json:"milkCowRate"`
+	MilkGoatRate        *float64 `json:"milkGoatRate"`
+	EggRate             *float64 `json:"eggRate"`
+	WoolRate            *float64 `json:"woolRate"`
+	MeatRate            *float64 `json:"meatRate"`
+	TotalValue          *float64 `json:"totalValue"`
+	ManualTotalOverride bool     `json:"manualTotalOverride"`
+}
 
-	var in struct {
-		Date                string   `json:"date"`
-		MilkLiters          *float64 `json:"milkLiters"`
-		MilkCowLiters       *float64 `json:"milkCowLiters"`
-		MilkGoatLiters      *float64 `json:"milkGoatLiters"`
-		EggsCount           *int     `json:"eggsCount"`
-		WoolKg              *float64 `json:"woolKg"`
-		MeatKg              *float64 `json:"meatKg"`
-		MilkRate            *float64 `json:"milkRate"`
-		MilkCowRate         *float64 `json:"milkCowRate"`
-		MilkGoatRate        *float64 `json:"milkGoatRate"`
-		EggRate             *float64 `json:"eggRate"`
-		WoolRate            *float64 `json:"woolRate"`
-		MeatRate            *float64 `json:"meatRate"`
-		TotalValue          *float64 `json:"totalValue"`
-		ManualTotalOverride bool     `json:"manualTotalOverride"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
+type productionLog struct {
+	date       time.Time
+	day        herdDay
+	totalValue *float64
+}
+
+func normalizeProductionLogInput(in productionLogInput) (productionLog, string) {
+	var p productionLog
 	if strings.TrimSpace(in.Date) == "" {
 		in.Date = time.Now().Format("2006-01-02")
 	}
 	d, err := time.Parse("2006-01-02", in.Date)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
-		return
+		return p, "date must be YYYY-MM-DD"
 	}
+	p.date = d
 	milk := 0.0
 	milkCow := 0.0
 	milkGoat := 0.0
 	eggs := 0
 	wool := 0.0
 	meat := 0.0
-	milkRate := defaultMilkRate
-	milkCowRate := defaultMilkCowRate
-	milkGoatRate := defaultMilkGoatRate
-	eggRate := defaultEggRate
-	woolRate := defaultWoolRate
-	meatRate := defaultMeatRate
-	totalValue := 0.0
 	if in.MilkLiters != nil {
 		milk = *in.MilkLiters
 	}
@@ -964,23 +1408,9 @@ func (s *Server) handleCreateProductionLog(w http.ResponseWriter, r *http.Reques
 	if in.MeatKg != nil {
 		meat = *in.MeatKg
 	}
-	if in.MilkRate != nil {
-		milkRate = *in.MilkRate
-	}
-	if in.MilkCowRate != nil {
-		milkCowRate = *in.MilkCowRate
-	}
-	if in.MilkGoatRate != nil {
-		milkGoatRate = *in.MilkGoatRate
-	}
-	if in.EggRate != nil {
-		eggRate = *in.EggRate
-	}
-	if in.WoolRate != nil {
-		woolRate = *in.WoolRate
-	}
-	if in.MeatRate != nil {
-		meatRate = *in.MeatRate
+	overrides := map[string]*float64{
+		"milk": in.MilkRate, "milk:cow": in.MilkCowRate, "milk:goat": in.MilkGoatRate,
+		"eggs": in.EggRate, "wool": in.WoolRate, "meat": in.MeatRate,
 	}
 
 	milkTotalFromVariants := milkCow + milkGoat
@@ -988,237 +1418,271 @@ func (s *Server) handleCreateProductionLog(w http.ResponseWriter, r *http.Reques
 		milk = milkTotalFromVariants
 	}
 
-	if milk < 0 || milkCow < 0 || milkGoat < 0 || eggs < 0 || wool < 0 || meat < 0 || milkRate < 0 || milkCowRate < 0 || milkGoatRate < 0 || eggRate < 0 || woolRate < 0 || meatRate < 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "production values cannot be negative"})
-		return
+	if milk < 0 || milkCow < 0 || milkGoat < 0 || eggs < 0 || wool < 0 || meat < 0 || negativeRate(overrides) {
+		return p, "production values cannot be negative"
 	}
+	if in.ManualTotalOverride && (in.TotalValue == nil || *in.TotalValue < 0) {
+		return p, "manual totalValue must be provided and non-negative"
+	}
+	p.day = herdDay{milk: milk, milkCow: milkCow, milkGoat: milkGoat, eggs: eggs, wool: wool, meat: meat, overrides: overrides}
 	if in.ManualTotalOverride {
-		if in.TotalValue == nil || *in.TotalValue < 0 {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "manual totalValue must be provided and non-negative"})
-			return
-		}
-		totalValue = *in.TotalValue
-	} else {
-		if milkTotalFromVariants > 0 {
-			totalValue = milkCow*milkCowRate + milkGoat*milkGoatRate
-		} else {
-			totalValue = milk * milkRate
-		}
-		totalValue += float64(eggs)*eggRate + wool*woolRate + meat*meatRate
+		p.totalValue = in.TotalValue
 	}
+	return p, ""
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO production_logs(log_date, milk_liters, milk_cow_liters, milk_goat_liters, eggs_count, wool_kg, meat_kg, total_value)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (log_date) DO UPDATE
-		SET milk_liters = EXCLUDED.milk_liters,
-			milk_cow_liters = EXCLUDED.milk_cow_liters,
-			milk_goat_liters = EXCLUDED.milk_goat_liters,
-			eggs_count = EXCLUDED.eggs_count,
-			wool_kg = EXCLUDED.wool_kg,
-			meat_kg = EXCLUDED.meat_kg,
-			total_value = EXCLUDED.total_value
-	`, d, milk, milkCow, milkGoat, eggs, wool, meat, totalValue)
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create production log"})
-		return
+func (p productionLog) entries(prices priceList) []productionEntry {
+	entries := p.day.entries(prices, p.date)
+	if p.totalValue != nil {
+		entries = withTotalValue(entries, *p.totalValue)
 	}
-	respondJSON(w, http.StatusCreated, map[string]any{"ok": true})
+	return entries
 }
 
-func (s *Server) handleUpdateProductionLog(w http.ResponseWriter, r *http.Request) {
-	const (
-		defaultMilkRate     = 60.0
-		defaultMilkCowRate  = 60.0
-		defaultMilkGoatRate = 80.0
-		defaultEggRate      = 15.0
-		defaultWoolRate     = 500.0
-		defaultMeatRate     = 450.0
-	)
-
-	logID, err := parsePathID(r, "id")
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid log id"})
+func (s *Server) handleCreateProductionLog(w http.ResponseWriter, r *http.Request) {
+	var in productionLogInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 		return
 	}
-	var in struct {
-		Date                string   `json:"date"`
-		MilkLiters          float64  `json:"milkLiters"`
-		MilkCowLiters       float64  `json:"milkCowLiters"`
-		MilkGoatLiters      float64  `json:"milkGoatLiters"`
-		EggsCount           int      `json:"eggsCount"`
-		WoolKg              float64  `json:"woolKg"`
-		MeatKg              float64  `json:"meatKg"`
-		MilkRate            *float64 `json:"milkRate"`
-		MilkCowRate         *float64 `json:"milkCowRate"`
-		MilkGoatRate        *float64 `json:"milkGoatRate"`
-		EggRate             *float64 `json:"eggRate"`
-		WoolRate            *float64 `json:"woolRate"`
-		MeatRate            *float64 `json:"meatRate"`
-		TotalValue          float64  `json:"totalValue"`
-		ManualTotalOverride bool     `json:"manualTotalOverride"`
+	p, msg := normalizeProductionLogInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	prices, err := s.loadPriceList(ctx, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load prices"})
 		return
 	}
-	d, err := time.Parse("2006-01-02", in.Date)
+
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create production log"})
 		return
 	}
-	milkRate := defaultMilkRate
-	milkCowRate := defaultMilkCowRate
-	milkGoatRate := defaultMilkGoatRate
-	eggRate := defaultEggRate
-	woolRate := defaultWoolRate
-	meatRate := defaultMeatRate
-	if in.MilkRate != nil {
-		milkRate = *in.MilkRate
+	defer tx.Rollback(ctx)
+
+	// Posting a day that already has herd records overwrites them; records
+	// kept against individual animals are left alone.
+	if _, err := s.replaceHerdDay(ctx, tx, r, farmID, p.date, p.date, p.entries(prices)); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create production log"})
+		return
 	}
-	if in.MilkCowRate != nil {
-		milkCowRate = *in.MilkCowRate
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create production log"})
+		return
 	}
-	if in.MilkGoatRate != nil {
-		milkGoatRate = *in.MilkGoatRate
+	// The herd total cannot say whose milk is in it, so animals under a
+	// withdrawal are a reminder rather than a refusal.
+	warnings := make([]string, 0)
+	if p.day.milk+p.day.milkCow+p.day.milkGoat > 0 {
+		warnings = s.withdrawalWarnings(ctx, farmID, p.date, "milk")
 	}
-	if in.EggRate != nil {
-		eggRate = *in.EggRate
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "warnings": warnings})
+}
+
+func (s *Server) handleUpdateProductionLog(w http.ResponseWriter, r *http.Request) {
+	logDate, err := time.Parse("2006-01-02", r.PathValue("date"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid log date"})
+		return
 	}
-	if in.WoolRate != nil {
-		woolRate = *in.WoolRate
+	var in productionLogInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
 	}
-	if in.MeatRate != nil {
-		meatRate = *in.MeatRate
+	if strings.TrimSpace(in.Date) == "" {
+		in.Date = logDate.Format("2006-01-02")
 	}
-
-	milkTotalFromVariants := in.MilkCowLiters + in.MilkGoatLiters
-	if milkTotalFromVariants > 0 {
-		in.MilkLiters = milkTotalFromVariants
+	p, msg := normalizeProductionLogInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
 	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	if in.MilkLiters < 0 || in.MilkCowLiters < 0 || in.MilkGoatLiters < 0 || in.EggsCount < 0 || in.WoolKg < 0 || in.MeatKg < 0 || milkRate < 0 || milkCowRate < 0 || milkGoatRate < 0 || eggRate < 0 || woolRate < 0 || meatRate < 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "production values cannot be negative"})
+	prices, err := s.loadPriceList(ctx, farmID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load prices"})
 		return
 	}
-	totalValue := in.TotalValue
-	if in.ManualTotalOverride {
-		if in.TotalValue < 0 {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "manual totalValue must be non-negative"})
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update production log"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if !p.date.Equal(logDate) {
+		var taken bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM production_records WHERE farm_id = $1 AND record_date = $2 AND animal_id IS NULL)
+		`, farmID, p.date).Scan(&taken); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update production log"})
 			return
 		}
-	} else {
-		if milkTotalFromVariants > 0 {
-			totalValue = in.MilkCowLiters*milkCowRate + in.MilkGoatLiters*milkGoatRate
-		} else {
-			totalValue = in.MilkLiters * milkRate
+		if taken {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "a log already exists for that date"})
+			return
 		}
-		totalValue += float64(in.EggsCount)*eggRate + in.WoolKg*woolRate + in.MeatKg*meatRate
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE production_logs
-		SET log_date = $1, milk_liters = $2, milk_cow_liters = $3, milk_goat_liters = $4,
-			eggs_count = $5, wool_kg = $6, meat_kg = $7, total_value = $8
-		WHERE id = $9
-	`, d, in.MilkLiters, in.MilkCowLiters, in.MilkGoatLiters, in.EggsCount, in.WoolKg, in.MeatKg, totalValue, logID)
+	replaced, err := s.replaceHerdDay(ctx, tx, r, farmID, logDate, p.date, p.entries(prices))
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update production log"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if replaced == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "log not found"})
 		return
 	}
-	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update production log"})
+		return
+	}
+	warnings := make([]string, 0)
+	if p.day.milk+p.day.milkCow+p.day.milkGoat > 0 {
+		warnings = s.withdrawalWarnings(ctx, farmID, p.date, "milk")
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true, "warnings": warnings})
 }
 
 func (s *Server) handleDeleteProductionLog(w http.ResponseWriter, r *http.Request) {
-	logID, err := parsePathID(r, "id")
+	logDate, err := time.Parse("2006-01-02", r.PathValue("date"))
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid log id"})
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid log date"})
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM production_logs WHERE id = $1`, logID)
+	farmID := farmIDFrom(ctx)
+
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete production log"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	defer tx.Rollback(ctx)
+
+	replaced, err := s.replaceHerdDay(ctx, tx, r, farmID, logDate, logDate, nil)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete production log"})
+		return
+	}
+	if replaced == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "log not found"})
 		return
 	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete production log"})
+		return
+	}
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-func (s *Server) handleCreateExpense(w http.ResponseWriter, r *http.Request) {
-	var in struct {
-		Date     string  `json:"date"`
-		Category string  `json:"category"`
-		Item     string  `json:"item"`
-		Vendor   string  `json:"vendor"`
-		Amount   float64 `json:"amount"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
+type expenseInput struct {
+	Date       string  `json:"date"`
+	Category   string  `json:"category"`
+	Item       string  `json:"item"`
+	Vendor     string  `json:"vendor"`
+	SupplierID int64   `json:"supplierId"`
+	Amount     float64 `json:"amount"`
+}
 
+type expense struct {
+	expenseInput
+	date time.Time
+}
+
+func normalizeExpenseInput(in expenseInput) (expense, string) {
 	in.Category = strings.TrimSpace(in.Category)
 	in.Item = strings.TrimSpace(in.Item)
 	in.Vendor = strings.TrimSpace(in.Vendor)
 	if in.Date == "" {
 		in.Date = time.Now().Format("2006-01-02")
 	}
-	if in.Category == "" || in.Item == "" || in.Vendor == "" || in.Amount <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date, category, item, vendor and positive amount are required"})
-		return
+	e := expense{expenseInput: in}
+	if in.Category == "" || in.Item == "" || (in.Vendor == "" && in.SupplierID <= 0) || in.Amount <= 0 {
+		return e, "date, category, item, vendor and positive amount are required"
 	}
 
 	d, err := time.Parse("2006-01-02", in.Date)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
+		return e, "date must be YYYY-MM-DD"
+	}
+	e.date = d
+	return e, ""
+}
+
+func insertExpense(ctx context.Context, tx pgx.Tx, farmID int64, e expense) (int64, error) {
+	sp, err := resolveSupplier(ctx, tx, farmID, e.SupplierID, e.Vendor)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO expenses(expense_date, category, item, vendor, supplier_id, amount, farm_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, e.date, e.Category, e.Item, sp.name, sp.id, e.Amount, farmID).Scan(&id)
+	return id, err
+}
+
+func (s *Server) handleCreateExpense(w http.ResponseWriter, r *http.Request) {
+	var in expenseInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	e, msg := normalizeExpenseInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO expenses(expense_date, category, item, vendor, amount)
-		VALUES ($1, $2, $3, $4, $5)
-	`, d, in.Category, in.Item, in.Vendor, in.Amount)
+	_, err := s.auditedWrite(ctx, r, auditCreate, "expenses", 0, func(tx pgx.Tx) (int64, error) {
+		return insertExpense(ctx, tx, farmID, e)
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create expense"})
+		respondHTTPError(w, err, "failed to create expense")
 		return
 	}
 
 	respondJSON(w, http.StatusCreated, map[string]any{"ok": true})
 }
 
-func (s *Server) handleCreateFeedingRecord(w http.ResponseWriter, r *http.Request) {
-	var in struct {
-		Date         string  `json:"date"`
-		AnimalTagID  string  `json:"animalTagId"`
-		RationID     *int64  `json:"rationId"`
-		PlanID       *int64  `json:"planId"`
-		FeedType     string  `json:"feedType"`
-		QuantityValue float64 `json:"quantityValue"`
-		QuantityUnit string  `json:"quantityUnit"`
-		Supplier     string  `json:"supplier"`
-		Cost         float64 `json:"cost"`
-		Notes        string  `json:"notes"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
+type feedingRecordInput struct {
+	Date         string  `json:"date"`
+	AnimalTagID  string  `json:"animalTagId"`
+	RationID     *int64  `json:"rationId"`
+	PlanID       *int64  `json:"planId"`
+	FeedType     string  `json:"feedType"`
+	QuantityValue float64 `json:"quantityValue"`
+	QuantityUnit string  `json:"quantityUnit"`
+	Supplier     string  `json:"supplier"`
+	SupplierID   int64   `json:"supplierId"`
+	Cost         float64 `json:"cost"`
+	Notes        string  `json:"notes"`
+}
 
+type feedingRecord struct {
+	feedingRecordInput
+	feedDate time.Time
+}
+
+func normalizeFeedingRecordInput(in feedingRecordInput) (feedingRecord, string) {
 	in.FeedType = strings.TrimSpace(in.FeedType)
 	in.QuantityUnit = strings.TrimSpace(in.QuantityUnit)
 	in.Supplier = strings.TrimSpace(in.Supplier)
@@ -1226,74 +1690,115 @@ func (s *Server) handleCreateFeedingRecord(w http.ResponseWriter, r *http.Reques
 	if in.Date == "" {
 		in.Date = time.Now().Format("2006-01-02")
 	}
-	if in.QuantityUnit == "" {
-		in.QuantityUnit = "kg"
+	f := feedingRecord{feedingRecordInput: in}
+	unit, ok := normalizeUnit(in.QuantityUnit, "kg")
+	if !ok {
+		return f, "quantityUnit must be one of " + unitCodes()
 	}
+	f.QuantityUnit = unit
 	if in.FeedType == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "feedType is required"})
-		return
+		return f, "feedType is required"
 	}
 	if in.QuantityValue < 0 || in.Cost < 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantityValue and cost must be non-negative"})
-		return
+		return f, "quantityValue and cost must be non-negative"
 	}
 
 	feedDate, err := time.Parse("2006-01-02", in.Date)
 	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
-		return
+		return f, "date must be YYYY-MM-DD"
 	}
+	f.feedDate = feedDate
 
-	var animalID *int64
 	if strings.TrimSpace(in.AnimalTagID) != "" {
 		tagID, ok := normalizeAnimalTag(in.AnimalTagID)
 		if !ok {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"})
-			return
+			return f, "animalTagId must be 2-24 chars (A-Z, 0-9, hyphen)"
 		}
-		in.AnimalTagID = tagID
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
+		f.AnimalTagID = tagID
+	}
+	return f, ""
+}
+
+// insertFeedingRecord looks up the animal, ration and plan a record points at,
+// stores it and issues it against feed stock.
+func insertFeedingRecord(ctx context.Context, tx pgx.Tx, farmID int64, f feedingRecord) (int64, error) {
+	var animalID *int64
+	if f.AnimalTagID != "" {
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, in.AnimalTagID).Scan(&id); err != nil {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animal not found"})
-			return
+		if err := tx.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, f.AnimalTagID, farmID).Scan(&id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, &httpError{http.StatusBadRequest, "animal not found"}
+			}
+			return 0, err
 		}
 		animalID = &id
 	}
 
 	var rationID *int64
-	if in.RationID != nil && *in.RationID > 0 {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
+	if f.RationID != nil && *f.RationID > 0 {
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1`, *in.RationID).Scan(&id); err != nil {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration not found"})
-			return
+		if err := tx.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1 AND farm_id = $2`, *f.RationID, farmID).Scan(&id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, &httpError{http.StatusBadRequest, "ration not found"}
+			}
+			return 0, err
 		}
 		rationID = &id
 	}
 
 	var planID *int64
-	if in.PlanID != nil && *in.PlanID > 0 {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
+	if f.PlanID != nil && *f.PlanID > 0 {
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_plans WHERE id = $1`, *in.PlanID).Scan(&id); err != nil {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "feeding plan not found"})
-			return
+		if err := tx.QueryRow(ctx, `SELECT id FROM feeding_plans WHERE id = $1 AND farm_id = $2`, *f.PlanID, farmID).Scan(&id); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, &httpError{http.StatusBadRequest, "feeding plan not found"}
+			}
+			return 0, err
 		}
 		planID = &id
 	}
 
+	var supplierID *int64
+	if f.Supplier != "" || f.SupplierID > 0 {
+		sp, err := resolveSupplier(ctx, tx, farmID, f.SupplierID, f.Supplier)
+		if err != nil {
+			return 0, err
+		}
+		f.Supplier, supplierID = sp.name, &sp.id
+	}
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO feeding_records(feed_date, animal_id, ration_id, plan_id, feed_type, quantity_value, quantity_unit, supplier, supplier_id, cost, notes, farm_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`, f.feedDate, animalID, rationID, planID, f.FeedType, f.QuantityValue, f.QuantityUnit, f.Supplier, supplierID, f.Cost, f.Notes, farmID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, issueFeedingRecord(ctx, tx, id, f.FeedType)
+}
+
+func (s *Server) handleCreateFeedingRecord(w http.ResponseWriter, r *http.Request) {
+	var in feedingRecordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	f, msg := normalizeFeedingRecordInput(in)
+	if msg != "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO feeding_records(feed_date, animal_id, ration_id, plan_id, feed_type, quantity_value, quantity_unit, supplier, cost, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-	`, feedDate, animalID, rationID, planID, in.FeedType, in.QuantityValue, in.QuantityUnit, in.Supplier, in.Cost, in.Notes)
+	farmID := farmIDFrom(ctx)
+
+	_, err := s.auditedWrite(ctx, r, auditCreate, "feeding_records", 0, func(tx pgx.Tx) (int64, error) {
+		return insertFeedingRecord(ctx, tx, farmID, f)
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create feeding record"})
+		respondHTTPError(w, err, "failed to create feeding record")
 		return
 	}
 	respondJSON(w, http.StatusCreated, map[string]any{"ok": true})
@@ -1315,6 +1820,7 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 		QuantityValue float64 `json:"quantityValue"`
 		QuantityUnit string  `json:"quantityUnit"`
 		Supplier     string  `json:"supplier"`
+		SupplierID   int64   `json:"supplierId"`
 		Cost         float64 `json:"cost"`
 		Notes        string  `json:"notes"`
 	}
@@ -1327,9 +1833,12 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 	in.QuantityUnit = strings.TrimSpace(in.QuantityUnit)
 	in.Supplier = strings.TrimSpace(in.Supplier)
 	in.Notes = strings.TrimSpace(in.Notes)
-	if in.QuantityUnit == "" {
-		in.QuantityUnit = "kg"
+	unit, ok := normalizeUnit(in.QuantityUnit, "kg")
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "quantityUnit must be one of " + unitCodes()})
+		return
 	}
+	in.QuantityUnit = unit
 	if in.FeedType == "" {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "feedType is required"})
 		return
@@ -1344,6 +1853,7 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	farmID := farmIDFrom(r.Context())
 	var animalID *int64
 	if strings.TrimSpace(in.AnimalTagID) != "" {
 		tagID, ok := normalizeAnimalTag(in.AnimalTagID)
@@ -1355,7 +1865,7 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, in.AnimalTagID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, in.AnimalTagID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animal not found"})
 			return
 		}
@@ -1367,7 +1877,7 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1`, *in.RationID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1 AND farm_id = $2`, *in.RationID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration not found"})
 			return
 		}
@@ -1379,7 +1889,7 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_plans WHERE id = $1`, *in.PlanID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_plans WHERE id = $1 AND farm_id = $2`, *in.PlanID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "feeding plan not found"})
 			return
 		}
@@ -1388,16 +1898,30 @@ func (s *Server) handleUpdateFeedingRecord(w http.ResponseWriter, r *http.Reques
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE feeding_records
-		SET feed_date = $1, animal_id = $2, ration_id = $3, plan_id = $4, feed_type = $5, quantity_value = $6, quantity_unit = $7, supplier = $8, cost = $9, notes = $10
-		WHERE id = $11
-	`, feedDate, animalID, rationID, planID, in.FeedType, in.QuantityValue, in.QuantityUnit, in.Supplier, in.Cost, in.Notes, recordID)
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "feeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		var supplierID *int64
+		if in.Supplier != "" || in.SupplierID > 0 {
+			sp, err := resolveSupplier(ctx, tx, farmID, in.SupplierID, in.Supplier)
+			if err != nil {
+				return 0, err
+			}
+			in.Supplier, supplierID = sp.name, &sp.id
+		}
+		res, err := tx.Exec(ctx, `
+			UPDATE feeding_records
+			SET feed_date = $1, animal_id = $2, ration_id = $3, plan_id = $4, feed_type = $5, quantity_value = $6, quantity_unit = $7, supplier = $8, supplier_id = $9, cost = $10, notes = $11
+			WHERE id = $12 AND farm_id = $13
+		`, feedDate, animalID, rationID, planID, in.FeedType, in.QuantityValue, in.QuantityUnit, in.Supplier, supplierID, in.Cost, in.Notes, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, issueFeedingRecord(ctx, tx, recordID, in.FeedType)
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update feeding record"})
+		respondHTTPError(w, err, "failed to update feeding record")
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -1412,12 +1936,19 @@ func (s *Server) handleDeleteFeedingRecord(w http.ResponseWriter, r *http.Reques
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM feeding_records WHERE id = $1`, recordID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "feeding_records", recordID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM feeding_records WHERE id = $1 AND farm_id = $2`, recordID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return recordID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete feeding record"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
 		return
 	}
@@ -1451,6 +1982,7 @@ func (s *Server) handleCreateFeedingRation(w http.ResponseWriter, r *http.Reques
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ration"})
@@ -1460,36 +1992,46 @@ func (s *Server) handleCreateFeedingRation(w http.ResponseWriter, r *http.Reques
 
 	var rationID int64
 	if err := tx.QueryRow(ctx, `
-		INSERT INTO feeding_rations(name, species, state, notes)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO feeding_rations(name, species, state, notes, farm_id)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id
-	`, in.Name, in.Species, in.State, in.Notes).Scan(&rationID); err != nil {
+	`, in.Name, in.Species, in.State, in.Notes, farmID).Scan(&rationID); err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ration"})
 		return
 	}
 
 	for _, item := range in.Items {
 		ingredient := strings.TrimSpace(item.Ingredient)
-		unit := strings.TrimSpace(item.Unit)
 		if ingredient == "" {
 			continue
 		}
-		if unit == "" {
-			unit = "kg"
+		unit, ok := normalizeUnit(item.Unit, "kg")
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration item unit must be one of " + unitCodes()})
+			return
 		}
 		if item.Quantity < 0 {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration item quantity must be non-negative"})
 			return
 		}
+		feedItemID, err := linkFeedItem(ctx, tx, farmID, ingredient, unit)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ration items"})
+			return
+		}
 		if _, err := tx.Exec(ctx, `
-			INSERT INTO feeding_ration_items(ration_id, ingredient, quantity_value, quantity_unit)
-			VALUES ($1, $2, $3, $4)
-		`, rationID, ingredient, item.Quantity, unit); err != nil {
+			INSERT INTO feeding_ration_items(ration_id, ingredient, quantity_value, quantity_unit, feed_item_id)
+			VALUES ($1, $2, $3, $4, $5)
+		`, rationID, ingredient, item.Quantity, unit, feedItemID); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ration items"})
 			return
 		}
 	}
 
+	if err := s.recordAudit(ctx, tx, r, auditCreate, "feeding_rations", rationID, nil); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ration"})
+		return
+	}
 	if err := tx.Commit(ctx); err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ration"})
 		return
@@ -1530,6 +2072,7 @@ func (s *Server) handleUpdateFeedingRation(w http.ResponseWriter, r *http.Reques
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration"})
@@ -1537,11 +2080,16 @@ func (s *Server) handleUpdateFeedingRation(w http.ResponseWriter, r *http.Reques
 	}
 	defer tx.Rollback(ctx)
 
+	before, err := auditSnapshot(ctx, tx, "feeding_rations", rationID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration"})
+		return
+	}
 	res, err := tx.Exec(ctx, `
 		UPDATE feeding_rations
 		SET name = $1, species = $2, state = $3, notes = $4
-		WHERE id = $5
-	`, in.Name, in.Species, in.State, in.Notes, rationID)
+		WHERE id = $5 AND farm_id = $6
+	`, in.Name, in.Species, in.State, in.Notes, rationID, farmID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration"})
 		return
@@ -1557,26 +2105,36 @@ func (s *Server) handleUpdateFeedingRation(w http.ResponseWriter, r *http.Reques
 	}
 	for _, item := range in.Items {
 		ingredient := strings.TrimSpace(item.Ingredient)
-		unit := strings.TrimSpace(item.Unit)
 		if ingredient == "" {
 			continue
 		}
-		if unit == "" {
-			unit = "kg"
+		unit, ok := normalizeUnit(item.Unit, "kg")
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration item unit must be one of " + unitCodes()})
+			return
 		}
 		if item.Quantity < 0 {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration item quantity must be non-negative"})
 			return
 		}
+		feedItemID, err := linkFeedItem(ctx, tx, farmID, ingredient, unit)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration items"})
+			return
+		}
 		if _, err := tx.Exec(ctx, `
-			INSERT INTO feeding_ration_items(ration_id, ingredient, quantity_value, quantity_unit)
-			VALUES ($1, $2, $3, $4)
-		`, rationID, ingredient, item.Quantity, unit); err != nil {
+			INSERT INTO feeding_ration_items(ration_id, ingredient, quantity_value, quantity_unit, feed_item_id)
+			VALUES ($1, $2, $3, $4, $5)
+		`, rationID, ingredient, item.Quantity, unit, feedItemID); err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration items"})
 			return
 		}
 	}
 
+	if err := s.recordAudit(ctx, tx, r, auditUpdate, "feeding_rations", rationID, before); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration"})
+		return
+	}
 	if err := tx.Commit(ctx); err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update ration"})
 		return
@@ -1592,12 +2150,19 @@ func (s *Server) handleDeleteFeedingRation(w http.ResponseWriter, r *http.Reques
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM feeding_rations WHERE id = $1`, rationID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "feeding_rations", rationID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM feeding_rations WHERE id = $1 AND farm_id = $2`, rationID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return rationID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete ration"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ration not found"})
 		return
 	}
@@ -1624,9 +2189,12 @@ func (s *Server) handleCreateFeedingPlan(w http.ResponseWriter, r *http.Request)
 	in.DailyQuantityUnit = strings.TrimSpace(in.DailyQuantityUnit)
 	in.Status = strings.TrimSpace(in.Status)
 	in.Notes = strings.TrimSpace(in.Notes)
-	if in.DailyQuantityUnit == "" {
-		in.DailyQuantityUnit = "kg"
+	unit, ok := normalizeUnit(in.DailyQuantityUnit, "kg")
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "dailyQuantityUnit must be one of " + unitCodes()})
+		return
 	}
+	in.DailyQuantityUnit = unit
 	if in.StartDate == "" {
 		in.StartDate = time.Now().Format("2006-01-02")
 	}
@@ -1652,6 +2220,7 @@ func (s *Server) handleCreateFeedingPlan(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	farmID := farmIDFrom(r.Context())
 	var animalID *int64
 	if strings.TrimSpace(in.AnimalTagID) != "" {
 		tagID, ok := normalizeAnimalTag(in.AnimalTagID)
@@ -1663,7 +2232,7 @@ func (s *Server) handleCreateFeedingPlan(w http.ResponseWriter, r *http.Request)
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND is_active = true`, in.AnimalTagID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2 AND is_active = true`, in.AnimalTagID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animal not found"})
 			return
 		}
@@ -1675,7 +2244,7 @@ func (s *Server) handleCreateFeedingPlan(w http.ResponseWriter, r *http.Request)
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1`, *in.RationID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1 AND farm_id = $2`, *in.RationID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration not found"})
 			return
 		}
@@ -1684,10 +2253,15 @@ func (s *Server) handleCreateFeedingPlan(w http.ResponseWriter, r *http.Request)
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO feeding_plans(animal_id, ration_id, animal_state, daily_quantity_value, daily_quantity_unit, start_date, end_date, status, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`, animalID, rationID, in.AnimalState, in.DailyQuantityValue, in.DailyQuantityUnit, startDate, endDate, in.Status, in.Notes)
+	_, err = s.auditedWrite(ctx, r, auditCreate, "feeding_plans", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO feeding_plans(animal_id, ration_id, animal_state, daily_quantity_value, daily_quantity_unit, start_date, end_date, status, notes, farm_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id
+		`, animalID, rationID, in.AnimalState, in.DailyQuantityValue, in.DailyQuantityUnit, startDate, endDate, in.Status, in.Notes, farmID).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create feeding plan"})
 		return
@@ -1721,9 +2295,12 @@ func (s *Server) handleUpdateFeedingPlan(w http.ResponseWriter, r *http.Request)
 	in.DailyQuantityUnit = strings.TrimSpace(in.DailyQuantityUnit)
 	in.Status = strings.TrimSpace(in.Status)
 	in.Notes = strings.TrimSpace(in.Notes)
-	if in.DailyQuantityUnit == "" {
-		in.DailyQuantityUnit = "kg"
+	unit, ok := normalizeUnit(in.DailyQuantityUnit, "kg")
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "dailyQuantityUnit must be one of " + unitCodes()})
+		return
 	}
+	in.DailyQuantityUnit = unit
 	if in.Status == "" {
 		in.Status = "active"
 	}
@@ -1746,6 +2323,7 @@ func (s *Server) handleUpdateFeedingPlan(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	farmID := farmIDFrom(r.Context())
 	var animalID *int64
 	if strings.TrimSpace(in.AnimalTagID) != "" {
 		tagID, ok := normalizeAnimalTag(in.AnimalTagID)
@@ -1757,7 +2335,7 @@ func (s *Server) handleUpdateFeedingPlan(w http.ResponseWriter, r *http.Request)
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1`, in.AnimalTagID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM animals WHERE tag_id = $1 AND farm_id = $2`, in.AnimalTagID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "animal not found"})
 			return
 		}
@@ -1769,7 +2347,7 @@ func (s *Server) handleUpdateFeedingPlan(w http.ResponseWriter, r *http.Request)
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 		var id int64
-		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1`, *in.RationID).Scan(&id); err != nil {
+		if err := s.db.QueryRow(ctx, `SELECT id FROM feeding_rations WHERE id = $1 AND farm_id = $2`, *in.RationID, farmID).Scan(&id); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ration not found"})
 			return
 		}
@@ -1778,16 +2356,22 @@ func (s *Server) handleUpdateFeedingPlan(w http.ResponseWriter, r *http.Request)
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE feeding_plans
-		SET animal_id = $1, ration_id = $2, animal_state = $3, daily_quantity_value = $4, daily_quantity_unit = $5, start_date = $6, end_date = $7, status = $8, notes = $9
-		WHERE id = $10
-	`, animalID, rationID, in.AnimalState, in.DailyQuantityValue, in.DailyQuantityUnit, startDate, endDate, in.Status, in.Notes, planID)
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "feeding_plans", planID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE feeding_plans
+			SET animal_id = $1, ration_id = $2, animal_state = $3, daily_quantity_value = $4, daily_quantity_unit = $5, start_date = $6, end_date = $7, status = $8, notes = $9
+			WHERE id = $10 AND farm_id = $11
+		`, animalID, rationID, in.AnimalState, in.DailyQuantityValue, in.DailyQuantityUnit, startDate, endDate, in.Status, in.Notes, planID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return planID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update feeding plan"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "plan not found"})
 		return
 	}
@@ -1802,12 +2386,19 @@ func (s *Server) handleDeleteFeedingPlan(w http.ResponseWriter, r *http.Request)
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM feeding_plans WHERE id = $1`, planID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "feeding_plans", planID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `DELETE FROM feeding_plans WHERE id = $1 AND farm_id = $2`, planID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return planID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete feeding plan"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "plan not found"})
 		return
 	}
@@ -1821,11 +2412,12 @@ func (s *Server) handleUpdateExpense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var in struct {
-		Date     string  `json:"date"`
-		Category string  `json:"category"`
-		Item     string  `json:"item"`
-		Vendor   string  `json:"vendor"`
-		Amount   float64 `json:"amount"`
+		Date       string  `json:"date"`
+		Category   string  `json:"category"`
+		Item       string  `json:"item"`
+		Vendor     string  `json:"vendor"`
+		SupplierID int64   `json:"supplierId"`
+		Amount     float64 `json:"amount"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
@@ -1836,22 +2428,36 @@ func (s *Server) handleUpdateExpense(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
 		return
 	}
-	if strings.TrimSpace(in.Category) == "" || strings.TrimSpace(in.Item) == "" || strings.TrimSpace(in.Vendor) == "" || in.Amount <= 0 {
+	if strings.TrimSpace(in.Category) == "" || strings.TrimSpace(in.Item) == "" || (strings.TrimSpace(in.Vendor) == "" && in.SupplierID <= 0) || in.Amount <= 0 {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "category, item, vendor and amount are required"})
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE expenses
-		SET expense_date = $1, category = $2, item = $3, vendor = $4, amount = $5
-		WHERE id = $6
-	`, d, strings.TrimSpace(in.Category), strings.TrimSpace(in.Item), strings.TrimSpace(in.Vendor), in.Amount, expenseID)
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "expenses", expenseID, func(tx pgx.Tx) (int64, error) {
+		if found, err := lockExpenseForChange(ctx, tx, farmID, expenseID); err != nil || !found {
+			return 0, err
+		}
+		sp, err := resolveSupplier(ctx, tx, farmID, in.SupplierID, in.Vendor)
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(ctx, `
+			UPDATE expenses
+			SET expense_date = $1, category = $2, item = $3, vendor = $4, supplier_id = $5, amount = $6
+			WHERE id = $7 AND farm_id = $8
+		`, d, strings.TrimSpace(in.Category), strings.TrimSpace(in.Item), sp.name, sp.id, in.Amount, expenseID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return expenseID, nil
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update expense"})
+		respondHTTPError(w, err, "failed to update expense")
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "expense not found"})
 		return
 	}
@@ -1866,214 +2472,23 @@ func (s *Server) handleDeleteExpense(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM expenses WHERE id = $1`, expenseID)
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete expense"})
-		return
-	}
-	if res.RowsAffected() == 0 {
-		respondJSON(w, http.StatusNotFound, map[string]string{"error": "expense not found"})
-		return
-	}
-	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
-
-func (s *Server) handleCreateSale(w http.ResponseWriter, r *http.Request) {
-	var in struct {
-		Date              string   `json:"date"`
-		Product           string   `json:"product"`
-		QuantityValue     float64  `json:"quantityValue"`
-		QuantityUnit      string   `json:"quantityUnit"`
-		Buyer             string   `json:"buyer"`
-		BuyerPIN          string   `json:"buyerPIN"`
-		DeliveryCounty    string   `json:"deliveryCounty"`
-		DeliverySubcounty string   `json:"deliverySubcounty"`
-		VATApplicable     bool     `json:"vatApplicable"`
-		VATRate           *float64 `json:"vatRate"`
-		PricePerUnit      float64  `json:"pricePerUnit"`
-		TotalAmount       *float64 `json:"totalAmount"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
-
-	in.Product = strings.TrimSpace(in.Product)
-	in.QuantityUnit = strings.TrimSpace(in.QuantityUnit)
-	in.Buyer = strings.TrimSpace(in.Buyer)
-	in.BuyerPIN = strings.TrimSpace(in.BuyerPIN)
-	in.DeliveryCounty = strings.TrimSpace(in.DeliveryCounty)
-	in.DeliverySubcounty = strings.TrimSpace(in.DeliverySubcounty)
-	if in.Date == "" {
-		in.Date = time.Now().Format("2006-01-02")
-	}
-	if in.Product == "" || in.QuantityUnit == "" || in.Buyer == "" || in.QuantityValue <= 0 || in.PricePerUnit <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date, product, quantity, unit, buyer and price are required"})
-		return
-	}
-
-	d, err := time.Parse("2006-01-02", in.Date)
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
-		return
-	}
-
-	buyerPIN, ok := normalizeKRAPIN(in.BuyerPIN)
-	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "buyer PIN must be valid KRA format (e.g. A012345678Z)"})
-		return
-	}
-	county, ok := normalizeCounty(in.DeliveryCounty)
-	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "delivery county must be a valid Kenya county"})
-		return
-	}
-	if in.DeliverySubcounty != "" && county == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "delivery county is required when subcounty is provided"})
-		return
-	}
-
-	total := in.QuantityValue * in.PricePerUnit
-	if in.TotalAmount != nil && *in.TotalAmount > 0 {
-		total = *in.TotalAmount
-	}
-	vatRate := 0.0
-	if in.VATApplicable {
-		vatRate = 0.16
-		if in.VATRate != nil {
-			vatRate = *in.VATRate
-		}
-		if vatRate < 0 || vatRate > 1 {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "vatRate must be between 0 and 1"})
-			return
-		}
-	}
-	netAmount := total
-	vatAmount := 0.0
-	if in.VATApplicable && vatRate > 0 {
-		netAmount = total / (1 + vatRate)
-		vatAmount = total - netAmount
-	}
-
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	_, err = s.db.Exec(ctx, `
-		INSERT INTO sales(
-			sale_date, product, quantity_value, quantity_unit, buyer, buyer_pin,
-			delivery_county, delivery_subcounty, vat_applicable, vat_rate, vat_amount, net_amount,
-			price_per_unit, total_amount
-		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
-	`, d, in.Product, in.QuantityValue, in.QuantityUnit, in.Buyer, buyerPIN, county, in.DeliverySubcounty, in.VATApplicable, vatRate, vatAmount, netAmount, in.PricePerUnit, total)
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create sale"})
-		return
-	}
-
-	respondJSON(w, http.StatusCreated, map[string]any{"ok": true})
-}
-
-func (s *Server) handleUpdateSale(w http.ResponseWriter, r *http.Request) {
-	saleID, err := parsePathID(r, "id")
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid sale id"})
-		return
-	}
-	var in struct {
-		Date              string   `json:"date"`
-		Product           string   `json:"product"`
-		QuantityValue     float64  `json:"quantityValue"`
-		QuantityUnit      string   `json:"quantityUnit"`
-		Buyer             string   `json:"buyer"`
-		BuyerPIN          string   `json:"buyerPIN"`
-		DeliveryCounty    string   `json:"deliveryCounty"`
-		DeliverySubcounty string   `json:"deliverySubcounty"`
-		VATApplicable     bool     `json:"vatApplicable"`
-		VATRate           *float64 `json:"vatRate"`
-		PricePerUnit      float64  `json:"pricePerUnit"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
-		return
-	}
-	d, err := time.Parse("2006-01-02", in.Date)
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "date must be YYYY-MM-DD"})
-		return
-	}
-	if strings.TrimSpace(in.Product) == "" || strings.TrimSpace(in.QuantityUnit) == "" || strings.TrimSpace(in.Buyer) == "" || in.QuantityValue <= 0 || in.PricePerUnit <= 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "product, quantity, unit, buyer and price are required"})
-		return
-	}
-	buyerPIN, ok := normalizeKRAPIN(in.BuyerPIN)
-	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "buyer PIN must be valid KRA format (e.g. A012345678Z)"})
-		return
-	}
-	county, ok := normalizeCounty(in.DeliveryCounty)
-	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "delivery county must be a valid Kenya county"})
-		return
-	}
-	subcounty := strings.TrimSpace(in.DeliverySubcounty)
-	if subcounty != "" && county == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "delivery county is required when subcounty is provided"})
-		return
-	}
-	total := in.QuantityValue * in.PricePerUnit
-	vatRate := 0.0
-	if in.VATApplicable {
-		vatRate = 0.16
-		if in.VATRate != nil {
-			vatRate = *in.VATRate
+	farmID := farmIDFrom(ctx)
+	changed, err := s.auditedWrite(ctx, r, auditDelete, "expenses", expenseID, func(tx pgx.Tx) (int64, error) {
+		if found, err := lockExpenseForChange(ctx, tx, farmID, expenseID); err != nil || !found {
+			return 0, err
 		}
-		if vatRate < 0 || vatRate > 1 {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "vatRate must be between 0 and 1"})
-			return
+		res, err := tx.Exec(ctx, `DELETE FROM expenses WHERE id = $1 AND farm_id = $2`, expenseID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
 		}
-	}
-	netAmount := total
-	vatAmount := 0.0
-	if in.VATApplicable && vatRate > 0 {
-		netAmount = total / (1 + vatRate)
-		vatAmount = total - netAmount
-	}
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-	res, err := s.db.Exec(ctx, `
-		UPDATE sales
-		SET sale_date = $1, product = $2, quantity_value = $3, quantity_unit = $4, buyer = $5, buyer_pin = $6,
-			delivery_county = $7, delivery_subcounty = $8, vat_applicable = $9, vat_rate = $10, vat_amount = $11, net_amount = $12,
-			price_per_unit = $13, total_amount = $14
-		WHERE id = $15
-	`, d, strings.TrimSpace(in.Product), in.QuantityValue, strings.TrimSpace(in.QuantityUnit), strings.TrimSpace(in.Buyer), buyerPIN, county, subcounty, in.VATApplicable, vatRate, vatAmount, netAmount, in.PricePerUnit, total, saleID)
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update sale"})
-		return
-	}
-	if res.RowsAffected() == 0 {
-		respondJSON(w, http.StatusNotFound, map[string]string{"error": "sale not found"})
-		return
-	}
-	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
-}
-
-func (s *Server) handleDeleteSale(w http.ResponseWriter, r *http.Request) {
-	saleID, err := parsePathID(r, "id")
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid sale id"})
-		return
-	}
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM sales WHERE id = $1`, saleID)
+		return expenseID, nil
+	})
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete sale"})
+		respondHTTPError(w, err, "failed to delete expense")
 		return
 	}
-	if res.RowsAffected() == 0 {
-		respondJSON(w, http.StatusNotFound, map[string]string{"error": "sale not found"})
+	if changed == 0 {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "expense not found"})
 		return
 	}
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
@@ -2104,11 +2519,8 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		in.Password = "password"
 	}
 
-	allowedRoles := map[string]bool{
-		"owner": true, "manager": true, "worker": true, "veterinarian": true,
-	}
 	allowedStatus := map[string]bool{"active": true, "inactive": true}
-	if in.Name == "" || in.Email == "" || !allowedRoles[in.Role] || !allowedStatus[in.Status] || len(in.Password) < 6 {
+	if in.Name == "" || in.Email == "" || in.Role == "" || !allowedStatus[in.Status] || len(in.Password) < 6 {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "name, email, valid role/status and password(min 6) are required"})
 		return
 	}
@@ -2131,29 +2543,66 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	roleID, roleName, err := s.resolveRole(ctx, in.Role)
+	roleID, roleName, err := s.resolveRole(ctx, farmID, in.Role)
 	if err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role"})
 		return
 	}
+	missing, err := s.missingRoleGrants(ctx, roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check role permissions"})
+		return
+	}
+	if len(missing) > 0 {
+		respondJSON(w, http.StatusForbidden, map[string]any{"error": "cannot assign a role with permissions you do not hold", "permissions": missing})
+		return
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
+		return
+	}
+	defer tx.Rollback(ctx)
 
-	_, err = s.db.Exec(ctx, `
+	var userID int64
+	err = tx.QueryRow(ctx, `
 		INSERT INTO users(name, email, password_hash, role_id, role, phone, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, in.Name, in.Email, string(hash), roleID, roleName, in.Phone, in.Status)
+		VALUES ($1, $2, $3, $4, $5, $6, 'active')
+		RETURNING id
+	`, in.Name, in.Email, string(hash), roleID, roleName, in.Phone).Scan(&userID)
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
-			respondJSON(w, http.StatusConflict, map[string]string{"error": "email already registered"})
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "email already registered; add the existing account to this farm instead"})
 			return
 		}
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
 		return
 	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO farm_members(farm_id, user_id, role_id, status)
+		VALUES ($1, $2, $3, $4)
+	`, farmID, userID, roleID, in.Status); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
+		return
+	}
+	if err := s.recordAudit(ctx, tx, r, auditCreate, "users", userID, nil); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
+		return
+	}
 
 	respondJSON(w, http.StatusCreated, map[string]any{"ok": true})
 }
 
+// handleUpdateUser changes a person's role and status on this farm. Their
+// name, email and password belong to their account, which they manage
+// themselves and may share with other farms.
 func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := parsePathID(r, "id")
 	if err != nil {
@@ -2161,92 +2610,140 @@ func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var in struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Role     string `json:"role"`
-		Phone    string `json:"phone"`
-		Status   string `json:"status"`
-		Password string `json:"password"`
+		Role   string `json:"role"`
+		Status string `json:"status"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
 		return
 	}
-	in.Name = strings.TrimSpace(in.Name)
-	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
 	in.Role = strings.ToLower(strings.TrimSpace(in.Role))
-	in.Phone = strings.TrimSpace(in.Phone)
-	allowedRoles := map[string]bool{
-		"owner": true, "manager": true, "worker": true, "veterinarian": true,
+	in.Status = strings.ToLower(strings.TrimSpace(in.Status))
+	if in.Status == "" {
+		in.Status = "active"
 	}
 	allowedStatus := map[string]bool{"active": true, "inactive": true}
-	if in.Name == "" || in.Email == "" || !allowedRoles[in.Role] || !allowedStatus[in.Status] {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "name, email, valid role and status are required"})
+	if in.Role == "" || !allowedStatus[in.Status] {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "a role and a status of active or inactive are required"})
 		return
 	}
-	if !emailRe.MatchString(in.Email) {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid email format"})
-		return
-	}
-	phone, ok := normalizeKenyaPhone(in.Phone)
-	if !ok {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "phone must be a valid Kenya number (e.g. +2547XXXXXXXX)"})
+	authID, _ := r.Context().Value(userIDContextKey).(int64)
+	if authID == userID && in.Status != "active" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "you cannot deactivate yourself"})
 		return
 	}
-	in.Phone = phone
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	roleID, roleName, err := s.resolveRole(ctx, in.Role)
+	roleID, _, err := s.resolveRole(ctx, farmID, in.Role)
 	if err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role"})
 		return
 	}
-
-	if strings.TrimSpace(in.Password) != "" {
-		if len(in.Password) < 6 {
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "password must be at least 6 characters"})
-			return
-		}
-		hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
-		if err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "password processing failed"})
-			return
-		}
-		res, err := s.db.Exec(ctx, `
-			UPDATE users
-			SET name = $1, email = $2, role_id = $3, role = $4, phone = $5, status = $6, password_hash = $7
-			WHERE id = $8
-		`, in.Name, in.Email, roleID, roleName, in.Phone, in.Status, string(hash), userID)
-		if err != nil {
-			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
-			return
-		}
-		if res.RowsAffected() == 0 {
-			respondJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
-			return
-		}
-		respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+	missing, err := s.missingRoleGrants(ctx, roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check role permissions"})
+		return
+	}
+	if len(missing) > 0 {
+		respondJSON(w, http.StatusForbidden, map[string]any{"error": "cannot assign a role with permissions you do not hold", "permissions": missing})
 		return
 	}
 
-	res, err := s.db.Exec(ctx, `
-		UPDATE users
-		SET name = $1, email = $2, role_id = $3, role = $4, phone = $5, status = $6
-		WHERE id = $7
-	`, in.Name, in.Email, roleID, roleName, in.Phone, in.Status, userID)
+	var memberID int64
+	if err := s.db.QueryRow(ctx, `SELECT id FROM farm_members WHERE farm_id = $1 AND user_id = $2`, farmID, userID).Scan(&memberID); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return
+	}
+	changed, err := s.auditedWrite(ctx, r, auditUpdate, "farm_members", memberID, func(tx pgx.Tx) (int64, error) {
+		res, err := tx.Exec(ctx, `
+			UPDATE farm_members
+			SET role_id = $1, status = $2
+			WHERE id = $3 AND farm_id = $4
+		`, roleID, in.Status, memberID, farmID)
+		if err != nil || res.RowsAffected() == 0 {
+			return 0, err
+		}
+		return memberID, nil
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update user"})
 		return
 	}
-	if res.RowsAffected() == 0 {
+	if changed == 0 {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
 		return
 	}
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+// handleAddFarmMember gives an existing account a role on this farm, for
+// people who already use FarmPro elsewhere.
+func (s *Server) handleAddFarmMember(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request payload"})
+		return
+	}
+	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
+	in.Role = strings.ToLower(strings.TrimSpace(in.Role))
+	if !emailRe.MatchString(in.Email) || in.Role == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "a valid email and a role are required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	farmID := farmIDFrom(ctx)
+
+	roleID, _, err := s.resolveRole(ctx, farmID, in.Role)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid role"})
+		return
+	}
+	missing, err := s.missingRoleGrants(ctx, roleID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check role permissions"})
+		return
+	}
+	if len(missing) > 0 {
+		respondJSON(w, http.StatusForbidden, map[string]any{"error": "cannot assign a role with permissions you do not hold", "permissions": missing})
+		return
+	}
+
+	var userID int64
+	_, err = s.auditedWrite(ctx, r, auditCreate, "farm_members", 0, func(tx pgx.Tx) (int64, error) {
+		err := tx.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, in.Email).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusNotFound, "no account uses that email; create the user instead"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO farm_members(farm_id, user_id, role_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (farm_id, user_id) DO NOTHING
+			RETURNING id
+		`, farmID, userID, roleID).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, &httpError{http.StatusConflict, "that person is already a member of this farm"}
+		}
+		return id, err
+	})
+	if err != nil {
+		respondHTTPError(w, err, "failed to add member")
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"ok": true, "userId": userID})
+}
+
 func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := parsePathID(r, "id")
 	if err != nil {
@@ -2260,7 +2757,20 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	res, err := s.db.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	farmID := farmIDFrom(ctx)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := auditSnapshot(ctx, tx, "users", userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
+	res, err := tx.Exec(ctx, `DELETE FROM farm_members WHERE farm_id = $1 AND user_id = $2`, farmID, userID)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
 		return
@@ -2269,6 +2779,22 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
 		return
 	}
+	// Users who still belong to another farm keep their account there.
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM users u
+		WHERE u.id = $1 AND NOT EXISTS (SELECT 1 FROM farm_members fm WHERE fm.user_id = u.id)
+	`, userID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
+	if err := s.recordAudit(ctx, tx, r, auditDelete, "users", userID, before); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete user"})
+		return
+	}
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
@@ -2300,13 +2826,17 @@ func (s *Server) handleGenerateReport(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
-	var id int64
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO reports(title, description, category, last_generated)
-		VALUES ($1, $2, $3, CURRENT_DATE)
-		RETURNING id
-	`, in.Title, description, in.ReportType).Scan(&id)
+	id, err := s.auditedWrite(ctx, r, auditCreate, "reports", 0, func(tx pgx.Tx) (int64, error) {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO reports(title, description, category, last_generated, farm_id)
+			VALUES ($1, $2, $3, CURRENT_DATE, $4)
+			RETURNING id
+		`, in.Title, description, in.ReportType, farmID).Scan(&id)
+		return id, err
+	})
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate report"})
 		return
@@ -2334,14 +2864,15 @@ func (s *Server) handleDownloadReport(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
+	farmID := farmIDFrom(ctx)
 
 	var title, description, category string
 	var generated time.Time
 	err = s.db.QueryRow(ctx, `
 		SELECT title, description, category, last_generated
 		FROM reports
-		WHERE id = $1
-	`, id).Scan(&title, &description, &category, &generated)
+		WHERE id = $1 AND farm_id = $2
+	`, id, farmID).Scan(&title, &description, &category, &generated)
 	if err != nil {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "report not found"})
 		return
@@ -2353,7 +2884,7 @@ func (s *Server) handleDownloadReport(w http.ResponseWriter, r *http.Request) {
 		requestedFormat = storedFormat
 	}
 
-	report, err := s.buildReportContent(ctx, id, title, category, dateRange, generated, requestedFormat)
+	report, err := s.buildReportContent(ctx, farmID, id, title, category, dateRange, generated, requestedFormat)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to build report"})
 		return
@@ -0,0 +1,642 @@
+// Package backup writes a farm's data to a versioned archive and restores it
+// into another database. The archive is a zip holding manifest.json and one
+// JSON-lines file per table. Rows keep their original ids in the archive;
+// restore inserts them with fresh ids and rewrites every reference to match.
+package backup
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Format is bumped whenever the archive layout changes in a way older
+// restores cannot read.
+const Format = 1
+
+type Manifest struct {
+	Format        int            `json:"format"`
+	SchemaVersion int64          `json:"schemaVersion"`
+	ExportedAt    time.Time      `json:"exportedAt"`
+	Farms         []int64        `json:"farms"`
+	Tables        map[string]int `json:"tables"`
+}
+
+// table describes how one table is exported and restored. where picks the
+// rows of the farms being exported, with the farm ids as $1. refs maps each
+// column holding an id to the table it points at.
+type table struct {
+	name    string
+	where   string
+	refs    map[string]string
+	noID    bool     // keyed by its references alone, e.g. farm_members
+	matchBy string   // restore reuses an existing row with the same value
+	matchIf string   // only rows with this column true are matched
+	omit    []string // never leaves the server
+	seeded  string   // rows a freshly migrated database already holds
+	// fix adjusts a row just before it is inserted.
+	fix func(row map[string]any, ids idMap)
+}
+
+const (
+	byFarm      = `farm_id = ANY($1)`
+	farmMembers = `SELECT user_id FROM farm_members WHERE farm_id = ANY($1)`
+	farmRoles   = `SELECT role_id FROM farm_members WHERE farm_id = ANY($1)
+		UNION SELECT role_id FROM users WHERE id IN (` + farmMembers + `)
+		UNION SELECT id FROM roles WHERE farm_id = ANY($1)`
+	// Migrations create a farm nobody belongs to yet, with default prices
+	// and alert rules, and creating any farm seeds the same.
+	unclaimedFarm = `farm_id NOT IN (SELECT farm_id FROM farm_members)`
+)
+
+// tables lists every exported table in restore order: a table comes after
+// the tables it references, except for the references restore fills in once
+// everything is in (animal parents, credit notes and the like).
+//
+// Left out on purpose: sessions, the eTIMS device and the M-Pesa account,
+// whose keys must not travel and which are set up again on the new server.
+var tables = []table{
+	{name: "farms", where: `id = ANY($1)`, seeded: `id NOT IN (SELECT farm_id FROM farm_members)`},
+	// System roles are shared and already there; a farm's own are not.
+	{name: "roles", where: `id IN (` + farmRoles + `)`, refs: map[string]string{"farm_id": "farms"}, matchBy: "name", matchIf: "is_system", seeded: "is_system"},
+	{
+		name:    "permissions",
+		where:   `id IN (SELECT permission_id FROM role_permissions WHERE role_id IN (` + farmRoles + `))`,
+		matchBy: "key",
+		seeded:  "true",
+	},
+	{
+		name:   "role_permissions",
+		where:  `role_id IN (` + farmRoles + `) AND role_id IN (SELECT id FROM roles WHERE NOT is_system)`,
+		refs:   map[string]string{"role_id": "roles", "permission_id": "permissions"},
+		noID:   true,
+		seeded: `role_id IN (SELECT id FROM roles WHERE is_system)`,
+	},
+	{
+		name:  "users",
+		where: `id IN (` + farmMembers + `)`,
+		refs:  map[string]string{"role_id": "roles"},
+		omit:  []string{"password_hash", "email_verify_token_hash", "email_verify_expires_at", "reset_token_hash", "reset_token_expires_at", "reset_code_hash", "reset_code_expires_at"},
+		// Restored users have no password and sign in after a reset.
+		fix: func(row map[string]any, _ idMap) { row["password_hash"] = "" },
+	},
+	{
+		name:  "farm_members",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "user_id": "users", "role_id": "roles"},
+		noID:  true,
+	},
+	{name: "animal_locations", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{
+		name:  "animals",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "mother_id": "animals", "father_id": "animals",
+			"birth_record_id": "breeding_records", "location_id": "animal_locations",
+		},
+	},
+	{
+		name:  "breeding_records",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "mother_animal_id": "animals", "father_animal_id": "animals"},
+	},
+	{
+		name:  "poultry_breeding_records",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "hen_animal_id": "animals", "rooster_animal_id": "animals"},
+	},
+	{
+		name:  "disease_cases",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "animal_id": "animals", "created_by": "users"},
+	},
+	{
+		name:  "health_records",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "animal_id": "animals", "medicine_id": "medicines", "batch_id": "medicine_batches",
+			"case_id": "disease_cases",
+		},
+	},
+	{name: "quarantine_groups", where: byFarm, refs: map[string]string{"farm_id": "farms", "location_id": "animal_locations"}},
+	{
+		name:  "quarantine_animals",
+		where: `group_id IN (SELECT id FROM quarantine_groups WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"group_id": "quarantine_groups", "animal_id": "animals", "case_id": "disease_cases"},
+	},
+	{name: "health_protocols", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{
+		name:  "health_protocol_steps",
+		where: `protocol_id IN (SELECT id FROM health_protocols WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"protocol_id": "health_protocols"},
+	},
+	{
+		name:  "animal_health_protocols",
+		where: `protocol_id IN (SELECT id FROM health_protocols WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"animal_id": "animals", "protocol_id": "health_protocols"},
+		noID:  true,
+	},
+	{
+		name:  "health_tasks",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "animal_id": "animals", "protocol_id": "health_protocols", "step_id": "health_protocol_steps",
+			"source_record_id": "health_records", "health_record_id": "health_records", "completed_by": "users",
+		},
+	},
+	{name: "production_records", where: byFarm, refs: map[string]string{"farm_id": "farms", "animal_id": "animals"}},
+	{name: "commodity_prices", where: byFarm, refs: map[string]string{"farm_id": "farms"}, seeded: unclaimedFarm},
+	{name: "customers", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{name: "invoice_sequences", where: byFarm, refs: map[string]string{"farm_id": "farms"}, noID: true},
+	{
+		name:  "invoices",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "original_invoice_id": "invoices", "customer_id": "customers"},
+	},
+	{
+		name:  "invoice_lines",
+		where: `invoice_id IN (SELECT id FROM invoices WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"invoice_id": "invoices", "original_line_id": "invoice_lines"},
+	},
+	{
+		name:  "payments",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "customer_id": "customers", "invoice_id": "invoices"},
+	},
+	{name: "etims_submissions", where: byFarm, refs: map[string]string{"farm_id": "farms", "invoice_id": "invoices"}},
+	{
+		name:  "mpesa_transactions",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "invoice_id": "invoices", "payment_id": "payments", "resolved_by": "users"},
+	},
+	{
+		name:  "mpesa_stk_requests",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "invoice_id": "invoices", "transaction_id": "mpesa_transactions", "requested_by": "users"},
+	},
+	{name: "suppliers", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{name: "medicines", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{
+		name:  "medicine_batches",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "medicine_id": "medicines", "supplier_id": "suppliers"},
+	},
+	{
+		name:  "medicine_stock_movements",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "batch_id": "medicine_batches", "health_record_id": "health_records", "created_by": "users",
+		},
+	},
+	{name: "document_sequences", where: byFarm, refs: map[string]string{"farm_id": "farms"}, noID: true},
+	{name: "feed_items", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{name: "purchase_orders", where: byFarm, refs: map[string]string{"farm_id": "farms", "supplier_id": "suppliers"}},
+	{
+		name:  "purchase_order_lines",
+		where: `purchase_order_id IN (SELECT id FROM purchase_orders WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"purchase_order_id": "purchase_orders", "feed_item_id": "feed_items"},
+	},
+	{
+		name:  "goods_receipts",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "purchase_order_id": "purchase_orders", "received_by": "users"},
+	},
+	{
+		name:  "goods_receipt_lines",
+		where: `goods_receipt_id IN (SELECT id FROM goods_receipts WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"goods_receipt_id": "goods_receipts", "purchase_order_line_id": "purchase_order_lines"},
+	},
+	{
+		name:  "expenses",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "supplier_id": "suppliers", "goods_receipt_line_id": "goods_receipt_lines"},
+	},
+	{name: "feeding_rations", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{
+		name:  "feeding_ration_items",
+		where: `ration_id IN (SELECT id FROM feeding_rations WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"ration_id": "feeding_rations", "feed_item_id": "feed_items"},
+	},
+	{
+		name:  "feeding_plans",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "animal_id": "animals", "ration_id": "feeding_rations"},
+	},
+	{
+		name:  "feeding_records",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "animal_id": "animals", "ration_id": "feeding_rations",
+			"plan_id": "feeding_plans", "supplier_id": "suppliers",
+		},
+	},
+	{
+		name:  "feed_stock_movements",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "feed_item_id": "feed_items", "goods_receipt_line_id": "goods_receipt_lines",
+			"feeding_record_id": "feeding_records", "created_by": "users",
+		},
+	},
+	{
+		name:  "animal_events",
+		where: byFarm,
+		refs: map[string]string{
+			"farm_id": "farms", "animal_id": "animals", "from_location_id": "animal_locations",
+			"to_location_id": "animal_locations", "invoice_id": "invoices", "expense_id": "expenses",
+			"breeding_record_id": "breeding_records", "created_by": "users",
+		},
+	},
+	{name: "reports", where: byFarm, refs: map[string]string{"farm_id": "farms"}},
+	{name: "report_schedules", where: byFarm, refs: map[string]string{"farm_id": "farms", "created_by": "users"}},
+	{
+		name:  "report_runs",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "schedule_id": "report_schedules", "report_id": "reports"},
+	},
+	{name: "alert_rules", where: byFarm, refs: map[string]string{"farm_id": "farms"}, seeded: unclaimedFarm},
+	{
+		name:  "alert_firings",
+		where: `rule_id IN (SELECT id FROM alert_rules WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"rule_id": "alert_rules"},
+		noID:  true,
+		// Keys start with the table and id that matched, e.g.
+		// "health_tasks:12:2024-05-01", so they follow the row.
+		fix: func(row map[string]any, ids idMap) {
+			key, _ := row["key"].(string)
+			parts := strings.SplitN(key, ":", 3)
+			if len(parts) < 2 {
+				return
+			}
+			old, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return
+			}
+			parts[1] = strconv.FormatInt(ids[parts[0]][old], 10)
+			row["key"] = strings.Join(parts, ":")
+		},
+	},
+	{
+		name:  "notifications",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "user_id": "users", "rule_id": "alert_rules"},
+		fix: func(row map[string]any, ids idMap) {
+			entity, _ := row["entity"].(string)
+			if old, ok := asID(row["entity_id"]); ok {
+				row["entity_id"] = ids[entity][old]
+			}
+		},
+	},
+	{
+		name:  "notification_deliveries",
+		where: `notification_id IN (SELECT id FROM notifications WHERE farm_id = ANY($1))`,
+		refs:  map[string]string{"notification_id": "notifications"},
+	},
+	{
+		name:  "notification_preferences",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "user_id": "users"},
+		noID:  true,
+	},
+	{
+		name:  "sms_messages",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "user_id": "users", "notification_id": "notifications"},
+	},
+	// Opt-outs are kept by number across farms; the members' numbers travel
+	// so the new server does not text anyone who asked it not to.
+	{name: "sms_opt_outs", where: `phone IN (SELECT phone FROM users WHERE id IN (` + farmMembers + `))`, noID: true},
+	{
+		name:  "audit_events",
+		where: byFarm,
+		refs:  map[string]string{"farm_id": "farms", "user_id": "users"},
+		// entity_id points into whichever table entity names. Rows deleted
+		// before the export have no new id and are kept against id 0.
+		fix: func(row map[string]any, ids idMap) {
+			entity, _ := row["entity"].(string)
+			old, _ := asID(row["entity_id"])
+			row["entity_id"] = ids[entity][old]
+		},
+	},
+}
+
+// idMap maps a table's archived ids to the ids the rows got on restore.
+type idMap map[string]map[int64]int64
+
+var identRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+func asID(v any) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(n.String(), 10, 64)
+	return id, err == nil
+}
+
+// SchemaVersion is the newest migration applied to db. An archive only
+// restores into a database at the same version, so its columns line up.
+func SchemaVersion(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	var v int64
+	err := db.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	return v, err
+}
+
+// Export writes every table of the given farms to w. With no farm ids it
+// exports every farm. The rows are read from one snapshot.
+func Export(ctx context.Context, db *pgxpool.Pool, w io.Writer, farmIDs []int64) (Manifest, error) {
+	m := Manifest{Format: Format, ExportedAt: time.Now().UTC(), Tables: map[string]int{}}
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return m, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&m.SchemaVersion); err != nil {
+		return m, fmt.Errorf("read schema version: %w", err)
+	}
+	if len(farmIDs) == 0 {
+		rows, err := tx.Query(ctx, `SELECT id FROM farms ORDER BY id`)
+		if err != nil {
+			return m, err
+		}
+		if farmIDs, err = pgx.CollectRows(rows, pgx.RowTo[int64]); err != nil {
+			return m, err
+		}
+	}
+	m.Farms = farmIDs
+
+	zw := zip.NewWriter(w)
+	for _, t := range tables {
+		order := "t.id"
+		if t.noID {
+			order = "1"
+		}
+		omit := t.omit
+		if omit == nil {
+			omit = []string{}
+		}
+		rows, err := tx.Query(ctx, `SELECT (to_jsonb(t) - $2::text[])::text FROM `+t.name+` t WHERE `+t.where+` ORDER BY `+order, farmIDs, omit)
+		if err != nil {
+			return m, fmt.Errorf("export %s: %w", t.name, err)
+		}
+		f, err := zw.Create("data/" + t.name + ".jsonl")
+		if err != nil {
+			rows.Close()
+			return m, err
+		}
+		n := 0
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				rows.Close()
+				return m, fmt.Errorf("export %s: %w", t.name, err)
+			}
+			if _, err := io.WriteString(f, line+"\n"); err != nil {
+				rows.Close()
+				return m, err
+			}
+			n++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return m, fmt.Errorf("export %s: %w", t.name, err)
+		}
+		m.Tables[t.name] = n
+	}
+
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return m, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return m, err
+	}
+	return m, zw.Close()
+}
+
+// deferredRef is a reference to a row restored after the one holding it,
+// filled in once every table is in.
+type deferredRef struct {
+	table, column, target string
+	id, old               int64
+}
+
+// Restore loads an archive written by Export into db, which must be at the
+// archive's schema version and hold nothing but what migrations seed.
+// Everything is restored in one transaction.
+func Restore(ctx context.Context, db *pgxpool.Pool, r io.ReaderAt, size int64) (Manifest, error) {
+	var m Manifest
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return m, errors.New("archive is not a zip file")
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	mf, ok := files["manifest.json"]
+	if !ok {
+		return m, errors.New("archive has no manifest.json")
+	}
+	rc, err := mf.Open()
+	if err != nil {
+		return m, err
+	}
+	err = json.NewDecoder(rc).Decode(&m)
+	rc.Close()
+	if err != nil {
+		return m, fmt.Errorf("read manifest: %w", err)
+	}
+	if m.Format != Format {
+		return m, fmt.Errorf("archive format %d is not supported (expected %d)", m.Format, Format)
+	}
+
+	version, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return m, fmt.Errorf("read schema version: %w", err)
+	}
+	if version != m.SchemaVersion {
+		return m, fmt.Errorf("archive is at schema version %d but the database is at %d; migrate to the same version first", m.SchemaVersion, version)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return m, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkEmpty(ctx, tx); err != nil {
+		return m, err
+	}
+
+	ids := idMap{}
+	restored := map[string]bool{}
+	var pending []deferredRef
+	for _, t := range tables {
+		ids[t.name] = map[int64]int64{}
+		f, ok := files["data/"+t.name+".jsonl"]
+		if !ok {
+			restored[t.name] = true
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return m, err
+		}
+		later, err := restoreTable(ctx, tx, t, rc, ids, restored)
+		rc.Close()
+		if err != nil {
+			return m, fmt.Errorf("restore %s: %w", t.name, err)
+		}
+		pending = append(pending, later...)
+		restored[t.name] = true
+	}
+
+	for _, p := range pending {
+		id, ok := ids[p.target][p.old]
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec(ctx, `UPDATE `+p.table+` SET `+p.column+` = $1 WHERE id = $2`, id, p.id); err != nil {
+			return m, fmt.Errorf("restore %s.%s: %w", p.table, p.column, err)
+		}
+	}
+	return m, tx.Commit(ctx)
+}
+
+// checkEmpty makes sure no restored table holds more than migrations seed,
+// so an archive is never mixed into live data.
+func checkEmpty(ctx context.Context, tx pgx.Tx) error {
+	for _, t := range tables {
+		var found bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM `+t.name+` WHERE `+unseeded(t)+`)`).Scan(&found); err != nil {
+			return fmt.Errorf("check %s: %w", t.name, err)
+		}
+		if found {
+			return fmt.Errorf("restore needs an empty database, and this one already has %s", strings.ReplaceAll(t.name, "_", " "))
+		}
+	}
+	return nil
+}
+
+// unseeded is the condition picking a table's rows that migrations did not
+// put there.
+func unseeded(t table) string {
+	if t.seeded == "" {
+		return "true"
+	}
+	return "NOT (" + t.seeded + ")"
+}
+
+// remapRefs points row's references at the restored ids. A reference into a
+// table not restored yet (or this one, for a row further on) is left empty
+// and returned to be set at the end. One into a table already restored that
+// has no match pointed at a row outside the export, such as a user who has
+// left the farm, and stays empty.
+func remapRefs(t table, row map[string]any, ids idMap, restored map[string]bool) []deferredRef {
+	var later []deferredRef
+	for col, target := range t.refs {
+		ref, ok := asID(row[col])
+		if !ok {
+			continue
+		}
+		if id, ok := ids[target][ref]; ok {
+			row[col] = id
+			continue
+		}
+		row[col] = nil
+		if !restored[target] {
+			later = append(later, deferredRef{table: t.name, column: col, target: target, old: ref})
+		}
+	}
+	return later
+}
+
+func restoreTable(ctx context.Context, tx pgx.Tx, t table, src io.Reader, ids idMap, restored map[string]bool) ([]deferredRef, error) {
+	var pending []deferredRef
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(sc.Text()))
+		dec.UseNumber()
+		var row map[string]any
+		if err := dec.Decode(&row); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		old, hasOld := asID(row["id"])
+		if !t.noID && !hasOld {
+			return nil, fmt.Errorf("line %d has no id", line)
+		}
+
+		if t.matchBy != "" && (t.matchIf == "" || row[t.matchIf] == true) {
+			var id int64
+			err := tx.QueryRow(ctx, `SELECT id FROM `+t.name+` WHERE `+t.matchBy+` = $1`, row[t.matchBy]).Scan(&id)
+			if err == nil {
+				ids[t.name][old] = id
+				continue
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return nil, err
+			}
+		}
+
+		later := remapRefs(t, row, ids, restored)
+		if t.fix != nil {
+			t.fix(row, ids)
+		}
+
+		cols := make([]string, 0, len(row))
+		for col := range row {
+			if col == "id" {
+				continue
+			}
+			if !identRe.MatchString(col) {
+				return nil, fmt.Errorf("line %d has an invalid column %q", line, col)
+			}
+			cols = append(cols, `"`+col+`"`)
+		}
+		sort.Strings(cols)
+		list := strings.Join(cols, ", ")
+		data, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		insert := `INSERT INTO ` + t.name + ` (` + list + `) SELECT ` + list + ` FROM json_populate_record(NULL::` + t.name + `, $1::json)`
+		if t.noID {
+			if _, err := tx.Exec(ctx, insert+` ON CONFLICT DO NOTHING`, string(data)); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			continue
+		}
+		var id int64
+		if err := tx.QueryRow(ctx, insert+` RETURNING id`, string(data)).Scan(&id); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ids[t.name][old] = id
+		for _, d := range later {
+			d.id = id
+			pending = append(pending, d)
+		}
+	}
+	return pending, sc.Err()
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRemapRefs(t *testing.T) {
+	animals := table{name: "animals", refs: map[string]string{
+		"farm_id": "farms", "mother_id": "animals", "location_id": "animal_locations", "created_by": "users",
+	}}
+	ids := idMap{
+		"farms":   {7: 1},
+		"users":   {40: 3},
+		"animals": {100: 11},
+	}
+	restored := map[string]bool{"farms": true, "users": true}
+
+	tests := []struct {
+		name  string
+		row   map[string]any
+		want  map[string]any
+		later []deferredRef
+	}{
+		{
+			name: "known references are rewritten",
+			row:  map[string]any{"id": json.Number("101"), "farm_id": json.Number("7"), "mother_id": json.Number("100"), "created_by": json.Number("40")},
+			want: map[string]any{"id": json.Number("101"), "farm_id": int64(1), "mother_id": int64(11), "created_by": int64(3)},
+		},
+		{
+			name: "a parent further on is deferred",
+			row:  map[string]any{"farm_id": json.Number("7"), "mother_id": json.Number("250")},
+			want: map[string]any{"farm_id": int64(1), "mother_id": nil},
+			later: []deferredRef{
+				{table: "animals", column: "mother_id", target: "animals", old: 250},
+			},
+		},
+		{
+			name: "a table not restored yet is deferred",
+			row:  map[string]any{"farm_id": json.Number("7"), "location_id": json.Number("5")},
+			want: map[string]any{"farm_id": int64(1), "location_id": nil},
+			later: []deferredRef{
+				{table: "animals", column: "location_id", target: "animal_locations", old: 5},
+			},
+		},
+		{
+			name: "a row outside the export stays empty",
+			row:  map[string]any{"farm_id": json.Number("7"), "created_by": json.Number("41")},
+			want: map[string]any{"farm_id": int64(1), "created_by": nil},
+		},
+		{
+			name: "empty references are left alone",
+			row:  map[string]any{"farm_id": json.Number("7"), "mother_id": nil, "notes": "x"},
+			want: map[string]any{"farm_id": int64(1), "mother_id": nil, "notes": "x"},
+		},
+	}
+	for _, tt := range tests {
+		later := remapRefs(animals, tt.row, ids, restored)
+		if !reflect.DeepEqual(tt.row, tt.want) {
+			t.Errorf("%s: row = %v, want %v", tt.name, tt.row, tt.want)
+		}
+		if !reflect.DeepEqual(later, tt.later) {
+			t.Errorf("%s: deferred = %+v, want %+v", tt.name, later, tt.later)
+		}
+	}
+}
+
+func TestAsID(t *testing.T) {
+	tests := []struct {
+		v    any
+		want int64
+		ok   bool
+	}{
+		{json.Number("42"), 42, true},
+		{json.Number("4.2"), 0, false},
+		{"42", 0, false},
+		{nil, 0, false},
+		{float64(42), 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := asID(tt.v)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("asID(%#v) = %d, %v, want %d, %v", tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// Restore walks tables in order and fills deferred references by id, so
+// every reference must name an exported table, and a table without ids can
+// only point back at tables restored before it.
+func TestTablesOrder(t *testing.T) {
+	pos := map[string]int{}
+	for i, tb := range tables {
+		if _, dup := pos[tb.name]; dup {
+			t.Errorf("table %s is listed twice", tb.name)
+		}
+		pos[tb.name] = i
+		if !identRe.MatchString(tb.name) {
+			t.Errorf("table name %q is not a plain identifier", tb.name)
+		}
+	}
+	for i, tb := range tables {
+		cols := make([]string, 0, len(tb.refs))
+		for col := range tb.refs {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		for _, col := range cols {
+			target := tb.refs[col]
+			p, ok := pos[target]
+			if !ok {
+				t.Errorf("%s.%s points at %s, which is not exported", tb.name, col, target)
+				continue
+			}
+			if tb.noID && p >= i {
+				t.Errorf("%s has no id, so %s.%s cannot wait for %s", tb.name, tb.name, col, target)
+			}
+		}
+		if tb.matchIf != "" && tb.matchBy == "" {
+			t.Errorf("%s sets matchIf without matchBy", tb.name)
+		}
+	}
+}
+
+func TestUnseeded(t *testing.T) {
+	if got := unseeded(table{name: "animals"}); got != "true" {
+		t.Errorf("unseeded without seed rows = %q", got)
+	}
+	if got := unseeded(table{name: "roles", seeded: "is_system"}); got != "NOT (is_system)" {
+		t.Errorf("unseeded for roles = %q", got)
+	}
+}
@@ -10,47 +10,104 @@ type Config struct {
 	Port               string
 	DatabaseURL        string
 	JWTSecret          string
-	SchemaPath         string
+	MigrationsPath     string
+	AutoMigrate        bool
 	CORSAllowedOrigins []string
 	FrontendBaseURL    string
 	AppTimezone        string
 	KRAPIN             string
 	MLBaseURL          string
+	EtimsBaseURL       string
+	EtimsBranchID      string
+	EtimsDeviceSerial  string
+	EtimsFake          bool
+	MpesaBaseURL       string
+	MpesaAppKey        string
+	MpesaAppSecret     string
+	MpesaCallbackURL   string
+	MpesaCallbackToken string
 	SMTPHost           string
 	SMTPPort           string
 	SMTPUsername       string
 	SMTPPassword       string
+	SMTPTLS            string
 	FromEmail          string
 	FromName           string
+	SMSBaseURL         string
+	SMSUsername        string
+	SMSAPIKey          string
+	SMSSenderID        string
+	SMSCallbackToken   string
+	SMSFake            bool
 }
 
 func Load() (Config, error) {
-	cfg := Config{
+	cfg := fromEnv()
+
+	if cfg.DatabaseURL == "" {
+		return Config{}, fmt.Errorf("missing required environment variable: DATABASE_URL")
+	}
+	if cfg.JWTSecret == "" {
+		return Config{}, fmt.Errorf("missing required environment variable: JWT_SECRET")
+	}
+	if cfg.MpesaBaseURL != "" && (cfg.MpesaCallbackURL == "" || cfg.MpesaCallbackToken == "") {
+		return Config{}, fmt.Errorf("MPESA_CALLBACK_BASE_URL and MPESA_CALLBACK_TOKEN are required when MPESA_BASE_URL is set")
+	}
+	if cfg.SMTPTLS != "" && cfg.SMTPTLS != "starttls" && cfg.SMTPTLS != "tls" {
+		return Config{}, fmt.Errorf("SMTP_TLS must be starttls or tls")
+	}
+	if cfg.SMSBaseURL != "" && (cfg.SMSUsername == "" || cfg.SMSAPIKey == "") {
+		return Config{}, fmt.Errorf("SMS_USERNAME and SMS_API_KEY are required when SMS_BASE_URL is set")
+	}
+
+	return cfg, nil
+}
+
+func LoadForMigrations() (Config, error) {
+	cfg := fromEnv()
+
+	if cfg.DatabaseURL == "" {
+		return Config{}, fmt.Errorf("missing required environment variable: DATABASE_URL")
+	}
+
+	return cfg, nil
+}
+
+func fromEnv() Config {
+	return Config{
 		Port:               getEnvOrDefault("PORT", "8080"),
 		DatabaseURL:        os.Getenv("DATABASE_URL"),
 		JWTSecret:          os.Getenv("JWT_SECRET"),
-		SchemaPath:         getEnvOrDefault("DB_SCHEMA_PATH", "db/schema.sql"),
+		MigrationsPath:     getEnvOrDefault("DB_MIGRATIONS_PATH", "db/migrations"),
+		AutoMigrate:        parseBoolEnv(getEnvOrDefault("DB_AUTO_MIGRATE", "true")),
 		CORSAllowedOrigins: splitCSVEnv(getEnvOrDefault("CORS_ALLOWED_ORIGINS", "http://localhost:5173,http://127.0.0.1:5173")),
 		FrontendBaseURL:    getEnvOrDefault("FRONTEND_BASE_URL", "http://localhost:5173"),
 		AppTimezone:        getEnvOrDefault("APP_TIMEZONE", "Africa/Nairobi"),
 		KRAPIN:             strings.TrimSpace(os.Getenv("KRA_PIN")),
 		MLBaseURL:          strings.TrimSpace(os.Getenv("ML_BASE_URL")),
+		EtimsBaseURL:       strings.TrimSpace(os.Getenv("ETIMS_BASE_URL")),
+		EtimsBranchID:      getEnvOrDefault("ETIMS_BRANCH_ID", "00"),
+		EtimsDeviceSerial:  strings.TrimSpace(os.Getenv("ETIMS_DEVICE_SERIAL")),
+		EtimsFake:          parseBoolEnv(os.Getenv("ETIMS_FAKE")),
+		MpesaBaseURL:       strings.TrimSpace(os.Getenv("MPESA_BASE_URL")),
+		MpesaAppKey:        strings.TrimSpace(os.Getenv("MPESA_CONSUMER_KEY")),
+		MpesaAppSecret:     strings.TrimSpace(os.Getenv("MPESA_CONSUMER_SECRET")),
+		MpesaCallbackURL:   strings.TrimRight(strings.TrimSpace(os.Getenv("MPESA_CALLBACK_BASE_URL")), "/"),
+		MpesaCallbackToken: strings.TrimSpace(os.Getenv("MPESA_CALLBACK_TOKEN")),
 		SMTPHost:           strings.TrimSpace(os.Getenv("SMTP_HOST")),
 		SMTPPort:           getEnvOrDefault("SMTP_PORT", "587"),
 		SMTPUsername:       strings.TrimSpace(os.Getenv("SMTP_USERNAME")),
 		SMTPPassword:       normalizeSMTPPassword(firstNonEmpty(os.Getenv("SMTP_PASSWORD"), os.Getenv("smtp_password"))),
+		SMTPTLS:            strings.ToLower(strings.TrimSpace(os.Getenv("SMTP_TLS"))),
 		FromEmail:          getEnvOrDefault("FROM_EMAIL", "noreply@farmpro.com"),
 		FromName:           getEnvOrDefault("FROM_NAME", "FarmPro"),
+		SMSBaseURL:         strings.TrimSpace(os.Getenv("SMS_BASE_URL")),
+		SMSUsername:        strings.TrimSpace(os.Getenv("SMS_USERNAME")),
+		SMSAPIKey:          strings.TrimSpace(os.Getenv("SMS_API_KEY")),
+		SMSSenderID:        strings.TrimSpace(os.Getenv("SMS_SENDER_ID")),
+		SMSCallbackToken:   strings.TrimSpace(os.Getenv("SMS_CALLBACK_TOKEN")),
+		SMSFake:            parseBoolEnv(os.Getenv("SMS_FAKE")),
 	}
-
-	if cfg.DatabaseURL == "" {
-		return Config{}, fmt.Errorf("missing required environment variable: DATABASE_URL")
-	}
-	if cfg.JWTSecret == "" {
-		return Config{}, fmt.Errorf("missing required environment variable: JWT_SECRET")
-	}
-
-	return cfg, nil
 }
 
 func getEnvOrDefault(key, fallback string) string {
@@ -87,3 +144,12 @@ func firstNonEmpty(values ...string) string {
 func normalizeSMTPPassword(v string) string {
 	return strings.ReplaceAll(strings.TrimSpace(v), " ", "")
 }
+
+func parseBoolEnv(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
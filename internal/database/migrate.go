@@ -5,31 +5,300 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func EnsureSchema(ctx context.Context, pool *pgxpool.Pool, schemaPath string) error {
-	if strings.TrimSpace(schemaPath) == "" {
-		schemaPath = "db/schema.sql"
+// migrationLockKey is the pg_advisory_lock key held while migrations run so
+// that replicas booting at the same time apply each file exactly once.
+const migrationLockKey int64 = 7254917301
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	UpSQL   string
+	DownSQL string
+}
+
+type MigrationState struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+func LoadMigrations(dir string) ([]Migration, error) {
+	if strings.TrimSpace(dir) == "" {
+		dir = "db/migrations"
 	}
 
-	data, err := os.ReadFile(filepath.Clean(schemaPath))
+	entries, err := os.ReadDir(filepath.Clean(dir))
 	if err != nil {
-		return fmt.Errorf("read schema file failed (%s): %w", schemaPath, err)
+		return nil, fmt.Errorf("read migrations dir failed (%s): %w", dir, err)
 	}
 
-	statements := strings.Split(string(data), ";")
-	for _, stmt := range statements {
-		query := strings.TrimSpace(stmt)
-		if query == "" {
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
 			continue
 		}
-		if _, err := pool.Exec(ctx, query); err != nil {
-			return fmt.Errorf("schema statement failed: %w", err)
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration failed (%s): %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has mismatched names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.UpSQL = string(data)
+		} else {
+			m.DownSQL = string(data)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.UpSQL) == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing its up file", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func MigrateUp(ctx context.Context, pool *pgxpool.Pool, dir string) ([]Migration, error) {
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]Migration, 0)
+	err = withMigrationLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range pendingMigrations(migrations, done) {
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, dir string, steps int) ([]Migration, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := make([]Migration, 0)
+	err = withMigrationLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		revert, err := migrationsToRevert(migrations, done, steps)
+		if err != nil {
+			return err
+		}
+		for _, m := range revert {
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// pendingMigrations returns the migrations not yet in done, oldest first.
+func pendingMigrations(migrations []Migration, done map[int64]struct{}) []Migration {
+	out := make([]Migration, 0)
+	for _, m := range migrations {
+		if _, ok := done[m.Version]; !ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// migrationsToRevert returns the last steps applied migrations, newest first.
+// It refuses before anything is reverted when one of them has no down file.
+func migrationsToRevert(migrations []Migration, done map[int64]struct{}, steps int) ([]Migration, error) {
+	out := make([]Migration, 0, steps)
+	for i := len(migrations) - 1; i >= 0 && len(out) < steps; i-- {
+		m := migrations[i]
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+		if strings.TrimSpace(m.DownSQL) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func MigrationStatus(ctx context.Context, pool *pgxpool.Pool, dir string) ([]MigrationState, error) {
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(ctx, pool); err != nil {
+		return nil, err
+	}
+
+	rows, err := pool.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("load migration history failed: %w", err)
+	}
+	defer rows.Close()
+	appliedAt := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if at, ok := appliedAt[m.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = &at
 		}
+		out = append(out, state)
 	}
+	return out, nil
+}
 
+type migrationExecer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func ensureMigrationsTable(ctx context.Context, db migrationExecer) error {
+	_, err := db.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations failed: %w", err)
+	}
+	return nil
+}
+
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire migration connection failed: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer func() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = conn.Exec(unlockCtx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]struct{}, error) {
+	rows, err := conn.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("load migration history failed: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[int64]struct{})
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		out[version] = struct{}{}
+	}
+	return out, rows.Err()
+}
+
+// runMigration executes a whole migration file in one transaction. The file is
+// sent without arguments so pgx uses the simple protocol, which accepts
+// multiple statements, DO blocks and function bodies as-is.
+func runMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("begin migration %d_%s failed: %w", m.Version, m.Name, err)
+	}
+	defer tx.Rollback(ctx)
+
+	direction := "up"
+	script := m.UpSQL
+	if !up {
+		direction = "down"
+		script = m.DownSQL
+	}
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s (%s) failed: %w", m.Version, m.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec(ctx, `INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		_, err = tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("record migration %d_%s failed: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit migration %d_%s failed: %w", m.Version, m.Name, err)
+	}
 	return nil
 }
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeMigrations(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadMigrations(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0002_farms.up.sql":   "CREATE TABLE farms();",
+		"0002_farms.down.sql": "DROP TABLE farms;",
+		"0001_init.up.sql":    "CREATE TABLE users();",
+		"0010_notes.up.sql":   "CREATE TABLE notes();",
+		"README.md":           "not a migration",
+	})
+	got, err := LoadMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		version int64
+		name    string
+		down    bool
+	}{
+		{1, "init", false},
+		{2, "farms", true},
+		{10, "notes", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("loaded %d migrations, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Version != w.version || got[i].Name != w.name || (got[i].DownSQL != "") != w.down {
+			t.Errorf("migration %d = %d_%s (down %v), want %d_%s (down %v)", i, got[i].Version, got[i].Name, got[i].DownSQL != "", w.version, w.name, w.down)
+		}
+	}
+}
+
+func TestLoadMigrationsRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"down without up", map[string]string{"0001_init.down.sql": "DROP TABLE users;"}, "missing its up file"},
+		{"names differ", map[string]string{"0001_init.up.sql": "SELECT 1;", "0001_start.down.sql": "SELECT 1;"}, "mismatched names"},
+		{"version zero", map[string]string{"0000_init.up.sql": "SELECT 1;"}, "invalid migration version"},
+	}
+	for _, tt := range tests {
+		_, err := LoadMigrations(writeMigrations(t, tt.files))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+	tests := []struct {
+		done map[int64]struct{}
+		want []int64
+	}{
+		{map[int64]struct{}{}, []int64{1, 2, 3, 4}},
+		{map[int64]struct{}{1: {}, 2: {}}, []int64{3, 4}},
+		// A migration merged behind one already applied still runs.
+		{map[int64]struct{}{1: {}, 3: {}}, []int64{2, 4}},
+		{map[int64]struct{}{1: {}, 2: {}, 3: {}, 4: {}}, nil},
+	}
+	for _, tt := range tests {
+		got := pendingMigrations(migrations, tt.done)
+		if versions(got) != versions(migrationsOf(tt.want)) {
+			t.Errorf("done %v: pending %s, want %v", tt.done, versions(got), tt.want)
+		}
+	}
+}
+
+func TestMigrationsToRevert(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, DownSQL: "DROP TABLE a;"},
+		{Version: 2, DownSQL: "DROP TABLE b;"},
+		{Version: 3},
+		{Version: 4, DownSQL: "DROP TABLE d;"},
+	}
+	applied := map[int64]struct{}{1: {}, 2: {}, 4: {}}
+	tests := []struct {
+		steps int
+		want  []int64
+	}{
+		{1, []int64{4}},
+		{2, []int64{4, 2}},
+		{5, []int64{4, 2, 1}},
+	}
+	for _, tt := range tests {
+		got, err := migrationsToRevert(migrations, applied, tt.steps)
+		if err != nil {
+			t.Errorf("steps %d: %v", tt.steps, err)
+			continue
+		}
+		if versions(got) != versions(migrationsOf(tt.want)) {
+			t.Errorf("steps %d: revert %s, want %v", tt.steps, versions(got), tt.want)
+		}
+	}
+
+	// Nothing is reverted when a migration in range cannot be.
+	applied[3] = struct{}{}
+	if got, err := migrationsToRevert(migrations, applied, 2); err == nil {
+		t.Errorf("reverting past a migration without a down file gave %s", versions(got))
+	}
+}
+
+// Every migration in the repository loads and can be reverted by a down file
+// that does something.
+func TestRepositoryMigrations(t *testing.T) {
+	migrations, err := LoadMigrations("../../db/migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations found")
+	}
+	for _, m := range migrations {
+		statements := false
+		for _, line := range strings.Split(m.DownSQL, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "--") {
+				statements = true
+				break
+			}
+		}
+		if !statements {
+			t.Errorf("migration %d_%s has no down statements", m.Version, m.Name)
+		}
+	}
+}
+
+func migrationsOf(list []int64) []Migration {
+	out := make([]Migration, len(list))
+	for i, v := range list {
+		out[i] = Migration{Version: v}
+	}
+	return out
+}
+
+func versions(ms []Migration) string {
+	parts := make([]string, len(ms))
+	for i, m := range ms {
+		parts[i] = strconv.FormatInt(m.Version, 10)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,140 @@
+// Package etims talks to KRA's electronic Tax Invoice Management System.
+// Client has an HTTP implementation for a VSCU/OSCU endpoint and an
+// in-process Fake for tests and development.
+package etims
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Client initialises a sales control unit device and signs invoices with it.
+type Client interface {
+	Initialize(ctx context.Context, req DeviceRequest) (Device, error)
+	SubmitInvoice(ctx context.Context, device Device, inv Invoice) (Receipt, error)
+}
+
+type DeviceRequest struct {
+	TIN          string
+	BranchID     string
+	DeviceSerial string
+}
+
+// Device is what KRA hands back on initialisation. CMCKey authenticates every
+// later call and must be kept server side.
+type Device struct {
+	TIN          string `json:"tin"`
+	BranchID     string `json:"branchId"`
+	DeviceSerial string `json:"deviceSerial"`
+	DeviceID     string `json:"deviceId"`
+	SDCID        string `json:"sdcId"`
+	MRCNumber    string `json:"mrcNumber"`
+	CMCKey       string `json:"-"`
+}
+
+// Receipt types KRA distinguishes on submission.
+const (
+	ReceiptSale       = "S"
+	ReceiptCreditNote = "R"
+)
+
+// Invoice is a sale or, when ReceiptType is ReceiptCreditNote, a credit note
+// against the invoice numbered OriginalInvoiceNumber. Sequence and
+// OriginalSequence are the numbers KRA tracks and are filled in at submission.
+type Invoice struct {
+	Sequence              int64         `json:"-"`
+	OriginalSequence      int64         `json:"-"`
+	ReceiptType           string        `json:"receiptType"`
+	InvoiceNumber         string        `json:"invoiceNumber"`
+	OriginalInvoiceNumber string        `json:"originalInvoiceNumber,omitempty"`
+	Reason                string        `json:"reason,omitempty"`
+	InvoiceDate           string        `json:"invoiceDate"`
+	Currency              string        `json:"currency"`
+	SupplierPIN           string        `json:"supplierPin"`
+	BuyerName             string        `json:"buyerName"`
+	BuyerPIN              string        `json:"buyerPin"`
+	County                string        `json:"county"`
+	Subcounty             string        `json:"subcounty"`
+	Items                 []InvoiceItem `json:"items"`
+	Summary               Summary       `json:"summary"`
+}
+
+// InvoiceItem is one line. TaxRate is a fraction (0.16) and Total includes
+// VAT. TaxType overrides the code derived from VATApplicable and TaxRate.
+type InvoiceItem struct {
+	Description   string  `json:"description"`
+	Quantity      float64 `json:"quantity"`
+	Unit          string  `json:"unit"`
+	UnitPrice     float64 `json:"unitPrice"`
+	VATApplicable bool    `json:"vatApplicable"`
+	TaxType       string  `json:"taxType,omitempty"`
+	TaxRate       float64 `json:"taxRate"`
+	Total         float64 `json:"total"`
+}
+
+type Summary struct {
+	TaxableAmount float64 `json:"taxableAmount"`
+	VATAmount     float64 `json:"vatAmount"`
+	GrossAmount   float64 `json:"grossAmount"`
+}
+
+// Receipt is KRA's signature over an accepted invoice.
+type Receipt struct {
+	ReceiptNumber      int64     `json:"receiptNumber"`
+	TotalReceiptNumber int64     `json:"totalReceiptNumber"`
+	InternalData       string    `json:"internalData"`
+	Signature          string    `json:"signature"`
+	SDCID              string    `json:"sdcId"`
+	MRCNumber          string    `json:"mrcNumber"`
+	QRData             string    `json:"qrData"`
+	SignedAt           time.Time `json:"signedAt"`
+}
+
+// RejectedError means KRA processed the request and refused it. Sending the
+// same invoice again will not help; anything else returned by a Client is
+// treated as transient and retried.
+type RejectedError struct {
+	Code    string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("etims rejected request (%s): %s", e.Code, e.Message)
+}
+
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// ResultDuplicate is the result code KRA answers with when it already holds
+// an invoice with the same number from this device ("overlapped data").
+const ResultDuplicate = "994"
+
+// IsDuplicate reports whether KRA refused an invoice it already holds.
+func IsDuplicate(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected) && rejected.Code == ResultDuplicate
+}
+
+// TaxType maps a line's VAT treatment onto KRA's tax type codes:
+// A exempt, B 16%, C zero rated, E 8%. rate is a fraction.
+func TaxType(vatApplicable bool, rate float64) string {
+	switch {
+	case !vatApplicable:
+		return "A"
+	case rate == 0:
+		return "C"
+	case rate == 0.08:
+		return "E"
+	default:
+		return "B"
+	}
+}
+
+// qrData is the string KRA's receipt QR code encodes.
+func qrData(tin, branchID, signature string) string {
+	return tin + branchID + signature
+}
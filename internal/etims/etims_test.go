@@ -0,0 +1,174 @@
+package etims
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTaxType(t *testing.T) {
+	tests := []struct {
+		vat  bool
+		rate float64
+		want string
+	}{
+		{false, 0.16, "A"},
+		{false, 0, "A"},
+		{true, 0, "C"},
+		{true, 0.08, "E"},
+		{true, 0.16, "B"},
+	}
+	for _, tt := range tests {
+		if got := TaxType(tt.vat, tt.rate); got != tt.want {
+			t.Errorf("TaxType(%v, %v) = %q, want %q", tt.vat, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	rejected := &RejectedError{Code: "901", Message: "invalid PIN"}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("timeout"), false},
+		{rejected, true},
+		{fmt.Errorf("submit: %w", rejected), true},
+	}
+	for _, tt := range tests {
+		if got := IsRejected(tt.err); got != tt.want {
+			t.Errorf("IsRejected(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFakeFailuresThenAccepts(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+	device, err := f.Initialize(ctx, DeviceRequest{TIN: "P051234567X", BranchID: "00", DeviceSerial: "abc"})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	if device.CMCKey == "" || device.DeviceID != "DVC-ABC" {
+		t.Fatalf("unexpected device %+v", device)
+	}
+
+	f.FailNext(2)
+	inv := Invoice{InvoiceNumber: "INV-0001"}
+	for i := 0; i < 2; i++ {
+		if _, err := f.SubmitInvoice(ctx, device, inv); err == nil || IsRejected(err) {
+			t.Fatalf("attempt %d: got %v, want a transient error", i+1, err)
+		}
+	}
+	first, err := f.SubmitInvoice(ctx, device, inv)
+	if err != nil {
+		t.Fatalf("third attempt: %v", err)
+	}
+	second, err := f.SubmitInvoice(ctx, device, Invoice{InvoiceNumber: "INV-0002"})
+	if err != nil {
+		t.Fatalf("next invoice: %v", err)
+	}
+	if first.ReceiptNumber != 1 || second.ReceiptNumber != 2 {
+		t.Errorf("receipt numbers = %d, %d, want 1, 2", first.ReceiptNumber, second.ReceiptNumber)
+	}
+	if first.Signature == second.Signature {
+		t.Error("different invoices got the same signature")
+	}
+	if first.QRData != device.TIN+device.BranchID+first.Signature {
+		t.Errorf("QRData = %q", first.QRData)
+	}
+	if len(f.Submitted) != 4 {
+		t.Errorf("Submitted has %d invoices, want 4", len(f.Submitted))
+	}
+}
+
+func TestFakeRejectWith(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+	f.RejectWith("910", "request parameter error")
+	_, err := f.SubmitInvoice(ctx, Device{}, Invoice{InvoiceNumber: "INV-0001"})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || rejected.Code != "910" {
+		t.Fatalf("got %v, want a rejection with code 910", err)
+	}
+	f.RejectWith("", "")
+	if _, err := f.SubmitInvoice(ctx, Device{}, Invoice{InvoiceNumber: "INV-0001"}); err != nil {
+		t.Fatalf("after clearing the rejection: %v", err)
+	}
+}
+
+func TestFakeRefusesSignedInvoice(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+	if _, err := f.SubmitInvoice(ctx, Device{}, Invoice{InvoiceNumber: "INV-0001"}); err != nil {
+		t.Fatalf("first submission: %v", err)
+	}
+	_, err := f.SubmitInvoice(ctx, Device{}, Invoice{InvoiceNumber: "INV-0001"})
+	if !IsDuplicate(err) {
+		t.Fatalf("resubmission got %v, want a duplicate rejection", err)
+	}
+	if IsDuplicate(&RejectedError{Code: "910"}) || IsDuplicate(errors.New("timeout")) {
+		t.Error("IsDuplicate matched another error")
+	}
+}
+
+func TestHTTPClientSubmitInvoice(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantErr      bool
+		wantRejected bool
+	}{
+		{"accepted", http.StatusOK, `{"resultCd":"000","data":{"rcptNo":7,"totRcptNo":9,"rcptSign":"SIG","vsdcRcptPbctDate":"20240301120000"}}`, false, false},
+		{"rejected", http.StatusOK, `{"resultCd":"901","resultMsg":"invalid device"}`, true, true},
+		{"server error", http.StatusBadGateway, `upstream down`, true, false},
+		{"unreadable", http.StatusOK, `<html>`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("cmcKey") != "KEY" {
+					t.Errorf("cmcKey header = %q", r.Header.Get("cmcKey"))
+				}
+				_ = json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := NewHTTPClient(srv.URL)
+			device := Device{TIN: "P051234567X", BranchID: "00", CMCKey: "KEY"}
+			inv := Invoice{
+				Sequence:    12,
+				InvoiceDate: "2024-03-01",
+				Items: []InvoiceItem{
+					{Description: "Milk", Quantity: 10, UnitPrice: 58, VATApplicable: true, TaxRate: 0.16, Total: 580},
+					{Description: "Eggs", Quantity: 1, UnitPrice: 400, Total: 400},
+				},
+			}
+			r, err := c.SubmitInvoice(context.Background(), device, inv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if IsRejected(err) != tt.wantRejected {
+				t.Fatalf("IsRejected(%v) = %v, want %v", err, IsRejected(err), tt.wantRejected)
+			}
+			if got["salesDt"] != "20240301" || got["rcptTyCd"] != ReceiptSale {
+				t.Errorf("salesDt = %v, rcptTyCd = %v", got["salesDt"], got["rcptTyCd"])
+			}
+			if got["taxAmtB"] != 80.0 || got["taxblAmtB"] != 500.0 || got["taxblAmtA"] != 400.0 {
+				t.Errorf("tax split B %v/%v, A %v", got["taxblAmtB"], got["taxAmtB"], got["taxblAmtA"])
+			}
+			if err == nil && (r.ReceiptNumber != 7 || r.Signature != "SIG" || r.QRData != "P051234567X00SIG") {
+				t.Errorf("receipt = %+v", r)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package etims
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake signs invoices in process. It stands in for KRA in tests and, when
+// ETIMS_FAKE is set, in development; its signatures mean nothing to KRA.
+type Fake struct {
+	mu        sync.Mutex
+	receipts  int64
+	failures  int
+	rejection *RejectedError
+	signed    map[string]bool
+	// Submitted holds every invoice that reached the fake, accepted or not.
+	Submitted []Invoice
+}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// FailNext makes the next n calls fail with a transient error.
+func (f *Fake) FailNext(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+// RejectWith makes every later submission fail with the given KRA result
+// code; an empty code accepts again.
+func (f *Fake) RejectWith(code, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if code == "" {
+		f.rejection = nil
+		return
+	}
+	f.rejection = &RejectedError{Code: code, Message: message}
+}
+
+func (f *Fake) Initialize(ctx context.Context, req DeviceRequest) (Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.transient(); err != nil {
+		return Device{}, err
+	}
+	serial := strings.ToUpper(req.DeviceSerial)
+	if serial == "" {
+		serial = "LOCAL"
+	}
+	return Device{
+		TIN:          req.TIN,
+		BranchID:     req.BranchID,
+		DeviceSerial: req.DeviceSerial,
+		DeviceID:     "DVC-" + serial,
+		SDCID:        "KRACU-LOCAL-" + serial,
+		MRCNumber:    "MRC-LOCAL-" + serial,
+		CMCKey:       digest("cmc", req.TIN, req.BranchID, serial),
+	}, nil
+}
+
+func (f *Fake) SubmitInvoice(ctx context.Context, device Device, inv Invoice) (Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Submitted = append(f.Submitted, inv)
+	if err := f.transient(); err != nil {
+		return Receipt{}, err
+	}
+	if f.rejection != nil {
+		return Receipt{}, f.rejection
+	}
+	if f.signed[inv.InvoiceNumber] {
+		return Receipt{}, &RejectedError{Code: ResultDuplicate, Message: "overlapped data"}
+	}
+	if f.signed == nil {
+		f.signed = map[string]bool{}
+	}
+	f.signed[inv.InvoiceNumber] = true
+	f.receipts++
+	signature := strings.ToUpper(digest("sign", device.CMCKey, inv.InvoiceNumber)[:16])
+	return Receipt{
+		ReceiptNumber:      f.receipts,
+		TotalReceiptNumber: f.receipts,
+		InternalData:       strings.ToUpper(digest("intrl", inv.InvoiceNumber)[:26]),
+		Signature:          signature,
+		SDCID:              device.SDCID,
+		MRCNumber:          device.MRCNumber,
+		QRData:             qrData(device.TIN, device.BranchID, signature),
+		SignedAt:           time.Now(),
+	}, nil
+}
+
+func (f *Fake) transient() error {
+	if f.failures > 0 {
+		f.failures--
+		return errFakeUnavailable
+	}
+	return nil
+}
+
+var errFakeUnavailable = errors.New("etims fake: service unavailable")
+
+func digest(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
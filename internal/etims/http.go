@@ -0,0 +1,223 @@
+package etims
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const resultOK = "000"
+
+// HTTPClient speaks the JSON protocol of a KRA VSCU/OSCU endpoint.
+type HTTPClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewHTTPClient(baseURL string) *HTTPClient {
+	return &HTTPClient{
+		baseURL: strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		http:    &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+type envelope struct {
+	ResultCode    string          `json:"resultCd"`
+	ResultMessage string          `json:"resultMsg"`
+	ResultDate    string          `json:"resultDt"`
+	Data          json.RawMessage `json:"data"`
+}
+
+func (c *HTTPClient) Initialize(ctx context.Context, req DeviceRequest) (Device, error) {
+	body := map[string]any{
+		"tin":      req.TIN,
+		"bhfId":    req.BranchID,
+		"dvcSrlNo": req.DeviceSerial,
+	}
+	var data struct {
+		Info struct {
+			TIN      string `json:"tin"`
+			BranchID string `json:"bhfId"`
+			DeviceID string `json:"dvcId"`
+			SDCID    string `json:"sdcId"`
+			MRCNo    string `json:"mrcNo"`
+			CMCKey   string `json:"cmcKey"`
+		} `json:"info"`
+	}
+	if err := c.post(ctx, "/selectInitOsdcInfo", nil, body, &data); err != nil {
+		return Device{}, err
+	}
+	d := Device{
+		TIN:          data.Info.TIN,
+		BranchID:     data.Info.BranchID,
+		DeviceSerial: req.DeviceSerial,
+		DeviceID:     data.Info.DeviceID,
+		SDCID:        data.Info.SDCID,
+		MRCNumber:    data.Info.MRCNo,
+		CMCKey:       data.Info.CMCKey,
+	}
+	if d.TIN == "" {
+		d.TIN = req.TIN
+	}
+	if d.BranchID == "" {
+		d.BranchID = req.BranchID
+	}
+	if d.CMCKey == "" {
+		return Device{}, fmt.Errorf("etims initialisation returned no cmc key")
+	}
+	return d, nil
+}
+
+func (c *HTTPClient) SubmitInvoice(ctx context.Context, device Device, inv Invoice) (Receipt, error) {
+	salesDate := strings.ReplaceAll(inv.InvoiceDate, "-", "")
+	items := make([]map[string]any, 0, len(inv.Items))
+	taxable := map[string]float64{}
+	tax := map[string]float64{}
+	for i, it := range inv.Items {
+		taxType := it.TaxType
+		if taxType == "" {
+			taxType = TaxType(it.VATApplicable, it.TaxRate)
+		}
+		taxAmount := 0.0
+		if taxType != "A" && it.TaxRate > 0 {
+			taxAmount = round2(it.Total * it.TaxRate / (1 + it.TaxRate))
+		}
+		taxable[taxType] += it.Total - taxAmount
+		tax[taxType] += taxAmount
+		items = append(items, map[string]any{
+			"itemSeq":   i + 1,
+			"itemNm":    it.Description,
+			"qty":       it.Quantity,
+			"qtyUnitCd": it.Unit,
+			"prc":       it.UnitPrice,
+			"splyAmt":   it.Total,
+			"taxTyCd":   taxType,
+			"taxblAmt":  round2(it.Total - taxAmount),
+			"taxAmt":    taxAmount,
+			"totAmt":    it.Total,
+		})
+	}
+	receiptType := inv.ReceiptType
+	if receiptType == "" {
+		receiptType = ReceiptSale
+	}
+	body := map[string]any{
+		"tin":         device.TIN,
+		"bhfId":       device.BranchID,
+		"invcNo":      inv.Sequence,
+		"orgInvcNo":   inv.OriginalSequence,
+		"custTin":     inv.BuyerPIN,
+		"custNm":      inv.BuyerName,
+		"salesTyCd":   "N",
+		"rcptTyCd":    receiptType,
+		"pmtTyCd":     "01",
+		"salesSttsCd": "02",
+		"cfmDt":       time.Now().Format("20060102150405"),
+		"salesDt":     salesDate,
+		"totItemCnt":  len(items),
+		"totTaxblAmt": inv.Summary.TaxableAmount,
+		"totTaxAmt":   inv.Summary.VATAmount,
+		"totAmt":      inv.Summary.GrossAmount,
+		"itemList":    items,
+	}
+	if inv.Reason != "" {
+		body["remark"] = inv.Reason
+	}
+	for _, t := range []string{"A", "B", "C", "D", "E"} {
+		body["taxblAmt"+t] = round2(taxable[t])
+		body["taxAmt"+t] = round2(tax[t])
+	}
+
+	var data struct {
+		ReceiptNo      int64  `json:"rcptNo"`
+		TotalReceiptNo int64  `json:"totRcptNo"`
+		InternalData   string `json:"intrlData"`
+		Signature      string `json:"rcptSign"`
+		SignedAt       string `json:"vsdcRcptPbctDate"`
+		SDCID          string `json:"sdcId"`
+		MRCNo          string `json:"mrcNo"`
+	}
+	headers := map[string]string{"tin": device.TIN, "bhfId": device.BranchID, "cmcKey": device.CMCKey}
+	if err := c.post(ctx, "/saveTrnsSalesOsdc", headers, body, &data); err != nil {
+		return Receipt{}, err
+	}
+	signedAt, err := time.ParseInLocation("20060102150405", data.SignedAt, time.Local)
+	if err != nil {
+		signedAt = time.Now()
+	}
+	r := Receipt{
+		ReceiptNumber:      data.ReceiptNo,
+		TotalReceiptNumber: data.TotalReceiptNo,
+		InternalData:       data.InternalData,
+		Signature:          data.Signature,
+		SDCID:              firstNonEmpty(data.SDCID, device.SDCID),
+		MRCNumber:          firstNonEmpty(data.MRCNo, device.MRCNumber),
+		SignedAt:           signedAt,
+	}
+	r.QRData = qrData(device.TIN, device.BranchID, r.Signature)
+	return r, nil
+}
+
+// post sends body and decodes the envelope's data into out. Transport errors
+// and 5xx responses come back as plain errors so callers retry; a result code
+// other than success is a RejectedError.
+func (c *HTTPClient) post(ctx context.Context, path string, headers map[string]string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("etims request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("etims response read failed: %w", err)
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("etims returned status %d", resp.StatusCode)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return fmt.Errorf("etims returned status %d with an unreadable body", resp.StatusCode)
+	}
+	if env.ResultCode != resultOK {
+		return &RejectedError{Code: env.ResultCode, Message: env.ResultMessage}
+	}
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return fmt.Errorf("etims returned unexpected data: %w", err)
+		}
+	}
+	return nil
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,235 @@
+package mpesa
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorCodePending is how the STK query endpoint says the customer has not
+// answered the prompt yet.
+const errorCodePending = "500.001.1001"
+
+// HTTPClient speaks Daraja's REST API, fetching and caching an OAuth token
+// from the app's consumer key and secret.
+type HTTPClient struct {
+	baseURL        string
+	consumerKey    string
+	consumerSecret string
+	http           *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func NewHTTPClient(baseURL, consumerKey, consumerSecret string) *HTTPClient {
+	return &HTTPClient{
+		baseURL:        strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		consumerKey:    strings.TrimSpace(consumerKey),
+		consumerSecret: strings.TrimSpace(consumerSecret),
+		http:           &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (c *HTTPClient) RegisterURLs(ctx context.Context, account Account, confirmationURL, validationURL string) error {
+	body := map[string]any{
+		"ShortCode":       account.ShortCode,
+		"ResponseType":    "Completed",
+		"ConfirmationURL": confirmationURL,
+		"ValidationURL":   validationURL,
+	}
+	var out struct {
+		ResponseCode        string `json:"ResponseCode"`
+		ResponseDescription string `json:"ResponseDescription"`
+	}
+	if err := c.post(ctx, "/mpesa/c2b/v1/registerurl", body, &out); err != nil {
+		return err
+	}
+	if out.ResponseCode != "" && out.ResponseCode != "0" {
+		return &RejectedError{Code: out.ResponseCode, Message: out.ResponseDescription}
+	}
+	return nil
+}
+
+func (c *HTTPClient) STKPush(ctx context.Context, account Account, req STKRequest) (STKResponse, error) {
+	ts := timestamp(time.Now())
+	txType := "CustomerPayBillOnline"
+	if account.Type == AccountTill {
+		txType = "CustomerBuyGoodsOnline"
+	}
+	body := map[string]any{
+		"BusinessShortCode": account.ShortCode,
+		"Password":          password(account, ts),
+		"Timestamp":         ts,
+		"TransactionType":   txType,
+		"Amount":            req.Amount,
+		"PartyA":            req.Phone,
+		"PartyB":            account.ShortCode,
+		"PhoneNumber":       req.Phone,
+		"CallBackURL":       req.CallbackURL,
+		"AccountReference":  req.AccountReference,
+		"TransactionDesc":   req.Description,
+	}
+	var out struct {
+		MerchantRequestID   string `json:"MerchantRequestID"`
+		CheckoutRequestID   string `json:"CheckoutRequestID"`
+		ResponseCode        string `json:"ResponseCode"`
+		ResponseDescription string `json:"ResponseDescription"`
+		CustomerMessage     string `json:"CustomerMessage"`
+	}
+	if err := c.post(ctx, "/mpesa/stkpush/v1/processrequest", body, &out); err != nil {
+		return STKResponse{}, err
+	}
+	if out.ResponseCode != "0" {
+		return STKResponse{}, &RejectedError{Code: out.ResponseCode, Message: out.ResponseDescription}
+	}
+	if out.CheckoutRequestID == "" {
+		return STKResponse{}, fmt.Errorf("mpesa stk push returned no checkout request id")
+	}
+	return STKResponse{
+		MerchantRequestID: out.MerchantRequestID,
+		CheckoutRequestID: out.CheckoutRequestID,
+		CustomerMessage:   out.CustomerMessage,
+	}, nil
+}
+
+func (c *HTTPClient) STKQuery(ctx context.Context, account Account, checkoutRequestID string) (STKResult, error) {
+	ts := timestamp(time.Now())
+	body := map[string]any{
+		"BusinessShortCode": account.ShortCode,
+		"Password":          password(account, ts),
+		"Timestamp":         ts,
+		"CheckoutRequestID": checkoutRequestID,
+	}
+	var out struct {
+		ResponseCode string `json:"ResponseCode"`
+		ResultCode   string `json:"ResultCode"`
+		ResultDesc   string `json:"ResultDesc"`
+	}
+	if err := c.post(ctx, "/mpesa/stkpushquery/v1/query", body, &out); err != nil {
+		return STKResult{}, err
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(out.ResultCode))
+	if err != nil {
+		return STKResult{}, fmt.Errorf("mpesa stk query returned result code %q", out.ResultCode)
+	}
+	return STKResult{ResultCode: code, ResultDesc: out.ResultDesc}, nil
+}
+
+// password is the STK signature Daraja derives from the shortcode, passkey
+// and request timestamp.
+func password(account Account, ts string) string {
+	return base64.StdEncoding.EncodeToString([]byte(account.ShortCode + account.Passkey + ts))
+}
+
+func (c *HTTPClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.consumerKey, c.consumerSecret)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("mpesa token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode >= 500 {
+			return "", fmt.Errorf("mpesa token request returned status %d", resp.StatusCode)
+		}
+		return "", &RejectedError{Code: strconv.Itoa(resp.StatusCode), Message: "consumer key or secret was refused"}
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&out); err != nil || out.AccessToken == "" {
+		return "", fmt.Errorf("mpesa token response was unreadable")
+	}
+	ttl, _ := strconv.Atoi(out.ExpiresIn)
+	if ttl <= 0 {
+		ttl = 3599
+	}
+	c.token = out.AccessToken
+	// Refresh a minute early so a token never expires mid-request.
+	c.tokenExpiry = time.Now().Add(time.Duration(ttl)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+func (c *HTTPClient) dropToken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+// post sends body with a bearer token and decodes the reply into out. Daraja
+// reports refusals as an errorCode body; those become RejectedError, except
+// the pending code of the STK query which is ErrPending. Transport errors and
+// other 5xx responses are plain errors.
+func (c *HTTPClient) post(ctx context.Context, path string, body any, out any) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("mpesa request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("mpesa response read failed: %w", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.dropToken()
+		return fmt.Errorf("mpesa access token was refused")
+	}
+
+	var failure struct {
+		ErrorCode    string `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	_ = json.Unmarshal(raw, &failure)
+	switch {
+	case failure.ErrorCode == errorCodePending:
+		return ErrPending
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("mpesa returned status %d", resp.StatusCode)
+	case failure.ErrorCode != "":
+		return &RejectedError{Code: failure.ErrorCode, Message: failure.ErrorMessage}
+	case resp.StatusCode >= 400:
+		return &RejectedError{Code: strconv.Itoa(resp.StatusCode), Message: strings.TrimSpace(string(raw))}
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("mpesa returned unexpected data: %w", err)
+		}
+	}
+	return nil
+}
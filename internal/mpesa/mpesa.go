@@ -0,0 +1,181 @@
+// Package mpesa talks to Safaricom's Daraja API. Client has an HTTP
+// implementation; Stub serves the same endpoints locally so the payment flow
+// can be exercised without a Daraja account.
+package mpesa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client prompts customers to pay a farm's shortcode and registers the URLs
+// Daraja posts C2B payments to.
+type Client interface {
+	RegisterURLs(ctx context.Context, account Account, confirmationURL, validationURL string) error
+	STKPush(ctx context.Context, account Account, req STKRequest) (STKResponse, error)
+	STKQuery(ctx context.Context, account Account, checkoutRequestID string) (STKResult, error)
+}
+
+// Account types. A paybill takes an account reference, a till does not.
+const (
+	AccountPaybill = "paybill"
+	AccountTill    = "till"
+)
+
+// Account is the shortcode a farm is paid through. Passkey signs STK pushes
+// and must be kept server side.
+type Account struct {
+	ShortCode string
+	Type      string
+	Passkey   string
+}
+
+// STKRequest asks the phone's owner to approve a payment. Phone is in the
+// 2547XXXXXXXX form Daraja expects and Amount is whole shillings.
+type STKRequest struct {
+	Phone            string
+	Amount           int64
+	AccountReference string
+	Description      string
+	CallbackURL      string
+}
+
+type STKResponse struct {
+	MerchantRequestID string `json:"merchantRequestId"`
+	CheckoutRequestID string `json:"checkoutRequestId"`
+	CustomerMessage   string `json:"customerMessage"`
+}
+
+// STKResult is Daraja's record of how an STK push ended.
+type STKResult struct {
+	ResultCode int    `json:"resultCode"`
+	ResultDesc string `json:"resultDesc"`
+}
+
+// STK result codes worth telling apart.
+const (
+	ResultSuccess   = 0
+	ResultCancelled = 1032
+)
+
+// RejectedError means Daraja processed the request and refused it; anything
+// else returned by a Client is a transport failure worth retrying.
+type RejectedError struct {
+	Code    string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("mpesa rejected request (%s): %s", e.Code, e.Message)
+}
+
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// ErrPending is returned by STKQuery while the customer has not answered.
+var ErrPending = errors.New("mpesa transaction is still being processed")
+
+// STKCallback is what Daraja posts to the callback URL of an STK push.
+type STKCallback struct {
+	Body struct {
+		StkCallback struct {
+			MerchantRequestID string `json:"MerchantRequestID"`
+			CheckoutRequestID string `json:"CheckoutRequestID"`
+			ResultCode        int    `json:"ResultCode"`
+			ResultDesc        string `json:"ResultDesc"`
+			CallbackMetadata  struct {
+				Item []CallbackItem `json:"Item"`
+			} `json:"CallbackMetadata"`
+		} `json:"stkCallback"`
+	} `json:"Body"`
+}
+
+// CallbackItem values are numbers or strings depending on the field.
+type CallbackItem struct {
+	Name  string          `json:"Name"`
+	Value json.RawMessage `json:"Value,omitempty"`
+}
+
+// STKPayment is the receipt carried by a successful STK callback.
+type STKPayment struct {
+	ReceiptNumber string
+	Amount        float64
+	Phone         string
+	PaidAt        time.Time
+}
+
+// Payment reads the receipt out of a successful callback's metadata.
+func (c STKCallback) Payment() (STKPayment, error) {
+	var p STKPayment
+	for _, item := range c.Body.StkCallback.CallbackMetadata.Item {
+		v := strings.Trim(strings.TrimSpace(string(item.Value)), `"`)
+		switch item.Name {
+		case "MpesaReceiptNumber":
+			p.ReceiptNumber = v
+		case "Amount":
+			p.Amount, _ = strconv.ParseFloat(v, 64)
+		case "PhoneNumber":
+			p.Phone = v
+		case "TransactionDate":
+			p.PaidAt, _ = ParseTime(v)
+		}
+	}
+	if p.ReceiptNumber == "" || p.Amount <= 0 {
+		return STKPayment{}, errors.New("mpesa callback has no receipt")
+	}
+	if p.PaidAt.IsZero() {
+		p.PaidAt = time.Now()
+	}
+	return p, nil
+}
+
+// C2BConfirmation is what Daraja posts when money reaches a registered
+// shortcode, whether or not it came from an STK push.
+type C2BConfirmation struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID"`
+	TransTime         string `json:"TransTime"`
+	TransAmount       string `json:"TransAmount"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"`
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+func (c C2BConfirmation) Amount() float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(c.TransAmount), 64)
+	return v
+}
+
+func (c C2BConfirmation) PayerName() string {
+	return strings.Join(strings.Fields(c.FirstName+" "+c.MiddleName+" "+c.LastName), " ")
+}
+
+// eat is the zone Daraja writes its timestamps in.
+var eat = time.FixedZone("EAT", 3*60*60)
+
+// ParseTime reads Daraja's yyyyMMddHHmmss timestamps.
+func ParseTime(v string) (time.Time, error) {
+	return time.ParseInLocation("20060102150405", strings.TrimSpace(v), eat)
+}
+
+func timestamp(t time.Time) string {
+	return t.In(eat).Format("20060102150405")
+}
+
+// MSISDN turns a +254 number into the form Daraja expects.
+func MSISDN(phone string) string {
+	return strings.TrimPrefix(strings.TrimSpace(phone), "+")
+}
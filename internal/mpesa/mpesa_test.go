@@ -0,0 +1,43 @@
+package mpesa
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSTKCallbackPayment(t *testing.T) {
+	body := `{"Body":{"stkCallback":{"ResultCode":0,"CallbackMetadata":{"Item":[
+		{"Name":"Amount","Value":1500.5},
+		{"Name":"MpesaReceiptNumber","Value":"QFT4ABC123"},
+		{"Name":"TransactionDate","Value":20240305143015},
+		{"Name":"PhoneNumber","Value":254712345678}]}}}}`
+	var cb STKCallback
+	if err := json.Unmarshal([]byte(body), &cb); err != nil {
+		t.Fatal(err)
+	}
+	p, err := cb.Payment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 3, 5, 14, 30, 15, 0, eat)
+	if p.ReceiptNumber != "QFT4ABC123" || p.Amount != 1500.5 || p.Phone != "254712345678" || !p.PaidAt.Equal(want) {
+		t.Errorf("Payment() = %+v", p)
+	}
+
+	var empty STKCallback
+	if err := json.Unmarshal([]byte(`{"Body":{"stkCallback":{"ResultCode":1032}}}`), &empty); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.Payment(); err == nil {
+		t.Error("a callback without a receipt should fail")
+	}
+}
+
+func TestMSISDN(t *testing.T) {
+	for in, want := range map[string]string{"+254712345678": "254712345678", " 254712345678 ": "254712345678"} {
+		if got := MSISDN(in); got != want {
+			t.Errorf("MSISDN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
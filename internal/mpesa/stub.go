@@ -0,0 +1,298 @@
+package mpesa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stub is a local stand-in for Daraja. It issues tokens, accepts STK pushes
+// and answers them by posting the callback, and its simulate endpoint posts
+// C2B confirmations to the URLs registered for a shortcode, like the Daraja
+// sandbox does.
+type Stub struct {
+	// ResultCode is how every later STK push ends; 0 pays, 1032 is a
+	// customer cancelling the prompt.
+	ResultCode int
+	// Delay is how long the customer takes to answer an STK prompt.
+	Delay time.Duration
+
+	mu       sync.Mutex
+	seq      int64
+	urls     map[string]registeredURLs
+	requests map[string]*stubRequest
+	client   *http.Client
+}
+
+type registeredURLs struct {
+	confirmation string
+	validation   string
+}
+
+type stubRequest struct {
+	done   bool
+	result STKResult
+}
+
+const stubToken = "stub-access-token"
+
+func NewStub() *Stub {
+	return &Stub{
+		Delay:    time.Second,
+		urls:     map[string]registeredURLs{},
+		requests: map[string]*stubRequest{},
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/oauth/v1/generate" {
+		if _, _, ok := r.BasicAuth(); !ok {
+			http.Error(w, "missing credentials", http.StatusBadRequest)
+			return
+		}
+		writeStubJSON(w, http.StatusOK, map[string]string{"access_token": stubToken, "expires_in": "3599"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+stubToken {
+		writeStubJSON(w, http.StatusUnauthorized, map[string]string{"errorCode": "404.001.03", "errorMessage": "Invalid Access Token"})
+		return
+	}
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid Body"})
+		return
+	}
+	switch r.URL.Path {
+	case "/mpesa/c2b/v1/registerurl":
+		s.registerURLs(w, body)
+	case "/mpesa/stkpush/v1/processrequest":
+		s.stkPush(w, body)
+	case "/mpesa/stkpushquery/v1/query":
+		s.stkQuery(w, body)
+	case "/mpesa/c2b/v1/simulate":
+		s.simulateC2B(w, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Stub) registerURLs(w http.ResponseWriter, body map[string]any) {
+	shortCode := stubString(body["ShortCode"])
+	confirmation := stubString(body["ConfirmationURL"])
+	if shortCode == "" || confirmation == "" {
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid ShortCode or ConfirmationURL"})
+		return
+	}
+	s.mu.Lock()
+	s.urls[shortCode] = registeredURLs{confirmation: confirmation, validation: stubString(body["ValidationURL"])}
+	s.mu.Unlock()
+	writeStubJSON(w, http.StatusOK, map[string]string{"ResponseCode": "0", "ResponseDescription": "success"})
+}
+
+func (s *Stub) stkPush(w http.ResponseWriter, body map[string]any) {
+	shortCode := stubString(body["BusinessShortCode"])
+	phone := stubString(body["PhoneNumber"])
+	callback := stubString(body["CallBackURL"])
+	amount, _ := strconv.ParseFloat(stubString(body["Amount"]), 64)
+	if shortCode == "" || phone == "" || callback == "" || amount < 1 {
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid STK request"})
+		return
+	}
+
+	s.mu.Lock()
+	s.seq++
+	n := s.seq
+	merchantID := fmt.Sprintf("STUB-%d", n)
+	checkoutID := fmt.Sprintf("ws_CO_STUB%010d", n)
+	req := &stubRequest{}
+	s.requests[checkoutID] = req
+	resultCode, delay := s.ResultCode, s.Delay
+	s.mu.Unlock()
+
+	writeStubJSON(w, http.StatusOK, map[string]string{
+		"MerchantRequestID":   merchantID,
+		"CheckoutRequestID":   checkoutID,
+		"ResponseCode":        "0",
+		"ResponseDescription": "Success. Request accepted for processing",
+		"CustomerMessage":     "Success. Request accepted for processing",
+	})
+
+	go func() {
+		time.Sleep(delay)
+		result := STKResult{ResultCode: resultCode, ResultDesc: "The service request is processed successfully."}
+		if resultCode == ResultCancelled {
+			result.ResultDesc = "Request cancelled by user"
+		} else if resultCode != ResultSuccess {
+			result.ResultDesc = "The transaction failed"
+		}
+		s.mu.Lock()
+		req.done = true
+		req.result = result
+		s.mu.Unlock()
+
+		var cb STKCallback
+		cb.Body.StkCallback.MerchantRequestID = merchantID
+		cb.Body.StkCallback.CheckoutRequestID = checkoutID
+		cb.Body.StkCallback.ResultCode = result.ResultCode
+		cb.Body.StkCallback.ResultDesc = result.ResultDesc
+		if result.ResultCode == ResultSuccess {
+			receipt := s.receiptNumber()
+			now := time.Now()
+			cb.Body.StkCallback.CallbackMetadata.Item = []CallbackItem{
+				{Name: "Amount", Value: json.RawMessage(strconv.FormatFloat(amount, 'f', -1, 64))},
+				{Name: "MpesaReceiptNumber", Value: json.RawMessage(strconv.Quote(receipt))},
+				{Name: "TransactionDate", Value: json.RawMessage(timestamp(now))},
+				{Name: "PhoneNumber", Value: json.RawMessage(strconv.Quote(phone))},
+			}
+			s.post(callback, cb)
+			s.confirm(shortCode, C2BConfirmation{
+				TransactionType:   "Pay Bill",
+				TransID:           receipt,
+				TransTime:         timestamp(now),
+				TransAmount:       strconv.FormatFloat(amount, 'f', 2, 64),
+				BusinessShortCode: shortCode,
+				BillRefNumber:     stubString(body["AccountReference"]),
+				MSISDN:            phone,
+				FirstName:         "STUB",
+			})
+			return
+		}
+		s.post(callback, cb)
+	}()
+}
+
+func (s *Stub) stkQuery(w http.ResponseWriter, body map[string]any) {
+	checkoutID := stubString(body["CheckoutRequestID"])
+	s.mu.Lock()
+	req, ok := s.requests[checkoutID]
+	var done bool
+	var result STKResult
+	if ok {
+		done, result = req.done, req.result
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid CheckoutRequestID"})
+	case !done:
+		writeStubJSON(w, http.StatusInternalServerError, map[string]string{"errorCode": errorCodePending, "errorMessage": "The transaction is being processed"})
+	default:
+		writeStubJSON(w, http.StatusOK, map[string]string{
+			"ResponseCode":        "0",
+			"ResponseDescription": "The service request has been accepted successsfully",
+			"CheckoutRequestID":   checkoutID,
+			"ResultCode":          strconv.Itoa(result.ResultCode),
+			"ResultDesc":          result.ResultDesc,
+		})
+	}
+}
+
+// simulateC2B pays a shortcode directly, as a customer paying from the M-Pesa
+// menu would: the validation URL may refuse it, otherwise it is confirmed.
+func (s *Stub) simulateC2B(w http.ResponseWriter, body map[string]any) {
+	shortCode := stubString(body["ShortCode"])
+	amount, _ := strconv.ParseFloat(stubString(body["Amount"]), 64)
+	s.mu.Lock()
+	urls, ok := s.urls[shortCode]
+	s.mu.Unlock()
+	if !ok || amount < 1 {
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"errorCode": "400.002.02", "errorMessage": "Bad Request - Invalid ShortCode or Amount"})
+		return
+	}
+	c := C2BConfirmation{
+		TransactionType:   "Pay Bill",
+		TransID:           s.receiptNumber(),
+		TransTime:         timestamp(time.Now()),
+		TransAmount:       strconv.FormatFloat(amount, 'f', 2, 64),
+		BusinessShortCode: shortCode,
+		BillRefNumber:     stubString(body["BillRefNumber"]),
+		MSISDN:            stubString(body["Msisdn"]),
+		FirstName:         "STUB",
+	}
+	if urls.validation != "" {
+		var verdict struct {
+			ResultCode any `json:"ResultCode"`
+		}
+		if err := s.postForReply(urls.validation, c, &verdict); err != nil || stubString(verdict.ResultCode) != "0" {
+			writeStubJSON(w, http.StatusOK, map[string]string{"ResponseCode": "0", "ResponseDescription": "Rejected by validation URL"})
+			return
+		}
+	}
+	go s.post(urls.confirmation, c)
+	writeStubJSON(w, http.StatusOK, map[string]string{
+		"ConversationID":      "STUB-" + c.TransID,
+		"ResponseCode":        "0",
+		"ResponseDescription": "Accept the service request successfully.",
+	})
+}
+
+func (s *Stub) confirm(shortCode string, c C2BConfirmation) {
+	s.mu.Lock()
+	urls, ok := s.urls[shortCode]
+	s.mu.Unlock()
+	if ok {
+		s.post(urls.confirmation, c)
+	}
+}
+
+func (s *Stub) receiptNumber() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return fmt.Sprintf("STB%07d", s.seq)
+}
+
+func (s *Stub) post(url string, body any) {
+	if err := s.postForReply(url, body, nil); err != nil {
+		log.Printf("mpesa stub: post to %s: %v", url, err)
+	}
+}
+
+func (s *Stub) postForReply(url string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func stubString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return strings.TrimSpace(fmt.Sprint(t))
+	}
+}
+
+func writeStubJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
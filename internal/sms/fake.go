@@ -0,0 +1,54 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// Fake accepts every message and logs it instead of sending it. It stands in
+// for the gateway during development and lets tests script failures.
+type Fake struct {
+	mu       sync.Mutex
+	seq      int64
+	failures int
+	optedOut map[string]bool
+	// Sent holds every message the fake accepted.
+	Sent []Message
+}
+
+func NewFake() *Fake {
+	return &Fake{optedOut: map[string]bool{}}
+}
+
+// FailNext makes the next n sends fail with a transient error.
+func (f *Fake) FailNext(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+// OptOut makes later sends to phone fail with ErrOptedOut.
+func (f *Fake) OptOut(phone string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.optedOut[phone] = true
+}
+
+func (f *Fake) Send(ctx context.Context, msg Message) (Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return Receipt{}, errors.New("sms fake: simulated outage")
+	}
+	if f.optedOut[msg.To] {
+		return Receipt{}, ErrOptedOut
+	}
+	f.seq++
+	f.Sent = append(f.Sent, msg)
+	log.Printf("sms fake: to %s: %s", msg.To, msg.Text)
+	return Receipt{MessageID: "fake-" + strconv.FormatInt(f.seq, 10), Currency: "KES"}, nil
+}
@@ -0,0 +1,107 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recipient status codes the gateway uses for a message it has taken on.
+// Everything from 401 up is a refusal; 406 means the number blocked us.
+const (
+	codeProcessed     = 100
+	codeSent          = 101
+	codeQueued        = 102
+	codeUserBlacklist = 406
+)
+
+// HTTPClient posts messages to the gateway's messaging endpoint with the
+// account's username and API key.
+type HTTPClient struct {
+	baseURL  string
+	username string
+	apiKey   string
+	senderID string
+	http     *http.Client
+}
+
+// NewHTTPClient talks to baseURL, e.g. https://api.africastalking.com or the
+// sandbox. senderID is the alphanumeric sender or short code; empty uses the
+// gateway's shared default.
+func NewHTTPClient(baseURL, username, apiKey, senderID string) *HTTPClient {
+	return &HTTPClient{
+		baseURL:  strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		username: strings.TrimSpace(username),
+		apiKey:   strings.TrimSpace(apiKey),
+		senderID: strings.TrimSpace(senderID),
+		http:     &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (c *HTTPClient) Send(ctx context.Context, msg Message) (Receipt, error) {
+	form := url.Values{}
+	form.Set("username", c.username)
+	form.Set("to", msg.To)
+	form.Set("message", msg.Text)
+	if c.senderID != "" {
+		form.Set("from", c.senderID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/version1/messaging", strings.NewReader(form.Encode()))
+	if err != nil {
+		return Receipt{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("sms request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return Receipt{}, fmt.Errorf("sms response read failed: %w", err)
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		return Receipt{}, fmt.Errorf("sms gateway returned status %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return Receipt{}, &RejectedError{Code: strconv.Itoa(resp.StatusCode), Message: strings.TrimSpace(string(raw))}
+	}
+
+	var out struct {
+		SMSMessageData struct {
+			Message    string `json:"Message"`
+			Recipients []struct {
+				StatusCode int    `json:"statusCode"`
+				Number     string `json:"number"`
+				Status     string `json:"status"`
+				Cost       string `json:"cost"`
+				MessageID  string `json:"messageId"`
+			} `json:"Recipients"`
+		} `json:"SMSMessageData"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return Receipt{}, fmt.Errorf("sms gateway returned unexpected data: %w", err)
+	}
+	if len(out.SMSMessageData.Recipients) == 0 {
+		return Receipt{}, &RejectedError{Code: "none", Message: out.SMSMessageData.Message}
+	}
+	rc := out.SMSMessageData.Recipients[0]
+	switch rc.StatusCode {
+	case codeProcessed, codeSent, codeQueued:
+	case codeUserBlacklist:
+		return Receipt{}, ErrOptedOut
+	default:
+		return Receipt{}, &RejectedError{Code: strconv.Itoa(rc.StatusCode), Message: rc.Status}
+	}
+	cost, currency := parseCost(rc.Cost)
+	return Receipt{MessageID: rc.MessageID, Cost: cost, Currency: currency}, nil
+}
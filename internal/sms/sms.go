@@ -0,0 +1,120 @@
+// Package sms sends text messages through an Africa's Talking style gateway.
+// Sender has an HTTP implementation; Fake stands in for the gateway during
+// development and logs what would have been sent.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Sender delivers one message to one phone number. To is in the +2547XXXXXXXX
+// form users.phone is stored in.
+type Sender interface {
+	Send(ctx context.Context, msg Message) (Receipt, error)
+}
+
+type Message struct {
+	To   string
+	Text string
+}
+
+// Receipt is the gateway accepting a message. Delivery to the handset is
+// reported later through the delivery callback, keyed by MessageID.
+type Receipt struct {
+	MessageID string
+	Cost      float64
+	Currency  string
+}
+
+// Delivery statuses, from the gateway accepting a message to the handset
+// receiving it or the attempt being given up.
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// RejectedError means the gateway refused the message; anything else
+// returned by a Sender is a transport failure worth retrying.
+type RejectedError struct {
+	Code    string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("sms rejected (%s): %s", e.Code, e.Message)
+}
+
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// ErrOptedOut is returned when the recipient has blocked messages from our
+// sender id.
+var ErrOptedOut = errors.New("recipient has opted out of messages")
+
+// DeliveryReport is what the gateway posts once it knows whether a message
+// reached the handset.
+type DeliveryReport struct {
+	MessageID     string
+	Phone         string
+	Status        string
+	FailureReason string
+	// OptedOut is set when delivery failed because the recipient blocked
+	// the sender id.
+	OptedOut bool
+}
+
+// ParseDeliveryReport reads the form the gateway posts to the delivery
+// report URL.
+func ParseDeliveryReport(form url.Values) (DeliveryReport, error) {
+	r := DeliveryReport{
+		MessageID:     strings.TrimSpace(form.Get("id")),
+		Phone:         strings.TrimSpace(form.Get("phoneNumber")),
+		FailureReason: strings.TrimSpace(form.Get("failureReason")),
+	}
+	if r.MessageID == "" {
+		return r, errors.New("delivery report has no message id")
+	}
+	switch strings.TrimSpace(form.Get("status")) {
+	case "Success":
+		r.Status = StatusDelivered
+	case "Sent", "Submitted", "Buffered":
+		r.Status = StatusSent
+	case "Failed", "Rejected", "AbsentSubscriber", "Expired":
+		r.Status = StatusFailed
+	default:
+		return r, fmt.Errorf("delivery report has unknown status %q", form.Get("status"))
+	}
+	r.OptedOut = r.FailureReason == "UserInBlacklist"
+	return r, nil
+}
+
+// ParseOptOut reads the phone number from the form the gateway posts when a
+// recipient blocks the sender id.
+func ParseOptOut(form url.Values) (string, error) {
+	phone := strings.TrimSpace(form.Get("phoneNumber"))
+	if phone == "" {
+		return "", errors.New("opt-out has no phone number")
+	}
+	return phone, nil
+}
+
+// parseCost reads the gateway's "KES 0.8000" cost strings.
+func parseCost(v string) (float64, string) {
+	currency, amount, ok := strings.Cut(strings.TrimSpace(v), " ")
+	if !ok {
+		return 0, ""
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+	if err != nil {
+		return 0, ""
+	}
+	return n, currency
+}